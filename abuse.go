@@ -0,0 +1,223 @@
+package kamune
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// abuseRateWindow is the time constant over which [ScoredMessage.Rate] is
+// averaged.
+const abuseRateWindow = 10 * time.Second
+
+// AbuseVerdict is what an [AbuseScorer] decides about an inbound message.
+type AbuseVerdict int
+
+const (
+	// AbuseAllow delivers the message.
+	AbuseAllow AbuseVerdict = iota
+	// AbuseFlag delivers the message, but counts it as a strike against the
+	// session.
+	AbuseFlag
+	// AbuseDrop discards the message, and counts it as a strike against the
+	// session.
+	AbuseDrop
+)
+
+func (v AbuseVerdict) String() string {
+	switch v {
+	case AbuseAllow:
+		return "allow"
+	case AbuseFlag:
+		return "flag"
+	case AbuseDrop:
+		return "drop"
+	default:
+		return fmt.Sprintf("AbuseVerdict(%d)", int(v))
+	}
+}
+
+// ScoredMessage describes a message a peer sent, for an [AbuseScorer].
+type ScoredMessage struct {
+	// Received is when the message was received.
+	Received time.Time
+	// Fingerprint is the sender's fingerprint, as computed by
+	// [fingerprint.Sum] over its public key.
+	Fingerprint string
+	SessionID   string
+	// Plaintext is the decrypted, serialized message, if
+	// [AbuseControl.Plaintext] is set, or nil.
+	Plaintext []byte
+	// Stats is a snapshot of the session's counters, this message included.
+	Stats TransportStats
+	// Rate is the number of messages per second the peer has been sending,
+	// averaged exponentially over about the last ten seconds.
+	Rate  float64
+	Route Route
+	// Size is the size of the serialized message in bytes.
+	Size int
+	// Strikes is the number of messages flagged or dropped in the session
+	// so far, this one excluded.
+	Strikes int
+}
+
+// AbuseScorer scores a message a peer sent. It runs on the goroutine
+// receiving from the session before the message is delivered, so it holds up
+// the session while it runs; it may be called for several sessions at once.
+type AbuseScorer func(ScoredMessage) AbuseVerdict
+
+// AbuseControl has every message a peer sends on a session, other than
+// control messages (ping, pong, and close) and transfers, scored before it is
+// delivered, and enforces the consequences of the messages scored as abusive.
+// Each message flagged or dropped is a strike against the session: once it
+// has ThrottleAfter strikes, the rest of its messages are limited by
+// Throttle, on top of any [RateLimit]; once it has DisconnectAfter, it is
+// closed; and once it has BlockAfter, the peer is blocked as well, see
+// [storage.Storage.BlockPeer], unless it is a guest. A zero count disables its
+// consequence. [Transport.Receive] reports a session closed for abuse as
+// [ErrAbusivePeer].
+type AbuseControl struct {
+	Scorer AbuseScorer
+	// Plaintext passes the contents of messages to Scorer. Without it,
+	// Scorer only sees their size and the session's statistics.
+	Plaintext bool
+	Throttle  RateLimit
+	// ThrottleAfter, DisconnectAfter, and BlockAfter are the number of
+	// strikes a session may collect before its consequence applies.
+	ThrottleAfter   int
+	DisconnectAfter int
+	BlockAfter      int
+}
+
+func (c AbuseControl) validate() error {
+	switch {
+	case c.Scorer == nil:
+		return errors.New("abuse control needs a scorer")
+	case c.ThrottleAfter < 0 || c.DisconnectAfter < 0 || c.BlockAfter < 0:
+		return errors.New("abuse control strike counts must not be negative")
+	case c.ThrottleAfter > 0:
+		return c.Throttle.validate()
+	}
+	return nil
+}
+
+// abuseState is a session's standing under its [AbuseControl]. It is only
+// touched by the goroutine receiving from the session.
+type abuseState struct {
+	control AbuseControl
+	// throttle is the session's bucket once it has been throttled.
+	throttle *rateLimiter
+	last     time.Time
+	rate     float64
+	strikes  int
+}
+
+// watchAbuse scores the messages of the session under c. A nil c leaves the
+// session unscored.
+func (t *Transport) watchAbuse(c *AbuseControl) {
+	if c != nil {
+		t.abuse = &abuseState{control: *c}
+	}
+}
+
+// screen scores a message received on route under the session's
+// [AbuseControl], and enforces the consequences. It reports whether the
+// message is to be dropped, and returns an error if the session has been
+// closed for abuse, or if the message is to be reported as rate limited.
+func (t *Transport) screen(route Route, msg []byte) (drop bool, err error) {
+	a := t.abuse
+	if a == nil || priorityForRoute(route) == PriorityControl {
+		return false, nil
+	}
+	if drop, err := t.throttleWith(a.throttle, route); drop || err != nil {
+		return drop, err
+	}
+
+	now := time.Now()
+	window := abuseRateWindow.Seconds()
+	if !a.last.IsZero() {
+		a.rate *= math.Exp(-now.Sub(a.last).Seconds() / window)
+	}
+	a.rate += 1 / window
+	a.last = now
+
+	in := ScoredMessage{
+		Received:    now,
+		Fingerprint: fingerprint.Sum(t.remotePeer.PublicKey),
+		SessionID:   t.sessionID,
+		Stats:       t.Stats(),
+		Rate:        a.rate,
+		Route:       route,
+		Size:        len(msg),
+		Strikes:     a.strikes,
+	}
+	if a.control.Plaintext {
+		in.Plaintext = msg
+	}
+	verdict := a.control.Scorer(in)
+	if verdict == AbuseAllow {
+		return false, nil
+	}
+
+	a.strikes++
+	slog.Info(
+		"inbound message scored as abusive",
+		slog.String("session_id", t.sessionID),
+		slog.String("route", route.String()),
+		slog.String("verdict", verdict.String()),
+		slog.Int("strikes", a.strikes),
+	)
+	if err := t.penalize(a); err != nil {
+		return false, err
+	}
+	return verdict != AbuseFlag, nil
+}
+
+// penalize enforces the consequences of the strikes a session has collected.
+func (t *Transport) penalize(a *abuseState) error {
+	c := a.control
+	reached := func(n int) bool { return n > 0 && a.strikes >= n }
+	if reached(c.BlockAfter) && t.store != nil && !t.guest {
+		if err := t.store.BlockPeer(t.remotePeer.PublicKey); err != nil {
+			slog.Error(
+				"failed to block abusive peer",
+				slog.String("session_id", t.sessionID),
+				slog.Any("error", err),
+			)
+		}
+	}
+	if reached(c.DisconnectAfter) || reached(c.BlockAfter) {
+		slog.Warn(
+			"closing session of abusive peer",
+			slog.String("session_id", t.sessionID),
+			slog.Int("strikes", a.strikes),
+		)
+		_ = t.Close()
+		t.reputation.record(
+			t.store, t.remotePeer.PublicKey, storage.OffenseAbuse,
+		)
+		return fmt.Errorf("%w: %d strikes", ErrAbusivePeer, a.strikes)
+	}
+	if reached(c.ThrottleAfter) && a.throttle == nil {
+		a.throttle = newRateLimiter(c.Throttle)
+	}
+	return nil
+}
+
+// ServeWithAbuseControl has the messages peers send scored and the abusive
+// ones dealt with as c says; see [AbuseControl]. Each session is scored on its
+// own, starting with no strikes, and resumed sessions start over.
+func ServeWithAbuseControl(c AbuseControl) ServerOptions {
+	return func(s *Server) error {
+		if err := c.validate(); err != nil {
+			return err
+		}
+		s.abuse = &c
+		return nil
+	}
+}
@@ -0,0 +1,230 @@
+package kamune
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// startScoredServer runs a server that scores its sessions under c and sends
+// what each Receive call returned on the returned channel, until one fails
+// with anything but ErrRateLimited. It also returns the server's storage and
+// the public key of each peer that connects.
+func startScoredServer(
+	t *testing.T, c AbuseControl,
+) (string, *storage.Storage, <-chan receiveResult, <-chan []byte) {
+	t.Helper()
+	messages := make(chan receiveResult, 16)
+	peers := make(chan []byte, 1)
+	handler := func(tr *Transport) error {
+		peers <- tr.RemotePeer().PublicKey
+		for {
+			msg := Bytes(nil)
+			_, err := tr.Receive(msg)
+			if errors.Is(err, ErrConnClosed) ||
+				errors.Is(err, ErrPeerDisconnected) {
+				return nil
+			}
+			messages <- receiveResult{err: err, text: string(msg.GetValue())}
+			if err != nil && !errors.Is(err, ErrRateLimited) {
+				return nil
+			}
+		}
+	}
+
+	store, cleanup := newTestStore(t)
+	t.Cleanup(cleanup)
+	_, addr, _ := startTestServer(
+		t, handler, store, storePeer, ServeWithAbuseControl(c),
+	)
+	return addr, store, messages, peers
+}
+
+// scoreSpam returns a scorer that gives verdict to messages mentioning spam
+// and allows the rest.
+func scoreSpam(verdict AbuseVerdict) AbuseScorer {
+	return func(m ScoredMessage) AbuseVerdict {
+		if bytes.Contains(m.Plaintext, []byte("spam")) {
+			return verdict
+		}
+		return AbuseAllow
+	}
+}
+
+func TestServeWithAbuseControl(t *testing.T) {
+	tests := []struct {
+		name    string
+		control AbuseControl
+		send    []string
+		want    []receiveResult
+		blocked bool
+	}{
+		{
+			name:    "drop",
+			control: AbuseControl{Scorer: scoreSpam(AbuseDrop)},
+			send:    []string{"1", "spam", "2"},
+			want:    []receiveResult{{text: "1"}, {text: "2"}},
+		},
+		{
+			name:    "flag",
+			control: AbuseControl{Scorer: scoreSpam(AbuseFlag)},
+			send:    []string{"1", "spam", "2"},
+			want: []receiveResult{
+				{text: "1"}, {text: "spam"}, {text: "2"},
+			},
+		},
+		{
+			name: "throttle",
+			control: AbuseControl{
+				Scorer:        scoreSpam(AbuseFlag),
+				ThrottleAfter: 1,
+				Throttle: RateLimit{
+					Rate: 0.1, Burst: 1, Action: RateLimitError,
+				},
+			},
+			send: []string{"spam", "1", "2"},
+			want: []receiveResult{
+				{text: "spam"}, {text: "1"}, {err: ErrRateLimited},
+			},
+		},
+		{
+			name: "disconnect",
+			control: AbuseControl{
+				Scorer: scoreSpam(AbuseFlag), DisconnectAfter: 2,
+			},
+			send: []string{"spam", "1", "spam", "2"},
+			want: []receiveResult{
+				{text: "spam"}, {text: "1"}, {err: ErrAbusivePeer},
+			},
+		},
+		{
+			name: "block",
+			control: AbuseControl{
+				Scorer: scoreSpam(AbuseDrop), DisconnectAfter: 3, BlockAfter: 1,
+			},
+			send:    []string{"1", "spam", "2"},
+			want:    []receiveResult{{text: "1"}, {err: ErrAbusivePeer}},
+			blocked: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			tc.control.Plaintext = true
+			addr, srvStore, messages, peers := startScoredServer(
+				t, tc.control,
+			)
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			d, err := NewDialer(addr, store, acceptAll)
+			a.NoError(err)
+			tr, err := d.Dial()
+			a.NoError(err)
+			defer tr.Close()
+
+			for _, text := range tc.send {
+				_, _ = tr.Send(Bytes([]byte(text)), RouteExchangeMessages)
+			}
+			for _, want := range tc.want {
+				got := <-messages
+				if want.err != nil {
+					a.ErrorIs(got.err, want.err)
+					continue
+				}
+				a.NoError(got.err)
+				a.Equal(want.text, got.text)
+			}
+
+			blocked, err := srvStore.IsBlocked(<-peers)
+			a.NoError(err)
+			a.Equal(tc.blocked, blocked)
+		})
+	}
+}
+
+func TestAbuseScorerInput(t *testing.T) {
+	a := require.New(t)
+	var (
+		mu     sync.Mutex
+		scored []ScoredMessage
+	)
+	addr, _, messages, peers := startScoredServer(t, AbuseControl{
+		Scorer: func(m ScoredMessage) AbuseVerdict {
+			mu.Lock()
+			defer mu.Unlock()
+			scored = append(scored, m)
+			return AbuseFlag
+		},
+	})
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+
+	for _, text := range []string{"hello", "world"} {
+		_, err := tr.Send(Bytes([]byte(text)), RouteExchangeMessages)
+		a.NoError(err)
+		a.Equal(text, (<-messages).text)
+	}
+	// Control messages are not scored.
+	_, err = tr.Send(Bytes([]byte("ping")), RoutePing)
+	a.NoError(err)
+	a.Equal("ping", (<-messages).text)
+
+	mu.Lock()
+	defer mu.Unlock()
+	a.Len(scored, 2)
+	fp := fingerprint.Sum(<-peers)
+	for i, m := range scored {
+		a.Equal(fp, m.Fingerprint)
+		a.Equal(tr.SessionID(), m.SessionID)
+		a.Equal(RouteExchangeMessages, m.Route)
+		a.Positive(m.Size)
+		a.Positive(m.Rate)
+		a.Equal(i, m.Strikes)
+		// Contents are only passed on when asked for.
+		a.Nil(m.Plaintext)
+	}
+	a.Greater(scored[1].Rate, scored[0].Rate)
+	a.Greater(
+		scored[1].Stats.MessagesReceived, scored[0].Stats.MessagesReceived,
+	)
+}
+
+func TestAbuseControlOptions(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	allow := func(ScoredMessage) AbuseVerdict { return AbuseAllow }
+	tests := []struct {
+		name    string
+		control AbuseControl
+	}{
+		{name: "no scorer", control: AbuseControl{DisconnectAfter: 1}},
+		{
+			name:    "negative count",
+			control: AbuseControl{Scorer: allow, BlockAfter: -1},
+		},
+		{
+			name:    "invalid throttle",
+			control: AbuseControl{Scorer: allow, ThrottleAfter: 1},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			_, err := NewServer(
+				"", nil, store, acceptAll, ServeWithAbuseControl(tc.control),
+			)
+			a.Error(err)
+		})
+	}
+}
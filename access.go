@@ -0,0 +1,128 @@
+package kamune
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// AccessPolicy restricts the routes a peer may send on a session according
+// to a role tied to its verified identity. A peer's role is, in order of
+// precedence, the one assigned to its fingerprint with [AccessPolicy.Assign]
+// (typically loaded from configuration), the one stored with
+// [storage.Storage.SetPeerRole], or the policy's default role.
+//
+// The role is resolved once, when the session is established or resumed, and
+// is available to handlers as [Transport.Role]. Which routes a role may send
+// is looked up for every message, so changes made with [AccessPolicy.Allow]
+// apply to live sessions. Control routes (ping, pong, and close) are always
+// allowed. A message on any other route that the role does not allow is
+// consumed and reported by [Transport.Receive] as [ErrUnauthorizedRoute]; the
+// session stays usable, and the handler decides whether to carry on.
+//
+// An AccessPolicy is safe for concurrent use. Use it with
+// [ServeWithAccessPolicy].
+type AccessPolicy struct {
+	routes      map[string]map[Route]struct{}
+	assigned    map[string]string
+	defaultRole string
+	mu          sync.RWMutex
+}
+
+// NewAccessPolicy returns a policy that gives defaultRole to peers without an
+// assigned role. Until routes are allowed for it, such peers may send nothing
+// but control messages.
+func NewAccessPolicy(defaultRole string) *AccessPolicy {
+	return &AccessPolicy{
+		routes:      make(map[string]map[Route]struct{}),
+		assigned:    make(map[string]string),
+		defaultRole: defaultRole,
+	}
+}
+
+// Allow lets peers with role send on routes, in addition to the routes
+// already allowed.
+func (p *AccessPolicy) Allow(role string, routes ...Route) error {
+	for _, r := range routes {
+		if !r.IsValid() {
+			return fmt.Errorf("%w: %d", ErrInvalidRoute, r)
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	allowed, ok := p.routes[role]
+	if !ok {
+		allowed = make(map[Route]struct{}, len(routes))
+		p.routes[role] = allowed
+	}
+	for _, r := range routes {
+		allowed[r] = struct{}{}
+	}
+	return nil
+}
+
+// Assign gives role to the peer with the fingerprint fp, as returned by
+// [fingerprint.Sum] for its public key. It takes precedence over the role
+// stored for the peer. An empty role removes the assignment.
+func (p *AccessPolicy) Assign(fp, role string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if role == "" {
+		delete(p.assigned, fp)
+		return
+	}
+	p.assigned[fp] = role
+}
+
+// Role returns the role of peer under the policy, consulting store for roles
+// that are not assigned explicitly.
+func (p *AccessPolicy) Role(
+	store *storage.Storage, peer *storage.Peer,
+) (string, error) {
+	p.mu.RLock()
+	role, ok := p.assigned[fingerprint.Sum(peer.PublicKey)]
+	p.mu.RUnlock()
+	if ok {
+		return role, nil
+	}
+	role, err := store.PeerRole(peer.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	if role == "" {
+		role = p.defaultRole
+	}
+	return role, nil
+}
+
+// Allowed reports whether a peer with role may send on route.
+func (p *AccessPolicy) Allowed(role string, route Route) bool {
+	if priorityForRoute(route) == PriorityControl {
+		return true
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.routes[role][route]
+	return ok
+}
+
+// restrict limits the routes the transport's peer may send to those allowed
+// for role under p. A nil policy leaves the transport unrestricted.
+func (t *Transport) restrict(p *AccessPolicy, role string) {
+	t.policy = p
+	t.role = role
+}
+
+// authorize returns ErrUnauthorizedRoute if the peer may not send on route.
+func (t *Transport) authorize(route Route) error {
+	if t.policy == nil || t.policy.Allowed(t.role, route) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s for role %q", ErrUnauthorizedRoute, route, t.role)
+}
+
+// Role returns the role of the remote peer under the server's
+// [AccessPolicy], or an empty string if the server has none.
+func (t *Transport) Role() string { return t.role }
@@ -0,0 +1,143 @@
+package kamune
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+func TestAccessPolicy_Role(t *testing.T) {
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	alice := &storage.Peer{PublicKey: []byte("alice-key")}
+	bob := &storage.Peer{PublicKey: []byte("bob-key")}
+	carol := &storage.Peer{PublicKey: []byte("carol-key")}
+
+	p := NewAccessPolicy("guest")
+	p.Assign(fingerprint.Sum(alice.PublicKey), "admin")
+	a.NoError(store.SetPeerRole(alice.PublicKey, "reader"))
+	a.NoError(store.SetPeerRole(bob.PublicKey, "reader"))
+
+	tests := []struct {
+		peer *storage.Peer
+		role string
+	}{
+		{peer: alice, role: "admin"},
+		{peer: bob, role: "reader"},
+		{peer: carol, role: "guest"},
+	}
+	for _, tc := range tests {
+		role, err := p.Role(store, tc.peer)
+		a.NoError(err)
+		a.Equal(tc.role, role, string(tc.peer.PublicKey))
+	}
+
+	p.Assign(fingerprint.Sum(alice.PublicKey), "")
+	role, err := p.Role(store, alice)
+	a.NoError(err)
+	a.Equal("reader", role, "assignment removed")
+}
+
+func TestAccessPolicy_Allowed(t *testing.T) {
+	a := require.New(t)
+	p := NewAccessPolicy("")
+	a.NoError(p.Allow("reader", RouteExchangeMessages))
+	a.NoError(p.Allow("admin", RouteExchangeMessages, RouteSessionData))
+	a.ErrorIs(p.Allow("admin", RouteInvalid), ErrInvalidRoute)
+
+	tests := []struct {
+		role    string
+		route   Route
+		allowed bool
+	}{
+		{role: "reader", route: RouteExchangeMessages, allowed: true},
+		{role: "reader", route: RouteSessionData},
+		{role: "admin", route: RouteSessionData, allowed: true},
+		{role: "unknown", route: RouteExchangeMessages},
+		{role: "unknown", route: RoutePing, allowed: true},
+		{role: "unknown", route: RouteCloseTransport, allowed: true},
+	}
+	for _, tc := range tests {
+		a.Equal(
+			tc.allowed, p.Allowed(tc.role, tc.route),
+			"%s on %s", tc.role, tc.route,
+		)
+	}
+}
+
+func TestServer_AccessPolicy(t *testing.T) {
+	a := require.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+
+	// The handler answers every message with the peer's role, or with the
+	// error it was rejected with.
+	handler := func(t *Transport) error {
+		for {
+			msg := Bytes(nil)
+			_, err := t.Receive(msg)
+			reply := t.Role()
+			switch {
+			case err == nil:
+			case errors.Is(err, ErrUnauthorizedRoute):
+				reply = "denied"
+			default:
+				return nil
+			}
+			_, err = t.Send(Bytes([]byte(reply)), RouteExchangeMessages)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	policy := NewAccessPolicy("reader")
+	a.NoError(policy.Allow("reader", RouteExchangeMessages))
+	a.NoError(policy.Allow("admin", RouteExchangeMessages, RouteSessionData))
+
+	serverStore, cleanup := newTestStore(t)
+	defer cleanup()
+	srv, err := NewServer(
+		"", handler, serverStore, acceptAll,
+		ServeWithListener(&tcpListener{Listener: l}),
+		ServeWithAccessPolicy(policy),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	dial := func(admin bool) *Transport {
+		store, cleanup := newTestStore(t)
+		t.Cleanup(cleanup)
+		d, err := NewDialer(l.Addr().String(), store, acceptAll)
+		a.NoError(err)
+		if admin {
+			policy.Assign(fingerprint.Sum(d.PublicKey()), "admin")
+		}
+		tr, err := d.Dial()
+		a.NoError(err)
+		t.Cleanup(func() { _ = tr.Close() })
+		return tr
+	}
+	ask := func(tr *Transport, route Route) string {
+		_, err := tr.Send(Bytes([]byte("hi")), route)
+		a.NoError(err)
+		reply := Bytes(nil)
+		_, err = tr.Receive(reply)
+		a.NoError(err)
+		return string(reply.GetValue())
+	}
+
+	admin, reader := dial(true), dial(false)
+	a.Equal("admin", ask(admin, RouteExchangeMessages))
+	a.Equal("admin", ask(admin, RouteSessionData))
+	a.Equal("reader", ask(reader, RouteExchangeMessages))
+	a.Equal("denied", ask(reader, RouteSessionData))
+	// The session survives a rejected message.
+	a.Equal("reader", ask(reader, RouteExchangeMessages))
+}
@@ -0,0 +1,393 @@
+package kamune
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+)
+
+const (
+	// capabilityAck is advertised in the introduction by peers that
+	// acknowledge the messages sent with [Transport.SendWithAck].
+	capabilityAck = "ack/v1"
+
+	// ackInitialBackoff and ackMaxBackoff bound the wait between attempts to
+	// send again the messages awaiting acknowledgment of a resumed session.
+	ackInitialBackoff = time.Second
+	ackMaxBackoff     = time.Minute
+	// ackMaxAttempts is the number of such attempts made on a transport
+	// before the messages are left to the next resumption.
+	ackMaxAttempts = 8
+)
+
+// Delivery is the outcome of a message sent with [Transport.SendWithAck],
+// settled once the peer's transport acknowledges receiving it, or once the
+// session ends without it doing so.
+type Delivery struct {
+	id      string
+	message Transferable
+	route   Route
+	// order is the place of the message among those of its session awaiting
+	// acknowledgment, and number its number for retransmission, if any.
+	order  uint64
+	number atomic.Uint64
+	done   chan struct{}
+	err    error
+	once   sync.Once
+}
+
+// ID returns the ID of the message, which is that of its [Metadata] on both
+// sides, however many times it is sent.
+func (d *Delivery) ID() string { return d.id }
+
+// Done returns a channel that is closed once the delivery is settled.
+func (d *Delivery) Done() <-chan struct{} { return d.done }
+
+// Err returns nil if the peer acknowledged the message, and why it did not
+// otherwise. It returns nil until Done is closed.
+func (d *Delivery) Err() error {
+	select {
+	case <-d.done:
+		return d.err
+	default:
+		return nil
+	}
+}
+
+// Wait waits for the delivery to be settled and returns [Delivery.Err], or
+// returns the error of ctx if it is done first.
+func (d *Delivery) Wait(ctx context.Context) error {
+	select {
+	case <-d.done:
+		return d.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Delivery) settle(err error) {
+	d.once.Do(func() {
+		d.err = err
+		close(d.done)
+	})
+}
+
+func (d *Delivery) settled() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// sessionDeliveries holds the messages of a session awaiting acknowledgment.
+// It outlives the transport they were sent on, so that the transport of the
+// resumed session sends them again and settles them.
+type sessionDeliveries struct {
+	pending map[string]*Delivery
+	// current is the transport the session last sent its messages on.
+	current *Transport
+	next    uint64
+	mu      sync.Mutex
+}
+
+func (s *sessionDeliveries) add(t *Transport, d *Delivery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	d.order = s.next
+	s.pending[d.id] = d
+	s.current = t
+}
+
+// settle settles and forgets the delivery with the given ID, if pending.
+func (s *sessionDeliveries) settle(id string, err error) {
+	s.mu.Lock()
+	d := s.pending[id]
+	delete(s.pending, id)
+	s.mu.Unlock()
+	if d != nil {
+		d.settle(err)
+	}
+}
+
+// settleAll settles and forgets every pending delivery with err.
+func (s *sessionDeliveries) settleAll(err error) {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[string]*Delivery)
+	s.mu.Unlock()
+	for _, d := range pending {
+		d.settle(err)
+	}
+}
+
+// settleReceived settles the deliveries of the messages that the peer
+// reports having received, peerReceived being the number of messages it
+// counted, and returns the others in the order they were sent.
+func (s *sessionDeliveries) settleReceived(
+	t *Transport, peerReceived uint64,
+) []*Delivery {
+	s.mu.Lock()
+	s.current = t
+	var received, missed []*Delivery
+	for id, d := range s.pending {
+		n := d.number.Load()
+		if n > 0 && n <= peerReceived {
+			received = append(received, d)
+			delete(s.pending, id)
+		} else {
+			missed = append(missed, d)
+		}
+	}
+	s.mu.Unlock()
+	for _, d := range received {
+		d.settle(nil)
+	}
+	slices.SortFunc(missed, func(a, b *Delivery) int {
+		return cmp.Compare(a.order, b.order)
+	})
+	return missed
+}
+
+// deliveryRegistry holds the deliveries of every session of the process, by
+// session and peer.
+type deliveryRegistry struct {
+	sessions map[string]*sessionDeliveries
+	mu       sync.Mutex
+}
+
+var deliveries = &deliveryRegistry{
+	sessions: make(map[string]*sessionDeliveries),
+}
+
+// of returns the deliveries of the session with the given key, creating them
+// if create is set, and nil otherwise.
+func (r *deliveryRegistry) of(key string, create bool) *sessionDeliveries {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.sessions[key]
+	if s == nil && create {
+		s = &sessionDeliveries{pending: make(map[string]*Delivery)}
+		r.sessions[key] = s
+	}
+	return s
+}
+
+func (r *deliveryRegistry) drop(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, key)
+}
+
+// deliveryKey identifies the deliveries of t's session. Both ends of a
+// session share its ID, and may live in the same process.
+func (t *Transport) deliveryKey() string {
+	return t.sessionID + "\x00" + string(t.remotePeer.PublicKey)
+}
+
+// SendWithAck is [Transport.Send] for a message whose delivery matters, such
+// as a chat message shown as delivered: the message asks the peer's
+// transport, in its signed metadata, to acknowledge receiving it on
+// [RouteAck], and the returned [Delivery] is settled when it does. The
+// acknowledgment is read by [Transport.Receive] like any other message, so
+// another goroutine must be receiving from t meanwhile.
+//
+// If the connection drops first, the message awaits the session's
+// resumption: messages the peer reports having received are taken as
+// acknowledged, and the others are sent again, with the same ID, backing off
+// between attempts while they fail. That takes retransmission, see
+// [ServeWithRetransmitWindow]; without it, the delivery fails once the
+// connection drops. It also fails once the session is closed, and with
+// [errors.ErrUnsupported] unless the peer enabled [ServeWithAcks] or
+// [DialWithAcks].
+func (t *Transport) SendWithAck(
+	message Transferable, route Route,
+) (*Delivery, error) {
+	if !route.IsValid() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRoute, route)
+	}
+	if !slices.Contains(t.remotePeer.Capabilities, capabilityAck) {
+		return nil, fmt.Errorf(
+			"%w: peer does not acknowledge messages", errors.ErrUnsupported,
+		)
+	}
+	d := &Delivery{
+		id:      rand.Text(),
+		message: message,
+		route:   route,
+		done:    make(chan struct{}),
+	}
+	pending := deliveries.of(t.deliveryKey(), true)
+	pending.add(t, d)
+	if err := t.sendDelivery(d); err != nil {
+		pending.settle(d.id, err)
+		return nil, err
+	}
+	return d, nil
+}
+
+// sendDelivery sends the message of d, and records its number.
+func (t *Transport) sendDelivery(d *Delivery) error {
+	req := &sendRequest{
+		message:  d.message,
+		route:    d.route,
+		priority: priorityForRoute(d.route),
+		id:       d.id,
+		ack:      true,
+	}
+	t.queue.submit(req)
+	if req.err != nil {
+		return req.err
+	}
+	d.number.Store(req.number)
+	return nil
+}
+
+// acknowledge acknowledges the message described by md if its sender asked
+// for it and acknowledgments are enabled. Failing to acknowledge does not
+// fail the receipt; the sender sends the message again once resumed.
+func (t *Transport) acknowledge(md *Metadata) {
+	if !t.acking || !md.AckRequested() {
+		return
+	}
+	if _, err := t.Send(&pb.Ack{ID: md.ID()}, RouteAck); err != nil {
+		slog.Warn(
+			"acknowledging message",
+			slog.String("session_id", t.sessionID),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// handleAck settles the delivery a message on RouteAck acknowledges. It
+// returns an error only if the message cannot be decoded.
+func (t *Transport) handleAck(msg []byte) error {
+	var a pb.Ack
+	if err := t.unmarshal(msg, &a); err != nil {
+		return err
+	}
+	if pending := deliveries.of(t.deliveryKey(), false); pending != nil {
+		pending.settle(a.GetID(), nil)
+	}
+	return nil
+}
+
+// redeliver settles the deliveries of the messages that the peer received
+// before the session was resumed, and sends the others again, retrying in
+// the background while sending fails.
+func (t *Transport) redeliver(peerReceived uint64) {
+	pending := deliveries.of(t.deliveryKey(), false)
+	if pending == nil {
+		return
+	}
+	missed := pending.settleReceived(t, peerReceived)
+	missed, err := t.sendDeliveries(missed)
+	if err == nil {
+		return
+	}
+	slog.Warn(
+		"resending unacknowledged messages",
+		slog.String("session_id", t.sessionID),
+		slog.Int("count", len(missed)),
+		slog.Any("error", err),
+	)
+	go t.retryDeliveries(missed)
+}
+
+// sendDeliveries sends the messages of ds in order until one fails, and
+// returns those left to send, which are none without an error. Deliveries
+// that were settled meanwhile are skipped.
+func (t *Transport) sendDeliveries(ds []*Delivery) ([]*Delivery, error) {
+	for i, d := range ds {
+		if d.settled() {
+			continue
+		}
+		if err := t.sendDelivery(d); err != nil {
+			return ds[i:], err
+		}
+	}
+	return nil, nil
+}
+
+// retryDeliveries sends the messages of ds again, backing off between
+// attempts, until they are sent, t is closed, or ackMaxAttempts is reached.
+func (t *Transport) retryDeliveries(ds []*Delivery) {
+	backoff := ackInitialBackoff
+	for range ackMaxAttempts {
+		time.Sleep(backoff)
+		if t.closed.Load() {
+			return
+		}
+		var err error
+		if ds, err = t.sendDeliveries(ds); err == nil {
+			return
+		}
+		backoff = min(2*backoff, ackMaxBackoff)
+	}
+	slog.Warn(
+		"gave up resending unacknowledged messages",
+		slog.String("session_id", t.sessionID),
+		slog.Int("count", len(ds)),
+	)
+}
+
+// endDeliveries fails the deliveries of the session with err once it can no
+// longer be resumed: when it is closed, the peer disconnected, or, for a
+// session without retransmission, the connection dropped. Transports that
+// the session was resumed from leave them alone.
+func (t *Transport) endDeliveries(err error) {
+	if t.remotePeer == nil {
+		// The session was never established.
+		return
+	}
+	if t.retransmit != nil && errors.Is(err, ErrConnClosed) &&
+		!t.closed.Load() {
+		return
+	}
+	key := t.deliveryKey()
+	pending := deliveries.of(key, false)
+	if pending == nil {
+		return
+	}
+	pending.mu.Lock()
+	current := pending.current == t
+	pending.mu.Unlock()
+	if current {
+		pending.settleAll(err)
+		deliveries.drop(key)
+	}
+}
+
+// ServeWithAcks controls whether the server acknowledges the messages that
+// dialers send with [Transport.SendWithAck], which also lets the server's
+// sessions use it with dialers that enabled [DialWithAcks]. Disabled by
+// default.
+func ServeWithAcks(enabled bool) ServerOptions {
+	return func(s *Server) error {
+		s.handshakeOpts.intro.capabilities = setCapability(
+			s.handshakeOpts.intro.capabilities, capabilityAck, enabled,
+		)
+		return nil
+	}
+}
+
+// DialWithAcks is the dialer's equivalent of [ServeWithAcks].
+func DialWithAcks(enabled bool) DialOption {
+	return func(d *Dialer) error {
+		d.handshakeOpts.intro.capabilities = setCapability(
+			d.handshakeOpts.intro.capabilities, capabilityAck, enabled,
+		)
+		return nil
+	}
+}
@@ -0,0 +1,173 @@
+package kamune
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// receivedMessage is a message received by a test server, with its ID.
+type receivedMessage struct {
+	id   string
+	text string
+}
+
+// startAckServer runs a server acknowledging messages that reports every
+// message it receives. Resumed sessions echo them; the first session of a
+// dialer stops receiving after a message, or at once if hold is set, until
+// the dialer drops it.
+func startAckServer(
+	t *testing.T, hold bool, dropped <-chan struct{},
+) (string, <-chan receivedMessage) {
+	t.Helper()
+	received := make(chan receivedMessage, 4)
+	handler := func(tr *Transport) error {
+		for i := 0; ; i++ {
+			if !tr.resumed && (hold || i == 1) {
+				<-dropped
+				return nil
+			}
+			msg := Bytes(nil)
+			md, err := tr.Receive(msg)
+			if err != nil {
+				return err
+			}
+			received <- receivedMessage{md.ID(), string(msg.GetValue())}
+			if !tr.resumed {
+				continue
+			}
+			if _, err := tr.Send(msg, md.Route()); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, addr, _ := startTestServer(
+		t, handler, nil, storePeer,
+		ServeWithAcks(true), ServeWithRetransmitWindow(8),
+	)
+	return addr, received
+}
+
+func TestSendWithAck(t *testing.T) {
+	a := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ackAddr := startSessionServer(t, ServeWithAcks(true))
+	plainAddr := startSessionServer(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	d, err := NewDialer(ackAddr, store, storePeer)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	delivery, err := tr.SendWithAck(Bytes([]byte("hi")), RouteExchangeMessages)
+	a.NoError(err)
+	a.NotEmpty(delivery.ID())
+	a.NoError(delivery.Err())
+
+	// The acknowledgment is read while receiving the echo.
+	reply := Bytes(nil)
+	_, err = tr.Receive(reply)
+	a.NoError(err)
+	a.Equal("hi", string(reply.GetValue()))
+	a.NoError(delivery.Wait(ctx))
+
+	d, err = NewDialer(plainAddr, store, storePeer)
+	a.NoError(err)
+	plain, err := d.Dial()
+	a.NoError(err)
+	defer plain.Close()
+	_, err = plain.SendWithAck(Bytes([]byte("hi")), RouteExchangeMessages)
+	a.ErrorIs(err, errors.ErrUnsupported)
+	_, err = tr.SendWithAck(Bytes(nil), Route(999))
+	a.ErrorIs(err, ErrInvalidRoute)
+}
+
+func TestSendWithAck_Close(t *testing.T) {
+	a := require.New(t)
+	dropped := make(chan struct{})
+	defer close(dropped)
+	addr, _ := startAckServer(t, true, dropped)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	d, err := NewDialer(addr, store, storePeer)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	delivery, err := tr.SendWithAck(Bytes([]byte("hi")), RouteExchangeMessages)
+	a.NoError(err)
+	a.NoError(tr.Close())
+	a.ErrorIs(delivery.Wait(context.Background()), ErrConnClosed)
+}
+
+func TestSendWithAck_Resume(t *testing.T) {
+	tests := []struct {
+		name string
+		// hold keeps the server from receiving the message before the
+		// connection drops, so that it is sent again once resumed.
+		hold bool
+	}{
+		{name: "received before the drop"},
+		{name: "sent again", hold: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			ctx, cancel := context.WithTimeout(
+				context.Background(), 5*time.Second,
+			)
+			defer cancel()
+			dropped := make(chan struct{})
+			addr, received := startAckServer(t, tc.hold, dropped)
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+
+			d, err := NewDialer(
+				addr, store, storePeer, DialWithRetransmitWindow(8),
+			)
+			a.NoError(err)
+			tr, err := d.Dial()
+			a.NoError(err)
+			delivery, err := tr.SendWithAck(
+				Bytes([]byte("hi")), RouteExchangeMessages,
+			)
+			a.NoError(err)
+			if !tc.hold {
+				a.Equal(receivedMessage{delivery.ID(), "hi"}, <-received)
+			}
+			// Drop the connection without closing the session, before the
+			// acknowledgment is read.
+			a.NoError(tr.currentConn().Close())
+			close(dropped)
+
+			d, err = NewDialer(
+				addr, store, storePeer,
+				DialWithRetransmitWindow(8), DialWithResume(tr.SessionID()),
+			)
+			a.NoError(err)
+			resumed, err := d.Dial()
+			a.NoError(err)
+			defer resumed.Close()
+			if tc.hold {
+				a.Equal(receivedMessage{delivery.ID(), "hi"}, <-received)
+				reply := Bytes(nil)
+				_, err = resumed.Receive(reply)
+				a.NoError(err)
+				a.Equal("hi", string(reply.GetValue()))
+			}
+			a.NoError(delivery.Wait(ctx))
+
+			// Nothing was sent twice.
+			echo(t, resumed, "done")
+			a.Equal("done", (<-received).text)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package kamune
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/enigma"
+	"github.com/kamune-org/kamune/pkg/attest"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// SharedSecretProvider returns the secret that both ends of a connection
+// adopted with [AdoptConn] hold, such as keying material exported by the
+// channel's own key exchange. It must be at least 32 bytes long and known to
+// no one else, since the session's keys are derived from it alone.
+type SharedSecretProvider func() ([]byte, error)
+
+// AdoptOption configures [AdoptConn].
+type AdoptOption func(*adoption) error
+
+type adoption struct {
+	tracer  *Tracer
+	timeout time.Duration
+}
+
+// AdoptWithTimeout bounds how long [AdoptConn] waits for the peer. The
+// default is 30 seconds.
+func AdoptWithTimeout(d time.Duration) AdoptOption {
+	return func(a *adoption) error {
+		if d <= 0 {
+			return errors.New("adoption timeout must be positive")
+		}
+		a.timeout = d
+		return nil
+	}
+}
+
+// AdoptWithTracer records the adoption, and then the session's traffic, in
+// tracer; see [ServeWithTracer].
+func AdoptWithTracer(tracer *Tracer) AdoptOption {
+	return func(a *adoption) error {
+		if tracer == nil {
+			return errors.New("tracer must not be nil")
+		}
+		a.tracer = tracer
+		return nil
+	}
+}
+
+// AdoptConn runs a kamune session over conn, a channel whose ends have already
+// authenticated each other and agreed on a secret, such as an SSH connection or
+// an earlier kamune session. It skips the introduction and the MLKEM key
+// agreement: both ends derive the session's keys from the secret returned by
+// secret, bound to their two public keys, and prove to each other that they
+// hold it with the usual challenges. Messages are then signed with at and
+// checked against remoteKey, as on any other transport.
+//
+// Both ends call AdoptConn, each with its own key and the other's. Neither
+// dials nor accepts; which end speaks first is decided by the keys. The
+// session is not recorded in storage, so it cannot be resumed, and no
+// verifier runs: the caller vouches for remoteKey. conn is not closed if
+// adoption fails. Wrap a net.Conn with [NewConn].
+func AdoptConn(
+	conn Conn,
+	at *attest.Attest,
+	remoteKey []byte,
+	secret SharedSecretProvider,
+	opts ...AdoptOption,
+) (t *Transport, err error) {
+	a := adoption{timeout: 30 * time.Second}
+	for _, opt := range opts {
+		if err := opt(&a); err != nil {
+			return nil, fmt.Errorf("applying option: %w", err)
+		}
+	}
+	if !attest.IsValidPublicKey(remoteKey) {
+		return nil, storage.ErrInvalidPublicKey
+	}
+	localKey := at.MarshalPublicKey()
+	order := bytes.Compare(localKey, remoteKey)
+	if order == 0 {
+		return nil, errors.New("remote key is the local key")
+	}
+	shared, err := secret()
+	if err != nil {
+		return nil, fmt.Errorf("getting shared secret: %w", err)
+	}
+	if len(shared) < adoptSecretMinSize {
+		return nil, fmt.Errorf(
+			"shared secret must be at least %d bytes", adoptSecretMinSize,
+		)
+	}
+
+	// The end with the lower key takes the initiator's part.
+	initiator, responder := localKey, remoteKey
+	if order > 0 {
+		initiator, responder = remoteKey, localKey
+	}
+	derived, err := enigma.Derive(
+		shared, nil,
+		slices.Concat([]byte(adoptInfo), initiator, responder),
+		len(shared),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("deriving secret: %w", err)
+	}
+
+	timer := newStepTimer(conn, a.timeout, HandshakeTimeouts{})
+	timer.trace = a.tracer.start()
+	defer func() {
+		err = timer.wrap(err)
+		if err != nil {
+			timer.trace.failed(err)
+		}
+		timer.finish()
+	}()
+	hopts := handshakeOpts{
+		secret:      derived,
+		negotiation: sha256.Sum256([]byte(adoptInfo)),
+		timer:       timer,
+	}
+	serde := newSignedSerde(remoteKey, at)
+	if order < 0 {
+		t, err = requestHandshake(conn, serde, hopts)
+	} else {
+		t, err = acceptHandshake(conn, serde, hopts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("adopting connection: %w", err)
+	}
+
+	t.remotePeer = &storage.Peer{PublicKey: remoteKey}
+	t.bindStorage(nil, false)
+	timer.trace.phase("adopted")
+	return t, nil
+}
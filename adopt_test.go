@@ -0,0 +1,128 @@
+package kamune
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/attest"
+)
+
+// adoptPair adopts both ends of a TCP connection, the first with secret and
+// the second with other.
+func adoptPair(
+	t *testing.T, secret, other []byte, opts ...AdoptOption,
+) (*Transport, *Transport, error, error) {
+	t.Helper()
+	a := require.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	defer l.Close()
+	alice, err := attest.New()
+	a.NoError(err)
+	bob, err := attest.New()
+	a.NoError(err)
+
+	type result struct {
+		tr  *Transport
+		err error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			accepted <- result{err: err}
+			return
+		}
+		tr, err := AdoptConn(
+			NewConn(c), bob, alice.MarshalPublicKey(),
+			func() ([]byte, error) { return other, nil }, opts...,
+		)
+		if err != nil {
+			_ = c.Close()
+		}
+		accepted <- result{tr: tr, err: err}
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	a.NoError(err)
+	tr, err := AdoptConn(
+		NewConn(c), alice, bob.MarshalPublicKey(),
+		func() ([]byte, error) { return secret, nil }, opts...,
+	)
+	if err != nil {
+		_ = c.Close()
+	}
+	r := <-accepted
+	return tr, r.tr, err, r.err
+}
+
+func TestAdoptConn(t *testing.T) {
+	a := require.New(t)
+	secret := bytes.Repeat([]byte{7}, 32)
+	tracer := NewTracer(0)
+	alice, bob, err, bobErr := adoptPair(
+		t, secret, secret, AdoptWithTracer(tracer),
+	)
+	a.NoError(err)
+	a.NoError(bobErr)
+	defer alice.Close()
+	defer bob.Close()
+	a.Equal(alice.SessionID(), bob.SessionID())
+
+	_, err = alice.Send(Bytes([]byte("hello")), RouteExchangeMessages)
+	a.NoError(err)
+	got := Bytes(nil)
+	_, err = bob.Receive(got)
+	a.NoError(err)
+	a.Equal("hello", string(got.Value))
+	_, err = bob.Send(Bytes([]byte("hi")), RouteExchangeMessages)
+	a.NoError(err)
+	_, err = alice.Receive(got)
+	a.NoError(err)
+	a.Equal("hi", string(got.Value))
+
+	steps := traceSteps(tracer.Events().Session(alice.SessionID()))
+	a.Contains(steps, "1 adopted")
+	a.Contains(steps, "2 adopted")
+}
+
+func TestAdoptConn_SecretMismatch(t *testing.T) {
+	a := require.New(t)
+	_, _, err, bobErr := adoptPair(
+		t,
+		bytes.Repeat([]byte{1}, 32),
+		bytes.Repeat([]byte{2}, 32),
+		AdoptWithTimeout(time.Second),
+	)
+	// The ends cannot read each other's challenges.
+	a.Error(err)
+	a.Error(bobErr)
+}
+
+func TestAdoptConn_InvalidArguments(t *testing.T) {
+	a := require.New(t)
+	at, err := attest.New()
+	a.NoError(err)
+	peer, err := attest.New()
+	a.NoError(err)
+	secret := func() ([]byte, error) { return make([]byte, 32), nil }
+	cn := &scriptedConn{}
+
+	_, err = AdoptConn(cn, at, []byte("not a key"), secret)
+	a.Error(err)
+	_, err = AdoptConn(cn, at, at.MarshalPublicKey(), secret)
+	a.Error(err)
+	_, err = AdoptConn(
+		cn, at, peer.MarshalPublicKey(),
+		func() ([]byte, error) { return make([]byte, 16), nil },
+	)
+	a.Error(err)
+	_, err = AdoptConn(
+		cn, at, peer.MarshalPublicKey(), secret, AdoptWithTimeout(0),
+	)
+	a.Error(err)
+}
@@ -0,0 +1,301 @@
+package kamune
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/pkg/attest"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+const (
+	// capabilityAddresses is advertised in the introduction by peers that
+	// record the addresses announced to them.
+	capabilityAddresses = "addresses/v1"
+
+	// addressesField and addressesSignatureField are the SessionData fields
+	// carrying an announcement and its signature. SessionData messages
+	// without them are returned to the application as usual.
+	addressesField          = "kamune/addresses"
+	addressesSignatureField = "kamune/addresses-signature"
+
+	// addressesSigningContext separates announcement signatures from every
+	// other signature made with the same key.
+	addressesSigningContext = "kamune-address-announcement\x00"
+
+	// maxAnnouncedAddresses caps the addresses of an announcement, and
+	// maxAddressLength the length of each of their fields.
+	maxAnnouncedAddresses = 16
+	maxAddressLength      = 255
+
+	// maxAnnouncementSkew is how far in the future an announcement may be
+	// issued, to allow for the peers' clocks to disagree.
+	maxAnnouncementSkew = 5 * time.Minute
+)
+
+// addressAnnouncement is the body of an announcement on the wire.
+type addressAnnouncement struct {
+	Issued    time.Time             `json:"issued"`
+	Addresses []storage.PeerAddress `json:"addresses"`
+}
+
+// signedAddresses returns the bytes an announcement's signature covers.
+func signedAddresses(issued time.Time, addrs []storage.PeerAddress) []byte {
+	b := []byte(addressesSigningContext)
+	b = binary.BigEndian.AppendUint64(b, uint64(issued.UnixNano()))
+	for _, a := range addrs {
+		for _, field := range []string{a.Network, a.Address} {
+			b = binary.BigEndian.AppendUint32(b, uint32(len(field)))
+			b = append(b, field...)
+		}
+	}
+	return b
+}
+
+func validateAddresses(addrs []storage.PeerAddress) error {
+	if len(addrs) > maxAnnouncedAddresses {
+		return fmt.Errorf(
+			"%d addresses announced, at most %d are allowed",
+			len(addrs), maxAnnouncedAddresses,
+		)
+	}
+	for _, a := range addrs {
+		if a.Network == "" || a.Address == "" {
+			return errors.New("address network and address must not be empty")
+		}
+		if len(a.Network) > maxAddressLength ||
+			len(a.Address) > maxAddressLength {
+			return fmt.Errorf("address %q is too long", a.Address)
+		}
+	}
+	return nil
+}
+
+// VerifyAnnouncedAddresses reports whether a was signed by the peer owning
+// publicKey, such as when it is shared with a third party.
+func VerifyAnnouncedAddresses(
+	publicKey []byte, a *storage.AnnouncedAddresses,
+) bool {
+	return attest.Verify(
+		publicKey, signedAddresses(a.Issued, a.Addresses), a.Signature,
+	)
+}
+
+// AnnounceAddresses tells the peer the addresses it can reach the local side
+// at, such as after moving to another network, so that its next dial can use
+// them; see [DialWithAnnouncedAddresses]. The announcement is signed and
+// timestamped, and the peer records it in place of any earlier one. Addresses
+// whose network is "tcp" or "udp" are dialed directly; others, such as
+// "relay", are only recorded, for the application to use. At most 16
+// addresses may be announced. It fails with [errors.ErrUnsupported] unless the
+// peer enabled [ServeWithAddressAnnouncements] or
+// [DialWithAddressAnnouncements].
+func (t *Transport) AnnounceAddresses(addrs ...storage.PeerAddress) error {
+	if !slices.Contains(t.remotePeer.Capabilities, capabilityAddresses) {
+		return fmt.Errorf(
+			"%w: peer does not record address announcements",
+			errors.ErrUnsupported,
+		)
+	}
+	if err := validateAddresses(addrs); err != nil {
+		return err
+	}
+	issued := time.Now()
+	sig, err := t.serde.attest.Sign(signedAddresses(issued, addrs))
+	if err != nil {
+		return fmt.Errorf("signing announcement: %w", err)
+	}
+	body, err := json.Marshal(addressAnnouncement{
+		Issued: issued, Addresses: addrs,
+	})
+	if err != nil {
+		return fmt.Errorf("marshalling announcement: %w", err)
+	}
+	_, err = t.Send(&pb.SessionData{Fields: map[string][]byte{
+		addressesField:          body,
+		addressesSignatureField: sig,
+	}}, RouteSessionData)
+	if err != nil {
+		return fmt.Errorf("sending announcement: %w", err)
+	}
+	return nil
+}
+
+// handleAnnouncement records the announcement msg carries, if the session
+// accepts announcements and it is one. It reports whether msg was an
+// announcement, which is not returned to the application. Announcements that
+// cannot be verified or are older than the recorded one are dropped.
+func (t *Transport) handleAnnouncement(route Route, msg []byte) bool {
+	if !t.addressBook || route != RouteSessionData {
+		return false
+	}
+	var sd pb.SessionData
+	if err := proto.Unmarshal(msg, &sd); err != nil {
+		return false
+	}
+	body, ok := sd.GetFields()[addressesField]
+	if !ok {
+		return false
+	}
+
+	err := t.recordAnnouncement(body, sd.GetFields()[addressesSignatureField])
+	switch {
+	case err == nil:
+	case errors.Is(err, storage.ErrStaleAddresses):
+		slog.Debug(
+			"dropped stale address announcement",
+			slog.String("session_id", t.sessionID),
+		)
+	default:
+		slog.Warn(
+			"dropped address announcement",
+			slog.String("session_id", t.sessionID),
+			slog.Any("error", err),
+		)
+	}
+	return true
+}
+
+func (t *Transport) recordAnnouncement(body, sig []byte) error {
+	var a addressAnnouncement
+	if err := json.Unmarshal(body, &a); err != nil {
+		return fmt.Errorf("unmarshalling announcement: %w", err)
+	}
+	if err := validateAddresses(a.Addresses); err != nil {
+		return err
+	}
+	now := time.Now()
+	if a.Issued.After(now.Add(maxAnnouncementSkew)) {
+		return fmt.Errorf("announcement issued in the future: %s", a.Issued)
+	}
+	record := &storage.AnnouncedAddresses{
+		Issued:    a.Issued,
+		Received:  now,
+		Addresses: a.Addresses,
+		Signature: sig,
+	}
+	if !VerifyAnnouncedAddresses(t.remotePeer.PublicKey, record) {
+		return ErrInvalidSignature
+	}
+	if t.store == nil {
+		return nil
+	}
+	return t.store.SetPeerAddresses(t.remotePeer.PublicKey, *record)
+}
+
+// ServeWithAddressAnnouncements controls whether dialers may announce the
+// addresses they can be reached at; see [Transport.AnnounceAddresses].
+// Announcements are verified and recorded in storage, where
+// [storage.Storage.PeerAddresses] returns them. Disabled by default.
+func ServeWithAddressAnnouncements(enabled bool) ServerOptions {
+	return func(s *Server) error {
+		s.handshakeOpts.intro.capabilities = setCapability(
+			s.handshakeOpts.intro.capabilities, capabilityAddresses, enabled,
+		)
+		return nil
+	}
+}
+
+// DialWithAddressAnnouncements is the dialer's equivalent of
+// [ServeWithAddressAnnouncements].
+func DialWithAddressAnnouncements(enabled bool) DialOption {
+	return func(d *Dialer) error {
+		d.handshakeOpts.intro.capabilities = setCapability(
+			d.handshakeOpts.intro.capabilities, capabilityAddresses, enabled,
+		)
+		return nil
+	}
+}
+
+// DialWithAnnouncedAddresses dials the peer owning publicKey at the "tcp" and
+// "udp" addresses it last announced, see [Transport.AnnounceAddresses], in the
+// order it gave them, before falling back to the dialer's own address. Only
+// connecting is retried on the next address: a failed handshake is not. The
+// server must be the peer owning publicKey, whichever address it was reached
+// at, or the dial fails with [ErrVerificationFailed] before the verifier is
+// consulted. It has no effect with [DialWithUDPPath].
+func DialWithAnnouncedAddresses(publicKey []byte) DialOption {
+	return func(d *Dialer) error {
+		if !attest.IsValidPublicKey(publicKey) {
+			return storage.ErrInvalidPublicKey
+		}
+		d.peerKey = publicKey
+		return nil
+	}
+}
+
+// checkExpectedPeer fails if the dialer was told to reach another peer with
+// [DialWithAnnouncedAddresses].
+func (d *Dialer) checkExpectedPeer(peer *storage.Peer) error {
+	if d.peerKey == nil || bytes.Equal(peer.PublicKey, d.peerKey) {
+		return nil
+	}
+	return fmt.Errorf(
+		"%w: server is not the expected peer", ErrVerificationFailed,
+	)
+}
+
+// dialAnnounced connects to the first of the peer's announced addresses that
+// accepts the connection, trying the dialer's own address last.
+func (d *Dialer) dialAnnounced() (Conn, error) {
+	if d.peerKey == nil {
+		return d.dial(d.address)
+	}
+	var addrs []storage.PeerAddress
+	if a, err := d.storage.PeerAddresses(d.peerKey); err != nil {
+		slog.Warn("failed to load peer addresses", slog.Any("error", err))
+	} else if a != nil {
+		addrs = a.Addresses
+	}
+
+	var errs []error
+	for _, a := range addrs {
+		var cn Conn
+		var err error
+		switch {
+		case a.Network == "tcp" && a.Address != d.address:
+			cn, err = d.dialTCP(a.Address)
+		case a.Network == "udp":
+			cn, err = dialUDP(a.Address, d.connOpts)
+		default:
+			continue
+		}
+		if err == nil {
+			slog.Debug(
+				"dialed announced address",
+				slog.String("network", a.Network),
+				slog.String("address", a.Address),
+			)
+			return cn, nil
+		}
+		if d.state.isClosed() {
+			return nil, err
+		}
+		errs = append(errs, err)
+	}
+	cn, err := d.dial(d.address)
+	if err != nil {
+		return nil, errors.Join(append(errs, err)...)
+	}
+	return cn, nil
+}
+
+// dialTCP connects to addr over TCP.
+func (d *Dialer) dialTCP(addr string) (Conn, error) {
+	nd := net.Dialer{Timeout: d.dialTimeout}
+	c, err := nd.DialContext(d.state.ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing tcp: %w", err)
+	}
+	return newConn(c, d.connOpts...), nil
+}
@@ -0,0 +1,132 @@
+package kamune
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/attest"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// startAnnouncingServer runs an echo server that announces addrs on every
+// session before echoing, and reports the announcement's error on the
+// returned channel.
+func startAnnouncingServer(
+	t *testing.T, addrs ...storage.PeerAddress,
+) (string, <-chan error) {
+	t.Helper()
+	announced := make(chan error, 8)
+	handler := func(t *Transport) error {
+		announced <- t.AnnounceAddresses(addrs...)
+		return NewEchoHandler()(t)
+	}
+	_, addr, _ := startTestServer(t, handler, nil, acceptAll)
+	return addr, announced
+}
+
+func TestAnnounceAddresses(t *testing.T) {
+	a := require.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	addr := l.Addr().String()
+	a.NoError(l.Close())
+	addrs := []storage.PeerAddress{
+		{Network: "relay", Address: "relay.example.org/token"},
+		{Network: "tcp", Address: addr},
+	}
+	srvAddr, announced := startAnnouncingServer(t, addrs...)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	// Peers that do not record announcements are not sent any.
+	d, err := NewDialer(srvAddr, store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	a.ErrorIs(<-announced, errors.ErrUnsupported)
+	serverKey := tr.RemotePeer().PublicKey
+	a.NoError(tr.Close())
+	rec, err := store.PeerAddresses(serverKey)
+	a.NoError(err)
+	a.Nil(rec)
+
+	d, err = NewDialer(
+		srvAddr, store, acceptAll, DialWithAddressAnnouncements(true),
+	)
+	a.NoError(err)
+	tr, err = d.Dial()
+	a.NoError(err)
+	a.NoError(<-announced)
+	echo(t, tr, "after announcement")
+	a.NoError(tr.Close())
+
+	rec, err = store.PeerAddresses(serverKey)
+	a.NoError(err)
+	a.NotNil(rec)
+	a.Equal(
+		[]storage.PeerAddress{
+			{Network: "relay", Address: "relay.example.org/token"},
+			{Network: "tcp", Address: addr},
+		},
+		rec.Addresses,
+	)
+	a.True(VerifyAnnouncedAddresses(serverKey, rec))
+	rec.Addresses[1].Address = "203.0.113.7:9000"
+	a.False(VerifyAnnouncedAddresses(serverKey, rec))
+}
+
+func TestDialWithAnnouncedAddresses(t *testing.T) {
+	a := require.New(t)
+	srvAddr, announced := startAnnouncingServer(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	deadAddr := l.Addr().String()
+	a.NoError(l.Close())
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(srvAddr, store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	<-announced
+	serverKey := tr.RemotePeer().PublicKey
+	a.NoError(tr.Close())
+
+	// The server moved to srvAddr, and said so.
+	a.NoError(store.SetPeerAddresses(serverKey, storage.AnnouncedAddresses{
+		Addresses: []storage.PeerAddress{
+			{Network: "relay", Address: "ignored"},
+			{Network: "tcp", Address: srvAddr},
+		},
+	}))
+	d, err = NewDialer(
+		deadAddr, store, acceptAll, DialWithAnnouncedAddresses(serverKey),
+	)
+	a.NoError(err)
+	tr, err = d.Dial()
+	a.NoError(err)
+	<-announced
+	echo(t, tr, "moved")
+	a.NoError(tr.Close())
+
+	// Another peer at a known address is not accepted in its place.
+	other, err := attest.New()
+	a.NoError(err)
+	d, err = NewDialer(
+		srvAddr, store, acceptAll,
+		DialWithAnnouncedAddresses(other.MarshalPublicKey()),
+	)
+	a.NoError(err)
+	_, err = d.Dial()
+	a.ErrorIs(err, ErrVerificationFailed)
+
+	_, err = NewDialer(
+		srvAddr, store, acceptAll, DialWithAnnouncedAddresses([]byte("key")),
+	)
+	a.ErrorIs(err, storage.ErrInvalidPublicKey)
+}
@@ -0,0 +1,93 @@
+package kamune
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/pkg/attest"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// Signature returns what the sender signed for the message, to be recorded
+// with [storage.Storage.AddSignedChatEntry] so that [VerifyChatEntry] can
+// tell who sent it long after the session is gone. It is nil for metadata
+// that was not sent or received by a [Transport].
+func (m Metadata) Signature() *storage.MessageSignature {
+	if m.signature == nil {
+		return nil
+	}
+	return &storage.MessageSignature{
+		Metadata:  m.raw,
+		Message:   m.message,
+		Signature: m.signature,
+	}
+}
+
+// VerifyChatEntry checks that e holds a message signed by publicKey, as
+// recorded with [Metadata.Signature], and that its content is what was
+// signed: either the encoded message itself or, for messages sent as
+// [Bytes], their value. It fails with [ErrVerificationFailed] otherwise,
+// including for entries recorded without a signature.
+func VerifyChatEntry(publicKey []byte, e storage.ChatEntry) error {
+	sig := e.Signature
+	if sig == nil {
+		return fmt.Errorf("%w: entry is not signed", ErrVerificationFailed)
+	}
+	var md pb.Metadata
+	if err := proto.Unmarshal(sig.Metadata, &md); err != nil {
+		return fmt.Errorf(
+			"%w: unmarshalling metadata: %w", ErrVerificationFailed, err,
+		)
+	}
+
+	// A deduplicated message is signed by reference to its content.
+	signed := sig.Message
+	if ref := md.GetReference(); ref != nil {
+		sum := sha256.Sum256(sig.Message)
+		if !bytes.Equal(ref, sum[:]) {
+			return fmt.Errorf(
+				"%w: message does not match its reference",
+				ErrVerificationFailed,
+			)
+		}
+		signed = nil
+	}
+	if !attest.Verify(
+		publicKey, signingInput(sig.Metadata, signed), sig.Signature,
+	) {
+		return fmt.Errorf("%w: invalid signature", ErrVerificationFailed)
+	}
+
+	if bytes.Equal(e.Data, sig.Message) {
+		return nil
+	}
+	var value wrapperspb.BytesValue
+	err := proto.Unmarshal(sig.Message, &value)
+	if err != nil || !bytes.Equal(e.Data, value.GetValue()) {
+		return fmt.Errorf(
+			"%w: content differs from the signed message",
+			ErrVerificationFailed,
+		)
+	}
+	return nil
+}
+
+// VerifyTranscript checks every entry of tr with [VerifyChatEntry], against
+// the key of the side that sent it, and returns the outcome for each entry
+// in order: nil for the authenticated ones.
+func VerifyTranscript(tr *storage.Transcript) []error {
+	errs := make([]error, len(tr.Entries))
+	for i, e := range tr.Entries {
+		key := tr.PeerKey
+		if e.Sender == storage.SenderLocal {
+			key = tr.LocalKey
+		}
+		errs[i] = VerifyChatEntry(key, e)
+	}
+	return errs
+}
@@ -0,0 +1,68 @@
+package kamune
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+func TestVerifyTranscript(t *testing.T) {
+	a := require.New(t)
+	addr, _, _ := startEchoServer(t)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, storePeer)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+
+	record := func(md *Metadata, data []byte, sender storage.Sender) {
+		sig := md.Signature()
+		a.NotNil(sig)
+		a.NoError(store.AddSignedChatEntry(
+			tr.SessionID(), data, md.Timestamp(), 0, sender, *sig,
+		))
+	}
+	for _, text := range []string{"hello", "again", "again"} {
+		md, err := tr.Send(Bytes([]byte(text)), RouteExchangeMessages)
+		a.NoError(err)
+		record(md, []byte(text), storage.SenderLocal)
+		reply := Bytes(nil)
+		md, err = tr.Receive(reply)
+		a.NoError(err)
+		record(md, reply.Value, storage.SenderPeer)
+	}
+	a.NoError(store.AddChatEntry(
+		tr.SessionID(), []byte("unsigned"), time.Now(), storage.SenderPeer,
+	))
+
+	transcript, err := store.ExportChatHistory(tr.SessionID(), true)
+	a.NoError(err)
+	a.Equal(tr.RemotePeer().PublicKey, transcript.PeerKey)
+	a.Len(transcript.Entries, 7)
+	errs := VerifyTranscript(transcript)
+	for _, err := range errs[:6] {
+		a.NoError(err)
+	}
+	a.ErrorIs(errs[6], ErrVerificationFailed)
+
+	// Altered content, or a swapped sender, no longer verifies.
+	transcript.Entries[0].Data = []byte("goodbye")
+	transcript.Entries[1].Sender = storage.SenderLocal
+	errs = VerifyTranscript(transcript)
+	a.ErrorIs(errs[0], ErrVerificationFailed)
+	a.ErrorIs(errs[1], ErrVerificationFailed)
+	a.NoError(errs[2])
+
+	// Without signatures, nothing does.
+	plain, err := store.ExportChatHistory(tr.SessionID(), false)
+	a.NoError(err)
+	for _, err := range VerifyTranscript(plain) {
+		a.ErrorIs(err, ErrVerificationFailed)
+	}
+}
@@ -0,0 +1,390 @@
+package kamune
+
+import (
+	"math/rand/v2"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// chaosConfig describes the faults a chaosConn injects in one direction.
+// Probabilities are in [0, 1] and are rolled independently for every frame
+// once the first skip frames have passed through untouched.
+type chaosConfig struct {
+	// latency delays every frame.
+	latency time.Duration
+	// duplicate delivers a frame twice.
+	duplicate float64
+	// reorder holds a frame back until the next one has been delivered, or
+	// until holdFor elapses. Only applied to writes.
+	reorder float64
+	// corrupt flips a random bit.
+	corrupt float64
+	// truncate delivers only the first half of a frame, as a partial write
+	// would.
+	truncate float64
+	// skip is the number of leading frames left intact.
+	skip int
+}
+
+// chaosConn wraps a Conn and injects latency, reordering, duplication,
+// corruption, and partial frames, to reproduce the behavior of flaky links
+// such as KCP over lossy Wi-Fi. Faults are drawn from a seeded source so
+// failures are reproducible.
+type chaosConn struct {
+	Conn
+	rng     *rand.Rand
+	held    []byte
+	hold    *time.Timer
+	pending [][]byte
+	write   chaosConfig
+	read    chaosConfig
+	writes  int
+	reads   int
+	holdFor time.Duration
+	mu      sync.Mutex
+	readMu  sync.Mutex
+}
+
+func newChaosConn(cn Conn, write, read chaosConfig, seed uint64) *chaosConn {
+	return &chaosConn{
+		Conn:    cn,
+		rng:     rand.New(rand.NewPCG(seed, seed)),
+		write:   write,
+		read:    read,
+		holdFor: 20 * time.Millisecond,
+	}
+}
+
+// configure replaces the fault configuration for both directions.
+func (c *chaosConn) configure(write, read chaosConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.write, c.read = write, read
+	c.writes, c.reads = 0, 0
+}
+
+// mangleLocked applies cfg to b and returns the frames to deliver. Caller
+// must hold c.mu.
+func (c *chaosConn) mangleLocked(
+	cfg chaosConfig, n int, b []byte,
+) [][]byte {
+	if n < cfg.skip {
+		return [][]byte{b}
+	}
+	b = append([]byte(nil), b...)
+	if len(b) > 0 && c.rng.Float64() < cfg.corrupt {
+		b[c.rng.IntN(len(b))] ^= 1 << c.rng.IntN(8)
+	}
+	if c.rng.Float64() < cfg.truncate {
+		b = b[:len(b)/2]
+	}
+	if c.rng.Float64() < cfg.duplicate {
+		return [][]byte{b, b}
+	}
+	return [][]byte{b}
+}
+
+func (c *chaosConn) WriteBytes(b []byte) error {
+	c.mu.Lock()
+	cfg := c.write
+	n := c.writes
+	c.writes++
+	frames := c.mangleLocked(cfg, n, b)
+	reorder := n >= cfg.skip && c.rng.Float64() < cfg.reorder
+	if reorder && c.held == nil {
+		c.held = frames[0]
+		c.hold = time.AfterFunc(c.holdFor, c.flush)
+		frames = frames[1:]
+	}
+	c.mu.Unlock()
+
+	time.Sleep(cfg.latency)
+	for _, f := range frames {
+		if err := c.Conn.WriteBytes(f); err != nil {
+			return err
+		}
+	}
+	if !reorder {
+		c.flush()
+	}
+	return nil
+}
+
+// flush delivers the held frame, if any.
+func (c *chaosConn) flush() {
+	c.mu.Lock()
+	held := c.held
+	c.held = nil
+	if c.hold != nil {
+		c.hold.Stop()
+		c.hold = nil
+	}
+	c.mu.Unlock()
+	if held != nil {
+		_ = c.Conn.WriteBytes(held)
+	}
+}
+
+func (c *chaosConn) ReadBytes() ([]byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for {
+		c.mu.Lock()
+		if len(c.pending) > 0 {
+			b := c.pending[0]
+			c.pending = c.pending[1:]
+			c.mu.Unlock()
+			return b, nil
+		}
+		c.mu.Unlock()
+
+		b, err := c.Conn.ReadBytes()
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		cfg := c.read
+		c.pending = c.mangleLocked(cfg, c.reads, b)
+		c.reads++
+		c.mu.Unlock()
+		time.Sleep(cfg.latency)
+	}
+}
+
+// dialChaos returns a dial function that wraps TCP connections in a
+// chaosConn, and a channel that receives each wrapped connection.
+func dialChaos(
+	write, read chaosConfig,
+) (func(string) (Conn, error), <-chan *chaosConn) {
+	conns := make(chan *chaosConn, 4)
+	var seed uint64
+	return func(addr string) (Conn, error) {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		seed++
+		cc := newChaosConn(newConn(c), write, read, seed)
+		conns <- cc
+		return cc, nil
+	}, conns
+}
+
+func TestChaos_Handshake(t *testing.T) {
+	tests := []struct {
+		name  string
+		write chaosConfig
+		read  chaosConfig
+		ok    bool
+	}{
+		{
+			name:  "latency",
+			write: chaosConfig{latency: 5 * time.Millisecond},
+			read:  chaosConfig{latency: 5 * time.Millisecond},
+			ok:    true,
+		},
+		{name: "reorder", write: chaosConfig{reorder: 1}},
+		{name: "corrupt write", write: chaosConfig{corrupt: 1, skip: 1}},
+		{name: "corrupt read", read: chaosConfig{corrupt: 1, skip: 1}},
+		{name: "truncate write", write: chaosConfig{truncate: 1}},
+		{name: "truncate read", read: chaosConfig{truncate: 1, skip: 1}},
+		{name: "duplicate write", write: chaosConfig{duplicate: 1, skip: 1}},
+		{name: "duplicate read", read: chaosConfig{duplicate: 1}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			addr, handlers, _ := startEchoServer(t)
+
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			dial, _ := dialChaos(tc.write, tc.read)
+			d, err := NewDialer(addr, store, acceptAll, DialWithFunc(dial))
+			a.NoError(err)
+			d.handshakeOpts.timeout = 5 * time.Second
+
+			start := time.Now()
+			tr, err := d.Dial()
+			if !tc.ok {
+				a.Error(err)
+				a.Less(time.Since(start), d.handshakeOpts.timeout,
+					"failure is detected, not timed out")
+				a.Zero(handlers.Load())
+				return
+			}
+			a.NoError(err)
+			defer tr.Close()
+			echo(t, tr, "hello")
+			echo(t, tr, "world")
+		})
+	}
+}
+
+func TestChaos_TransportRejectsMangledFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		write chaosConfig
+		read  chaosConfig
+		err   error
+	}{
+		{name: "duplicate", read: chaosConfig{duplicate: 1}, err: ErrOutOfSync},
+		{name: "corrupt", read: chaosConfig{corrupt: 1}},
+		{name: "truncate", read: chaosConfig{truncate: 1}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			addr, _, _ := startEchoServer(t)
+
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			dial, conns := dialChaos(chaosConfig{}, chaosConfig{})
+			d, err := NewDialer(addr, store, acceptAll, DialWithFunc(dial))
+			a.NoError(err)
+			tr, err := d.Dial()
+			a.NoError(err)
+			defer tr.Close()
+			cc := <-conns
+
+			echo(t, tr, "clean")
+			cc.configure(tc.write, tc.read)
+
+			_, err = tr.Send(Bytes([]byte("mangled")), RouteExchangeMessages)
+			a.NoError(err)
+			reply := Bytes(nil)
+			_, err = tr.Receive(reply)
+			if tc.err != nil {
+				// The first copy is accepted; the duplicate is not.
+				a.NoError(err)
+				a.Equal("mangled", string(reply.Value))
+				_, err = tr.Receive(reply)
+				a.ErrorIs(err, tc.err)
+			} else {
+				a.Error(err)
+			}
+			stats := tr.Stats()
+			a.EqualValues(1, stats.Undecryptable+stats.OutOfSync)
+		})
+	}
+}
+
+func TestChaos_TransportDetectsReordering(t *testing.T) {
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	exited := make(chan error, 1)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	srv, err := NewServer(
+		"", func(t *Transport) error {
+			for {
+				if _, err := t.Receive(Bytes(nil)); err != nil {
+					exited <- err
+					return nil
+				}
+			}
+		}, store, acceptAll, ServeWithListener(&tcpListener{Listener: l}),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	clientStore, clientCleanup := newTestStore(t)
+	defer clientCleanup()
+	dial, conns := dialChaos(chaosConfig{}, chaosConfig{})
+	d, err := NewDialer(
+		l.Addr().String(), clientStore, acceptAll, DialWithFunc(dial),
+	)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	cc := <-conns
+
+	cc.configure(chaosConfig{reorder: 1}, chaosConfig{})
+	cc.holdFor = time.Second
+	for _, msg := range []string{"first", "second"} {
+		_, err = tr.Send(Bytes([]byte(msg)), RouteExchangeMessages)
+		a.NoError(err)
+	}
+	a.ErrorIs(<-exited, ErrOutOfSync)
+}
+
+func TestChaos_Resumption(t *testing.T) {
+	tests := []struct {
+		name  string
+		write chaosConfig
+		read  chaosConfig
+		ok    bool
+	}{
+		{
+			name:  "latency",
+			write: chaosConfig{latency: 5 * time.Millisecond},
+			read:  chaosConfig{latency: 5 * time.Millisecond},
+			ok:    true,
+		},
+		{name: "reorder", write: chaosConfig{reorder: 1}},
+		{name: "corrupt", write: chaosConfig{corrupt: 1, skip: 1}},
+		{name: "truncate", read: chaosConfig{truncate: 1, skip: 1}},
+		{name: "duplicate", write: chaosConfig{duplicate: 1, skip: 1}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			ctx := setupResumptionTest(t)
+			defer ctx.cleanup()
+
+			handled := make(chan struct{}, 1)
+			srv, err := NewServer(
+				"", func(t *Transport) error {
+					handled <- struct{}{}
+					reply := Bytes(nil)
+					md, err := t.Receive(reply)
+					if err != nil {
+						return nil
+					}
+					_, err = t.Send(reply, md.Route())
+					return err
+				}, ctx.storage2, acceptAll,
+			)
+			a.NoError(err)
+			srv.attest = ctx.attest2
+			srv.handshakeOpts.timeout = 5 * time.Second
+
+			d, err := NewDialer(
+				"", ctx.storage1, acceptAll, DialWithResume(ctx.sessionID),
+			)
+			a.NoError(err)
+			d.attest = ctx.attest1
+			d.handshakeOpts.timeout = 5 * time.Second
+
+			c1, c2 := net.Pipe()
+			cc := newChaosConn(newConn(c1), tc.write, tc.read, 1)
+			defer cc.Close()
+			go func() { _ = srv.serve(newConn(c2)) }()
+
+			start := time.Now()
+			tr, err := d.handshake(cc)
+			if !tc.ok {
+				a.Error(err)
+				a.Less(time.Since(start), d.handshakeOpts.timeout,
+					"failure is detected, not timed out")
+				a.Empty(handled)
+				return
+			}
+			a.NoError(err)
+			a.Equal(ctx.sessionID, tr.SessionID())
+			<-handled
+
+			cc.configure(chaosConfig{}, chaosConfig{})
+			echo(t, tr, "resumed")
+		})
+	}
+}
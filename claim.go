@@ -0,0 +1,207 @@
+package kamune
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/pkg/attest"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// claimSigningContext separates claim signatures from every other signature
+// made with the same key.
+const claimSigningContext = "kamune-identity-claim\x00"
+
+// Claim is a statement by an issuer, such as an organisation's signing key,
+// that a public key belongs to a subject, such as an email address or a
+// username. Peers present a chain of claims in their introduction: the first
+// is about their own key, and each one after it vouches for the issuer of the
+// one before, up to an issuer the verifying side trusts. See
+// [ClaimVerifier].
+type Claim struct {
+	// Expires is when the claim stops being valid. The zero value never
+	// expires.
+	Expires   time.Time
+	Subject   string
+	PublicKey []byte
+	// Issuer is the public key of the claim's signer.
+	Issuer    []byte
+	Signature []byte
+}
+
+// ClaimVerifier checks the chain of claims a peer presents in its
+// introduction, chain[0] being the claim about the peer's own key, and returns
+// the subject it vouches for. Peers whose claims are verified are accepted
+// and stored as known peers without consulting the [RemoteVerifier], and
+// their subject is made available as [storage.Peer.Subject]; peers without
+// claims, or whose claims are rejected, are left to the [RemoteVerifier] as
+// before. See [TrustClaimIssuers] for a verifier that trusts a fixed set of
+// issuers.
+type ClaimVerifier func(peer *storage.Peer, chain []Claim) (string, error)
+
+// SignClaim returns a claim by issuer that publicKey belongs to subject, valid
+// until expires.
+func SignClaim(
+	issuer attest.Attester, subject string, publicKey []byte, expires time.Time,
+) (Claim, error) {
+	if !attest.IsValidPublicKey(publicKey) {
+		return Claim{}, fmt.Errorf("%w: invalid public key", ErrInvalidClaim)
+	}
+	c := Claim{
+		Expires:   expires,
+		Subject:   subject,
+		PublicKey: publicKey,
+		Issuer:    issuer.MarshalPublicKey(),
+	}
+	sig, err := issuer.Sign(c.signed())
+	if err != nil {
+		return Claim{}, fmt.Errorf("signing claim: %w", err)
+	}
+	c.Signature = sig
+	return c, nil
+}
+
+// TrustClaimIssuers returns a [ClaimVerifier] that accepts a chain of claims
+// if each is signed by the key the next one is about, none has expired, and
+// the last is signed by one of the trusted public keys. Chains fail with
+// [ErrVerificationFailed] if a signature is invalid or the last issuer is not
+// trusted, and with [ErrInvalidClaim] otherwise.
+func TrustClaimIssuers(trusted ...[]byte) ClaimVerifier {
+	return func(peer *storage.Peer, chain []Claim) (string, error) {
+		return verifyClaimChain(time.Now(), trusted, peer.PublicKey, chain)
+	}
+}
+
+func verifyClaimChain(
+	now time.Time, trusted [][]byte, key []byte, chain []Claim,
+) (string, error) {
+	if len(chain) == 0 {
+		return "", fmt.Errorf("%w: no claims", ErrInvalidClaim)
+	}
+	for i, c := range chain {
+		switch {
+		case !bytes.Equal(c.PublicKey, key):
+			return "", fmt.Errorf(
+				"%w: claim %d is about another key", ErrInvalidClaim, i,
+			)
+		case !c.Expires.IsZero() && !now.Before(c.Expires):
+			return "", fmt.Errorf(
+				"%w: claim %d expired at %s", ErrInvalidClaim, i, c.Expires,
+			)
+		case !attest.Verify(c.Issuer, c.signed(), c.Signature):
+			return "", fmt.Errorf(
+				"%w: invalid signature on claim %d", ErrVerificationFailed, i,
+			)
+		}
+		key = c.Issuer
+	}
+	if !slices.ContainsFunc(trusted, func(k []byte) bool {
+		return bytes.Equal(k, key)
+	}) {
+		return "", fmt.Errorf(
+			"%w: claims issued by an untrusted key", ErrVerificationFailed,
+		)
+	}
+	return chain[0].Subject, nil
+}
+
+// verify checks the claims in the introduction st that peer was read from.
+// It reports whether they were verified, in which case peer has been given
+// its subject and stored as a known peer.
+func (v ClaimVerifier) verify(
+	store *storage.Storage, peer *storage.Peer, st *pb.SignedTransport,
+) (bool, error) {
+	if v == nil {
+		return false, nil
+	}
+	chain, err := introClaims(st)
+	if err != nil || len(chain) == 0 {
+		return false, err
+	}
+	subject, err := v(peer, chain)
+	if err != nil {
+		slog.Info(
+			"identity claims rejected",
+			slog.String("peer", peer.Name),
+			slog.Any("error", err),
+		)
+		return false, nil
+	}
+	peer.Subject = subject
+	if _, err := store.FindPeer(peer.PublicKey); err == nil {
+		return true, nil
+	}
+	if err := store.StorePeer(peer); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// introClaims returns the claims carried by an introduction whose signature
+// has already been verified.
+func introClaims(st *pb.SignedTransport) ([]Claim, error) {
+	var introduce pb.Introduce
+	if err := proto.Unmarshal(st.GetData(), &introduce); err != nil {
+		return nil, fmt.Errorf("deserializing: %w", err)
+	}
+	chain := make([]Claim, 0, len(introduce.GetClaims()))
+	for _, c := range introduce.GetClaims() {
+		claim := Claim{
+			Subject:   c.GetSubject(),
+			PublicKey: c.GetPublicKey(),
+			Issuer:    c.GetIssuer(),
+			Signature: c.GetSignature(),
+		}
+		if c.GetExpires() != nil {
+			claim.Expires = c.GetExpires().AsTime()
+		}
+		chain = append(chain, claim)
+	}
+	return chain, nil
+}
+
+// checkClaimChain rejects chains longer than claimChainMaxLength.
+func checkClaimChain(n int) error {
+	if n > claimChainMaxLength {
+		return fmt.Errorf(
+			"%w: chain of %d claims, limit is %d",
+			ErrInvalidClaim, n, claimChainMaxLength,
+		)
+	}
+	return nil
+}
+
+func (c Claim) toProto() *pb.IdentityClaim {
+	claim := &pb.IdentityClaim{
+		Subject:   c.Subject,
+		PublicKey: c.PublicKey,
+		Issuer:    c.Issuer,
+		Signature: c.Signature,
+	}
+	if !c.Expires.IsZero() {
+		claim.Expires = timestamppb.New(c.Expires)
+	}
+	return claim
+}
+
+// signed returns the message a claim's signature covers.
+func (c Claim) signed() []byte {
+	var expires int64
+	if !c.Expires.IsZero() {
+		expires = c.Expires.UnixNano()
+	}
+	b := []byte(claimSigningContext)
+	for _, field := range [][]byte{[]byte(c.Subject), c.PublicKey, c.Issuer} {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(field)))
+		b = append(b, field...)
+	}
+	return binary.BigEndian.AppendUint64(b, uint64(expires))
+}
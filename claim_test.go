@@ -0,0 +1,234 @@
+package kamune
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/attest"
+)
+
+func TestVerifyClaimChain(t *testing.T) {
+	a := require.New(t)
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	root, err := attest.New()
+	a.NoError(err)
+	team, err := attest.New()
+	a.NoError(err)
+	peer, err := attest.New()
+	a.NoError(err)
+	key := peer.MarshalPublicKey()
+
+	sign := func(
+		issuer *attest.Attest, subject string, key []byte, expires time.Time,
+	) Claim {
+		c, err := SignClaim(issuer, subject, key, expires)
+		a.NoError(err)
+		return c
+	}
+	direct := sign(root, "alice@example.org", key, time.Time{})
+	leaf := sign(team, "alice@example.org", key, now.Add(time.Hour))
+	intermediate := sign(root, "ops", team.MarshalPublicKey(), time.Time{})
+	tampered := direct
+	tampered.Subject = "mallory@example.org"
+
+	tests := []struct {
+		name    string
+		chain   []Claim
+		subject string
+		err     error
+	}{
+		{name: "direct", chain: []Claim{direct}, subject: "alice@example.org"},
+		{
+			name:    "intermediate",
+			chain:   []Claim{leaf, intermediate},
+			subject: "alice@example.org",
+		},
+		{name: "empty", err: ErrInvalidClaim},
+		{
+			name:  "untrusted issuer",
+			chain: []Claim{leaf},
+			err:   ErrVerificationFailed,
+		},
+		{
+			name: "another key",
+			chain: []Claim{
+				sign(root, "bob@example.org", team.MarshalPublicKey(), now),
+			},
+			err: ErrInvalidClaim,
+		},
+		{
+			name:  "broken link",
+			chain: []Claim{direct, intermediate},
+			err:   ErrInvalidClaim,
+		},
+		{
+			name:  "expired",
+			chain: []Claim{sign(root, "alice@example.org", key, now)},
+			err:   ErrInvalidClaim,
+		},
+		{
+			name:  "tampered",
+			chain: []Claim{tampered},
+			err:   ErrVerificationFailed,
+		},
+	}
+	trusted := [][]byte{root.MarshalPublicKey()}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			subject, err := verifyClaimChain(now, trusted, key, tc.chain)
+			if tc.err != nil {
+				a.ErrorIs(err, tc.err)
+				return
+			}
+			a.NoError(err)
+			a.Equal(tc.subject, subject)
+		})
+	}
+}
+
+// startClaimServer runs an echo server that trusts no peer by itself and
+// verifies claims with v. It sends the subject of every accepted dialer on
+// the returned channel.
+func startClaimServer(
+	t *testing.T, v ClaimVerifier, opts ...ServerOptions,
+) (string, <-chan string) {
+	t.Helper()
+	subjects := make(chan string, 1)
+	echo := NewEchoHandler()
+	handler := func(tr *Transport) error {
+		subjects <- tr.RemotePeer().Subject
+		return echo(tr)
+	}
+
+	_, addr, _ := startTestServer(
+		t, handler, nil, rejectAll,
+		append([]ServerOptions{ServeWithClaimVerifier(v)}, opts...)...,
+	)
+	return addr, subjects
+}
+
+func TestClaimVerifier(t *testing.T) {
+	a := require.New(t)
+	org, err := attest.New()
+	a.NoError(err)
+	other, err := attest.New()
+	a.NoError(err)
+	addr, subjects := startClaimServer(
+		t, TrustClaimIssuers(org.MarshalPublicKey()),
+	)
+
+	tests := []struct {
+		issuer  *attest.Attest
+		name    string
+		subject string
+		// accepted reports whether the server accepts the dialer.
+		accepted bool
+	}{
+		{
+			name:     "trusted",
+			issuer:   org,
+			subject:  "alice@example.org",
+			accepted: true,
+		},
+		{name: "untrusted", issuer: other, subject: "alice@example.org"},
+		{name: "no claims"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			var opts []DialOption
+			if tc.issuer != nil {
+				at, err := store.Attester()
+				a.NoError(err)
+				c, err := SignClaim(
+					tc.issuer, tc.subject, at.MarshalPublicKey(),
+					time.Now().Add(time.Hour),
+				)
+				a.NoError(err)
+				opts = append(opts, DialWithClaims(c))
+			}
+
+			d, err := NewDialer(addr, store, storePeer, opts...)
+			a.NoError(err)
+			tr, err := d.Dial()
+			if !tc.accepted {
+				a.Error(err)
+				return
+			}
+			a.NoError(err)
+			defer tr.Close()
+			a.Equal(tc.subject, <-subjects)
+			echo(t, tr, "hello")
+		})
+	}
+}
+
+func TestDialWithClaimVerifier(t *testing.T) {
+	a := require.New(t)
+	org, err := attest.New()
+	a.NoError(err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	serverStore, cleanup := newTestStore(t)
+	defer cleanup()
+	at, err := serverStore.Attester()
+	a.NoError(err)
+	c, err := SignClaim(
+		org, "chat.example.org", at.MarshalPublicKey(), time.Time{},
+	)
+	a.NoError(err)
+	srv, err := NewServer(
+		"", NewEchoHandler(), serverStore, acceptAll,
+		ServeWithListener(&tcpListener{Listener: ln}), ServeWithClaims(c),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	// The dialer trusts the server by its claims alone, and stores it so
+	// that the session can be resumed.
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(
+		ln.Addr().String(), store, rejectAll,
+		DialWithClaimVerifier(TrustClaimIssuers(org.MarshalPublicKey())),
+	)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	a.Equal("chat.example.org", tr.RemotePeer().Subject)
+	echo(t, tr, "hello")
+	_, err = store.FindPeer(at.MarshalPublicKey())
+	a.NoError(err)
+}
+
+func TestClaimOptions(t *testing.T) {
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	chain := make([]Claim, claimChainMaxLength+1)
+
+	_, err := NewServer("", nil, store, acceptAll, ServeWithClaims(chain...))
+	a.ErrorIs(err, ErrInvalidClaim)
+	_, err = NewServer("", nil, store, acceptAll, ServeWithClaimVerifier(nil))
+	a.Error(err)
+	_, err = NewDialer(
+		"127.0.0.1:1", store, acceptAll, DialWithClaims(chain...),
+	)
+	a.ErrorIs(err, ErrInvalidClaim)
+	_, err = NewDialer(
+		"127.0.0.1:1", store, acceptAll, DialWithClaimVerifier(nil),
+	)
+	a.Error(err)
+
+	_, err = SignClaim(nil, "alice", []byte("not a key"), time.Time{})
+	a.ErrorIs(err, ErrInvalidClaim)
+}
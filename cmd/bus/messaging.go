@@ -122,7 +122,8 @@ func (a *App) receiveMessages(session *liveSession) {
 		isActive := a.activeSessionID == session.ID
 		a.mu.Unlock()
 
-		if store := a.store(); store != nil && !a.incognito {
+		if store := a.store(); store != nil && !a.incognito &&
+			!metadata.Ephemeral() {
 			store.AddChatEntry(
 				session.ID, b.GetValue(), metadata.Timestamp(), storage.SenderPeer,
 			)
@@ -671,9 +671,6 @@ func (a *App) ConnectToServer(
 	}
 
 	if store := a.store(); store != nil && !a.incognito {
-		if err := store.CreateSession(sessionID, peer.PublicKey); err != nil {
-			a.addLogEntry("WARN", "Failed to create session record: "+err.Error())
-		}
 		a.deriveAndStoreRelayTokens(t, sessionID)
 	}
 
@@ -865,9 +862,6 @@ func (a *App) serverHandler(t *kamune.Transport) error {
 	}
 
 	if store := a.store(); store != nil && !a.incognito {
-		if err := store.CreateSession(sessionID, peer.PublicKey); err != nil {
-			a.addLogEntry("WARN", "Failed to create session record: "+err.Error())
-		}
 		a.deriveAndStoreRelayTokens(t, sessionID)
 	}
 
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/kamune-org/kamune/pkg/attest"
+)
+
+// configSigningContext separates the signatures of configuration updates
+// from every other signature made with the admin key. It must match the one
+// kamune-admin signs with (cmd/kamune-admin/config.go).
+const configSigningContext = "kamune-daemon-config\x00"
+
+// Settings under which the configuration managed by an admin is recorded.
+// The admin key is only pinned out of band, with kamune-admin.
+const (
+	settingAdminKey      = "admin_key"
+	settingConfigVersion = "config_version"
+	settingReconnect     = "reconnect"
+)
+
+// adminKey returns the pinned admin key and the version of the last
+// configuration update applied, or a nil key if the daemon is not managed.
+func (d *Daemon) adminKey() ([]byte, uint64, error) {
+	store := d.store()
+	if store == nil {
+		return nil, 0, nil
+	}
+	encoded, err := store.GetSettings("daemon", settingAdminKey)
+	if err != nil || encoded == "" {
+		return nil, 0, err
+	}
+	key, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, 0, fmt.Errorf("decoding admin key: %w", err)
+	}
+	var version uint64
+	v, err := store.GetSettings("daemon", settingConfigVersion)
+	if err == nil && v != "" {
+		version, _ = strconv.ParseUint(v, 10, 64)
+	}
+	return key, version, nil
+}
+
+// rejectManaged emits an error and returns true if an admin key is pinned,
+// in which case the settings it manages only change with set_config.
+func (d *Daemon) rejectManaged(cmd Command) bool {
+	key, _, err := d.adminKey()
+	switch {
+	case err != nil:
+		d.emitError(cmd.ID, fmt.Sprintf("reading admin key: %v", err))
+		return true
+	case key != nil:
+		d.addLogEntry("WARN", "Rejected unsigned change: "+string(cmd.CMD))
+		d.emitError(cmd.ID,
+			"configuration is managed by an admin key, use set_config")
+		return true
+	}
+	return false
+}
+
+// reconnectAllowed reports whether dialed sessions are resumed transparently
+// when their connection drops.
+func (d *Daemon) reconnectAllowed() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return !d.noReconnect
+}
+
+// handleSetConfig applies a configuration update signed by the pinned admin
+// key. Updates must carry increasing versions, so that a recorded update
+// cannot be replayed to undo a later one. Every field of the update is
+// checked before any is applied.
+func (d *Daemon) handleSetConfig(cmd Command) {
+	var params SetConfigParams
+	if err := json.Unmarshal(cmd.Params, &params); err != nil {
+		d.emitError(cmd.ID, fmt.Sprintf("invalid params: %v", err))
+		return
+	}
+
+	store := d.store()
+	if store == nil {
+		d.emitError(cmd.ID, "storage is not open")
+		return
+	}
+	key, last, err := d.adminKey()
+	if err != nil {
+		d.emitError(cmd.ID, fmt.Sprintf("reading admin key: %v", err))
+		return
+	}
+	if key == nil {
+		d.emitError(cmd.ID,
+			"no admin key pinned, pin one with kamune-admin config pin")
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(params.UpdateBase64)
+	if err != nil {
+		d.emitError(cmd.ID, fmt.Sprintf("invalid base64 update: %v", err))
+		return
+	}
+	sig, err := base64.StdEncoding.DecodeString(params.SignatureBase64)
+	if err != nil {
+		d.emitError(cmd.ID, fmt.Sprintf("invalid base64 signature: %v", err))
+		return
+	}
+	msg := append([]byte(configSigningContext), data...)
+	if !attest.Verify(key, msg, sig) {
+		d.addLogEntry("WARN", "Rejected configuration update: bad signature")
+		d.emitError(cmd.ID, "invalid signature")
+		return
+	}
+
+	var update ConfigUpdate
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&update); err != nil {
+		d.emitError(cmd.ID, fmt.Sprintf("invalid update: %v", err))
+		return
+	}
+	if update.Version <= last {
+		d.addLogEntry("WARN", fmt.Sprintf(
+			"Rejected configuration version %d, %d already applied",
+			update.Version, last,
+		))
+		d.emitError(cmd.ID, fmt.Sprintf(
+			"stale version %d, last applied is %d", update.Version, last,
+		))
+		return
+	}
+	if m := update.VerificationMode; m != nil &&
+		(VerificationMode(*m) < VerificationModeStrict ||
+			VerificationMode(*m) > VerificationModeAutoAccept) {
+		d.emitError(cmd.ID, fmt.Sprintf("invalid mode: %d", *m))
+		return
+	}
+
+	changed := d.applyConfig(update)
+	err = store.SetSettings("daemon", settingConfigVersion,
+		strconv.FormatUint(update.Version, 10))
+	if err != nil {
+		d.emitError(cmd.ID, fmt.Sprintf("recording version: %v", err))
+		return
+	}
+
+	summary := strings.Join(changed, ", ")
+	d.addLogEntry("INFO", fmt.Sprintf(
+		"Applied configuration version %d: %s", update.Version, summary,
+	))
+	slog.Info("applied configuration",
+		slog.Uint64("version", update.Version),
+		slog.String("changed", summary),
+	)
+	d.emit(EvtResponse, cmd.ID, MapA{
+		"status": "applied", "version": update.Version,
+	})
+
+	d.mu.RLock()
+	serverRunning := d.server != nil
+	d.mu.RUnlock()
+	if update.VerificationMode != nil && serverRunning {
+		d.handleRestartServer(Command{ID: ""})
+	}
+}
+
+// applyConfig applies and persists the fields set in update, and returns
+// them as "name=value" pairs.
+func (d *Daemon) applyConfig(update ConfigUpdate) []string {
+	store := d.store()
+	var changed []string
+	set := func(key, value string) {
+		if store != nil {
+			_ = store.SetSettings("daemon", key, value)
+		}
+		changed = append(changed, key+"="+value)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if m := update.VerificationMode; m != nil {
+		d.verifMode = VerificationMode(*m)
+		set("verification_mode", strconv.Itoa(*m))
+	}
+	if v := update.Incognito; v != nil {
+		d.incognito = *v
+		set("incognito", strconv.FormatBool(*v))
+	}
+	if v := update.LogLevel; v != nil {
+		d.logLevel = *v
+		set("log_level", *v)
+	}
+	if v := update.Reconnect; v != nil {
+		d.noReconnect = !*v
+		set(settingReconnect, strconv.FormatBool(*v))
+	}
+	if len(changed) == 0 {
+		changed = append(changed, "no changes")
+	}
+	return changed
+}
+
+// handleGetConfig returns the managed settings, whether an admin key is
+// pinned, and the version of the last configuration update applied.
+func (d *Daemon) handleGetConfig(cmd Command) {
+	key, version, err := d.adminKey()
+	if err != nil {
+		d.emitError(cmd.ID, fmt.Sprintf("reading admin key: %v", err))
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	resp := MapA{
+		"managed":           key != nil,
+		"version":           version,
+		"verification_mode": int(d.verifMode),
+		"incognito":         d.incognito,
+		"log_level":         d.logLevel,
+		"reconnect":         !d.noReconnect,
+	}
+	if key != nil {
+		resp["admin_key"] = base64.RawURLEncoding.EncodeToString(key)
+	}
+	d.emit(EvtResponse, cmd.ID, resp)
+}
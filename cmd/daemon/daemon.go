@@ -45,6 +45,7 @@ type Daemon struct {
 	dbPath        string
 	verifMode     VerificationMode
 	incognito     bool
+	noReconnect   bool
 
 	verifMu        sync.Mutex
 	verifRequests  map[int64]*pendingVerification
@@ -75,8 +76,9 @@ type Daemon struct {
 	output   *json.Encoder
 	outputMu sync.Mutex
 
-	storeMu sync.Mutex
-	db      *storage.Storage
+	storeMu   sync.Mutex
+	db        *storage.Storage
+	storeOpts []storage.StorageOption
 
 	passphrase atomic.Value
 
@@ -86,6 +88,10 @@ type Daemon struct {
 	logLevel      string
 
 	fingerprintFmt string
+	filters        []FilterRule
+
+	streamsMu sync.Mutex
+	streams   map[string]*outgoingStream
 
 	wg sync.WaitGroup
 }
@@ -103,6 +109,7 @@ func NewDaemon() *Daemon {
 		status:         StatusDisconnected,
 		statusMsg:      "Not connected",
 		verifRequests:  make(map[int64]*pendingVerification),
+		streams:        make(map[string]*outgoingStream),
 		logBufferSize:  200,
 		logEntries:     make([]LogEntryInfo, 0, 200),
 		logLevel:       "INFO",
@@ -240,6 +247,7 @@ func (d *Daemon) openStorage(params OpenStorageParams) error {
 
 	d.storeMu.Lock()
 	d.db = store
+	d.storeOpts = opts
 	d.storeMu.Unlock()
 
 	d.mu.Lock()
@@ -337,6 +345,10 @@ func (d *Daemon) handleCommand(cmd Command) {
 		d.handleDial(cmd)
 	case CmdSendMessage:
 		d.handleSendMessage(cmd)
+	case CmdSendFile:
+		d.handleSendFile(cmd)
+	case CmdSendMessageStream:
+		d.handleSendMessageStream(cmd)
 	case CmdListSessions:
 		d.handleListSessions(cmd)
 	case CmdCloseSession:
@@ -381,6 +393,10 @@ func (d *Daemon) handleCommand(cmd Command) {
 		d.handleDeletePeer(cmd)
 	case CmdGetFingerprint:
 		d.handleGetFingerprint(cmd)
+	case CmdSetFilters:
+		d.handleSetFilters(cmd)
+	case CmdGetFilters:
+		d.handleGetFilters(cmd)
 	case CmdGetMyName:
 		d.handleGetMyName(cmd)
 	case CmdSetMyName:
@@ -393,6 +409,10 @@ func (d *Daemon) handleCommand(cmd Command) {
 		d.handleGetIncognito(cmd)
 	case CmdSetIncognito:
 		d.handleSetIncognito(cmd)
+	case CmdSetConfig:
+		d.handleSetConfig(cmd)
+	case CmdGetConfig:
+		d.handleGetConfig(cmd)
 	case CmdAddPeer:
 		d.handleAddPeer(cmd)
 	case CmdRenamePeer:
@@ -419,6 +439,12 @@ func (d *Daemon) handleCommand(cmd Command) {
 		d.handleGetFingerprintFormat(cmd)
 	case CmdSetFingerprintFormat:
 		d.handleSetFingerprintFormat(cmd)
+	case CmdCompactStorage:
+		d.handleCompactStorage(cmd)
+	case CmdPruneExpired:
+		d.handlePruneExpired(cmd)
+	case CmdStorageStats:
+		d.handleStorageStats(cmd)
 	case CmdShutdown:
 		d.Shutdown()
 	default:
@@ -470,13 +496,14 @@ func (d *Daemon) handleSubmitPassphrase(cmd Command) {
 	d.closeStore()
 	d.passphrase.Store([]byte(params.Passphrase))
 
-	store, err := storage.OpenStorage(
+	opts := []storage.StorageOption{
 		storage.WithDBPath(dbPath),
 		storage.WithPassphraseHandler(func() ([]byte, error) {
 			p, _ := d.passphrase.Load().([]byte)
 			return p, nil
 		}),
-	)
+	}
+	store, err := storage.OpenStorage(opts...)
 	if err != nil {
 		d.emitError(cmd.ID, fmt.Sprintf("failed to open storage: %v", err))
 		return
@@ -484,6 +511,7 @@ func (d *Daemon) handleSubmitPassphrase(cmd Command) {
 
 	d.storeMu.Lock()
 	d.db = store
+	d.storeOpts = opts
 	d.storeMu.Unlock()
 
 	d.loadIdentityAndHistory()
@@ -795,6 +823,9 @@ func (d *Daemon) handleGetLogLevel(cmd Command) {
 // handleSetLogLevel sets the minimum log level (mirrors cmd/bus/app.go:1090-1097).
 // Persisted to storage when available.
 func (d *Daemon) handleSetLogLevel(cmd Command) {
+	if d.rejectManaged(cmd) {
+		return
+	}
 	var params SetLogLevelParams
 	if err := json.Unmarshal(cmd.Params, &params); err != nil {
 		d.emitError(cmd.ID, fmt.Sprintf("invalid params: %v", err))
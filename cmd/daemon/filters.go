@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kamune-org/kamune"
+)
+
+// FilterRule tags the messages it matches with its name in message_received
+// events, so that frontends can prioritize notifications without parsing
+// every message themselves. A message matches when it contains one of the
+// keywords as a whole word, ignoring case, or mentions the local name as
+// "@name" while Mentions is set. SessionIDs, if any, restrict the rule to
+// those sessions.
+type FilterRule struct {
+	Name       string   `json:"name"`
+	Keywords   []string `json:"keywords,omitempty"`
+	Mentions   bool     `json:"mentions,omitempty"`
+	SessionIDs []string `json:"session_ids,omitempty"`
+}
+
+// validate reports whether the rule can match anything.
+func (r FilterRule) validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return fmt.Errorf("filter name is required")
+	}
+	if !r.Mentions && len(r.Keywords) == 0 {
+		return fmt.Errorf("filter %q has no keywords and no mentions", r.Name)
+	}
+	for _, k := range r.Keywords {
+		if strings.TrimSpace(k) == "" {
+			return fmt.Errorf("filter %q has an empty keyword", r.Name)
+		}
+	}
+	return nil
+}
+
+// match reports whether text, received on sessionID, matches the rule while
+// the local name is myName.
+func (r FilterRule) match(sessionID, text, myName string) bool {
+	if len(r.SessionIDs) > 0 && !slices.Contains(r.SessionIDs, sessionID) {
+		return false
+	}
+	if r.Mentions && myName != "" && containsWord(text, "@"+myName) {
+		return true
+	}
+	for _, k := range r.Keywords {
+		if containsWord(text, strings.TrimSpace(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchFilters returns the names of the rules that text matches, in order.
+func matchFilters(
+	rules []FilterRule, sessionID, text, myName string,
+) []string {
+	var names []string
+	for _, r := range rules {
+		if r.match(sessionID, text, myName) {
+			names = append(names, r.Name)
+		}
+	}
+	return names
+}
+
+// containsWord reports whether text contains word, ignoring case, with no
+// letter or digit right before or after it.
+func containsWord(text, word string) bool {
+	text, word = strings.ToLower(text), strings.ToLower(word)
+	for i := 0; ; {
+		j := strings.Index(text[i:], word)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(word)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if !isWordRune(before) && !isWordRune(after) {
+			return true
+		}
+		_, size := utf8.DecodeRuneInString(text[start:])
+		i = start + size
+	}
+}
+
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// messageEvent returns the message_received event for data, received on
+// sessionID with md, tagged with the filters it matches.
+func (d *Daemon) messageEvent(
+	sessionID string, data []byte, md *kamune.Metadata,
+) MapA {
+	evt := MapA{
+		"session_id":  sessionID,
+		"data_base64": base64.StdEncoding.EncodeToString(data),
+		"timestamp":   md.Timestamp().Format(time.RFC3339Nano),
+	}
+	if md.Ephemeral() {
+		evt["ephemeral"] = true
+	}
+	d.mu.RLock()
+	matched := matchFilters(d.filters, sessionID, string(data), d.myName)
+	d.mu.RUnlock()
+	if len(matched) > 0 {
+		evt["matched_rules"] = matched
+	}
+	return evt
+}
+
+// loadFilters restores the filters saved by set_filters.
+func (d *Daemon) loadFilters() {
+	store := d.store()
+	if store == nil {
+		return
+	}
+	raw, err := store.GetSettings("daemon", "filters")
+	if err != nil || raw == "" {
+		return
+	}
+	var rules []FilterRule
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		d.addLogEntry("WARN", "Ignoring saved filters: "+err.Error())
+		return
+	}
+	d.mu.Lock()
+	d.filters = rules
+	d.mu.Unlock()
+}
+
+// handleSetFilters replaces the priority filters and saves them to storage.
+func (d *Daemon) handleSetFilters(cmd Command) {
+	var params SetFiltersParams
+	if err := json.Unmarshal(cmd.Params, &params); err != nil {
+		d.emitError(cmd.ID, fmt.Sprintf("invalid params: %v", err))
+		return
+	}
+	seen := make(map[string]bool, len(params.Rules))
+	for _, r := range params.Rules {
+		if err := r.validate(); err != nil {
+			d.emitError(cmd.ID, err.Error())
+			return
+		}
+		if seen[r.Name] {
+			d.emitError(cmd.ID, fmt.Sprintf("duplicate filter %q", r.Name))
+			return
+		}
+		seen[r.Name] = true
+	}
+
+	if store := d.store(); store != nil {
+		data, err := json.Marshal(params.Rules)
+		if err == nil {
+			err = store.SetSettings("daemon", "filters", string(data))
+		}
+		if err != nil {
+			d.emitError(cmd.ID, fmt.Sprintf("failed to save filters: %v", err))
+			return
+		}
+	}
+	d.mu.Lock()
+	d.filters = params.Rules
+	d.mu.Unlock()
+
+	d.addLogEntry("DEBUG", fmt.Sprintf("Set %d filters", len(params.Rules)))
+	d.emit(EvtResponse, cmd.ID, MapA{"rules": nonNilRules(params.Rules)})
+}
+
+// handleGetFilters returns the priority filters.
+func (d *Daemon) handleGetFilters(cmd Command) {
+	d.mu.RLock()
+	rules := nonNilRules(d.filters)
+	d.mu.RUnlock()
+	d.emit(EvtResponse, cmd.ID, MapA{"rules": rules})
+}
+
+// nonNilRules returns rules, or an empty list so that it encodes as [].
+func nonNilRules(rules []FilterRule) []FilterRule {
+	if rules == nil {
+		return []FilterRule{}
+	}
+	return rules
+}
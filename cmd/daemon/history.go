@@ -64,6 +64,13 @@ func (d *Daemon) loadIdentityAndHistory() {
 		d.mu.Unlock()
 	}
 
+	if v, err := store.GetSettings("daemon", settingReconnect); err == nil {
+		d.mu.Lock()
+		d.noReconnect = v == "false"
+		d.mu.Unlock()
+	}
+
+	d.loadFilters()
 	d.loadHistorySessions()
 }
 
@@ -400,6 +407,9 @@ func (d *Daemon) handleGetIncognito(cmd Command) {
 }
 
 func (d *Daemon) handleSetIncognito(cmd Command) {
+	if d.rejectManaged(cmd) {
+		return
+	}
 	var params SetIncognitoParams
 	if err := json.Unmarshal(cmd.Params, &params); err != nil {
 		d.emitError(cmd.ID, fmt.Sprintf("invalid params: %v", err))
@@ -43,6 +43,8 @@ const (
 	CmdGetStatus               CMD = "get_status"
 	CmdDial                    CMD = "dial"
 	CmdSendMessage             CMD = "send_message"
+	CmdSendFile                CMD = "send_file"
+	CmdSendMessageStream       CMD = "send_message_stream"
 	CmdListSessions            CMD = "list_sessions"
 	CmdCloseSession            CMD = "close_session"
 	CmdRenameSession           CMD = "rename_session"
@@ -68,6 +70,8 @@ const (
 	CmdGetLibraryVersion       CMD = "get_library_version"
 	CmdGetIncognito            CMD = "get_incognito"
 	CmdSetIncognito            CMD = "set_incognito"
+	CmdSetConfig               CMD = "set_config"
+	CmdGetConfig               CMD = "get_config"
 	CmdShutdown                CMD = "shutdown"
 	CmdGenerateP2PToken        CMD = "generate_p2p_token"
 	CmdRemoveP2PToken          CMD = "remove_p2p_token"
@@ -85,6 +89,11 @@ const (
 	CmdClearKeychainPassphrase CMD = "clear_keychain_passphrase"
 	CmdGetFingerprintFormat    CMD = "get_fingerprint_format"
 	CmdSetFingerprintFormat    CMD = "set_fingerprint_format"
+	CmdCompactStorage          CMD = "compact_storage"
+	CmdPruneExpired            CMD = "prune_expired"
+	CmdStorageStats            CMD = "storage_stats"
+	CmdSetFilters              CMD = "set_filters"
+	CmdGetFilters              CMD = "get_filters"
 )
 
 // Evt represents events
@@ -102,6 +111,8 @@ const (
 	EvtSessionUpdated    Evt = "session_updated"
 	EvtMessageReceived   Evt = "message_received"
 	EvtMessageSent       Evt = "message_sent"
+	EvtFileProgress      Evt = "file_progress"
+	EvtMessageChunk      Evt = "message_chunk_received"
 	EvtStatusChanged     Evt = "status_changed"
 	EvtFingerprintChange Evt = "fingerprint_changed"
 	EvtVersionWarning    Evt = "version_warning"
@@ -187,6 +198,15 @@ type PeerInfo struct {
 	PublicKey  string    `json:"public_key"` // base64-encoded
 }
 
+// BucketStatsInfo is one top-level storage bucket as returned by
+// storage_stats.
+type BucketStatsInfo struct {
+	Name    string `json:"name"`
+	Keys    int    `json:"keys"`
+	Buckets int    `json:"buckets"`
+	Bytes   int64  `json:"bytes"`
+}
+
 // FingerprintInfo is the public fingerprint shape returned by get_fingerprint.
 type FingerprintInfo struct {
 	Emoji string `json:"emoji"`
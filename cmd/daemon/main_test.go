@@ -366,6 +366,18 @@ func TestDeletePeerParams(t *testing.T) {
 	a.Equal(params.PublicKey, decoded.PublicKey)
 }
 
+func TestPruneExpiredParams(t *testing.T) {
+	a := require.New(t)
+	var decoded PruneExpiredParams
+	a.NoError(json.Unmarshal(
+		[]byte(`{"chat_older_than_ns":3600000000000,"keep_peers":true}`),
+		&decoded,
+	))
+	a.Equal(
+		PruneExpiredParams{ChatOlderThan: time.Hour, KeepPeers: true}, decoded,
+	)
+}
+
 func TestSetMyNameParams(t *testing.T) {
 	a := require.New(t)
 	params := SetMyNameParams{Name: "CrimsonOtter"}
@@ -376,6 +388,76 @@ func TestSetMyNameParams(t *testing.T) {
 	a.Equal(params.Name, decoded.Name)
 }
 
+func TestSetFiltersParams(t *testing.T) {
+	a := require.New(t)
+	var decoded SetFiltersParams
+	a.NoError(json.Unmarshal([]byte(`{"rules":[{"name":"urgent",`+
+		`"keywords":["asap"],"mentions":true,"session_ids":["s1"]}]}`,
+	), &decoded))
+	a.Equal(SetFiltersParams{Rules: []FilterRule{{
+		Name: "urgent", Keywords: []string{"asap"}, Mentions: true,
+		SessionIDs: []string{"s1"},
+	}}}, decoded)
+}
+
+func TestFilterRuleValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  FilterRule
+		valid bool
+	}{
+		{"keywords", FilterRule{Name: "a", Keywords: []string{"x"}}, true},
+		{"mentions", FilterRule{Name: "a", Mentions: true}, true},
+		{"no name", FilterRule{Keywords: []string{"x"}}, false},
+		{"no criteria", FilterRule{Name: "a"}, false},
+		{"empty keyword", FilterRule{Name: "a", Keywords: []string{" "}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := require.New(t)
+			err := tt.rule.validate()
+			if tt.valid {
+				a.NoError(err)
+			} else {
+				a.Error(err)
+			}
+		})
+	}
+}
+
+func TestMatchFilters(t *testing.T) {
+	a := require.New(t)
+	rules := []FilterRule{
+		{Name: "urgent", Keywords: []string{"ASAP", "on fire"}},
+		{Name: "mention", Mentions: true},
+		{Name: "boss", Keywords: []string{"deploy"}, SessionIDs: []string{"s1"}},
+	}
+	tests := []struct {
+		name      string
+		sessionID string
+		text      string
+		want      []string
+	}{
+		{"none", "s2", "hello there", nil},
+		{"keyword ignores case", "s2", "need this asap!", []string{"urgent"}},
+		{"phrase", "s2", "prod is On Fire", []string{"urgent"}},
+		{"not a whole word", "s2", "asaps and deployment", nil},
+		{"mention", "s2", "hi @crimsonotter, ping", []string{"mention"}},
+		{"mention prefix", "s2", "hi @CrimsonOtters", nil},
+		{"other session", "s2", "deploy now", nil},
+		{"session", "s1", "deploy ASAP", []string{"urgent", "boss"}},
+		{"later occurrence", "s2", "asapx then asap", []string{"urgent"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := require.New(t)
+			got := matchFilters(rules, tt.sessionID, tt.text, "CrimsonOtter")
+			a.Equal(tt.want, got)
+		})
+	}
+	a.Empty(matchFilters(rules[1:2], "s1", "@ hi", ""))
+}
+
 func TestHistorySessionInfo(t *testing.T) {
 	a := require.New(t)
 	ts := time.Date(2026, 6, 21, 10, 0, 0, 0, time.UTC)
@@ -549,6 +631,8 @@ func TestCommandConstants(t *testing.T) {
 		"get_status":             CmdGetStatus,
 		"dial":                   CmdDial,
 		"send_message":           CmdSendMessage,
+		"send_file":              CmdSendFile,
+		"send_message_stream":    CmdSendMessageStream,
 		"list_sessions":          CmdListSessions,
 		"close_session":          CmdCloseSession,
 		"rename_session":         CmdRenameSession,
@@ -573,6 +657,13 @@ func TestCommandConstants(t *testing.T) {
 		"get_version":            CmdGetVersion,
 		"get_library_version":    CmdGetLibraryVersion,
 		"shutdown":               CmdShutdown,
+		"set_config":             CmdSetConfig,
+		"get_config":             CmdGetConfig,
+		"compact_storage":        CmdCompactStorage,
+		"prune_expired":          CmdPruneExpired,
+		"storage_stats":          CmdStorageStats,
+		"set_filters":            CmdSetFilters,
+		"get_filters":            CmdGetFilters,
 	}
 
 	for expected, actual := range expectedCommands {
@@ -593,6 +684,8 @@ func TestEventConstants(t *testing.T) {
 		"session_updated":        EvtSessionUpdated,
 		"message_received":       EvtMessageReceived,
 		"message_sent":           EvtMessageSent,
+		"file_progress":          EvtFileProgress,
+		"message_chunk_received": EvtMessageChunk,
 		"status_changed":         EvtStatusChanged,
 		"fingerprint_changed":    EvtFingerprintChange,
 		"version_warning":        EvtVersionWarning,
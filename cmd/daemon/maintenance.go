@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// compactTimeout bounds how long compact_storage waits for the database file
+// once the daemon has closed it.
+const compactTimeout = 5 * time.Second
+
+// handleCompactStorage rewrites the database file to release the space left
+// behind by deleted data. The storage is closed meanwhile, so the server must
+// be stopped and every session closed first.
+func (d *Daemon) handleCompactStorage(cmd Command) {
+	d.mu.RLock()
+	dbPath := d.dbPath
+	busy := d.server != nil || d.relayListeners != nil ||
+		d.p2pListener != nil || len(d.sessions) > 0
+	d.mu.RUnlock()
+
+	if busy {
+		d.emitError(
+			cmd.ID, "stop the server and close all sessions before compacting",
+		)
+		return
+	}
+
+	d.storeMu.Lock()
+	defer d.storeMu.Unlock()
+	if dbPath == "" || d.db == nil {
+		d.emitError(cmd.ID, "storage not opened — call open_storage first")
+		return
+	}
+	err := d.db.Close()
+	if err != nil {
+		d.emitError(cmd.ID, fmt.Sprintf("failed to close storage: %v", err))
+		return
+	}
+	before, after, compactErr := storage.CompactDB(dbPath, compactTimeout)
+	// Reopen the storage whether or not compaction succeeded, since the
+	// original file is left in place if it did not.
+	d.db, err = storage.OpenStorage(d.storeOpts...)
+	if err != nil {
+		d.addLogEntry("ERROR", "Failed to reopen storage: "+err.Error())
+		d.emitError(cmd.ID, fmt.Sprintf("failed to reopen storage: %v", err))
+		return
+	}
+	if compactErr != nil {
+		d.addLogEntry("ERROR", "Failed to compact storage: "+
+			compactErr.Error())
+		d.emitError(cmd.ID, fmt.Sprintf("failed to compact: %v", compactErr))
+		return
+	}
+
+	d.addLogEntry("INFO", fmt.Sprintf(
+		"Compacted storage: %d -> %d bytes", before, after,
+	))
+	d.emit(EvtResponse, cmd.ID, MapA{
+		"before_bytes":    before,
+		"after_bytes":     after,
+		"reclaimed_bytes": before - after,
+	})
+}
+
+// handlePruneExpired deletes expired peers and, if asked to, sessions, chat
+// entries, resumption tokens, and statistics older than the given ages. It
+// returns how many records of each kind were deleted.
+func (d *Daemon) handlePruneExpired(cmd Command) {
+	var params PruneExpiredParams
+	if len(cmd.Params) > 0 {
+		if err := json.Unmarshal(cmd.Params, &params); err != nil {
+			d.emitError(cmd.ID, fmt.Sprintf("invalid params: %v", err))
+			return
+		}
+	}
+
+	store := d.store()
+	if store == nil {
+		d.emitError(cmd.ID, "storage is not available")
+		return
+	}
+
+	now := time.Now()
+	steps := []struct {
+		prune func() (int, error)
+		key   string
+		run   bool
+	}{
+		{store.PruneExpiredPeers, "peers", !params.KeepPeers},
+		{func() (int, error) {
+			return store.PruneSessions(now.Add(-params.SessionsOlderThan))
+		}, "sessions", params.SessionsOlderThan > 0},
+		{func() (int, error) {
+			return store.PruneChatHistory(now.Add(-params.ChatOlderThan))
+		}, "chat_entries", params.ChatOlderThan > 0},
+		{func() (int, error) {
+			before := now.Add(-params.TokensOlderThan)
+			return store.PruneResumptionTokens(before)
+		}, "resumption_tokens", params.TokensOlderThan > 0},
+		{func() (int, error) {
+			return store.PruneSessionStats(now.Add(-params.StatsOlderThan))
+		}, "stats", params.StatsOlderThan > 0},
+	}
+	pruned := make(map[string]int, len(steps))
+	for _, step := range steps {
+		if !step.run {
+			continue
+		}
+		n, err := step.prune()
+		if err != nil {
+			msg := fmt.Sprintf("failed to prune %s: %v", step.key, err)
+			d.addLogEntry("ERROR", msg)
+			d.emitError(cmd.ID, msg)
+			return
+		}
+		pruned[step.key] = n
+	}
+
+	if pruned["sessions"] > 0 || pruned["chat_entries"] > 0 {
+		d.loadHistorySessions()
+	}
+	d.addLogEntry("INFO", fmt.Sprintf("Pruned storage: %v", pruned))
+	d.emit(EvtResponse, cmd.ID, MapA{"pruned": pruned})
+}
+
+// handleStorageStats returns the size of every top-level storage bucket and
+// of the database file.
+func (d *Daemon) handleStorageStats(cmd Command) {
+	store := d.store()
+	if store == nil {
+		d.emitError(cmd.ID, "storage is not available")
+		return
+	}
+
+	stats, err := store.Inspect()
+	if err != nil {
+		d.emitError(cmd.ID, fmt.Sprintf("failed to inspect storage: %v", err))
+		return
+	}
+	buckets := make([]BucketStatsInfo, len(stats))
+	var total int64
+	for i, st := range stats {
+		buckets[i] = BucketStatsInfo{
+			Name:    st.Name,
+			Keys:    st.Keys,
+			Buckets: st.Buckets,
+			Bytes:   st.Bytes,
+		}
+		total += st.Bytes
+	}
+
+	d.mu.RLock()
+	dbPath := d.dbPath
+	d.mu.RUnlock()
+	var fileSize, freeSize int64
+	if info, err := os.Stat(dbPath); err == nil {
+		fileSize = info.Size()
+	}
+	if space, err := store.Space(); err == nil {
+		freeSize = space.FreeBytes
+	}
+
+	d.emit(EvtResponse, cmd.ID, MapA{
+		"buckets":     buckets,
+		"total_bytes": total,
+		"file_bytes":  fileSize,
+		"free_bytes":  freeSize,
+	})
+}
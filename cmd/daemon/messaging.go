@@ -40,9 +40,11 @@ func (d *Daemon) handleSendMessage(cmd Command) {
 		return
 	}
 
-	metadata, err := session.Transport.Send(
-		kamune.Bytes(data), kamune.RouteExchangeMessages,
-	)
+	send := session.Transport.Send
+	if params.Ephemeral {
+		send = session.Transport.SendEphemeral
+	}
+	metadata, err := send(kamune.Bytes(data), kamune.RouteExchangeMessages)
 	if err != nil {
 		d.emitError(cmd.ID, fmt.Sprintf("failed to send message: %v", err))
 		return
@@ -59,7 +61,7 @@ func (d *Daemon) handleSendMessage(cmd Command) {
 	session.LastActivity = time.Now()
 	d.mu.Unlock()
 
-	if store := d.store(); store != nil && !d.incognito {
+	if store := d.store(); store != nil && !d.incognito && !params.Ephemeral {
 		store.AddChatEntry(
 			params.SessionID, data, metadata.Timestamp(), storage.SenderLocal,
 		)
@@ -73,6 +75,73 @@ func (d *Daemon) handleSendMessage(cmd Command) {
 	d.addLogEntry("DEBUG", "Sent message to "+params.SessionID)
 }
 
+// handleSendFile sends a file on an existing session in the background,
+// emitting file_progress as it goes. A transfer cut short is resumed by
+// sending the same file to the same peer again.
+func (d *Daemon) handleSendFile(cmd Command) {
+	var params SendFileParams
+	if err := json.Unmarshal(cmd.Params, &params); err != nil {
+		d.emitError(cmd.ID, fmt.Sprintf("invalid params: %v", err))
+		return
+	}
+
+	d.mu.RLock()
+	session, ok := d.sessions[params.SessionID]
+	d.mu.RUnlock()
+
+	if !ok {
+		d.emitError(
+			cmd.ID, fmt.Sprintf("session not found: %s", params.SessionID),
+		)
+		return
+	}
+	if params.Path == "" {
+		d.emitError(cmd.ID, "path is required")
+		return
+	}
+
+	go func() {
+		// Progress is reported once per percent, so that large files do not
+		// flood the client.
+		lastPercent := -1
+		progress := func(p kamune.TransferProgress, done bool) {
+			percent := 100
+			if p.Size > 0 {
+				percent = int(p.Done * 100 / p.Size)
+			}
+			if percent == lastPercent && !done {
+				return
+			}
+			lastPercent = percent
+			d.emit(EvtFileProgress, cmd.ID, MapA{
+				"session_id":  params.SessionID,
+				"transfer_id": p.ID,
+				"name":        p.Name,
+				"sent":        p.Done,
+				"size":        p.Size,
+				"done":        done,
+			})
+		}
+
+		var last kamune.TransferProgress
+		file := kamune.FileTransfer{
+			Name: params.Name,
+			Type: params.Type,
+			Progress: func(p kamune.TransferProgress) {
+				last = p
+				progress(p, false)
+			},
+		}
+		err := session.Transport.SendFile(d.ctx, params.Path, file)
+		if err != nil {
+			d.emitError(cmd.ID, fmt.Sprintf("failed to send file: %v", err))
+			return
+		}
+		progress(last, true)
+		d.addLogEntry("DEBUG", "Sent file to "+params.SessionID)
+	}()
+}
+
 // receiveMessages is the wrapper for client-side (dialed) sessions. It
 // closes session.ReceiveDone when the receive loop exits and cleans up the
 // session from the map. On involuntary disconnect (ErrConnClosed) it attempts
@@ -90,7 +159,7 @@ func (d *Daemon) receiveMessages(session *liveSession) {
 				d.addLogEntry("INFO", "Peer disconnected: "+session.ID)
 			case errors.Is(err, kamune.ErrConnClosed):
 				d.addLogEntry("INFO", "Connection closed: "+session.ID)
-				if session.reconnectFn != nil &&
+				if session.reconnectFn != nil && d.reconnectAllowed() &&
 					d.reconnectSession(session) {
 					continue
 				}
@@ -133,17 +202,16 @@ func (d *Daemon) receiveMessages(session *liveSession) {
 		session.LastActivity = time.Now()
 		d.mu.Unlock()
 
-		if store := d.store(); store != nil && !d.incognito {
+		if store := d.store(); store != nil && !d.incognito &&
+			!metadata.Ephemeral() {
 			store.AddChatEntry(
 				session.ID, b.GetValue(), metadata.Timestamp(), storage.SenderPeer,
 			)
 		}
 
-		d.emit(EvtMessageReceived, "", MapA{
-			"session_id":  session.ID,
-			"data_base64": base64.StdEncoding.EncodeToString(b.GetValue()),
-			"timestamp":   metadata.Timestamp().Format(time.RFC3339Nano),
-		})
+		d.emit(EvtMessageReceived, "", d.messageEvent(
+			session.ID, b.GetValue(), metadata,
+		))
 		d.emit(EvtSessionUpdated, "", MapS{"session_id": session.ID})
 		d.addLogEntry("DEBUG", "Received message from "+session.ID)
 	}
@@ -206,17 +274,16 @@ func (d *Daemon) receiveMessagesBlocking(session *liveSession) {
 		session.LastActivity = time.Now()
 		d.mu.Unlock()
 
-		if store := d.store(); store != nil && !d.incognito {
+		if store := d.store(); store != nil && !d.incognito &&
+			!metadata.Ephemeral() {
 			store.AddChatEntry(
 				session.ID, b.GetValue(), metadata.Timestamp(), storage.SenderPeer,
 			)
 		}
 
-		d.emit(EvtMessageReceived, "", MapA{
-			"session_id":  session.ID,
-			"data_base64": base64.StdEncoding.EncodeToString(b.GetValue()),
-			"timestamp":   metadata.Timestamp().Format(time.RFC3339Nano),
-		})
+		d.emit(EvtMessageReceived, "", d.messageEvent(
+			session.ID, b.GetValue(), metadata,
+		))
 		d.emit(EvtSessionUpdated, "", MapS{"session_id": session.ID})
 		d.addLogEntry("DEBUG", "Received message from "+session.ID)
 	}
@@ -314,6 +381,7 @@ func (d *Daemon) reconnectSession(session *liveSession) bool {
 		close(session.keepAliveDone)
 		session.keepAliveDone = make(chan struct{})
 		d.mu.Unlock()
+		d.acceptStreams(session)
 
 		d.addLogEntry("INFO", "Reconnected session "+session.ID)
 		go d.keepAliveLoop(session)
@@ -561,13 +561,11 @@ func (d *Daemon) handleDial(cmd Command) {
 			pongCh:           make(chan []byte, 1),
 			keepAliveDone:    make(chan struct{}),
 		}
+		d.acceptStreams(session)
 
 		var store *storage.Storage
 		if s := d.store(); s != nil && !d.incognito {
 			store = s
-			if err := store.CreateSession(sessionID, peer.PublicKey); err != nil {
-				d.addLogEntry("WARN", "Failed to create session record: "+err.Error())
-			}
 			d.deriveAndStoreRelayTokens(t, sessionID)
 		}
 
@@ -631,13 +629,11 @@ func (d *Daemon) serverHandler(t *kamune.Transport) error {
 		pongCh:           make(chan []byte, 1),
 		keepAliveDone:    make(chan struct{}),
 	}
+	d.acceptStreams(session)
 
 	var store *storage.Storage
 	if s := d.store(); s != nil && !d.incognito {
 		store = s
-		if err := store.CreateSession(sessionID, peer.PublicKey); err != nil {
-			d.addLogEntry("WARN", "Failed to create session record: "+err.Error())
-		}
 		d.deriveAndStoreRelayTokens(t, sessionID)
 	}
 
@@ -50,6 +50,29 @@ type DialParams struct {
 type SendMessageParams struct {
 	SessionID  string `json:"session_id"`
 	DataBase64 string `json:"data_base64"`
+	// Ephemeral asks the peer not to persist the message, and keeps it out
+	// of the local chat history.
+	Ephemeral bool `json:"ephemeral,omitempty"`
+}
+
+// SendFileParams contains parameters for sending a file
+type SendFileParams struct {
+	SessionID string `json:"session_id"`
+	Path      string `json:"path"`
+	// Name is offered as the file's name, or its base name if empty.
+	Name string `json:"name,omitempty"`
+	Type string `json:"type,omitempty"`
+}
+
+// SendMessageStreamParams contains parameters for one operation of a
+// streamed message
+type SendMessageStreamParams struct {
+	SessionID  string `json:"session_id,omitempty"`
+	StreamID   string `json:"stream_id"`
+	Op         string `json:"op"` // "begin", "chunk", "end", "abort"
+	DataBase64 string `json:"data_base64,omitempty"`
+	// Size is the total size of the message, given when it is begun.
+	Size uint64 `json:"size,omitempty"`
 }
 
 // CloseSessionParams contains parameters for closing a session
@@ -161,3 +184,37 @@ type SetMyNameParams struct {
 type SetIncognitoParams struct {
 	Enabled bool `json:"enabled"`
 }
+
+// SetConfigParams carries a configuration update and the admin key's
+// signature of it. The update is sent as signed, so that the daemon checks
+// the signature over the exact bytes the admin signed.
+type SetConfigParams struct {
+	UpdateBase64    string `json:"update_base64"`
+	SignatureBase64 string `json:"signature_base64"`
+}
+
+// ConfigUpdate is the signed document of set_config. Settings left out are
+// kept as they are.
+type ConfigUpdate struct {
+	Version          uint64  `json:"version"`
+	VerificationMode *int    `json:"verification_mode,omitempty"`
+	Incognito        *bool   `json:"incognito,omitempty"`
+	LogLevel         *string `json:"log_level,omitempty"`
+	Reconnect        *bool   `json:"reconnect,omitempty"`
+}
+
+// PruneExpiredParams selects what prune_expired deletes besides expired peers.
+// A zero age keeps the corresponding records.
+type PruneExpiredParams struct {
+	SessionsOlderThan time.Duration `json:"sessions_older_than_ns"`
+	ChatOlderThan     time.Duration `json:"chat_older_than_ns"`
+	TokensOlderThan   time.Duration `json:"tokens_older_than_ns"`
+	StatsOlderThan    time.Duration `json:"stats_older_than_ns"`
+	KeepPeers         bool          `json:"keep_peers"`
+}
+
+// SetFiltersParams replaces the priority filters that message_received events
+// are tagged with. An empty list removes every filter.
+type SetFiltersParams struct {
+	Rules []FilterRule `json:"rules"`
+}
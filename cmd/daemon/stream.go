@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/kamune-org/kamune"
+)
+
+// streamType is the transfer type that marks a transfer as a message sent
+// with send_message_stream, as opposed to a file.
+const streamType = "application/vnd.kamune.message-stream"
+
+// streamQueueSize is the number of chunks of an outgoing stream held while
+// the peer has yet to take them.
+const streamQueueSize = 16
+
+// Operations of send_message_stream.
+const (
+	streamBegin = "begin"
+	streamChunk = "chunk"
+	streamEnd   = "end"
+	streamAbort = "abort"
+)
+
+// outgoingStream is a message being sent with send_message_stream. Its
+// chunks are queued and written to the transfer by a goroutine of its own,
+// so that a slow peer does not hold up the commands on stdin.
+type outgoingStream struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	chunks    chan []byte
+	done      chan error
+	sessionID string
+	size      uint64
+	queued    uint64
+}
+
+// streamWriter emits the data of an incoming stream as
+// message_chunk_received events as it is written.
+type streamWriter struct {
+	d         *Daemon
+	sessionID string
+	offer     kamune.TransferOffer
+	offset    uint64
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.d.emit(EvtMessageChunk, "", w.event(p))
+	w.offset += uint64(len(p))
+	return len(p), nil
+}
+
+// event returns the message_chunk_received event of data, which starts at
+// the writer's offset.
+func (w *streamWriter) event(data []byte) MapA {
+	return MapA{
+		"session_id":  w.sessionID,
+		"stream_id":   w.offer.ID,
+		"offset":      w.offset,
+		"size":        w.offer.Size,
+		"data_base64": base64.StdEncoding.EncodeToString(data),
+		"final":       false,
+	}
+}
+
+// handleSendMessageStream sends a message too large for send_message in
+// chunks, each small enough for a line of the protocol. A stream is begun
+// with its total size, fed with chunks in order, and ended; it is sent to the
+// peer as a transfer, which the peer's daemon emits as
+// message_chunk_received events.
+func (d *Daemon) handleSendMessageStream(cmd Command) {
+	var params SendMessageStreamParams
+	if err := json.Unmarshal(cmd.Params, &params); err != nil {
+		d.emitError(cmd.ID, fmt.Sprintf("invalid params: %v", err))
+		return
+	}
+	if params.StreamID == "" {
+		d.emitError(cmd.ID, "stream_id is required")
+		return
+	}
+
+	switch params.Op {
+	case streamBegin:
+		d.beginStream(cmd, params)
+	case streamChunk:
+		d.queueStreamChunk(cmd, params)
+	case streamEnd:
+		d.endStream(cmd, params)
+	case streamAbort:
+		s := d.takeStream(params.StreamID)
+		if s == nil {
+			d.emitError(cmd.ID, "stream not found: "+params.StreamID)
+			return
+		}
+		s.cancel()
+		d.emit(EvtResponse, cmd.ID, MapS{
+			"status": "aborted", "stream_id": params.StreamID,
+		})
+	default:
+		d.emitError(cmd.ID, fmt.Sprintf("unknown stream op: %s", params.Op))
+	}
+}
+
+// beginStream offers the peer a stream of params.Size bytes and starts
+// feeding it the chunks queued for it.
+func (d *Daemon) beginStream(cmd Command, params SendMessageStreamParams) {
+	d.mu.RLock()
+	session, ok := d.sessions[params.SessionID]
+	d.mu.RUnlock()
+
+	if !ok {
+		d.emitError(
+			cmd.ID, fmt.Sprintf("session not found: %s", params.SessionID),
+		)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(d.ctx)
+	s := &outgoingStream{
+		ctx:       ctx,
+		cancel:    cancel,
+		chunks:    make(chan []byte, streamQueueSize),
+		done:      make(chan error, 1),
+		sessionID: params.SessionID,
+		size:      params.Size,
+	}
+	d.streamsMu.Lock()
+	if _, exists := d.streams[params.StreamID]; exists {
+		d.streamsMu.Unlock()
+		cancel()
+		d.emitError(cmd.ID, "stream already exists: "+params.StreamID)
+		return
+	}
+	d.streams[params.StreamID] = s
+	d.streamsMu.Unlock()
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer pw.Close()
+		for {
+			select {
+			case data, ok := <-s.chunks:
+				if !ok {
+					return
+				}
+				if _, err := pw.Write(data); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				_ = pw.CloseWithError(ctx.Err())
+				return
+			}
+		}
+	}()
+	go func() {
+		offer := kamune.TransferOffer{Type: streamType, Size: params.Size}
+		err := session.Transport.Transfer(ctx, offer, pr)
+		_ = pr.CloseWithError(err)
+		if err != nil {
+			// A stream that failed is forgotten at once, as nothing more
+			// can be sent on it.
+			d.streamsMu.Lock()
+			if d.streams[params.StreamID] == s {
+				delete(d.streams, params.StreamID)
+			}
+			d.streamsMu.Unlock()
+			d.emitError(cmd.ID, fmt.Sprintf("failed to send stream: %v", err))
+		}
+		s.done <- err
+		cancel()
+	}()
+
+	d.emit(EvtResponse, cmd.ID, MapS{
+		"status": "begun", "stream_id": params.StreamID,
+	})
+}
+
+// queueStreamChunk queues a chunk of an outgoing stream, waiting at most
+// channelTimeout for the peer to make room for it.
+func (d *Daemon) queueStreamChunk(cmd Command, params SendMessageStreamParams) {
+	d.streamsMu.Lock()
+	s, ok := d.streams[params.StreamID]
+	d.streamsMu.Unlock()
+	if !ok {
+		d.emitError(cmd.ID, "stream not found: "+params.StreamID)
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(params.DataBase64)
+	if err != nil {
+		d.emitError(cmd.ID, fmt.Sprintf("invalid base64 data: %v", err))
+		return
+	}
+	if s.queued+uint64(len(data)) > s.size {
+		d.emitError(cmd.ID, fmt.Sprintf(
+			"chunk exceeds stream size: %d bytes left", s.size-s.queued,
+		))
+		return
+	}
+
+	select {
+	case s.chunks <- data:
+	case <-s.ctx.Done():
+		d.emitError(cmd.ID, "stream has ended: "+params.StreamID)
+		return
+	case <-time.After(channelTimeout):
+		d.emitError(cmd.ID, "stream is stalled, retry the chunk later")
+		return
+	}
+	s.queued += uint64(len(data))
+	d.emit(EvtResponse, cmd.ID, MapA{
+		"status": "queued", "stream_id": params.StreamID, "queued": s.queued,
+	})
+}
+
+// endStream ends an outgoing stream once all of it is queued, and emits
+// message_sent when the peer has received all of it.
+func (d *Daemon) endStream(cmd Command, params SendMessageStreamParams) {
+	s := d.takeStream(params.StreamID)
+	if s == nil {
+		d.emitError(cmd.ID, "stream not found: "+params.StreamID)
+		return
+	}
+	if s.queued != s.size {
+		s.cancel()
+		d.emitError(cmd.ID, fmt.Sprintf(
+			"stream ended early: %d of %d bytes sent", s.queued, s.size,
+		))
+		return
+	}
+	close(s.chunks)
+
+	go func() {
+		if err := <-s.done; err != nil {
+			// The failure was already reported to the begin command.
+			return
+		}
+		d.emit(EvtMessageSent, cmd.ID, MapA{
+			"session_id": s.sessionID,
+			"stream_id":  params.StreamID,
+			"size":       s.size,
+			"timestamp":  time.Now().Format(time.RFC3339Nano),
+		})
+		d.emit(EvtSessionUpdated, "", MapS{"session_id": s.sessionID})
+		d.addLogEntry("DEBUG", "Sent message stream to "+s.sessionID)
+	}()
+}
+
+// takeStream removes the outgoing stream with the given ID and returns it,
+// or nil if there is none.
+func (d *Daemon) takeStream(id string) *outgoingStream {
+	d.streamsMu.Lock()
+	defer d.streamsMu.Unlock()
+	s := d.streams[id]
+	delete(d.streams, id)
+	return s
+}
+
+// acceptStreams makes the session accept the message streams the peer
+// sends, emitting their data as message_chunk_received events. Streamed
+// messages are not kept in the chat history. Other transfers are rejected.
+// It is called again whenever the session's transport is replaced.
+func (d *Daemon) acceptStreams(session *liveSession) {
+	session.Transport.HandleTransfers(func(in *kamune.IncomingTransfer) {
+		offer := in.Offer()
+		if offer.Type != streamType {
+			_ = in.Reject("only message streams are accepted")
+			return
+		}
+
+		w := &streamWriter{d: d, sessionID: session.ID, offer: offer}
+		err := in.Accept(w)
+		final := w.event(nil)
+		final["final"] = true
+		if err != nil {
+			final["error"] = err.Error()
+			d.addLogEntry("WARN", "Message stream failed: "+err.Error())
+		}
+		d.emit(EvtMessageChunk, "", final)
+		if err != nil {
+			return
+		}
+
+		d.mu.Lock()
+		session.LastActivity = time.Now()
+		d.mu.Unlock()
+		d.emit(EvtSessionUpdated, "", MapS{"session_id": session.ID})
+		d.addLogEntry("DEBUG", "Received message stream from "+session.ID)
+	})
+}
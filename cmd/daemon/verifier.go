@@ -224,6 +224,9 @@ func (d *Daemon) handleVerifyResponse(cmd Command) {
 // restarts the server if running (to apply the new mode to incoming
 // connections).
 func (d *Daemon) handleSetVerificationMode(cmd Command) {
+	if d.rejectManaged(cmd) {
+		return
+	}
 	var params SetVerificationModeParams
 	if err := json.Unmarshal(cmd.Params, &params); err != nil {
 		d.emitError(cmd.ID, fmt.Sprintf("invalid params: %v", err))
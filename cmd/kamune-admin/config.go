@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/kamune-org/kamune/pkg/attest"
+)
+
+// configSigningContext separates the signatures of daemon configuration
+// updates from every other signature made with the same key. It must match
+// the one the daemon checks (cmd/daemon/config.go).
+const configSigningContext = "kamune-daemon-config\x00"
+
+const configUsage = `usage: kamune-admin config <subcommand> [flags]
+
+Subcommands:
+  sign   sign a configuration update for a daemon's set_config command
+  pin    pin the admin key whose updates a daemon accepts
+  unpin  remove the pinned admin key
+  show   print the pinned admin key and the last applied version`
+
+// runConfig signs configuration updates for daemons managed remotely, and
+// pins the admin key those daemons accept them from.
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		return errors.New(configUsage)
+	}
+	switch args[0] {
+	case "sign":
+		return runConfigSign(args[1:])
+	case "pin":
+		return runConfigPin(args[1:])
+	case "unpin":
+		return runConfigUnpin(args[1:])
+	case "show":
+		return runConfigShow(args[1:])
+	default:
+		return fmt.Errorf(
+			"unknown subcommand %q\n\n%s", args[0], configUsage,
+		)
+	}
+}
+
+func runConfigSign(args []string) error {
+	fs, f := newFlagSet("config sign")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: kamune-admin config sign [flags] <update>")
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var update struct {
+		Version uint64 `json:"version"`
+	}
+	if err := json.Unmarshal(data, &update); err != nil {
+		return fmt.Errorf("parsing update: %w", err)
+	}
+	if update.Version == 0 {
+		return errors.New("update needs a version greater than zero")
+	}
+
+	pass, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	store, err := openStorage(f, pass)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	at, err := store.Attester()
+	if err != nil {
+		return err
+	}
+
+	sig, err := at.Sign(append([]byte(configSigningContext), data...))
+	if err != nil {
+		return err
+	}
+	params, err := json.Marshal(map[string]string{
+		"update_base64":    base64.StdEncoding.EncodeToString(data),
+		"signature_base64": base64.StdEncoding.EncodeToString(sig),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(params))
+	fmt.Fprintf(os.Stderr, "signed version %d with %s\n",
+		update.Version, at.EncodePublicKey())
+	return nil
+}
+
+func runConfigPin(args []string) error {
+	fs, f := newFlagSet("config pin")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: kamune-admin config pin [flags] <key>")
+	}
+	key, err := base64.RawURLEncoding.DecodeString(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("decoding key: %w", err)
+	}
+	if !attest.IsValidPublicKey(key) {
+		return errors.New("not a valid public key")
+	}
+
+	pass, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	store, err := openStorage(f, pass)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	err = store.SetSettings("daemon", "admin_key", fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Println("admin key pinned; the daemon now only accepts signed updates")
+	return nil
+}
+
+func runConfigUnpin(args []string) error {
+	fs, f := newFlagSet("config unpin")
+	_ = fs.Parse(args)
+
+	pass, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	store, err := openStorage(f, pass)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := store.SetSettings("daemon", "admin_key", ""); err != nil {
+		return err
+	}
+	fmt.Println("admin key removed")
+	return nil
+}
+
+func runConfigShow(args []string) error {
+	fs, f := newFlagSet("config show")
+	_ = fs.Parse(args)
+
+	pass, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	store, err := openStorage(f, pass)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	key, err := store.GetSettings("daemon", "admin_key")
+	if err != nil {
+		return err
+	}
+	version, err := store.GetSettings("daemon", "config_version")
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		key = "none"
+	}
+	if version == "" {
+		version = "none"
+	}
+	fmt.Printf("admin key: %s\n", key)
+	fmt.Printf("last applied version: %s\n", version)
+	return nil
+}
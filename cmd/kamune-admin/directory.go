@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/kamune-org/kamune"
+)
+
+// runSign signs a list of servers with the database's identity, producing a
+// kamune-known-hosts file for [kamune.DialWithDirectory].
+func runSign(args []string) error {
+	fs, f := newFlagSet("sign")
+	out := fs.String("o", "kamune-known-hosts",
+		"`path` of the signed directory")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: kamune-admin sign [flags] <server list>")
+	}
+
+	list, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	entries, err := kamune.ParseDirectoryEntries(list)
+	if err != nil {
+		return err
+	}
+
+	pass, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	store, err := openStorage(f, pass)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	at, err := store.Attester()
+	if err != nil {
+		return err
+	}
+
+	data, err := kamune.SignDirectory(at, entries)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("signed %d servers into %s\n", len(entries), *out)
+	fmt.Printf("signer: %s\n", at.EncodePublicKey())
+	return nil
+}
+
+// runVerify checks the signature of a kamune-known-hosts file and lists the
+// servers in it.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	var trusted [][]byte
+	fs.Func("signer", "trusted signer `key` in base64url (repeatable)",
+		func(s string) error {
+			key, err := base64.RawURLEncoding.DecodeString(s)
+			if err != nil {
+				return fmt.Errorf("decoding signer: %w", err)
+			}
+			trusted = append(trusted, key)
+			return nil
+		})
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || len(trusted) == 0 {
+		return errors.New(
+			"usage: kamune-admin verify -signer <key> <directory>",
+		)
+	}
+
+	dir, err := kamune.LoadDirectory(fs.Arg(0), trusted...)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tKEY\tNAME\t")
+	for _, e := range dir.Entries() {
+		fmt.Fprintf(w, "%s\t%s\t%s\t\n", e.Address,
+			base64.RawURLEncoding.EncodeToString(e.PublicKey), e.Name)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kamune-org/kamune"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+const historyUsage = `usage: kamune-admin history <subcommand> [flags]
+
+Subcommands:
+  export  write a session's chat history to a transcript file
+  import  add the entries of a transcript to the database
+  verify  report which entries of a transcript are authenticated`
+
+// runHistory exports, imports and verifies chat transcripts.
+func runHistory(args []string) error {
+	if len(args) == 0 {
+		return errors.New(historyUsage)
+	}
+	switch args[0] {
+	case "export":
+		return runHistoryExport(args[1:])
+	case "import":
+		return runHistoryImport(args[1:])
+	case "verify":
+		return runHistoryVerify(args[1:])
+	default:
+		return fmt.Errorf("unknown subcommand %q\n\n%s", args[0], historyUsage)
+	}
+}
+
+// runHistoryExport writes the chat history of a session as a JSON transcript.
+func runHistoryExport(args []string) error {
+	fs, f := newFlagSet("history export")
+	session := fs.String("session", "", "`id` of the session to export")
+	signatures := fs.Bool("signatures", false,
+		"keep the messages' signatures, so that they can be verified")
+	out := fs.String("o", "", "`path` of the transcript (default stdout)")
+	_ = fs.Parse(args)
+	if *session == "" {
+		return errors.New(
+			"usage: kamune-admin history export -session <id> [flags]",
+		)
+	}
+
+	pass, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	store, err := openStorage(f, pass)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	tr, err := store.ExportChatHistory(*session, *signatures)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(tr, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if *out == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0o600); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d entries to %s\n",
+		len(tr.Entries), *out)
+	return nil
+}
+
+// runHistoryImport adds the entries of a transcript to the database.
+func runHistoryImport(args []string) error {
+	fs, f := newFlagSet("history import")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New(
+			"usage: kamune-admin history import [flags] <transcript>",
+		)
+	}
+	tr, err := readTranscript(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	pass, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	store, err := openStorage(f, pass)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	added, err := store.ImportChatHistory(tr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d of %d entries into session %s\n",
+		added, len(tr.Entries), tr.SessionID)
+	return nil
+}
+
+// runHistoryVerify checks the signature of every entry of a transcript
+// against the keys it names, and fails if any does not match.
+func runHistoryVerify(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: kamune-admin history verify <transcript>")
+	}
+	tr, err := readTranscript(args[0])
+	if err != nil {
+		return err
+	}
+
+	encode := base64.RawURLEncoding.EncodeToString
+	fmt.Printf("session: %s\n", tr.SessionID)
+	fmt.Printf("local:   %s\n", encode(tr.LocalKey))
+	fmt.Printf("peer:    %s\n\n", encode(tr.PeerKey))
+
+	var authenticated, unsigned, failed int
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tTIME\tSENDER\tSTATUS\t")
+	for i, err := range kamune.VerifyTranscript(tr) {
+		e := tr.Entries[i]
+		status := "authenticated"
+		switch {
+		case err == nil:
+			authenticated++
+		case e.Signature == nil:
+			status = "unsigned"
+			unsigned++
+		default:
+			status = "FAILED: " + err.Error()
+			failed++
+		}
+		sender := "peer"
+		if e.Sender == storage.SenderLocal {
+			sender = "local"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t\n",
+			i+1, e.Timestamp.Format(time.DateTime), sender, status)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\n%d authenticated, %d unsigned, %d failed\n",
+		authenticated, unsigned, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d entries failed verification", failed)
+	}
+	return nil
+}
+
+func readTranscript(path string) (*storage.Transcript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tr storage.Transcript
+	if err := json.Unmarshal(data, &tr); err != nil {
+		return nil, fmt.Errorf("parsing transcript: %w", err)
+	}
+	return &tr, nil
+}
@@ -0,0 +1,274 @@
+// Command kamune-admin performs offline maintenance on a kamune database:
+// compacting the file, pruning expired records, printing per-bucket
+// statistics, and rotating the encryption keys. It also signs and checks
+// directories of servers with the database's identity, exports and restores
+// the identity as a recovery phrase, exports, imports and verifies chat
+// transcripts, lists and clears the offenses counted against peers, and
+// signs the configuration updates of remotely managed daemons. The
+// application that owns the database must be stopped first.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+const usage = `Usage: kamune-admin <command> [flags]
+
+Commands:
+  stats    print the number of keys and bytes per bucket
+  compact  rewrite the database to release unused space
+  prune    delete expired peers, sessions, chat entries and tokens
+  rotate   change the passphrase or re-encrypt with a new data key
+  sign     sign a list of servers into a kamune-known-hosts directory
+  verify   check a directory's signature and list its servers
+  paperkey print the identity's recovery phrase and back up its peers
+  restore  recreate a database from a recovery phrase and peer backup
+  history  export, import or verify the chat transcript of a session
+  offenses list or clear the offenses servers counted against peers
+  config   sign daemon configuration updates and pin the admin key
+
+The database must not be in use. Run "kamune-admin <command> -h" for the
+flags of a command.
+`
+
+var commands = map[string]func(args []string) error{
+	"stats":    runStats,
+	"compact":  runCompact,
+	"prune":    runPrune,
+	"rotate":   runRotate,
+	"sign":     runSign,
+	"verify":   runVerify,
+	"paperkey": runPaperKey,
+	"restore":  runRestore,
+	"history":  runHistory,
+	"offenses": runOffenses,
+	"config":   runConfig,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err := run(os.Args[2:]); err != nil {
+		if errors.Is(err, storage.ErrStoreInUse) {
+			err = fmt.Errorf("%w; stop the application using it first", err)
+		}
+		slog.Error(os.Args[1], "error", err)
+		os.Exit(1)
+	}
+}
+
+// dbFlags holds the flags shared by every command.
+type dbFlags struct {
+	path    string
+	timeout time.Duration
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *dbFlags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var f dbFlags
+	fs.StringVar(&f.path, "db", defaultDBPath(),
+		"`path` of the database (default from KAMUNE_DB_PATH)")
+	fs.DurationVar(&f.timeout, "timeout", time.Second,
+		"how long to wait for the database lock")
+	return fs, &f
+}
+
+func defaultDBPath() string {
+	if p := os.Getenv("KAMUNE_DB_PATH"); p != "" {
+		return p
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "kamune", "db")
+	}
+	return "./kamune.db"
+}
+
+// readPassphrase returns KAMUNE_DB_PASSPHRASE if it is set, and prompts for
+// the passphrase otherwise.
+func readPassphrase(prompt string) ([]byte, error) {
+	if pass := os.Getenv("KAMUNE_DB_PASSPHRASE"); pass != "" {
+		return []byte(pass), nil
+	}
+	return promptPassphrase(prompt)
+}
+
+func promptPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	pass, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("reading passphrase: %w", err)
+	}
+	return pass, nil
+}
+
+func openStorage(f *dbFlags, pass []byte) (*storage.Storage, error) {
+	return storage.OpenStorage(
+		storage.WithDBPath(f.path),
+		storage.WithCreateDB(false),
+		storage.WithTimeout(f.timeout),
+		storage.WithPassphraseHandler(func() ([]byte, error) {
+			return pass, nil
+		}),
+	)
+}
+
+func runStats(args []string) error {
+	fs, f := newFlagSet("stats")
+	_ = fs.Parse(args)
+
+	stats, err := storage.InspectDB(f.path, f.timeout)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BUCKET\tKEYS\tBUCKETS\tBYTES\t")
+	var total storage.BucketStats
+	for _, st := range stats {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t\n",
+			st.Name, st.Keys, st.Buckets, st.Bytes)
+		total.Keys += st.Keys
+		total.Buckets += st.Buckets
+		total.Bytes += st.Bytes
+	}
+	fmt.Fprintf(w, "total\t%d\t%d\t%d\t\n",
+		total.Keys, total.Buckets, total.Bytes)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\nfile size: %d bytes\n", info.Size())
+	return nil
+}
+
+func runCompact(args []string) error {
+	fs, f := newFlagSet("compact")
+	_ = fs.Parse(args)
+
+	before, after, err := storage.CompactDB(f.path, f.timeout)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("compacted %s: %d -> %d bytes\n", f.path, before, after)
+	return nil
+}
+
+func runPrune(args []string) error {
+	fs, f := newFlagSet("prune")
+	peers := fs.Bool("peers", true, "delete expired peers")
+	tokens := fs.Duration("tokens-older-than", 24*time.Hour,
+		"delete resumption tokens of sessions established before this `age`")
+	sessions := fs.Duration("sessions-older-than", 0,
+		"delete sessions without activity for this `age` (0 keeps them)")
+	chat := fs.Duration("chat-older-than", 0,
+		"delete chat entries older than this `age` (0 keeps them)")
+	stats := fs.Duration("stats-older-than", 0,
+		"delete session statistics older than this `age` (0 keeps them)")
+	_ = fs.Parse(args)
+
+	pass, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	store, err := openStorage(f, pass)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	now := time.Now()
+	steps := []struct {
+		prune func() (int, error)
+		what  string
+		run   bool
+	}{
+		{store.PruneExpiredPeers, "expired peers", *peers},
+		{func() (int, error) {
+			return store.PruneSessions(now.Add(-*sessions))
+		}, "sessions", *sessions > 0},
+		{func() (int, error) {
+			return store.PruneChatHistory(now.Add(-*chat))
+		}, "chat entries", *chat > 0},
+		{func() (int, error) {
+			return store.PruneResumptionTokens(now.Add(-*tokens))
+		}, "sessions' resumption tokens", *tokens > 0},
+		{func() (int, error) {
+			return store.PruneSessionStats(now.Add(-*stats))
+		}, "statistics records", *stats > 0},
+	}
+	for _, step := range steps {
+		if !step.run {
+			continue
+		}
+		n, err := step.prune()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("pruned %d %s\n", n, step.what)
+	}
+	return nil
+}
+
+func runRotate(args []string) error {
+	fs, f := newFlagSet("rotate")
+	dataKey := fs.Bool("data-key", false,
+		"generate a new data key and re-encrypt every stored value")
+	_ = fs.Parse(args)
+
+	old, err := readPassphrase("Current passphrase: ")
+	if err != nil {
+		return err
+	}
+	store, err := openStorage(f, old)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	pass, err := promptPassphrase("New passphrase: ")
+	if err != nil {
+		return err
+	}
+	confirm, err := promptPassphrase("Repeat new passphrase: ")
+	if err != nil {
+		return err
+	}
+	if string(pass) != string(confirm) {
+		return errors.New("passphrases do not match")
+	}
+
+	if *dataKey {
+		if err := store.RotateDataKey(old, pass); err != nil {
+			return err
+		}
+		fmt.Println("data key rotated and all values re-encrypted")
+		return nil
+	}
+	if err := store.RotatePassphrase(old, pass); err != nil {
+		return err
+	}
+	fmt.Println("passphrase changed")
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+)
+
+const offensesUsage = `usage: kamune-admin offenses <subcommand> [flags]
+
+Subcommands:
+  list   print the offenses on record for each peer
+  clear  forget the offenses of a peer, or of every peer with -all`
+
+// runOffenses lists and clears the offenses servers counted against peers.
+func runOffenses(args []string) error {
+	if len(args) == 0 {
+		return errors.New(offensesUsage)
+	}
+	switch args[0] {
+	case "list":
+		return runOffensesList(args[1:])
+	case "clear":
+		return runOffensesClear(args[1:])
+	default:
+		return fmt.Errorf(
+			"unknown subcommand %q\n\n%s", args[0], offensesUsage,
+		)
+	}
+}
+
+func runOffensesList(args []string) error {
+	fs, f := newFlagSet("offenses list")
+	_ = fs.Parse(args)
+
+	pass, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	store, err := openStorage(f, pass)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	list, err := store.ListReputations()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FINGERPRINT\tHANDSHAKE\tABUSE\tFIRST\tLAST\t")
+	for _, r := range list {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t\n",
+			fingerprint.Sum(r.PublicKey), r.HandshakeFailures, r.Abuses,
+			r.First.Format(time.DateTime), r.Last.Format(time.DateTime))
+	}
+	return w.Flush()
+}
+
+func runOffensesClear(args []string) error {
+	fs, f := newFlagSet("offenses clear")
+	all := fs.Bool("all", false, "clear the offenses of every peer")
+	_ = fs.Parse(args)
+	if *all == (fs.NArg() == 1) || fs.NArg() > 1 {
+		return errors.New(
+			"usage: kamune-admin offenses clear [flags] <fingerprint>|-all",
+		)
+	}
+
+	pass, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	store, err := openStorage(f, pass)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	list, err := store.ListReputations()
+	if err != nil {
+		return err
+	}
+	var cleared int
+	for _, r := range list {
+		if !*all && fingerprint.Sum(r.PublicKey) != fs.Arg(0) {
+			continue
+		}
+		if err := store.ClearReputation(r.PublicKey); err != nil {
+			return err
+		}
+		cleared++
+	}
+	if !*all && cleared == 0 {
+		return fmt.Errorf("no offenses on record for %s", fs.Arg(0))
+	}
+	fmt.Printf("cleared the offenses of %d peers\n", cleared)
+	return nil
+}
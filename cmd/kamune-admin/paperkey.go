@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// runPaperKey prints the recovery phrase of the database's identity and
+// writes the encrypted backup of its peers next to it.
+func runPaperKey(args []string) error {
+	fs, f := newFlagSet("paperkey")
+	out := fs.String("o", "kamune-paper-backup",
+		"`path` of the encrypted peer backup")
+	_ = fs.Parse(args)
+
+	pass, err := readPassphrase("Passphrase: ")
+	if err != nil {
+		return err
+	}
+	store, err := openStorage(f, pass)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	key, err := store.ExportPaperKey()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, key.Backup, 0o600); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr,
+		"Write these words down and keep them safe; anyone who has them "+
+			"can act as you.")
+	fmt.Println(key.Phrase)
+	fmt.Fprintf(os.Stderr, "peer backup written to %s\n", *out)
+	return nil
+}
+
+// runRestore creates a database holding the identity of a recovery phrase,
+// and the peers of its backup if one is given.
+func runRestore(args []string) error {
+	fs, f := newFlagSet("restore")
+	backupPath := fs.String("backup", "",
+		"`path` of the peer backup written by paperkey (optional)")
+	_ = fs.Parse(args)
+
+	var backup []byte
+	if *backupPath != "" {
+		var err error
+		if backup, err = os.ReadFile(*backupPath); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(f.path); err == nil {
+		return fmt.Errorf("%s already exists", f.path)
+	}
+
+	fmt.Fprint(os.Stderr, "Recovery phrase: ")
+	phrase, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && phrase == "" {
+		return fmt.Errorf("reading recovery phrase: %w", err)
+	}
+	pass, err := readPassphrase("New passphrase: ")
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.OpenStorage(
+		storage.WithDBPath(f.path),
+		storage.WithTimeout(f.timeout),
+		storage.WithPassphraseHandler(func() ([]byte, error) {
+			return pass, nil
+		}),
+	)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	at, err := store.RestorePaperKey(strings.TrimSpace(phrase), backup)
+	if err != nil {
+		// Leave no database behind for the next attempt to trip over.
+		_ = store.Close()
+		_ = os.Remove(f.path)
+		return err
+	}
+	peers, err := store.ListPeers()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored identity %s with %d peers into %s\n",
+		at.EncodePublicKey(), len(peers), f.path)
+	return nil
+}
@@ -0,0 +1,21 @@
+// Package integration holds end-to-end tests that run kamune servers and
+// dialers against a real relay: relayed delivery between several pairs of
+// nodes at once, session resumption after a dialer is killed with SIGKILL,
+// and broker-assisted hole punching between peers behind simulated NATs.
+//
+// The tests are behind the integration build tag. By default they build the
+// relay binary and run it on free local ports:
+//
+//	go test -tags=integration ./integration/
+//
+// To test a containerized relay instead, start it with the compose file in
+// this directory and point the tests at it:
+//
+//	docker compose -f integration/compose.yaml up -d --build
+//	KAMUNE_RELAY_TCP=127.0.0.1:8889 KAMUNE_RELAY_BROKER=127.0.0.1:4788 \
+//		go test -tags=integration ./integration/
+//
+// The container uses host networking, since the hole punching tests rely on
+// the broker seeing the peers' real source ports. The broker tests are
+// skipped when only KAMUNE_RELAY_TCP is set.
+package integration
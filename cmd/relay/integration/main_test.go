@@ -0,0 +1,304 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune"
+	"github.com/kamune-org/kamune/pkg/relayconn"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+const (
+	envRelayTCP    = "KAMUNE_RELAY_TCP"
+	envRelayBroker = "KAMUNE_RELAY_BROKER"
+	relayReady     = 10 * time.Second
+)
+
+// relayTCP and relayBroker are the addresses of the relay under test. The
+// broker address is empty when an external relay without one is used.
+var relayTCP, relayBroker string
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	// A killed dialer re-executes the test binary; it talks to the relay of
+	// its parent, whose addresses it inherits.
+	if os.Getenv(envRelayTCP) != "" || os.Getenv(envChildDialer) != "" {
+		relayTCP = os.Getenv(envRelayTCP)
+		relayBroker = os.Getenv(envRelayBroker)
+		os.Exit(m.Run())
+	}
+
+	stop, err := startRelay()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "starting relay:", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+// startRelay builds the relay and runs it on free local ports with the
+// configuration the container uses.
+func startRelay() (func(), error) {
+	dir, err := os.MkdirTemp("", "kamune-relay")
+	if err != nil {
+		return nil, err
+	}
+	bin := filepath.Join(dir, "relay")
+	build := exec.Command("go", "build", "-o", bin, "..")
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		return nil, fmt.Errorf("building relay: %w", err)
+	}
+
+	tcpAddr, err := freePort("tcp")
+	if err != nil {
+		return nil, err
+	}
+	brokerAddr, err := freePort("udp")
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := os.ReadFile("relay.toml")
+	if err != nil {
+		return nil, err
+	}
+	cfg = []byte(strings.NewReplacer(
+		"0.0.0.0:8889", tcpAddr, "0.0.0.0:4788", brokerAddr,
+	).Replace(string(cfg)))
+	cfgPath := filepath.Join(dir, "relay.toml")
+	if err := os.WriteFile(cfgPath, cfg, 0o600); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(bin, "-c", cfgPath)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("running relay: %w", err)
+	}
+	stop := func() {
+		_ = cmd.Process.Signal(os.Interrupt)
+		_ = cmd.Wait()
+		_ = os.RemoveAll(dir)
+	}
+	if err := waitTCP(tcpAddr); err != nil {
+		stop()
+		return nil, err
+	}
+
+	relayTCP, relayBroker = tcpAddr, brokerAddr
+	// Children inherit the relay through the environment.
+	_ = os.Setenv(envRelayTCP, relayTCP)
+	_ = os.Setenv(envRelayBroker, relayBroker)
+	return stop, nil
+}
+
+// freePort returns a local address that was free a moment ago.
+func freePort(network string) (string, error) {
+	if network == "udp" {
+		c, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err != nil {
+			return "", err
+		}
+		defer c.Close()
+		return c.LocalAddr().String(), nil
+	}
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+func waitTCP(addr string) error {
+	deadline := time.Now().Add(relayReady)
+	for time.Now().Before(deadline) {
+		c, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			return c.Close()
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("relay at %s did not come up", addr)
+}
+
+func requireBroker(t *testing.T) {
+	t.Helper()
+	if relayBroker == "" {
+		t.Skip(envRelayBroker + " is not set")
+	}
+}
+
+func randomToken(t *testing.T, n int) []byte {
+	t.Helper()
+	a := require.New(t)
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	a.NoError(err)
+	return b
+}
+
+// trustAll accepts every peer and remembers it, so its sessions can be
+// recorded and resumed.
+func trustAll(store *storage.Storage, peer *storage.Peer) error {
+	return store.StorePeer(peer)
+}
+
+func newStore(t *testing.T, opts ...storage.StorageOption) *storage.Storage {
+	t.Helper()
+	a := require.New(t)
+	if len(opts) == 0 {
+		opts = []storage.StorageOption{storage.WithInMemory()}
+	}
+	store, err := storage.OpenStorage(opts...)
+	a.NoError(err)
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+// echoHandler echoes each message on its route.
+func echoHandler(t *kamune.Transport) error {
+	for {
+		msg := kamune.Bytes(nil)
+		md, err := t.Receive(msg)
+		if err != nil {
+			return nil
+		}
+		if _, err := t.Send(msg, md.Route()); err != nil {
+			return err
+		}
+	}
+}
+
+func echo(t *testing.T, tr *kamune.Transport, text string) {
+	t.Helper()
+	a := require.New(t)
+	_, err := tr.Send(kamune.Bytes([]byte(text)), kamune.RouteExchangeMessages)
+	a.NoError(err)
+	reply := kamune.Bytes(nil)
+	md, err := tr.Receive(reply)
+	a.NoError(err)
+	a.Equal(kamune.RouteExchangeMessages, md.Route())
+	a.Equal(text, string(reply.GetValue()))
+}
+
+// relayListener keeps a server registered with the relay under one token. A
+// relay listener carries a single session and is closed by the relay once
+// its dialer leaves, so a new one is registered whenever that happens.
+type relayListener struct {
+	ctx     context.Context
+	current *relayconn.RelayListener
+	cancel  context.CancelFunc
+	token   []byte
+	mu      sync.Mutex
+}
+
+func listenRelay(token []byte) *relayListener {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &relayListener{ctx: ctx, cancel: cancel, token: token}
+}
+
+func (l *relayListener) Accept() (kamune.Conn, error) {
+	for {
+		rl, err := l.register()
+		if err != nil {
+			if l.ctx.Err() != nil {
+				return nil, net.ErrClosed
+			}
+			// The relay may still hold the previous registration.
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		c, err := rl.Accept()
+		if err == nil {
+			return c, nil
+		}
+		l.mu.Lock()
+		if l.current == rl {
+			l.current = nil
+		}
+		l.mu.Unlock()
+		if l.ctx.Err() != nil {
+			return nil, net.ErrClosed
+		}
+	}
+}
+
+// register returns the current relay listener, registering one if needed.
+func (l *relayListener) register() (*relayconn.RelayListener, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.current != nil {
+		return l.current, nil
+	}
+	res, err := relayconn.ListenRelayTCP(
+		l.ctx, relayTCP, relayconn.WithToken(l.token),
+	)
+	if err != nil {
+		return nil, err
+	}
+	l.current = res.Listener
+	return l.current, nil
+}
+
+func (l *relayListener) Close() error {
+	l.cancel()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.current != nil {
+		return l.current.Close()
+	}
+	return nil
+}
+
+// serveRelayed runs an echo server reachable through the relay under token.
+func serveRelayed(t *testing.T, token []byte) {
+	t.Helper()
+	a := require.New(t)
+	store := newStore(t)
+	srv, err := kamune.NewServer(
+		"", echoHandler, store, trustAll,
+		kamune.ServeWithListener(listenRelay(token)),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	t.Cleanup(func() { _ = srv.Close() })
+}
+
+// dialRelayed returns a dial option reaching the server registered under
+// token. Until the server's registration arrives, the relay turns dialers
+// away, so dialing is retried for a while.
+func dialRelayed(token []byte) kamune.DialOption {
+	return kamune.DialWithFunc(func(string) (kamune.Conn, error) {
+		var err error
+		deadline := time.Now().Add(relayReady)
+		for time.Now().Before(deadline) {
+			// The context outlives the dial: it bounds the connection.
+			var c *relayconn.RelayConn
+			c, err = relayconn.DialRelayTCP(
+				context.Background(), relayTCP, token,
+			)
+			if err == nil {
+				return c, nil
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return nil, err
+	})
+}
@@ -0,0 +1,419 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtaci/kcp-go/v5"
+
+	"github.com/kamune-org/kamune"
+	relaybroker "github.com/kamune-org/kamune/pkg/relayconn/broker"
+)
+
+// echoRequest is the broker's STUN_ECHO packet: "KBRK" magic, version and
+// opcode, as in cmd/bus.
+var echoRequest = []byte{'K', 'B', 'R', 'K', 0x01, 0x01}
+
+const (
+	brokerTokenSize = 16
+	punchTimeout    = 3 * time.Second
+)
+
+type natKind int
+
+const (
+	// fullCone maps the inside socket to a single outside port that anyone
+	// can reach.
+	fullCone natKind = iota + 1
+	// restrictedCone maps like fullCone, but only lets in packets from
+	// addresses the inside socket has sent to.
+	restrictedCone
+	// symmetric maps every destination to its own outside port, and only
+	// lets in packets from that destination, so the port the broker sees is
+	// of no use to the peer.
+	symmetric
+)
+
+func (k natKind) String() string {
+	switch k {
+	case fullCone:
+		return "full cone"
+	case restrictedCone:
+		return "restricted cone"
+	case symmetric:
+		return "symmetric"
+	default:
+		return "invalid"
+	}
+}
+
+type natPacket struct {
+	from *net.UDPAddr
+	data []byte
+}
+
+// natConn is a UDP socket behind a simulated NAT. Outside sockets on the
+// loopback stand for the NAT's public ports.
+type natConn struct {
+	deadline time.Time
+	in       chan natPacket
+	closed   chan struct{}
+	// outside holds the NAT's ports, keyed by destination for symmetric NATs
+	// and by the empty string otherwise.
+	outside   map[string]*net.UDPConn
+	sent      map[string]bool
+	mu        sync.Mutex
+	closeOnce sync.Once
+	kind      natKind
+}
+
+func newNATConn(kind natKind) *natConn {
+	return &natConn{
+		in:      make(chan natPacket, 256),
+		closed:  make(chan struct{}),
+		outside: make(map[string]*net.UDPConn),
+		sent:    make(map[string]bool),
+		kind:    kind,
+	}
+}
+
+func (c *natConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case pkt := <-c.in:
+		return copy(p, pkt.data), pkt.from, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (c *natConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	dst, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("unsupported address %v", addr)
+	}
+	key := ""
+	if c.kind == symmetric {
+		key = dst.String()
+	}
+
+	c.mu.Lock()
+	out, ok := c.outside[key]
+	if !ok {
+		var err error
+		out, err = net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+		if err != nil {
+			c.mu.Unlock()
+			return 0, err
+		}
+		c.outside[key] = out
+		go c.forward(out, key)
+	}
+	c.sent[dst.String()] = true
+	c.mu.Unlock()
+	return out.WriteToUDP(p, dst)
+}
+
+// forward passes the packets arriving on an outside port that the NAT lets
+// in to the inside socket.
+func (c *natConn) forward(out *net.UDPConn, key string) {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := out.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !c.admits(key, from) {
+			continue
+		}
+		select {
+		case c.in <- natPacket{from: from, data: bytes.Clone(buf[:n])}:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *natConn) admits(key string, from *net.UDPAddr) bool {
+	switch c.kind {
+	case fullCone:
+		return true
+	case symmetric:
+		return key == from.String()
+	default:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.sent[from.String()]
+	}
+}
+
+func (c *natConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, out := range c.outside {
+			_ = out.Close()
+		}
+	})
+	return nil
+}
+
+// LocalAddr returns the inside address, which nobody outside can reach.
+func (c *natConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4242}
+}
+
+func (c *natConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *natConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *natConn) SetWriteDeadline(time.Time) error { return nil }
+
+// matchPeer registers conn with the broker under token and waits for the
+// peer registering the same token. It returns the peer's address.
+func matchPeer(conn *natConn, token []byte) (*net.UDPAddr, error) {
+	broker, err := net.ResolveUDPAddr("udp4", relayBroker)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// The broker reports the outside address it sees, which is the one the
+	// peer is told to punch.
+	if _, err := conn.WriteTo(echoRequest, broker); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1500)
+	_ = conn.SetReadDeadline(time.Now().Add(punchTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		return nil, fmt.Errorf("reading echo: %w", err)
+	}
+	claim, err := parseEchoResponse(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	pkt := relaybroker.BuildRegister(
+		token, key.PublicKey().Bytes(), claim.IP, uint16(claim.Port),
+	)
+	if _, err := conn.WriteTo(pkt, broker); err != nil {
+		return nil, err
+	}
+
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, fmt.Errorf("reading notify: %w", err)
+		}
+		p, err := openNotify(key, buf[:n])
+		if err != nil || p.Type != relaybroker.NotifyPeerMatched {
+			continue
+		}
+		return &net.UDPAddr{IP: p.IP, Port: int(p.Port)}, nil
+	}
+}
+
+func openNotify(
+	key *ecdh.PrivateKey, pkt []byte,
+) (relaybroker.NotifyPayload, error) {
+	brokerEphPub, nonce, sealed, err := relaybroker.ParseNotify(pkt)
+	if err != nil {
+		return relaybroker.NotifyPayload{}, err
+	}
+	brokerPub, err := ecdh.X25519().NewPublicKey(brokerEphPub)
+	if err != nil {
+		return relaybroker.NotifyPayload{}, err
+	}
+	shared, err := key.ECDH(brokerPub)
+	if err != nil {
+		return relaybroker.NotifyPayload{}, err
+	}
+	aeadKey := sha256.Sum256(shared)
+	plaintext, err := relaybroker.OpenNotify(
+		aeadKey[:], brokerEphPub, nonce, sealed,
+	)
+	if err != nil {
+		return relaybroker.NotifyPayload{}, err
+	}
+	return relaybroker.ParseNotifyPayload(plaintext)
+}
+
+// parseEchoResponse parses the broker's "ip:port\0" answer.
+func parseEchoResponse(resp []byte) (*net.UDPAddr, error) {
+	resp, _, _ = bytes.Cut(resp, []byte{0})
+	host, port, err := net.SplitHostPort(string(resp))
+	if err != nil {
+		return nil, fmt.Errorf("malformed echo response %q: %w", resp, err)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("parsing port %q: %w", port, err)
+	}
+	return &net.UDPAddr{IP: net.ParseIP(host), Port: int(p)}, nil
+}
+
+// kick sends a few packets to the peer to open the NAT towards it. KCP drops
+// them as invalid frames.
+func kick(conn *natConn, peer *net.UDPAddr) {
+	for range 5 {
+		if _, err := conn.WriteTo([]byte{0}, peer); err != nil {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// kcpListener accepts kamune connections over KCP on a punched socket.
+type kcpListener struct {
+	*kcp.Listener
+}
+
+func (l kcpListener) Accept() (kamune.Conn, error) {
+	s, err := l.AcceptKCP()
+	if err != nil {
+		return nil, err
+	}
+	return kamune.NewConn(s), nil
+}
+
+// punch matches a server and a dialer behind NATs of the given kinds through
+// the broker, and runs a kamune session over the punched path.
+func punch(t *testing.T, serverNAT, dialerNAT natKind) error {
+	token := randomToken(t, brokerTokenSize)
+	serverConn, dialerConn := newNATConn(serverNAT), newNATConn(dialerNAT)
+	t.Cleanup(func() { _ = serverConn.Close() })
+	t.Cleanup(func() { _ = dialerConn.Close() })
+
+	// The peer that registers first is held until the second arrives.
+	serverPeer := make(chan *net.UDPAddr, 1)
+	serverErr := make(chan error, 1)
+	go func() {
+		addr, err := matchPeer(serverConn, token)
+		serverErr <- err
+		serverPeer <- addr
+	}()
+	time.Sleep(50 * time.Millisecond)
+	dialerPeer, err := matchPeer(dialerConn, token)
+	if err != nil {
+		return fmt.Errorf("dialer match: %w", err)
+	}
+	if err := <-serverErr; err != nil {
+		return fmt.Errorf("server match: %w", err)
+	}
+	go kick(serverConn, <-serverPeer)
+
+	l, err := kcp.ServeConn(nil, 0, 0, serverConn)
+	if err != nil {
+		return err
+	}
+	store := newStore(t)
+	srv, err := kamune.NewServer(
+		"", echoHandler, store, trustAll,
+		kamune.ServeWithListener(kcpListener{Listener: l}),
+	)
+	if err != nil {
+		return err
+	}
+	go func() { _ = srv.ListenAndServe() }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	d, err := kamune.NewDialer(
+		"", newStore(t), trustAll,
+		kamune.DialWithFunc(func(string) (kamune.Conn, error) {
+			var conv uint32
+			_ = binary.Read(rand.Reader, binary.LittleEndian, &conv)
+			s, err := kcp.NewConn4(
+				conv, dialerPeer, nil, 0, 0, false, dialerConn,
+			)
+			if err != nil {
+				return nil, err
+			}
+			return kamune.NewConn(s), nil
+		}),
+		kamune.DialWithHandshakeTimeouts(kamune.HandshakeTimeouts{
+			Exchange: punchTimeout,
+		}),
+	)
+	if err != nil {
+		return err
+	}
+	tr, err := d.Dial()
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+	echo(t, tr, "punched through")
+	return nil
+}
+
+func TestHolePunch(t *testing.T) {
+	requireBroker(t)
+	tests := []struct {
+		server, dialer natKind
+		direct         bool
+	}{
+		{server: fullCone, dialer: fullCone, direct: true},
+		{server: restrictedCone, dialer: fullCone, direct: true},
+		{server: restrictedCone, dialer: restrictedCone, direct: true},
+		{server: symmetric, dialer: restrictedCone},
+		{server: restrictedCone, dialer: symmetric},
+	}
+	for _, tc := range tests {
+		name := fmt.Sprintf("%s to %s", tc.dialer, tc.server)
+		t.Run(name, func(t *testing.T) {
+			a := require.New(t)
+			err := punch(t, tc.server, tc.dialer)
+			if tc.direct {
+				a.NoError(err)
+				return
+			}
+			// Behind a symmetric NAT, peers fall back to the relay.
+			a.Error(err)
+			token := randomToken(t, relayTokenSize)
+			serveRelayed(t, token)
+			d, err := kamune.NewDialer(
+				"", newStore(t), trustAll, dialRelayed(token),
+			)
+			a.NoError(err)
+			tr, err := d.Dial()
+			a.NoError(err)
+			defer tr.Close()
+			echo(t, tr, "relayed instead")
+		})
+	}
+}
@@ -0,0 +1,129 @@
+//go:build integration
+
+package integration
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+const (
+	// envChildDialer makes TestResumeAfterKill act as the dialer that gets
+	// killed. Its value is the path of the dialer's database; the relay
+	// token follows in envChildToken.
+	envChildDialer = "KAMUNE_INTEGRATION_DIALER"
+	envChildToken  = "KAMUNE_INTEGRATION_TOKEN"
+	relayTokenSize = 32
+)
+
+func TestRelayedDelivery(t *testing.T) {
+	const nodes, messages = 4, 16
+
+	var wg sync.WaitGroup
+	for i := range nodes {
+		token := randomToken(t, relayTokenSize)
+		serveRelayed(t, token)
+		wg.Go(func() {
+			a := require.New(t)
+			d, err := kamune.NewDialer(
+				"", newStore(t), trustAll, dialRelayed(token),
+			)
+			a.NoError(err)
+			tr, err := d.Dial()
+			a.NoError(err)
+			defer tr.Close()
+			for j := range messages {
+				echo(t, tr, fmt.Sprintf("node %d message %d", i, j))
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// TestResumeAfterKill kills a dialer with SIGKILL in the middle of its
+// session, so it neither closes the session nor says goodbye, and resumes
+// the session from the dialer's database in a new process.
+func TestResumeAfterKill(t *testing.T) {
+	if path := os.Getenv(envChildDialer); path != "" {
+		runChildDialer(t, path)
+		return
+	}
+	a := require.New(t)
+	token := randomToken(t, relayTokenSize)
+	serveRelayed(t, token)
+
+	dbPath := filepath.Join(t.TempDir(), "dialer.db")
+	child := exec.Command(os.Args[0], "-test.run=^TestResumeAfterKill$")
+	child.Env = append(
+		os.Environ(),
+		envChildDialer+"="+dbPath,
+		fmt.Sprintf("%s=%x", envChildToken, token),
+	)
+	child.Stderr = os.Stderr
+	stdout, err := child.StdoutPipe()
+	a.NoError(err)
+	a.NoError(child.Start())
+
+	// The child reports its session once a message went through, then
+	// waits to be killed.
+	var sessionID string
+	lines := bufio.NewScanner(stdout)
+	for lines.Scan() {
+		if id, ok := strings.CutPrefix(lines.Text(), "session "); ok {
+			sessionID = id
+			break
+		}
+	}
+	a.NotEmpty(sessionID, "child dialer did not establish a session")
+	a.NoError(child.Process.Kill())
+	_ = child.Wait()
+
+	store := newStore(t, storage.WithDBPath(dbPath), storage.WithNoPassphrase())
+	d, err := kamune.NewDialer(
+		"", store, trustAll,
+		dialRelayed(token), kamune.DialWithResume(sessionID),
+	)
+	a.NoError(err)
+
+	// The server notices the dead session only when the relay drops it, and
+	// registers again afterwards; until then the resumption fails.
+	var tr *kamune.Transport
+	a.Eventually(func() bool {
+		tr, err = d.Dial()
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	defer tr.Close()
+	a.Equal(sessionID, tr.SessionID())
+	echo(t, tr, "back from the dead")
+}
+
+// runChildDialer establishes a session through the relay, which is recorded
+// in the database at path, and blocks.
+func runChildDialer(t *testing.T, path string) {
+	a := require.New(t)
+	var token []byte
+	_, err := fmt.Sscanf(os.Getenv(envChildToken), "%x", &token)
+	a.NoError(err)
+
+	store := newStore(t, storage.WithDBPath(path), storage.WithNoPassphrase())
+	d, err := kamune.NewDialer("", store, trustAll, dialRelayed(token))
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	echo(t, tr, "before the kill")
+
+	fmt.Printf("session %s\n", tr.SessionID())
+	select {}
+}
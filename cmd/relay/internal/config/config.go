@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"time"
 
@@ -18,6 +19,7 @@ type Config struct {
 	TLS       TLS       `toml:"tls"`
 	WSS       WSS       `toml:"wss"`
 	Broker    Broker    `toml:"broker"`
+	Push      Push      `toml:"push"`
 }
 
 type Server struct {
@@ -69,6 +71,23 @@ type Broker struct {
 	RegistrationTTL time.Duration `toml:"registration_ttl"`
 }
 
+// Push configures wakeups of suspended mobile listeners through platform push
+// services. Listeners register an opaque blob that the relay hands to the
+// named provider when a dialer joins their token while they are offline.
+type Push struct {
+	Providers        map[string]PushProvider `toml:"providers"`
+	RegistrationTTL  time.Duration           `toml:"registration_ttl"`
+	WakeTimeout      time.Duration           `toml:"wake_timeout"`
+	MaxRegistrations int                     `toml:"max_registrations"`
+	Enabled          bool                    `toml:"enabled"`
+}
+
+// PushProvider is a push gateway that the relay posts registered blobs to.
+// The gateway decrypts the blob and sends the platform push (FCM, APNs).
+type PushProvider struct {
+	URL string `toml:"url"`
+}
+
 type RateLimit struct {
 	Disabled   bool          `toml:"disabled"`
 	TimeWindow time.Duration `toml:"time_window"`
@@ -126,6 +145,9 @@ func (c Config) Validate() error {
 			c.WSS.CertFile, c.WSS.KeyFile,
 		)
 	}
+	if err := c.Push.validate(); err != nil {
+		return err
+	}
 	if !c.Diagnose.Enabled && !c.WS.Enabled && !c.TCP.Enabled &&
 		!c.TLS.Enabled && !c.WSS.Enabled && !c.Broker.Enabled {
 		return fmt.Errorf(
@@ -136,6 +158,43 @@ func (c Config) Validate() error {
 	return nil
 }
 
+func (p Push) validate() error {
+	if !p.Enabled {
+		return nil
+	}
+	if len(p.Providers) == 0 {
+		return fmt.Errorf("push.providers must not be empty when enabled")
+	}
+	for name, provider := range p.Providers {
+		u, err := url.Parse(provider.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf(
+				"push.providers.%s.url must be an http(s) URL, got %q",
+				name, provider.URL,
+			)
+		}
+	}
+	if p.RegistrationTTL < 0 {
+		return fmt.Errorf(
+			"push.registration_ttl must be >= 0 (0 = default), got %s",
+			p.RegistrationTTL,
+		)
+	}
+	if p.WakeTimeout < 0 {
+		return fmt.Errorf(
+			"push.wake_timeout must be >= 0 (0 = default), got %s",
+			p.WakeTimeout,
+		)
+	}
+	if p.MaxRegistrations < 0 {
+		return fmt.Errorf(
+			"push.max_registrations must be >= 0 (0 = default), got %d",
+			p.MaxRegistrations,
+		)
+	}
+	return nil
+}
+
 const EnvKey = "KAMUNE_RELAY_CONFIG"
 
 // New loads config from the given file path. If path is empty, it falls back to
@@ -292,6 +292,66 @@ func TestConfig_Validate_RejectsNoServersEnabled(t *testing.T) {
 	a.Contains(err.Error(), "at least one server")
 }
 
+func TestConfig_Validate_Push(t *testing.T) {
+	gateway := map[string]PushProvider{
+		"fcm": {URL: "https://push.example.com/fcm"},
+	}
+	tests := []struct {
+		name    string
+		push    Push
+		wantErr string
+	}{
+		{name: "disabled", push: Push{Providers: map[string]PushProvider{
+			"fcm": {URL: "not a url"},
+		}}},
+		{name: "defaults", push: Push{Enabled: true, Providers: gateway}},
+		{
+			name:    "no providers",
+			push:    Push{Enabled: true},
+			wantErr: "push.providers",
+		},
+		{
+			name: "bad url",
+			push: Push{Enabled: true, Providers: map[string]PushProvider{
+				"apns": {URL: "ftp://push.example.com"},
+			}},
+			wantErr: "push.providers.apns.url",
+		},
+		{
+			name: "negative registration ttl",
+			push: Push{
+				Enabled: true, Providers: gateway, RegistrationTTL: -1,
+			},
+			wantErr: "push.registration_ttl",
+		},
+		{
+			name:    "negative wake timeout",
+			push:    Push{Enabled: true, Providers: gateway, WakeTimeout: -1},
+			wantErr: "push.wake_timeout",
+		},
+		{
+			name: "negative max registrations",
+			push: Push{
+				Enabled: true, Providers: gateway, MaxRegistrations: -1,
+			},
+			wantErr: "push.max_registrations",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			cfg := validConfig()
+			cfg.Push = tc.push
+			err := cfg.Validate()
+			if tc.wantErr == "" {
+				a.NoError(err)
+				return
+			}
+			a.ErrorContains(err, tc.wantErr)
+		})
+	}
+}
+
 func TestNew_EnvVar(t *testing.T) {
 	a := require.New(t)
 	t.Setenv(EnvKey, `
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"net"
 	"testing"
 	"time"
@@ -22,7 +23,7 @@ func newTestHub(
 ) *services.Hub {
 	t.Helper()
 	sm := services.NewSessionManager(time.Minute, 100, 0)
-	return services.NewHub(sm, password, 0, nil, handshakeTimeout)
+	return services.NewHub(sm, password, 0, nil, handshakeTimeout, nil)
 }
 
 // dialClient drives a fake client over the given net.Conn: it performs
@@ -726,3 +727,72 @@ func TestHandler_RejectsWrongSizeToken(t *testing.T) {
 		a.FailNow("relay did not close on wrong-size token")
 	}
 }
+
+// wakeProvider is a push provider that reports the blobs it is asked to
+// wake.
+type wakeProvider chan []byte
+
+func (p wakeProvider) Wake(_ context.Context, blob []byte) error {
+	p <- blob
+	return nil
+}
+
+func TestHandler_PushWakeup(t *testing.T) {
+	a := require.New(t)
+	woken := make(wakeProvider, 1)
+	hub := services.NewHub(
+		services.NewSessionManager(time.Minute, 100, 0), "", 0, nil, 0,
+		services.NewPushRegistry(
+			map[string]services.PushProvider{"fcm": woken},
+			time.Hour, 5*time.Second, 100,
+		),
+	)
+	token := makeStaticToken(0x20)
+
+	// The listener registers a push wakeup along with its token.
+	listenerClient, listenerServer := net.Pipe()
+	defer listenerClient.Close()
+	stopListener := runServer(hub, listenerServer, nil)
+	ch, err := exchange.Initiate(newRawTCPAdapter(listenerClient, 0))
+	a.NoError(err, "Initiate")
+	sendFrame(t, ch, &pb.Frame{
+		Kind: &pb.Frame_Register{Register: &pb.Register{
+			Mode:  pb.Register_MODE_CREATE,
+			Token: token,
+			Push:  &pb.Push{Provider: "fcm", Blob: []byte("sealed")},
+		}},
+	})
+	a.True(readFrame(t, ch).GetRegistered().GetPushRegistered())
+	// The device is suspended.
+	stopListener()
+
+	// A dialer arrives and is held while the listener is woken.
+	dialerClient, dialerServer := net.Pipe()
+	defer dialerClient.Close()
+	stopDialer := runServer(hub, dialerServer, nil)
+	defer stopDialer()
+	joined := make(chan *exchange.Channel, 1)
+	go func() {
+		ch, _ := dialClient(
+			t, dialerClient, "", "", pb.Register_MODE_JOIN, token,
+		)
+		joined <- ch
+	}()
+	a.Equal([]byte("sealed"), <-woken)
+
+	wokenClient, wokenServer := net.Pipe()
+	defer wokenClient.Close()
+	stopWoken := runServer(hub, wokenServer, nil)
+	defer stopWoken()
+	listenerCh, reg := dialClient(
+		t, wokenClient, "", "", pb.Register_MODE_CREATE, token,
+	)
+	a.False(reg.GetPushRegistered())
+
+	dialerCh := <-joined
+	want := []byte("hello from dialer")
+	sendFrame(t, dialerCh, &pb.Frame{
+		Kind: &pb.Frame_Msg{Msg: &pb.Message{Data: want}},
+	})
+	a.Equal(want, readFrame(t, listenerCh).GetMsg().GetData())
+}
@@ -146,6 +146,7 @@ func handleRelayConn(
 		sentToken         []byte
 		ttlSeconds        uint32
 		sessionTTLSeconds uint32
+		pushRegistered    bool
 	)
 
 	mode := register.GetMode()
@@ -175,6 +176,17 @@ func handleRelayConn(
 			}
 			sentToken = token
 			ttlSeconds = uint32(hub.TokenTTL().Seconds())
+
+			if push := register.GetPush(); push != nil {
+				if err := hub.RegisterPush(token, push); err != nil {
+					slog.Warn(
+						"relay: register push",
+						slog.Any("error", err),
+					)
+				} else {
+					pushRegistered = true
+				}
+			}
 		}
 
 	case pb.Register_MODE_JOIN:
@@ -209,6 +221,7 @@ func handleRelayConn(
 				Token:             sentToken,
 				TtlSeconds:        ttlSeconds,
 				SessionTtlSeconds: sessionTTLSeconds,
+				PushRegistered:    pushRegistered,
 			},
 		},
 	}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"errors"
 	"log/slog"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 
 type Hub struct {
 	sessions         *SessionManager
+	push             *PushRegistry
 	password         string
 	maxMsgSize       int
 	rateLimiter      *ratelimit.RateLimiter
@@ -24,9 +26,11 @@ func NewHub(
 	maxMsgSize int,
 	rateLimiter *ratelimit.RateLimiter,
 	handshakeTimeout time.Duration,
+	push *PushRegistry,
 ) *Hub {
 	return &Hub{
 		sessions:         sessions,
+		push:             push,
 		password:         password,
 		maxMsgSize:       maxMsgSize,
 		rateLimiter:      rateLimiter,
@@ -63,11 +67,51 @@ func (h *Hub) RegisterListener(ch *exchange.Channel) ([]byte, error) {
 }
 
 func (h *Hub) RegisterListenerWith(ch *exchange.Channel, token []byte) error {
-	return h.sessions.CreateWith(ch, token)
+	if err := h.sessions.CreateWith(ch, token); err != nil {
+		return err
+	}
+	if h.push != nil {
+		h.push.Notify(token)
+	}
+	return nil
+}
+
+// RegisterPush stores the push wakeup of the listener of token. The listener
+// must have been registered under token already.
+func (h *Hub) RegisterPush(token []byte, push *pb.Push) error {
+	if h.push == nil {
+		return ErrPushDisabled
+	}
+	return h.push.Register(token, push.GetProvider(), push.GetBlob())
 }
 
+// RegisterDialer joins the dialer to the session of token. If there is no
+// such session but its listener registered a push wakeup, the listener is
+// woken and the dialer is held until it registers again or the wake timeout
+// passes.
 func (h *Hub) RegisterDialer(ch *exchange.Channel, token []byte) error {
-	return h.sessions.Join(token, ch)
+	err := h.sessions.Join(token, ch)
+	if !errors.Is(err, ErrTokenNotFound) || h.push == nil {
+		return err
+	}
+	woken, ok := h.push.Wake(token)
+	if !ok {
+		return err
+	}
+
+	// The listener may have registered between the join and the wake, in
+	// which case it is not notified; rejoin before waiting.
+	if err := h.sessions.Join(token, ch); !errors.Is(err, ErrTokenNotFound) {
+		return err
+	}
+	timer := time.NewTimer(h.push.WakeTimeout())
+	defer timer.Stop()
+	select {
+	case <-woken:
+		return h.sessions.Join(token, ch)
+	case <-timer.C:
+		return ErrWakeTimeout
+	}
 }
 
 func (h *Hub) ReadPump(ch *exchange.Channel, token []byte) {
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kamune-org/kamune/pkg/relayconn"
+)
+
+var (
+	ErrPushDisabled        = errors.New("push wakeups disabled")
+	ErrUnknownPushProvider = errors.New("unknown push provider")
+	ErrPushRegistryFull    = errors.New("max push registrations reached")
+	ErrWakeTimeout         = errors.New("listener did not wake up in time")
+)
+
+// PushProvider wakes a suspended listener through a platform push service.
+// The blob is whatever the listener registered, typically its device token
+// sealed for a push gateway; the relay never interprets it.
+type PushProvider interface {
+	Wake(ctx context.Context, blob []byte) error
+}
+
+// WebhookProvider posts blobs to a push gateway, which holds the platform
+// credentials and the key to open them. The request carries the blob and
+// nothing else, so the gateway learns neither the token nor the dialer.
+type WebhookProvider struct {
+	client *http.Client
+	url    string
+}
+
+func NewWebhookProvider(url string) *WebhookProvider {
+	return &WebhookProvider{client: http.DefaultClient, url: url}
+}
+
+func (w *WebhookProvider) Wake(ctx context.Context, blob []byte) error {
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, w.url, bytes.NewReader(blob),
+	)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("push gateway returned %s", resp.Status)
+	}
+	return nil
+}
+
+type pushRegistration struct {
+	expiry   time.Time
+	provider string
+	blob     []byte
+}
+
+// wakeup is an outstanding wake of a listener. done is closed when the
+// listener registers again.
+type wakeup struct {
+	deadline time.Time
+	done     chan struct{}
+}
+
+// PushRegistry keeps the push wakeups registered by listeners with static
+// tokens, keyed by token like the sessions, and outlives their connections.
+type PushRegistry struct {
+	providers     map[string]PushProvider
+	registrations map[string]*pushRegistration
+	wakeups       map[string]*wakeup
+	ttl           time.Duration
+	wakeTimeout   time.Duration
+	maxEntries    int
+	mu            sync.Mutex
+}
+
+func NewPushRegistry(
+	providers map[string]PushProvider,
+	ttl, wakeTimeout time.Duration,
+	maxEntries int,
+) *PushRegistry {
+	return &PushRegistry{
+		providers:     providers,
+		registrations: make(map[string]*pushRegistration),
+		wakeups:       make(map[string]*wakeup),
+		ttl:           ttl,
+		wakeTimeout:   wakeTimeout,
+		maxEntries:    maxEntries,
+	}
+}
+
+// Register stores blob for the listener of token, to be handed to the named
+// provider when the listener must be woken. A registration lasts for the
+// registry's TTL and is renewed every time the listener registers. An empty
+// blob removes the registration.
+func (pr *PushRegistry) Register(
+	token []byte, provider string, blob []byte,
+) error {
+	key := fmt.Sprintf("%x", token)
+	if len(blob) == 0 {
+		pr.mu.Lock()
+		delete(pr.registrations, key)
+		pr.mu.Unlock()
+		return nil
+	}
+	if len(blob) > relayconn.MaxPushBlobSize {
+		return relayconn.ErrPushBlobTooLarge
+	}
+	if _, ok := pr.providers[provider]; !ok {
+		return ErrUnknownPushProvider
+	}
+
+	pr.purgeExpired()
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if _, exists := pr.registrations[key]; !exists &&
+		len(pr.registrations) >= pr.maxEntries {
+		return ErrPushRegistryFull
+	}
+	pr.registrations[key] = &pushRegistration{
+		expiry:   time.Now().Add(pr.ttl),
+		provider: provider,
+		blob:     bytes.Clone(blob),
+	}
+	return nil
+}
+
+// Wake returns a channel that is closed once the listener of token registers
+// again, and asks the push provider to wake the listener unless a wakeup is
+// already under way. It reports false if token has no push registration.
+func (pr *PushRegistry) Wake(token []byte) (<-chan struct{}, bool) {
+	key := fmt.Sprintf("%x", token)
+	now := time.Now()
+
+	pr.mu.Lock()
+	reg, ok := pr.registrations[key]
+	if !ok || now.After(reg.expiry) {
+		pr.mu.Unlock()
+		return nil, false
+	}
+	if w, ok := pr.wakeups[key]; ok && now.Before(w.deadline) {
+		pr.mu.Unlock()
+		return w.done, true
+	}
+	w := &wakeup{
+		deadline: now.Add(pr.wakeTimeout),
+		done:     make(chan struct{}),
+	}
+	pr.wakeups[key] = w
+	provider := pr.providers[reg.provider]
+	pr.mu.Unlock()
+
+	go pr.send(provider, reg.provider, reg.blob)
+	return w.done, true
+}
+
+func (pr *PushRegistry) send(p PushProvider, name string, blob []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), pr.wakeTimeout)
+	defer cancel()
+	if err := p.Wake(ctx, blob); err != nil {
+		slog.Warn(
+			"push: wake failed",
+			slog.String("provider", name),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// Notify releases the dialers waiting for the listener of token to wake up.
+func (pr *PushRegistry) Notify(token []byte) {
+	key := fmt.Sprintf("%x", token)
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if w, ok := pr.wakeups[key]; ok {
+		close(w.done)
+		delete(pr.wakeups, key)
+	}
+}
+
+func (pr *PushRegistry) Len() int {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return len(pr.registrations)
+}
+
+func (pr *PushRegistry) WakeTimeout() time.Duration {
+	return pr.wakeTimeout
+}
+
+func (pr *PushRegistry) cleanupLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pr.purgeExpired()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// purgeExpired drops expired registrations and wakeups that are past their
+// deadline. The dialers of the latter give up on their own.
+func (pr *PushRegistry) purgeExpired() {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	now := time.Now()
+	for key, reg := range pr.registrations {
+		if now.After(reg.expiry) {
+			delete(pr.registrations, key)
+		}
+	}
+	for key, w := range pr.wakeups {
+		if now.After(w.deadline) {
+			delete(pr.wakeups, key)
+		}
+	}
+}
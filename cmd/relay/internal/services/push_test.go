@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/relayconn"
+	"github.com/kamune-org/kamune/pkg/relayconn/pb"
+)
+
+// fakeProvider records the blobs it is asked to wake.
+type fakeProvider struct {
+	woken chan []byte
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{woken: make(chan []byte, 8)}
+}
+
+func (p *fakeProvider) Wake(_ context.Context, blob []byte) error {
+	p.woken <- blob
+	return nil
+}
+
+func pushToken(seed byte) []byte {
+	tok := make([]byte, 32)
+	for i := range tok {
+		tok[i] = seed + byte(i)
+	}
+	return tok
+}
+
+func newTestPushRegistry(
+	p PushProvider, ttl time.Duration, maxEntries int,
+) *PushRegistry {
+	return NewPushRegistry(
+		map[string]PushProvider{"fcm": p}, ttl, time.Second, maxEntries,
+	)
+}
+
+func TestPushRegistry_Register(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		blob     []byte
+		wantErr  error
+	}{
+		{name: "ok", provider: "fcm", blob: []byte("sealed")},
+		{
+			name:     "unknown provider",
+			provider: "apns",
+			blob:     []byte("sealed"),
+			wantErr:  ErrUnknownPushProvider,
+		},
+		{
+			name:     "blob too large",
+			provider: "fcm",
+			blob:     make([]byte, relayconn.MaxPushBlobSize+1),
+			wantErr:  relayconn.ErrPushBlobTooLarge,
+		},
+		{
+			name:     "full",
+			provider: "fcm",
+			blob:     []byte("sealed"),
+			wantErr:  ErrPushRegistryFull,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			pr := newTestPushRegistry(newFakeProvider(), time.Hour, 1)
+			a.NoError(pr.Register(pushToken(1), "fcm", []byte("other")))
+			if tc.wantErr == nil {
+				// Replacing a registration needs no room.
+				a.NoError(pr.Register(pushToken(1), tc.provider, tc.blob))
+				a.Equal(1, pr.Len())
+				return
+			}
+			a.ErrorIs(
+				pr.Register(pushToken(2), tc.provider, tc.blob), tc.wantErr,
+			)
+		})
+	}
+}
+
+func TestPushRegistry_Wake(t *testing.T) {
+	a := require.New(t)
+	p := newFakeProvider()
+	pr := newTestPushRegistry(p, time.Hour, 10)
+	token := pushToken(1)
+
+	_, ok := pr.Wake(token)
+	a.False(ok, "no registration")
+
+	a.NoError(pr.Register(token, "fcm", []byte("sealed")))
+	done, ok := pr.Wake(token)
+	a.True(ok)
+	a.Equal([]byte("sealed"), <-p.woken)
+
+	// A second dialer shares the outstanding wakeup.
+	again, ok := pr.Wake(token)
+	a.True(ok)
+	a.Equal(done, again)
+	a.Empty(p.woken)
+
+	pr.Notify(token)
+	select {
+	case <-done:
+	default:
+		a.Fail("wakeup not released")
+	}
+
+	// An empty blob removes the registration.
+	a.NoError(pr.Register(token, "", nil))
+	_, ok = pr.Wake(token)
+	a.False(ok)
+	a.Zero(pr.Len())
+}
+
+func TestPushRegistry_Expiry(t *testing.T) {
+	a := require.New(t)
+	pr := newTestPushRegistry(newFakeProvider(), time.Millisecond, 10)
+	a.NoError(pr.Register(pushToken(1), "fcm", []byte("sealed")))
+	time.Sleep(5 * time.Millisecond)
+
+	_, ok := pr.Wake(pushToken(1))
+	a.False(ok)
+	pr.purgeExpired()
+	a.Zero(pr.Len())
+}
+
+func TestHub_RegisterDialer_WakesListener(t *testing.T) {
+	a := require.New(t)
+	p := newFakeProvider()
+	hub := NewHub(
+		newTestSessionManager(time.Minute, 0, 10), "", 0, nil, 0,
+		newTestPushRegistry(p, time.Hour, 10),
+	)
+	token := pushToken(1)
+
+	// Without a registration, a missing listener fails the join at once.
+	dialer, _, cleanup := pipeChans(t)
+	defer cleanup()
+	a.ErrorIs(hub.RegisterDialer(dialer, token), ErrTokenNotFound)
+
+	// The listener registers a push wakeup and goes away.
+	listener, _, cleanup := pipeChans(t)
+	defer cleanup()
+	a.NoError(hub.RegisterListenerWith(listener, token))
+	a.NoError(hub.RegisterPush(token, &pb.Push{
+		Provider: "fcm", Blob: []byte("sealed"),
+	}))
+	hub.Unregister(token)
+
+	joined := make(chan error, 1)
+	go func() { joined <- hub.RegisterDialer(dialer, token) }()
+	a.Equal([]byte("sealed"), <-p.woken)
+
+	// The woken listener registers again and the dialer is let in.
+	woken, _, cleanup := pipeChans(t)
+	defer cleanup()
+	a.NoError(hub.RegisterListenerWith(woken, token))
+	a.NoError(<-joined)
+	recipient, err := hub.sessions.Recipient(token, dialer)
+	a.NoError(err)
+	a.Equal(woken, recipient)
+}
+
+func TestHub_RegisterDialer_WakeTimeout(t *testing.T) {
+	a := require.New(t)
+	pr := NewPushRegistry(
+		map[string]PushProvider{"fcm": newFakeProvider()},
+		time.Hour, 10*time.Millisecond, 10,
+	)
+	hub := NewHub(
+		newTestSessionManager(time.Minute, 0, 10), "", 0, nil, 0, pr,
+	)
+	token := pushToken(1)
+	a.NoError(pr.Register(token, "fcm", []byte("sealed")))
+
+	dialer, _, cleanup := pipeChans(t)
+	defer cleanup()
+	a.ErrorIs(hub.RegisterDialer(dialer, token), ErrWakeTimeout)
+}
+
+func TestHub_RegisterPush_Disabled(t *testing.T) {
+	a := require.New(t)
+	hub := NewHub(
+		newTestSessionManager(time.Minute, 0, 10), "", 0, nil, 0, nil,
+	)
+	a.ErrorIs(
+		hub.RegisterPush(pushToken(1), &pb.Push{Provider: "fcm"}),
+		ErrPushDisabled,
+	)
+}
+
+func TestWebhookProvider_Wake(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "accepted", status: http.StatusAccepted},
+		{name: "rejected", status: http.StatusBadRequest, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			got := make(chan string, 1)
+			srv := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					body, _ := io.ReadAll(r.Body)
+					got <- r.Header.Get("Content-Type") + " " + string(body)
+					w.WriteHeader(tc.status)
+				},
+			))
+			defer srv.Close()
+
+			err := NewWebhookProvider(srv.URL).Wake(
+				context.Background(), []byte("sealed"),
+			)
+			a.Equal("application/octet-stream sealed", <-got)
+			if tc.wantErr {
+				a.ErrorContains(err, "400")
+				return
+			}
+			a.NoError(err)
+		})
+	}
+}
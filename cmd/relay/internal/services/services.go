@@ -1,6 +1,7 @@
 package services
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"log/slog"
@@ -10,6 +11,11 @@ import (
 	"github.com/kamune-org/kamune/cmd/relay/internal/ratelimit"
 )
 
+const (
+	defaultPushRegistrationTTL = 30 * 24 * time.Hour
+	defaultWakeTimeout         = 20 * time.Second
+)
+
 type Service struct {
 	hub       *Hub
 	sessions  *SessionManager
@@ -58,12 +64,19 @@ func New(ctx context.Context, cfg config.Config) (*Service, error) {
 		)
 	}
 
+	var push *PushRegistry
+	if cfg.Push.Enabled {
+		push = newPushRegistry(cfg, handshakeTimeout)
+		go push.cleanupLoop(ctx)
+	}
+
 	hub := NewHub(
 		sessions,
 		cfg.Server.Password,
 		cfg.Session.MaxMessageSize,
 		rl,
 		handshakeTimeout,
+		push,
 	)
 
 	go sessions.cleanupLoop(ctx)
@@ -86,6 +99,39 @@ func New(ctx context.Context, cfg config.Config) (*Service, error) {
 	}, nil
 }
 
+// newPushRegistry builds the push registry from cfg, applying the defaults
+// for unset values.
+func newPushRegistry(
+	cfg config.Config, handshakeTimeout time.Duration,
+) *PushRegistry {
+	ttl := cmp.Or(cfg.Push.RegistrationTTL, defaultPushRegistrationTTL)
+	wakeTimeout := cmp.Or(cfg.Push.WakeTimeout, defaultWakeTimeout)
+	maxEntries := cmp.Or(
+		cfg.Push.MaxRegistrations, cfg.Session.MaxConcurrentSessions,
+	)
+	if handshakeTimeout > 0 && wakeTimeout >= handshakeTimeout {
+		// A dialer held for a wakeup is still in its handshake.
+		slog.Warn(
+			"push wake timeout exceeds handshake timeout; "+
+				"dialers will be dropped before listeners wake up",
+			slog.Duration("wake_timeout", wakeTimeout),
+			slog.Duration("handshake_timeout", handshakeTimeout),
+		)
+	}
+
+	providers := make(map[string]PushProvider, len(cfg.Push.Providers))
+	for name, p := range cfg.Push.Providers {
+		providers[name] = NewWebhookProvider(p.URL)
+	}
+	slog.Info(
+		"push wakeups enabled",
+		slog.Int("providers", len(providers)),
+		slog.Duration("registration_ttl", ttl),
+		slog.Duration("wake_timeout", wakeTimeout),
+	)
+	return NewPushRegistry(providers, ttl, wakeTimeout, maxEntries)
+}
+
 func (s *Service) Hub() *Hub {
 	return s.hub
 }
@@ -58,10 +58,11 @@ func (m *model) updateChat(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.vp.GotoBottom()
 				return m, tiCmd
 			}
-			if err := m.store.AddChatEntry(
+			if err := m.store.AddChatEntryWithClock(
 				m.transport.SessionID(),
 				[]byte(text),
 				metadata.Timestamp(),
+				uint64(metadata.HybridTime()),
 				storage.SenderLocal,
 			); err != nil {
 				slog.Error("failed to persist sent chat entry",
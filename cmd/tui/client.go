@@ -4,11 +4,18 @@ import (
 	"fmt"
 
 	"github.com/kamune-org/kamune"
+	"github.com/kamune-org/kamune/pkg/fingerprint"
 	"github.com/kamune-org/kamune/pkg/storage"
 )
 
+// emojiSets lists the emoji sets fingerprints may be rendered with, as set
+// by the -emoji flag.
+var emojiSets = fingerprint.EmojiSets()
+
 func dial(addr string, store *storage.Storage, verifyFn kamune.RemoteVerifier) (*kamune.Transport, error) {
-	dialer, err := kamune.NewDialer(addr, store, verifyFn)
+	dialer, err := kamune.NewDialer(addr, store, verifyFn,
+		kamune.DialWithEmojiSets(emojiSets...),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("create dialer: %w", err)
 	}
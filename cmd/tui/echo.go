@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kamune-org/kamune"
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// acceptAll trusts every peer. The echo server and the benchmark talk to
+// strangers by design and use a throwaway identity, so there is nothing to
+// protect.
+func acceptAll(*storage.Storage, *storage.Peer) error { return nil }
+
+// runEcho serves kamune.NewEchoHandler on addr with an in-memory identity
+// until interrupted.
+func runEcho(addr string) error {
+	store, err := storage.OpenStorage(storage.WithInMemory())
+	if err != nil {
+		return fmt.Errorf("open storage: %w", err)
+	}
+	defer store.Close()
+
+	srv, err := kamune.NewServer(addr, kamune.NewEchoHandler(), store, acceptAll)
+	if err != nil {
+		return fmt.Errorf("create server: %w", err)
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.ListenAndServe() }()
+
+	fmt.Printf("Echo server listening on %s\n", addr)
+	fmt.Printf("Fingerprint: %s\n", fingerprint.Sum(srv.PublicKey()))
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("serve: %w", err)
+	case <-sig:
+		return srv.Close()
+	}
+}
+
+// runBench dials the echo server at addr with an in-memory identity and
+// prints the results of kamune.Benchmark.
+func runBench(addr string, count, size int) error {
+	store, err := storage.OpenStorage(storage.WithInMemory())
+	if err != nil {
+		return fmt.Errorf("open storage: %w", err)
+	}
+	defer store.Close()
+
+	t, err := dial(addr, store, acceptAll)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer t.Close()
+
+	fmt.Printf("Connected to %s (%s)\n",
+		addr, fingerprint.Sum(t.RemotePeer().PublicKey))
+	res, err := kamune.Benchmark(t, count, size)
+	if err != nil {
+		return fmt.Errorf("benchmark: %w", err)
+	}
+	fmt.Printf("Messages:   %d x %d bytes\n", res.Messages, size)
+	fmt.Printf("RTT:        min %s, mean %s, max %s\n",
+		res.MinRTT, res.MeanRTT, res.MaxRTT)
+	fmt.Printf("Throughput: %.1f KiB/s\n", res.Throughput()/1024)
+	return nil
+}
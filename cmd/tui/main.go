@@ -2,6 +2,8 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"os"
@@ -9,36 +11,68 @@ import (
 	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kamune-org/kamune/pkg/fingerprint"
 	"github.com/kamune-org/kamune/pkg/storage"
 	"golang.org/x/term"
 )
 
 func main() {
+	echoAddr := flag.String("echo", "",
+		"run a headless echo server on `addr` for interop testing")
+	benchAddr := flag.String("bench", "",
+		"measure RTT and throughput against the echo server at `addr`")
+	benchCount := flag.Int("bench-count", 100, "number of benchmark messages")
+	benchSize := flag.Int("bench-size", 1024, "benchmark message size in bytes")
+	profile := flag.String("profile", "",
+		"open the profile `name`, creating it if needed, instead of a "+
+			"database path")
+	emoji := flag.String("emoji",
+		fingerprint.FormatEmojiSets(fingerprint.EmojiSets()),
+		"comma-separated emoji `sets` the terminal renders well, for "+
+			"fingerprints")
+	flag.Parse()
+	emojiSets = fingerprint.ParseEmojiSets(*emoji)
+	if err := checkEmojiSets(emojiSets); err != nil {
+		slog.Error("emoji sets", "error", err)
+		os.Exit(2)
+	}
+
+	switch {
+	case *echoAddr != "":
+		if err := runEcho(*echoAddr); err != nil {
+			slog.Error("echo server", "error", err)
+			os.Exit(1)
+		}
+		return
+	case *benchAddr != "":
+		if err := runBench(*benchAddr, *benchCount, *benchSize); err != nil {
+			slog.Error("benchmark", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	fmt.Println("╔══════════════════════════════╗")
 	fmt.Println("║      Kamune Chat (TUI)        ║")
 	fmt.Println("╚══════════════════════════════╝")
 	fmt.Println()
 
-	dbPath := os.Getenv("KAMUNE_DB_PATH")
-	if dbPath == "" {
-		if home, err := os.UserHomeDir(); err == nil {
-			dbPath = filepath.Join(home, ".config", "kamune", "db")
-		} else {
-			dbPath = "./kamune.db"
+	var dbPath string
+	if *profile != "" {
+		p, err := openProfile(*profile)
+		if err != nil {
+			slog.Error("opening profile", "error", err)
+			os.Exit(1)
 		}
-	}
-
-	scanner := bufio.NewScanner(os.Stdin)
-	fmt.Printf("Database path [%s]: ", dbPath)
-	if scanner.Scan() {
-		if input := strings.TrimSpace(scanner.Text()); input != "" {
-			dbPath = input
+		fmt.Printf("Profile: %s\n", p.Name)
+		dbPath = p.DBPath()
+	} else {
+		var err error
+		if dbPath, err = promptDBPath(); err != nil {
+			slog.Error("reading db path", "error", err)
+			os.Exit(1)
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		slog.Error("reading db path", "error", err)
-		os.Exit(1)
-	}
 
 	pass := os.Getenv("KAMUNE_DB_PASSPHRASE")
 	if pass == "" {
@@ -72,3 +106,52 @@ func main() {
 		slog.Error("program run", "error", err)
 	}
 }
+
+// checkEmojiSets rejects an empty list of emoji sets, or one with unknown sets.
+func checkEmojiSets(ids []string) error {
+	if len(ids) == 0 {
+		return errors.New("no emoji sets given")
+	}
+	for _, id := range ids {
+		if _, ok := fingerprint.LookupEmojiSet(id); !ok {
+			return fmt.Errorf("unknown emoji set %q, want one of %s", id,
+				fingerprint.FormatEmojiSets(fingerprint.EmojiSets()))
+		}
+	}
+	return nil
+}
+
+// openProfile returns the profile named name in the default data directory,
+// creating it if it does not exist.
+func openProfile(name string) (storage.Profile, error) {
+	profiles, err := storage.NewProfiles("")
+	if err != nil {
+		return storage.Profile{}, err
+	}
+	p, err := profiles.Get(name)
+	if errors.Is(err, storage.ErrProfileNotFound) {
+		return profiles.Create(name)
+	}
+	return p, err
+}
+
+// promptDBPath asks for the database path, offering the default one.
+func promptDBPath() (string, error) {
+	dbPath := os.Getenv("KAMUNE_DB_PATH")
+	if dbPath == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			dbPath = filepath.Join(home, ".config", "kamune", "db")
+		} else {
+			dbPath = "./kamune.db"
+		}
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	fmt.Printf("Database path [%s]: ", dbPath)
+	if scanner.Scan() {
+		if input := strings.TrimSpace(scanner.Text()); input != "" {
+			dbPath = input
+		}
+	}
+	return dbPath, scanner.Err()
+}
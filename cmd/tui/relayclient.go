@@ -33,6 +33,7 @@ func relayDial(relayAddr, tokenHex, password string, store *storage.Storage, ver
 			sessionTTL = conn.SessionTTL()
 			return conn, nil
 		}),
+		kamune.DialWithEmojiSets(emojiSets...),
 	)
 	if err != nil {
 		return nil, 0, fmt.Errorf("create dialer: %w", err)
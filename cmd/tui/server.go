@@ -17,6 +17,7 @@ func serve(addr string, store *storage.Storage, verifyFn kamune.RemoteVerifier,
 		return nil
 	}
 
+	opts = append(opts, kamune.ServeWithEmojiSets(emojiSets...))
 	srv, err := kamune.NewServer(addr, handler, store, verifyFn, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("create server: %w", err)
@@ -66,9 +66,11 @@ type relayReadyMsg struct {
 type tickMsg time.Time
 
 type chatMessageMsg struct {
-	sender storage.Sender
-	text   string
-	time   time.Time
+	sender    storage.Sender
+	text      string
+	time      time.Time
+	clock     kamune.HybridTime
+	ephemeral bool
 }
 
 type peerDisconnectedMsg struct{}
@@ -316,7 +318,12 @@ func (m *model) mkVerifier() kamune.RemoteVerifier {
 			isNew = true
 		}
 		key := peer.PublicKey
-		emojiFP := strings.Join(fingerprint.Emoji(key), " • ")
+		set, err := kamune.PeerEmojiSet(peer, emojiSets)
+		if err != nil {
+			// The peer renders none of our sets; ours is as good as any.
+			set, _ = fingerprint.LookupEmojiSet(emojiSets[0])
+		}
+		emojiFP := strings.Join(set.Emoji(key), " • ")
 		hexFP := fingerprint.Hex(key)
 		respCh := make(chan error, 1)
 		m.program.Send(verifyRequest{
@@ -326,7 +333,7 @@ func (m *model) mkVerifier() kamune.RemoteVerifier {
 			hexFP:      hexFP,
 			responseCh: respCh,
 		})
-		err := <-respCh
+		err = <-respCh
 		if err == nil && isNew {
 			peer.FirstSeen = time.Now()
 			if serr := store.StorePeer(peer); serr != nil {
@@ -425,16 +432,6 @@ func (m *model) enterChat() (tea.Model, tea.Cmd) {
 		m.sessionExpiry = time.Now().Add(m.relaySessionTTL)
 	}
 
-	if peer := m.transport.RemotePeer(); peer != nil {
-		err := m.store.CreateSession(m.transport.SessionID(), peer.PublicKey)
-		if err != nil {
-			slog.Warn("failed to create session record",
-				slog.String("session_id", m.transport.SessionID()),
-				slog.Any("error", err),
-			)
-		}
-	}
-
 	m.ta = textarea.New()
 	m.ta.Placeholder = "Send a message..."
 	m.ta.Focus()
@@ -558,9 +555,11 @@ func (m *model) startReceiving() {
 
 			text := string(b.GetValue())
 			m.program.Send(chatMessageMsg{
-				sender: storage.SenderPeer,
-				text:   text,
-				time:   metadata.Timestamp(),
+				sender:    storage.SenderPeer,
+				text:      text,
+				time:      metadata.Timestamp(),
+				clock:     metadata.HybridTime(),
+				ephemeral: metadata.Ephemeral(),
 			})
 		}
 	}()
@@ -623,11 +622,12 @@ func (m *model) handleChatMessage(msg chatMessageMsg) *model {
 	m.messages = append(m.messages, prefix+m.s.peerText.Render(msg.text))
 	m.vp.SetContent(renderChatContent(m))
 	m.vp.GotoBottom()
-	if m.store != nil {
-		if err := m.store.AddChatEntry(
+	if m.store != nil && !msg.ephemeral {
+		if err := m.store.AddChatEntryWithClock(
 			m.transport.SessionID(),
 			[]byte(msg.text),
 			msg.time,
+			uint64(msg.clock),
 			storage.SenderPeer,
 		); err != nil {
 			slog.Error("failed to persist received chat entry",
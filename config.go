@@ -0,0 +1,450 @@
+package kamune
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// Config describes a dialer or a server, along with its storage and how it
+// verifies peers, in a form that can be kept in a file. It is turned into
+// options with [Config.DialOptions], [Config.ServerOptions], and
+// [Config.StorageOptions], and into a verifier with [Config.Verifier].
+// Options that take code rather than values, such as a listener, a schema
+// registry, or a passphrase handler, are appended by the caller.
+//
+// The zero value is valid and leaves every default in place.
+type Config struct {
+	Name         string             `json:"name"`
+	Services     []string           `json:"services"`
+	Transport    TransportConfig    `json:"transport"`
+	Storage      StorageConfig      `json:"storage"`
+	Verification VerificationConfig `json:"verification"`
+	Timeouts     TimeoutConfig      `json:"timeouts"`
+	Limits       LimitConfig        `json:"limits"`
+	Resumption   ResumptionConfig   `json:"resumption"`
+	Features     FeatureConfig      `json:"features"`
+}
+
+// TransportConfig selects the network and the connection deadlines.
+type TransportConfig struct {
+	// Address is the address the server listens on or the dialer connects
+	// to. It is passed to [NewServer] or [NewDialer] by the caller.
+	Address string `json:"address"`
+	// Network is "tcp", the default, "udp", or "websocket". Servers on
+	// websocket serve ws:// and leave TLS to a reverse proxy; dialers dial
+	// the address as described in [DialWithWebSocketConn].
+	Network      string   `json:"network"`
+	ReadTimeout  Duration `json:"read_timeout"`
+	WriteTimeout Duration `json:"write_timeout"`
+	// KCPProfile tunes UDP connections: "latency", "balanced", or
+	// "throughput" apply that profile, and "auto" switches between them; see
+	// [ConnWithKCPProfile] and [ConnWithKCPAutoTune]. KCP's defaults are
+	// used if it is empty.
+	KCPProfile string `json:"kcp_profile"`
+}
+
+// TimeoutConfig bounds connection setup; see [HandshakeTimeouts]. Dial only
+// applies to dialers.
+type TimeoutConfig struct {
+	Dial         Duration `json:"dial"`
+	Exchange     Duration `json:"exchange"`
+	Introduction Duration `json:"introduction"`
+	Handshake    Duration `json:"handshake"`
+	Challenge    Duration `json:"challenge"`
+	Resumption   Duration `json:"resumption"`
+}
+
+// ResumptionConfig controls whether a server lets sessions be resumed and
+// migrated; see [ServeWithResumeEnabled] and [ServeWithMigrationEnabled].
+// Both are enabled unless disabled here.
+type ResumptionConfig struct {
+	Disabled          bool `json:"disabled"`
+	MigrationDisabled bool `json:"migration_disabled"`
+}
+
+// StorageConfig describes the database; see [storage.OpenStorage]. Without a
+// path, the storage's default location is used.
+type StorageConfig struct {
+	Path           string   `json:"path"`
+	Expiry         Duration `json:"expiry"`
+	StatsRetention Duration `json:"stats_retention"`
+	AutoLock       Duration `json:"auto_lock"`
+	Timeout        Duration `json:"timeout"`
+	InMemory       bool     `json:"in_memory"`
+	NoPassphrase   bool     `json:"no_passphrase"`
+	SearchIndex    bool     `json:"search_index"`
+}
+
+// VerificationConfig selects how remote peers are verified; see
+// [Config.Verifier].
+type VerificationConfig struct {
+	Policy VerificationPolicy `json:"policy"`
+}
+
+// LimitConfig bounds what a server accepts and keeps. Zero leaves the
+// default of each limit in place.
+type LimitConfig struct {
+	// IntroductionMaxAge rejects introductions older than this; see
+	// [ServeWithIntroductionMaxAge].
+	IntroductionMaxAge Duration `json:"introduction_max_age"`
+	// ReplayCacheSize is the number of introductions remembered to reject
+	// replays; see [ServeWithIntroductionReplayCacheSize].
+	ReplayCacheSize int `json:"replay_cache_size"`
+	// Inbox replaces the handler with an inbox of this size; see
+	// [ServeWithInbox].
+	Inbox int `json:"inbox"`
+}
+
+// FeatureConfig enables optional features; see [ServeWithDedupEnabled],
+// [ServeWithJournalEnabled], and [ServeWithTimelineEnabled].
+type FeatureConfig struct {
+	Dedup    bool `json:"dedup"`
+	Journal  bool `json:"journal"`
+	Timeline bool `json:"timeline"`
+}
+
+// VerificationPolicy decides which remote peers are accepted.
+type VerificationPolicy string
+
+const (
+	// VerifyPrompt leaves the decision to the verifier the application
+	// passes to [Config.Verifier], typically one asking the user to compare
+	// fingerprints. It is the default.
+	VerifyPrompt VerificationPolicy = "prompt"
+	// VerifyKnown accepts only peers already in storage.
+	VerifyKnown VerificationPolicy = "known"
+	// VerifyFirstUse accepts known peers, and accepts and stores unknown
+	// ones, so a peer's key cannot change after the first session.
+	VerifyFirstUse VerificationPolicy = "tofu"
+	// VerifyAcceptAll accepts every peer without storing it. Sessions with
+	// unknown peers are then neither recorded nor resumable.
+	VerifyAcceptAll VerificationPolicy = "accept"
+)
+
+// Duration is a [time.Duration] written in configuration files as a string
+// such as "1m30s".
+type Duration time.Duration
+
+// MarshalText implements [encoding.TextMarshaler].
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler].
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LoadConfig reads a configuration file. Its format is chosen by its
+// extension: .toml, .yaml or .yml, or .json. Unknown keys are rejected, so
+// that a misspelled setting does not go unnoticed.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+
+	// TOML and YAML are brought to JSON first, so that the fields are
+	// named and checked the same way in every format.
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+	case ".toml":
+		var v map[string]any
+		if _, err := toml.Decode(string(data), &v); err != nil {
+			return nil, fmt.Errorf("decoding config: %w", err)
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return nil, fmt.Errorf("converting config: %w", err)
+		}
+	case ".yaml", ".yml":
+		var v map[string]any
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("decoding config: %w", err)
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return nil, fmt.Errorf("converting config: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", ext)
+	}
+
+	var c Config
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("decoding config: %w", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Validate reports the first setting of c that no option would accept.
+func (c *Config) Validate() error {
+	switch c.Transport.Network {
+	case "", "tcp", "udp", "websocket":
+	default:
+		return fmt.Errorf(
+			"transport.network must be tcp, udp or websocket, got %q",
+			c.Transport.Network,
+		)
+	}
+	switch c.Transport.KCPProfile {
+	case "", "auto", KCPLatency.Name, KCPBalanced.Name, KCPThroughput.Name:
+	default:
+		return fmt.Errorf(
+			"transport.kcp_profile must be latency, balanced, throughput, "+
+				"or auto, got %q",
+			c.Transport.KCPProfile,
+		)
+	}
+	switch c.Verification.Policy {
+	case "", VerifyPrompt, VerifyKnown, VerifyFirstUse, VerifyAcceptAll:
+	default:
+		return fmt.Errorf(
+			"verification.policy must be prompt, known, tofu, or accept, "+
+				"got %q",
+			c.Verification.Policy,
+		)
+	}
+	for _, s := range c.Services {
+		if s == "" {
+			return errors.New("services must not contain an empty name")
+		}
+	}
+	if c.Storage.InMemory && c.Storage.Path != "" {
+		return errors.New("storage.path and storage.in_memory are exclusive")
+	}
+
+	durations := []struct {
+		name  string
+		value Duration
+	}{
+		{"transport.read_timeout", c.Transport.ReadTimeout},
+		{"transport.write_timeout", c.Transport.WriteTimeout},
+		{"timeouts.dial", c.Timeouts.Dial},
+		{"timeouts.exchange", c.Timeouts.Exchange},
+		{"timeouts.introduction", c.Timeouts.Introduction},
+		{"timeouts.handshake", c.Timeouts.Handshake},
+		{"timeouts.challenge", c.Timeouts.Challenge},
+		{"timeouts.resumption", c.Timeouts.Resumption},
+		{"storage.expiry", c.Storage.Expiry},
+		{"storage.stats_retention", c.Storage.StatsRetention},
+		{"storage.auto_lock", c.Storage.AutoLock},
+		{"storage.timeout", c.Storage.Timeout},
+		{"limits.introduction_max_age", c.Limits.IntroductionMaxAge},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			return fmt.Errorf(
+				"%s must be >= 0, got %s", d.name, time.Duration(d.value),
+			)
+		}
+	}
+	if c.Limits.ReplayCacheSize < 0 {
+		return fmt.Errorf(
+			"limits.replay_cache_size must be >= 0, got %d",
+			c.Limits.ReplayCacheSize,
+		)
+	}
+	if c.Limits.Inbox < 0 {
+		return fmt.Errorf(
+			"limits.inbox must be >= 0, got %d", c.Limits.Inbox,
+		)
+	}
+	return nil
+}
+
+// DialOptions returns the dial options c describes. Settings that only apply
+// to servers are ignored.
+func (c *Config) DialOptions() []DialOption {
+	var opts []DialOption
+	if c.Timeouts.Dial > 0 {
+		opts = append(opts, DialWithDialTimeout(time.Duration(c.Timeouts.Dial)))
+	}
+	switch conn := c.connOptions(); {
+	case c.Transport.Network == "udp":
+		opts = append(opts, DialWithUDP(conn...))
+	case c.Transport.Network == "websocket":
+		opts = append(opts, DialWithWebSocketConn(nil, conn...))
+	case len(conn) > 0:
+		opts = append(opts, DialWithTCP(conn...))
+	}
+	if ht := c.handshakeTimeouts(); ht != (HandshakeTimeouts{}) {
+		opts = append(opts, DialWithHandshakeTimeouts(ht))
+	}
+	if c.Name != "" {
+		opts = append(opts, DialWithClientName(c.Name))
+	}
+	return append(opts,
+		DialWithDedupEnabled(c.Features.Dedup),
+		DialWithJournalEnabled(c.Features.Journal),
+		DialWithTimelineEnabled(c.Features.Timeline),
+	)
+}
+
+// ServerOptions returns the server options c describes. Settings that only
+// apply to dialers are ignored. With the udp or websocket network, or with
+// connection deadlines, [NewServer] starts listening right away.
+func (c *Config) ServerOptions() []ServerOptions {
+	var opts []ServerOptions
+	switch conn := c.connOptions(); {
+	case c.Transport.Network == "udp":
+		opts = append(opts, ServeWithUDP(conn...))
+	case c.Transport.Network == "websocket":
+		opts = append(opts, ServeWithWebSocket(nil, conn...))
+	case len(conn) > 0:
+		opts = append(opts, ServeWithTCP(conn...))
+	}
+	if ht := c.handshakeTimeouts(); ht != (HandshakeTimeouts{}) {
+		opts = append(opts, ServeWithHandshakeTimeouts(ht))
+	}
+	if c.Name != "" {
+		opts = append(opts, ServeWithServerName(c.Name))
+	}
+	if len(c.Services) > 0 {
+		opts = append(opts, ServeWithServices(c.Services...))
+	}
+	if c.Limits.IntroductionMaxAge > 0 {
+		opts = append(opts, ServeWithIntroductionMaxAge(
+			time.Duration(c.Limits.IntroductionMaxAge),
+		))
+	}
+	if c.Limits.ReplayCacheSize > 0 {
+		opts = append(opts, ServeWithIntroductionReplayCacheSize(
+			c.Limits.ReplayCacheSize,
+		))
+	}
+	if c.Limits.Inbox > 0 {
+		opts = append(opts, ServeWithInbox(c.Limits.Inbox))
+	}
+	return append(opts,
+		ServeWithResumeEnabled(!c.Resumption.Disabled),
+		ServeWithMigrationEnabled(!c.Resumption.MigrationDisabled),
+		ServeWithDedupEnabled(c.Features.Dedup),
+		ServeWithJournalEnabled(c.Features.Journal),
+		ServeWithTimelineEnabled(c.Features.Timeline),
+	)
+}
+
+// StorageOptions returns the options for [storage.OpenStorage] that c
+// describes.
+func (c *Config) StorageOptions() []storage.StorageOption {
+	s := c.Storage
+	var opts []storage.StorageOption
+	switch {
+	case s.InMemory:
+		opts = append(opts, storage.WithInMemory())
+	case s.Path != "":
+		opts = append(opts, storage.WithDBPath(s.Path))
+	}
+	if s.NoPassphrase {
+		opts = append(opts, storage.WithNoPassphrase())
+	}
+	if s.Expiry > 0 {
+		opts = append(opts, storage.WithExpiryDuration(time.Duration(s.Expiry)))
+	}
+	if s.StatsRetention > 0 {
+		opts = append(opts, storage.WithStatsRetention(
+			time.Duration(s.StatsRetention),
+		))
+	}
+	if s.AutoLock > 0 {
+		opts = append(opts, storage.WithAutoLock(time.Duration(s.AutoLock)))
+	}
+	if s.Timeout > 0 {
+		opts = append(opts, storage.WithTimeout(time.Duration(s.Timeout)))
+	}
+	if s.SearchIndex {
+		opts = append(opts, storage.WithSearchIndex(true))
+	}
+	return opts
+}
+
+// Verifier returns the remote verifier for the configured policy. With the
+// prompt policy, which is the default, it returns prompt, which must then
+// not be nil; the other policies ignore it.
+func (c *Config) Verifier(prompt RemoteVerifier) (RemoteVerifier, error) {
+	switch c.Verification.Policy {
+	case "", VerifyPrompt:
+		if prompt == nil {
+			return nil, errors.New(
+				"verification policy prompt needs a verifier",
+			)
+		}
+		return prompt, nil
+	case VerifyKnown:
+		return func(store *storage.Storage, peer *storage.Peer) error {
+			if _, err := store.FindPeer(peer.PublicKey); err != nil {
+				return fmt.Errorf("%w: unknown peer", ErrVerificationFailed)
+			}
+			return nil
+		}, nil
+	case VerifyFirstUse:
+		return func(store *storage.Storage, peer *storage.Peer) error {
+			if _, err := store.FindPeer(peer.PublicKey); err == nil {
+				return nil
+			}
+			return store.StorePeer(peer)
+		}, nil
+	case VerifyAcceptAll:
+		return func(*storage.Storage, *storage.Peer) error { return nil }, nil
+	default:
+		return nil, fmt.Errorf(
+			"unknown verification policy %q", c.Verification.Policy,
+		)
+	}
+}
+
+func (c *Config) connOptions() []ConnOption {
+	var opts []ConnOption
+	if c.Transport.ReadTimeout > 0 {
+		opts = append(opts, ConnWithReadTimeout(
+			time.Duration(c.Transport.ReadTimeout),
+		))
+	}
+	if c.Transport.WriteTimeout > 0 {
+		opts = append(opts, ConnWithWriteTimeout(
+			time.Duration(c.Transport.WriteTimeout),
+		))
+	}
+	switch c.Transport.KCPProfile {
+	case "auto":
+		opts = append(opts, ConnWithKCPAutoTune(0))
+	case KCPLatency.Name:
+		opts = append(opts, ConnWithKCPProfile(KCPLatency))
+	case KCPBalanced.Name:
+		opts = append(opts, ConnWithKCPProfile(KCPBalanced))
+	case KCPThroughput.Name:
+		opts = append(opts, ConnWithKCPProfile(KCPThroughput))
+	}
+	return opts
+}
+
+func (c *Config) handshakeTimeouts() HandshakeTimeouts {
+	t := c.Timeouts
+	return HandshakeTimeouts{
+		Exchange:     time.Duration(t.Exchange),
+		Introduction: time.Duration(t.Introduction),
+		Handshake:    time.Duration(t.Handshake),
+		Challenge:    time.Duration(t.Challenge),
+		Resumption:   time.Duration(t.Resumption),
+	}
+}
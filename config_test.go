@@ -0,0 +1,319 @@
+package kamune
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/attest"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	a := require.New(t)
+	path := filepath.Join(t.TempDir(), name)
+	a.NoError(os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	want := &Config{
+		Name:     "alice",
+		Services: []string{"chat", "files"},
+		Transport: TransportConfig{
+			Address:     "127.0.0.1:9000",
+			Network:     "udp",
+			ReadTimeout: Duration(time.Minute),
+		},
+		Timeouts: TimeoutConfig{
+			Dial:      Duration(5 * time.Second),
+			Handshake: Duration(1500 * time.Millisecond),
+		},
+		Storage: StorageConfig{
+			Path:         "/var/lib/kamune/db",
+			Expiry:       Duration(720 * time.Hour),
+			NoPassphrase: true,
+		},
+		Verification: VerificationConfig{Policy: VerifyFirstUse},
+		Limits:       LimitConfig{ReplayCacheSize: 1024},
+		Resumption:   ResumptionConfig{MigrationDisabled: true},
+		Features:     FeatureConfig{Dedup: true},
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "toml",
+			file: "kamune.toml",
+			content: `
+name = "alice"
+services = ["chat", "files"]
+
+[transport]
+address = "127.0.0.1:9000"
+network = "udp"
+read_timeout = "1m"
+
+[timeouts]
+dial = "5s"
+handshake = "1.5s"
+
+[storage]
+path = "/var/lib/kamune/db"
+expiry = "720h"
+no_passphrase = true
+
+[verification]
+policy = "tofu"
+
+[limits]
+replay_cache_size = 1024
+
+[resumption]
+migration_disabled = true
+
+[features]
+dedup = true
+`,
+		},
+		{
+			name: "yaml",
+			file: "kamune.yml",
+			content: `
+name: alice
+services: [chat, files]
+transport:
+  address: 127.0.0.1:9000
+  network: udp
+  read_timeout: 1m
+timeouts:
+  dial: 5s
+  handshake: 1.5s
+storage:
+  path: /var/lib/kamune/db
+  expiry: 720h
+  no_passphrase: true
+verification:
+  policy: tofu
+limits:
+  replay_cache_size: 1024
+resumption:
+  migration_disabled: true
+features:
+  dedup: true
+`,
+		},
+		{
+			name: "json",
+			file: "kamune.json",
+			content: `{
+	"name": "alice",
+	"services": ["chat", "files"],
+	"transport": {
+		"address": "127.0.0.1:9000",
+		"network": "udp",
+		"read_timeout": "1m"
+	},
+	"timeouts": {"dial": "5s", "handshake": "1.5s"},
+	"storage": {
+		"path": "/var/lib/kamune/db",
+		"expiry": "720h",
+		"no_passphrase": true
+	},
+	"verification": {"policy": "tofu"},
+	"limits": {"replay_cache_size": 1024},
+	"resumption": {"migration_disabled": true},
+	"features": {"dedup": true}
+}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			c, err := LoadConfig(writeConfig(t, tc.file, tc.content))
+			a.NoError(err)
+			a.Equal(want, c)
+		})
+	}
+}
+
+func TestLoadConfigRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{name: "unknown format", file: "kamune.ini", content: "name = x"},
+		{name: "unknown key", file: "kamune.toml", content: "nmae = \"x\""},
+		{
+			name:    "unknown nested key",
+			file:    "kamune.yaml",
+			content: "transport:\n  netwrok: udp\n",
+		},
+		{
+			name:    "bad duration",
+			file:    "kamune.json",
+			content: `{"timeouts": {"dial": "soon"}}`,
+		},
+		{
+			name:    "negative duration",
+			file:    "kamune.json",
+			content: `{"timeouts": {"dial": "-1s"}}`,
+		},
+		{
+			name:    "bad network",
+			file:    "kamune.toml",
+			content: "[transport]\nnetwork = \"sctp\"",
+		},
+		{
+			name:    "bad kcp profile",
+			file:    "kamune.toml",
+			content: "[transport]\nkcp_profile = \"fast\"",
+		},
+		{
+			name:    "bad policy",
+			file:    "kamune.toml",
+			content: "[verification]\npolicy = \"maybe\"",
+		},
+		{
+			name:    "path and in memory",
+			file:    "kamune.json",
+			content: `{"storage": {"path": "db", "in_memory": true}}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			_, err := LoadConfig(writeConfig(t, tc.file, tc.content))
+			a.Error(err)
+		})
+	}
+}
+
+func TestConfigOptions(t *testing.T) {
+	a := require.New(t)
+	c := &Config{
+		Name:     "node",
+		Services: []string{"chat"},
+		Transport: TransportConfig{
+			ReadTimeout:  Duration(time.Minute),
+			WriteTimeout: Duration(time.Minute),
+		},
+		Timeouts: TimeoutConfig{
+			Dial:     Duration(3 * time.Second),
+			Exchange: Duration(time.Second),
+		},
+		Storage:    StorageConfig{InMemory: true, SearchIndex: true},
+		Limits:     LimitConfig{IntroductionMaxAge: Duration(time.Minute)},
+		Resumption: ResumptionConfig{Disabled: true},
+		Features:   FeatureConfig{Dedup: true, Journal: true},
+	}
+	store, err := storage.OpenStorage(c.StorageOptions()...)
+	a.NoError(err)
+	defer store.Close()
+
+	d, err := NewDialer("127.0.0.1:0", store, acceptAll, c.DialOptions()...)
+	a.NoError(err)
+	a.Equal("node", d.clientName)
+	a.Equal(3*time.Second, d.dialTimeout)
+	a.Equal(time.Second, d.handshakeOpts.timeouts.Exchange)
+	a.True(d.journal)
+	a.NotNil(d.dialFunc)
+
+	s, err := NewServer(
+		"127.0.0.1:0", nil, store, acceptAll, c.ServerOptions()...,
+	)
+	a.NoError(err)
+	defer s.Close()
+	a.Equal("node", s.serverName)
+	a.Equal([]string{"chat"}, s.handshakeOpts.intro.services)
+	a.Equal(time.Minute, s.introMaxAge)
+	a.False(s.resumeEnabled)
+	a.True(s.migrationEnabled)
+	a.True(s.journal)
+	a.NotNil(s.listener)
+
+	// The zero config keeps the defaults.
+	var zero Config
+	s, err = NewServer("", nil, store, acceptAll, zero.ServerOptions()...)
+	a.NoError(err)
+	a.True(s.resumeEnabled)
+	a.True(s.migrationEnabled)
+	a.Nil(s.listener)
+
+	// The websocket network listens right away.
+	ws := Config{Transport: TransportConfig{Network: "websocket"}}
+	a.NoError(ws.Validate())
+	s, err = NewServer(
+		"127.0.0.1:0", nil, store, acceptAll, ws.ServerOptions()...,
+	)
+	a.NoError(err)
+	defer s.Close()
+	a.IsType(&wsListener{}, s.listener)
+}
+
+func TestConfigVerifier(t *testing.T) {
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	known, err := attest.New()
+	a.NoError(err)
+	a.NoError(store.StorePeer(&storage.Peer{
+		Name: "known", PublicKey: known.MarshalPublicKey(),
+	}))
+	newPeer := func(t *testing.T) *storage.Peer {
+		a := require.New(t)
+		at, err := attest.New()
+		a.NoError(err)
+		return &storage.Peer{Name: "new", PublicKey: at.MarshalPublicKey()}
+	}
+
+	tests := []struct {
+		policy     VerificationPolicy
+		acceptNew  bool
+		storesNew  bool
+		needPrompt bool
+	}{
+		{policy: "", needPrompt: true},
+		{policy: VerifyPrompt, needPrompt: true},
+		{policy: VerifyKnown},
+		{policy: VerifyFirstUse, acceptNew: true, storesNew: true},
+		{policy: VerifyAcceptAll, acceptNew: true},
+	}
+	for _, tc := range tests {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			a := require.New(t)
+			c := &Config{Verification: VerificationConfig{Policy: tc.policy}}
+			if tc.needPrompt {
+				_, err := c.Verifier(nil)
+				a.Error(err)
+				rv, err := c.Verifier(storePeer)
+				a.NoError(err)
+				a.NotNil(rv)
+				return
+			}
+			rv, err := c.Verifier(nil)
+			a.NoError(err)
+
+			a.NoError(rv(store, &storage.Peer{
+				Name: "known", PublicKey: known.MarshalPublicKey(),
+			}))
+			peer := newPeer(t)
+			err = rv(store, peer)
+			if !tc.acceptNew {
+				a.ErrorIs(err, ErrVerificationFailed)
+				return
+			}
+			a.NoError(err)
+			_, err = store.FindPeer(peer.PublicKey)
+			a.Equal(tc.storesNew, err == nil)
+		})
+	}
+}
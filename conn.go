@@ -36,6 +36,9 @@ type conn struct {
 	conn                 net.Conn
 	readDeadline         time.Duration
 	writeDeadline        time.Duration
+	kcpProfile           *KCPProfile
+	kcpTune              time.Duration
+	kcp                  *kcpState
 	readMu               sync.Mutex
 	writeMu              sync.Mutex
 	closed               atomic.Bool
@@ -45,6 +48,9 @@ func (c *conn) Close() error {
 	if !c.closed.CompareAndSwap(false, true) {
 		return ErrConnClosed
 	}
+	if c.kcp != nil {
+		c.kcp.close()
+	}
 	return c.conn.Close()
 }
 
@@ -290,6 +296,7 @@ func newConn(c net.Conn, opts ...ConnOption) *conn {
 	for _, opt := range opts {
 		opt(cn)
 	}
+	cn.kcp = newKCPState(c, cn.kcpProfile, cn.kcpTune)
 
 	return cn
 }
@@ -0,0 +1,125 @@
+package kamune
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// connLimitSweepInterval is how often a server forgets the addresses whose
+// connection bucket has refilled, so that the buckets of past peers do not
+// pile up.
+const connLimitSweepInterval = time.Minute
+
+// connLimiter holds a token bucket of connections for every remote IP
+// address; see [ServeWithConnectionRateLimit]. A nil limiter admits every
+// connection.
+type connLimiter struct {
+	buckets map[string]*connBucket
+	swept   time.Time
+	rate    float64
+	burst   float64
+	mu      sync.Mutex
+}
+
+type connBucket struct {
+	last   time.Time
+	tokens float64
+}
+
+func newConnLimiter(rate float64, burst int) *connLimiter {
+	return &connLimiter{
+		buckets: make(map[string]*connBucket),
+		rate:    rate,
+		burst:   float64(burst),
+	}
+}
+
+// admit reports whether a connection from ip may be set up at now, taking a
+// token from its bucket if so.
+func (l *connLimiter) admit(ip string, now time.Time) bool {
+	if l == nil || ip == "" {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) >= connLimitSweepInterval {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &connBucket{last: now, tokens: l.burst}
+		l.buckets[ip] = b
+	}
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*l.rate, l.burst)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep drops the buckets that have refilled by now, which are no different
+// from new ones. It must be called with mu held.
+func (l *connLimiter) sweep(now time.Time) {
+	for ip, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+	l.swept = now
+}
+
+// remoteIP returns the IP address cn was accepted from, or an empty string if
+// it has none, as with connections through a relay.
+func remoteIP(cn Conn) string {
+	ra, ok := cn.(interface{ RemoteAddr() net.Addr })
+	if !ok || ra.RemoteAddr() == nil {
+		return ""
+	}
+	addr := ra.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// ServeWithConnectionRateLimit limits how fast each remote IP address may
+// open connections, to blunt handshake floods: every address has a token
+// bucket that refills at perIP connections per second up to burst, and
+// connections beyond it are closed as soon as they are accepted, before any
+// key exchange or signature check. They are counted in
+// [ServerStatus.Throttled]. Connections without an IP address of their own,
+// such as those through a relay, are not limited. Without it, every address
+// may connect as fast as it likes.
+func ServeWithConnectionRateLimit(perIP float64, burst int) ServerOptions {
+	return func(s *Server) error {
+		switch {
+		case perIP <= 0:
+			return errors.New("connection rate limit must be positive")
+		case burst < 1:
+			return errors.New(
+				"connection rate limit burst must be at least one",
+			)
+		}
+		s.connLimit = newConnLimiter(perIP, burst)
+		return nil
+	}
+}
+
+// ServeWithMaxConcurrentHandshakes caps the connections the server sets up
+// at once to n; connections accepted beyond it are closed before any key
+// exchange. It is a shorthand for [ServeWithHandshakePool] with n workers and
+// no queue; use the pool to let connections wait instead. It cannot be
+// combined with the pool.
+func ServeWithMaxConcurrentHandshakes(n int) ServerOptions {
+	return func(s *Server) error {
+		if n < 1 {
+			return errors.New("concurrent handshakes must be at least one")
+		}
+		return s.setHandshakes(HandshakePool{Workers: n})
+	}
+}
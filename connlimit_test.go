@@ -0,0 +1,102 @@
+package kamune
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnLimiter(t *testing.T) {
+	a := require.New(t)
+	l := newConnLimiter(1, 2)
+	now := time.Now()
+
+	a.True(l.admit("10.0.0.1", now))
+	a.True(l.admit("10.0.0.1", now))
+	a.False(l.admit("10.0.0.1", now))
+	// Addresses have buckets of their own.
+	a.True(l.admit("10.0.0.2", now))
+	// Connections without an address are not limited.
+	a.True(l.admit("", now))
+
+	now = now.Add(time.Second)
+	a.True(l.admit("10.0.0.1", now))
+	a.False(l.admit("10.0.0.1", now))
+
+	// Refilled buckets are forgotten.
+	now = now.Add(connLimitSweepInterval)
+	a.True(l.admit("10.0.0.3", now))
+	a.Len(l.buckets, 1)
+
+	var none *connLimiter
+	a.True(none.admit("10.0.0.1", now))
+}
+
+func TestServeWithConnectionRateLimit(t *testing.T) {
+	a := require.New(t)
+	_, err := NewServer("", nil, nil, nil, ServeWithConnectionRateLimit(0, 1))
+	a.Error(err)
+	_, err = NewServer("", nil, nil, nil, ServeWithConnectionRateLimit(1, 0))
+	a.Error(err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	srv, err := NewServer(
+		"", NewEchoHandler(), store, storePeer,
+		ServeWithListener(&tcpListener{Listener: l}),
+		ServeWithConnectionRateLimit(0.001, 1),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	dialStore, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(l.Addr().String(), dialStore, storePeer)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	_, err = d.Dial()
+	a.Error(err)
+	a.EqualValues(1, srv.Status().Throttled)
+}
+
+func TestServeWithMaxConcurrentHandshakes(t *testing.T) {
+	a := require.New(t)
+	_, err := NewServer("", nil, nil, nil, ServeWithMaxConcurrentHandshakes(0))
+	a.Error(err)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	srv, err := NewServer(
+		"", NewEchoHandler(), store, storePeer,
+		ServeWithMaxConcurrentHandshakes(3),
+	)
+	a.NoError(err)
+	st := srv.Status().Handshakes
+	a.NotNil(st)
+	a.Equal(3, st.Workers)
+	a.True(srv.handshakes.admit())
+	a.True(srv.handshakes.admit())
+	a.True(srv.handshakes.admit())
+	a.False(srv.handshakes.admit())
+
+	for _, opts := range [][]ServerOptions{
+		{
+			ServeWithMaxConcurrentHandshakes(3),
+			ServeWithHandshakePool(HandshakePool{Workers: 1}),
+		},
+		{
+			ServeWithHandshakePool(HandshakePool{Workers: 1}),
+			ServeWithMaxConcurrentHandshakes(3),
+		},
+	} {
+		_, err = NewServer("", NewEchoHandler(), store, storePeer, opts...)
+		a.Error(err, "limits would replace each other")
+	}
+}
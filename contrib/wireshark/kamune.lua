@@ -0,0 +1,103 @@
+-- Wireshark dissector for the kamune protocol over TCP.
+--
+-- It splits the stream into length-prefixed frames (SPEC §4.1) and labels
+-- each frame with its phase, using the frame classification of SPEC §4.4.
+-- Frame contents are encrypted; see README.md for decrypting transport
+-- frames with a key log.
+--
+-- Install by copying this file into Wireshark's personal plugins directory,
+-- then use "Decode As..." on the server's port, or set the port in the
+-- protocol preferences.
+
+local kamune = Proto("kamune", "Kamune")
+
+local f_length = ProtoField.uint16("kamune.length", "Length", base.DEC)
+local f_phase = ProtoField.string("kamune.phase", "Phase")
+local f_payload = ProtoField.bytes("kamune.payload", "Payload")
+local f_nonce = ProtoField.bytes("kamune.nonce", "Nonce")
+local f_ciphertext = ProtoField.bytes("kamune.ciphertext", "Ciphertext")
+local f_tag = ProtoField.bytes("kamune.tag", "Tag")
+kamune.fields = {
+	f_length, f_phase, f_payload, f_nonce, f_ciphertext, f_tag,
+}
+
+kamune.prefs.port = Pref.uint("TCP port", 0, "Server port (0 to disable)")
+
+-- Sizes of SPEC §6.1.1 and §12.7.
+local HPKE_PUBLIC_KEY = 1216
+local HPKE_ENC = 1120
+local HPKE_MERGED = 2 + HPKE_ENC + HPKE_PUBLIC_KEY
+local HPKE_TAG = 16
+local NONCE = 24
+local TAG = 16
+local BUCKETS = { 512, 1024, 4096, 16384, 32768, 65495 }
+
+local function is_bucket(n)
+	for _, b in ipairs(BUCKETS) do
+		if n == b then
+			return true
+		end
+	end
+	return false
+end
+
+-- classify names the phase of a frame of the given length.
+local function classify(len)
+	if len == HPKE_PUBLIC_KEY then
+		return "Exchange: HPKE public key", false
+	elseif len == HPKE_MERGED then
+		return "Exchange: HPKE encapsulation and public key", false
+	elseif len == HPKE_ENC then
+		return "Exchange: HPKE encapsulation", false
+	elseif is_bucket(len - HPKE_TAG) then
+		return "Handshake: sealed envelope", false
+	elseif is_bucket(len - HPKE_TAG - NONCE - TAG) then
+		return "Handshake: sealed challenge", false
+	elseif is_bucket(len - NONCE - TAG) then
+		return "Transport: encrypted envelope", true
+	end
+	return "Unknown", false
+end
+
+local function frame_length(tvb, pinfo, offset)
+	return tvb(offset, 2):uint() + 2
+end
+
+local function dissect_frame(tvb, pinfo, tree)
+	local len = tvb(0, 2):uint()
+	local phase, transport = classify(len)
+
+	pinfo.cols.protocol = "Kamune"
+	pinfo.cols.info:append(" [" .. phase .. "]")
+
+	local subtree = tree:add(kamune, tvb(), "Kamune Frame")
+	subtree:add(f_length, tvb(0, 2))
+	subtree:add(f_phase, phase):set_generated()
+	if transport then
+		subtree:add(f_nonce, tvb(2, NONCE))
+		subtree:add(f_ciphertext, tvb(2 + NONCE, len - NONCE - TAG))
+		subtree:add(f_tag, tvb(2 + len - TAG, TAG))
+	else
+		subtree:add(f_payload, tvb(2, len))
+	end
+	return len + 2
+end
+
+function kamune.dissector(tvb, pinfo, tree)
+	pinfo.cols.info:clear()
+	dissect_tcp_pdus(tvb, tree, 2, frame_length, dissect_frame)
+end
+
+local registered = 0
+function kamune.prefs_changed()
+	local tcp = DissectorTable.get("tcp.port")
+	if registered ~= 0 then
+		tcp:remove(registered, kamune)
+	end
+	registered = kamune.prefs.port
+	if registered ~= 0 then
+		tcp:add(registered, kamune)
+	end
+end
+
+DissectorTable.get("tcp.port"):add_for_decode_as(kamune)
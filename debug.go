@@ -0,0 +1,213 @@
+package kamune
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+)
+
+// numRoutes sizes the per-route counters; routes are dense from RouteInvalid.
+const numRoutes = int(RouteTypedMessage) + 1
+
+// DebugDump is a point-in-time snapshot of a session, meant to be attached to
+// bug reports. By default it holds no secrets: the peer is identified by its
+// fingerprint, introduction metadata is reduced to its keys, and the
+// resumption root is left out. See [DebugIncludeUnsafeSecrets].
+type DebugDump struct {
+	Established time.Time `json:"established"`
+	// Captured is when the snapshot was taken.
+	Captured time.Time `json:"captured"`
+	// Sent and Received count the delivered messages per route name.
+	Sent     map[string]uint64 `json:"sent"`
+	Received map[string]uint64 `json:"received"`
+	Config   DebugConfig       `json:"config"`
+	// Secrets is only set with [DebugIncludeUnsafeSecrets].
+	Secrets *DebugSecrets `json:"secrets,omitempty"`
+	// Phase is "established", "resumed", or "closed".
+	Phase        string         `json:"phase"`
+	SessionID    string         `json:"sessionId"`
+	Peer         DebugPeer      `json:"peer"`
+	Stats        TransportStats `json:"stats"`
+	Uptime       time.Duration  `json:"uptime"`
+	SendSequence uint64         `json:"sendSequence"`
+	RecvSequence uint64         `json:"recvSequence"`
+}
+
+// DebugPeer identifies the remote peer of a session without its public key.
+type DebugPeer struct {
+	Fingerprint string `json:"fingerprint"`
+	Name        string `json:"name"`
+	AppVersion  string `json:"appVersion"`
+	// MetadataKeys lists the keys of the peer's introduction metadata.
+	MetadataKeys []string `json:"metadataKeys,omitempty"`
+}
+
+// DebugConfig is the negotiated configuration of a session.
+type DebugConfig struct {
+	Service      string   `json:"service,omitempty"`
+	Role         string   `json:"role,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	// PendingSends is the number of queued messages per priority lane.
+	PendingSends map[string]int `json:"pendingSends"`
+	ReadTimeout  time.Duration  `json:"readTimeout,omitempty"`
+	WriteTimeout time.Duration  `json:"writeTimeout,omitempty"`
+	Deduplicated bool           `json:"deduplicated"`
+}
+
+// DebugSecrets holds the session secrets included in an unsafe dump, encoded
+// in standard base64. Anyone holding them can resume or migrate the session.
+type DebugSecrets struct {
+	PeerPublicKey  string `json:"peerPublicKey"`
+	ResumptionRoot string `json:"resumptionRoot,omitempty"`
+	// Metadata is the peer's introduction metadata, values in base64.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type debugConfig struct {
+	unsafeSecrets bool
+}
+
+// DebugOption configures [Transport.DebugDump] and
+// [SessionRegistry.ExportDiagnostics].
+type DebugOption func(*debugConfig)
+
+// DebugIncludeUnsafeSecrets adds the session's secrets and the peer's full
+// identity to a dump. A dump taken with it lets its reader take over the
+// session; never attach one to a public report.
+func DebugIncludeUnsafeSecrets() DebugOption {
+	return func(c *debugConfig) { c.unsafeSecrets = true }
+}
+
+// DebugDump returns a snapshot of the session's state for diagnostics. It is
+// safe to call concurrently with Send and Receive.
+func (t *Transport) DebugDump(opts ...DebugOption) DebugDump {
+	var cfg debugConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	now := time.Now()
+	d := DebugDump{
+		Captured:    now,
+		Established: t.established,
+		SessionID:   t.sessionID,
+		Phase:       "established",
+		Stats:       t.Stats(),
+		Sent:        routeCounts(&t.stats.routesSent),
+		Received:    routeCounts(&t.stats.routesReceived),
+	}
+	switch {
+	case t.closed.Load():
+		d.Phase = "closed"
+	case t.resumed:
+		d.Phase = "resumed"
+	}
+	if !t.established.IsZero() {
+		d.Uptime = now.Sub(t.established)
+	}
+	t.mu.Lock()
+	d.SendSequence, d.RecvSequence = t.sendSequence, t.recvSequence
+	cn := t.conn
+	t.mu.Unlock()
+
+	d.Config = DebugConfig{
+		Service:      t.service,
+		Role:         t.role,
+		Deduplicated: t.Deduplicated(),
+		PendingSends: make(map[string]int, numPriorities),
+	}
+	for p, n := range t.queue.pending() {
+		d.Config.PendingSends[Priority(p).String()] = n
+	}
+	if c, ok := cn.(*conn); ok {
+		d.Config.ReadTimeout = c.readDeadline
+		d.Config.WriteTimeout = c.writeDeadline
+	}
+
+	if peer := t.remotePeer; peer != nil {
+		d.Config.Capabilities = peer.Capabilities
+		d.Peer.Fingerprint = fingerprint.Sum(peer.PublicKey)
+		d.Peer.Name = peer.Name
+		d.Peer.AppVersion = peer.AppVersion
+		for k := range peer.Metadata {
+			d.Peer.MetadataKeys = append(d.Peer.MetadataKeys, k)
+		}
+		slices.Sort(d.Peer.MetadataKeys)
+	}
+
+	if cfg.unsafeSecrets {
+		d.Secrets = t.debugSecrets()
+	}
+	return d
+}
+
+func (t *Transport) debugSecrets() *DebugSecrets {
+	s := &DebugSecrets{}
+	if t.resumptionRoot != nil {
+		s.ResumptionRoot = base64.StdEncoding.EncodeToString(t.resumptionRoot)
+	}
+	if peer := t.remotePeer; peer != nil {
+		s.PeerPublicKey = base64.StdEncoding.EncodeToString(peer.PublicKey)
+		if len(peer.Metadata) > 0 {
+			s.Metadata = make(map[string]string, len(peer.Metadata))
+			for k, v := range peer.Metadata {
+				s.Metadata[k] = base64.StdEncoding.EncodeToString(v)
+			}
+		}
+	}
+	return s
+}
+
+// routeCounts returns the non-zero counters of c by route name.
+func routeCounts(c *[numRoutes]atomic.Uint64) map[string]uint64 {
+	counts := make(map[string]uint64)
+	for r := range c {
+		if n := c[r].Load(); n > 0 {
+			counts[Route(r).String()] = n
+		}
+	}
+	return counts
+}
+
+// countRoute increments the counter of route in c, ignoring invalid routes.
+func countRoute(c *[numRoutes]atomic.Uint64, route Route) {
+	if route.IsValid() {
+		c[route].Add(1)
+	}
+}
+
+// ExportDiagnostics writes the dumps of all live sessions to w as a JSON
+// document, ordered by session ID. Unless [DebugIncludeUnsafeSecrets] is
+// given, the output holds no secrets and can be attached to a bug report.
+func (r *SessionRegistry) ExportDiagnostics(
+	w io.Writer, opts ...DebugOption,
+) error {
+	sessions := r.Sessions()
+	slices.SortFunc(sessions, func(a, b *Transport) int {
+		return strings.Compare(a.sessionID, b.sessionID)
+	})
+	report := struct {
+		Captured time.Time   `json:"captured"`
+		Sessions []DebugDump `json:"sessions"`
+	}{
+		Captured: time.Now(),
+		Sessions: make([]DebugDump, 0, len(sessions)),
+	}
+	for _, t := range sessions {
+		report.Sessions = append(report.Sessions, t.DebugDump(opts...))
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("encoding diagnostics: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package kamune
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+)
+
+func TestTransport_DebugDump(t *testing.T) {
+	a := require.New(t)
+	addr, _, _ := startEchoServer(
+		t, ServeWithIntroductionMetadata(map[string][]byte{
+			"tenant": []byte("secret-tenant"),
+		}),
+	)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+
+	// The handshake already used some sequence numbers.
+	before := tr.DebugDump()
+	for range 2 {
+		_, err := tr.Send(Bytes([]byte("hi")), RouteExchangeMessages)
+		a.NoError(err)
+		_, err = tr.Receive(Bytes(nil))
+		a.NoError(err)
+	}
+
+	dump := tr.DebugDump()
+	a.Equal("established", dump.Phase)
+	a.Equal(tr.SessionID(), dump.SessionID)
+	a.Equal(before.SendSequence+2, dump.SendSequence)
+	a.Equal(before.RecvSequence+2, dump.RecvSequence)
+	a.EqualValues(2, dump.Sent[RouteExchangeMessages.String()])
+	a.EqualValues(2, dump.Received[RouteExchangeMessages.String()])
+	a.Equal(fingerprint.Sum(tr.RemotePeer().PublicKey), dump.Peer.Fingerprint)
+	a.Equal([]string{"tenant"}, dump.Peer.MetadataKeys)
+	a.Nil(dump.Secrets)
+
+	// Nothing secret reaches the redacted export.
+	var buf bytes.Buffer
+	a.NoError(d.SessionRegistry().ExportDiagnostics(&buf))
+	for _, secret := range [][]byte{
+		tr.resumptionRoot, tr.RemotePeer().PublicKey, []byte("secret-tenant"),
+	} {
+		a.NotContains(buf.String(), base64.StdEncoding.EncodeToString(secret))
+		a.NotContains(buf.String(), string(secret))
+	}
+	var report struct {
+		Sessions []DebugDump `json:"sessions"`
+	}
+	a.NoError(json.Unmarshal(buf.Bytes(), &report))
+	a.Len(report.Sessions, 1)
+	a.Equal(dump.SessionID, report.Sessions[0].SessionID)
+
+	unsafe := tr.DebugDump(DebugIncludeUnsafeSecrets())
+	a.NotNil(unsafe.Secrets)
+	a.Equal(
+		base64.StdEncoding.EncodeToString(tr.resumptionRoot),
+		unsafe.Secrets.ResumptionRoot,
+	)
+	a.Equal(
+		base64.StdEncoding.EncodeToString([]byte("secret-tenant")),
+		unsafe.Secrets.Metadata["tenant"],
+	)
+
+	a.NoError(tr.Close())
+	a.Equal("closed", tr.DebugDump().Phase)
+}
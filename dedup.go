@@ -0,0 +1,181 @@
+package kamune
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+const (
+	// capabilityDedup is advertised in the introduction by peers that resolve
+	// payload references.
+	capabilityDedup = "dedup/v1"
+
+	// dedupMinSize is the smallest encoded message that is deduplicated.
+	// Smaller messages fit in the first padding buckets, where a reference
+	// saves nothing.
+	dedupMinSize = 1024
+
+	// dedupCacheSize caps the payload bytes each side of a session remembers
+	// per direction.
+	dedupCacheSize = 4 * 1024 * 1024
+)
+
+// dedupCache remembers the payloads sent in one direction of a session. The
+// sender and the receiver each keep one and apply the same operations in
+// sequence order, so the sender knows exactly which payloads the receiver can
+// resolve from a reference, without asking.
+//
+// Only the receiver needs the payloads themselves; the sender keeps sizes.
+type dedupCache struct {
+	entries map[[sha256.Size]byte]*list.Element
+	order   *list.List
+	size    int
+	mu      sync.Mutex
+	keep    bool
+}
+
+type dedupEntry struct {
+	payload []byte
+	sum     [sha256.Size]byte
+	size    int
+}
+
+func newDedupCache(keep bool) *dedupCache {
+	return &dedupCache{
+		entries: make(map[[sha256.Size]byte]*list.Element),
+		order:   list.New(),
+		keep:    keep,
+	}
+}
+
+// reference returns the digest of payload if it is large enough to be
+// deduplicated, and whether the receiver already holds it. The cache is not
+// changed until the message is committed with add.
+func (c *dedupCache) reference(payload []byte) ([]byte, bool) {
+	if c == nil || len(payload) < dedupMinSize {
+		return nil, false
+	}
+	sum := sha256.Sum256(payload)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[sum]
+	return sum[:], ok
+}
+
+// add records a payload as the most recently used, evicting the least
+// recently used ones beyond dedupCacheSize.
+func (c *dedupCache) add(sum, payload []byte) {
+	key := [sha256.Size]byte(sum)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	entry := &dedupEntry{sum: key, size: len(payload)}
+	if c.keep {
+		entry.payload = slices.Clone(payload)
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	c.size += entry.size
+	for c.size > dedupCacheSize {
+		oldest := c.order.Remove(c.order.Back()).(*dedupEntry)
+		delete(c.entries, oldest.sum)
+		c.size -= oldest.size
+	}
+}
+
+// resolve returns the payload of a received message: msg itself, or the
+// cached payload that ref points to. It mirrors what the sender did with the
+// message, so it must be called for every message in sequence order.
+func (c *dedupCache) resolve(ref, msg []byte) ([]byte, error) {
+	if ref == nil {
+		if sum, _ := c.reference(msg); sum != nil {
+			c.add(sum, msg)
+		}
+		return msg, nil
+	}
+	if c == nil || len(ref) != sha256.Size {
+		return nil, ErrUnknownReference
+	}
+	c.mu.Lock()
+	e, ok := c.entries[[sha256.Size]byte(ref)]
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %x", ErrUnknownReference, ref)
+	}
+	payload := e.Value.(*dedupEntry).payload
+	c.add(ref, payload)
+	return payload, nil
+}
+
+// negotiate enables the optional features that both sides advertised. The
+// peer's capabilities are persisted so that a resumed session, which skips
+// the introduction, can restore them with loadCapabilities, and so that its
+// next introduction can be checked against them by checkDowngrade. Its name
+// is recorded for checkRename likewise, and the frame format for
+// loadFrameFormat.
+func (t *Transport) negotiate(store *storage.Storage, local []string) {
+	recordParameters(store, t.remotePeer)
+	recordName(store, t.remotePeer)
+	if remote := t.remotePeer.Capabilities; len(remote) > 0 {
+		_ = store.SetMeta(t.sessionID, storage.NewBytesMeta(
+			storage.CapabilitiesKey, []byte(strings.Join(remote, ",")),
+		))
+	}
+	t.enableCapabilities(local)
+	t.saveFrameFormat(store)
+}
+
+// loadCapabilities restores the peer's capabilities of a resumed session and
+// enables the features both sides support. The session keeps the frame
+// format it was established with.
+func (t *Transport) loadCapabilities(store *storage.Storage, local []string) {
+	m, err := store.GetMeta(t.sessionID, storage.CapabilitiesKey)
+	if err == nil && len(m.Value()) > 0 {
+		t.remotePeer.Capabilities = strings.Split(string(m.Value()), ",")
+	}
+	t.enableCapabilities(local)
+	t.loadFrameFormat(store)
+}
+
+// setCapability adds c to caps, or removes it when enabled is false.
+func setCapability(caps []string, c string, enabled bool) []string {
+	caps = slices.DeleteFunc(slices.Clone(caps), func(s string) bool {
+		return s == c
+	})
+	if enabled {
+		caps = append(caps, c)
+	}
+	return caps
+}
+
+func (t *Transport) enableCapabilities(local []string) {
+	remote := t.remotePeer.Capabilities
+	t.serde.limit = maxMessageSize(remote)
+	t.receiveLimit = maxMessageSize(local)
+	t.addressBook = slices.Contains(local, capabilityAddresses)
+	t.wipeable = slices.Contains(local, capabilityWipe)
+	t.groupable = slices.Contains(local, capabilityGroups)
+	t.acking = slices.Contains(local, capabilityAck)
+	// Peers that share no frame format are turned away by checkFrameFormats
+	// before they get this far.
+	if f, err := negotiateFrameFormat(local, remote); err == nil {
+		t.setFrameFormat(f)
+	}
+	if slices.Contains(local, capabilityDedup) &&
+		slices.Contains(remote, capabilityDedup) {
+		t.outbound = newDedupCache(false)
+		t.inbound = newDedupCache(true)
+	}
+}
+
+// Deduplicated reports whether payload deduplication is in effect for the
+// session; see [ServeWithDedupEnabled].
+func (t *Transport) Deduplicated() bool { return t.outbound != nil }
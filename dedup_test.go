@@ -0,0 +1,109 @@
+package kamune
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupCache_Mirror(t *testing.T) {
+	a := require.New(t)
+	sender, receiver := newDedupCache(false), newDedupCache(true)
+	// send returns the reference the sender puts on the wire for payload,
+	// and what the receiver makes of it.
+	send := func(payload []byte) ([]byte, []byte, error) {
+		sum, cached := sender.reference(payload)
+		var ref, data []byte
+		if cached {
+			ref = sum
+		} else {
+			data = payload
+		}
+		if sum != nil {
+			sender.add(sum, payload)
+		}
+		got, err := receiver.resolve(ref, data)
+		return ref, got, err
+	}
+
+	small := []byte("hello")
+	large := bytes.Repeat([]byte("a"), dedupMinSize)
+	tests := []struct {
+		name    string
+		payload []byte
+		wantRef bool
+	}{
+		{name: "small", payload: small},
+		{name: "small again", payload: small},
+		{name: "large", payload: large},
+		{name: "large again", payload: large, wantRef: true},
+	}
+	for _, tc := range tests {
+		ref, got, err := send(tc.payload)
+		a.NoError(err, tc.name)
+		a.Equal(tc.wantRef, ref != nil, tc.name)
+		a.Equal(tc.payload, got, tc.name)
+	}
+
+	// Filling the cache evicts the least recently used payload on both sides.
+	for i := range dedupCacheSize / dedupMinSize {
+		filler := bytes.Repeat([]byte{byte(i), byte(i >> 8)}, dedupMinSize/2)
+		_, _, err := send(filler)
+		a.NoError(err)
+	}
+	ref, got, err := send(large)
+	a.NoError(err)
+	a.Nil(ref, "evicted")
+	a.Equal(large, got)
+
+	_, err = receiver.resolve(bytes.Repeat([]byte{1}, 32), nil)
+	a.ErrorIs(err, ErrUnknownReference)
+	var disabled *dedupCache
+	_, err = disabled.resolve(bytes.Repeat([]byte{1}, 32), nil)
+	a.ErrorIs(err, ErrUnknownReference)
+}
+
+func TestTransport_Dedup(t *testing.T) {
+	tests := []struct {
+		name   string
+		server bool
+		dialer bool
+	}{
+		{name: "both", server: true, dialer: true},
+		{name: "server only", server: true},
+		{name: "dialer only", dialer: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			addr, _, _ := startEchoServer(t, ServeWithDedupEnabled(tc.server))
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			d, err := NewDialer(
+				addr, store, acceptAll, DialWithDedupEnabled(tc.dialer),
+			)
+			a.NoError(err)
+			tr, err := d.Dial()
+			a.NoError(err)
+			defer tr.Close()
+
+			enabled := tc.server && tc.dialer
+			a.Equal(enabled, tr.Deduplicated())
+			payload := bytes.Repeat([]byte("kamune"), 2000)
+			for range 3 {
+				_, err := tr.Send(Bytes(payload), RouteExchangeMessages)
+				a.NoError(err)
+				reply := Bytes(nil)
+				_, err = tr.Receive(reply)
+				a.NoError(err)
+				a.Equal(payload, reply.GetValue())
+			}
+			if enabled {
+				a.EqualValues(2, tr.Stats().Deduplicated)
+			} else {
+				a.Zero(tr.Stats().Deduplicated)
+			}
+		})
+	}
+}
@@ -1,61 +1,249 @@
 package kamune
 
 import (
+	"context"
+	"crypto/hmac"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"runtime/debug"
+	"sync"
 	"time"
 
-	"github.com/xtaci/kcp-go/v5"
-
+	"github.com/kamune-org/kamune/internal/box/pb"
 	"github.com/kamune-org/kamune/pkg/attest"
 	"github.com/kamune-org/kamune/pkg/exchange"
-	"github.com/kamune-org/kamune/pkg/fingerprint"
 	"github.com/kamune-org/kamune/pkg/storage"
 )
 
 // Dialer handles outgoing connections and initiates handshakes.
 type Dialer struct {
-	attest        *attest.Attest
-	storage       *storage.Storage
-	dialFunc      func(addr string) (Conn, error)
-	clientName    string
-	address       string
-	handshakeOpts handshakeOpts
-	connOpts      []ConnOption
-	dialTimeout   time.Duration
+	attest           *attest.Attest
+	storage          *storage.Storage
+	registry         *SessionRegistry
+	state            *dialerState
+	directory        *Directory
+	rateLimit        *RateLimit
+	tracer           *Tracer
+	udpPath          *udpPath
+	dialFunc         func(addr string) (Conn, error)
+	peerKey          []byte
+	clientName       string
+	address          string
+	handshakeOpts    handshakeOpts
+	connOpts         []ConnOption
+	dialTimeout      time.Duration
+	approvalTimeout  time.Duration
+	retransmitWindow int
+	journal          bool
+	timeline         bool
+	sharedStorage    bool
+}
+
+// dialerState is the state that a [Dialer] shares with the copies made by
+// [Dialer.DialService], so that [Dialer.Shutdown] reaches their dials too.
+type dialerState struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	pending map[Conn]struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	closed  bool
+}
+
+func newDialerState() *dialerState {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &dialerState{
+		ctx:     ctx,
+		cancel:  cancel,
+		pending: make(map[Conn]struct{}),
+	}
+}
+
+// enter registers a dial in flight, or returns [ErrClosedDialer] once the
+// dialer is shut down. Each successful call must be paired with wg.Done.
+func (s *dialerState) enter() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosedDialer
+	}
+	s.wg.Add(1)
+	return nil
+}
+
+// watch records cn as being set up, so that shutting down closes it. It
+// reports false if the dialer was shut down while cn was being dialed.
+func (s *dialerState) watch(cn Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.pending[cn] = struct{}{}
+	return true
+}
+
+func (s *dialerState) unwatch(cn Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, cn)
+}
+
+func (s *dialerState) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
 }
 
 // Dial establishes a connection and performs the handshake.
 func (d *Dialer) Dial() (*Transport, error) {
-	cn, err := d.dial(d.address)
+	if err := d.state.enter(); err != nil {
+		return nil, err
+	}
+	defer d.state.wg.Done()
+	if d.udpPath != nil {
+		return d.dialPaths()
+	}
+
+	cn, err := d.dialAnnounced()
 	if err != nil {
+		if d.state.isClosed() {
+			return nil, ErrClosedDialer
+		}
 		return nil, fmt.Errorf("dialing: %w", err)
 	}
+	if !d.state.watch(cn) {
+		cn.Close()
+		return nil, ErrClosedDialer
+	}
+	defer d.state.unwatch(cn)
 
 	transport, err := d.handshake(cn)
 	if err != nil {
 		cn.Close()
+		if d.state.isClosed() {
+			return nil, ErrClosedDialer
+		}
 		return nil, fmt.Errorf("handshake: %w", err)
 	}
 
 	return transport, nil
 }
 
+// Shutdown closes the dialer and everything it holds. It cancels the dials in
+// flight, closes every session in its [SessionRegistry] as
+// [Transport.Close] does, and then closes its storage, releasing the
+// database lock, unless it is shared (see [DialWithSharedStorage]). The
+// sessions' statistics and resumption tokens are recorded before the storage
+// is closed, so a later dialer over the same database can resume them with
+// [DialWithResume].
+//
+// If ctx ends before the sessions have closed, the remaining connections are
+// dropped, the storage is closed all the same, and ctx's error is returned.
+// Dials made after Shutdown fail with [ErrClosedDialer]; calling it again
+// returns nil.
+func (d *Dialer) Shutdown(ctx context.Context) error {
+	s := d.state
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.cancel()
+	for cn := range s.pending {
+		_ = cn.Close()
+	}
+	s.mu.Unlock()
+
+	// Sessions established by dials still in flight are registered by the
+	// time those dials return, so wait for them before closing the sessions.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.wg.Wait()
+		var wg sync.WaitGroup
+		for _, t := range d.registry.Sessions() {
+			wg.Go(func() { _ = t.Close() })
+		}
+		wg.Wait()
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		for _, t := range d.registry.Sessions() {
+			_ = t.currentConn().Close()
+		}
+	}
+	if d.sharedStorage {
+		return err
+	}
+	if cerr := d.storage.Close(); cerr != nil {
+		err = errors.Join(err, fmt.Errorf("closing storage: %w", cerr))
+	}
+	return err
+}
+
+// DialService connects to addr and requests the named service, as offered by
+// the server through [ServeWithServices]. The server's introduction lists the
+// services it offers; if the requested one is not among them, DialService
+// fails with [ErrServiceUnavailable] before the handshake and before the
+// server is verified. The service is available to both handlers through
+// [Transport.Service].
+func (d *Dialer) DialService(addr, service string) (*Transport, error) {
+	sd := *d
+	sd.address = addr
+	sd.handshakeOpts.intro.service = service
+	sd.udpPath = nil
+	sd.peerKey = nil
+	return sd.Dial()
+}
+
+// Clone returns a dialer for addr that shares d's identity, storage,
+// [SessionRegistry], and shutdown, so that hub applications can dial many
+// servers, concurrently if they like, over a single storage handle instead of
+// opening one per server, which the database does not allow. The clone has
+// d's options with opts applied on top, except for the session set by
+// [DialWithResume], the path set by [DialWithUDPPath], and the peer set by
+// [DialWithAnnouncedAddresses], which belong to d's address. Shutting down
+// either dialer shuts down both, and closes their sessions.
+func (d *Dialer) Clone(addr string, opts ...DialOption) (*Dialer, error) {
+	c := *d
+	c.address = addr
+	c.handshakeOpts.sessionID = ""
+	c.udpPath = nil
+	c.peerKey = nil
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
 func (d *Dialer) dial(addr string) (Conn, error) {
 	if d.dialFunc != nil {
 		return d.dialFunc(addr)
 	}
 	// defaults to TCP
-	c, err := net.DialTimeout("tcp", addr, d.dialTimeout)
-	if err != nil {
-		return nil, fmt.Errorf("dialing tcp: %w", err)
-	}
-	return newConn(c, d.connOpts...), nil
+	return d.dialTCP(addr)
+}
+
+func (d *Dialer) handshake(cn Conn) (*Transport, error) {
+	return d.handshakeOver(cn, nil)
 }
 
-func (d *Dialer) handshake(cn Conn) (t *Transport, err error) {
+// handshakeOver runs the handshake on cn, starting with the exchange unless
+// ec is the channel of one already run on it.
+func (d *Dialer) handshakeOver(
+	cn Conn, ec *exchange.Channel,
+) (t *Transport, err error) {
 	defer func() {
 		if msg := recover(); msg != nil {
 			slog.Error(
@@ -69,19 +257,30 @@ func (d *Dialer) handshake(cn Conn) (t *Transport, err error) {
 	}()
 
 	// Bound the handshake to avoid indefinite blocking.
-	_ = cn.SetDeadline(time.Now().Add(d.handshakeOpts.timeout))
-	defer func() { _ = cn.SetDeadline(time.Time{}) }()
+	opts := d.handshakeOpts
+	opts.timer = newStepTimer(cn, opts.timeout, opts.timeouts)
+	opts.timer.trace = d.tracer.start()
+	defer func() {
+		err = opts.timer.wrap(err)
+		if err != nil {
+			opts.timer.trace.failed(err)
+		}
+		opts.timer.finish()
+	}()
 
 	// Step 0: Exchange HPKE keys to derive an encrypted connection for the
 	// handshake
-	ec, err := exchange.Initiate(cn)
-	if err != nil {
-		return nil, fmt.Errorf("initiate exchange: %w", err)
+	opts.timer.begin(StepExchange)
+	if ec == nil {
+		ec, err = exchange.Initiate(cn)
+		if err != nil {
+			return nil, fmt.Errorf("initiate exchange: %w", err)
+		}
 	}
 
 	// Attempt resumption if sessionID is provided.
-	if d.handshakeOpts.sessionID != "" {
-		t, err = d.attemptResume(ec, cn)
+	if opts.sessionID != "" {
+		t, err = d.attemptResume(ec, cn, opts)
 		if err != nil {
 			return nil, fmt.Errorf("attempt resume: %w", err)
 		}
@@ -89,12 +288,19 @@ func (d *Dialer) handshake(cn Conn) (t *Transport, err error) {
 	}
 
 	// Step 1: Send our introduction
-	err = sendIntroduction(ec, d.attest, d.clientName, AppVersion)
+	opts.timer.begin(StepIntroduction)
+	name := localName(d.storage, d.attest, d.clientName)
+	err = sendIntroduction(
+		ec, d.attest, name, AppVersion, d.handshakeOpts.intro,
+	)
 	if err != nil {
 		return nil, fmt.Errorf("send introduction: %w", err)
 	}
+	opts.timer.trace.sent(RouteIdentity)
 
-	// Step 2: Receive peer's introduction
+	// Step 2: Receive peer's introduction, which a server holding the
+	// connection for its application's approval sends only once approved.
+	opts.timer.extend(d.approvalTimeout)
 	st, err := readSignedTransport(ec)
 	if err != nil {
 		return nil, fmt.Errorf("read transport: %w", err)
@@ -105,6 +311,7 @@ func (d *Dialer) handshake(cn Conn) (t *Transport, err error) {
 	if err != nil {
 		return nil, fmt.Errorf("extracting route: %w", err)
 	}
+	opts.timer.trace.received(r)
 	if r != RouteIdentity {
 		return nil, fmt.Errorf(
 			"%w: expected %s, got %s", ErrUnexpectedRoute, RouteIdentity, r,
@@ -120,13 +327,34 @@ func (d *Dialer) handshake(cn Conn) (t *Transport, err error) {
 		return nil, fmt.Errorf("version check: %w", err)
 	}
 
-	if err := d.handshakeOpts.remoteVerifier(d.storage, peer); err != nil {
+	service := d.handshakeOpts.intro.service
+	if err := checkService(peer.Services, service); err != nil {
+		return nil, err
+	}
+	err = checkFrameFormats(d.handshakeOpts.intro.capabilities, peer)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkBlocked(d.storage, peer.PublicKey); err != nil {
+		return nil, err
+	}
+	if err := d.checkExpectedPeer(peer); err != nil {
+		return nil, err
+	}
+	checkDowngrade(d.storage, peer)
+	checkRename(d.storage, peer)
+
+	if err := d.verifyRemote(peer, st); err != nil {
 		return nil, fmt.Errorf("verify remote: %w", err)
 	}
 	serde := newSignedSerde(peer.PublicKey, d.attest)
 
 	// Step 3: Proceed with the handshake
-	t, err = requestHandshake(ec, serde, d.handshakeOpts)
+	opts.negotiation = negotiationHash(
+		localIntroParams(opts.intro), peerIntroParams(peer),
+	)
+	t, err = requestHandshake(ec, serde, opts)
 	if err != nil {
 		return nil, fmt.Errorf("request handshake: %w", err)
 	}
@@ -135,6 +363,14 @@ func (d *Dialer) handshake(cn Conn) (t *Transport, err error) {
 	// derived from the handshake, we can switch to the plain connection.
 	t.conn = cn
 	t.remotePeer = peer
+	t.bindStorage(d.storage, false)
+	t.journal = d.journal
+	t.timeline = d.timeline
+	t.limit(d.rateLimit)
+	t.record(d.storage)
+	t.setService(d.storage, service)
+	t.negotiate(d.storage, d.handshakeOpts.intro.capabilities)
+	t.enableRetransmit(d.retransmitWindow)
 	_ = d.storage.SetMeta(t.sessionID, storage.NewByteSlicesMeta(
 		storage.ResumptionTokensKey, t.deriveResumptionTokens(),
 	))
@@ -144,7 +380,10 @@ func (d *Dialer) handshake(cn Conn) (t *Transport, err error) {
 		slog.String("session_id", t.sessionID),
 		slog.String("peer", peer.Name),
 	)
+	opts.timer.trace.phase("established")
+	t.recordTimeline(storage.TimelineEstablished, "")
 
+	d.track(t)
 	return t, nil
 }
 
@@ -152,9 +391,9 @@ func (d *Dialer) handshake(cn Conn) (t *Transport, err error) {
 // or an error if resumption failed (caller should fall back to cold
 // Introduction).
 func (d *Dialer) attemptResume(
-	ec *exchange.Channel, cn Conn,
+	ec *exchange.Channel, cn Conn, opts handshakeOpts,
 ) (*Transport, error) {
-	sessionID := d.handshakeOpts.sessionID
+	sessionID := opts.sessionID
 	token, err := d.storage.PopList(sessionID, storage.ResumptionTokensKey)
 	if err != nil {
 		return nil, fmt.Errorf("getting resumption token: %w", err)
@@ -163,40 +402,206 @@ func (d *Dialer) attemptResume(
 	if err != nil {
 		return nil, fmt.Errorf("getting session peer: %w", err)
 	}
+	if err := checkBlocked(d.storage, peer.PublicKey); err != nil {
+		return nil, err
+	}
+	if err := d.checkExpectedPeer(peer); err != nil {
+		return nil, err
+	}
+	if d.directory != nil {
+		if err := d.directory.verify(d.storage, d.address, peer); err != nil {
+			return nil, err
+		}
+	}
 
 	// Send ResumeRequest.
-	err = sendResumeRequest(ec, d.attest, sessionID, token)
+	opts.timer.trace.session(sessionID)
+	opts.timer.begin(StepResumption)
+	err = sendResumeRequest(
+		ec, d.attest, sessionID, token, receivedCount(d.storage, sessionID),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("sending resume request: %w", err)
 	}
+	opts.timer.trace.sent(RouteResumeRequest)
 
 	// Receive ResumeAccept.
-	accepted, reason, err := receiveResumeAccept(ec, peer.PublicKey)
-	switch {
-	case err != nil:
+	accepted, reason, peerReceived, err := receiveResumeAccept(
+		ec, peer.PublicKey,
+	)
+	if err != nil {
 		return nil, fmt.Errorf("receiving resume accept: %w", err)
-	case !accepted:
+	}
+	opts.timer.trace.received(RouteResumeAccept)
+	if !accepted {
 		return nil, fmt.Errorf("%w: %s", ErrResumptionRejected, reason)
 	}
 
 	// Resume accepted — proceed to handshake with predetermined session ID.
 	serde := newSignedSerde(peer.PublicKey, d.attest)
-	t, err := requestHandshake(ec, serde, d.handshakeOpts)
+	t, err := requestHandshake(ec, serde, opts)
 	if err != nil {
 		return nil, fmt.Errorf("request handshake after resume: %w", err)
 	}
 
 	t.conn = cn
 	t.remotePeer = peer
+	t.bindStorage(d.storage, true)
+	t.journal = d.journal
+	t.timeline = d.timeline
+	t.limit(d.rateLimit)
+	t.loadService(d.storage)
+	t.loadCapabilities(d.storage, d.handshakeOpts.intro.capabilities)
+	t.enableRetransmit(d.retransmitWindow)
 	_ = d.storage.SetMeta(t.sessionID, storage.NewByteSlicesMeta(
 		storage.ResumptionTokensKey, t.deriveResumptionTokens(),
 	))
 
 	slog.Info("session resumed", slog.String("session_id", t.sessionID))
+	opts.timer.trace.phase("resumed")
+	t.recordTimeline(storage.TimelineResumed, "")
+
+	if err := t.resend(peerReceived); err != nil {
+		_ = t.Close()
+		return nil, fmt.Errorf("resending missed messages: %w", err)
+	}
 
+	d.track(t)
 	return t, nil
 }
 
+// verifyRemote verifies the server with the directory set through
+// [DialWithDirectory], or with the claims in its introduction st if a
+// [ClaimVerifier] accepts them, or failing that, with the [RemoteVerifier].
+func (d *Dialer) verifyRemote(
+	peer *storage.Peer, st *pb.SignedTransport,
+) error {
+	if d.directory != nil {
+		return d.directory.verify(d.storage, d.address, peer)
+	}
+	verified, err := d.handshakeOpts.claimVerifier.verify(d.storage, peer, st)
+	if err != nil || verified {
+		return err
+	}
+	return d.handshakeOpts.remoteVerifier(d.storage, peer)
+}
+
+// track registers t in the dialer's [SessionRegistry] until it is closed,
+// and has it follow the reconnect hints of a draining server.
+func (d *Dialer) track(t *Transport) {
+	t.followHints = true
+	t.untrack = func() { d.registry.remove(t) }
+	d.registry.add(t)
+}
+
+// SessionRegistry returns the registry of the sessions established by the
+// dialer. Unlike a server's, its sessions are only removed by
+// [Transport.Close].
+func (d *Dialer) SessionRegistry() *SessionRegistry {
+	return d.registry
+}
+
+// Migrate moves an established session to a fresh connection, e.g. after the
+// local network changed from Wi-Fi to cellular. It dials the dialer's address
+// again and proves possession of the session keys over a new HPKE tunnel; no
+// introduction or key agreement is repeated. On success t is rebound to the
+// new connection, the old one is closed, and any goroutine blocked in
+// [Transport.Receive] continues on the new path.
+//
+// If the server no longer holds the session, [ErrMigrationRejected] is
+// returned and the caller should fall back to [DialWithResume].
+func (d *Dialer) Migrate(t *Transport) error {
+	if err := d.state.enter(); err != nil {
+		return err
+	}
+	defer d.state.wg.Done()
+
+	cn, err := d.dial(d.address)
+	if err != nil {
+		return fmt.Errorf("dialing: %w", err)
+	}
+	if !d.state.watch(cn) {
+		cn.Close()
+		return ErrClosedDialer
+	}
+	defer d.state.unwatch(cn)
+
+	if err := d.migrate(t, cn); err != nil {
+		cn.Close()
+		return fmt.Errorf("migrate: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Dialer) migrate(t *Transport, cn Conn) error {
+	// The deadline is cleared explicitly rather than deferred: once the
+	// session is rebound, a pending Receive may already be blocked on cn.
+	_ = cn.SetDeadline(time.Now().Add(d.handshakeOpts.timeout))
+
+	ec, err := exchange.Initiate(cn)
+	if err != nil {
+		return fmt.Errorf("initiate exchange: %w", err)
+	}
+
+	// The server closes the old connection as soon as it accepts, which
+	// may be before the accept reaches us.
+	defer t.beginMigration()()
+	binding, err := migrationBinding(ec)
+	if err != nil {
+		return err
+	}
+	nonce := randomBytes(migrationNonceSize)
+	proof, err := t.migrationProof(migrationRequestInfo, binding, nonce)
+	if err != nil {
+		return fmt.Errorf("computing migration proof: %w", err)
+	}
+	req := &pb.MigrateRequest{
+		SessionID: t.sessionID,
+		Nonce:     nonce,
+		Proof:     proof,
+		Sequence:  t.receivedSequence(),
+		Received:  t.receivedMessages(),
+	}
+	if err := sendSigned(ec, d.attest, req, RouteMigrateRequest); err != nil {
+		return fmt.Errorf("sending migrate request: %w", err)
+	}
+	t.trace.sent(RouteMigrateRequest)
+
+	st, err := readSignedTransport(ec)
+	if err != nil {
+		return fmt.Errorf("reading migrate accept: %w", err)
+	}
+	var resp pb.MigrateAccept
+	err = verifySigned(st, t.remotePeer.PublicKey, RouteMigrateAccept, &resp)
+	if err != nil {
+		return fmt.Errorf("receiving migrate accept: %w", err)
+	}
+	t.trace.received(RouteMigrateAccept)
+	if !resp.GetAccepted() {
+		return fmt.Errorf("%w: %s", ErrMigrationRejected, resp.GetReason())
+	}
+
+	expected, err := t.migrationProof(migrationAcceptInfo, binding, nonce)
+	if err != nil {
+		return fmt.Errorf("computing migration proof: %w", err)
+	}
+	if !hmac.Equal(expected, resp.GetProof()) {
+		return ErrVerificationFailed
+	}
+
+	_ = cn.SetDeadline(time.Time{})
+	t.migrate(cn, resp.GetSequence())
+	t.trace.phase("migrated")
+	if t.Retransmitting() {
+		if err := t.resend(resp.GetReceived()); err != nil {
+			_ = t.Close()
+			return fmt.Errorf("resending missed messages: %w", err)
+		}
+	}
+	return nil
+}
+
 // PublicKey returns the dialer's public key.
 func (d *Dialer) PublicKey() []byte {
 	return d.attest.MarshalPublicKey()
@@ -209,6 +614,8 @@ func NewDialer(
 	d := &Dialer{
 		address:     addr,
 		storage:     store,
+		registry:    newSessionRegistry(store),
+		state:       newDialerState(),
 		dialTimeout: 10 * time.Second,
 		handshakeOpts: handshakeOpts{
 			remoteVerifier: rv,
@@ -227,9 +634,6 @@ func NewDialer(
 		return nil, fmt.Errorf("loading attester: %w", err)
 	}
 	d.attest = at
-	if d.clientName == "" {
-		d.clientName = fingerprint.Sum(at.MarshalPublicKey())
-	}
 
 	return d, nil
 }
@@ -255,7 +659,8 @@ func DialWithFunc(fn func(addr string) (Conn, error)) DialOption {
 func DialWithTCP(opts ...ConnOption) DialOption {
 	return func(d *Dialer) error {
 		d.dialFunc = func(addr string) (Conn, error) {
-			c, err := net.DialTimeout("tcp", addr, d.dialTimeout)
+			nd := net.Dialer{Timeout: d.dialTimeout}
+			c, err := nd.DialContext(d.state.ctx, "tcp", addr)
 			if err != nil {
 				return nil, fmt.Errorf("dialing tcp: %w", err)
 			}
@@ -265,30 +670,57 @@ func DialWithTCP(opts ...ConnOption) DialOption {
 	}
 }
 
-// DialWithUDP configures the dialer to use UDP/KCP connections. The dialer
-// will fail at [NewDialer] time if the UDP dialer cannot be created.
-func DialWithUDP(opts ...ConnOption) DialOption {
+// DialWithDialTimeout sets the timeout for establishing connections.
+func DialWithDialTimeout(timeout time.Duration) DialOption {
 	return func(d *Dialer) error {
-		d.dialFunc = func(addr string) (Conn, error) {
-			c, err := kcp.Dial(addr)
-			if err != nil {
-				return nil, fmt.Errorf("dialing udp: %w", err)
-			}
-			return newConn(c, opts...), nil
+		d.dialTimeout = timeout
+		return nil
+	}
+}
+
+// DialWithHandshakeTimeouts bounds the individual steps of connection setup.
+// If the server stalls in one of them, [Dialer.Dial] fails with a
+// [*HandshakeTimeoutError] naming the step.
+func DialWithHandshakeTimeouts(timeouts HandshakeTimeouts) DialOption {
+	return func(d *Dialer) error {
+		if err := timeouts.validate(); err != nil {
+			return err
 		}
+		d.handshakeOpts.timeouts = timeouts
 		return nil
 	}
 }
 
-// DialWithDialTimeout sets the timeout for establishing connections.
-func DialWithDialTimeout(timeout time.Duration) DialOption {
+// DialWithApprovalTimeout gives the server up to timeout more to send its
+// introduction, on top of the handshake timeouts, for servers that hold
+// connections from unknown peers until their user approves them; see
+// [ServeWithPendingConnections].
+func DialWithApprovalTimeout(timeout time.Duration) DialOption {
 	return func(d *Dialer) error {
-		d.dialTimeout = timeout
+		if timeout < 0 {
+			return errors.New("approval timeout must be non-negative")
+		}
+		d.approvalTimeout = timeout
+		return nil
+	}
+}
+
+// DialWithTracer records the protocol activity of every connection the dialer
+// opens with tr; see [Tracer]. It is the dial-side equivalent of
+// [ServeWithTracer].
+func DialWithTracer(tr *Tracer) DialOption {
+	return func(d *Dialer) error {
+		if tr == nil {
+			return errors.New("tracer must not be nil")
+		}
+		d.tracer = tr
 		return nil
 	}
 }
 
-// DialWithClientName sets the client's advertised name.
+// DialWithClientName sets the client's advertised name. Without it, the
+// storage's display name is advertised, or the fingerprint of the public key
+// if it has none.
 func DialWithClientName(name string) DialOption {
 	return func(d *Dialer) error {
 		d.clientName = name
@@ -296,6 +728,136 @@ func DialWithClientName(name string) DialOption {
 	}
 }
 
+// DialWithIntroductionMetadata attaches application metadata, such as a client
+// version, capabilities, or a tenant claim, to the dialer's introduction. It
+// is signed along with the introduction and made available to the server's
+// [RemoteVerifier] as [storage.Peer.Metadata], before the handshake runs. The
+// combined size of keys and values is limited to 4 KiB.
+func DialWithIntroductionMetadata(md map[string][]byte) DialOption {
+	return func(d *Dialer) error {
+		if err := checkIntroMetadata(md); err != nil {
+			return err
+		}
+		d.handshakeOpts.intro.metadata = md
+		return nil
+	}
+}
+
+// DialWithDedupEnabled controls payload deduplication; see
+// [ServeWithDedupEnabled]. Disabled by default.
+func DialWithDedupEnabled(enabled bool) DialOption {
+	return func(d *Dialer) error {
+		d.handshakeOpts.intro.capabilities = setCapability(
+			d.handshakeOpts.intro.capabilities, capabilityDedup, enabled,
+		)
+		return nil
+	}
+}
+
+// DialWithRetransmitWindow is the dial-side equivalent of
+// [ServeWithRetransmitWindow].
+func DialWithRetransmitWindow(window int) DialOption {
+	return func(d *Dialer) error {
+		if window < 0 {
+			return errors.New("retransmit window must not be negative")
+		}
+		d.retransmitWindow = window
+		d.handshakeOpts.intro.capabilities = setCapability(
+			d.handshakeOpts.intro.capabilities, capabilityRetransmit,
+			window > 0,
+		)
+		return nil
+	}
+}
+
+// DialWithJournalEnabled controls the journal of outgoing messages; see
+// [ServeWithJournalEnabled]. Disabled by default.
+func DialWithJournalEnabled(enabled bool) DialOption {
+	return func(d *Dialer) error {
+		d.journal = enabled
+		return nil
+	}
+}
+
+// DialWithTimelineEnabled controls the timeline of each session; see
+// [ServeWithTimelineEnabled]. Disabled by default.
+func DialWithTimelineEnabled(enabled bool) DialOption {
+	return func(d *Dialer) error {
+		d.timeline = enabled
+		return nil
+	}
+}
+
+// DialWithSharedStorage makes [Dialer.Shutdown] leave the dialer's storage
+// open, for processes that use it for other things too, such as peer-to-peer
+// applications that also accept connections with a [Server] over the same
+// storage and identity, since the database allows only one handle. The
+// storage is then closed by its owner, once both are done with it.
+func DialWithSharedStorage() DialOption {
+	return func(d *Dialer) error {
+		d.sharedStorage = true
+		return nil
+	}
+}
+
+// DialWithRateLimit is the dial-side equivalent of [ServeWithRateLimit].
+func DialWithRateLimit(l RateLimit) DialOption {
+	return func(d *Dialer) error {
+		if err := l.validate(); err != nil {
+			return err
+		}
+		d.rateLimit = &l
+		return nil
+	}
+}
+
+// DialWithSchemas advertises the schemas registered in r; see
+// [ServeWithSchemas].
+func DialWithSchemas(r *SchemaRegistry) DialOption {
+	return func(d *Dialer) error {
+		for _, c := range r.capabilities() {
+			d.handshakeOpts.intro.capabilities = setCapability(
+				d.handshakeOpts.intro.capabilities, c, true,
+			)
+		}
+		return nil
+	}
+}
+
+// DialWithMaxMessageSize is the dial-side equivalent of
+// [ServeWithMaxMessageSize].
+func DialWithMaxMessageSize(size int) DialOption {
+	return func(d *Dialer) error {
+		caps, err := setMaxMessageSize(d.handshakeOpts.intro.capabilities, size)
+		if err != nil {
+			return err
+		}
+		d.handshakeOpts.intro.capabilities = caps
+		return nil
+	}
+}
+
+// DialWithFrameFormats is the dial-side equivalent of
+// [ServeWithFrameFormats].
+func DialWithFrameFormats(f FrameFormats) DialOption {
+	return func(d *Dialer) error {
+		caps, err := setFrameFormats(d.handshakeOpts.intro.capabilities, f)
+		if err != nil {
+			return err
+		}
+		d.handshakeOpts.intro.capabilities = caps
+		return nil
+	}
+}
+
+// DialWithKeyLog is the dial-side equivalent of [ServeWithKeyLog].
+func DialWithKeyLog(w io.Writer) DialOption {
+	return func(d *Dialer) error {
+		d.handshakeOpts.keyLog = newKeyLog(w)
+		return nil
+	}
+}
+
 // DialWithResume configures the dialer to attempt session resumption.
 func DialWithResume(sessionID string) DialOption {
 	return func(d *Dialer) error {
@@ -303,3 +865,43 @@ func DialWithResume(sessionID string) DialOption {
 		return nil
 	}
 }
+
+// DialWithClaims is the dial-side equivalent of [ServeWithClaims].
+func DialWithClaims(chain ...Claim) DialOption {
+	return func(d *Dialer) error {
+		if err := checkClaimChain(len(chain)); err != nil {
+			return err
+		}
+		d.handshakeOpts.intro.claims = chain
+		return nil
+	}
+}
+
+// DialWithClaimVerifier is the dial-side equivalent of
+// [ServeWithClaimVerifier]. It has no effect on dialers using
+// [DialWithDirectory].
+func DialWithClaimVerifier(v ClaimVerifier) DialOption {
+	return func(d *Dialer) error {
+		if v == nil {
+			return errors.New("claim verifier must not be nil")
+		}
+		d.handshakeOpts.claimVerifier = v
+		return nil
+	}
+}
+
+// DialWithDirectory makes the dialer verify servers with dir instead of the
+// [RemoteVerifier]: a server is accepted only if dir lists it, with its
+// public key, at the address being dialed, and fails with
+// [ErrNotInDirectory] otherwise. Accepted servers are stored as known peers,
+// so that their sessions can be recorded and resumed. Resumed sessions are
+// checked against dir too.
+func DialWithDirectory(dir *Directory) DialOption {
+	return func(d *Dialer) error {
+		if dir == nil {
+			return errors.New("directory must not be nil")
+		}
+		d.directory = dir
+		return nil
+	}
+}
@@ -0,0 +1,191 @@
+package kamune
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+func TestDialer_Shutdown(t *testing.T) {
+	a := require.New(t)
+	addr := startSessionServer(t)
+	path := filepath.Join(t.TempDir(), "kamune.db")
+	open := func() (*storage.Storage, error) {
+		return storage.OpenStorage(
+			storage.WithDBPath(path),
+			storage.WithNoPassphrase(),
+			storage.WithTimeout(100*time.Millisecond),
+		)
+	}
+
+	store, err := open()
+	a.NoError(err)
+	d, err := NewDialer(addr, store, storePeer)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	echo(t, tr, "before shutdown")
+	sessionID := tr.SessionID()
+
+	a.NoError(d.Shutdown(t.Context()))
+	a.Zero(d.SessionRegistry().Len())
+	_, err = tr.Send(Bytes([]byte("late")), RouteExchangeMessages)
+	a.Error(err)
+	_, err = d.Dial()
+	a.ErrorIs(err, ErrClosedDialer)
+	a.NoError(d.Shutdown(t.Context()))
+
+	// The database lock is released and the session can be resumed.
+	store, err = open()
+	a.NoError(err)
+	defer store.Close()
+	usage, err := store.SessionUsage(sessionID)
+	a.NoError(err)
+	a.Equal(1, usage.Connections)
+	d, err = NewDialer(addr, store, storePeer, DialWithResume(sessionID))
+	a.NoError(err)
+	tr, err = d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	a.Equal(sessionID, tr.SessionID())
+	echo(t, tr, "after shutdown")
+}
+
+func TestDialer_SharedStorage(t *testing.T) {
+	a := require.New(t)
+
+	// Each peer serves and dials over a single storage and identity.
+	storeA, cleanup := newTestStore(t)
+	defer cleanup()
+	storeB, cleanup := newTestStore(t)
+	defer cleanup()
+	connects := make(chan string, 4)
+	_, addrA, _ := startDrainServer(t, "a", storeA, connects)
+	_, addrB, _ := startDrainServer(t, "b", storeB, connects)
+
+	dialerA, err := NewDialer(addrB, storeA, storePeer, DialWithSharedStorage())
+	a.NoError(err)
+	dialerB, err := NewDialer(addrA, storeB, storePeer, DialWithSharedStorage())
+	a.NoError(err)
+	defer dialerB.Shutdown(t.Context())
+
+	tr, err := dialerA.Dial()
+	a.NoError(err)
+	echo(t, tr, "from a")
+	a.Equal("b", <-connects)
+	tr, err = dialerB.Dial()
+	a.NoError(err)
+	echo(t, tr, "from b")
+	a.Equal("a", <-connects)
+
+	// Shutting the dialer down leaves the server's storage open.
+	a.NoError(dialerA.Shutdown(t.Context()))
+	_, err = storeA.PublicKey()
+	a.NoError(err)
+	tr, err = dialerB.Dial()
+	a.NoError(err)
+	echo(t, tr, "after shutdown")
+	a.Equal("a", <-connects)
+}
+
+func TestDialer_ShutdownCancelsDials(t *testing.T) {
+	a := require.New(t)
+	// The server accepts connections but never answers, so dials hang in the
+	// exchange until they are cancelled.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { _ = c.Close() })
+		}
+	}()
+
+	tests := []struct {
+		name string
+		opts []DialOption
+	}{
+		{name: "default"},
+		{name: "tcp", opts: []DialOption{DialWithTCP()}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			store, err := storage.OpenStorage(storage.WithInMemory())
+			a.NoError(err)
+			d, err := NewDialer(l.Addr().String(), store, acceptAll, tc.opts...)
+			a.NoError(err)
+
+			errs := make(chan error, 1)
+			go func() {
+				_, err := d.Dial()
+				errs <- err
+			}()
+			a.Eventually(func() bool {
+				d.state.mu.Lock()
+				defer d.state.mu.Unlock()
+				return len(d.state.pending) == 1
+			}, 5*time.Second, 10*time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+			defer cancel()
+			a.NoError(d.Shutdown(ctx))
+			select {
+			case err := <-errs:
+				a.ErrorIs(err, ErrClosedDialer)
+			case <-time.After(5 * time.Second):
+				a.Fail("dial was not cancelled")
+			}
+		})
+	}
+}
+
+func TestDialer_Clone(t *testing.T) {
+	a := require.New(t)
+	addr1 := startSessionServer(t)
+	addr2 := startSessionServer(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	d, err := NewDialer(addr1, store, storePeer, DialWithResume("unknown"))
+	a.NoError(err)
+	_, err = d.Clone(addr2, DialWithRateLimit(RateLimit{}))
+	a.Error(err)
+	clone, err := d.Clone(addr2)
+	a.NoError(err)
+
+	// Clones dial their own address with a fresh handshake, concurrently.
+	again, err := clone.Clone(addr1)
+	a.NoError(err)
+	transports := make([]*Transport, 4)
+	errs := make([]error, len(transports))
+	var wg sync.WaitGroup
+	for i := range transports {
+		dialer := []*Dialer{clone, again}[i%2]
+		wg.Go(func() { transports[i], errs[i] = dialer.Dial() })
+	}
+	wg.Wait()
+	for i, tr := range transports {
+		a.NoError(errs[i])
+		echo(t, tr, fmt.Sprintf("message %d", i))
+	}
+	a.Equal(4, d.SessionRegistry().Len())
+	a.Equal(d.PublicKey(), clone.PublicKey())
+
+	a.NoError(clone.Shutdown(t.Context()))
+	a.Zero(d.SessionRegistry().Len())
+	_, err = d.Dial()
+	a.ErrorIs(err, ErrClosedDialer)
+}
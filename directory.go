@@ -0,0 +1,246 @@
+package kamune
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/kamune-org/kamune/pkg/attest"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+const (
+	// directorySignatureLine starts the last line of a directory file, which
+	// holds the signer's public key and the signature over every line above.
+	directorySignatureLine = "signature"
+
+	// directorySigningContext separates directory signatures from every other
+	// signature made with the same key.
+	directorySigningContext = "kamune-known-hosts\x00"
+)
+
+// DirectoryEntry is a server listed in a [Directory].
+type DirectoryEntry struct {
+	// Address is the address the server is dialed at, exactly as passed to
+	// [NewDialer] or [Dialer.DialService].
+	Address string
+	// Name is an optional label for the server.
+	Name string
+	// PublicKey is the server's public key, as returned by
+	// [Server.PublicKey].
+	PublicKey []byte
+}
+
+// Directory is a signed list of servers and their addresses, distributed by
+// an organisation the way an SSH known_hosts file is, so that a fleet of
+// clients can verify servers without asking their users. It is read from a
+// kamune-known-hosts file, in which each line is a server:
+//
+//	# comments and blank lines are ignored
+//	chat.example.org:9000 MCowBQYDK2VwAyEA... Chat
+//	10.0.0.5:9000 MCowBQYDK2VwAyEA...
+//
+// giving its address, its public key in unpadded base64url, and an optional
+// name. The last line signs every line above it:
+//
+//	signature <signer public key> <signature>
+//
+// Files are written by [SignDirectory] and read by [LoadDirectory], which
+// accepts them only if the signer is trusted. See [DialWithDirectory].
+type Directory struct {
+	signer  []byte
+	entries []DirectoryEntry
+}
+
+// SignDirectory returns a directory file listing entries, signed by at.
+func SignDirectory(
+	at attest.Attester, entries []DirectoryEntry,
+) ([]byte, error) {
+	var body bytes.Buffer
+	body.WriteString("# kamune-known-hosts\n")
+	for _, e := range entries {
+		if err := e.validate(); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(
+			&body, "%s %s", e.Address,
+			base64.RawURLEncoding.EncodeToString(e.PublicKey),
+		)
+		if e.Name != "" {
+			fmt.Fprintf(&body, " %s", e.Name)
+		}
+		body.WriteByte('\n')
+	}
+
+	sig, err := at.Sign(directorySigned(body.Bytes()))
+	if err != nil {
+		return nil, fmt.Errorf("signing directory: %w", err)
+	}
+	fmt.Fprintf(
+		&body, "%s %s %s\n", directorySignatureLine,
+		base64.RawURLEncoding.EncodeToString(at.MarshalPublicKey()),
+		base64.RawURLEncoding.EncodeToString(sig),
+	)
+	return body.Bytes(), nil
+}
+
+// LoadDirectory reads the directory file at path. See [ParseDirectory].
+func LoadDirectory(path string, trusted ...[]byte) (*Directory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading directory: %w", err)
+	}
+	return ParseDirectory(data, trusted...)
+}
+
+// ParseDirectory parses a directory file and checks its signature. The
+// directory must be signed by one of the trusted public keys; otherwise
+// [ErrVerificationFailed] is returned.
+func ParseDirectory(data []byte, trusted ...[]byte) (*Directory, error) {
+	body, sigLine := splitDirectory(data)
+	fields := strings.Fields(sigLine)
+	if len(fields) != 3 || fields[0] != directorySignatureLine {
+		return nil, fmt.Errorf("%w: missing signature", ErrInvalidDirectory)
+	}
+	signer, err := base64.RawURLEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: decoding signer", ErrInvalidDirectory)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: decoding signature", ErrInvalidDirectory)
+	}
+	if !slices.ContainsFunc(trusted, func(k []byte) bool {
+		return bytes.Equal(k, signer)
+	}) {
+		return nil, fmt.Errorf(
+			"%w: directory signed by an untrusted key", ErrVerificationFailed,
+		)
+	}
+	if !attest.Verify(signer, directorySigned(body), sig) {
+		return nil, fmt.Errorf(
+			"%w: invalid directory signature", ErrVerificationFailed,
+		)
+	}
+
+	entries, err := ParseDirectoryEntries(body)
+	if err != nil {
+		return nil, err
+	}
+	return &Directory{signer: signer, entries: entries}, nil
+}
+
+// ParseDirectoryEntries parses the server lines of a directory file, such as
+// an unsigned list to be signed with [SignDirectory]. It ignores any
+// signature and does not check it.
+func ParseDirectoryEntries(data []byte) ([]DirectoryEntry, error) {
+	var entries []DirectoryEntry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if fields[0] == directorySignatureLine {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf(
+				"%w: line %d: missing public key", ErrInvalidDirectory, n,
+			)
+		}
+		key, err := base64.RawURLEncoding.DecodeString(fields[1])
+		if err != nil || !attest.IsValidPublicKey(key) {
+			return nil, fmt.Errorf(
+				"%w: line %d: invalid public key", ErrInvalidDirectory, n,
+			)
+		}
+		entries = append(entries, DirectoryEntry{
+			Address:   fields[0],
+			Name:      strings.Join(fields[2:], " "),
+			PublicKey: key,
+		})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDirectory, err)
+	}
+	return entries, nil
+}
+
+// Signer returns the public key that signed the directory.
+func (d *Directory) Signer() []byte { return d.signer }
+
+// Entries returns the servers listed in the directory, in file order.
+func (d *Directory) Entries() []DirectoryEntry {
+	return slices.Clone(d.entries)
+}
+
+// Lookup returns the servers listed at addr. An address may be listed more
+// than once, such as while a server's key is being rotated.
+func (d *Directory) Lookup(addr string) []DirectoryEntry {
+	var found []DirectoryEntry
+	for _, e := range d.entries {
+		if e.Address == addr {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// verify accepts the server dialed at addr if it is listed there with its
+// public key, and stores it as a known peer so that its sessions can be
+// recorded and resumed.
+func (d *Directory) verify(
+	store *storage.Storage, addr string, peer *storage.Peer,
+) error {
+	listed := slices.ContainsFunc(d.Lookup(addr), func(e DirectoryEntry) bool {
+		return bytes.Equal(e.PublicKey, peer.PublicKey)
+	})
+	if !listed {
+		return fmt.Errorf("%w: %s", ErrNotInDirectory, addr)
+	}
+	if _, err := store.FindPeer(peer.PublicKey); err == nil {
+		return nil
+	}
+	return store.StorePeer(peer)
+}
+
+func (e DirectoryEntry) validate() error {
+	switch {
+	case e.Address == "" || strings.ContainsFunc(e.Address, unicode.IsSpace):
+		return fmt.Errorf(
+			"%w: invalid address %q", ErrInvalidDirectory, e.Address,
+		)
+	case strings.ContainsAny(e.Name, "\r\n"):
+		return fmt.Errorf("%w: invalid name %q", ErrInvalidDirectory, e.Name)
+	case strings.HasPrefix(e.Address, "#") ||
+		e.Address == directorySignatureLine:
+		return fmt.Errorf(
+			"%w: reserved address %q", ErrInvalidDirectory, e.Address,
+		)
+	case !attest.IsValidPublicKey(e.PublicKey):
+		return fmt.Errorf(
+			"%w: invalid public key for %s", ErrInvalidDirectory, e.Address,
+		)
+	}
+	return nil
+}
+
+// splitDirectory splits a directory file into the signed body and its last
+// non-blank line, which should be the signature.
+func splitDirectory(data []byte) (body []byte, last string) {
+	trimmed := bytes.TrimRight(data, " \t\r\n")
+	i := bytes.LastIndexByte(trimmed, '\n')
+	return trimmed[:i+1], string(trimmed[i+1:])
+}
+
+// directorySigned returns the message a directory's signature covers.
+func directorySigned(body []byte) []byte {
+	return append([]byte(directorySigningContext), body...)
+}
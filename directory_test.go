@@ -0,0 +1,252 @@
+package kamune
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/attest"
+)
+
+func newDirectoryEntries(t *testing.T) []DirectoryEntry {
+	t.Helper()
+	a := require.New(t)
+	var entries []DirectoryEntry
+	for _, e := range []struct{ addr, name string }{
+		{"chat.example.org:9000", "Chat server"},
+		{"10.0.0.5:9000", ""},
+		{"chat.example.org:9000", "Chat server (next key)"},
+	} {
+		at, err := attest.New()
+		a.NoError(err)
+		entries = append(entries, DirectoryEntry{
+			Address: e.addr, Name: e.name, PublicKey: at.MarshalPublicKey(),
+		})
+	}
+	return entries
+}
+
+func TestDirectory(t *testing.T) {
+	a := require.New(t)
+	signer, err := attest.New()
+	a.NoError(err)
+	other, err := attest.New()
+	a.NoError(err)
+	entries := newDirectoryEntries(t)
+
+	data, err := SignDirectory(signer, entries)
+	a.NoError(err)
+	path := filepath.Join(t.TempDir(), "kamune-known-hosts")
+	a.NoError(os.WriteFile(path, data, 0o600))
+
+	dir, err := LoadDirectory(
+		path, other.MarshalPublicKey(), signer.MarshalPublicKey(),
+	)
+	a.NoError(err)
+	a.Equal(signer.MarshalPublicKey(), dir.Signer())
+	a.Equal(entries, dir.Entries())
+	a.Equal(
+		[]DirectoryEntry{entries[0], entries[2]},
+		dir.Lookup("chat.example.org:9000"),
+	)
+	a.Empty(dir.Lookup("chat.example.org"))
+
+	listed, err := ParseDirectoryEntries(data)
+	a.NoError(err)
+	a.Equal(entries, listed)
+}
+
+func TestParseDirectoryRejects(t *testing.T) {
+	a := require.New(t)
+	signer, err := attest.New()
+	a.NoError(err)
+	other, err := attest.New()
+	a.NoError(err)
+	data, err := SignDirectory(signer, newDirectoryEntries(t))
+	a.NoError(err)
+	sigAt := bytes.LastIndex(data, []byte(directorySignatureLine))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		trusted [][]byte
+		err     error
+	}{
+		{
+			name: "no trusted keys",
+			data: data,
+			err:  ErrVerificationFailed,
+		},
+		{
+			name:    "untrusted signer",
+			data:    data,
+			trusted: [][]byte{other.MarshalPublicKey()},
+			err:     ErrVerificationFailed,
+		},
+		{
+			name: "tampered entry",
+			data: bytes.Replace(
+				data, []byte("10.0.0.5"), []byte("10.0.0.6"), 1,
+			),
+			trusted: [][]byte{signer.MarshalPublicKey()},
+			err:     ErrVerificationFailed,
+		},
+		{
+			name: "entry after signature",
+			data: append(
+				bytes.Clone(data),
+				[]byte("evil.example.org:9000 "+other.EncodePublicKey())...,
+			),
+			trusted: [][]byte{signer.MarshalPublicKey()},
+			err:     ErrInvalidDirectory,
+		},
+		{
+			name:    "unsigned",
+			data:    data[:sigAt],
+			trusted: [][]byte{signer.MarshalPublicKey()},
+			err:     ErrInvalidDirectory,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			_, err := ParseDirectory(tc.data, tc.trusted...)
+			a.ErrorIs(err, tc.err)
+		})
+	}
+}
+
+func TestDirectoryEntriesRejects(t *testing.T) {
+	a := require.New(t)
+	at, err := attest.New()
+	a.NoError(err)
+	key := at.MarshalPublicKey()
+
+	tests := []struct {
+		name  string
+		entry DirectoryEntry
+		line  string
+	}{
+		{
+			name:  "empty address",
+			entry: DirectoryEntry{PublicKey: key},
+		},
+		{
+			name:  "space in address",
+			entry: DirectoryEntry{Address: "a b:1", PublicKey: key},
+			line:  "a b:1 " + at.EncodePublicKey(),
+		},
+		{
+			name:  "newline in name",
+			entry: DirectoryEntry{Address: "a:1", Name: "x\ny", PublicKey: key},
+		},
+		{
+			name:  "invalid key",
+			entry: DirectoryEntry{Address: "a:1", PublicKey: []byte("key")},
+			line:  "a:1 a2V5",
+		},
+		{
+			name:  "missing key",
+			entry: DirectoryEntry{Address: "a:1"},
+			line:  "a:1",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			_, err := SignDirectory(at, []DirectoryEntry{tc.entry})
+			a.ErrorIs(err, ErrInvalidDirectory)
+			if tc.line != "" {
+				_, err = ParseDirectoryEntries([]byte(tc.line))
+				a.ErrorIs(err, ErrInvalidDirectory)
+			}
+		})
+	}
+}
+
+func TestDialWithDirectory(t *testing.T) {
+	a := require.New(t)
+	addr := startSessionServer(t)
+	// Learn the server's key from a session verified the usual way.
+	probeStore, cleanup := newTestStore(t)
+	defer cleanup()
+	probe, err := NewDialer(addr, probeStore, acceptAll)
+	a.NoError(err)
+	tr, err := probe.Dial()
+	a.NoError(err)
+	serverKey := tr.RemotePeer().PublicKey
+	a.NoError(tr.Close())
+
+	signer, err := attest.New()
+	a.NoError(err)
+	other, err := attest.New()
+	a.NoError(err)
+
+	tests := []struct {
+		name  string
+		entry DirectoryEntry
+		err   error
+	}{
+		{
+			name:  "listed",
+			entry: DirectoryEntry{Address: addr, PublicKey: serverKey},
+		},
+		{
+			name: "other address",
+			entry: DirectoryEntry{
+				Address: "127.0.0.1:1", PublicKey: serverKey,
+			},
+			err: ErrNotInDirectory,
+		},
+		{
+			name: "other key",
+			entry: DirectoryEntry{
+				Address: addr, PublicKey: other.MarshalPublicKey(),
+			},
+			err: ErrNotInDirectory,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			data, err := SignDirectory(signer, []DirectoryEntry{tc.entry})
+			a.NoError(err)
+			dir, err := ParseDirectory(data, signer.MarshalPublicKey())
+			a.NoError(err)
+
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			// The verifier must not be consulted.
+			d, err := NewDialer(addr, store, rejectAll, DialWithDirectory(dir))
+			a.NoError(err)
+			tr, err := d.Dial()
+			if tc.err != nil {
+				a.ErrorIs(err, tc.err)
+				_, err = store.FindPeer(serverKey)
+				a.Error(err)
+				return
+			}
+			a.NoError(err)
+			defer tr.Close()
+			echo(t, tr, "listed")
+			_, err = store.FindPeer(serverKey)
+			a.NoError(err)
+
+			// The stored peer lets the session be resumed.
+			sessionID := tr.SessionID()
+			a.NoError(tr.Close())
+			d, err = NewDialer(
+				addr, store, rejectAll,
+				DialWithDirectory(dir), DialWithResume(sessionID),
+			)
+			a.NoError(err)
+			tr, err = d.Dial()
+			a.NoError(err)
+			defer tr.Close()
+			a.Equal(sessionID, tr.SessionID())
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package kamune
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// checkDowngrade compares the introduction of peer with the one it last
+// established a session with, and records in peer.Downgrade whether it now
+// offers less: capabilities it dropped, or an older version. Verifiers see
+// the result and decide; the connection is never refused here. Capabilities
+// whose parameters changed, such as a schema's versions, are not dropped.
+func checkDowngrade(store *storage.Storage, peer *storage.Peer) {
+	previous, err := store.PeerParameters(peer.PublicKey)
+	if err != nil || previous == nil {
+		return
+	}
+
+	current := make(map[string]bool, len(peer.Capabilities))
+	for _, c := range peer.Capabilities {
+		current[capabilityName(c)] = true
+	}
+	var dropped []string
+	for _, c := range previous.Capabilities {
+		if !current[capabilityName(c)] {
+			dropped = append(dropped, c)
+		}
+	}
+	older := olderVersion(peer.AppVersion, previous.AppVersion)
+	if len(dropped) == 0 && !older {
+		return
+	}
+
+	peer.Downgrade = &storage.Downgrade{
+		Previous:     *previous,
+		Capabilities: dropped,
+		OlderVersion: older,
+	}
+	slog.Warn(
+		"peer offers less than before",
+		slog.String("peer", peer.Name),
+		slog.String("version", peer.AppVersion),
+		slog.String("previous_version", previous.AppVersion),
+		slog.Any("dropped_capabilities", dropped),
+	)
+}
+
+// recordParameters records what the peer introduced itself with, as the
+// baseline of checkDowngrade for its next connections.
+func recordParameters(store *storage.Storage, peer *storage.Peer) {
+	_ = store.SetPeerParameters(peer.PublicKey, storage.PeerParameters{
+		AppVersion:   peer.AppVersion,
+		Capabilities: slices.Clone(peer.Capabilities),
+	})
+}
+
+// capabilityName returns the capability c without its parameters.
+func capabilityName(c string) string {
+	if strings.HasPrefix(c, capabilityMaxMessagePrefix) {
+		return capabilityMaxMessagePrefix
+	}
+	if strings.HasPrefix(c, capabilityFramePrefix) {
+		return capabilityFramePrefix
+	}
+	name, _, _ := strings.Cut(c, "@")
+	return name
+}
+
+// olderVersion reports whether version v is older than previous. Versions
+// that cannot be parsed are not compared.
+func olderVersion(v, previous string) bool {
+	cur, err := parseSemver(v)
+	if err != nil {
+		return false
+	}
+	prev, err := parseSemver(previous)
+	if err != nil {
+		return false
+	}
+	switch {
+	case cur.major != prev.major:
+		return cur.major < prev.major
+	case cur.minor != prev.minor:
+		return cur.minor < prev.minor
+	default:
+		return cur.patch < prev.patch
+	}
+}
@@ -0,0 +1,136 @@
+package kamune
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+func TestDowngrade_Verifier(t *testing.T) {
+	a := require.New(t)
+	addr := startSessionServer(t, ServeWithMaxMessageSize(4096))
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	var seen *storage.Peer
+	verifier := func(s *storage.Storage, p *storage.Peer) error {
+		seen = p
+		return s.StorePeer(p)
+	}
+	dial := func() {
+		d, err := NewDialer(addr, store, verifier)
+		a.NoError(err)
+		tr, err := d.Dial()
+		a.NoError(err)
+		a.NoError(tr.Close())
+	}
+
+	dial()
+	a.Nil(seen.Downgrade)
+	recorded, err := store.PeerParameters(seen.PublicKey)
+	a.NoError(err)
+	a.Equal(seen.Capabilities, recorded.Capabilities)
+	a.Equal(AppVersion, recorded.AppVersion)
+
+	// Pretend the server offered more last time.
+	previous := storage.PeerParameters{
+		AppVersion:   "99.0.0",
+		Capabilities: append([]string{"legacy"}, recorded.Capabilities...),
+	}
+	a.NoError(store.SetPeerParameters(seen.PublicKey, previous))
+	dial()
+	a.Equal(&storage.Downgrade{
+		Previous:     previous,
+		Capabilities: []string{"legacy"},
+		OlderVersion: true,
+	}, seen.Downgrade)
+
+	// The accepted introduction became the baseline.
+	dial()
+	a.Nil(seen.Downgrade)
+}
+
+func TestCheckDowngrade(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	key := []byte("peer")
+	a := require.New(t)
+	a.NoError(store.SetPeerParameters(key, storage.PeerParameters{
+		AppVersion: "1.2.3",
+		Capabilities: []string{
+			capabilityDedup, "schema/chat@1-2", "max-message/4096",
+		},
+	}))
+
+	tests := []struct {
+		name    string
+		version string
+		caps    []string
+		want    *storage.Downgrade
+	}{
+		{
+			name:    "unchanged",
+			version: "1.2.3",
+			caps: []string{
+				capabilityDedup, "schema/chat@1-2", "max-message/4096",
+			},
+		},
+		{
+			name:    "parameters changed",
+			version: "1.3.0",
+			caps: []string{
+				"max-message/2048", "schema/chat@2-3", capabilityDedup,
+				"new",
+			},
+		},
+		{
+			name:    "dropped",
+			version: "1.2.3",
+			caps:    []string{"schema/chat@1-2"},
+			want: &storage.Downgrade{
+				Capabilities: []string{capabilityDedup, "max-message/4096"},
+			},
+		},
+		{
+			name:    "older",
+			version: "1.2.2",
+			caps: []string{
+				capabilityDedup, "schema/chat@1-2", "max-message/4096",
+			},
+			want: &storage.Downgrade{OlderVersion: true},
+		},
+		{
+			name:    "unparsable version",
+			version: "dev",
+			caps: []string{
+				capabilityDedup, "schema/chat@1-2", "max-message/4096",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := require.New(t)
+			peer := &storage.Peer{
+				PublicKey:    key,
+				AppVersion:   tt.version,
+				Capabilities: tt.caps,
+			}
+			checkDowngrade(store, peer)
+			if tt.want == nil {
+				a.Nil(peer.Downgrade)
+				return
+			}
+			a.NotNil(peer.Downgrade)
+			a.Equal("1.2.3", peer.Downgrade.Previous.AppVersion)
+			a.Equal(tt.want.Capabilities, peer.Downgrade.Capabilities)
+			a.Equal(tt.want.OlderVersion, peer.Downgrade.OlderVersion)
+		})
+	}
+
+	// Peers never seen before have nothing to compare with.
+	peer := &storage.Peer{PublicKey: []byte("other"), AppVersion: "0.0.1"}
+	checkDowngrade(store, peer)
+	a.Nil(peer.Downgrade)
+}
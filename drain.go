@@ -0,0 +1,135 @@
+package kamune
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+)
+
+// drainPollInterval is how often [Server.Drain] checks whether its sessions
+// have moved on.
+const drainPollInterval = 50 * time.Millisecond
+
+// Drain shuts the server down without closing its sessions, for rolling
+// upgrades. It stops accepting connections, so that [Server.ListenAndServe]
+// returns, and sends every live session a reconnect hint naming the address
+// set by [ServeWithReconnectAddress]. Dialers drop the connection on the
+// hint but keep the session, which [Dialer.Run] then resumes at that
+// address; other dialers see [ErrServerDraining]. Sessions whose handshake
+// completes meanwhile are hinted as soon as they are established.
+//
+// Drain returns once every session has moved on, or once ctx ends, dropping
+// the connections of the sessions left without closing them, and returns
+// ctx's error in that case. Either way the server is closed afterwards. For
+// no messages to be lost, the server that takes over must share the
+// server's storage and accept resumption, see [ServeWithResumeEnabled], and
+// both sides should keep messages for retransmission, see
+// [ServeWithRetransmitWindow].
+func (s *Server) Drain(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosedServer
+	}
+	s.draining.Store(true)
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	s.mu.Unlock()
+
+	sessions := s.registry.Sessions()
+	slog.Info(
+		"draining server",
+		slog.String("reconnect_address", s.reconnectAddr),
+		slog.Int("sessions", len(sessions)),
+	)
+	for _, t := range sessions {
+		s.hintReconnect(t)
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	var err error
+	for err == nil && s.registry.Len() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+			for _, t := range s.registry.Sessions() {
+				_ = t.currentConn().Close()
+			}
+		}
+	}
+	if cerr := s.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// hintReconnect tells the peer of t to resume the session elsewhere. A
+// failure only leaves the session to be dropped once the drain times out.
+func (s *Server) hintReconnect(t *Transport) {
+	hint := &pb.ReconnectHint{Address: s.reconnectAddr}
+	if _, err := t.Send(hint, RouteReconnectHint); err != nil {
+		slog.Warn(
+			"sending reconnect hint",
+			slog.String("session_id", t.SessionID()),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// handleReconnectHint drops the connection of a dialed session whose server
+// is draining, without closing the session, so that it can be resumed at the
+// address in msg.
+func (t *Transport) handleReconnectHint(msg []byte) error {
+	if !t.followHints {
+		return fmt.Errorf(
+			"%w: %s sent by dialer", ErrUnexpectedRoute, RouteReconnectHint,
+		)
+	}
+	var hint pb.ReconnectHint
+	if err := t.unmarshal(msg, &hint); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.reconnectAddr = hint.GetAddress()
+	t.mu.Unlock()
+
+	slog.Info(
+		"server is draining, dropping session",
+		slog.String("session_id", t.sessionID),
+		slog.String("reconnect_address", hint.GetAddress()),
+	)
+	t.reader.stop()
+	_ = t.currentConn().Close()
+	t.transfers.fail(ErrServerDraining)
+	t.wipes.fail(ErrServerDraining)
+	return fmt.Errorf(
+		"%w: reconnect to %q", ErrServerDraining, hint.GetAddress(),
+	)
+}
+
+// ReconnectAddress returns the address that the server asked the session to
+// be resumed at when it drained, after [Transport.Receive] has failed with
+// [ErrServerDraining]. It is empty if the server named none, in which case
+// the session is to be resumed at the address it was dialed at, once the
+// server is back.
+func (t *Transport) ReconnectAddress() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.reconnectAddr
+}
+
+// ServeWithReconnectAddress sets the address that [Server.Drain] sends peers
+// to, such as that of the server taking over. Without it, peers are told to
+// resume at the address they dialed.
+func ServeWithReconnectAddress(addr string) ServerOptions {
+	return func(s *Server) error {
+		s.reconnectAddr = addr
+		return nil
+	}
+}
@@ -0,0 +1,158 @@
+package kamune
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// startDrainServer runs a managed echo server over store on a loopback TCP
+// listener, reporting on connects the name of the server every session is
+// established or resumed on. It returns the server, its address, and the
+// result of serving.
+func startDrainServer(
+	t *testing.T,
+	name string,
+	store *storage.Storage,
+	connects chan<- string,
+	opts ...ServerOptions,
+) (*Server, string, <-chan error) {
+	t.Helper()
+	h := Handlers{
+		OnConnect: func(*Transport) { connects <- name },
+		OnMessage: func(m *InboundMessage) {
+			msg := Bytes(nil)
+			if m.Decode(msg) == nil {
+				_, _ = m.Transport.Send(msg, RouteExchangeMessages)
+			}
+		},
+	}
+	return startTestServer(
+		t, Managed(h), store, storePeer,
+		append([]ServerOptions{ServeWithRetransmitWindow(8)}, opts...)...,
+	)
+}
+
+func TestServerDrain(t *testing.T) {
+	a := require.New(t)
+
+	// Both servers share the storage, as the old and new process of a
+	// rolling upgrade would.
+	serverStore, cleanup := newTestStore(t)
+	defer cleanup()
+	connects := make(chan string, 4)
+	_, next, _ := startDrainServer(t, "next", serverStore, connects)
+	old, addr, served := startDrainServer(
+		t, "old", serverStore, connects, ServeWithReconnectAddress(next),
+	)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(
+		addr, store, storePeer, DialWithRetransmitWindow(8),
+	)
+	a.NoError(err)
+
+	transports := make(chan *Transport, 4)
+	replies := make(chan string, 4)
+	disconnects := make(chan error, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = d.Run(ctx, Handlers{
+			OnConnect: func(t *Transport) { transports <- t },
+			OnMessage: func(m *InboundMessage) {
+				msg := Bytes(nil)
+				if m.Decode(msg) == nil {
+					replies <- string(msg.Value)
+				}
+			},
+			OnDisconnect: func(_ *Transport, err error) {
+				disconnects <- err
+			},
+		})
+	}()
+	receive := func(ch <-chan string) string {
+		select {
+		case v := <-ch:
+			return v
+		case <-time.After(5 * time.Second):
+			a.FailNow("timed out")
+			return ""
+		}
+	}
+
+	first := <-transports
+	a.Equal("old", receive(connects))
+	_, err = first.Send(Bytes([]byte("hello")), RouteExchangeMessages)
+	a.NoError(err)
+	a.Equal("hello", receive(replies))
+
+	// A message sent after the hint never reaches the dialer, which drops
+	// the connection on reading the hint, and is resent on resumption.
+	st, ok := old.SessionRegistry().Get(first.SessionID())
+	a.True(ok)
+	old.hintReconnect(st)
+	_, _ = st.Send(Bytes([]byte("pushed")), RouteExchangeMessages)
+
+	drainCtx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+	a.NoError(old.Drain(drainCtx))
+	a.NoError(<-served)
+	a.ErrorIs(<-disconnects, ErrServerDraining)
+	a.Equal(next, first.ReconnectAddress())
+
+	// The session is resumed on the server taking over.
+	var resumed *Transport
+	select {
+	case resumed = <-transports:
+	case <-time.After(5 * time.Second):
+		a.FailNow("session was not resumed")
+	}
+	a.Equal("next", receive(connects))
+	a.Equal(first.SessionID(), resumed.SessionID())
+	a.Equal("pushed", receive(replies))
+	_, err = resumed.Send(Bytes([]byte("again")), RouteExchangeMessages)
+	a.NoError(err)
+	a.Equal("again", receive(replies))
+
+	a.ErrorIs(old.Drain(drainCtx), ErrClosedServer)
+}
+
+func TestServerDrain_Timeout(t *testing.T) {
+	a := require.New(t)
+
+	serverStore, cleanup := newTestStore(t)
+	defer cleanup()
+	connects := make(chan string, 1)
+	srv, addr, _ := startDrainServer(t, "old", serverStore, connects)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, storePeer)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	<-connects
+
+	// Nobody reads the hint, so the session does not move on in time.
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 200*time.Millisecond,
+	)
+	defer cancel()
+	a.ErrorIs(srv.Drain(ctx), context.DeadlineExceeded)
+	a.Eventually(func() bool {
+		return srv.SessionRegistry().Len() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The hint still arrives ahead of the dropped connection, without a
+	// named address, and the session is not closed.
+	_, err = tr.Receive(Bytes(nil))
+	a.ErrorIs(err, ErrServerDraining)
+	a.Empty(tr.ReconnectAddress())
+	a.False(tr.closed.Load())
+}
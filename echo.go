@@ -0,0 +1,124 @@
+package kamune
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// NewEchoHandler returns a handler that sends every message back to the peer
+// on the route it arrived on, answering pings with pongs. It gives integrators
+// a known-good peer to check handshake compatibility and to run [Benchmark]
+// against. When the peer disconnects, the session's traffic and throughput are
+// logged.
+func NewEchoHandler() HandlerFunc {
+	return func(t *Transport) error {
+		start := time.Now()
+		defer func() { logEchoSession(t, time.Since(start)) }()
+
+		for {
+			msg := Bytes(nil)
+			md, err := t.Receive(msg)
+			switch {
+			case err == nil: // continue
+			case errors.Is(err, ErrPeerDisconnected),
+				errors.Is(err, ErrConnClosed):
+				return nil
+			default:
+				return fmt.Errorf("receiving: %w", err)
+			}
+
+			route := md.Route()
+			if route == RoutePing {
+				route = RoutePong
+			}
+			if _, err := t.Send(msg, route); err != nil {
+				return fmt.Errorf("echoing: %w", err)
+			}
+		}
+	}
+}
+
+func logEchoSession(t *Transport, elapsed time.Duration) {
+	stats := t.Stats()
+	traffic := stats.BytesSent + stats.BytesReceived
+	slog.Info(
+		"echo session finished",
+		slog.String("session_id", t.SessionID()),
+		slog.Uint64("messages", stats.MessagesReceived),
+		slog.Uint64("bytes", traffic),
+		slog.Duration("duration", elapsed),
+		slog.Float64("bytes_per_second", float64(traffic)/elapsed.Seconds()),
+	)
+}
+
+// BenchmarkResult holds the measurements of a [Benchmark] run.
+type BenchmarkResult struct {
+	// MinRTT, MaxRTT, and MeanRTT summarize the round-trip time of each
+	// message.
+	MinRTT  time.Duration
+	MaxRTT  time.Duration
+	MeanRTT time.Duration
+	// Elapsed is the wall time of the whole run.
+	Elapsed time.Duration
+	// Bytes counts the payload bytes sent and received.
+	Bytes    uint64
+	Messages int
+}
+
+// Throughput returns the payload bytes exchanged per second.
+func (r BenchmarkResult) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / r.Elapsed.Seconds()
+}
+
+// Benchmark sends count messages of size random bytes over t, one at a time,
+// and waits for each to be echoed back, as done by [NewEchoHandler]. It fails
+// if an echo does not match what was sent.
+func Benchmark(t *Transport, count, size int) (BenchmarkResult, error) {
+	if count <= 0 || size < 0 {
+		return BenchmarkResult{}, fmt.Errorf(
+			"invalid benchmark: %d messages of %d bytes", count, size,
+		)
+	}
+
+	var (
+		res   BenchmarkResult
+		total time.Duration
+	)
+	payload := make([]byte, size)
+	start := time.Now()
+	for i := range count {
+		_, _ = rand.Read(payload)
+
+		sent := time.Now()
+		if _, err := t.Send(Bytes(payload), RouteExchangeMessages); err != nil {
+			return res, fmt.Errorf("sending message %d: %w", i, err)
+		}
+		reply := Bytes(nil)
+		if _, err := t.Receive(reply); err != nil {
+			return res, fmt.Errorf("receiving echo %d: %w", i, err)
+		}
+		rtt := time.Since(sent)
+		if !bytes.Equal(payload, reply.GetValue()) {
+			return res, fmt.Errorf("echo %d does not match the message", i)
+		}
+
+		if res.Messages == 0 || rtt < res.MinRTT {
+			res.MinRTT = rtt
+		}
+		res.MaxRTT = max(res.MaxRTT, rtt)
+		total += rtt
+		res.Messages++
+		res.Bytes += 2 * uint64(size)
+	}
+	res.Elapsed = time.Since(start)
+	res.MeanRTT = total / time.Duration(res.Messages)
+
+	return res, nil
+}
@@ -0,0 +1,51 @@
+package kamune
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEchoHandler_Benchmark(t *testing.T) {
+	a := require.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	srv, err := NewServer(
+		"", NewEchoHandler(), store, acceptAll,
+		ServeWithListener(&tcpListener{Listener: l}),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	clientStore, clientCleanup := newTestStore(t)
+	defer clientCleanup()
+	d, err := NewDialer(l.Addr().String(), clientStore, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+
+	_, err = tr.Send(Bytes([]byte("ping")), RoutePing)
+	a.NoError(err)
+	reply := Bytes(nil)
+	md, err := tr.Receive(reply)
+	a.NoError(err)
+	a.Equal(RoutePong, md.Route())
+	a.Equal("ping", string(reply.Value))
+
+	res, err := Benchmark(tr, 20, 1024)
+	a.NoError(err)
+	a.Equal(20, res.Messages)
+	a.EqualValues(2*20*1024, res.Bytes)
+	a.Positive(res.MinRTT)
+	a.LessOrEqual(res.MinRTT, res.MeanRTT)
+	a.LessOrEqual(res.MeanRTT, res.MaxRTT)
+	a.Positive(res.Throughput())
+
+	_, err = Benchmark(tr, 0, 1024)
+	a.Error(err)
+}
@@ -0,0 +1,81 @@
+package kamune
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// EmojiSetsMetadataKey is the introduction metadata key under which a peer
+// lists the emoji sets it can render fingerprints with; see
+// [ServeWithEmojiSets].
+const EmojiSetsMetadataKey = "kamune.emoji-sets"
+
+// checkEmojiSets rejects empty lists and unknown set IDs.
+func checkEmojiSets(ids []string) error {
+	if len(ids) == 0 {
+		return errors.New("no emoji sets given")
+	}
+	for _, id := range ids {
+		if _, ok := fingerprint.LookupEmojiSet(id); !ok {
+			return fmt.Errorf("unknown emoji set: %q", id)
+		}
+	}
+	return nil
+}
+
+// withEmojiSets returns md with the emoji sets in ids added, leaving md
+// itself untouched.
+func withEmojiSets(md map[string][]byte, ids []string) map[string][]byte {
+	if len(ids) == 0 {
+		return md
+	}
+	md = maps.Clone(md)
+	if md == nil {
+		md = make(map[string][]byte, 1)
+	}
+	md[EmojiSetsMetadataKey] = []byte(fingerprint.FormatEmojiSets(ids))
+	return md
+}
+
+// PeerEmojiSet returns the emoji set to render fingerprints with when
+// verifying peer, given the IDs of the sets the local side can render, such
+// as from a [RemoteVerifier]. Both sides find the same set as long as each
+// advertises its own list with [ServeWithEmojiSets] or [DialWithEmojiSets];
+// see [fingerprint.NegotiateEmojiSet].
+func PeerEmojiSet(
+	peer *storage.Peer, local []string,
+) (fingerprint.EmojiSet, error) {
+	remote := fingerprint.ParseEmojiSets(
+		string(peer.Metadata[EmojiSetsMetadataKey]),
+	)
+	return fingerprint.NegotiateEmojiSet(local, remote)
+}
+
+// ServeWithEmojiSets lists, in the server's introduction, the emoji sets it
+// can render fingerprints with, so that the dialer renders the same set; see
+// [PeerEmojiSet]. Servers that do not list any only support
+// [fingerprint.EmojiStandard].
+func ServeWithEmojiSets(ids ...string) ServerOptions {
+	return func(s *Server) error {
+		if err := checkEmojiSets(ids); err != nil {
+			return err
+		}
+		s.handshakeOpts.intro.emojiSets = ids
+		return nil
+	}
+}
+
+// DialWithEmojiSets is [ServeWithEmojiSets] for the dialer.
+func DialWithEmojiSets(ids ...string) DialOption {
+	return func(d *Dialer) error {
+		if err := checkEmojiSets(ids); err != nil {
+			return err
+		}
+		d.handshakeOpts.intro.emojiSets = ids
+		return nil
+	}
+}
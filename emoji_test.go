@@ -0,0 +1,73 @@
+package kamune
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+func TestEmojiSets(t *testing.T) {
+	a := require.New(t)
+	standard, basic := fingerprint.EmojiStandard.ID, fingerprint.EmojiBasic.ID
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	dialers := make(chan *storage.Peer, 1)
+	serverStore, cleanup := newTestStore(t)
+	defer cleanup()
+	srv, err := NewServer(
+		"", NewEchoHandler(), serverStore,
+		func(s *storage.Storage, p *storage.Peer) error {
+			dialers <- p
+			return nil
+		},
+		ServeWithListener(&tcpListener{Listener: ln}),
+		ServeWithIntroductionMetadata(map[string][]byte{"app": []byte("1")}),
+		ServeWithEmojiSets(basic),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	var server *storage.Peer
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(
+		ln.Addr().String(), store,
+		func(s *storage.Storage, p *storage.Peer) error {
+			server = p
+			return nil
+		},
+		DialWithEmojiSets(standard, basic),
+	)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	echo(t, tr, "hello")
+
+	// Each side settles on the set the other can render.
+	set, err := PeerEmojiSet(server, fingerprint.EmojiSets())
+	a.NoError(err)
+	a.Equal(fingerprint.EmojiBasic, set)
+	a.Equal("1", string(server.Metadata["app"]))
+	set, err = PeerEmojiSet(<-dialers, []string{basic})
+	a.NoError(err)
+	a.Equal(fingerprint.EmojiBasic, set)
+
+	// Peers that list no sets only render the standard one.
+	set, err = PeerEmojiSet(&storage.Peer{}, fingerprint.EmojiSets())
+	a.NoError(err)
+	a.Equal(fingerprint.EmojiStandard, set)
+	_, err = PeerEmojiSet(&storage.Peer{}, []string{basic})
+	a.ErrorIs(err, fingerprint.ErrNoCommonEmojiSet)
+
+	_, err = NewDialer("", store, acceptAll, DialWithEmojiSets())
+	a.Error(err)
+	_, err = NewDialer("", store, acceptAll, DialWithEmojiSets("future/1"))
+	a.Error(err)
+}
@@ -9,6 +9,27 @@ var (
 	// ErrClosedServer is returned when an operation is attempted on a server
 	// that has been shut down.
 	ErrClosedServer = errors.New("server is closed")
+	// ErrInboxDisabled is returned by [Server.NextMessage] on a server created
+	// without [ServeWithInbox].
+	ErrInboxDisabled = errors.New("server inbox is not enabled")
+	// ErrPendingDisabled is returned by the pending connection methods of a
+	// server created without [ServeWithPendingConnections].
+	ErrPendingDisabled = errors.New("pending connections are not enabled")
+	// ErrPendingNotFound is returned when deciding on a pending connection
+	// that is unknown, or that has already been decided on or has expired.
+	ErrPendingNotFound = errors.New("pending connection not found")
+	// ErrClosedPool is returned when a session is requested from a dialer
+	// pool that has been closed.
+	ErrClosedPool = errors.New("dialer pool is closed")
+	// ErrClosedDialer is returned when a dial is attempted on a dialer that
+	// has been shut down with [Dialer.Shutdown].
+	ErrClosedDialer = errors.New("dialer is closed")
+	// ErrInvalidDirectory is returned when a directory file or entry is
+	// malformed.
+	ErrInvalidDirectory = errors.New("invalid directory")
+	// ErrNotInDirectory is returned by a dialer using [DialWithDirectory]
+	// when the server is not listed at the dialed address with its key.
+	ErrNotInDirectory = errors.New("server not in directory")
 	// ErrConnClosed is returned when an operation is attempted on a connection
 	// that has already been closed.
 	ErrConnClosed = errors.New("connection has been closed")
@@ -21,7 +42,8 @@ var (
 	// ErrVerificationFailed is returned when a peer verification check
 	// (e.g. challenge-response, remote verifier) does not pass.
 	ErrVerificationFailed = errors.New("verification failed")
-	// ErrMessageTooLarge is returned when a frame exceeds maxTransportSize.
+	// ErrMessageTooLarge is returned when a frame exceeds maxTransportSize,
+	// or the limit the receiver advertised; see [MessageTooLargeError].
 	ErrMessageTooLarge = errors.New("message is too large")
 	// ErrOutOfSync is returned when received message sequence numbers indicate
 	// duplicates, gaps, or out-of-order delivery.
@@ -32,9 +54,112 @@ var (
 	// ErrInvalidRoute is returned when a route is not a recognized protocol
 	// route (e.g. when used with Transport.Send).
 	ErrInvalidRoute = errors.New("invalid route")
+	// ErrUnregisteredRoute is returned when a TypeRegistry has no message type
+	// for a route.
+	ErrUnregisteredRoute = errors.New("no message type registered for route")
+	// ErrUnregisteredType is returned when a TypeRegistry has no message type
+	// for an application message type.
+	ErrUnregisteredType = errors.New("application message type not registered")
+	// ErrInvalidMessageType is returned by SendTyped and
+	// TypeRegistry.RegisterType for an empty application message type.
+	ErrInvalidMessageType = errors.New("invalid message type")
+	// ErrSchemaMismatch is returned by a SchemaRegistry when the peers share no
+	// version of a message's schema.
+	ErrSchemaMismatch = errors.New("no common schema version")
+	// ErrFrameFormatMismatch is returned when the peers speak no frame format
+	// in common, or a frame is not of the session's format.
+	ErrFrameFormatMismatch = errors.New("no common frame format")
+	// ErrInvalidPriority is returned when a send priority is not a known lane.
+	ErrInvalidPriority = errors.New("invalid priority")
 	// ErrReceiveTimeout is returned when Transport.Receive exceeds its deadline.
 	ErrReceiveTimeout = errors.New("receive timed out")
+	// ErrNoMessage is returned by [Transport.TryReceive] when no message has
+	// arrived yet.
+	ErrNoMessage = errors.New("no message available")
+	// ErrHandshakeTimeout is returned, wrapped in a HandshakeTimeoutError,
+	// when the remote peer stalls during connection setup.
+	ErrHandshakeTimeout = errors.New("handshake timed out")
+	// ErrStaleIntroduction is returned when an introduction's timestamp is
+	// outside the server's configured freshness window.
+	ErrStaleIntroduction = errors.New("stale introduction")
+	// ErrReplayedIntroduction is returned when an introduction that was already
+	// accepted is presented again within the freshness window.
+	ErrReplayedIntroduction = errors.New("replayed introduction")
+	// ErrIntroductionMetadataTooLarge is returned when introduction metadata
+	// exceeds introMetadataMaxSize.
+	ErrIntroductionMetadataTooLarge = errors.New(
+		"introduction metadata is too large",
+	)
+	// ErrPeerBlocked is returned when the remote peer is on the local
+	// blocklist (see storage.Storage.BlockPeer).
+	ErrPeerBlocked = errors.New("peer is blocked")
+	// ErrPeerThrottled is returned when the remote peer is refused for the
+	// offenses on its record; see [ReputationPolicy].
+	ErrPeerThrottled = errors.New("peer is throttled")
+	// ErrServiceUnavailable is returned when the remote peer does not offer
+	// the requested service.
+	ErrServiceUnavailable = errors.New("service unavailable")
 	// ErrResumptionRejected is returned when a ResumeRequest is rejected by the
 	//  responder (session not found, expired, token invalid, etc.).
 	ErrResumptionRejected = errors.New("resumption rejected")
+	// ErrUnauthorizedRoute is returned when the remote peer sends on a route
+	// that its role does not allow; see [AccessPolicy].
+	ErrUnauthorizedRoute = errors.New("route not authorized")
+	// ErrUnknownReference is returned when a received payload reference does
+	// not match a payload the session has seen; see [ServeWithDedupEnabled].
+	ErrUnknownReference = errors.New("unknown payload reference")
+	// ErrMigrationRejected is returned when a MigrateRequest is rejected by the
+	// responder (session not live, proof invalid, migration disabled, etc.).
+	ErrMigrationRejected = errors.New("migration rejected")
+	// ErrTransferRejected is returned when the peer rejects or aborts a
+	// transfer; see [TransferRejectedError].
+	ErrTransferRejected = errors.New("transfer rejected")
+	// ErrUnsolicitedTransfer is returned when the peer sends transfer data
+	// that was not accepted, or more than it offered.
+	ErrUnsolicitedTransfer = errors.New("unsolicited transfer")
+	// ErrTransferCorrupt is returned when transfer data does not match the
+	// hash the peer sent for it.
+	ErrTransferCorrupt = errors.New("transfer data does not match its hash")
+	// ErrRateLimited is returned when the remote peer sends beyond the
+	// session's rate limit; see [RateLimit].
+	ErrRateLimited = errors.New("rate limited")
+	// ErrInvalidClaim is returned when an identity claim is malformed,
+	// expired, or about another key; see [Claim].
+	ErrInvalidClaim = errors.New("invalid identity claim")
+	// ErrInvalidSessionLink is returned when a session link is malformed or
+	// does not name the session it is verified against; see [SessionLink].
+	ErrInvalidSessionLink = errors.New("invalid session link")
+	// ErrInvalidSessionToken is returned when a session token is malformed,
+	// expired, or does not answer the challenge or name the session it is
+	// verified against; see [SessionToken].
+	ErrInvalidSessionToken = errors.New("invalid session token")
+	// ErrWipeRefused is returned by [Transport.RequestWipe] when the peer
+	// does not wipe its copy of the conversation.
+	ErrWipeRefused = errors.New("wipe refused")
+	// ErrAbusivePeer is returned by [Transport.Receive] when the session has
+	// been closed because of the peer's messages; see [AbuseControl].
+	ErrAbusivePeer = errors.New("session closed for abuse")
+	// ErrServerDraining is returned by [Transport.Receive] when the server
+	// drops the session to shut down, leaving it to be resumed elsewhere;
+	// see [Server.Drain] and [Transport.ReconnectAddress].
+	ErrServerDraining = errors.New("server is draining")
+	// ErrUDPUnsupported is returned by [ServeWithUDP], [DialWithUDP], and
+	// [DialWithUDPPath] in builds made with the kamune_noudp tag, which leave
+	// out KCP and its dependencies.
+	ErrUDPUnsupported = errors.New("udp support not built in")
+	// ErrServerBusy is returned for connections refused or abandoned because
+	// the handshake pool was full; see [HandshakePool].
+	ErrServerBusy = errors.New("server busy")
+	// ErrNotGroupOwner is returned when a member other than the owner of a
+	// group attempts what only the owner does, such as changing membership.
+	ErrNotGroupOwner = errors.New("not the group owner")
+	// ErrNotGroupMember is returned for peers that are not members of a
+	// group, and by [Group.Receive] once the local peer has been removed.
+	ErrNotGroupMember = errors.New("not a group member")
+	// ErrGroupClosed is returned by [Group.Send] and [Group.Receive] once the
+	// group has been closed.
+	ErrGroupClosed = errors.New("group is closed")
+	// ErrGroupUnreachable is returned by [Group.Send] when the session with
+	// the group owner is not attached.
+	ErrGroupUnreachable = errors.New("group owner unreachable")
 )
@@ -0,0 +1,299 @@
+package kamune
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// transferCheckpoint is how much data a file transfer receives between saves
+// of its progress. A resumed transfer repeats at most this much.
+const transferCheckpoint = 1 << 20
+
+// FileTransfer describes a file sent with [Transport.SendFile].
+type FileTransfer struct {
+	// Name is the name offered for the file, or its base name if empty.
+	Name string
+	// Type is the kind of data, such as a media type.
+	Type string
+	// Progress, if set, is called after each chunk of the file is sent.
+	Progress func(TransferProgress)
+}
+
+// TransferProgress reports how far a transfer has come.
+type TransferProgress struct {
+	ID   string
+	Name string
+	// Done is the number of bytes sent or written so far out of Size,
+	// counting those of the earlier attempts a transfer resumes.
+	Done uint64
+	Size uint64
+}
+
+// fileSink is the file a transfer accepted with AcceptFile is written to,
+// along with the state saved to resume it.
+type fileSink struct {
+	store *storage.Storage
+	file  *os.File
+	state storage.TransferState
+}
+
+// SendFile offers the peer the file at path and, once the peer accepts, sends
+// it as [Transport.Transfer] does, along with the hash of the whole file for
+// the peer to check it against.
+//
+// Until the file is sent, the transfer is saved in the session's storage. If
+// it is cut short, such as by the connection dropping, calling SendFile again
+// for the same unchanged file with the same peer, on this session or a later
+// one, offers the same transfer again. A peer that accepted it with
+// [IncomingTransfer.AcceptFile] then asks for the rest of the file only.
+func (t *Transport) SendFile(
+	ctx context.Context, path string, f FileTransfer,
+) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("resolving file path: %w", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return fmt.Errorf("hashing file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("hashing file: %w", err)
+	}
+
+	offer := TransferOffer{
+		Name: cmp.Or(f.Name, filepath.Base(path)),
+		Type: f.Type,
+		Size: uint64(info.Size()),
+		Hash: h.Sum(nil),
+	}
+	offer.ID = t.outgoingTransfer(path, offer)
+	err = t.transfer(ctx, offer, file, f.Progress)
+	if err == nil || errors.Is(err, ErrTransferRejected) {
+		if store := t.transferStore(); store != nil {
+			_ = store.DeleteTransfer(t.remotePeer.PublicKey, offer.ID)
+		}
+	}
+	return err
+}
+
+// outgoingTransfer returns the ID of the saved transfer of the file at path
+// with offer's size and hash to the peer, or saves a new one.
+func (t *Transport) outgoingTransfer(path string, offer TransferOffer) string {
+	store := t.transferStore()
+	if store == nil {
+		return rand.Text()
+	}
+	peer := t.remotePeer.PublicKey
+	if states, err := store.Transfers(peer); err == nil {
+		for _, st := range states {
+			if st.Outgoing && st.Path == path && st.Size == offer.Size &&
+				bytes.Equal(st.Hash, offer.Hash) {
+				return st.ID
+			}
+		}
+	}
+	id := rand.Text()
+	_ = store.SaveTransfer(storage.TransferState{
+		ID:       id,
+		Path:     path,
+		Name:     offer.Name,
+		PeerKey:  peer,
+		Hash:     offer.Hash,
+		Size:     offer.Size,
+		Outgoing: true,
+	})
+	return id
+}
+
+// transferStore returns the storage the state of the session's file
+// transfers is saved in, or nil if they are not to be resumed.
+func (t *Transport) transferStore() *storage.Storage {
+	if t.guest || t.remotePeer == nil {
+		return nil
+	}
+	return t.store
+}
+
+// partialTransfer returns the saved state of an earlier attempt at the
+// transfer offered, if one was received with AcceptFile.
+func (t *Transport) partialTransfer(
+	offer TransferOffer,
+) *storage.TransferState {
+	store := t.transferStore()
+	if store == nil || offer.Hash == nil {
+		return nil
+	}
+	st, err := store.Transfer(t.remotePeer.PublicKey, offer.ID)
+	if err != nil || st.Outgoing || st.Size != offer.Size ||
+		!bytes.Equal(st.Hash, offer.Hash) {
+		return nil
+	}
+	return &st
+}
+
+// PartialFile reports whether the offer resumes a transfer that was accepted
+// with [IncomingTransfer.AcceptFile] and cut short, and if so the path it was
+// being written to.
+func (in *IncomingTransfer) PartialFile() (string, bool) {
+	if in.partial == nil {
+		return "", false
+	}
+	return in.partial.Path, true
+}
+
+// AcceptFile accepts the offer and writes the data to the file at path, as
+// Accept does. If the offer resumes a transfer that was being written to the
+// same path (see [IncomingTransfer.PartialFile]), only the rest of the data
+// is asked for and appended to what the file already holds; otherwise the
+// file is created or truncated. progress, if set, is called after each chunk
+// is written.
+//
+// Unless the peer made the offer with [Transport.SendFile], the transfer
+// cannot be resumed. Otherwise its progress is saved in the session's storage
+// as the data arrives, and the whole file is checked against the hash the
+// peer sent, failing with [ErrTransferCorrupt] if it does not match.
+func (in *IncomingTransfer) AcceptFile(
+	path string, progress func(TransferProgress),
+) error {
+	in.mu.Lock()
+	state := in.state
+	in.mu.Unlock()
+	if state != transferOffered {
+		return errors.New("transfer was already decided on")
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("resolving file path: %w", err)
+	}
+
+	h := sha256.New()
+	var offset uint64
+	file := resumeFile(in.partial, path, h)
+	if file != nil {
+		offset = in.partial.Offset
+	} else {
+		h.Reset()
+		file, err = os.Create(path)
+		if err != nil {
+			_ = in.Reject("receiver failed")
+			return fmt.Errorf("creating file: %w", err)
+		}
+	}
+	defer file.Close()
+
+	store := in.t.transferStore()
+	if store != nil && in.offer.Hash != nil {
+		in.sink = &fileSink{
+			store: store,
+			file:  file,
+			state: storage.TransferState{
+				ID:      in.offer.ID,
+				Path:    path,
+				Name:    in.offer.Name,
+				PeerKey: in.t.remotePeer.PublicKey,
+				Hash:    in.offer.Hash,
+				Size:    in.offer.Size,
+			},
+		}
+		in.sink.save(offset, h)
+	}
+	in.progress = progress
+	err = in.accept(file, h, offset)
+	if err == nil || errors.Is(err, ErrTransferCorrupt) {
+		in.forgetPartial()
+	}
+	return err
+}
+
+// resumeFile opens the file at path to go on writing the transfer partial
+// was saved for, and restores the hash of its data into h. It returns nil if
+// the transfer cannot be resumed from the file.
+func resumeFile(
+	partial *storage.TransferState, path string, h hash.Hash,
+) *os.File {
+	if partial == nil || partial.Path != path {
+		return nil
+	}
+	u, ok := h.(encoding.BinaryUnmarshaler)
+	if !ok || u.UnmarshalBinary(partial.Digest) != nil {
+		return nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil
+	}
+	offset := int64(partial.Offset)
+	info, err := file.Stat()
+	if err == nil && info.Size() >= offset {
+		err = file.Truncate(offset)
+	} else if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil
+	}
+	return file
+}
+
+// checkpoint saves the progress of in once it has received enough since it
+// was last saved. A nil sink does nothing.
+func (fs *fileSink) checkpoint(in *IncomingTransfer) {
+	if fs == nil || in.received-fs.state.Offset < transferCheckpoint {
+		return
+	}
+	if fs.file.Sync() != nil {
+		return
+	}
+	fs.save(in.received, in.hash)
+}
+
+// save saves that the first offset bytes of the file, hashing to h, are
+// written.
+func (fs *fileSink) save(offset uint64, h hash.Hash) {
+	m, ok := h.(encoding.BinaryMarshaler)
+	if !ok {
+		return
+	}
+	digest, err := m.MarshalBinary()
+	if err != nil {
+		return
+	}
+	fs.state.Offset, fs.state.Digest = offset, digest
+	_ = fs.store.SaveTransfer(fs.state)
+}
+
+// forgetPartial deletes the saved state of the transfer, if any.
+func (in *IncomingTransfer) forgetPartial() {
+	if in.partial == nil && in.sink == nil {
+		return
+	}
+	if store := in.t.transferStore(); store != nil {
+		_ = store.DeleteTransfer(in.t.remotePeer.PublicKey, in.offer.ID)
+	}
+}
@@ -0,0 +1,102 @@
+package kamune
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransport_SendFile(t *testing.T) {
+	a := require.New(t)
+	dir := t.TempDir()
+	data := make([]byte, 2*transferCheckpoint+1000)
+	_, err := rand.Read(data)
+	a.NoError(err)
+	src := filepath.Join(dir, "src.bin")
+	dst := filepath.Join(dir, "dst.bin")
+	a.NoError(os.WriteFile(src, data, 0o600))
+
+	partials := make(chan bool, 2)
+	addr, results, _ := startTransferServer(
+		t, func(in *IncomingTransfer) transferResult {
+			path, ok := in.PartialFile()
+			partials <- ok
+			if ok {
+				a.Equal(dst, path)
+			}
+			return transferResult{err: in.AcceptFile(dst, nil)}
+		},
+	)
+	tr := dialTransfer(t, addr)
+
+	// The first attempt is cut short once past the first checkpoint.
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	err = tr.SendFile(ctx, src, FileTransfer{
+		Progress: func(p TransferProgress) {
+			if p.Done > transferCheckpoint+transferChunkSize {
+				cancel()
+			}
+		},
+	})
+	a.ErrorIs(err, context.Canceled)
+	a.False(<-partials)
+	a.Error((<-results).err)
+	states, err := tr.store.Transfers(tr.remotePeer.PublicKey)
+	a.NoError(err)
+	a.Len(states, 1)
+	a.True(states[0].Outgoing)
+
+	// The second attempt only sends what was not saved by the receiver.
+	var progress []TransferProgress
+	ctx, cancel = context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	err = tr.SendFile(ctx, src, FileTransfer{
+		Name: "renamed.bin",
+		Progress: func(p TransferProgress) {
+			progress = append(progress, p)
+		},
+	})
+	a.NoError(err)
+	a.True(<-partials)
+	a.NoError((<-results).err)
+	a.NotEmpty(progress)
+	a.GreaterOrEqual(progress[0].Done, uint64(transferCheckpoint))
+	a.Equal(states[0].ID, progress[0].ID)
+	a.EqualValues(len(data), progress[len(progress)-1].Done)
+
+	got, err := os.ReadFile(dst)
+	a.NoError(err)
+	a.True(bytes.Equal(data, got))
+	states, err = tr.store.Transfers(tr.remotePeer.PublicKey)
+	a.NoError(err)
+	a.Empty(states)
+}
+
+func TestIncomingTransfer_AcceptFileCorrupt(t *testing.T) {
+	a := require.New(t)
+	dst := filepath.Join(t.TempDir(), "dst.bin")
+	addr, results, _ := startTransferServer(
+		t, func(in *IncomingTransfer) transferResult {
+			return transferResult{err: in.AcceptFile(dst, nil)}
+		},
+	)
+	tr := dialTransfer(t, addr)
+
+	offer := TransferOffer{
+		ID:   "corrupt",
+		Size: 4,
+		Hash: hashChunk([]byte("nope")),
+	}
+	err := tr.transfer(
+		t.Context(), offer, bytes.NewReader([]byte("data")), nil,
+	)
+	a.NoError(err)
+	a.ErrorIs((<-results).err, ErrTransferCorrupt)
+}
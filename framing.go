@@ -0,0 +1,194 @@
+package kamune
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// capabilityFramePrefix starts the capability a peer advertises for the frame
+// formats it speaks, followed by "<first>-<last>", as in "frame/1-2". A peer
+// that advertises none speaks FrameFormatV1 only.
+const capabilityFramePrefix = "frame/"
+
+// FrameFormat is a version of the layout of a session's frames inside their
+// encryption. When the wire format has to change, a new format is added, and
+// peers speak the old and the new one side by side for a while; see
+// [FrameFormats].
+type FrameFormat uint8
+
+const (
+	// FrameFormatV1 is the original format: each frame holds the signed
+	// envelope of a message and nothing else.
+	FrameFormatV1 FrameFormat = iota + 1
+	// FrameFormatV2 leads each frame with the number of its format, so that
+	// the formats that follow it can be told apart frame by frame.
+	FrameFormatV2
+
+	// newestFrameFormat is the newest format this release speaks.
+	newestFrameFormat = FrameFormatV2
+)
+
+// String returns the string representation of the format.
+func (f FrameFormat) String() string {
+	if f < FrameFormatV1 || f > newestFrameFormat {
+		return "invalid"
+	}
+	return "v" + strconv.Itoa(int(f))
+}
+
+// overhead returns the number of bytes the format adds to each envelope.
+func (f FrameFormat) overhead() int {
+	if f >= FrameFormatV2 {
+		return 1
+	}
+	return 0
+}
+
+// FrameFormats declares the frame formats a peer speaks, from Oldest to
+// Newest; see [ServeWithFrameFormats]. Each session uses the newest format
+// both peers speak, so a change of the wire format goes in two steps: Newest
+// is raised first, and the new format is used with every peer that speaks it
+// while the others go on with the old one. Once they are few enough, as told
+// by [ServerStatus.FrameFormats], Oldest is raised, and the peers that still
+// speak only the old format can no longer establish sessions.
+type FrameFormats struct {
+	Oldest FrameFormat
+	Newest FrameFormat
+}
+
+// capability returns the capability advertising the formats, or an empty
+// string for those of a peer that predates them.
+func (f FrameFormats) capability() string {
+	if f.Oldest == FrameFormatV1 && f.Newest == FrameFormatV1 {
+		return ""
+	}
+	return fmt.Sprintf("%s%d-%d", capabilityFramePrefix, f.Oldest, f.Newest)
+}
+
+// setFrameFormats replaces the frame formats advertised in caps with f.
+func setFrameFormats(caps []string, f FrameFormats) ([]string, error) {
+	if f.Oldest < FrameFormatV1 || f.Newest > newestFrameFormat ||
+		f.Oldest > f.Newest {
+		return nil, fmt.Errorf(
+			"invalid frame formats %s-%s, this release speaks %s-%s",
+			f.Oldest, f.Newest, FrameFormatV1, newestFrameFormat,
+		)
+	}
+	caps = slices.DeleteFunc(slices.Clone(caps), func(c string) bool {
+		return strings.HasPrefix(c, capabilityFramePrefix)
+	})
+	if c := f.capability(); c != "" {
+		caps = append(caps, c)
+	}
+	return caps, nil
+}
+
+// frameFormats returns the frame formats advertised in caps. A missing or
+// malformed capability stands for FrameFormatV1 only.
+func frameFormats(caps []string) FrameFormats {
+	v1 := FrameFormats{Oldest: FrameFormatV1, Newest: FrameFormatV1}
+	for _, c := range caps {
+		versions, ok := strings.CutPrefix(c, capabilityFramePrefix)
+		if !ok {
+			continue
+		}
+		lo, hi, ok := strings.Cut(versions, "-")
+		if !ok {
+			return v1
+		}
+		first, err := strconv.ParseUint(lo, 10, 8)
+		if err != nil {
+			return v1
+		}
+		last, err := strconv.ParseUint(hi, 10, 8)
+		if err != nil || first == 0 || last < first {
+			return v1
+		}
+		return FrameFormats{
+			Oldest: FrameFormat(first), Newest: FrameFormat(last),
+		}
+	}
+	return v1
+}
+
+// negotiateFrameFormat returns the newest frame format spoken by both the
+// local peer, advertising local, and the remote one, advertising remote. It
+// returns ErrFrameFormatMismatch if they speak none in common.
+func negotiateFrameFormat(local, remote []string) (FrameFormat, error) {
+	l, r := frameFormats(local), frameFormats(remote)
+	f := min(l.Newest, r.Newest)
+	if f < max(l.Oldest, r.Oldest) {
+		return 0, fmt.Errorf(
+			"%w: formats %s-%s, peer has %s-%s", ErrFrameFormatMismatch,
+			l.Oldest, l.Newest, r.Oldest, r.Newest,
+		)
+	}
+	return f, nil
+}
+
+// checkFrameFormats returns ErrFrameFormatMismatch if peer speaks no frame
+// format in common with the local peer, which advertises local.
+func checkFrameFormats(local []string, peer *storage.Peer) error {
+	_, err := negotiateFrameFormat(local, peer.Capabilities)
+	return err
+}
+
+// setFrameFormat switches the session to the frame format f.
+func (t *Transport) setFrameFormat(f FrameFormat) {
+	t.frameFormat = f
+	t.serde.framing = f.overhead()
+}
+
+// saveFrameFormat records the session's frame format, so that resuming the
+// session keeps to it whatever either peer speaks by then.
+func (t *Transport) saveFrameFormat(store *storage.Storage) {
+	_ = store.SetMeta(t.sessionID, storage.NewBytesMeta(
+		storage.FrameFormatKey, []byte{byte(t.frameFormat)},
+	))
+}
+
+// loadFrameFormat restores the frame format of a resumed session. Sessions
+// established before frame formats were recorded use FrameFormatV1.
+func (t *Transport) loadFrameFormat(store *storage.Storage) {
+	f := FrameFormatV1
+	m, err := store.GetMeta(t.sessionID, storage.FrameFormatKey)
+	if err == nil && len(m.Value()) == 1 {
+		f = FrameFormat(m.Value()[0])
+	}
+	t.setFrameFormat(f)
+}
+
+// frame lays out envelope as a frame of the session's format.
+func (t *Transport) frame(envelope []byte) []byte {
+	if t.frameFormat < FrameFormatV2 {
+		return envelope
+	}
+	frame := make([]byte, 0, 1+len(envelope))
+	frame = append(frame, byte(t.frameFormat))
+	return append(frame, envelope...)
+}
+
+// unframe returns the envelope held in frame, which must be of the session's
+// format.
+func (t *Transport) unframe(frame []byte) ([]byte, error) {
+	if t.frameFormat < FrameFormatV2 {
+		return frame, nil
+	}
+	if len(frame) == 0 || FrameFormat(frame[0]) != t.frameFormat {
+		return nil, fmt.Errorf(
+			"%w: frame is not of format %s", ErrFrameFormatMismatch,
+			t.frameFormat,
+		)
+	}
+	return frame[1:], nil
+}
+
+// FrameFormat returns the frame format the session uses; see
+// [FrameFormats].
+func (t *Transport) FrameFormat() FrameFormat {
+	return max(t.frameFormat, FrameFormatV1)
+}
@@ -0,0 +1,151 @@
+package kamune
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+)
+
+func TestNegotiateFrameFormat(t *testing.T) {
+	a := require.New(t)
+	tests := []struct {
+		name    string
+		local   FrameFormats
+		remote  []string
+		want    FrameFormat
+		wantErr bool
+	}{
+		{
+			name:   "peer predates frame formats",
+			local:  FrameFormats{FrameFormatV1, FrameFormatV2},
+			remote: nil,
+			want:   FrameFormatV1,
+		},
+		{
+			name:   "both speak the new format",
+			local:  FrameFormats{FrameFormatV1, FrameFormatV2},
+			remote: []string{"dedup/v1", "frame/1-2"},
+			want:   FrameFormatV2,
+		},
+		{
+			name:   "peer speaks formats from the future",
+			local:  FrameFormats{FrameFormatV1, FrameFormatV2},
+			remote: []string{"frame/2-9"},
+			want:   FrameFormatV2,
+		},
+		{
+			name:   "malformed capability",
+			local:  FrameFormats{FrameFormatV1, FrameFormatV2},
+			remote: []string{"frame/2"},
+			want:   FrameFormatV1,
+		},
+		{
+			name:    "old format dropped",
+			local:   FrameFormats{FrameFormatV2, FrameFormatV2},
+			remote:  nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := require.New(t)
+			local, err := setFrameFormats(nil, tt.local)
+			a.NoError(err)
+			f, err := negotiateFrameFormat(local, tt.remote)
+			if tt.wantErr {
+				a.ErrorIs(err, ErrFrameFormatMismatch)
+				return
+			}
+			a.NoError(err)
+			a.Equal(tt.want, f)
+		})
+	}
+
+	_, err := setFrameFormats(nil, FrameFormats{FrameFormatV2, FrameFormatV1})
+	a.Error(err)
+	_, err = setFrameFormats(nil, FrameFormats{FrameFormatV1, 9})
+	a.Error(err)
+}
+
+func TestFrameFormats(t *testing.T) {
+	a := require.New(t)
+	var srv *Server
+	addr := startSessionServer(t,
+		ServeWithFrameFormats(FrameFormats{FrameFormatV1, FrameFormatV2}),
+		func(s *Server) error {
+			srv = s
+			return nil
+		},
+	)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	// A peer that only speaks the old format keeps to it.
+	d, err := NewDialer(addr, store, storePeer)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	a.Equal(FrameFormatV1, tr.FrameFormat())
+	echo(t, tr, "old")
+	a.NoError(tr.Close())
+
+	// A peer that speaks the new one moves to it.
+	d, err = NewDialer(addr, store, storePeer, DialWithFrameFormats(
+		FrameFormats{FrameFormatV1, FrameFormatV2},
+	))
+	a.NoError(err)
+	tr, err = d.Dial()
+	a.NoError(err)
+	a.Equal(FrameFormatV2, tr.FrameFormat())
+	echo(t, tr, "new")
+	sessionID := tr.SessionID()
+	a.NoError(tr.Close())
+
+	// A resumed session keeps its format.
+	d, err = NewDialer(addr, store, storePeer, DialWithResume(sessionID))
+	a.NoError(err)
+	tr, err = d.Dial()
+	a.NoError(err)
+	a.Equal(FrameFormatV2, tr.FrameFormat())
+	echo(t, tr, "resumed")
+	a.NoError(tr.Close())
+
+	a.Eventually(func() bool {
+		return srv.Status().FrameFormats["v2"] == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	a.Equal(uint64(1), srv.Status().FrameFormats["v1"])
+
+	// Once the old format is dropped, its peers are turned away.
+	d, err = NewDialer(addr, store, storePeer, DialWithFrameFormats(
+		FrameFormats{FrameFormatV2, FrameFormatV2},
+	))
+	a.NoError(err)
+	tr, err = d.Dial()
+	a.NoError(err)
+	a.Equal(FrameFormatV2, tr.FrameFormat())
+	a.NoError(tr.Close())
+	old := startSessionServer(t)
+	d, err = NewDialer(old, store, storePeer, DialWithFrameFormats(
+		FrameFormats{FrameFormatV2, FrameFormatV2},
+	))
+	a.NoError(err)
+	_, err = d.Dial()
+	a.ErrorIs(err, ErrFrameFormatMismatch)
+}
+
+func TestFrameFormatV2_Padding(t *testing.T) {
+	a := require.New(t)
+	for _, size := range []int{0, 100, 3000, maxTransportSize} {
+		st := &pb.SignedTransport{Data: bytes.Repeat([]byte{1}, size)}
+		payload, err := padSignedTransportWithin(
+			st, frameTargetSize, FrameFormatV2.overhead(), nil,
+		)
+		a.NoError(err)
+		// The frame, not the envelope, fills the bucket.
+		a.Contains(paddingBuckets, len(payload)+1)
+	}
+}
@@ -0,0 +1,746 @@
+package kamune
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/pkg/attest"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+const (
+	// capabilityGroups is advertised in the introduction by peers that take
+	// part in group chats.
+	capabilityGroups = "group/v1"
+
+	// groupSigningContext separates group messages from every other
+	// signature made with the same key.
+	groupSigningContext = "kamune-group-message\x00"
+
+	// groupQueueSize is the number of messages a group holds for
+	// [Group.Receive] before it stops reading from its sessions.
+	groupQueueSize = 64
+	// groupSeenSize is the number of message IDs the owner remembers to drop
+	// messages sent twice.
+	groupSeenSize = 1024
+)
+
+// GroupMetadata describes a message of a group chat.
+type GroupMetadata struct {
+	ID string
+	// Sender is the public key of the member that wrote the message.
+	Sender []byte
+	// Sequence is the place of the message in the group's order, assigned
+	// by the owner. It is zero in what [Group.Send] returns to members other
+	// than the owner, as their messages are ordered once the owner has them.
+	Sequence uint64
+	// Time is the sender's clock reading when it wrote the message.
+	Time HybridTime
+}
+
+// Group is a multi-party chat carried over the pairwise sessions of its
+// members. One member, the owner, orders the group: the others send their
+// messages to it, and it numbers each one and fans it out to every member,
+// the sender included, so that all members see the same messages in the
+// same order. Only the owner changes the membership. Every message is
+// signed by its sender, so the owner relays but cannot forge them.
+//
+// The group's ID, membership, and place in its order are kept in storage,
+// so that a group outlives its sessions; see [OpenGroup]. Sessions carry a
+// group's messages once attached with [Group.Attach], and only if both
+// peers enabled [ServeWithGroups] or [DialWithGroups].
+type Group struct {
+	store    *storage.Storage
+	attester attest.Attester
+	id       string
+	owner    []byte
+	self     []byte
+	incoming chan *pb.GroupMessage
+	done     chan struct{}
+	links    map[string]*Transport
+	seen     map[string]struct{}
+	seenIDs  []string
+	state    storage.GroupState
+	err      error
+	// order is held while a message is ordered and fanned out, so that
+	// members receive messages in the order they were numbered.
+	order sync.Mutex
+	mu    sync.Mutex
+	once  sync.Once
+}
+
+// NewGroup creates a group named name, owned by the identity of store, and
+// saves it.
+func NewGroup(store *storage.Storage, name string) (*Group, error) {
+	at, err := store.Attester()
+	if err != nil {
+		return nil, fmt.Errorf("loading identity: %w", err)
+	}
+	self := at.MarshalPublicKey()
+	state := storage.GroupState{
+		Created: time.Now(),
+		ID:      rand.Text(),
+		Name:    name,
+		Owner:   self,
+		Members: [][]byte{self},
+	}
+	if err := store.SaveGroup(state); err != nil {
+		return nil, err
+	}
+	return newGroup(store, at, state), nil
+}
+
+// OpenGroup opens the group saved in store with the given ID, which is
+// created with [NewGroup] or joined through [Transport.HandleGroupInvites].
+// Its sessions must be attached again.
+func OpenGroup(store *storage.Storage, id string) (*Group, error) {
+	state, err := store.Group(id)
+	if err != nil {
+		return nil, err
+	}
+	at, err := store.Attester()
+	if err != nil {
+		return nil, fmt.Errorf("loading identity: %w", err)
+	}
+	return newGroup(store, at, state), nil
+}
+
+func newGroup(
+	store *storage.Storage, at attest.Attester, state storage.GroupState,
+) *Group {
+	return &Group{
+		store:    store,
+		attester: at,
+		id:       state.ID,
+		owner:    state.Owner,
+		self:     at.MarshalPublicKey(),
+		incoming: make(chan *pb.GroupMessage, groupQueueSize),
+		done:     make(chan struct{}),
+		links:    make(map[string]*Transport),
+		seen:     make(map[string]struct{}),
+		state:    state,
+	}
+}
+
+// ID returns the group's ID, which is the same for every member.
+func (g *Group) ID() string { return g.id }
+
+// Name returns the group's name, as last set by the owner.
+func (g *Group) Name() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.state.Name
+}
+
+// Owner returns the public key of the group's owner.
+func (g *Group) Owner() []byte { return bytes.Clone(g.owner) }
+
+// Members returns the public keys of the group's members, the owner first.
+func (g *Group) Members() [][]byte {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	members := make([][]byte, len(g.state.Members))
+	for i, m := range g.state.Members {
+		members[i] = bytes.Clone(m)
+	}
+	return members
+}
+
+// Sequence returns the number of the last message ordered: the last one
+// numbered, for the owner, and the last one received, for other members.
+func (g *Group) Sequence() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.state.Sequence
+}
+
+func (g *Group) owned() bool { return bytes.Equal(g.self, g.owner) }
+
+// Attach has the group's messages carried over t. The owner attaches the
+// session of every member it can reach; other members attach their session
+// with the owner, and nothing else. Attaching a session with a peer that is
+// already attached replaces the older session.
+func (g *Group) Attach(t *Transport) error {
+	if !t.groupable {
+		return fmt.Errorf(
+			"%w: groups are not enabled on the session", errors.ErrUnsupported,
+		)
+	}
+	if !slices.Contains(t.remotePeer.Capabilities, capabilityGroups) {
+		return fmt.Errorf(
+			"%w: peer does not take part in groups", errors.ErrUnsupported,
+		)
+	}
+	key := t.remotePeer.PublicKey
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch {
+	case g.err != nil:
+		return g.err
+	case !containsKey(g.state.Members, key):
+		return ErrNotGroupMember
+	case !g.owned() && !bytes.Equal(key, g.owner):
+		return fmt.Errorf(
+			"%w: members only exchange messages with the owner",
+			ErrNotGroupOwner,
+		)
+	}
+	g.links[string(key)] = t
+	t.groups.add(g)
+	return nil
+}
+
+// detach stops carrying the group's messages over t.
+func (g *Group) detach(t *Transport) {
+	g.mu.Lock()
+	key := string(t.remotePeer.PublicKey)
+	if g.links[key] == t {
+		delete(g.links, key)
+	}
+	g.mu.Unlock()
+	t.groups.remove(g.id, g)
+}
+
+// Add adds the peer of t to the group, attaches t, and tells every member of
+// the new membership. Only the owner adds members.
+func (g *Group) Add(t *Transport) error {
+	if !g.owned() {
+		return ErrNotGroupOwner
+	}
+	g.order.Lock()
+	defer g.order.Unlock()
+
+	g.mu.Lock()
+	members := g.state.Members
+	key := t.remotePeer.PublicKey
+	added := !containsKey(members, key)
+	if added {
+		// Attach needs the peer to be a member already.
+		g.state.Members = append(slices.Clip(members), bytes.Clone(key))
+	}
+	updated := g.state.Members
+	g.mu.Unlock()
+
+	err := g.Attach(t)
+	if err == nil && added {
+		err = g.announce(updated)
+	}
+	if err != nil && added {
+		g.detach(t)
+		g.mu.Lock()
+		g.state.Members = members
+		g.mu.Unlock()
+	}
+	return err
+}
+
+// Remove removes the member with the given public key from the group, and
+// tells every member, the removed one included, of the new membership.
+// Only the owner removes members, and it cannot remove itself.
+func (g *Group) Remove(publicKey []byte) error {
+	if !g.owned() {
+		return ErrNotGroupOwner
+	}
+	if bytes.Equal(publicKey, g.self) {
+		return errors.New("the owner cannot leave its group")
+	}
+	g.order.Lock()
+	defer g.order.Unlock()
+
+	g.mu.Lock()
+	members := g.state.Members
+	t := g.links[string(publicKey)]
+	g.mu.Unlock()
+	if !containsKey(members, publicKey) {
+		return ErrNotGroupMember
+	}
+	err := g.announce(slices.DeleteFunc(
+		slices.Clone(members),
+		func(m []byte) bool { return bytes.Equal(m, publicKey) },
+	))
+	if t != nil {
+		g.detach(t)
+	}
+	return err
+}
+
+// announce orders a membership update. The caller holds g.order, and the
+// members of the update include the peers it has attached.
+func (g *Group) announce(members [][]byte) error {
+	g.mu.Lock()
+	membership := &pb.GroupMembership{
+		Name: g.state.Name, Owner: g.owner, Members: members,
+	}
+	g.mu.Unlock()
+	m, err := g.sign(&pb.GroupMessage{Membership: membership})
+	if err != nil {
+		return err
+	}
+	return g.sequence(m)
+}
+
+// Send sends msg to every member of the group. The owner orders it right
+// away; other members send it to the owner, which fails with
+// [ErrGroupUnreachable] if their session with the owner is not attached.
+func (g *Group) Send(msg Transferable) (*GroupMetadata, error) {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling message: %w", err)
+	}
+	m, err := g.sign(&pb.GroupMessage{Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	if g.owned() {
+		g.order.Lock()
+		defer g.order.Unlock()
+		if err := g.sequence(m); err != nil {
+			return nil, err
+		}
+		return groupMetadataOf(m), nil
+	}
+
+	g.mu.Lock()
+	err = g.err
+	t := g.links[string(g.owner)]
+	g.mu.Unlock()
+	switch {
+	case err != nil:
+		return nil, err
+	case t == nil:
+		return nil, ErrGroupUnreachable
+	}
+	if _, err := t.Send(m, RouteGroupMessage); err != nil {
+		return nil, fmt.Errorf("sending to the group owner: %w", err)
+	}
+	return groupMetadataOf(m), nil
+}
+
+// sign fills in what every message of the group carries, and signs m.
+func (g *Group) sign(m *pb.GroupMessage) (*pb.GroupMessage, error) {
+	m.Group = g.id
+	m.ID = rand.Text()
+	m.Sender = g.self
+	m.Clock = uint64(processClock.now())
+	sig, err := g.attester.Sign(signedGroupMessage(m))
+	if err != nil {
+		return nil, fmt.Errorf("signing group message: %w", err)
+	}
+	m.Signature = sig
+	return m, nil
+}
+
+// sequence numbers m, saves the group's new state, and fans m out to the
+// owner itself and to every attached member. The caller holds g.order.
+func (g *Group) sequence(m *pb.GroupMessage) error {
+	g.mu.Lock()
+	if g.err != nil {
+		g.mu.Unlock()
+		return g.err
+	}
+	state := g.state
+	state.Sequence++
+	if ms := m.GetMembership(); ms != nil {
+		state.Members = ms.GetMembers()
+	}
+	if err := g.store.SaveGroup(state); err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	g.state = state
+	m.Sequence = state.Sequence
+	links := make([]*Transport, 0, len(g.links))
+	for _, t := range g.links {
+		links = append(links, t)
+	}
+	g.mu.Unlock()
+
+	if m.GetMembership() == nil {
+		g.deliver(m)
+	}
+	for _, t := range links {
+		if _, err := t.Send(m, RouteGroupMessage); err != nil {
+			slog.Warn(
+				"detached group member",
+				slog.String("group_id", g.id),
+				slog.String("session_id", t.sessionID),
+				slog.Any("error", err),
+			)
+			g.detach(t)
+		}
+	}
+	return nil
+}
+
+// deliver queues m for Receive, waiting for room unless the group is
+// closed.
+func (g *Group) deliver(m *pb.GroupMessage) {
+	select {
+	case g.incoming <- m:
+	case <-g.done:
+	}
+}
+
+// Receive reads the next message of the group into dst, in the order the
+// owner gave it, the member's own messages included. Messages are read from
+// the group's sessions as those are received from, and wait here for
+// Receive; once groupQueueSize are waiting, the sessions stop, so Receive
+// must be called from a goroutine of its own. It fails with [ErrGroupClosed]
+// once the group is closed, and with [ErrNotGroupMember] once the local peer
+// is removed from it.
+func (g *Group) Receive(dst Transferable) (*GroupMetadata, error) {
+	var m *pb.GroupMessage
+	select {
+	case m = <-g.incoming:
+	default:
+		select {
+		case m = <-g.incoming:
+		case <-g.done:
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			return nil, g.err
+		}
+	}
+	if err := proto.Unmarshal(m.GetData(), dst); err != nil {
+		return nil, fmt.Errorf("unmarshalling group message: %w", err)
+	}
+	return groupMetadataOf(m), nil
+}
+
+// Close detaches the group from its sessions, which stay open, and stops
+// Receive. The group stays in storage.
+func (g *Group) Close() error {
+	g.end(ErrGroupClosed)
+	return nil
+}
+
+// end closes the group with err, which Receive and Send return from then
+// on.
+func (g *Group) end(err error) {
+	g.once.Do(func() {
+		g.mu.Lock()
+		g.err = err
+		links := g.links
+		g.links = make(map[string]*Transport)
+		g.mu.Unlock()
+		close(g.done)
+		for _, t := range links {
+			t.groups.remove(g.id, g)
+		}
+	})
+}
+
+// handle handles a message of the group received over t.
+func (g *Group) handle(t *Transport, m *pb.GroupMessage) error {
+	if g.owned() {
+		return g.handleFromMember(t, m)
+	}
+	return g.handleFromOwner(t, m)
+}
+
+// handleFromMember orders a message a member sent to the owner. Members send
+// their own messages only, and never membership updates.
+func (g *Group) handleFromMember(t *Transport, m *pb.GroupMessage) error {
+	g.order.Lock()
+	defer g.order.Unlock()
+
+	g.mu.Lock()
+	sender := m.GetSender()
+	_, seen := g.seen[m.GetID()]
+	valid := m.GetMembership() == nil &&
+		bytes.Equal(sender, t.remotePeer.PublicKey) &&
+		containsKey(g.state.Members, sender) &&
+		verifyGroupMessage(m)
+	if valid && !seen {
+		g.remember(m.GetID())
+	}
+	g.mu.Unlock()
+	if !valid || seen {
+		t.dropGroupMessage(m, "invalid or repeated group message")
+		return nil
+	}
+	processClock.observe(HybridTime(m.GetClock()))
+	return g.sequence(m)
+}
+
+// remember records id as ordered, forgetting the oldest IDs past
+// groupSeenSize. The caller holds g.mu.
+func (g *Group) remember(id string) {
+	g.seen[id] = struct{}{}
+	g.seenIDs = append(g.seenIDs, id)
+	if len(g.seenIDs) > groupSeenSize {
+		delete(g.seen, g.seenIDs[0])
+		g.seenIDs = g.seenIDs[1:]
+	}
+}
+
+// handleFromOwner takes a message the owner ordered. Messages ordered
+// before, such as those sent again after a reconnection, are dropped.
+func (g *Group) handleFromOwner(t *Transport, m *pb.GroupMessage) error {
+	g.mu.Lock()
+	sender := m.GetSender()
+	ms := m.GetMembership()
+	valid := bytes.Equal(t.remotePeer.PublicKey, g.owner) &&
+		m.GetSequence() > g.state.Sequence &&
+		verifyGroupMessage(m)
+	if ms != nil {
+		valid = valid && bytes.Equal(sender, g.owner) &&
+			bytes.Equal(ms.GetOwner(), g.owner)
+	} else {
+		valid = valid && containsKey(g.state.Members, sender)
+	}
+	if !valid {
+		g.mu.Unlock()
+		t.dropGroupMessage(m, "invalid or repeated group message")
+		return nil
+	}
+	state := g.state
+	state.Sequence = m.GetSequence()
+	removed := false
+	if ms != nil {
+		state.Name = ms.GetName()
+		state.Members = ms.GetMembers()
+		removed = !containsKey(state.Members, g.self)
+	}
+	var err error
+	if removed {
+		err = g.store.DeleteGroup(state.ID)
+	} else {
+		err = g.store.SaveGroup(state)
+	}
+	if err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	g.state = state
+	g.mu.Unlock()
+
+	processClock.observe(HybridTime(m.GetClock()))
+	switch {
+	case removed:
+		slog.Info(
+			"removed from group",
+			slog.String("group_id", state.ID),
+		)
+		g.end(ErrNotGroupMember)
+	case ms == nil:
+		g.deliver(m)
+	}
+	return nil
+}
+
+// groupMetadataOf returns the metadata of m.
+func groupMetadataOf(m *pb.GroupMessage) *GroupMetadata {
+	return &GroupMetadata{
+		ID:       m.GetID(),
+		Sender:   m.GetSender(),
+		Sequence: m.GetSequence(),
+		Time:     HybridTime(m.GetClock()),
+	}
+}
+
+// signedGroupMessage returns the bytes the signature of m covers. The
+// sequence is left out, as the owner assigns it after the sender signs; it
+// is trusted for coming over the session with the owner.
+func signedGroupMessage(m *pb.GroupMessage) []byte {
+	b := []byte(groupSigningContext)
+	b = appendGroupField(b, []byte(m.GetGroup()))
+	b = appendGroupField(b, []byte(m.GetID()))
+	b = appendGroupField(b, m.GetSender())
+	b = appendGroupField(b, m.GetData())
+	b = binary.BigEndian.AppendUint64(b, m.GetClock())
+	ms := m.GetMembership()
+	if ms == nil {
+		return append(b, 0)
+	}
+	b = append(b, 1)
+	b = appendGroupField(b, []byte(ms.GetName()))
+	b = appendGroupField(b, ms.GetOwner())
+	b = binary.BigEndian.AppendUint32(b, uint32(len(ms.GetMembers())))
+	for _, member := range ms.GetMembers() {
+		b = appendGroupField(b, member)
+	}
+	return b
+}
+
+func appendGroupField(b, field []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(field)))
+	return append(b, field...)
+}
+
+// verifyGroupMessage reports whether m was signed by its sender.
+func verifyGroupMessage(m *pb.GroupMessage) bool {
+	return attest.Verify(
+		m.GetSender(), signedGroupMessage(m), m.GetSignature(),
+	)
+}
+
+func containsKey(keys [][]byte, key []byte) bool {
+	return slices.ContainsFunc(keys, func(k []byte) bool {
+		return bytes.Equal(k, key)
+	})
+}
+
+// groupLinks are the groups attached to a session.
+type groupLinks struct {
+	byID   map[string]*Group
+	invite func(*Group) error
+	mu     sync.Mutex
+}
+
+func (l *groupLinks) add(g *Group) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.byID == nil {
+		l.byID = make(map[string]*Group)
+	}
+	l.byID[g.id] = g
+}
+
+// remove removes g, unless another group with its ID replaced it.
+func (l *groupLinks) remove(id string, g *Group) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.byID[id] == g {
+		delete(l.byID, id)
+	}
+}
+
+// HandleGroupInvites has fn decide on the groups the peer adds the local
+// peer to. The group is joined, saved, and attached to t if fn returns nil,
+// and ignored otherwise; without a handler, invites are ignored. fn runs
+// while t receives, so it must not wait on the group.
+func (t *Transport) HandleGroupInvites(fn func(*Group) error) {
+	t.groups.mu.Lock()
+	defer t.groups.mu.Unlock()
+	t.groups.invite = fn
+}
+
+// detachGroups detaches every group from t once the session has ended.
+func (t *Transport) detachGroups() {
+	t.groups.mu.Lock()
+	groups := make([]*Group, 0, len(t.groups.byID))
+	for _, g := range t.groups.byID {
+		groups = append(groups, g)
+	}
+	t.groups.mu.Unlock()
+	for _, g := range groups {
+		g.detach(t)
+	}
+}
+
+// handleGroupMessage handles a message on RouteGroupMessage. It returns an
+// error only if the message cannot be decoded or the group's state cannot
+// be saved; messages that are invalid or of unknown groups are dropped.
+func (t *Transport) handleGroupMessage(msg []byte) error {
+	var m pb.GroupMessage
+	if err := t.unmarshal(msg, &m); err != nil {
+		return err
+	}
+	if !t.groupable {
+		t.dropGroupMessage(&m, "groups are not enabled")
+		return nil
+	}
+	t.groups.mu.Lock()
+	g := t.groups.byID[m.GetGroup()]
+	invite := t.groups.invite
+	t.groups.mu.Unlock()
+	switch {
+	case g != nil:
+		return g.handle(t, &m)
+	case invite != nil && m.GetMembership() != nil:
+		return t.joinGroup(&m, invite)
+	}
+	t.dropGroupMessage(&m, "unknown group")
+	return nil
+}
+
+// joinGroup offers the group m adds the local peer to, to invite.
+func (t *Transport) joinGroup(
+	m *pb.GroupMessage, invite func(*Group) error,
+) error {
+	ms := m.GetMembership()
+	owner := t.remotePeer.PublicKey
+	self := t.serde.attest.MarshalPublicKey()
+	if t.store == nil ||
+		!bytes.Equal(m.GetSender(), owner) ||
+		!bytes.Equal(ms.GetOwner(), owner) ||
+		!containsKey(ms.GetMembers(), self) ||
+		!verifyGroupMessage(m) {
+		t.dropGroupMessage(m, "invalid group invite")
+		return nil
+	}
+	if _, err := t.store.Group(m.GetGroup()); err == nil {
+		// The group is known, but not attached to this session.
+		t.dropGroupMessage(m, "group is not attached")
+		return nil
+	}
+	processClock.observe(HybridTime(m.GetClock()))
+
+	g := newGroup(t.store, t.serde.attest, storage.GroupState{
+		Created:  time.Now(),
+		ID:       m.GetGroup(),
+		Name:     ms.GetName(),
+		Owner:    owner,
+		Members:  ms.GetMembers(),
+		Sequence: m.GetSequence(),
+	})
+	if err := invite(g); err != nil {
+		t.dropGroupMessage(m, "group invite declined")
+		return nil
+	}
+	if err := t.store.SaveGroup(g.state); err != nil {
+		return err
+	}
+	if err := g.Attach(t); err != nil {
+		return fmt.Errorf("attaching group: %w", err)
+	}
+	slog.Info(
+		"joined group",
+		slog.String("group_id", g.id),
+		slog.String("session_id", t.sessionID),
+	)
+	return nil
+}
+
+func (t *Transport) dropGroupMessage(m *pb.GroupMessage, reason string) {
+	slog.Debug(
+		"dropped group message",
+		slog.String("session_id", t.sessionID),
+		slog.String("group_id", m.GetGroup()),
+		slog.String("reason", reason),
+	)
+}
+
+// ServeWithGroups controls whether the server takes part in group chats;
+// see [Group]. Disabled by default.
+func ServeWithGroups(enabled bool) ServerOptions {
+	return func(s *Server) error {
+		s.handshakeOpts.intro.capabilities = setCapability(
+			s.handshakeOpts.intro.capabilities, capabilityGroups, enabled,
+		)
+		return nil
+	}
+}
+
+// DialWithGroups is the dialer's equivalent of [ServeWithGroups].
+func DialWithGroups(enabled bool) DialOption {
+	return func(d *Dialer) error {
+		d.handshakeOpts.intro.capabilities = setCapability(
+			d.handshakeOpts.intro.capabilities, capabilityGroups, enabled,
+		)
+		return nil
+	}
+}
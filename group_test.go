@@ -0,0 +1,268 @@
+package kamune
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/pkg/attest"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// startGroupServer runs a server taking part in groups that hands over the
+// server side of every session, and keeps receiving from it so that group
+// messages are handled.
+func startGroupServer(
+	t *testing.T, opts ...ServerOptions,
+) (string, *storage.Storage, <-chan *Transport) {
+	t.Helper()
+	store, cleanup := newTestStore(t)
+	t.Cleanup(cleanup)
+	sessions := make(chan *Transport, 4)
+	handler := func(tr *Transport) error {
+		sessions <- tr
+		for {
+			if _, err := tr.Receive(Bytes(nil)); err != nil {
+				return err
+			}
+		}
+	}
+	_, addr, _ := startTestServer(
+		t, handler, store, storePeer,
+		append([]ServerOptions{ServeWithGroups(true)}, opts...)...,
+	)
+	return addr, store, sessions
+}
+
+// groupMember is a peer dialed into a group server, with the groups it was
+// invited to.
+type groupMember struct {
+	store   *storage.Storage
+	tr      *Transport
+	invites chan *Group
+}
+
+// dialGroupMember dials addr, accepts every group invite, and keeps
+// receiving in the background. It returns the member and the server side of
+// its session.
+func dialGroupMember(
+	t *testing.T, addr string, sessions <-chan *Transport,
+) (*groupMember, *Transport) {
+	t.Helper()
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	t.Cleanup(cleanup)
+	d, err := NewDialer(addr, store, storePeer, DialWithGroups(true))
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	t.Cleanup(func() { _ = tr.Close() })
+
+	m := &groupMember{store: store, tr: tr, invites: make(chan *Group, 1)}
+	tr.HandleGroupInvites(func(g *Group) error {
+		m.invites <- g
+		return nil
+	})
+	go func() {
+		for {
+			if _, err := tr.Receive(Bytes(nil)); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case srvTr := <-sessions:
+		return m, srvTr
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not accept the session")
+		return nil, nil
+	}
+}
+
+// joined returns the group m was invited to.
+func (m *groupMember) joined(t *testing.T) *Group {
+	t.Helper()
+	select {
+	case g := <-m.invites:
+		return g
+	case <-time.After(5 * time.Second):
+		t.Fatal("no group invite")
+		return nil
+	}
+}
+
+// receiveGroup receives n messages of g, returning their texts in order.
+func receiveGroup(t *testing.T, g *Group, n int) []string {
+	t.Helper()
+	a := require.New(t)
+	var texts []string
+	for range n {
+		msg := Bytes(nil)
+		md, err := g.Receive(msg)
+		a.NoError(err)
+		a.NotZero(md.Sequence)
+		texts = append(texts, string(msg.Value))
+	}
+	return texts
+}
+
+func TestGroup(t *testing.T) {
+	a := require.New(t)
+	addr, ownerStore, sessions := startGroupServer(t)
+	alice, aliceSrv := dialGroupMember(t, addr, sessions)
+	bob, bobSrv := dialGroupMember(t, addr, sessions)
+
+	owner, err := NewGroup(ownerStore, "friends")
+	a.NoError(err)
+	defer owner.Close()
+	a.NoError(owner.Add(aliceSrv))
+	aliceGroup := alice.joined(t)
+	a.NoError(owner.Add(bobSrv))
+	bobGroup := bob.joined(t)
+
+	a.Equal(owner.ID(), aliceGroup.ID())
+	a.Equal("friends", bobGroup.Name())
+	a.Equal(owner.Owner(), bobGroup.Owner())
+	a.Len(bobGroup.Members(), 3)
+
+	// Every member, the senders included, sees every message in the same
+	// order.
+	var wg sync.WaitGroup
+	for g, text := range map[*Group]string{
+		owner: "owner", aliceGroup: "alice", bobGroup: "bob",
+	} {
+		wg.Go(func() {
+			_, err := g.Send(Bytes([]byte(text)))
+			a.NoError(err)
+		})
+	}
+	wg.Wait()
+	want := receiveGroup(t, owner, 3)
+	a.ElementsMatch([]string{"owner", "alice", "bob"}, want)
+	a.Equal(want, receiveGroup(t, aliceGroup, 3))
+	a.Equal(want, receiveGroup(t, bobGroup, 3))
+	a.Len(aliceGroup.Members(), 3)
+
+	// The removed member hears of it, and forgets the group.
+	a.NoError(owner.Remove(bobSrv.RemotePeer().PublicKey))
+	_, err = bobGroup.Receive(Bytes(nil))
+	a.ErrorIs(err, ErrNotGroupMember)
+	_, err = bob.store.Group(owner.ID())
+	a.ErrorIs(err, storage.ErrNotFound)
+
+	md, err := aliceGroup.Send(Bytes([]byte("after")))
+	a.NoError(err)
+	a.Zero(md.Sequence)
+	a.Equal([]string{"after"}, receiveGroup(t, owner, 1))
+	a.Equal([]string{"after"}, receiveGroup(t, aliceGroup, 1))
+	a.Len(aliceGroup.Members(), 2)
+
+	// The membership and place in the order are kept.
+	saved, err := OpenGroup(alice.store, owner.ID())
+	a.NoError(err)
+	a.Equal(owner.Sequence(), saved.Sequence())
+	a.Equal(aliceGroup.Members(), saved.Members())
+	saved, err = OpenGroup(ownerStore, owner.ID())
+	a.NoError(err)
+	a.Equal(owner.Sequence(), saved.Sequence())
+}
+
+func TestGroup_Refusals(t *testing.T) {
+	a := require.New(t)
+	addr, ownerStore, sessions := startGroupServer(t)
+	alice, aliceSrv := dialGroupMember(t, addr, sessions)
+
+	owner, err := NewGroup(ownerStore, "friends")
+	a.NoError(err)
+	defer owner.Close()
+
+	// Sessions of peers that are not members are not attached.
+	a.ErrorIs(owner.Attach(aliceSrv), ErrNotGroupMember)
+	a.NoError(owner.Add(aliceSrv))
+	aliceGroup := alice.joined(t)
+
+	// Only the owner changes the membership.
+	a.ErrorIs(aliceGroup.Add(alice.tr), ErrNotGroupOwner)
+	a.ErrorIs(aliceGroup.Remove(owner.Owner()), ErrNotGroupOwner)
+	a.Error(owner.Remove(owner.Owner()))
+
+	// Peers that do not take part in groups cannot be added.
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, storePeer)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	a.ErrorIs(owner.Add(<-sessions), errors.ErrUnsupported)
+	a.Len(owner.Members(), 2)
+
+	a.NoError(aliceGroup.Close())
+	_, err = aliceGroup.Send(Bytes([]byte("late")))
+	a.ErrorIs(err, ErrGroupClosed)
+	_, err = aliceGroup.Receive(Bytes(nil))
+	a.ErrorIs(err, ErrGroupClosed)
+}
+
+func TestSignedGroupMessage(t *testing.T) {
+	a := require.New(t)
+	at, err := attest.New()
+	a.NoError(err)
+	other, err := attest.New()
+	a.NoError(err)
+	signed := func() *pb.GroupMessage {
+		m := &pb.GroupMessage{
+			Group:  "group",
+			ID:     "id",
+			Sender: at.MarshalPublicKey(),
+			Data:   []byte("data"),
+			Clock:  42,
+			Membership: &pb.GroupMembership{
+				Name:    "friends",
+				Owner:   at.MarshalPublicKey(),
+				Members: [][]byte{at.MarshalPublicKey()},
+			},
+		}
+		sig, err := at.Sign(signedGroupMessage(m))
+		a.NoError(err)
+		m.Signature = sig
+		return m
+	}
+
+	tests := []struct {
+		name   string
+		modify func(m *pb.GroupMessage)
+		valid  bool
+	}{
+		{"unchanged", func(*pb.GroupMessage) {}, true},
+		{"sequence", func(m *pb.GroupMessage) { m.Sequence = 7 }, true},
+		{"group", func(m *pb.GroupMessage) { m.Group = "other" }, false},
+		{"id", func(m *pb.GroupMessage) { m.ID = "other" }, false},
+		{"data", func(m *pb.GroupMessage) { m.Data = []byte("x") }, false},
+		{"clock", func(m *pb.GroupMessage) { m.Clock++ }, false},
+		{"sender", func(m *pb.GroupMessage) {
+			m.Sender = other.MarshalPublicKey()
+		}, false},
+		{"members", func(m *pb.GroupMessage) {
+			m.Membership.Members = append(
+				m.Membership.Members, other.MarshalPublicKey(),
+			)
+		}, false},
+		{"no membership", func(m *pb.GroupMessage) {
+			m.Membership = nil
+		}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m := signed()
+			a := require.New(t)
+			tc.modify(m)
+			a.Equal(tc.valid, verifyGroupMessage(m))
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package kamune
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// GuestRole is the role of guest sessions, as returned by [Transport.Role].
+// See [ServeWithGuests].
+const GuestRole = "guest"
+
+// guestPolicy is the restrictions that [ServeWithGuests] puts on guests.
+type guestPolicy struct {
+	access   *AccessPolicy
+	duration time.Duration
+}
+
+// ServeWithGuests lets the server admit peers it does not know as guests, for
+// support-chat style services, instead of turning them away. A peer that the
+// [RemoteVerifier] rejects and that is not in storage is admitted, and its
+// session:
+//   - may send only on routes, besides control messages; other messages are
+//     reported by [Transport.Receive] as [ErrUnauthorizedRoute], as under an
+//     [AccessPolicy], and its role is [GuestRole];
+//   - is closed after duration, at [Transport.ExpiresAt];
+//   - leaves nothing in storage: no session record, resumption tokens,
+//     statistics, or journal, so it cannot be resumed.
+//
+// Handlers tell guests apart with [Transport.IsGuest]. Blocked peers are
+// still refused.
+func ServeWithGuests(duration time.Duration, routes ...Route) ServerOptions {
+	return func(s *Server) error {
+		if duration <= 0 {
+			return errors.New("guest duration must be positive")
+		}
+		access := NewAccessPolicy(GuestRole)
+		if err := access.Allow(GuestRole, routes...); err != nil {
+			return err
+		}
+		s.guests = &guestPolicy{access: access, duration: duration}
+		return nil
+	}
+}
+
+// admitsGuest reports whether peer, which the remote verifier rejected, may
+// be admitted as a guest.
+func (s *Server) admitsGuest(peer *storage.Peer) bool {
+	if s.guests == nil {
+		return false
+	}
+	_, err := s.storage.FindPeer(peer.PublicKey)
+	return err != nil
+}
+
+// admitGuest restricts t to what g allows a guest and closes it once its time
+// is up. The returned function stops the timer.
+func (t *Transport) admitGuest(g *guestPolicy) (stop func()) {
+	t.guest = true
+	t.expiresAt = t.established.Add(g.duration)
+	t.restrict(g.access, GuestRole)
+	timer := time.AfterFunc(g.duration, func() {
+		slog.Info(
+			"guest session expired",
+			slog.String("session_id", t.sessionID),
+		)
+		_ = t.Close()
+	})
+	return func() { timer.Stop() }
+}
+
+// IsGuest reports whether the remote peer was admitted as a guest; see
+// [ServeWithGuests].
+func (t *Transport) IsGuest() bool { return t.guest }
+
+// ExpiresAt returns the time a guest session is closed at, or the zero time
+// for other sessions.
+func (t *Transport) ExpiresAt() time.Time { return t.expiresAt }
@@ -0,0 +1,183 @@
+package kamune
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+func rejectAll(*storage.Storage, *storage.Peer) error {
+	return ErrVerificationFailed
+}
+
+// guestSession is what a server handler saw of a session.
+type guestSession struct {
+	expiresAt time.Time
+	sessionID string
+	role      string
+	// errs are the errors returned by Receive, the last one ending the
+	// session.
+	errs  []error
+	guest bool
+}
+
+// startGuestServer runs a server over store that rejects every peer through
+// its verifier and echoes messages back. What each handler saw is sent on the
+// returned channel once the session ends.
+func startGuestServer(
+	t *testing.T, store *storage.Storage, opts ...ServerOptions,
+) (string, <-chan guestSession) {
+	t.Helper()
+	sessions := make(chan guestSession, 8)
+	handler := func(tr *Transport) error {
+		gs := guestSession{
+			expiresAt: tr.ExpiresAt(),
+			sessionID: tr.SessionID(),
+			role:      tr.Role(),
+			guest:     tr.IsGuest(),
+		}
+		defer func() { sessions <- gs }()
+		for {
+			msg := Bytes(nil)
+			md, err := tr.Receive(msg)
+			if err != nil {
+				gs.errs = append(gs.errs, err)
+				if errors.Is(err, ErrUnauthorizedRoute) {
+					continue
+				}
+				return nil
+			}
+			if _, err := tr.Send(msg, md.Route()); err != nil {
+				return err
+			}
+		}
+	}
+	_, addr, _ := startTestServer(t, handler, store, rejectAll, opts...)
+	return addr, sessions
+}
+
+func TestServeWithGuests(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []ServerOptions
+		known  bool
+		admits bool
+	}{
+		{
+			name: "unknown peer",
+			opts: []ServerOptions{
+				ServeWithGuests(time.Minute, RouteExchangeMessages),
+			},
+			admits: true,
+		},
+		{
+			name: "known peer",
+			opts: []ServerOptions{
+				ServeWithGuests(time.Minute, RouteExchangeMessages),
+			},
+			known: true,
+		},
+		{name: "guests disabled"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			serverStore, cleanup := newTestStore(t)
+			defer cleanup()
+			addr, sessions := startGuestServer(t, serverStore, tc.opts...)
+
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			if tc.known {
+				at, err := store.Attester()
+				a.NoError(err)
+				a.NoError(serverStore.StorePeer(&storage.Peer{
+					Name: "known", PublicKey: at.MarshalPublicKey(),
+				}))
+			}
+			d, err := NewDialer(addr, store, storePeer)
+			a.NoError(err)
+			tr, err := d.Dial()
+			if !tc.admits {
+				a.Error(err)
+				return
+			}
+			a.NoError(err)
+
+			echo(t, tr, "hello")
+			_, err = tr.Send(Bytes([]byte("meta")), RouteSessionData)
+			a.NoError(err)
+			echo(t, tr, "still here")
+			a.NoError(tr.Close())
+
+			gs := <-sessions
+			a.True(gs.guest)
+			a.Equal(GuestRole, gs.role)
+			a.WithinDuration(
+				time.Now().Add(time.Minute), gs.expiresAt, 5*time.Second,
+			)
+			a.Len(gs.errs, 2)
+			a.ErrorIs(gs.errs[0], ErrUnauthorizedRoute)
+
+			// The guest left nothing behind.
+			_, err = serverStore.GetPeer(gs.sessionID)
+			a.Error(err)
+			usage, err := serverStore.SessionUsage(gs.sessionID)
+			a.NoError(err)
+			a.Zero(usage.Connections)
+			_, err = serverStore.FindPeer(d.PublicKey())
+			a.Error(err)
+		})
+	}
+}
+
+func TestServeWithGuests_Expiry(t *testing.T) {
+	a := require.New(t)
+	serverStore, cleanup := newTestStore(t)
+	defer cleanup()
+	addr, sessions := startGuestServer(
+		t, serverStore,
+		ServeWithGuests(200*time.Millisecond, RouteExchangeMessages),
+	)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	echo(t, tr, "hello")
+
+	_, err = tr.Receive(Bytes(nil))
+	a.ErrorIs(err, ErrPeerDisconnected)
+	select {
+	case gs := <-sessions:
+		a.True(gs.guest)
+	case <-time.After(5 * time.Second):
+		a.Fail("guest session did not end")
+	}
+}
+
+func TestServeWithGuests_Options(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	tests := []struct {
+		name string
+		opt  ServerOptions
+	}{
+		{name: "zero duration", opt: ServeWithGuests(0)},
+		{name: "invalid route", opt: ServeWithGuests(time.Minute, Route(-1))},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			_, err := NewServer("", nil, store, rejectAll, tc.opt)
+			a.Error(err)
+		})
+	}
+}
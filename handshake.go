@@ -16,8 +16,22 @@ import (
 
 type handshakeOpts struct {
 	remoteVerifier RemoteVerifier
-	sessionID      string
-	timeout        time.Duration
+	claimVerifier  ClaimVerifier
+	// negotiation is the negotiationHash of the peers' introductions, or zero
+	// when resuming, which skips them.
+	negotiation [32]byte
+	timer       *stepTimer
+	keyLog      *keyLog
+	intro       introFields
+	// secret replaces the MLKEM exchange with a secret the peers already
+	// share; see AdoptConn.
+	secret []byte
+	// paddingSeed seeds the padding of the session's frames; see
+	// ServeWithPaddingSeed.
+	paddingSeed []byte
+	sessionID   string
+	timeouts    HandshakeTimeouts
+	timeout     time.Duration
 }
 
 // requestHandshake initiates a handshake as the client/initiator.
@@ -33,9 +47,13 @@ func requestHandshake(
 	conn Conn, serde *signedSerde, opts handshakeOpts,
 ) (*Transport, error) {
 	// Step 1: Generate MLKEM keys and send handshake request
-	ml, err := exchange.NewMLKEM()
-	if err != nil {
-		return nil, fmt.Errorf("creating MLKEM keys: %w", err)
+	opts.timer.begin(StepHandshake)
+	var ml *exchange.MLKEM
+	if opts.secret == nil {
+		var err error
+		if ml, err = exchange.NewMLKEM(); err != nil {
+			return nil, fmt.Errorf("creating MLKEM keys: %w", err)
+		}
 	}
 	localSalt := randomBytes(handshakeSaltSize)
 	var sessionID, sessionKey string
@@ -48,10 +66,9 @@ func requestHandshake(
 		sessionKey = sessionID
 	}
 
-	req := &pb.Handshake{
-		Key:        ml.PublicKey.Bytes(),
-		Salt:       localSalt,
-		SessionKey: sessionKey,
+	req := &pb.Handshake{Salt: localSalt, SessionKey: sessionKey}
+	if ml != nil {
+		req.Key = ml.PublicKey.Bytes()
 	}
 
 	reqBytes, _, err := serde.serialize(req, RouteRequestHandshake, 0)
@@ -61,6 +78,8 @@ func requestHandshake(
 	if err = conn.WriteBytes(reqBytes); err != nil {
 		return nil, fmt.Errorf("writing handshake request: %w", err)
 	}
+	trace := opts.timer.traced()
+	trace.sent(RouteRequestHandshake)
 
 	// Step 2: Receive handshake response containing the encapsulated key
 	respBytes, err := conn.ReadBytes()
@@ -72,6 +91,7 @@ func requestHandshake(
 	if err != nil {
 		return nil, fmt.Errorf("deserializing handshake response: %w", err)
 	}
+	trace.received(md.Route())
 	if route := md.Route(); route != RouteAcceptHandshake {
 		return nil, fmt.Errorf(
 			"%w: expected %s, got %s",
@@ -90,13 +110,18 @@ func requestHandshake(
 		sessionID = sessionKey + resp.GetSessionKey()
 	}
 	// Bind later challenge material to the semantic handshake transcript
-	// (inner pb.Handshake fields only).
-	transcriptHash := handshakeTranscriptHash(req, &resp)
+	// (inner pb.Handshake fields and the negotiated parameters).
+	transcriptHash := handshakeTranscriptHash(req, &resp, opts.negotiation)
 
 	// Step 3: Decapsulate shared secret
-	secret, err := ml.Decapsulate(resp.GetKey())
-	if err != nil {
-		return nil, fmt.Errorf("decapsulating secret: %w", err)
+	secret := opts.secret
+	if ml != nil {
+		secret, err = ml.Decapsulate(resp.GetKey())
+		if err != nil {
+			return nil, fmt.Errorf("decapsulating secret: %w", err)
+		}
+	} else if len(resp.GetKey()) != 0 {
+		return nil, fmt.Errorf("%w: unexpected key", ErrVerificationFailed)
 	}
 	// Step 4: Create transport with encryption
 	encoder, err := enigma.NewEnigma(
@@ -111,10 +136,17 @@ func requestHandshake(
 	if err != nil {
 		return nil, fmt.Errorf("creating decrypter: %w", err)
 	}
+	opts.keyLog.write(sessionID, secret, localSalt, resp.GetSalt())
 
 	t := newTransport(conn, serde, sessionID, encoder, decoder)
+	if err := t.seedPadding(opts.paddingSeed, paddingC2SInfo); err != nil {
+		return nil, err
+	}
+	t.trace = trace
+	trace.session(sessionID)
 
 	// Step 5: Challenge exchange (bound to handshake transcript)
+	opts.timer.begin(StepChallenge)
 	err = sendChallenge(
 		t,
 		secret,
@@ -124,7 +156,13 @@ func requestHandshake(
 		return nil, fmt.Errorf("sending challenge: %w", err)
 	}
 
-	if err := acceptChallenge(t, RouteSendChallenge); err != nil {
+	err = acceptChallenge(
+		t,
+		RouteSendChallenge,
+		secret,
+		deriveChallengeInfo(sessionID, handshakeS2CInfo, transcriptHash),
+	)
+	if err != nil {
 		return nil, fmt.Errorf("accepting challenge: %w", err)
 	}
 
@@ -145,6 +183,7 @@ func acceptHandshake(
 	conn Conn, ut *signedSerde, opts handshakeOpts,
 ) (*Transport, error) {
 	// Step 1: Receive handshake request
+	opts.timer.begin(StepHandshake)
 	reqBytes, err := conn.ReadBytes()
 	if err != nil {
 		return nil, fmt.Errorf("reading handshake request: %w", err)
@@ -154,6 +193,8 @@ func acceptHandshake(
 	if err != nil {
 		return nil, fmt.Errorf("deserializing handshake request: %w", err)
 	}
+	trace := opts.timer.traced()
+	trace.received(md.Route())
 	if route := md.Route(); route != RouteRequestHandshake {
 		return nil, fmt.Errorf(
 			"%w: expected %s, got %s",
@@ -168,9 +209,14 @@ func acceptHandshake(
 	}
 
 	// Step 2: Encapsulate secret and prepare response
-	secret, ct, err := exchange.EncapsulateMLKEM(req.GetKey())
-	if err != nil {
-		return nil, fmt.Errorf("encapsulating key: %w", err)
+	secret, ct := opts.secret, []byte(nil)
+	if secret == nil {
+		secret, ct, err = exchange.EncapsulateMLKEM(req.GetKey())
+		if err != nil {
+			return nil, fmt.Errorf("encapsulating key: %w", err)
+		}
+	} else if len(req.GetKey()) != 0 {
+		return nil, fmt.Errorf("%w: unexpected key", ErrVerificationFailed)
 	}
 
 	remoteSalt := req.GetSalt()
@@ -202,10 +248,11 @@ func acceptHandshake(
 	if err = conn.WriteBytes(respBytes); err != nil {
 		return nil, fmt.Errorf("writing handshake response: %w", err)
 	}
+	trace.sent(RouteAcceptHandshake)
 
 	// Bind later challenge material to the semantic handshake transcript
-	// (inner pb.Handshake fields only).
-	transcriptHash := handshakeTranscriptHash(&req, resp)
+	// (inner pb.Handshake fields and the negotiated parameters).
+	transcriptHash := handshakeTranscriptHash(&req, resp, opts.negotiation)
 
 	// Step 3: Create transport with encryption
 	encoder, err := enigma.NewEnigma(
@@ -220,12 +267,25 @@ func acceptHandshake(
 	if err != nil {
 		return nil, fmt.Errorf("creating decrypter: %w", err)
 	}
+	opts.keyLog.write(sessionID, secret, remoteSalt, localSalt)
 
 	t := newTransport(conn, ut, sessionID, encoder, decoder)
+	if err := t.seedPadding(opts.paddingSeed, paddingS2CInfo); err != nil {
+		return nil, err
+	}
+	t.trace = trace
+	trace.session(sessionID)
 
 	// Step 4: Challenge exchange (bound to handshake transcript). Responder
 	// accepts initiator's challenge, then sends its own and verifies echo.
-	if err := acceptChallenge(t, RouteSendChallenge); err != nil {
+	opts.timer.begin(StepChallenge)
+	err = acceptChallenge(
+		t,
+		RouteSendChallenge,
+		secret,
+		deriveChallengeInfo(sessionID, handshakeC2SInfo, transcriptHash),
+	)
+	if err != nil {
 		return nil, fmt.Errorf("accepting challenge: %w", err)
 	}
 
@@ -273,7 +333,18 @@ func sendChallenge(t *Transport, secret, info []byte) error {
 }
 
 // acceptChallenge receives a challenge and echoes it back for verification.
-func acceptChallenge(t *Transport, expectedRoute Route) error {
+// The challenge must be the one derived from the shared secret and info, so
+// that a peer that saw a different handshake transcript, or negotiated
+// different parameters, is refused with [ErrVerificationFailed] before
+// anything is echoed.
+func acceptChallenge(
+	t *Transport, expectedRoute Route, secret, info []byte,
+) error {
+	expected, err := enigma.Derive(secret, nil, info, handshakeChallengeSize)
+	if err != nil {
+		return fmt.Errorf("deriving a challenge: %w", err)
+	}
+
 	r := Bytes(nil)
 	md, err := t.Receive(r)
 	if err != nil {
@@ -284,6 +355,9 @@ func acceptChallenge(t *Transport, expectedRoute Route) error {
 			"%w: expected %s, got %s", ErrUnexpectedRoute, expectedRoute, route,
 		)
 	}
+	if subtle.ConstantTimeCompare(r.Value, expected) != 1 {
+		return ErrVerificationFailed
+	}
 
 	if _, err := t.Send(Bytes(r.Value), RouteVerifyChallenge); err != nil {
 		return fmt.Errorf("sending: %w", err)
@@ -316,8 +390,13 @@ func validateHandshakeFields(salt []byte, sessionKey string) error {
 //   - initiator: MLKEM public key, salt, session prefix
 //   - responder: KEM enc, salt, session suffix
 //
+// along with the hash of the parameters negotiated in the introductions, so
+// that the challenges fail if a downgrade made the peers disagree on them.
+//
 // It returns a fixed-size array to avoid returning a heap slice.
-func handshakeTranscriptHash(req *pb.Handshake, resp *pb.Handshake) [32]byte {
+func handshakeTranscriptHash(
+	req *pb.Handshake, resp *pb.Handshake, negotiation [32]byte,
+) [32]byte {
 	h := sha256.New()
 	var b [4]byte
 
@@ -352,6 +431,9 @@ func handshakeTranscriptHash(req *pb.Handshake, resp *pb.Handshake) [32]byte {
 	_, _ = h.Write(b[:])
 	_, _ = h.Write([]byte(resp.GetSessionKey()))
 
+	// Negotiated parameters (fixed size)
+	_, _ = h.Write(negotiation[:])
+
 	var out [32]byte
 	copy(out[:], h.Sum(nil))
 	return out
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"net"
+	"slices"
 	"testing"
 	"time"
 
@@ -92,6 +93,86 @@ func TestHandshake(t *testing.T) {
 	a.Equal(metadata2.SequenceNum(), receivedMetadata2.SequenceNum())
 }
 
+func TestHandshake_Downgrade(t *testing.T) {
+	initiator := introParams{
+		version:      AppVersion,
+		service:      "chat",
+		capabilities: []string{capabilityDedup, capabilityRetransmit},
+	}
+	responder := introParams{
+		version:      AppVersion,
+		services:     []string{"chat", "bot"},
+		capabilities: []string{capabilityDedup, capabilityRetransmit},
+	}
+	tests := []struct {
+		name string
+		// tamper alters the initiator's introduction as the responder sees
+		// it.
+		tamper func(p *introParams)
+		err    error
+	}{
+		{name: "untouched", tamper: func(*introParams) {}},
+		{
+			name: "capability stripped",
+			tamper: func(p *introParams) {
+				p.capabilities = []string{capabilityDedup}
+			},
+			err: ErrVerificationFailed,
+		},
+		{
+			name:   "version downgraded",
+			tamper: func(p *introParams) { p.version = "0.0.1" },
+			err:    ErrVerificationFailed,
+		},
+		{
+			name:   "service dropped",
+			tamper: func(p *introParams) { p.service = "" },
+			err:    ErrVerificationFailed,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			c1, c2 := net.Pipe()
+			conn1 := newConn(c1)
+			conn2 := newConn(c2)
+			attest1, err := attest.New()
+			a.NoError(err)
+			attest2, err := attest.New()
+			a.NoError(err)
+			serde1 := newSignedSerde(attest2.MarshalPublicKey(), attest1)
+			serde2 := newSignedSerde(attest1.MarshalPublicKey(), attest2)
+
+			seen := initiator
+			seen.capabilities = slices.Clone(initiator.capabilities)
+			tc.tamper(&seen)
+			opts1 := handshakeOpts{
+				negotiation: negotiationHash(initiator, responder),
+			}
+			opts2 := handshakeOpts{negotiation: negotiationHash(seen, responder)}
+
+			// The responder checks the initiator's challenge first, and
+			// hangs up if it does not match.
+			done := make(chan error, 1)
+			go func() {
+				_, err := acceptHandshake(conn2, serde2, opts2)
+				_ = conn2.Close()
+				done <- err
+			}()
+			_, err = requestHandshake(conn1, serde1, opts1)
+			_ = conn1.Close()
+			acceptErr := <-done
+			if tc.err != nil {
+				a.ErrorIs(acceptErr, tc.err)
+				a.Error(err)
+				return
+			}
+			a.NoError(acceptErr)
+			a.NoError(err)
+		})
+	}
+}
+
 func BenchmarkValidateHandshakeFields_OK(b *testing.B) {
 	salt := make([]byte, handshakeSaltSize)
 	sessionKey := bytes.Repeat([]byte{'A'}, sessionIDLength/2)
@@ -153,12 +234,13 @@ func BenchmarkHandshakeTranscriptHash_HandshakeFieldsTypical(b *testing.B) {
 			4 + len(req.GetSessionKey()) +
 			4 + len(resp.GetKey()) +
 			4 + len(resp.GetSalt()) +
-			4 + len(resp.GetSessionKey())
+			4 + len(resp.GetSessionKey()) +
+			32
 
 	b.ReportAllocs()
 	b.SetBytes(int64(totalBytes))
 	for b.Loop() {
-		_ = handshakeTranscriptHash(req, resp)
+		_ = handshakeTranscriptHash(req, resp, [32]byte{})
 	}
 }
 
@@ -0,0 +1,91 @@
+package kamune
+
+import (
+	"fmt"
+	"sync"
+)
+
+// maxHeartbeatSize is the largest payload a heartbeat may carry. Heartbeats
+// are meant for small status such as unread counts, not for messages.
+const maxHeartbeatSize = 1024
+
+// HeartbeatProvider returns the payload to attach to the next ping or pong
+// sent on a session, or nil to send none. See
+// [Transport.SetHeartbeatPayload].
+type HeartbeatProvider func() []byte
+
+// HeartbeatHandler is called with the payload of each ping or pong the peer
+// attaches one to. See [Transport.HandleHeartbeats].
+type HeartbeatHandler func(payload []byte)
+
+// heartbeat holds a session's heartbeat provider and handler.
+type heartbeat struct {
+	provider HeartbeatProvider
+	handler  HeartbeatHandler
+	mu       sync.Mutex
+}
+
+// SetHeartbeatPayload sets the provider of the payload attached to every
+// ping and pong sent on the session, such as presence details or unread
+// counts. It saves applications a separate periodic message: the payload
+// rides on the keep-alive frames, is delivered to the peer's
+// [HeartbeatHandler], and never reaches chat history or the journal as a
+// message of its own. The provider is called by the sending goroutine and
+// must be quick; a payload larger than 1 KiB fails the send with
+// [ErrMessageTooLarge]. A nil provider stops attaching payloads.
+func (t *Transport) SetHeartbeatPayload(p HeartbeatProvider) {
+	t.heartbeat.mu.Lock()
+	defer t.heartbeat.mu.Unlock()
+	t.heartbeat.provider = p
+}
+
+// HandleHeartbeats sets the handler that receives the payloads the peer
+// attaches to its pings and pongs; see [Transport.SetHeartbeatPayload]. The
+// frames themselves are still returned by [Transport.Receive], so the handler
+// is only called while the session is being received from, and must not
+// block. Payloads are dropped while no handler is set.
+func (t *Transport) HandleHeartbeats(h HeartbeatHandler) {
+	t.heartbeat.mu.Lock()
+	defer t.heartbeat.mu.Unlock()
+	t.heartbeat.handler = h
+}
+
+// payload returns the payload to attach to a message sent on route.
+func (h *heartbeat) payload(route Route) ([]byte, error) {
+	if !isHeartbeatRoute(route) {
+		return nil, nil
+	}
+	h.mu.Lock()
+	provider := h.provider
+	h.mu.Unlock()
+	if provider == nil {
+		return nil, nil
+	}
+
+	p := provider()
+	if len(p) > maxHeartbeatSize {
+		return nil, fmt.Errorf(
+			"%w: heartbeat payload of %d bytes", ErrMessageTooLarge, len(p),
+		)
+	}
+	return p, nil
+}
+
+// deliver hands the heartbeat payload of a received message, if it has one,
+// to the handler.
+func (h *heartbeat) deliver(md *Metadata) {
+	p := md.pb.GetHeartbeat()
+	if len(p) == 0 || !isHeartbeatRoute(md.Route()) {
+		return
+	}
+	h.mu.Lock()
+	handler := h.handler
+	h.mu.Unlock()
+	if handler != nil {
+		handler(p)
+	}
+}
+
+func isHeartbeatRoute(r Route) bool {
+	return r == RoutePing || r == RoutePong
+}
@@ -0,0 +1,122 @@
+package kamune
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startHeartbeatServer runs a server that answers pings with pongs carrying
+// payload, and sends the heartbeat payloads it receives on the returned
+// channel.
+func startHeartbeatServer(
+	t *testing.T, payload []byte,
+) (string, <-chan []byte) {
+	t.Helper()
+	received := make(chan []byte, 8)
+	handler := func(tr *Transport) error {
+		if payload != nil {
+			tr.SetHeartbeatPayload(func() []byte { return payload })
+		}
+		tr.HandleHeartbeats(func(p []byte) { received <- p })
+		for {
+			msg := Bytes(nil)
+			md, err := tr.Receive(msg)
+			if err != nil {
+				return nil
+			}
+			if md.Route() != RoutePing {
+				continue
+			}
+			if _, err := tr.Send(msg, RoutePong); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, addr, _ := startTestServer(t, handler, nil, acceptAll)
+	return addr, received
+}
+
+func TestTransport_Heartbeat(t *testing.T) {
+	tests := []struct {
+		name   string
+		client []byte
+		server []byte
+	}{
+		{name: "both", client: []byte("unread:3"), server: []byte("away")},
+		{name: "client only", client: []byte("typing")},
+		{name: "server only", server: []byte("online")},
+		{name: "neither"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			addr, serverGot := startHeartbeatServer(t, tc.server)
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			d, err := NewDialer(addr, store, acceptAll)
+			a.NoError(err)
+			tr, err := d.Dial()
+			a.NoError(err)
+			defer tr.Close()
+
+			clientGot := make(chan []byte, 8)
+			tr.HandleHeartbeats(func(p []byte) { clientGot <- p })
+			if tc.client != nil {
+				tr.SetHeartbeatPayload(func() []byte { return tc.client })
+			}
+
+			_, err = tr.Send(Bytes([]byte("token")), RoutePing)
+			a.NoError(err)
+			reply := Bytes(nil)
+			md, err := tr.Receive(reply)
+			a.NoError(err)
+			a.Equal(RoutePong, md.Route())
+			a.Equal("token", string(reply.Value))
+
+			// The pong was received, so the server has handled the ping.
+			if tc.client != nil {
+				a.True(bytes.Equal(tc.client, <-serverGot))
+			}
+			a.Empty(serverGot)
+			if tc.server != nil {
+				a.True(bytes.Equal(tc.server, <-clientGot))
+			}
+			a.Empty(clientGot)
+
+			// Other messages carry no heartbeat.
+			_, err = tr.Send(Bytes([]byte("hello")), RouteExchangeMessages)
+			a.NoError(err)
+			_, err = tr.Send(Bytes([]byte("token")), RoutePing)
+			a.NoError(err)
+			_, err = tr.Receive(Bytes(nil))
+			a.NoError(err)
+			if tc.client != nil {
+				a.Len(serverGot, 1)
+			} else {
+				a.Empty(serverGot)
+			}
+		})
+	}
+}
+
+func TestTransport_HeartbeatTooLarge(t *testing.T) {
+	a := require.New(t)
+	addr, _ := startHeartbeatServer(t, nil)
+	tr := dialTransfer(t, addr)
+
+	tr.SetHeartbeatPayload(func() []byte {
+		return make([]byte, maxHeartbeatSize+1)
+	})
+	_, err := tr.Send(Bytes(nil), RoutePing)
+	a.ErrorIs(err, ErrMessageTooLarge)
+	// Only keep-alive frames carry the payload.
+	_, err = tr.Send(Bytes(nil), RouteExchangeMessages)
+	a.NoError(err)
+
+	tr.SetHeartbeatPayload(nil)
+	_, err = tr.Send(Bytes(nil), RoutePing)
+	a.NoError(err)
+}
@@ -0,0 +1,93 @@
+package kamune
+
+import (
+	"cmp"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/clock"
+)
+
+const (
+	// hybridLogicalBits is the width of the counter in a [HybridTime].
+	hybridLogicalBits = 16
+
+	// maxHybridDrift is how far ahead of the local clock a peer's reading may
+	// be and still be adopted. A peer whose clock is further ahead does not
+	// drag the local clock with it.
+	maxHybridDrift = time.Minute
+)
+
+// HybridTime is a reading of a hybrid logical clock: milliseconds since the
+// Unix epoch in its high 48 bits and a counter in its low 16 bits. Every
+// message carries the sender's reading, and every message received moves the
+// receiver's clock past it, so a reply always orders after the message it
+// answers, however skewed the peers' wall clocks are. Readings stay close to
+// wall-clock time, and order like integers.
+//
+// Readings of different peers can be equal; callers that need a total order
+// break ties with something unique, such as the message ID.
+type HybridTime uint64
+
+// hybridTimeOf returns the reading for the wall-clock time t, with a zero
+// counter.
+func hybridTimeOf(t time.Time) HybridTime {
+	return HybridTime(uint64(t.UnixMilli()) << hybridLogicalBits)
+}
+
+// Time returns the wall-clock part of the reading.
+func (h HybridTime) Time() time.Time {
+	return time.UnixMilli(int64(h >> hybridLogicalBits))
+}
+
+// Logical returns the counter part of the reading, which orders readings
+// with the same wall-clock part.
+func (h HybridTime) Logical() uint16 { return uint16(h) }
+
+// Compare returns -1, 0, or +1 depending on whether h orders before, with, or
+// after o.
+func (h HybridTime) Compare(o HybridTime) int { return cmp.Compare(h, o) }
+
+// String returns the reading as its wall-clock time and counter.
+func (h HybridTime) String() string {
+	return fmt.Sprintf(
+		"%s+%d", h.Time().UTC().Format(time.RFC3339Nano), h.Logical(),
+	)
+}
+
+// hybridClock is a hybrid logical clock. Readings are strictly increasing.
+type hybridClock struct {
+	clock clock.Clock
+	last  HybridTime
+	mu    sync.Mutex
+}
+
+// processClock is shared by every session of the process, so that the
+// messages of all sessions, and of all peers they talk to, are ordered
+// together.
+var processClock = newHybridClock(clock.Real())
+
+func newHybridClock(c clock.Clock) *hybridClock {
+	return &hybridClock{clock: c}
+}
+
+// now returns a reading for a local event, such as sending a message.
+func (c *hybridClock) now() HybridTime {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = max(c.last+1, hybridTimeOf(c.clock.Now()))
+	return c.last
+}
+
+// observe moves the clock past a reading received from a peer. Readings too
+// far ahead of the local clock are ignored.
+func (c *hybridClock) observe(remote HybridTime) {
+	wall := c.clock.Now()
+	if remote.Time().After(wall.Add(maxHybridDrift)) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = max(c.last, remote)
+}
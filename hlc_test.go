@@ -0,0 +1,107 @@
+package kamune
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/internal/clock"
+	"github.com/kamune-org/kamune/pkg/attest"
+)
+
+func TestHybridTime(t *testing.T) {
+	a := require.New(t)
+	wall := time.Date(2026, 1, 2, 3, 4, 5, 6_000_000, time.UTC)
+	h := hybridTimeOf(wall) + 3
+	a.True(wall.Equal(h.Time()))
+	a.EqualValues(3, h.Logical())
+	a.Equal("2026-01-02T03:04:05.006Z+3", h.String())
+	a.Equal(-1, h.Compare(h+1))
+	a.Equal(1, (h + 1).Compare(h))
+	a.Equal(0, h.Compare(h))
+	a.Equal(-1, h.Compare(hybridTimeOf(wall.Add(time.Millisecond))))
+}
+
+func TestHybridClock(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		// run drives the clock and returns readings expected to increase.
+		run func(c *hybridClock, fake *clock.Fake) []HybridTime
+	}{
+		{
+			name: "wall clock stalls",
+			run: func(c *hybridClock, _ *clock.Fake) []HybridTime {
+				return []HybridTime{c.now(), c.now(), c.now()}
+			},
+		},
+		{
+			name: "wall clock goes back",
+			run: func(c *hybridClock, fake *clock.Fake) []HybridTime {
+				first := c.now()
+				fake.Advance(-time.Hour)
+				return []HybridTime{first, c.now()}
+			},
+		},
+		{
+			name: "peer is ahead",
+			run: func(c *hybridClock, _ *clock.Fake) []HybridTime {
+				before := c.now()
+				remote := hybridTimeOf(start.Add(30*time.Second)) + 7
+				c.observe(remote)
+				return []HybridTime{before, remote, c.now()}
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			fake := clock.NewFake(start)
+			readings := tc.run(newHybridClock(fake), fake)
+			for i := 1; i < len(readings); i++ {
+				a.Less(readings[i-1], readings[i])
+			}
+		})
+	}
+}
+
+func TestHybridClock_IgnoresFarFuture(t *testing.T) {
+	a := require.New(t)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newHybridClock(clock.NewFake(start))
+	c.observe(hybridTimeOf(start.Add(maxHybridDrift + time.Second)))
+	a.Equal(hybridTimeOf(start), c.now())
+}
+
+// TestSerde_HybridTime checks that a reply orders after the message it
+// answers when the replying peer's wall clock is behind.
+func TestSerde_HybridTime(t *testing.T) {
+	a := require.New(t)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	alice, err := attest.New()
+	a.NoError(err)
+	bob, err := attest.New()
+	a.NoError(err)
+
+	aliceSerde := newSignedSerde(bob.MarshalPublicKey(), alice)
+	aliceSerde.clock = newHybridClock(
+		clock.NewFake(start.Add(20 * time.Second)),
+	)
+	bobSerde := newSignedSerde(alice.MarshalPublicKey(), bob)
+	bobSerde.clock = newHybridClock(clock.NewFake(start))
+
+	payload, sent, err := aliceSerde.serialize(
+		Bytes([]byte("ping")), RouteExchangeMessages, 1,
+	)
+	a.NoError(err)
+	received, err := bobSerde.deserialize(payload, Bytes(nil))
+	a.NoError(err)
+	a.Equal(sent.HybridTime(), received.HybridTime())
+
+	_, reply, err := bobSerde.serialize(
+		Bytes([]byte("pong")), RouteExchangeMessages, 1,
+	)
+	a.NoError(err)
+	a.Equal(1, reply.HybridTime().Compare(sent.HybridTime()))
+}
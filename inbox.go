@@ -0,0 +1,324 @@
+package kamune
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// InboundMessage is a message taken from a server's inbox with
+// [Server.NextMessage], or handed to [Handlers.OnMessage].
+type InboundMessage struct {
+	// Transport is the session the message arrived on. Replies are sent on it
+	// as usual.
+	Transport *Transport
+	Metadata  *Metadata
+	// Err reports a frame the session rejected instead of a message. Unless it
+	// is [ErrUnauthorizedRoute], the session has ended and this is its last
+	// message.
+	Err  error
+	data []byte
+}
+
+// Decode decodes the message into dst.
+func (m *InboundMessage) Decode(dst Transferable) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	return m.Transport.unmarshal(m.data, dst)
+}
+
+// inbox collects the messages of every session of a server into per-session
+// queues and hands them out in round-robin order, so that a peer that floods
+// its session only ever gets its turn. A full queue stops reading from its
+// session until the application catches up, which pushes back on that peer
+// alone.
+//
+// With spilling enabled, a queue holding size messages keeps up to spill more
+// in storage before it counts as full, so that an application that is briefly
+// busy does not hold back the network. They are loaded back, in order, as the
+// messages held in memory are taken.
+type inbox struct {
+	// ready holds the queues with pending messages, in the order they are
+	// served.
+	ready []*inboxQueue
+	// wake is closed, and replaced, when a queue becomes ready.
+	wake   chan struct{}
+	done   chan struct{}
+	store  *storage.Storage
+	size   int
+	spill  int
+	mu     sync.Mutex
+	closed bool
+}
+
+type inboxQueue struct {
+	// t is the session the queue is read from, whose statistics count the
+	// queued messages.
+	t *Transport
+	// msgs holds the oldest queued messages in memory. Once it holds the
+	// inbox's size, newer messages are spilled to storage, and those that
+	// cannot be, such as errors, wait in tail behind them.
+	msgs []*InboundMessage
+	tail []*InboundMessage
+	// space holds a token for every queued message, wherever it is kept.
+	space chan struct{}
+	// id identifies the queue's spilled messages in storage. They are
+	// numbered from one; taken of them have been loaded back and spilled
+	// have been written.
+	id      uint64
+	spilled uint64
+	taken   uint64
+	// unspillable is set once spilling failed, such as because the session
+	// is not recorded in storage, so that it is not tried again.
+	unspillable bool
+}
+
+func newInbox(size int) *inbox {
+	return &inbox{
+		wake: make(chan struct{}),
+		done: make(chan struct{}),
+		size: size,
+	}
+}
+
+// queue returns a new queue for the messages of t.
+func (b *inbox) queue(t *Transport) *inboxQueue {
+	return &inboxQueue{
+		t:     t,
+		space: make(chan struct{}, b.size+b.spill),
+		id:    mathrand.Uint64(),
+	}
+}
+
+// count adjusts the backlog statistics of the queue's session.
+func (q *inboxQueue) count(backlog, spilled int64) {
+	if q.t == nil {
+		return
+	}
+	q.t.stats.backlog.Add(backlog)
+	q.t.stats.spilled.Add(spilled)
+}
+
+// serve is the handler of every session of a server with an inbox. Pings need
+// no application logic, so they are answered right away instead of queued.
+func (b *inbox) serve(t *Transport) error {
+	q := b.queue(t)
+	for {
+		md, msg, err := t.receive()
+		switch {
+		case err == nil: // continue
+		case errors.Is(err, ErrUnauthorizedRoute):
+			if !b.push(q, &InboundMessage{Transport: t, Err: err}) {
+				return nil
+			}
+			continue
+		default:
+			b.push(q, &InboundMessage{Transport: t, Err: err})
+			if errors.Is(err, ErrPeerDisconnected) ||
+				errors.Is(err, ErrConnClosed) {
+				return nil
+			}
+			return fmt.Errorf("receiving: %w", err)
+		}
+
+		if md.Route() == RoutePing {
+			token := Bytes(nil)
+			if err := t.unmarshal(msg, token); err != nil {
+				return err
+			}
+			if _, err := t.Send(token, RoutePong); err != nil {
+				return fmt.Errorf("answering ping: %w", err)
+			}
+			continue
+		}
+		if !b.push(q, &InboundMessage{Transport: t, Metadata: md, data: msg}) {
+			return nil
+		}
+	}
+}
+
+// push appends m to q, blocking while q is full. It reports false once the
+// inbox is closed.
+func (b *inbox) push(q *inboxQueue, m *InboundMessage) bool {
+	select {
+	case q.space <- struct{}{}:
+	case <-b.done:
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return false
+	}
+	switch {
+	case len(q.msgs) < b.size && q.taken == q.spilled && len(q.tail) == 0:
+		q.msgs = append(q.msgs, m)
+	case len(q.tail) == 0 && b.spillMessage(q, m):
+	default:
+		q.tail = append(q.tail, m)
+	}
+	q.count(1, 0)
+	if len(q.msgs) == 1 {
+		b.ready = append(b.ready, q)
+		close(b.wake)
+		b.wake = make(chan struct{})
+	}
+	return true
+}
+
+// spillMessage writes m to storage behind the messages q holds in memory. It
+// reports false if m cannot be spilled, so that it is kept in memory instead.
+func (b *inbox) spillMessage(q *inboxQueue, m *InboundMessage) bool {
+	if b.store == nil || q.unspillable || m.Err != nil || m.Metadata == nil ||
+		m.Metadata.Ephemeral() {
+		return false
+	}
+	data, err := proto.Marshal(&pb.SignedTransport{
+		Data:      m.data,
+		Signature: m.Metadata.signature,
+		Metadata:  m.Metadata.raw,
+	})
+	if err == nil {
+		err = b.store.SpillMessage(
+			m.Transport.SessionID(), q.id, q.spilled+1, data,
+		)
+	}
+	if err != nil {
+		q.unspillable = true
+		slog.Warn(
+			"keeping inbox messages in memory",
+			slog.String("session_id", m.Transport.SessionID()),
+			slog.Any("error", err),
+		)
+		return false
+	}
+	q.spilled++
+	q.count(0, 1)
+	return true
+}
+
+// refill moves the oldest message kept outside of memory, if any, behind the
+// messages q holds in memory.
+func (b *inbox) refill(q *inboxQueue) {
+	for q.taken < q.spilled {
+		q.taken++
+		q.count(0, -1)
+		m, err := b.loadMessage(q, q.taken)
+		if err == nil {
+			q.msgs = append(q.msgs, m)
+			return
+		}
+		// The message is lost, but the queue must go on.
+		slog.Error(
+			"dropping spilled inbox message",
+			slog.String("session_id", q.t.SessionID()),
+			slog.Any("error", err),
+		)
+		q.count(-1, 0)
+		<-q.space
+	}
+	if len(q.tail) > 0 {
+		q.msgs = append(q.msgs, q.tail[0])
+		q.tail[0] = nil
+		q.tail = q.tail[1:]
+	}
+}
+
+// loadMessage takes the spilled message numbered number of q from storage.
+func (b *inbox) loadMessage(
+	q *inboxQueue, number uint64,
+) (*InboundMessage, error) {
+	data, err := b.store.TakeSpilledMessage(q.t.SessionID(), q.id, number)
+	if err != nil {
+		return nil, err
+	}
+	var st pb.SignedTransport
+	if err := proto.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("unmarshalling spilled message: %w", err)
+	}
+	var md pb.Metadata
+	if err := proto.Unmarshal(st.GetMetadata(), &md); err != nil {
+		return nil, fmt.Errorf("unmarshalling metadata: %w", err)
+	}
+	return &InboundMessage{
+		Transport: q.t,
+		Metadata: &Metadata{
+			pb:        &md,
+			raw:       st.GetMetadata(),
+			message:   st.GetData(),
+			signature: st.GetSignature(),
+		},
+		data: st.GetData(),
+	}, nil
+}
+
+// next takes one message from the queue whose turn it is, waiting for one to
+// arrive if all queues are empty.
+func (b *inbox) next(ctx context.Context) (*InboundMessage, error) {
+	for {
+		b.mu.Lock()
+		if len(b.ready) > 0 {
+			q := b.ready[0]
+			b.ready = b.ready[1:]
+			m := q.msgs[0]
+			q.msgs[0] = nil
+			q.msgs = q.msgs[1:]
+			b.refill(q)
+			if len(q.msgs) > 0 {
+				b.ready = append(b.ready, q)
+			}
+			q.count(-1, 0)
+			b.mu.Unlock()
+			<-q.space
+			return m, nil
+		}
+		if b.closed {
+			b.mu.Unlock()
+			return nil, ErrClosedServer
+		}
+		wake := b.wake
+		b.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// close makes pending and future pushes fail. Messages already queued can
+// still be taken.
+func (b *inbox) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	close(b.done)
+	close(b.wake)
+}
+
+// NextMessage returns the next message received on any of the server's
+// sessions, waiting until one arrives or ctx is done. Sessions with pending
+// messages take turns, one message each, so a peer sending faster than the
+// application can process holds back only its own session. It returns
+// [ErrInboxDisabled] unless the server was created with [ServeWithInbox], and
+// [ErrClosedServer] once the server is closed and no queued messages remain.
+func (s *Server) NextMessage(ctx context.Context) (*InboundMessage, error) {
+	if s.inbox == nil {
+		return nil, ErrInboxDisabled
+	}
+	return s.inbox.next(ctx)
+}
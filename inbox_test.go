@@ -0,0 +1,235 @@
+package kamune
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInbox_RoundRobin(t *testing.T) {
+	a := require.New(t)
+	b := newInbox(3)
+	flood := &inboxQueue{space: make(chan struct{}, 3)}
+	quiet := &inboxQueue{space: make(chan struct{}, 3)}
+	msg := func(q *inboxQueue, id string) {
+		a.True(b.push(q, &InboundMessage{data: []byte(id)}))
+	}
+	msg(flood, "f1")
+	msg(flood, "f2")
+	msg(flood, "f3")
+	msg(quiet, "q1")
+
+	// The flooding queue is full, so the next push waits for room.
+	pushed := make(chan struct{})
+	go func() {
+		msg(flood, "f4")
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		a.Fail("push into a full queue did not block")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	var order []string
+	for range 5 {
+		m, err := b.next(context.Background())
+		a.NoError(err)
+		order = append(order, string(m.data))
+	}
+	<-pushed
+	a.Equal([]string{"f1", "q1", "f2", "f3", "f4"}, order)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err := b.next(ctx)
+	a.ErrorIs(err, context.DeadlineExceeded)
+}
+
+func TestInbox_Close(t *testing.T) {
+	a := require.New(t)
+	b := newInbox(1)
+	q := &inboxQueue{space: make(chan struct{}, 1)}
+	a.True(b.push(q, &InboundMessage{data: []byte("queued")}))
+
+	blocked := make(chan bool)
+	go func() { blocked <- b.push(q, &InboundMessage{}) }()
+	b.close()
+	a.False(<-blocked)
+
+	// Queued messages outlive the inbox.
+	m, err := b.next(context.Background())
+	a.NoError(err)
+	a.Equal("queued", string(m.data))
+	_, err = b.next(context.Background())
+	a.ErrorIs(err, ErrClosedServer)
+}
+
+func TestServer_NextMessage(t *testing.T) {
+	a := require.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	serverStore, cleanup := newTestStore(t)
+	defer cleanup()
+	srv, err := NewServer(
+		"", nil, serverStore, acceptAll,
+		ServeWithListener(&tcpListener{Listener: l}),
+		ServeWithInbox(4),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	dial := func() *Transport {
+		store, cleanup := newTestStore(t)
+		t.Cleanup(cleanup)
+		d, err := NewDialer(l.Addr().String(), store, acceptAll)
+		a.NoError(err)
+		tr, err := d.Dial()
+		a.NoError(err)
+		return tr
+	}
+	flooder, quiet := dial(), dial()
+
+	// A ping is answered without the application's involvement.
+	_, err = quiet.Send(Bytes([]byte("token")), RoutePing)
+	a.NoError(err)
+	pong := Bytes(nil)
+	md, err := quiet.Receive(pong)
+	a.NoError(err)
+	a.Equal(RoutePong, md.Route())
+	a.Equal("token", string(pong.GetValue()))
+
+	for range 20 {
+		_, err := flooder.Send(Bytes([]byte("flood")), RouteExchangeMessages)
+		a.NoError(err)
+	}
+	_, err = quiet.Send(Bytes([]byte("quiet")), RouteExchangeMessages)
+	a.NoError(err)
+	a.Eventually(func() bool {
+		srv.inbox.mu.Lock()
+		defer srv.inbox.mu.Unlock()
+		return len(srv.inbox.ready) == 2
+	}, time.Second, time.Millisecond)
+
+	// The quiet session is served within one turn of the flooding one.
+	ctx := context.Background()
+	var turn int
+	for turn = 1; ; turn++ {
+		m, err := srv.NextMessage(ctx)
+		a.NoError(err)
+		msg := Bytes(nil)
+		a.NoError(m.Decode(msg))
+		if string(msg.GetValue()) != "quiet" {
+			continue
+		}
+		_, err = m.Transport.Send(Bytes([]byte("ack")), RouteExchangeMessages)
+		a.NoError(err)
+		break
+	}
+	a.LessOrEqual(turn, 2)
+	reply := Bytes(nil)
+	_, err = quiet.Receive(reply)
+	a.NoError(err)
+	a.Equal("ack", string(reply.GetValue()))
+
+	// The end of a session is reported as its last message.
+	a.NoError(quiet.Close())
+	for {
+		m, err := srv.NextMessage(ctx)
+		a.NoError(err)
+		if m.Transport.SessionID() == quiet.SessionID() {
+			a.ErrorIs(m.Err, ErrPeerDisconnected)
+			break
+		}
+	}
+	a.NoError(flooder.Close())
+}
+
+func TestServer_InboxSpill(t *testing.T) {
+	a := require.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	serverStore, cleanup := newTestStore(t)
+	defer cleanup()
+	srv, err := NewServer(
+		"", nil, serverStore, storePeer,
+		ServeWithListener(&tcpListener{Listener: l}),
+		ServeWithInbox(2),
+		ServeWithInboxSpill(3),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(l.Addr().String(), store, storePeer)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	for i := range 8 {
+		text := fmt.Sprintf("m%d", i)
+		_, err := tr.Send(Bytes([]byte(text)), RouteExchangeMessages)
+		a.NoError(err)
+	}
+
+	// Two messages are held in memory and three in storage, after which the
+	// session is no longer read.
+	var st *Transport
+	a.Eventually(func() bool {
+		var ok bool
+		st, ok = srv.SessionRegistry().Get(tr.SessionID())
+		return ok && st.Stats().Backlog == 5
+	}, 5*time.Second, time.Millisecond)
+	a.EqualValues(3, st.Stats().Spilled)
+	time.Sleep(20 * time.Millisecond)
+	a.EqualValues(5, st.Stats().Backlog)
+
+	ctx := context.Background()
+	var seq uint64
+	for i := range 8 {
+		m, err := srv.NextMessage(ctx)
+		a.NoError(err)
+		msg := Bytes(nil)
+		a.NoError(m.Decode(msg))
+		a.Equal(fmt.Sprintf("m%d", i), string(msg.GetValue()))
+		a.Equal(RouteExchangeMessages, m.Metadata.Route())
+		if i > 0 {
+			a.Equal(seq+1, m.Metadata.SequenceNum())
+		}
+		seq = m.Metadata.SequenceNum()
+	}
+	a.Eventually(func() bool {
+		stats := st.Stats()
+		return stats.Backlog == 0 && stats.Spilled == 0
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestServeWithInbox(t *testing.T) {
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	handler := func(*Transport) error { return nil }
+
+	_, err := NewServer("", handler, store, acceptAll, ServeWithInbox(8))
+	a.Error(err, "handler and inbox")
+	_, err = NewServer("", nil, store, acceptAll, ServeWithInbox(0))
+	a.Error(err)
+	_, err = NewServer(
+		"", nil, store, acceptAll, ServeWithInbox(8), ServeWithInboxSpill(0),
+	)
+	a.Error(err)
+	_, err = NewServer("", handler, store, acceptAll, ServeWithInboxSpill(8))
+	a.Error(err, "spill without inbox")
+
+	srv, err := NewServer("", handler, store, acceptAll)
+	a.NoError(err)
+	_, err = srv.NextMessage(context.Background())
+	a.ErrorIs(err, ErrInboxDisabled)
+}
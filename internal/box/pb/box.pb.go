@@ -39,6 +39,17 @@ const (
 	Route_ROUTE_RESUME_REQUEST     Route = 11
 	Route_ROUTE_RESUME_ACCEPT      Route = 12
 	Route_ROUTE_SESSION_DATA       Route = 13
+	Route_ROUTE_MIGRATE_REQUEST    Route = 14
+	Route_ROUTE_MIGRATE_ACCEPT     Route = 15
+	Route_ROUTE_TRANSFER_OFFER     Route = 16
+	Route_ROUTE_TRANSFER_REPLY     Route = 17
+	Route_ROUTE_TRANSFER_DATA      Route = 18
+	Route_ROUTE_WIPE_REQUEST       Route = 19
+	Route_ROUTE_WIPE_ACCEPT        Route = 20
+	Route_ROUTE_RECONNECT_HINT     Route = 21
+	Route_ROUTE_GROUP_MESSAGE      Route = 22
+	Route_ROUTE_ACK                Route = 23
+	Route_ROUTE_TYPED_MESSAGE      Route = 24
 )
 
 // Enum value maps for Route.
@@ -58,6 +69,17 @@ var (
 		11: "ROUTE_RESUME_REQUEST",
 		12: "ROUTE_RESUME_ACCEPT",
 		13: "ROUTE_SESSION_DATA",
+		14: "ROUTE_MIGRATE_REQUEST",
+		15: "ROUTE_MIGRATE_ACCEPT",
+		16: "ROUTE_TRANSFER_OFFER",
+		17: "ROUTE_TRANSFER_REPLY",
+		18: "ROUTE_TRANSFER_DATA",
+		19: "ROUTE_WIPE_REQUEST",
+		20: "ROUTE_WIPE_ACCEPT",
+		21: "ROUTE_RECONNECT_HINT",
+		22: "ROUTE_GROUP_MESSAGE",
+		23: "ROUTE_ACK",
+		24: "ROUTE_TYPED_MESSAGE",
 	}
 	Route_value = map[string]int32{
 		"ROUTE_INVALID":            0,
@@ -74,6 +96,17 @@ var (
 		"ROUTE_RESUME_REQUEST":     11,
 		"ROUTE_RESUME_ACCEPT":      12,
 		"ROUTE_SESSION_DATA":       13,
+		"ROUTE_MIGRATE_REQUEST":    14,
+		"ROUTE_MIGRATE_ACCEPT":     15,
+		"ROUTE_TRANSFER_OFFER":     16,
+		"ROUTE_TRANSFER_REPLY":     17,
+		"ROUTE_TRANSFER_DATA":      18,
+		"ROUTE_WIPE_REQUEST":       19,
+		"ROUTE_WIPE_ACCEPT":        20,
+		"ROUTE_RECONNECT_HINT":     21,
+		"ROUTE_GROUP_MESSAGE":      22,
+		"ROUTE_ACK":                23,
+		"ROUTE_TYPED_MESSAGE":      24,
 	}
 )
 
@@ -178,6 +211,11 @@ type Metadata struct {
 	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"`
 	Sequence      uint64                 `protobuf:"varint,3,opt,name=Sequence,proto3" json:"Sequence,omitempty"`
 	Route         Route                  `protobuf:"varint,4,opt,name=Route,proto3,enum=box.Route" json:"Route,omitempty"`
+	Reference     []byte                 `protobuf:"bytes,5,opt,name=Reference,proto3" json:"Reference,omitempty"`
+	Clock         uint64                 `protobuf:"varint,6,opt,name=Clock,proto3" json:"Clock,omitempty"`
+	Heartbeat     []byte                 `protobuf:"bytes,7,opt,name=Heartbeat,proto3" json:"Heartbeat,omitempty"`
+	Ephemeral     bool                   `protobuf:"varint,8,opt,name=Ephemeral,proto3" json:"Ephemeral,omitempty"`
+	AckRequested  bool                   `protobuf:"varint,9,opt,name=AckRequested,proto3" json:"AckRequested,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -240,6 +278,41 @@ func (x *Metadata) GetRoute() Route {
 	return Route_ROUTE_INVALID
 }
 
+func (x *Metadata) GetReference() []byte {
+	if x != nil {
+		return x.Reference
+	}
+	return nil
+}
+
+func (x *Metadata) GetClock() uint64 {
+	if x != nil {
+		return x.Clock
+	}
+	return 0
+}
+
+func (x *Metadata) GetHeartbeat() []byte {
+	if x != nil {
+		return x.Heartbeat
+	}
+	return nil
+}
+
+func (x *Metadata) GetEphemeral() bool {
+	if x != nil {
+		return x.Ephemeral
+	}
+	return false
+}
+
+func (x *Metadata) GetAckRequested() bool {
+	if x != nil {
+		return x.AckRequested
+	}
+	return false
+}
+
 var File_box_proto protoreflect.FileDescriptor
 
 const file_box_proto_rawDesc = "" +
@@ -249,13 +322,18 @@ const file_box_proto_rawDesc = "" +
 	"\x04Data\x18\x01 \x01(\fR\x04Data\x12\x1c\n" +
 	"\tSignature\x18\x02 \x01(\fR\tSignature\x12\x1a\n" +
 	"\bMetadata\x18\x03 \x01(\fR\bMetadata\x12\x18\n" +
-	"\aPadding\x18\x04 \x01(\fR\aPadding\"\x92\x01\n" +
+	"\aPadding\x18\x04 \x01(\fR\aPadding\"\xa6\x02\n" +
 	"\bMetadata\x12\x0e\n" +
 	"\x02ID\x18\x01 \x01(\tR\x02ID\x128\n" +
 	"\tTimestamp\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\tTimestamp\x12\x1a\n" +
 	"\bSequence\x18\x03 \x01(\x04R\bSequence\x12 \n" +
 	"\x05Route\x18\x04 \x01(\x0e2\n" +
-	".box.RouteR\x05Route*\xde\x02\n" +
+	".box.RouteR\x05Route\x12\x1c\n" +
+	"\tReference\x18\x05 \x01(\fR\tReference\x12\x14\n" +
+	"\x05Clock\x18\x06 \x01(\x04R\x05Clock\x12\x1c\n" +
+	"\tHeartbeat\x18\a \x01(\fR\tHeartbeat\x12\x1c\n" +
+	"\tEphemeral\x18\b \x01(\bR\tEphemeral\x12\"\n" +
+	"\fAckRequested\x18\t \x01(\bR\fAckRequested*\xea\x04\n" +
 	"\x05Route\x12\x11\n" +
 	"\rROUTE_INVALID\x10\x00\x12\x12\n" +
 	"\x0eROUTE_IDENTITY\x10\x01\x12\x1b\n" +
@@ -273,7 +351,18 @@ const file_box_proto_rawDesc = "" +
 	"\x12\x18\n" +
 	"\x14ROUTE_RESUME_REQUEST\x10\v\x12\x17\n" +
 	"\x13ROUTE_RESUME_ACCEPT\x10\f\x12\x16\n" +
-	"\x12ROUTE_SESSION_DATA\x10\rB\x06Z\x04./pbb\x06proto3"
+	"\x12ROUTE_SESSION_DATA\x10\r\x12\x19\n" +
+	"\x15ROUTE_MIGRATE_REQUEST\x10\x0e\x12\x18\n" +
+	"\x14ROUTE_MIGRATE_ACCEPT\x10\x0f\x12\x18\n" +
+	"\x14ROUTE_TRANSFER_OFFER\x10\x10\x12\x18\n" +
+	"\x14ROUTE_TRANSFER_REPLY\x10\x11\x12\x17\n" +
+	"\x13ROUTE_TRANSFER_DATA\x10\x12\x12\x16\n" +
+	"\x12ROUTE_WIPE_REQUEST\x10\x13\x12\x15\n" +
+	"\x11ROUTE_WIPE_ACCEPT\x10\x14\x12\x18\n" +
+	"\x14ROUTE_RECONNECT_HINT\x10\x15\x12\x17\n" +
+	"\x13ROUTE_GROUP_MESSAGE\x10\x16\x12\r\n" +
+	"\tROUTE_ACK\x10\x17\x12\x17\n" +
+	"\x13ROUTE_TYPED_MESSAGE\x10\x18B\x06Z\x04./pbb\x06proto3"
 
 var (
 	file_box_proto_rawDescOnce sync.Once
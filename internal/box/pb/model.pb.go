@@ -27,6 +27,11 @@ type Introduce struct {
 	Name          string                 `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
 	PublicKey     []byte                 `protobuf:"bytes,2,opt,name=PublicKey,proto3" json:"PublicKey,omitempty"`
 	AppVersion    string                 `protobuf:"bytes,3,opt,name=AppVersion,proto3" json:"AppVersion,omitempty"`
+	Metadata      map[string][]byte      `protobuf:"bytes,4,rep,name=Metadata,proto3" json:"Metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Services      []string               `protobuf:"bytes,5,rep,name=Services,proto3" json:"Services,omitempty"`
+	Service       string                 `protobuf:"bytes,6,opt,name=Service,proto3" json:"Service,omitempty"`
+	Capabilities  []string               `protobuf:"bytes,7,rep,name=Capabilities,proto3" json:"Capabilities,omitempty"`
+	Claims        []*IdentityClaim       `protobuf:"bytes,8,rep,name=Claims,proto3" json:"Claims,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -82,6 +87,117 @@ func (x *Introduce) GetAppVersion() string {
 	return ""
 }
 
+func (x *Introduce) GetMetadata() map[string][]byte {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *Introduce) GetServices() []string {
+	if x != nil {
+		return x.Services
+	}
+	return nil
+}
+
+func (x *Introduce) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+func (x *Introduce) GetCapabilities() []string {
+	if x != nil {
+		return x.Capabilities
+	}
+	return nil
+}
+
+func (x *Introduce) GetClaims() []*IdentityClaim {
+	if x != nil {
+		return x.Claims
+	}
+	return nil
+}
+
+type IdentityClaim struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Subject       string                 `protobuf:"bytes,1,opt,name=Subject,proto3" json:"Subject,omitempty"`
+	PublicKey     []byte                 `protobuf:"bytes,2,opt,name=PublicKey,proto3" json:"PublicKey,omitempty"`
+	Issuer        []byte                 `protobuf:"bytes,3,opt,name=Issuer,proto3" json:"Issuer,omitempty"`
+	Expires       *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=Expires,proto3" json:"Expires,omitempty"`
+	Signature     []byte                 `protobuf:"bytes,5,opt,name=Signature,proto3" json:"Signature,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IdentityClaim) Reset() {
+	*x = IdentityClaim{}
+	mi := &file_model_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IdentityClaim) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IdentityClaim) ProtoMessage() {}
+
+func (x *IdentityClaim) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IdentityClaim.ProtoReflect.Descriptor instead.
+func (*IdentityClaim) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *IdentityClaim) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+func (x *IdentityClaim) GetPublicKey() []byte {
+	if x != nil {
+		return x.PublicKey
+	}
+	return nil
+}
+
+func (x *IdentityClaim) GetIssuer() []byte {
+	if x != nil {
+		return x.Issuer
+	}
+	return nil
+}
+
+func (x *IdentityClaim) GetExpires() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Expires
+	}
+	return nil
+}
+
+func (x *IdentityClaim) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
 type Handshake struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Key           []byte                 `protobuf:"bytes,1,opt,name=Key,proto3" json:"Key,omitempty"`
@@ -93,7 +209,7 @@ type Handshake struct {
 
 func (x *Handshake) Reset() {
 	*x = Handshake{}
-	mi := &file_model_proto_msgTypes[1]
+	mi := &file_model_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -105,7 +221,7 @@ func (x *Handshake) String() string {
 func (*Handshake) ProtoMessage() {}
 
 func (x *Handshake) ProtoReflect() protoreflect.Message {
-	mi := &file_model_proto_msgTypes[1]
+	mi := &file_model_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -118,7 +234,7 @@ func (x *Handshake) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Handshake.ProtoReflect.Descriptor instead.
 func (*Handshake) Descriptor() ([]byte, []int) {
-	return file_model_proto_rawDescGZIP(), []int{1}
+	return file_model_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *Handshake) GetKey() []byte {
@@ -155,7 +271,7 @@ type Peer struct {
 
 func (x *Peer) Reset() {
 	*x = Peer{}
-	mi := &file_model_proto_msgTypes[2]
+	mi := &file_model_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -167,7 +283,7 @@ func (x *Peer) String() string {
 func (*Peer) ProtoMessage() {}
 
 func (x *Peer) ProtoReflect() protoreflect.Message {
-	mi := &file_model_proto_msgTypes[2]
+	mi := &file_model_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -180,7 +296,7 @@ func (x *Peer) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Peer.ProtoReflect.Descriptor instead.
 func (*Peer) Descriptor() ([]byte, []int) {
-	return file_model_proto_rawDescGZIP(), []int{2}
+	return file_model_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *Peer) GetName() string {
@@ -222,13 +338,14 @@ type ResumeRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	SessionID     string                 `protobuf:"bytes,1,opt,name=SessionID,proto3" json:"SessionID,omitempty"`
 	Token         []byte                 `protobuf:"bytes,2,opt,name=Token,proto3" json:"Token,omitempty"`
+	Received      uint64                 `protobuf:"varint,3,opt,name=Received,proto3" json:"Received,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *ResumeRequest) Reset() {
 	*x = ResumeRequest{}
-	mi := &file_model_proto_msgTypes[3]
+	mi := &file_model_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -240,7 +357,7 @@ func (x *ResumeRequest) String() string {
 func (*ResumeRequest) ProtoMessage() {}
 
 func (x *ResumeRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_model_proto_msgTypes[3]
+	mi := &file_model_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -253,7 +370,7 @@ func (x *ResumeRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ResumeRequest.ProtoReflect.Descriptor instead.
 func (*ResumeRequest) Descriptor() ([]byte, []int) {
-	return file_model_proto_rawDescGZIP(), []int{3}
+	return file_model_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *ResumeRequest) GetSessionID() string {
@@ -270,17 +387,25 @@ func (x *ResumeRequest) GetToken() []byte {
 	return nil
 }
 
+func (x *ResumeRequest) GetReceived() uint64 {
+	if x != nil {
+		return x.Received
+	}
+	return 0
+}
+
 type ResumeAccept struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Accepted      bool                   `protobuf:"varint,1,opt,name=Accepted,proto3" json:"Accepted,omitempty"`
 	Reason        string                 `protobuf:"bytes,2,opt,name=Reason,proto3" json:"Reason,omitempty"`
+	Received      uint64                 `protobuf:"varint,3,opt,name=Received,proto3" json:"Received,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *ResumeAccept) Reset() {
 	*x = ResumeAccept{}
-	mi := &file_model_proto_msgTypes[4]
+	mi := &file_model_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -292,7 +417,7 @@ func (x *ResumeAccept) String() string {
 func (*ResumeAccept) ProtoMessage() {}
 
 func (x *ResumeAccept) ProtoReflect() protoreflect.Message {
-	mi := &file_model_proto_msgTypes[4]
+	mi := &file_model_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -305,7 +430,7 @@ func (x *ResumeAccept) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ResumeAccept.ProtoReflect.Descriptor instead.
 func (*ResumeAccept) Descriptor() ([]byte, []int) {
-	return file_model_proto_rawDescGZIP(), []int{4}
+	return file_model_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *ResumeAccept) GetAccepted() bool {
@@ -322,28 +447,39 @@ func (x *ResumeAccept) GetReason() string {
 	return ""
 }
 
-type SessionData struct {
+func (x *ResumeAccept) GetReceived() uint64 {
+	if x != nil {
+		return x.Received
+	}
+	return 0
+}
+
+type MigrateRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Fields        map[string][]byte      `protobuf:"bytes,1,rep,name=Fields,proto3" json:"Fields,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	SessionID     string                 `protobuf:"bytes,1,opt,name=SessionID,proto3" json:"SessionID,omitempty"`
+	Nonce         []byte                 `protobuf:"bytes,2,opt,name=Nonce,proto3" json:"Nonce,omitempty"`
+	Proof         []byte                 `protobuf:"bytes,3,opt,name=Proof,proto3" json:"Proof,omitempty"`
+	Sequence      uint64                 `protobuf:"varint,4,opt,name=Sequence,proto3" json:"Sequence,omitempty"`
+	Received      uint64                 `protobuf:"varint,5,opt,name=Received,proto3" json:"Received,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *SessionData) Reset() {
-	*x = SessionData{}
-	mi := &file_model_proto_msgTypes[5]
+func (x *MigrateRequest) Reset() {
+	*x = MigrateRequest{}
+	mi := &file_model_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *SessionData) String() string {
+func (x *MigrateRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*SessionData) ProtoMessage() {}
+func (*MigrateRequest) ProtoMessage() {}
 
-func (x *SessionData) ProtoReflect() protoreflect.Message {
-	mi := &file_model_proto_msgTypes[5]
+func (x *MigrateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -354,54 +490,1127 @@ func (x *SessionData) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use SessionData.ProtoReflect.Descriptor instead.
-func (*SessionData) Descriptor() ([]byte, []int) {
-	return file_model_proto_rawDescGZIP(), []int{5}
+// Deprecated: Use MigrateRequest.ProtoReflect.Descriptor instead.
+func (*MigrateRequest) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{6}
 }
 
-func (x *SessionData) GetFields() map[string][]byte {
+func (x *MigrateRequest) GetSessionID() string {
 	if x != nil {
-		return x.Fields
+		return x.SessionID
+	}
+	return ""
+}
+
+func (x *MigrateRequest) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
 	}
 	return nil
 }
 
-var File_model_proto protoreflect.FileDescriptor
+func (x *MigrateRequest) GetProof() []byte {
+	if x != nil {
+		return x.Proof
+	}
+	return nil
+}
 
-const file_model_proto_rawDesc = "" +
-	"\n" +
-	"\vmodel.proto\x12\x03box\x1a\x1fgoogle/protobuf/timestamp.proto\"]\n" +
-	"\tIntroduce\x12\x12\n" +
-	"\x04Name\x18\x01 \x01(\tR\x04Name\x12\x1c\n" +
-	"\tPublicKey\x18\x02 \x01(\fR\tPublicKey\x12\x1e\n" +
-	"\n" +
-	"AppVersion\x18\x03 \x01(\tR\n" +
-	"AppVersion\"Q\n" +
-	"\tHandshake\x12\x10\n" +
-	"\x03Key\x18\x01 \x01(\fR\x03Key\x12\x12\n" +
-	"\x04Salt\x18\x02 \x01(\fR\x04Salt\x12\x1e\n" +
-	"\n" +
-	"SessionKey\x18\x03 \x01(\tR\n" +
-	"SessionKey\"\xca\x01\n" +
-	"\x04Peer\x12\x12\n" +
-	"\x04Name\x18\x01 \x01(\tR\x04Name\x12\x1c\n" +
-	"\tPublicKey\x18\x02 \x01(\fR\tPublicKey\x128\n" +
-	"\tFirstSeen\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\tFirstSeen\x126\n" +
-	"\bLastSeen\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\bLastSeen\x12\x1e\n" +
-	"\n" +
-	"AppVersion\x18\x05 \x01(\tR\n" +
-	"AppVersion\"C\n" +
-	"\rResumeRequest\x12\x1c\n" +
-	"\tSessionID\x18\x01 \x01(\tR\tSessionID\x12\x14\n" +
-	"\x05Token\x18\x02 \x01(\fR\x05Token\"B\n" +
-	"\fResumeAccept\x12\x1a\n" +
-	"\bAccepted\x18\x01 \x01(\bR\bAccepted\x12\x16\n" +
-	"\x06Reason\x18\x02 \x01(\tR\x06Reason\"~\n" +
-	"\vSessionData\x124\n" +
-	"\x06Fields\x18\x01 \x03(\v2\x1c.box.SessionData.FieldsEntryR\x06Fields\x1a9\n" +
-	"\vFieldsEntry\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\fR\x05value:\x028\x01B\x06Z\x04./pbb\x06proto3"
+func (x *MigrateRequest) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *MigrateRequest) GetReceived() uint64 {
+	if x != nil {
+		return x.Received
+	}
+	return 0
+}
+
+type MigrateAccept struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Accepted      bool                   `protobuf:"varint,1,opt,name=Accepted,proto3" json:"Accepted,omitempty"`
+	Proof         []byte                 `protobuf:"bytes,2,opt,name=Proof,proto3" json:"Proof,omitempty"`
+	Sequence      uint64                 `protobuf:"varint,3,opt,name=Sequence,proto3" json:"Sequence,omitempty"`
+	Reason        string                 `protobuf:"bytes,4,opt,name=Reason,proto3" json:"Reason,omitempty"`
+	Received      uint64                 `protobuf:"varint,5,opt,name=Received,proto3" json:"Received,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MigrateAccept) Reset() {
+	*x = MigrateAccept{}
+	mi := &file_model_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MigrateAccept) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MigrateAccept) ProtoMessage() {}
+
+func (x *MigrateAccept) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MigrateAccept.ProtoReflect.Descriptor instead.
+func (*MigrateAccept) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *MigrateAccept) GetAccepted() bool {
+	if x != nil {
+		return x.Accepted
+	}
+	return false
+}
+
+func (x *MigrateAccept) GetProof() []byte {
+	if x != nil {
+		return x.Proof
+	}
+	return nil
+}
+
+func (x *MigrateAccept) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *MigrateAccept) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *MigrateAccept) GetReceived() uint64 {
+	if x != nil {
+		return x.Received
+	}
+	return 0
+}
+
+type SessionStats struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	SessionID        string                 `protobuf:"bytes,1,opt,name=SessionID,proto3" json:"SessionID,omitempty"`
+	PeerKey          []byte                 `protobuf:"bytes,2,opt,name=PeerKey,proto3" json:"PeerKey,omitempty"`
+	Start            *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=Start,proto3" json:"Start,omitempty"`
+	End              *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=End,proto3" json:"End,omitempty"`
+	MessagesSent     uint64                 `protobuf:"varint,5,opt,name=MessagesSent,proto3" json:"MessagesSent,omitempty"`
+	MessagesReceived uint64                 `protobuf:"varint,6,opt,name=MessagesReceived,proto3" json:"MessagesReceived,omitempty"`
+	BytesSent        uint64                 `protobuf:"varint,7,opt,name=BytesSent,proto3" json:"BytesSent,omitempty"`
+	BytesReceived    uint64                 `protobuf:"varint,8,opt,name=BytesReceived,proto3" json:"BytesReceived,omitempty"`
+	Resumed          bool                   `protobuf:"varint,9,opt,name=Resumed,proto3" json:"Resumed,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *SessionStats) Reset() {
+	*x = SessionStats{}
+	mi := &file_model_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SessionStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionStats) ProtoMessage() {}
+
+func (x *SessionStats) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SessionStats.ProtoReflect.Descriptor instead.
+func (*SessionStats) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SessionStats) GetSessionID() string {
+	if x != nil {
+		return x.SessionID
+	}
+	return ""
+}
+
+func (x *SessionStats) GetPeerKey() []byte {
+	if x != nil {
+		return x.PeerKey
+	}
+	return nil
+}
+
+func (x *SessionStats) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *SessionStats) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *SessionStats) GetMessagesSent() uint64 {
+	if x != nil {
+		return x.MessagesSent
+	}
+	return 0
+}
+
+func (x *SessionStats) GetMessagesReceived() uint64 {
+	if x != nil {
+		return x.MessagesReceived
+	}
+	return 0
+}
+
+func (x *SessionStats) GetBytesSent() uint64 {
+	if x != nil {
+		return x.BytesSent
+	}
+	return 0
+}
+
+func (x *SessionStats) GetBytesReceived() uint64 {
+	if x != nil {
+		return x.BytesReceived
+	}
+	return 0
+}
+
+func (x *SessionStats) GetResumed() bool {
+	if x != nil {
+		return x.Resumed
+	}
+	return false
+}
+
+type Conversation struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ID            string                 `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	PeerKey       []byte                 `protobuf:"bytes,2,opt,name=PeerKey,proto3" json:"PeerKey,omitempty"`
+	Created       *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=Created,proto3" json:"Created,omitempty"`
+	Updated       *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=Updated,proto3" json:"Updated,omitempty"`
+	Sessions      []string               `protobuf:"bytes,5,rep,name=Sessions,proto3" json:"Sessions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Conversation) Reset() {
+	*x = Conversation{}
+	mi := &file_model_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Conversation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Conversation) ProtoMessage() {}
+
+func (x *Conversation) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Conversation.ProtoReflect.Descriptor instead.
+func (*Conversation) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Conversation) GetID() string {
+	if x != nil {
+		return x.ID
+	}
+	return ""
+}
+
+func (x *Conversation) GetPeerKey() []byte {
+	if x != nil {
+		return x.PeerKey
+	}
+	return nil
+}
+
+func (x *Conversation) GetCreated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Created
+	}
+	return nil
+}
+
+func (x *Conversation) GetUpdated() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Updated
+	}
+	return nil
+}
+
+func (x *Conversation) GetSessions() []string {
+	if x != nil {
+		return x.Sessions
+	}
+	return nil
+}
+
+type SessionData struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Fields        map[string][]byte      `protobuf:"bytes,1,rep,name=Fields,proto3" json:"Fields,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SessionData) Reset() {
+	*x = SessionData{}
+	mi := &file_model_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SessionData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionData) ProtoMessage() {}
+
+func (x *SessionData) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SessionData.ProtoReflect.Descriptor instead.
+func (*SessionData) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *SessionData) GetFields() map[string][]byte {
+	if x != nil {
+		return x.Fields
+	}
+	return nil
+}
+
+type TransferOffer struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ID            string                 `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=Name,proto3" json:"Name,omitempty"`
+	Type          string                 `protobuf:"bytes,3,opt,name=Type,proto3" json:"Type,omitempty"`
+	Size          uint64                 `protobuf:"varint,4,opt,name=Size,proto3" json:"Size,omitempty"`
+	Hash          []byte                 `protobuf:"bytes,5,opt,name=Hash,proto3" json:"Hash,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransferOffer) Reset() {
+	*x = TransferOffer{}
+	mi := &file_model_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransferOffer) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransferOffer) ProtoMessage() {}
+
+func (x *TransferOffer) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransferOffer.ProtoReflect.Descriptor instead.
+func (*TransferOffer) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *TransferOffer) GetID() string {
+	if x != nil {
+		return x.ID
+	}
+	return ""
+}
+
+func (x *TransferOffer) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *TransferOffer) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *TransferOffer) GetSize() uint64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *TransferOffer) GetHash() []byte {
+	if x != nil {
+		return x.Hash
+	}
+	return nil
+}
+
+type TransferReply struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ID            string                 `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Accepted      bool                   `protobuf:"varint,2,opt,name=Accepted,proto3" json:"Accepted,omitempty"`
+	Reason        string                 `protobuf:"bytes,3,opt,name=Reason,proto3" json:"Reason,omitempty"`
+	Offset        uint64                 `protobuf:"varint,4,opt,name=Offset,proto3" json:"Offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransferReply) Reset() {
+	*x = TransferReply{}
+	mi := &file_model_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransferReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransferReply) ProtoMessage() {}
+
+func (x *TransferReply) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransferReply.ProtoReflect.Descriptor instead.
+func (*TransferReply) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *TransferReply) GetID() string {
+	if x != nil {
+		return x.ID
+	}
+	return ""
+}
+
+func (x *TransferReply) GetAccepted() bool {
+	if x != nil {
+		return x.Accepted
+	}
+	return false
+}
+
+func (x *TransferReply) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *TransferReply) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+type TransferChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ID            string                 `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Offset        uint64                 `protobuf:"varint,2,opt,name=Offset,proto3" json:"Offset,omitempty"`
+	Data          []byte                 `protobuf:"bytes,3,opt,name=Data,proto3" json:"Data,omitempty"`
+	Final         bool                   `protobuf:"varint,4,opt,name=Final,proto3" json:"Final,omitempty"`
+	Hash          []byte                 `protobuf:"bytes,5,opt,name=Hash,proto3" json:"Hash,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransferChunk) Reset() {
+	*x = TransferChunk{}
+	mi := &file_model_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransferChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransferChunk) ProtoMessage() {}
+
+func (x *TransferChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransferChunk.ProtoReflect.Descriptor instead.
+func (*TransferChunk) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *TransferChunk) GetID() string {
+	if x != nil {
+		return x.ID
+	}
+	return ""
+}
+
+func (x *TransferChunk) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *TransferChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *TransferChunk) GetFinal() bool {
+	if x != nil {
+		return x.Final
+	}
+	return false
+}
+
+func (x *TransferChunk) GetHash() []byte {
+	if x != nil {
+		return x.Hash
+	}
+	return nil
+}
+
+type WipeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ID            string                 `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Requested     *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=Requested,proto3" json:"Requested,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WipeRequest) Reset() {
+	*x = WipeRequest{}
+	mi := &file_model_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WipeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WipeRequest) ProtoMessage() {}
+
+func (x *WipeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WipeRequest.ProtoReflect.Descriptor instead.
+func (*WipeRequest) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *WipeRequest) GetID() string {
+	if x != nil {
+		return x.ID
+	}
+	return ""
+}
+
+func (x *WipeRequest) GetRequested() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Requested
+	}
+	return nil
+}
+
+type WipeAccept struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ID            string                 `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Accepted      bool                   `protobuf:"varint,2,opt,name=Accepted,proto3" json:"Accepted,omitempty"`
+	Reason        string                 `protobuf:"bytes,3,opt,name=Reason,proto3" json:"Reason,omitempty"`
+	Wiped         *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=Wiped,proto3" json:"Wiped,omitempty"`
+	Signature     []byte                 `protobuf:"bytes,5,opt,name=Signature,proto3" json:"Signature,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WipeAccept) Reset() {
+	*x = WipeAccept{}
+	mi := &file_model_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WipeAccept) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WipeAccept) ProtoMessage() {}
+
+func (x *WipeAccept) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WipeAccept.ProtoReflect.Descriptor instead.
+func (*WipeAccept) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *WipeAccept) GetID() string {
+	if x != nil {
+		return x.ID
+	}
+	return ""
+}
+
+func (x *WipeAccept) GetAccepted() bool {
+	if x != nil {
+		return x.Accepted
+	}
+	return false
+}
+
+func (x *WipeAccept) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *WipeAccept) GetWiped() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Wiped
+	}
+	return nil
+}
+
+func (x *WipeAccept) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+type ReconnectHint struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Address       string                 `protobuf:"bytes,1,opt,name=Address,proto3" json:"Address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReconnectHint) Reset() {
+	*x = ReconnectHint{}
+	mi := &file_model_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReconnectHint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReconnectHint) ProtoMessage() {}
+
+func (x *ReconnectHint) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReconnectHint.ProtoReflect.Descriptor instead.
+func (*ReconnectHint) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *ReconnectHint) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+type GroupMembership struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
+	Owner         []byte                 `protobuf:"bytes,2,opt,name=Owner,proto3" json:"Owner,omitempty"`
+	Members       [][]byte               `protobuf:"bytes,3,rep,name=Members,proto3" json:"Members,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GroupMembership) Reset() {
+	*x = GroupMembership{}
+	mi := &file_model_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GroupMembership) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GroupMembership) ProtoMessage() {}
+
+func (x *GroupMembership) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GroupMembership.ProtoReflect.Descriptor instead.
+func (*GroupMembership) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *GroupMembership) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *GroupMembership) GetOwner() []byte {
+	if x != nil {
+		return x.Owner
+	}
+	return nil
+}
+
+func (x *GroupMembership) GetMembers() [][]byte {
+	if x != nil {
+		return x.Members
+	}
+	return nil
+}
+
+type GroupMessage struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=Group,proto3" json:"Group,omitempty"`
+	ID            string                 `protobuf:"bytes,2,opt,name=ID,proto3" json:"ID,omitempty"`
+	Sender        []byte                 `protobuf:"bytes,3,opt,name=Sender,proto3" json:"Sender,omitempty"`
+	Data          []byte                 `protobuf:"bytes,4,opt,name=Data,proto3" json:"Data,omitempty"`
+	Clock         uint64                 `protobuf:"varint,5,opt,name=Clock,proto3" json:"Clock,omitempty"`
+	Membership    *GroupMembership       `protobuf:"bytes,6,opt,name=Membership,proto3" json:"Membership,omitempty"`
+	Signature     []byte                 `protobuf:"bytes,7,opt,name=Signature,proto3" json:"Signature,omitempty"`
+	Sequence      uint64                 `protobuf:"varint,8,opt,name=Sequence,proto3" json:"Sequence,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GroupMessage) Reset() {
+	*x = GroupMessage{}
+	mi := &file_model_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GroupMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GroupMessage) ProtoMessage() {}
+
+func (x *GroupMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GroupMessage.ProtoReflect.Descriptor instead.
+func (*GroupMessage) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *GroupMessage) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *GroupMessage) GetID() string {
+	if x != nil {
+		return x.ID
+	}
+	return ""
+}
+
+func (x *GroupMessage) GetSender() []byte {
+	if x != nil {
+		return x.Sender
+	}
+	return nil
+}
+
+func (x *GroupMessage) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *GroupMessage) GetClock() uint64 {
+	if x != nil {
+		return x.Clock
+	}
+	return 0
+}
+
+func (x *GroupMessage) GetMembership() *GroupMembership {
+	if x != nil {
+		return x.Membership
+	}
+	return nil
+}
+
+func (x *GroupMessage) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+func (x *GroupMessage) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+type Ack struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ID            string                 `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Ack) Reset() {
+	*x = Ack{}
+	mi := &file_model_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Ack) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ack) ProtoMessage() {}
+
+func (x *Ack) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ack.ProtoReflect.Descriptor instead.
+func (*Ack) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *Ack) GetID() string {
+	if x != nil {
+		return x.ID
+	}
+	return ""
+}
+
+type Envelope struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=Type,proto3" json:"Type,omitempty"`
+	Payload       []byte                 `protobuf:"bytes,2,opt,name=Payload,proto3" json:"Payload,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Envelope) Reset() {
+	*x = Envelope{}
+	mi := &file_model_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Envelope) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Envelope) ProtoMessage() {}
+
+func (x *Envelope) ProtoReflect() protoreflect.Message {
+	mi := &file_model_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Envelope.ProtoReflect.Descriptor instead.
+func (*Envelope) Descriptor() ([]byte, []int) {
+	return file_model_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *Envelope) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Envelope) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+var File_model_proto protoreflect.FileDescriptor
+
+const file_model_proto_rawDesc = "" +
+	"\n" +
+	"\vmodel.proto\x12\x03box\x1a\x1fgoogle/protobuf/timestamp.proto\"\xda\x02\n" +
+	"\tIntroduce\x12\x12\n" +
+	"\x04Name\x18\x01 \x01(\tR\x04Name\x12\x1c\n" +
+	"\tPublicKey\x18\x02 \x01(\fR\tPublicKey\x12\x1e\n" +
+	"\n" +
+	"AppVersion\x18\x03 \x01(\tR\n" +
+	"AppVersion\x128\n" +
+	"\bMetadata\x18\x04 \x03(\v2\x1c.box.Introduce.MetadataEntryR\bMetadata\x12\x1a\n" +
+	"\bServices\x18\x05 \x03(\tR\bServices\x12\x18\n" +
+	"\aService\x18\x06 \x01(\tR\aService\x12\"\n" +
+	"\fCapabilities\x18\a \x03(\tR\fCapabilities\x12*\n" +
+	"\x06Claims\x18\b \x03(\v2\x12.box.IdentityClaimR\x06Claims\x1a;\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value:\x028\x01\"\xb3\x01\n" +
+	"\rIdentityClaim\x12\x18\n" +
+	"\aSubject\x18\x01 \x01(\tR\aSubject\x12\x1c\n" +
+	"\tPublicKey\x18\x02 \x01(\fR\tPublicKey\x12\x16\n" +
+	"\x06Issuer\x18\x03 \x01(\fR\x06Issuer\x124\n" +
+	"\aExpires\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\aExpires\x12\x1c\n" +
+	"\tSignature\x18\x05 \x01(\fR\tSignature\"Q\n" +
+	"\tHandshake\x12\x10\n" +
+	"\x03Key\x18\x01 \x01(\fR\x03Key\x12\x12\n" +
+	"\x04Salt\x18\x02 \x01(\fR\x04Salt\x12\x1e\n" +
+	"\n" +
+	"SessionKey\x18\x03 \x01(\tR\n" +
+	"SessionKey\"\xca\x01\n" +
+	"\x04Peer\x12\x12\n" +
+	"\x04Name\x18\x01 \x01(\tR\x04Name\x12\x1c\n" +
+	"\tPublicKey\x18\x02 \x01(\fR\tPublicKey\x128\n" +
+	"\tFirstSeen\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\tFirstSeen\x126\n" +
+	"\bLastSeen\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\bLastSeen\x12\x1e\n" +
+	"\n" +
+	"AppVersion\x18\x05 \x01(\tR\n" +
+	"AppVersion\"_\n" +
+	"\rResumeRequest\x12\x1c\n" +
+	"\tSessionID\x18\x01 \x01(\tR\tSessionID\x12\x14\n" +
+	"\x05Token\x18\x02 \x01(\fR\x05Token\x12\x1a\n" +
+	"\bReceived\x18\x03 \x01(\x04R\bReceived\"^\n" +
+	"\fResumeAccept\x12\x1a\n" +
+	"\bAccepted\x18\x01 \x01(\bR\bAccepted\x12\x16\n" +
+	"\x06Reason\x18\x02 \x01(\tR\x06Reason\x12\x1a\n" +
+	"\bReceived\x18\x03 \x01(\x04R\bReceived\"\x92\x01\n" +
+	"\x0eMigrateRequest\x12\x1c\n" +
+	"\tSessionID\x18\x01 \x01(\tR\tSessionID\x12\x14\n" +
+	"\x05Nonce\x18\x02 \x01(\fR\x05Nonce\x12\x14\n" +
+	"\x05Proof\x18\x03 \x01(\fR\x05Proof\x12\x1a\n" +
+	"\bSequence\x18\x04 \x01(\x04R\bSequence\x12\x1a\n" +
+	"\bReceived\x18\x05 \x01(\x04R\bReceived\"\x91\x01\n" +
+	"\rMigrateAccept\x12\x1a\n" +
+	"\bAccepted\x18\x01 \x01(\bR\bAccepted\x12\x14\n" +
+	"\x05Proof\x18\x02 \x01(\fR\x05Proof\x12\x1a\n" +
+	"\bSequence\x18\x03 \x01(\x04R\bSequence\x12\x16\n" +
+	"\x06Reason\x18\x04 \x01(\tR\x06Reason\x12\x1a\n" +
+	"\bReceived\x18\x05 \x01(\x04R\bReceived\"\xd4\x02\n" +
+	"\fSessionStats\x12\x1c\n" +
+	"\tSessionID\x18\x01 \x01(\tR\tSessionID\x12\x18\n" +
+	"\aPeerKey\x18\x02 \x01(\fR\aPeerKey\x120\n" +
+	"\x05Start\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x05Start\x12,\n" +
+	"\x03End\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x03End\x12\"\n" +
+	"\fMessagesSent\x18\x05 \x01(\x04R\fMessagesSent\x12*\n" +
+	"\x10MessagesReceived\x18\x06 \x01(\x04R\x10MessagesReceived\x12\x1c\n" +
+	"\tBytesSent\x18\a \x01(\x04R\tBytesSent\x12$\n" +
+	"\rBytesReceived\x18\b \x01(\x04R\rBytesReceived\x12\x18\n" +
+	"\aResumed\x18\t \x01(\bR\aResumed\"\xc0\x01\n" +
+	"\fConversation\x12\x0e\n" +
+	"\x02ID\x18\x01 \x01(\tR\x02ID\x12\x18\n" +
+	"\aPeerKey\x18\x02 \x01(\fR\aPeerKey\x124\n" +
+	"\aCreated\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\aCreated\x124\n" +
+	"\aUpdated\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\aUpdated\x12\x1a\n" +
+	"\bSessions\x18\x05 \x03(\tR\bSessions\"~\n" +
+	"\vSessionData\x124\n" +
+	"\x06Fields\x18\x01 \x03(\v2\x1c.box.SessionData.FieldsEntryR\x06Fields\x1a9\n" +
+	"\vFieldsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\fR\x05value:\x028\x01\"o\n" +
+	"\rTransferOffer\x12\x0e\n" +
+	"\x02ID\x18\x01 \x01(\tR\x02ID\x12\x12\n" +
+	"\x04Name\x18\x02 \x01(\tR\x04Name\x12\x12\n" +
+	"\x04Type\x18\x03 \x01(\tR\x04Type\x12\x12\n" +
+	"\x04Size\x18\x04 \x01(\x04R\x04Size\x12\x12\n" +
+	"\x04Hash\x18\x05 \x01(\fR\x04Hash\"k\n" +
+	"\rTransferReply\x12\x0e\n" +
+	"\x02ID\x18\x01 \x01(\tR\x02ID\x12\x1a\n" +
+	"\bAccepted\x18\x02 \x01(\bR\bAccepted\x12\x16\n" +
+	"\x06Reason\x18\x03 \x01(\tR\x06Reason\x12\x16\n" +
+	"\x06Offset\x18\x04 \x01(\x04R\x06Offset\"u\n" +
+	"\rTransferChunk\x12\x0e\n" +
+	"\x02ID\x18\x01 \x01(\tR\x02ID\x12\x16\n" +
+	"\x06Offset\x18\x02 \x01(\x04R\x06Offset\x12\x12\n" +
+	"\x04Data\x18\x03 \x01(\fR\x04Data\x12\x14\n" +
+	"\x05Final\x18\x04 \x01(\bR\x05Final\x12\x12\n" +
+	"\x04Hash\x18\x05 \x01(\fR\x04Hash\"W\n" +
+	"\vWipeRequest\x12\x0e\n" +
+	"\x02ID\x18\x01 \x01(\tR\x02ID\x128\n" +
+	"\tRequested\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\tRequested\"\xa0\x01\n" +
+	"\n" +
+	"WipeAccept\x12\x0e\n" +
+	"\x02ID\x18\x01 \x01(\tR\x02ID\x12\x1a\n" +
+	"\bAccepted\x18\x02 \x01(\bR\bAccepted\x12\x16\n" +
+	"\x06Reason\x18\x03 \x01(\tR\x06Reason\x120\n" +
+	"\x05Wiped\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x05Wiped\x12\x1c\n" +
+	"\tSignature\x18\x05 \x01(\fR\tSignature\")\n" +
+	"\rReconnectHint\x12\x18\n" +
+	"\aAddress\x18\x01 \x01(\tR\aAddress\"U\n" +
+	"\x0fGroupMembership\x12\x12\n" +
+	"\x04Name\x18\x01 \x01(\tR\x04Name\x12\x14\n" +
+	"\x05Owner\x18\x02 \x01(\fR\x05Owner\x12\x18\n" +
+	"\aMembers\x18\x03 \x03(\fR\aMembers\"\xe6\x01\n" +
+	"\fGroupMessage\x12\x14\n" +
+	"\x05Group\x18\x01 \x01(\tR\x05Group\x12\x0e\n" +
+	"\x02ID\x18\x02 \x01(\tR\x02ID\x12\x16\n" +
+	"\x06Sender\x18\x03 \x01(\fR\x06Sender\x12\x12\n" +
+	"\x04Data\x18\x04 \x01(\fR\x04Data\x12\x14\n" +
+	"\x05Clock\x18\x05 \x01(\x04R\x05Clock\x124\n" +
+	"\n" +
+	"Membership\x18\x06 \x01(\v2\x14.box.GroupMembershipR\n" +
+	"Membership\x12\x1c\n" +
+	"\tSignature\x18\a \x01(\fR\tSignature\x12\x1a\n" +
+	"\bSequence\x18\b \x01(\x04R\bSequence\"\x15\n" +
+	"\x03Ack\x12\x0e\n" +
+	"\x02ID\x18\x01 \x01(\tR\x02ID\"8\n" +
+	"\bEnvelope\x12\x12\n" +
+	"\x04Type\x18\x01 \x01(\tR\x04Type\x12\x18\n" +
+	"\aPayload\x18\x02 \x01(\fR\aPayloadB\x06Z\x04./pbb\x06proto3"
 
 var (
 	file_model_proto_rawDescOnce sync.Once
@@ -415,26 +1624,52 @@ func file_model_proto_rawDescGZIP() []byte {
 	return file_model_proto_rawDescData
 }
 
-var file_model_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_model_proto_msgTypes = make([]protoimpl.MessageInfo, 23)
 var file_model_proto_goTypes = []any{
 	(*Introduce)(nil),             // 0: box.Introduce
-	(*Handshake)(nil),             // 1: box.Handshake
-	(*Peer)(nil),                  // 2: box.Peer
-	(*ResumeRequest)(nil),         // 3: box.ResumeRequest
-	(*ResumeAccept)(nil),          // 4: box.ResumeAccept
-	(*SessionData)(nil),           // 5: box.SessionData
-	nil,                           // 6: box.SessionData.FieldsEntry
-	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+	(*IdentityClaim)(nil),         // 1: box.IdentityClaim
+	(*Handshake)(nil),             // 2: box.Handshake
+	(*Peer)(nil),                  // 3: box.Peer
+	(*ResumeRequest)(nil),         // 4: box.ResumeRequest
+	(*ResumeAccept)(nil),          // 5: box.ResumeAccept
+	(*MigrateRequest)(nil),        // 6: box.MigrateRequest
+	(*MigrateAccept)(nil),         // 7: box.MigrateAccept
+	(*SessionStats)(nil),          // 8: box.SessionStats
+	(*Conversation)(nil),          // 9: box.Conversation
+	(*SessionData)(nil),           // 10: box.SessionData
+	(*TransferOffer)(nil),         // 11: box.TransferOffer
+	(*TransferReply)(nil),         // 12: box.TransferReply
+	(*TransferChunk)(nil),         // 13: box.TransferChunk
+	(*WipeRequest)(nil),           // 14: box.WipeRequest
+	(*WipeAccept)(nil),            // 15: box.WipeAccept
+	(*ReconnectHint)(nil),         // 16: box.ReconnectHint
+	(*GroupMembership)(nil),       // 17: box.GroupMembership
+	(*GroupMessage)(nil),          // 18: box.GroupMessage
+	(*Ack)(nil),                   // 19: box.Ack
+	(*Envelope)(nil),              // 20: box.Envelope
+	nil,                           // 21: box.Introduce.MetadataEntry
+	nil,                           // 22: box.SessionData.FieldsEntry
+	(*timestamppb.Timestamp)(nil), // 23: google.protobuf.Timestamp
 }
 var file_model_proto_depIdxs = []int32{
-	7, // 0: box.Peer.FirstSeen:type_name -> google.protobuf.Timestamp
-	7, // 1: box.Peer.LastSeen:type_name -> google.protobuf.Timestamp
-	6, // 2: box.SessionData.Fields:type_name -> box.SessionData.FieldsEntry
-	3, // [3:3] is the sub-list for method output_type
-	3, // [3:3] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+	21, // 0: box.Introduce.Metadata:type_name -> box.Introduce.MetadataEntry
+	1,  // 1: box.Introduce.Claims:type_name -> box.IdentityClaim
+	23, // 2: box.IdentityClaim.Expires:type_name -> google.protobuf.Timestamp
+	23, // 3: box.Peer.FirstSeen:type_name -> google.protobuf.Timestamp
+	23, // 4: box.Peer.LastSeen:type_name -> google.protobuf.Timestamp
+	23, // 5: box.SessionStats.Start:type_name -> google.protobuf.Timestamp
+	23, // 6: box.SessionStats.End:type_name -> google.protobuf.Timestamp
+	23, // 7: box.Conversation.Created:type_name -> google.protobuf.Timestamp
+	23, // 8: box.Conversation.Updated:type_name -> google.protobuf.Timestamp
+	22, // 9: box.SessionData.Fields:type_name -> box.SessionData.FieldsEntry
+	23, // 10: box.WipeRequest.Requested:type_name -> google.protobuf.Timestamp
+	23, // 11: box.WipeAccept.Wiped:type_name -> google.protobuf.Timestamp
+	17, // 12: box.GroupMessage.Membership:type_name -> box.GroupMembership
+	13, // [13:13] is the sub-list for method output_type
+	13, // [13:13] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
 }
 
 func init() { file_model_proto_init() }
@@ -448,7 +1683,7 @@ func file_model_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_model_proto_rawDesc), len(file_model_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   7,
+			NumMessages:   23,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
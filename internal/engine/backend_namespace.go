@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+
+	"github.com/kamune-org/kamune/internal/enigma"
+	"github.com/kamune-org/kamune/pkg/storage/store"
+)
+
+// backendNamespace is the implementation of [Namespace] over a bucket of a
+// [store.Backend]. Like the root of a BoltDB transaction, the root namespace
+// holds only child namespaces.
+type backendNamespace struct {
+	bucket store.Bucket
+	cipher *enigma.Enigma
+	name   string
+	root   bool
+}
+
+// Sub navigates to a child namespace. It never creates a missing namespace,
+// returning [nilNamespace] instead.
+func (b *backendNamespace) Sub(name []byte) Namespace {
+	sub := b.bucket.Bucket(name)
+	if sub == nil {
+		return nilNamespace{}
+	}
+	return &backendNamespace{bucket: sub, cipher: b.cipher, name: string(name)}
+}
+
+// Ensure navigates to a child namespace, creating it if it does not exist.
+// Inside [Store.Query] a missing namespace yields [nilNamespace].
+func (b *backendNamespace) Ensure(name []byte) Namespace {
+	sub, err := b.bucket.CreateBucket(name)
+	if err != nil {
+		slog.Debug(
+			"create bucket",
+			slog.String("name", string(name)),
+			slog.Any("error", err),
+		)
+		return nilNamespace{}
+	}
+	return &backendNamespace{bucket: sub, cipher: b.cipher, name: string(name)}
+}
+
+func (b *backendNamespace) GetEncrypted(key []byte) ([]byte, error) {
+	if b.root {
+		return nil, ErrMissingNamespace
+	}
+	value := b.bucket.Get(key)
+	if value == nil {
+		return nil, ErrMissingItem
+	}
+	data, err := b.cipher.Decrypt(value)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	return data, nil
+}
+
+func (b *backendNamespace) PutEncrypted(key, value []byte) error {
+	if b.root {
+		return ErrMissingNamespace
+	}
+	if err := b.bucket.Put(key, b.cipher.Encrypt(value)); err != nil {
+		return fmt.Errorf("put: %w", err)
+	}
+	return nil
+}
+
+func (b *backendNamespace) Delete(key []byte) error {
+	if b.root {
+		return ErrMissingNamespace
+	}
+	if err := b.bucket.Delete(key); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
+
+func (b *backendNamespace) DeleteNamespace(name []byte) error {
+	if len(name) == 0 || b.root {
+		return ErrMissingNamespace
+	}
+	err := b.bucket.DeleteBucket(name)
+	switch {
+	case errors.Is(err, store.ErrBucketNotFound):
+		return fmt.Errorf(
+			"delete namespace %q: %w", name, ErrMissingNamespace,
+		)
+	case err != nil:
+		return fmt.Errorf("delete namespace %q: %w", name, err)
+	}
+	return nil
+}
+
+func (b *backendNamespace) IterateEncrypted() iter.Seq2[[]byte, []byte] {
+	return func(yield func(k, v []byte) bool) {
+		for k, v := range b.bucket.Values() {
+			kc := append([]byte{}, k...)
+			data, err := b.cipher.Decrypt(v)
+			if err != nil {
+				slog.Warn(
+					"decrypting value",
+					slog.String("namespace", b.name),
+					slog.String("key", string(kc)),
+					slog.Any("error", err),
+				)
+				continue
+			}
+			if !yield(kc, data) {
+				return
+			}
+		}
+	}
+}
+
+func (b *backendNamespace) FirstKey() []byte {
+	if k := b.bucket.First(); k != nil {
+		return append([]byte{}, k...)
+	}
+	return nil
+}
+
+func (b *backendNamespace) LastKey() []byte {
+	if k := b.bucket.Last(); k != nil {
+		return append([]byte{}, k...)
+	}
+	return nil
+}
+
+func (b *backendNamespace) KeyCount() int {
+	return b.bucket.Len()
+}
+
+func (b *backendNamespace) ListSubNamespaces() []string {
+	return b.bucket.Buckets()
+}
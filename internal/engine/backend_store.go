@@ -0,0 +1,266 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/kamune-org/kamune/internal/enigma"
+	"github.com/kamune-org/kamune/pkg/storage/store"
+)
+
+// BackendStore is the implementation of [Store] and [Locker] over a
+// [store.Backend]. It encrypts values as [BoltStore] does, keeping the
+// wrapped data key in the default namespace, so the backend only ever holds
+// ciphertext.
+type BackendStore struct {
+	backend store.Backend
+	cipher  *enigma.Enigma
+	mu      sync.RWMutex
+}
+
+// NewBackendStore opens a store over backend, encrypting values with the
+// provided passphrase. A backend that holds no data key yet is given a new
+// one.
+func NewBackendStore(
+	backend store.Backend, passphrase []byte,
+) (*BackendStore, error) {
+	var meta cipherMeta
+	err := backend.Update(func(root store.Bucket) error {
+		for _, name := range [][]byte{
+			defaultNamespace,
+			settingsNamespace,
+			peersNamespace,
+			sessionsNamespace,
+			statsNamespace,
+			convsNamespace,
+			blockedNamespace,
+			rolesNamespace,
+			paramsNamespace,
+			aliasesNamespace,
+			chatKeysNamespace,
+			addrsNamespace,
+			wipesNamespace,
+			reputeNamespace,
+			namesNamespace,
+			xfersNamespace,
+			groupsNamespace,
+			timelineNamespace,
+		} {
+			if _, err := root.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		meta = readCipherMeta(root.Bucket(defaultNamespace).Get)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating default bucket: %w", err)
+	}
+
+	s := &BackendStore{backend: backend}
+	s.cipher, err = meta.dataCipher(passphrase)
+	if errors.Is(err, ErrMissingItem) {
+		s.cipher, err = s.createCipher(passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cipher: %w", err)
+	}
+	return s, nil
+}
+
+func (s *BackendStore) createCipher(pass []byte) (*enigma.Enigma, error) {
+	meta, c, err := newCipherMeta(pass)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.putCipherMeta(meta); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *BackendStore) readCipherMeta() (cipherMeta, error) {
+	var meta cipherMeta
+	err := s.backend.View(func(root store.Bucket) error {
+		meta = readCipherMeta(root.Bucket(defaultNamespace).Get)
+		return nil
+	})
+	if err != nil {
+		return cipherMeta{}, fmt.Errorf("get values: %w", err)
+	}
+	return meta, nil
+}
+
+func (s *BackendStore) putCipherMeta(meta cipherMeta) error {
+	err := s.backend.Update(func(root store.Bucket) error {
+		return putBucketCipherMeta(root, meta)
+	})
+	if err != nil {
+		return fmt.Errorf("update metadata: %w", err)
+	}
+	return nil
+}
+
+func putBucketCipherMeta(root store.Bucket, meta cipherMeta) error {
+	bucket := root.Bucket(defaultNamespace)
+	for _, kv := range meta.entries() {
+		if err := bucket.Put(kv[0], kv[1]); err != nil {
+			return fmt.Errorf("put %s: %w", kv[0], err)
+		}
+	}
+	return nil
+}
+
+func (s *BackendStore) Close() error {
+	return s.backend.Close()
+}
+
+func (s *BackendStore) Query(f func(b Namespace) error) error {
+	c := s.dataCipher()
+	if c == nil {
+		return ErrLocked
+	}
+	return s.backend.View(func(root store.Bucket) error {
+		return f(&backendNamespace{bucket: root, cipher: c, root: true})
+	})
+}
+
+// Command runs f with write access. If f returns an error, none of its
+// changes are kept.
+func (s *BackendStore) Command(f func(b Namespace) error) error {
+	c := s.dataCipher()
+	if c == nil {
+		return ErrLocked
+	}
+	return s.backend.Update(func(root store.Bucket) error {
+		return f(&backendNamespace{bucket: root, cipher: c, root: true})
+	})
+}
+
+// Lock drops the data cipher. Transactions already running finish with it.
+func (s *BackendStore) Lock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cipher = nil
+}
+
+// Unlock derives the data cipher from passphrase again. On a store that is
+// not locked, it only checks the passphrase.
+func (s *BackendStore) Unlock(passphrase []byte) error {
+	meta, err := s.readCipherMeta()
+	if err != nil {
+		return err
+	}
+	c, err := meta.dataCipher(passphrase)
+	if err != nil {
+		return fmt.Errorf("extract cipher: %w", err)
+	}
+	s.setCipher(c)
+	return nil
+}
+
+func (s *BackendStore) Locked() bool {
+	return s.dataCipher() == nil
+}
+
+func (s *BackendStore) dataCipher() *enigma.Enigma {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cipher
+}
+
+func (s *BackendStore) setCipher(c *enigma.Enigma) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cipher = c
+}
+
+// RotatePassphrase re-wraps the data encryption key with a new passphrase. Only
+// the key-wrapping metadata changes; encrypted data is untouched.
+func (s *BackendStore) RotatePassphrase(old, new []byte) error {
+	if s.Locked() {
+		return ErrLocked
+	}
+	meta, err := s.readCipherMeta()
+	if err != nil {
+		return err
+	}
+	secret, err := meta.unwrap(old)
+	if err != nil {
+		return fmt.Errorf("extract cipher with old passphrase: %w", err)
+	}
+	newMeta, err := wrapCipherMeta(secret, meta.secretSalt, new)
+	if err != nil {
+		return err
+	}
+	if err := s.putCipherMeta(newMeta); err != nil {
+		return err
+	}
+	c, err := newMeta.dataCipher(new)
+	if err != nil {
+		return fmt.Errorf("reload cipher: %w", err)
+	}
+	s.setCipher(c)
+	return nil
+}
+
+// RotateDataKey generates a new data encryption key and re-encrypts every
+// value in one transaction.
+func (s *BackendStore) RotateDataKey(old, new []byte) error {
+	if s.Locked() {
+		return ErrLocked
+	}
+	meta, err := s.readCipherMeta()
+	if err != nil {
+		return err
+	}
+	oldCipher, err := meta.dataCipher(old)
+	if err != nil {
+		return fmt.Errorf("extract cipher with old passphrase: %w", err)
+	}
+	newMeta, newCipher, err := newCipherMeta(new)
+	if err != nil {
+		return err
+	}
+
+	var reencrypt func(b store.Bucket) error
+	reencrypt = func(b store.Bucket) error {
+		// Collect first, as backends need not allow writes while iterating.
+		var keys, values [][]byte
+		for k, v := range b.Values() {
+			plaintext, err := oldCipher.Decrypt(v)
+			if err != nil {
+				// Cipher metadata keys are stored as raw bytes — skip
+				// values that fail to decrypt.
+				continue
+			}
+			keys = append(keys, append([]byte{}, k...))
+			values = append(values, newCipher.Encrypt(plaintext))
+		}
+		for i, k := range keys {
+			if err := b.Put(k, values[i]); err != nil {
+				return fmt.Errorf("put %s: %w", k, err)
+			}
+		}
+		for _, name := range b.Buckets() {
+			if err := reencrypt(b.Bucket([]byte(name))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err = s.backend.Update(func(root store.Bucket) error {
+		for _, name := range root.Buckets() {
+			if err := reencrypt(root.Bucket([]byte(name))); err != nil {
+				return err
+			}
+		}
+		return putBucketCipherMeta(root, newMeta)
+	})
+	if err != nil {
+		return fmt.Errorf("write phase: %w", err)
+	}
+	s.setCipher(newCipher)
+	return nil
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/storage/store"
+)
+
+var (
+	_ Store     = (*BackendStore)(nil)
+	_ Locker    = (*BackendStore)(nil)
+	_ Namespace = (*backendNamespace)(nil)
+)
+
+// backendHolds reports whether any value stored in backend contains data.
+func backendHolds(t *testing.T, backend store.Backend, data []byte) bool {
+	t.Helper()
+	a := require.New(t)
+	var found bool
+	var walk func(b store.Bucket)
+	walk = func(b store.Bucket) {
+		for _, v := range b.Values() {
+			found = found || bytes.Contains(v, data)
+		}
+		for _, name := range b.Buckets() {
+			walk(b.Bucket([]byte(name)))
+		}
+	}
+	a.NoError(backend.View(func(root store.Bucket) error {
+		walk(root)
+		return nil
+	}))
+	return found
+}
+
+func TestBackendStore(t *testing.T) {
+	a := require.New(t)
+	backend := store.NewMemory()
+	db, err := NewBackendStore(backend, []byte("test-pass"))
+	a.NoError(err)
+	defer db.Close()
+
+	a.NoError(db.Command(func(b Namespace) error {
+		ns := b.Ensure([]byte(SessionsNamespace)).Ensure([]byte("s1"))
+		a.NoError(ns.PutEncrypted([]byte("zzz"), []byte("3")))
+		a.NoError(ns.PutEncrypted([]byte("aaa"), []byte("1")))
+		a.NoError(ns.PutEncrypted([]byte("mmm"), []byte("secret-data")))
+		a.ErrorIs(b.PutEncrypted([]byte("k"), nil), ErrMissingNamespace)
+		return nil
+	}))
+	a.False(backendHolds(t, backend, []byte("secret-data")))
+
+	a.NoError(db.Query(func(b Namespace) error {
+		a.Equal(
+			[]string{"s1"},
+			b.Sub([]byte(SessionsNamespace)).ListSubNamespaces(),
+		)
+		a.IsType(nilNamespace{}, b.Ensure([]byte("new")))
+		ns := b.Sub([]byte(SessionsNamespace)).Sub([]byte("s1"))
+		a.Equal(3, ns.KeyCount())
+		a.Equal([]byte("aaa"), ns.FirstKey())
+		a.Equal([]byte("zzz"), ns.LastKey())
+
+		var keys []string
+		for k := range ns.IterateEncrypted() {
+			keys = append(keys, string(k))
+		}
+		a.Equal([]string{"aaa", "mmm", "zzz"}, keys)
+		val, err := ns.GetEncrypted([]byte("mmm"))
+		a.NoError(err)
+		a.Equal([]byte("secret-data"), val)
+		_, err = ns.GetEncrypted([]byte("missing"))
+		a.ErrorIs(err, ErrMissingItem)
+		a.ErrorIs(ns.Delete([]byte("aaa")), store.ErrReadOnly)
+		return nil
+	}))
+
+	a.NoError(db.Command(func(b Namespace) error {
+		sessions := b.Sub([]byte(SessionsNamespace))
+		a.NoError(sessions.DeleteNamespace([]byte("s1")))
+		a.ErrorIs(
+			sessions.DeleteNamespace([]byte("s1")), ErrMissingNamespace,
+		)
+		return nil
+	}))
+}
+
+func TestBackendStore_Keys(t *testing.T) {
+	a := require.New(t)
+	backend := store.NewMemory()
+	db, err := NewBackendStore(backend, []byte("test-pass"))
+	a.NoError(err)
+	a.NoError(db.Command(func(b Namespace) error {
+		return b.Sub([]byte(PeersNamespace)).PutEncrypted(
+			[]byte("pk1"), []byte("peer-data"),
+		)
+	}))
+	get := func(db *BackendStore) []byte {
+		var val []byte
+		a.NoError(db.Query(func(b Namespace) error {
+			var err error
+			val, err = b.Sub([]byte(PeersNamespace)).GetEncrypted(
+				[]byte("pk1"),
+			)
+			return err
+		}))
+		return val
+	}
+
+	// The data key is kept in the backend, wrapped with the passphrase.
+	_, err = NewBackendStore(backend, []byte("wrong"))
+	a.Error(err)
+	reopened, err := NewBackendStore(backend, []byte("test-pass"))
+	a.NoError(err)
+	a.Equal([]byte("peer-data"), get(reopened))
+
+	db.Lock()
+	a.True(db.Locked())
+	a.ErrorIs(db.Query(func(Namespace) error { return nil }), ErrLocked)
+	a.Error(db.Unlock([]byte("wrong")))
+	a.NoError(db.Unlock([]byte("test-pass")))
+
+	a.Error(db.RotatePassphrase([]byte("wrong"), []byte("new-pass")))
+	a.NoError(db.RotatePassphrase([]byte("test-pass"), []byte("new-pass")))
+	a.Equal([]byte("peer-data"), get(db))
+	a.NoError(db.RotateDataKey([]byte("new-pass"), []byte("newer-pass")))
+	a.Equal([]byte("peer-data"), get(db))
+
+	_, err = NewBackendStore(backend, []byte("new-pass"))
+	a.Error(err)
+	reopened, err = NewBackendStore(backend, []byte("newer-pass"))
+	a.NoError(err)
+	a.Equal([]byte("peer-data"), get(reopened))
+}
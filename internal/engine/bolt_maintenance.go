@@ -0,0 +1,560 @@
+package engine
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	boltErrors "go.etcd.io/bbolt/errors"
+)
+
+var (
+	// ErrStoreInUse is returned by the maintenance functions when another
+	// process holds the database open.
+	ErrStoreInUse = errors.New("database is in use by another process")
+	// ErrCorruptDB is returned by [CheckBoltDB] when a database file is
+	// damaged or is not a kamune store.
+	ErrCorruptDB = errors.New("database is corrupt")
+)
+
+// compactTxMaxSize bounds the size of each transaction during compaction.
+const compactTxMaxSize = 64 * 1024
+
+// BucketStats summarizes a top-level bucket of a BoltDB file. Counts include
+// nested buckets.
+type BucketStats struct {
+	Name string
+	// Keys is the number of key-value pairs, and Buckets the number of nested
+	// buckets.
+	Keys    int
+	Buckets int
+	// Bytes is the total size of keys and (encrypted) values.
+	Bytes int64
+}
+
+// CompactProgress reports how far a compaction has got: Copied of the Total
+// bytes of keys and values have been written to the new file.
+type CompactProgress struct {
+	Copied int64
+	Total  int64
+}
+
+// SpaceStats describes how much of a database file is in use.
+type SpaceStats struct {
+	// FileBytes is the size of the file, and FreeBytes the part of it taken
+	// by pages that deleted data left free. Free pages are reused for new
+	// data, but only compaction returns them to the file system. Space the
+	// file was grown by ahead of use is not counted as free.
+	FileBytes int64
+	FreeBytes int64
+}
+
+// FreeRatio returns the fraction of the file taken by free pages.
+func (s SpaceStats) FreeRatio() float64 {
+	if s.FileBytes <= 0 {
+		return 0
+	}
+	return float64(s.FreeBytes) / float64(s.FileBytes)
+}
+
+// openBolt opens the BoltDB file at path, reporting a file lock that could not
+// be acquired within the timeout as [ErrStoreInUse].
+func openBolt(path string, opts *bolt.Options) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, opts)
+	if errors.Is(err, boltErrors.ErrTimeout) {
+		return nil, fmt.Errorf("open db: %w", ErrStoreInUse)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open db: %w", err)
+	}
+	return db, nil
+}
+
+func openBoltForMaintenance(
+	path string, readOnly bool, timeout time.Duration,
+) (*bolt.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("open db: %w", err)
+	}
+	return openBolt(path, &bolt.Options{ReadOnly: readOnly, Timeout: timeout})
+}
+
+// InspectBoltDB returns statistics for every top-level bucket of the BoltDB
+// file at path, sorted by name. It only reads the file, so no passphrase is
+// needed, but the database must not be open elsewhere.
+func InspectBoltDB(path string, timeout time.Duration) ([]BucketStats, error) {
+	db, err := openBoltForMaintenance(path, true, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return inspect(db)
+}
+
+// Inspect is the equivalent of [InspectBoltDB] for an open store. It runs
+// within a read transaction, so the store stays usable meanwhile.
+func (s *BoltStore) Inspect() ([]BucketStats, error) {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return inspect(s.db)
+}
+
+func inspect(db *bolt.DB) ([]BucketStats, error) {
+	var stats []BucketStats
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		stats, err = inspectTx(tx)
+		return err
+	})
+	return stats, err
+}
+
+func inspectTx(tx *bolt.Tx) ([]BucketStats, error) {
+	var stats []BucketStats
+	var walk func(b *bolt.Bucket, st *BucketStats) error
+	walk = func(b *bolt.Bucket, st *BucketStats) error {
+		return b.ForEach(func(k, v []byte) error {
+			st.Bytes += int64(len(k) + len(v))
+			if sub := b.Bucket(k); sub != nil {
+				st.Buckets++
+				return walk(sub, st)
+			}
+			st.Keys++
+			return nil
+		})
+	}
+	err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		st := BucketStats{Name: string(name)}
+		if err := walk(b, &st); err != nil {
+			return err
+		}
+		stats = append(stats, st)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("inspect db: %w", err)
+	}
+	slices.SortFunc(stats, func(a, b BucketStats) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return stats, nil
+}
+
+// CompactBoltDB rewrites the BoltDB file at path without the free pages left
+// behind by deleted data, and returns its size before and after. The data is
+// copied as is, so no passphrase is needed, but the database must not be open
+// elsewhere. The original is replaced only once the copy is complete.
+func CompactBoltDB(
+	path string, timeout time.Duration,
+) (before, after int64, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("stat db: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return 0, 0, fmt.Errorf("create temporary db: %w", err)
+	}
+	tmpPath := tmp.Name()
+	_ = tmp.Close()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	err = compactInto(path, tmpPath, info.Mode().Perm(), timeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	compacted, err := os.Stat(tmpPath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("stat compacted db: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return 0, 0, fmt.Errorf("replace db: %w", err)
+	}
+	return info.Size(), compacted.Size(), nil
+}
+
+// compactInto copies the database at srcPath into a fresh file at dstPath.
+// Both are closed on return.
+func compactInto(
+	srcPath, dstPath string, mode os.FileMode, timeout time.Duration,
+) error {
+	src, err := openBoltForMaintenance(srcPath, true, timeout)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return compactFrom(src, dstPath, mode, nil)
+}
+
+// compactFrom copies a snapshot of src into a fresh file at dstPath, which is
+// closed on return. src stays usable meanwhile.
+func compactFrom(
+	src *bolt.DB, dstPath string, mode os.FileMode,
+	progress func(CompactProgress),
+) error {
+	dst, err := bolt.Open(dstPath, mode, nil)
+	if err != nil {
+		return fmt.Errorf("open temporary db: %w", err)
+	}
+	err = src.View(func(tx *bolt.Tx) error {
+		return compact(dst, tx, progress)
+	})
+	if err != nil {
+		_ = dst.Close()
+		return fmt.Errorf("compact: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("close temporary db: %w", err)
+	}
+	return nil
+}
+
+// compact copies every bucket of src into dst, as [bolt.Compact] does, but
+// from a single transaction so that the copy is consistent, and calling
+// progress, if not nil, each time a batch of compactTxMaxSize bytes is
+// committed, and once done.
+func compact(
+	dst *bolt.DB, src *bolt.Tx, progress func(CompactProgress),
+) error {
+	stats, err := inspectTx(src)
+	if err != nil {
+		return err
+	}
+	var p CompactProgress
+	for _, st := range stats {
+		p.Total += st.Bytes
+	}
+	report := func() {
+		if progress != nil {
+			progress(p)
+		}
+	}
+
+	tx, err := dst.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var size int64
+	// bucket returns the bucket of the current transaction at path, since
+	// committing invalidates the buckets of the previous one.
+	bucket := func(path [][]byte) *bolt.Bucket {
+		b := tx.Bucket(path[0])
+		for _, name := range path[1:] {
+			b = b.Bucket(name)
+		}
+		b.FillPercent = 1.0
+		return b
+	}
+
+	var copyBucket func(from *bolt.Bucket, path [][]byte) error
+	copyBucket = func(from *bolt.Bucket, path [][]byte) error {
+		return from.ForEach(func(k, v []byte) error {
+			n := int64(len(k) + len(v))
+			if size > 0 && size+n > compactTxMaxSize {
+				if err := tx.Commit(); err != nil {
+					return err
+				}
+				report()
+				if tx, err = dst.Begin(true); err != nil {
+					return err
+				}
+				size = 0
+			}
+			size += n
+			p.Copied += n
+			if v != nil {
+				return bucket(path).Put(k, v)
+			}
+			sub := from.Bucket(k)
+			created, err := bucket(path).CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			if err := created.SetSequence(sub.Sequence()); err != nil {
+				return err
+			}
+			return copyBucket(sub, append(path[:len(path):len(path)], k))
+		})
+	}
+	err = src.ForEach(func(name []byte, b *bolt.Bucket) error {
+		created, err := tx.CreateBucket(name)
+		if err != nil {
+			return err
+		}
+		if err := created.SetSequence(b.Sequence()); err != nil {
+			return err
+		}
+		return copyBucket(b, [][]byte{name})
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	report()
+	return nil
+}
+
+// CompactTo writes a compacted copy of a snapshot of the database to path,
+// which must not exist, while the store stays in use. progress, if not nil,
+// is called as the copy goes. The file only appears at path once it is
+// complete.
+func (s *BoltStore) CompactTo(
+	path string, progress func(CompactProgress),
+) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("compact db: %w", os.ErrExist)
+	}
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	tmp := path + ".tmp"
+	err := compactFrom(s.db, tmp, 0600, progress)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("compact db: %w", err)
+	}
+	return nil
+}
+
+// CompactInPlace replaces the database file with a compacted copy, as
+// [CompactBoltDB] does for a closed one, and returns its size before and
+// after. Transactions wait for it to finish. The copy is checked before it
+// replaces the original, and if it cannot be swapped in, the store reopens
+// the original file.
+func (s *BoltStore) CompactInPlace(
+	progress func(CompactProgress),
+) (before, after int64, err error) {
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+
+	path := s.db.Path()
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("stat db: %w", err)
+	}
+	tmp := path + ".compact"
+	err = compactFrom(s.db, tmp, info.Mode().Perm(), progress)
+	if err == nil {
+		err = CheckBoltDB(tmp, s.opts.Timeout)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, 0, fmt.Errorf("compact db: %w", err)
+	}
+	if err := s.swap(path, tmp); err != nil {
+		return 0, 0, fmt.Errorf("compact db: %w", err)
+	}
+	compacted, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("stat compacted db: %w", err)
+	}
+	return info.Size(), compacted.Size(), nil
+}
+
+// swap replaces the database file at path with the one at tmp and opens it.
+// The database is closed first, as an open file cannot be replaced on every
+// platform, and the original is moved aside until the new file has opened.
+// If any step fails, the original is moved back and reopened; should that
+// fail too, the store is left closed and its transactions fail.
+func (s *BoltStore) swap(path, tmp string) error {
+	aside := path + ".old"
+	err := s.db.Close()
+	if err != nil {
+		err = fmt.Errorf("close db: %w", err)
+	} else if err = os.Rename(path, aside); err == nil {
+		if err = os.Rename(tmp, path); err == nil {
+			db, openErr := openBolt(path, s.opts)
+			if openErr == nil {
+				s.db = db
+				_ = os.Remove(aside)
+				return nil
+			}
+			err = fmt.Errorf("reopen compacted db: %w", openErr)
+		}
+		if rerr := os.Rename(aside, path); rerr != nil {
+			return errors.Join(err, fmt.Errorf("restore db: %w", rerr))
+		}
+	}
+	_ = os.Remove(tmp)
+	db, rerr := openBolt(path, s.opts)
+	if rerr != nil {
+		return errors.Join(err, fmt.Errorf("reopen db: %w", rerr))
+	}
+	s.db = db
+	return err
+}
+
+// Space reports how much of the database file is taken by free pages.
+func (s *BoltStore) Space() (SpaceStats, error) {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	info, err := os.Stat(s.db.Path())
+	if err != nil {
+		return SpaceStats{}, fmt.Errorf("stat db: %w", err)
+	}
+	return SpaceStats{
+		FileBytes: info.Size(),
+		FreeBytes: int64(s.db.Stats().FreeAlloc),
+	}, nil
+}
+
+// CheckBoltDB verifies the BoltDB file at path: that its pages and buckets are
+// consistent, and that it holds the key material needed to decrypt it. Values
+// are not decrypted, so no passphrase is needed, but the database must not be
+// open elsewhere. Problems are reported as [ErrCorruptDB].
+func CheckBoltDB(path string, timeout time.Duration) error {
+	db, err := openBoltForMaintenance(path, true, timeout)
+	if errors.Is(err, ErrStoreInUse) || errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCorruptDB, err)
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		// The channel must be drained for the check to finish.
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("%w: %w", ErrCorruptDB, errors.Join(errs...))
+		}
+		b := tx.Bucket(defaultNamespace)
+		if b == nil {
+			return fmt.Errorf("%w: missing default bucket", ErrCorruptDB)
+		}
+		for _, key := range []string{
+			secretSaltKey, deriveSaltKey, wrappedSaltKey, wrappedKey,
+		} {
+			if b.Get([]byte(key)) == nil {
+				return fmt.Errorf("%w: missing %s", ErrCorruptDB, key)
+			}
+		}
+		return nil
+	})
+}
+
+// Backup copies the database to path within a read transaction, so that the
+// store stays usable meanwhile, and checks the copy with [CheckBoltDB]. The
+// file only appears at path once it is complete and checked.
+func (s *BoltStore) Backup(path string) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	tmp := path + ".tmp"
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(tmp, 0600)
+	})
+	if err == nil {
+		err = CheckBoltDB(tmp, 0)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("backup db: %w", err)
+	}
+	return nil
+}
+
+// RestoreBoltDB replaces the database at path with the backup at backup,
+// after checking the backup with [CheckBoltDB]. The database being replaced,
+// if any, is kept next to it with a ".before-restore" suffix, followed by a
+// number if an earlier restore kept one already. If the backup cannot take
+// its place, the database is moved back. The database must not be open
+// elsewhere.
+func RestoreBoltDB(path, backup string, timeout time.Duration) error {
+	if err := CheckBoltDB(backup, timeout); err != nil {
+		return fmt.Errorf("check backup: %w", err)
+	}
+	// A damaged database may fail to open, but one that is in use must not
+	// be replaced.
+	if db, err := openBoltForMaintenance(path, true, timeout); err == nil {
+		_ = db.Close()
+	} else if errors.Is(err, ErrStoreInUse) {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := copyFile(backup, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("restore db: %w", err)
+	}
+	kept, err := unusedName(path + ".before-restore")
+	if err == nil {
+		err = os.Rename(path, kept)
+	}
+	moved := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("restore db: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		if moved {
+			if rerr := os.Rename(kept, path); rerr != nil {
+				err = errors.Join(err, rerr)
+			}
+		}
+		return fmt.Errorf("restore db: %w", err)
+	}
+	return nil
+}
+
+// unusedName returns name, or name followed by the first number that makes
+// it the name of no file.
+func unusedName(name string) (string, error) {
+	for i := 0; ; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s.%d", name, i)
+		}
+		_, err := os.Lstat(candidate)
+		if errors.Is(err, os.ErrNotExist) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
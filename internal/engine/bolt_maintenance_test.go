@@ -0,0 +1,324 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBoltPath(t *testing.T) (string, *BoltStore) {
+	t.Helper()
+	a := require.New(t)
+	path := filepath.Join(t.TempDir(), "maintenance.db")
+	db, err := NewBoltDB(path, []byte("test-pass"))
+	a.NoError(err)
+	return path, db
+}
+
+func TestInspectBoltDB(t *testing.T) {
+	a := require.New(t)
+	path, db := newTestBoltPath(t)
+	a.NoError(db.Command(func(b Namespace) error {
+		sess := b.Sub([]byte(SessionsNamespace)).Ensure([]byte("s1"))
+		for i := range 3 {
+			key := fmt.Appendf(nil, "k%d", i)
+			if err := sess.PutEncrypted(key, []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	live, err := db.Inspect()
+	a.NoError(err)
+	a.NoError(db.Close())
+
+	stats, err := InspectBoltDB(path, time.Second)
+	a.NoError(err)
+	a.Equal(live, stats)
+	byName := make(map[string]BucketStats, len(stats))
+	for i, st := range stats {
+		if i > 0 {
+			a.Less(stats[i-1].Name, st.Name)
+		}
+		byName[st.Name] = st
+	}
+	sessions := byName[SessionsNamespace]
+	a.Equal(3, sessions.Keys)
+	a.Equal(1, sessions.Buckets)
+	a.Positive(sessions.Bytes)
+	a.Contains(byName, PeersNamespace)
+}
+
+func TestCompactBoltDB(t *testing.T) {
+	a := require.New(t)
+	path, db := newTestBoltPath(t)
+	value := make([]byte, 1024)
+	a.NoError(db.Command(func(b Namespace) error {
+		ns := b.Sub([]byte(DefaultNamespace))
+		for i := range 1000 {
+			key := fmt.Appendf(nil, "k%04d", i)
+			if err := ns.PutEncrypted(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	a.NoError(db.Command(func(b Namespace) error {
+		ns := b.Sub([]byte(DefaultNamespace))
+		for i := 1; i < 1000; i++ {
+			if err := ns.Delete(fmt.Appendf(nil, "k%04d", i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	a.NoError(db.Close())
+
+	before, after, err := CompactBoltDB(path, time.Second)
+	a.NoError(err)
+	a.Less(after, before)
+	info, err := os.Stat(path)
+	a.NoError(err)
+	a.Equal(after, info.Size())
+	entries, err := os.ReadDir(filepath.Dir(path))
+	a.NoError(err)
+	a.Len(entries, 1, "temporary file is removed")
+
+	db, err = NewBoltDB(path, []byte("test-pass"), WithCreateIfMissing(false))
+	a.NoError(err)
+	defer db.Close()
+	a.NoError(db.Query(func(b Namespace) error {
+		got, err := b.Sub([]byte(DefaultNamespace)).GetEncrypted(
+			[]byte("k0000"),
+		)
+		a.NoError(err)
+		a.Equal(value, got)
+		return nil
+	}))
+}
+
+func TestBoltStore_Compact(t *testing.T) {
+	a := require.New(t)
+	path, db := newTestBoltPath(t)
+	defer db.Close()
+	value := make([]byte, 1024)
+	a.NoError(db.Command(func(b Namespace) error {
+		ns := b.Sub([]byte(DefaultNamespace))
+		for i := range 1000 {
+			key := fmt.Appendf(nil, "k%04d", i)
+			if err := ns.PutEncrypted(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	a.NoError(db.Command(func(b Namespace) error {
+		ns := b.Sub([]byte(DefaultNamespace))
+		for i := 100; i < 1000; i++ {
+			if err := ns.Delete(fmt.Appendf(nil, "k%04d", i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	space, err := db.Space()
+	a.NoError(err)
+	a.Greater(space.FreeRatio(), 0.4)
+	check := func(db *BoltStore) {
+		a.NoError(db.Query(func(b Namespace) error {
+			got, err := b.Sub([]byte(DefaultNamespace)).GetEncrypted(
+				[]byte("k0099"),
+			)
+			a.NoError(err)
+			a.Equal(value, got)
+			return nil
+		}))
+	}
+
+	// A copy is written while the store stays open.
+	copyPath := filepath.Join(filepath.Dir(path), "copy.db")
+	var progress []CompactProgress
+	a.NoError(db.CompactTo(copyPath, func(p CompactProgress) {
+		progress = append(progress, p)
+	}))
+	a.Greater(len(progress), 1)
+	for i, p := range progress[1:] {
+		a.Greater(p.Copied, progress[i].Copied)
+	}
+	last := progress[len(progress)-1]
+	a.Equal(last.Total, last.Copied)
+	a.ErrorIs(db.CompactTo(copyPath, nil), os.ErrExist)
+	info, err := os.Stat(copyPath)
+	a.NoError(err)
+	a.Less(info.Size(), space.FileBytes)
+	dup, err := NewBoltDB(copyPath, []byte("test-pass"))
+	a.NoError(err)
+	check(dup)
+	a.NoError(dup.Close())
+
+	// Compacting in place swaps the file under the open store.
+	before, after, err := db.CompactInPlace(nil)
+	a.NoError(err)
+	a.Equal(space.FileBytes, before)
+	a.Less(after, before)
+	check(db)
+	a.NoError(db.Command(func(b Namespace) error {
+		return b.Sub([]byte(DefaultNamespace)).PutEncrypted(
+			[]byte("after"), value,
+		)
+	}))
+	space, err = db.Space()
+	a.NoError(err)
+	a.Less(space.FreeRatio(), 0.1)
+	entries, err := os.ReadDir(filepath.Dir(path))
+	a.NoError(err)
+	a.Len(entries, 2, "temporary file is removed")
+}
+
+func TestBoltStore_CompactInPlaceRollsBack(t *testing.T) {
+	a := require.New(t)
+	path, db := newTestBoltPath(t)
+	defer db.Close()
+	put := func(key string) error {
+		return db.Command(func(b Namespace) error {
+			return b.Sub([]byte(DefaultNamespace)).PutEncrypted(
+				[]byte(key), []byte("value"),
+			)
+		})
+	}
+	a.NoError(put("before"))
+
+	// Fail the first open, that of the compacted copy, but not the reopening
+	// of the original.
+	failed := false
+	db.opts.OpenFile = func(
+		name string, flag int, perm os.FileMode,
+	) (*os.File, error) {
+		if !failed {
+			failed = true
+			return nil, os.ErrPermission
+		}
+		return os.OpenFile(name, flag, perm)
+	}
+	_, _, err := db.CompactInPlace(nil)
+	a.ErrorIs(err, os.ErrPermission)
+	a.True(failed)
+
+	a.NoError(put("after"))
+	a.NoError(db.Close())
+	reopened, err := NewBoltDB(path, []byte("test-pass"))
+	a.NoError(err)
+	defer reopened.Close()
+	a.NoError(reopened.Query(func(b Namespace) error {
+		ns := b.Sub([]byte(DefaultNamespace))
+		for _, key := range []string{"before", "after"} {
+			if _, err := ns.GetEncrypted([]byte(key)); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+		return nil
+	}))
+	entries, err := os.ReadDir(filepath.Dir(path))
+	a.NoError(err)
+	a.Len(entries, 1, "temporary files are removed")
+}
+
+func TestBoltMaintenance_StoreInUse(t *testing.T) {
+	a := require.New(t)
+	path, db := newTestBoltPath(t)
+	defer db.Close()
+
+	_, err := NewBoltDB(
+		path, []byte("test-pass"), WithTimeout(50*time.Millisecond),
+	)
+	a.ErrorIs(err, ErrStoreInUse)
+	_, err = InspectBoltDB(path, 50*time.Millisecond)
+	a.ErrorIs(err, ErrStoreInUse)
+	_, _, err = CompactBoltDB(path, 50*time.Millisecond)
+	a.ErrorIs(err, ErrStoreInUse)
+	entries, err := os.ReadDir(filepath.Dir(path))
+	a.NoError(err)
+	a.Len(entries, 1, "temporary file is removed")
+}
+
+func TestBoltMaintenance_MissingFile(t *testing.T) {
+	a := require.New(t)
+	path := filepath.Join(t.TempDir(), "missing.db")
+	_, err := InspectBoltDB(path, time.Second)
+	a.ErrorIs(err, os.ErrNotExist)
+	_, _, err = CompactBoltDB(path, time.Second)
+	a.ErrorIs(err, os.ErrNotExist)
+	a.ErrorIs(CheckBoltDB(path, time.Second), os.ErrNotExist)
+}
+
+func TestBoltStore_Backup(t *testing.T) {
+	a := require.New(t)
+	path, db := newTestBoltPath(t)
+	defer db.Close()
+	put := func(value string) {
+		a.NoError(db.Command(func(b Namespace) error {
+			ns := b.Sub([]byte(DefaultNamespace))
+			return ns.PutEncrypted([]byte("key"), []byte(value))
+		}))
+	}
+	put("before")
+
+	// The store stays open and writable while it is backed up.
+	backup := filepath.Join(filepath.Dir(path), "backup.db")
+	a.NoError(db.Backup(backup))
+	put("after")
+	a.NoError(CheckBoltDB(backup, time.Second))
+	_, err := os.Stat(backup + ".tmp")
+	a.ErrorIs(err, os.ErrNotExist)
+
+	restored, err := NewBoltDB(
+		backup, []byte("test-pass"), WithCreateIfMissing(false),
+	)
+	a.NoError(err)
+	defer restored.Close()
+	a.NoError(restored.Query(func(b Namespace) error {
+		got, err := b.Sub([]byte(DefaultNamespace)).GetEncrypted([]byte("key"))
+		a.NoError(err)
+		a.Equal("before", string(got))
+		return nil
+	}))
+}
+
+func TestCheckBoltDB_Corrupt(t *testing.T) {
+	a := require.New(t)
+	path, db := newTestBoltPath(t)
+	a.NoError(db.Close())
+	a.NoError(CheckBoltDB(path, time.Second))
+
+	data, err := os.ReadFile(path)
+	a.NoError(err)
+	// Overwrite everything past the meta pages.
+	damaged := bytes.Clone(data)
+	for i := 2 * os.Getpagesize(); i < len(damaged); i++ {
+		damaged[i] = 0xab
+	}
+	tests := map[string][]byte{
+		"damaged": damaged,
+		"garbage": []byte("not a database"),
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := require.New(t)
+			bad := filepath.Join(t.TempDir(), "bad.db")
+			a.NoError(os.WriteFile(bad, content, 0600))
+			a.ErrorIs(CheckBoltDB(bad, time.Second), ErrCorruptDB)
+		})
+	}
+
+	// A valid BoltDB file that is not a kamune store.
+	other := filepath.Join(t.TempDir(), "other.db")
+	plain, err := openBolt(other, nil)
+	a.NoError(err)
+	a.NoError(plain.Close())
+	a.ErrorIs(CheckBoltDB(other, time.Second), ErrCorruptDB)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
@@ -19,10 +20,14 @@ const (
 	secretSaltKey  = "secret-salt"
 )
 
-// BoltStore is the BoltDB implementation of [Store].
+// BoltStore is the BoltDB implementation of [Store] and [Locker].
 type BoltStore struct {
 	db     *bolt.DB
+	opts   *bolt.Options
 	cipher *enigma.Enigma
+	mu     sync.RWMutex
+	// dbMu guards db, which [BoltStore.CompactInPlace] replaces.
+	dbMu sync.RWMutex
 }
 
 // NewBoltDB creates a new BoltStore at the given path, encrypting values with
@@ -48,9 +53,9 @@ func NewBoltDB(
 	if o.Timeout > 0 {
 		boltOpts.Timeout = o.Timeout
 	}
-	db, err := bolt.Open(path, 0600, boltOpts)
+	db, err := openBolt(path, boltOpts)
 	if err != nil {
-		return nil, fmt.Errorf("open db: %w", err)
+		return nil, err
 	}
 
 	err = db.Update(func(tx *bolt.Tx) error {
@@ -59,6 +64,20 @@ func NewBoltDB(
 			settingsNamespace,
 			peersNamespace,
 			sessionsNamespace,
+			statsNamespace,
+			convsNamespace,
+			blockedNamespace,
+			rolesNamespace,
+			paramsNamespace,
+			aliasesNamespace,
+			chatKeysNamespace,
+			addrsNamespace,
+			wipesNamespace,
+			reputeNamespace,
+			namesNamespace,
+			xfersNamespace,
+			groupsNamespace,
+			timelineNamespace,
 		} {
 			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
 				return err
@@ -80,31 +99,76 @@ func NewBoltDB(
 		return nil, fmt.Errorf("cipher: %w", err)
 	}
 
-	return &BoltStore{db: db, cipher: cipher}, nil
+	return &BoltStore{db: db, opts: boltOpts, cipher: cipher}, nil
 }
 
 func (s *BoltStore) Close() error {
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
 	return s.db.Close()
 }
 
 func (s *BoltStore) Query(f func(b Namespace) error) error {
+	c := s.dataCipher()
+	if c == nil {
+		return ErrLocked
+	}
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
 	return s.db.View(func(tx *bolt.Tx) error {
-		return f(newRootNamespace(tx, s.cipher))
+		return f(newRootNamespace(tx, c))
 	})
 }
 
+// Command runs f with write access. Writes that fail because the disk is full
+// or failing are reported as [ErrUnavailable].
 func (s *BoltStore) Command(f func(b Namespace) error) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
-		return f(newRootNamespace(tx, s.cipher))
+	c := s.dataCipher()
+	if c == nil {
+		return ErrLocked
+	}
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return f(newRootNamespace(tx, c))
 	})
+	return WrapUnavailable(err)
+}
+
+// Lock drops the data cipher. Transactions already running finish with it.
+func (s *BoltStore) Lock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cipher = nil
+}
+
+// Unlock derives the data cipher from passphrase again. On a store that is
+// not locked, it only checks the passphrase.
+func (s *BoltStore) Unlock(passphrase []byte) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	c, _, err := extractCipher(s.db, passphrase)
+	if err != nil {
+		return fmt.Errorf("extract cipher: %w", err)
+	}
+	s.setCipher(c)
+	return nil
 }
 
-// cipherMeta holds the raw cipher-wrapping metadata stored in the DB.
-type cipherMeta struct {
-	secretSalt  []byte
-	deriveSalt  []byte
-	wrappedSalt []byte
-	wrappedKey  []byte
+func (s *BoltStore) Locked() bool {
+	return s.dataCipher() == nil
+}
+
+func (s *BoltStore) dataCipher() *enigma.Enigma {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cipher
+}
+
+func (s *BoltStore) setCipher(c *enigma.Enigma) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cipher = c
 }
 
 func extractCipher(
@@ -112,87 +176,26 @@ func extractCipher(
 ) (*enigma.Enigma, cipherMeta, error) {
 	var meta cipherMeta
 	err := db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultNamespace)
-		meta.wrappedKey = bytes.Clone(bucket.Get([]byte(wrappedKey)))
-		meta.deriveSalt = bytes.Clone(bucket.Get([]byte(deriveSaltKey)))
-		meta.wrappedSalt = bytes.Clone(bucket.Get([]byte(wrappedSaltKey)))
-		meta.secretSalt = bytes.Clone(bucket.Get([]byte(secretSaltKey)))
+		meta = readCipherMeta(tx.Bucket(defaultNamespace).Get)
 		return nil
 	})
 	if err != nil {
 		return nil, cipherMeta{}, fmt.Errorf("get values: %w", err)
 	}
-	if meta.secretSalt == nil || meta.deriveSalt == nil ||
-		meta.wrappedSalt == nil || meta.wrappedKey == nil {
-		return nil, cipherMeta{}, ErrMissingItem
-	}
-	derivedPass, err := enigma.Derive(
-		pass, meta.deriveSalt, []byte(dpk), 32,
-	)
-	if err != nil {
-		return nil, cipherMeta{}, fmt.Errorf("derive from pass: %w", err)
-	}
-	keyCipher, err := enigma.NewEnigma(
-		derivedPass, meta.wrappedSalt, []byte(kek),
-	)
+	dataCipher, err := meta.dataCipher(pass)
 	if err != nil {
-		return nil, cipherMeta{}, fmt.Errorf("key cipher: %w", err)
-	}
-	secret, err := keyCipher.Decrypt(meta.wrappedKey)
-	if err != nil {
-		return nil, cipherMeta{}, fmt.Errorf("decrypt secret: %w", err)
-	}
-	dataCipher, err := enigma.NewEnigma(
-		secret, meta.secretSalt, []byte(dek),
-	)
-	if err != nil {
-		return nil, cipherMeta{}, fmt.Errorf("data cipher: %w", err)
+		return nil, cipherMeta{}, err
 	}
 	return dataCipher, meta, nil
 }
 
 func createCipher(db *bolt.DB, pass []byte) (*enigma.Enigma, error) {
-	var (
-		secret      = randomBytes(32)
-		secretSalt  = randomBytes(32)
-		deriveSalt  = randomBytes(32)
-		wrappedSalt = randomBytes(32)
-	)
-
-	derivedPass, err := enigma.Derive(pass, deriveSalt, []byte(dpk), 32)
-	if err != nil {
-		return nil, fmt.Errorf("derive from pass: %w", err)
-	}
-	keyCipher, err := enigma.NewEnigma(derivedPass, wrappedSalt, []byte(kek))
-	if err != nil {
-		return nil, fmt.Errorf("key cipher: %w", err)
-	}
-	wrapped := keyCipher.Encrypt(secret)
-	dataCipher, err := enigma.NewEnigma(secret, secretSalt, []byte(dek))
+	meta, dataCipher, err := newCipherMeta(pass)
 	if err != nil {
-		return nil, fmt.Errorf("data cipher: %w", err)
+		return nil, err
 	}
-
 	err = db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultNamespace)
-		err = bucket.Put([]byte(wrappedKey), wrapped)
-		if err != nil {
-			return fmt.Errorf("put wrapped key: %w", err)
-		}
-		err = bucket.Put([]byte(wrappedSaltKey), wrappedSalt)
-		if err != nil {
-			return fmt.Errorf("put wrapped salt: %w", err)
-		}
-		err = bucket.Put([]byte(deriveSaltKey), deriveSalt)
-		if err != nil {
-			return fmt.Errorf("put derive salt: %w", err)
-		}
-		err = bucket.Put([]byte(secretSaltKey), secretSalt)
-		if err != nil {
-			return fmt.Errorf("put secret salt: %w", err)
-		}
-
-		return nil
+		return putCipherMeta(tx, meta)
 	})
 	if err != nil {
 		return nil, fmt.Errorf("update db: %w", err)
@@ -201,6 +204,17 @@ func createCipher(db *bolt.DB, pass []byte) (*enigma.Enigma, error) {
 	return dataCipher, nil
 }
 
+// putCipherMeta stores meta in the default bucket.
+func putCipherMeta(tx *bolt.Tx, meta cipherMeta) error {
+	bucket := tx.Bucket(defaultNamespace)
+	for _, kv := range meta.entries() {
+		if err := bucket.Put(kv[0], kv[1]); err != nil {
+			return fmt.Errorf("put %s: %w", kv[0], err)
+		}
+	}
+	return nil
+}
+
 // navigateBucket walks a slash-separated path (e.g. "a/b/c") from the tx root,
 // returning the deepest bucket or nil if any segment is missing.
 func navigateBucket(tx *bolt.Tx, path []byte) *bolt.Bucket {
@@ -218,68 +232,42 @@ func navigateBucket(tx *bolt.Tx, path []byte) *bolt.Bucket {
 // RotatePassphrase re-wraps the data encryption key with a new passphrase. Only
 // the key-wrapping metadata changes; encrypted data is untouched.
 func (s *BoltStore) RotatePassphrase(old, new []byte) error {
+	if s.Locked() {
+		return ErrLocked
+	}
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+
 	// Decrypt the DEK secret using the old passphrase.
 	_, meta, err := extractCipher(s.db, old)
 	if err != nil {
 		return fmt.Errorf("extract cipher with old passphrase: %w", err)
 	}
-
-	// Decrypt the raw DEK secret.
-	oldDerivedPass, err := enigma.Derive(old, meta.deriveSalt, []byte(dpk), 32)
+	secret, err := meta.unwrap(old)
 	if err != nil {
-		return fmt.Errorf("derive old pass: %w", err)
-	}
-	oldKeyCipher, err := enigma.NewEnigma(
-		oldDerivedPass, meta.wrappedSalt, []byte(kek),
-	)
-	if err != nil {
-		return fmt.Errorf("old key cipher: %w", err)
-	}
-	secret, err := oldKeyCipher.Decrypt(meta.wrappedKey)
-	if err != nil {
-		return fmt.Errorf("decrypt secret: %w", err)
+		return err
 	}
 
 	// Re-wrap with new passphrase using fresh salts.
-	newDeriveSalt := randomBytes(32)
-	newWrappedSalt := randomBytes(32)
-
-	newDerivedPass, err := enigma.Derive(new, newDeriveSalt, []byte(dpk), 32)
-	if err != nil {
-		return fmt.Errorf("derive new pass: %w", err)
-	}
-	newKeyCipher, err := enigma.NewEnigma(
-		newDerivedPass, newWrappedSalt, []byte(kek),
-	)
+	newMeta, err := wrapCipherMeta(secret, meta.secretSalt, new)
 	if err != nil {
-		return fmt.Errorf("new key cipher: %w", err)
+		return err
 	}
-	newWrapped := newKeyCipher.Encrypt(secret)
 
 	// Write updated metadata.
 	err = s.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultNamespace)
-		if err := bucket.Put([]byte(wrappedKey), newWrapped); err != nil {
-			return fmt.Errorf("put wrapped key: %w", err)
-		}
-		err := bucket.Put([]byte(wrappedSaltKey), newWrappedSalt)
-		if err != nil {
-			return fmt.Errorf("put wrapped salt: %w", err)
-		}
-		if err := bucket.Put([]byte(deriveSaltKey), newDeriveSalt); err != nil {
-			return fmt.Errorf("put derive salt: %w", err)
-		}
-		return nil
+		return putCipherMeta(tx, newMeta)
 	})
 	if err != nil {
 		return fmt.Errorf("update metadata: %w", err)
 	}
 
 	// Swap the in-memory cipher.
-	s.cipher, _, err = extractCipher(s.db, new)
+	c, _, err := extractCipher(s.db, new)
 	if err != nil {
 		return fmt.Errorf("reload cipher: %w", err)
 	}
+	s.setCipher(c)
 
 	return nil
 }
@@ -288,35 +276,24 @@ func (s *BoltStore) RotatePassphrase(old, new []byte) error {
 // encrypted values across every namespace. This is expensive but atomic per
 // bolt.Update transaction.
 func (s *BoltStore) RotateDataKey(old, new []byte) error {
+	if s.Locked() {
+		return ErrLocked
+	}
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+
 	// Verify we can decrypt with the old passphrase.
 	oldCipher, _, err := extractCipher(s.db, old)
 	if err != nil {
 		return fmt.Errorf("extract cipher with old passphrase: %w", err)
 	}
 
-	// Generate a fresh DEK.
-	newSecret := randomBytes(32)
-	newSecretSalt := randomBytes(32)
-	newCipher, err := enigma.NewEnigma(newSecret, newSecretSalt, []byte(dek))
+	// Generate a fresh DEK wrapped with the new passphrase.
+	newMeta, newCipher, err := newCipherMeta(new)
 	if err != nil {
-		return fmt.Errorf("new data cipher: %w", err)
+		return err
 	}
 
-	// Wrap the new DEK with the new passphrase.
-	newDeriveSalt := randomBytes(32)
-	newWrappedSalt := randomBytes(32)
-	newDerivedPass, err := enigma.Derive(new, newDeriveSalt, []byte(dpk), 32)
-	if err != nil {
-		return fmt.Errorf("derive new pass: %w", err)
-	}
-	newKeyCipher, err := enigma.NewEnigma(
-		newDerivedPass, newWrappedSalt, []byte(kek),
-	)
-	if err != nil {
-		return fmt.Errorf("new key cipher: %w", err)
-	}
-	newWrapped := newKeyCipher.Encrypt(newSecret)
-
 	// Collect every (bucket-path, key, ciphertext) triple first, outside the
 	// write transaction, to avoid holding a write lock while iterating.
 	type entry struct {
@@ -382,25 +359,14 @@ func (s *BoltStore) RotateDataKey(old, new []byte) error {
 
 		// Store all cipher metadata so future reads reconstruct the correct
 		// cipher on restart.
-		bucket := tx.Bucket(defaultNamespace)
-		for _, kv := range [][2][]byte{
-			{[]byte(secretSaltKey), newSecretSalt},
-			{[]byte(wrappedKey), newWrapped},
-			{[]byte(wrappedSaltKey), newWrappedSalt},
-			{[]byte(deriveSaltKey), newDeriveSalt},
-		} {
-			if err := bucket.Put(kv[0], kv[1]); err != nil {
-				return fmt.Errorf("put %s: %w", kv[0], err)
-			}
-		}
-		return nil
+		return putCipherMeta(tx, newMeta)
 	})
 	if err != nil {
 		return fmt.Errorf("write phase: %w", err)
 	}
 
 	// Swap the in-memory cipher.
-	s.cipher = newCipher
+	s.setCipher(newCipher)
 
 	return nil
 }
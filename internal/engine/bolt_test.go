@@ -12,6 +12,7 @@ import (
 
 var (
 	_ Store     = (*BoltStore)(nil)
+	_ Locker    = (*BoltStore)(nil)
 	_ Namespace = (*boltNamespace)(nil)
 )
 
@@ -357,6 +358,35 @@ func TestRotatePassphrase_WrongOldPass(t *testing.T) {
 	a.Error(err)
 }
 
+func TestBoltStore_Lock(t *testing.T) {
+	a := require.New(t)
+	db := newTestBoltStore(t)
+	a.NoError(db.Command(func(b Namespace) error {
+		return b.Sub([]byte(DefaultNamespace)).PutEncrypted(
+			[]byte("secret"), []byte("my-secret-data"),
+		)
+	}))
+
+	db.Lock()
+	a.True(db.Locked())
+	noop := func(Namespace) error { return nil }
+	a.ErrorIs(db.Query(noop), ErrLocked)
+	a.ErrorIs(db.Command(noop), ErrLocked)
+	a.ErrorIs(db.RotatePassphrase([]byte("test-pass"), []byte("new")), ErrLocked)
+	a.ErrorIs(db.RotateDataKey([]byte("test-pass"), []byte("new")), ErrLocked)
+
+	a.Error(db.Unlock([]byte("wrong")))
+	a.True(db.Locked())
+	a.NoError(db.Unlock([]byte("test-pass")))
+	a.False(db.Locked())
+	a.NoError(db.Query(func(b Namespace) error {
+		val, err := b.Sub([]byte(DefaultNamespace)).GetEncrypted([]byte("secret"))
+		a.NoError(err)
+		a.Equal([]byte("my-secret-data"), val)
+		return nil
+	}))
+}
+
 func TestRotateDataKey(t *testing.T) {
 	a := require.New(t)
 	db := newTestBoltStore(t)
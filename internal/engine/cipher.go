@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/kamune-org/kamune/internal/enigma"
+)
+
+// cipherMeta holds the raw cipher-wrapping metadata stored in the DB.
+type cipherMeta struct {
+	secretSalt  []byte
+	deriveSalt  []byte
+	wrappedSalt []byte
+	wrappedKey  []byte
+}
+
+// readCipherMeta reads the metadata with get, which returns the value of a
+// key of the default namespace. The values are copied.
+func readCipherMeta(get func(key []byte) []byte) cipherMeta {
+	clone := func(key string) []byte {
+		v := get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		return append([]byte{}, v...)
+	}
+	return cipherMeta{
+		wrappedKey:  clone(wrappedKey),
+		deriveSalt:  clone(deriveSaltKey),
+		wrappedSalt: clone(wrappedSaltKey),
+		secretSalt:  clone(secretSaltKey),
+	}
+}
+
+// entries returns the keys and values to store m under in the default
+// namespace.
+func (m cipherMeta) entries() [][2][]byte {
+	return [][2][]byte{
+		{[]byte(secretSaltKey), m.secretSalt},
+		{[]byte(wrappedKey), m.wrappedKey},
+		{[]byte(wrappedSaltKey), m.wrappedSalt},
+		{[]byte(deriveSaltKey), m.deriveSalt},
+	}
+}
+
+// unwrap returns the data encryption key wrapped in m, unwrapping it with the
+// key derived from pass. It fails with [ErrMissingItem] if m is incomplete.
+func (m cipherMeta) unwrap(pass []byte) ([]byte, error) {
+	if m.secretSalt == nil || m.deriveSalt == nil ||
+		m.wrappedSalt == nil || m.wrappedKey == nil {
+		return nil, ErrMissingItem
+	}
+	derivedPass, err := enigma.Derive(pass, m.deriveSalt, []byte(dpk), 32)
+	if err != nil {
+		return nil, fmt.Errorf("derive from pass: %w", err)
+	}
+	keyCipher, err := enigma.NewEnigma(
+		derivedPass, m.wrappedSalt, []byte(kek),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("key cipher: %w", err)
+	}
+	secret, err := keyCipher.Decrypt(m.wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt secret: %w", err)
+	}
+	return secret, nil
+}
+
+// dataCipher returns the data cipher of m, unwrapping its key with pass.
+func (m cipherMeta) dataCipher(pass []byte) (*enigma.Enigma, error) {
+	secret, err := m.unwrap(pass)
+	if err != nil {
+		return nil, err
+	}
+	c, err := enigma.NewEnigma(secret, m.secretSalt, []byte(dek))
+	if err != nil {
+		return nil, fmt.Errorf("data cipher: %w", err)
+	}
+	return c, nil
+}
+
+// wrapCipherMeta wraps the data encryption key secret, used with secretSalt,
+// with a key derived from pass under fresh salts.
+func wrapCipherMeta(secret, secretSalt, pass []byte) (cipherMeta, error) {
+	m := cipherMeta{
+		secretSalt:  secretSalt,
+		deriveSalt:  randomBytes(32),
+		wrappedSalt: randomBytes(32),
+	}
+	derivedPass, err := enigma.Derive(pass, m.deriveSalt, []byte(dpk), 32)
+	if err != nil {
+		return cipherMeta{}, fmt.Errorf("derive from pass: %w", err)
+	}
+	keyCipher, err := enigma.NewEnigma(
+		derivedPass, m.wrappedSalt, []byte(kek),
+	)
+	if err != nil {
+		return cipherMeta{}, fmt.Errorf("key cipher: %w", err)
+	}
+	m.wrappedKey = keyCipher.Encrypt(secret)
+	return m, nil
+}
+
+// newCipherMeta creates a fresh data encryption key wrapped with pass, and
+// returns its metadata and data cipher.
+func newCipherMeta(pass []byte) (cipherMeta, *enigma.Enigma, error) {
+	secret, secretSalt := randomBytes(32), randomBytes(32)
+	m, err := wrapCipherMeta(secret, secretSalt, pass)
+	if err != nil {
+		return cipherMeta{}, nil, err
+	}
+	c, err := enigma.NewEnigma(secret, secretSalt, []byte(dek))
+	if err != nil {
+		return cipherMeta{}, nil, fmt.Errorf("data cipher: %w", err)
+	}
+	return m, c, nil
+}
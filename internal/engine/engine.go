@@ -9,10 +9,25 @@ import (
 )
 
 const (
-	DefaultNamespace  = "kamune-store"
-	PeersNamespace    = "peers"
-	SettingsNamespace = "settings"
-	SessionsNamespace = "sessions"
+	DefaultNamespace       = "kamune-store"
+	PeersNamespace         = "peers"
+	SettingsNamespace      = "settings"
+	SessionsNamespace      = "sessions"
+	StatsNamespace         = "stats"
+	SearchNamespace        = "search"
+	ConversationsNamespace = "conversations"
+	BlockedNamespace       = "blocked"
+	RolesNamespace         = "roles"
+	ParametersNamespace    = "parameters"
+	AliasesNamespace       = "aliases"
+	ChatKeysNamespace      = "chat_keys"
+	AddressesNamespace     = "addresses"
+	WipesNamespace         = "wipes"
+	ReputationNamespace    = "reputation"
+	NamesNamespace         = "names"
+	TransfersNamespace     = "transfers"
+	GroupsNamespace        = "groups"
+	TimelineNamespace      = "timeline"
 
 	kek = "key-encryption-key"
 	dek = "data-encryption-key"
@@ -22,11 +37,26 @@ const (
 var (
 	ErrMissingItem      = errors.New("item not found")
 	ErrMissingNamespace = errors.New("namespace not found")
+	ErrLocked           = errors.New("store is locked")
 
 	defaultNamespace  = []byte(DefaultNamespace)
 	settingsNamespace = []byte(SettingsNamespace)
 	peersNamespace    = []byte(PeersNamespace)
 	sessionsNamespace = []byte(SessionsNamespace)
+	statsNamespace    = []byte(StatsNamespace)
+	convsNamespace    = []byte(ConversationsNamespace)
+	blockedNamespace  = []byte(BlockedNamespace)
+	rolesNamespace    = []byte(RolesNamespace)
+	paramsNamespace   = []byte(ParametersNamespace)
+	aliasesNamespace  = []byte(AliasesNamespace)
+	chatKeysNamespace = []byte(ChatKeysNamespace)
+	addrsNamespace    = []byte(AddressesNamespace)
+	wipesNamespace    = []byte(WipesNamespace)
+	reputeNamespace   = []byte(ReputationNamespace)
+	namesNamespace    = []byte(NamesNamespace)
+	xfersNamespace    = []byte(TransfersNamespace)
+	groupsNamespace   = []byte(GroupsNamespace)
+	timelineNamespace = []byte(TimelineNamespace)
 )
 
 // Options holds backend-agnostic configuration for opening a store.
@@ -68,6 +98,37 @@ type Store interface {
 	RotateDataKey(old, new []byte) error
 }
 
+// Locker is implemented by stores that can drop their data encryption key from
+// memory and recover it from the passphrase. While a store is locked, Query,
+// Command, and key rotation fail with [ErrLocked].
+type Locker interface {
+	Lock()
+	Unlock(passphrase []byte) error
+	Locked() bool
+}
+
+// Backuper is implemented by stores that can copy a consistent snapshot of
+// themselves to a file while they stay in use.
+type Backuper interface {
+	Backup(path string) error
+}
+
+// Inspector is implemented by stores that can report statistics for their
+// top-level buckets while they stay in use.
+type Inspector interface {
+	Inspect() ([]BucketStats, error)
+}
+
+// Compactor is implemented by stores that can rewrite their file without the
+// free pages left behind by deleted data while they stay open.
+type Compactor interface {
+	CompactTo(path string, progress func(CompactProgress)) error
+	CompactInPlace(
+		progress func(CompactProgress),
+	) (before, after int64, err error)
+	Space() (SpaceStats, error)
+}
+
 // Namespace is the interface for pluggable namespace implementations.
 type Namespace interface {
 	Sub(name []byte) Namespace
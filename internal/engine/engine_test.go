@@ -1,6 +1,10 @@
 package engine
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -32,3 +36,33 @@ func TestNilNamespace(t *testing.T) {
 	}
 	a.Equal(0, count)
 }
+
+func TestWrapUnavailable(t *testing.T) {
+	tests := []struct {
+		err         error
+		name        string
+		unavailable bool
+	}{
+		{name: "nil"},
+		{name: "other", err: ErrMissingItem},
+		{
+			name:        "disk full",
+			err:         &fs.PathError{Op: "write", Err: syscall.ENOSPC},
+			unavailable: true,
+		},
+		{
+			name:        "io error",
+			err:         fmt.Errorf("sync: %w", syscall.EIO),
+			unavailable: true,
+		},
+		{name: "already wrapped", err: ErrUnavailable, unavailable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := require.New(t)
+			err := WrapUnavailable(tt.err)
+			a.Equal(tt.unavailable, errors.Is(err, ErrUnavailable))
+			a.ErrorIs(err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// ErrUnavailable is returned when a write fails because the disk holding the
+// store is full or failing. The store itself is intact, and writes may
+// succeed again once the condition clears.
+var ErrUnavailable = errors.New("storage is unavailable")
+
+// diskErrors are the system errors that make a store unavailable.
+var diskErrors = []error{
+	syscall.ENOSPC, syscall.EDQUOT, syscall.EIO, syscall.EROFS,
+}
+
+// WrapUnavailable returns err as [ErrUnavailable] if it was caused by a full
+// or failing disk, and unchanged otherwise.
+func WrapUnavailable(err error) error {
+	if err == nil || errors.Is(err, ErrUnavailable) {
+		return err
+	}
+	for _, target := range diskErrors {
+		if errors.Is(err, target) {
+			return fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+	}
+	return err
+}
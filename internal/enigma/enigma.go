@@ -18,6 +18,9 @@ import (
 const (
 	base32alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
 	nonceSize      = chacha20poly1305.NonceSizeX
+
+	// KeySize is the size of the key NewEnigma derives.
+	KeySize = chacha20poly1305.KeySize
 )
 
 var (
@@ -30,7 +33,7 @@ type Enigma struct {
 }
 
 func NewEnigma(secret, salt, info []byte) (*Enigma, error) {
-	key, err := Derive(secret, salt, info, chacha20poly1305.KeySize)
+	key, err := Derive(secret, salt, info, KeySize)
 	if err != nil {
 		return nil, fmt.Errorf("derive key: %w", err)
 	}
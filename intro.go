@@ -1,36 +1,72 @@
 package kamune
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
+	"slices"
+	"sync"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/internal/clock"
 	"github.com/kamune-org/kamune/pkg/attest"
 	"github.com/kamune-org/kamune/pkg/storage"
 )
 
+// introFields holds the optional fields of an introduction.
+type introFields struct {
+	// metadata is application metadata; see checkIntroMetadata.
+	metadata map[string][]byte
+	// services lists the services offered by the sender.
+	services []string
+	// service is the service the sender requests from the peer.
+	service string
+	// capabilities lists the optional protocol features the sender supports.
+	capabilities []string
+	// claims is the chain of identity claims about the sender's key.
+	claims []Claim
+	// emojiSets lists the emoji sets the sender can render fingerprints
+	// with; see ServeWithEmojiSets.
+	emojiSets []string
+}
+
 // sendIntroduction sends an identity introduction message to the peer.
-// This is the first message exchanged in a new connection.
+// This is the first message exchanged in a new connection. The optional
+// fields are covered by the introduction's signature.
 func sendIntroduction(
-	conn Conn, at *attest.Attest, name, version string,
+	conn Conn, at *attest.Attest, name, version string, fields introFields,
 ) error {
 	intro := &pb.Introduce{
-		Name:       name,
-		PublicKey:  at.MarshalPublicKey(),
-		AppVersion: version,
+		Name:         name,
+		PublicKey:    at.MarshalPublicKey(),
+		AppVersion:   version,
+		Metadata:     withEmojiSets(fields.metadata, fields.emojiSets),
+		Services:     fields.services,
+		Service:      fields.service,
+		Capabilities: fields.capabilities,
+	}
+	for _, c := range fields.claims {
+		intro.Claims = append(intro.Claims, c.toProto())
 	}
 	message, err := proto.Marshal(intro)
 	if err != nil {
 		return fmt.Errorf("marshalling intro: %w", err)
 	}
 
-	md := &pb.Metadata{
+	// The random ID keeps two introductions from producing the same signature
+	// even if they are identical and share a timestamp, so that the peer's
+	// replay cache only ever matches a genuine replay.
+	metadata := &pb.Metadata{
+		ID:        rand.Text(),
 		Timestamp: timestamppb.Now(),
 		Route:     RouteIdentity.ToProto(),
 	}
-	metadataBytes, err := proto.Marshal(md)
+	metadataBytes, err := proto.Marshal(metadata)
 	if err != nil {
 		return fmt.Errorf("marshalling metadata: %w", err)
 	}
@@ -84,11 +120,193 @@ func receiveIntroduction(st *pb.SignedTransport) (*storage.Peer, string, error)
 		return nil, "", ErrInvalidSignature
 	}
 
+	if err := checkIntroMetadata(introduce.GetMetadata()); err != nil {
+		return nil, "", err
+	}
+	if err := checkClaimChain(len(introduce.GetClaims())); err != nil {
+		return nil, "", err
+	}
+
 	peer := &storage.Peer{
-		Name:       introduce.GetName(),
-		PublicKey:  remote,
-		AppVersion: introduce.GetAppVersion(),
+		Name:         introduce.GetName(),
+		PublicKey:    remote,
+		AppVersion:   introduce.GetAppVersion(),
+		Metadata:     introduce.GetMetadata(),
+		Services:     introduce.GetServices(),
+		Service:      introduce.GetService(),
+		Capabilities: introduce.GetCapabilities(),
 	}
 
 	return peer, introduce.GetAppVersion(), nil
 }
+
+// checkIntroMetadata rejects introduction metadata whose keys and values
+// together exceed introMetadataMaxSize.
+func checkIntroMetadata(md map[string][]byte) error {
+	var size int
+	for k, v := range md {
+		size += len(k) + len(v)
+	}
+	if size > introMetadataMaxSize {
+		return fmt.Errorf(
+			"%w: %d bytes, limit is %d",
+			ErrIntroductionMetadataTooLarge, size, introMetadataMaxSize,
+		)
+	}
+	return nil
+}
+
+// checkService returns ErrServiceUnavailable if service is requested but not
+// among the offered services.
+func checkService(offered []string, service string) error {
+	if service != "" && !slices.Contains(offered, service) {
+		return fmt.Errorf("%w: %q", ErrServiceUnavailable, service)
+	}
+	return nil
+}
+
+// introGuard rejects stale and replayed introductions on the accept path. It
+// runs before any storage access or key agreement work, so that a flood of
+// recorded introductions costs the server as little as possible. A zero maxAge
+// disables both checks. The replay cache holds at most capacity signatures.
+type introGuard struct {
+	clock    clock.Clock
+	seen     map[[sha256.Size]byte]time.Time
+	maxAge   time.Duration
+	capacity int
+	mu       sync.Mutex
+}
+
+func newIntroGuard(
+	c clock.Clock, maxAge time.Duration, capacity int,
+) *introGuard {
+	return &introGuard{
+		clock:    c,
+		maxAge:   maxAge,
+		capacity: capacity,
+		seen:     make(map[[sha256.Size]byte]time.Time),
+	}
+}
+
+// checkFresh rejects an introduction whose timestamp lies outside maxAge of
+// the local clock, in either direction to tolerate bounded clock skew. It only
+// parses the metadata and is meant to run before signature verification.
+func (g *introGuard) checkFresh(st *pb.SignedTransport) error {
+	if g.maxAge <= 0 {
+		return nil
+	}
+	var md pb.Metadata
+	if err := proto.Unmarshal(st.GetMetadata(), &md); err != nil {
+		return fmt.Errorf("unmarshalling metadata: %w", err)
+	}
+	if md.GetTimestamp() == nil {
+		return fmt.Errorf("%w: missing timestamp", ErrStaleIntroduction)
+	}
+	age := g.clock.Now().Sub(md.GetTimestamp().AsTime())
+	if age > g.maxAge || age < -g.maxAge {
+		return fmt.Errorf("%w: age %s", ErrStaleIntroduction, age)
+	}
+	return nil
+}
+
+// checkReplay records the introduction's signature and rejects it if the same
+// signature was already seen within maxAge. It must only be called after the
+// signature has been verified, so that forged frames cannot fill the cache.
+func (g *introGuard) checkReplay(st *pb.SignedTransport) error {
+	if g.maxAge <= 0 {
+		return nil
+	}
+	key := sha256.Sum256(st.GetSignature())
+	now := g.clock.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if expiry, ok := g.seen[key]; ok && now.Before(expiry) {
+		return ErrReplayedIntroduction
+	}
+	if len(g.seen) >= g.capacity {
+		g.pruneLocked(now)
+	}
+	// A signature is only useful to an attacker while checkFresh would still
+	// accept its timestamp, which is at most 2*maxAge after it was accepted.
+	g.seen[key] = now.Add(2 * g.maxAge)
+	return nil
+}
+
+// pruneLocked drops expired entries. If the cache is still full afterwards,
+// arbitrary entries are evicted to make room. Caller must hold g.mu.
+func (g *introGuard) pruneLocked(now time.Time) {
+	for k, expiry := range g.seen {
+		if !now.Before(expiry) {
+			delete(g.seen, k)
+		}
+	}
+	for k := range g.seen {
+		if len(g.seen) < g.capacity {
+			break
+		}
+		delete(g.seen, k)
+	}
+}
+
+// introParams are the protocol parameters a peer declares in its
+// introduction.
+type introParams struct {
+	version      string
+	service      string
+	services     []string
+	capabilities []string
+}
+
+func localIntroParams(f introFields) introParams {
+	return introParams{
+		version:      AppVersion,
+		service:      f.service,
+		services:     f.services,
+		capabilities: f.capabilities,
+	}
+}
+
+func peerIntroParams(peer *storage.Peer) introParams {
+	return introParams{
+		version:      peer.AppVersion,
+		service:      peer.Service,
+		services:     peer.Services,
+		capabilities: peer.Capabilities,
+	}
+}
+
+// negotiationHash hashes the parameters both peers declared in their
+// introductions, initiator first. It is bound into the handshake transcript
+// (see handshakeTranscriptHash), so that a man in the middle that got either
+// peer to see a different introduction, such as one with a capability
+// stripped, a service dropped, or an older version, makes the challenges
+// fail with [ErrVerificationFailed] instead of silently downgrading the
+// session.
+func negotiationHash(initiator, responder introParams) [32]byte {
+	h := sha256.New()
+	var b [4]byte
+	write := func(v []byte) {
+		binary.BigEndian.PutUint32(b[:], uint32(len(v)))
+		_, _ = h.Write(b[:])
+		_, _ = h.Write(v)
+	}
+
+	_, _ = h.Write([]byte(negotiationInfo))
+	for _, p := range []introParams{initiator, responder} {
+		write([]byte(p.version))
+		write([]byte(p.service))
+		for _, list := range [][]string{p.services, p.capabilities} {
+			binary.BigEndian.PutUint32(b[:], uint32(len(list)))
+			_, _ = h.Write(b[:])
+			for _, v := range list {
+				write([]byte(v))
+			}
+		}
+	}
+
+	var out [32]byte
+	copy(out[:], h.Sum(nil))
+	return out
+}
@@ -3,10 +3,16 @@ package kamune
 import (
 	"crypto/rand"
 	"net"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/internal/clock"
 	"github.com/kamune-org/kamune/pkg/attest"
 )
 
@@ -28,7 +34,9 @@ func TestIntroduce(t *testing.T) {
 	done1 := make(chan struct{})
 	go func() {
 		defer close(done1)
-		sendErr1 = sendIntroduction(conn1, attest1, rand.Text(), "1.0.0")
+		sendErr1 = sendIntroduction(
+			conn1, attest1, rand.Text(), "1.0.0", introFields{},
+		)
 	}()
 	st2, err := readSignedTransport(conn2)
 	a.NoError(err)
@@ -46,7 +54,9 @@ func TestIntroduce(t *testing.T) {
 	done2 := make(chan struct{})
 	go func() {
 		defer close(done2)
-		sendErr2 = sendIntroduction(conn2, attest2, rand.Text(), "1.0.0")
+		sendErr2 = sendIntroduction(
+			conn2, attest2, rand.Text(), "1.0.0", introFields{},
+		)
 	}()
 	st1, err := readSignedTransport(conn1)
 	a.NoError(err)
@@ -60,3 +70,244 @@ func TestIntroduce(t *testing.T) {
 	a.Equal(attest2.MarshalPublicKey(), peer.PublicKey)
 	a.Equal("1.0.0", version)
 }
+
+func introAt(t *testing.T, ts time.Time, sig []byte) *pb.SignedTransport {
+	t.Helper()
+	a := require.New(t)
+	md, err := proto.Marshal(&pb.Metadata{
+		Timestamp: timestamppb.New(ts),
+		Route:     RouteIdentity.ToProto(),
+	})
+	a.NoError(err)
+	return &pb.SignedTransport{Metadata: md, Signature: sig}
+}
+
+func TestIntroGuard_CheckFresh(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		maxAge time.Duration
+		ts     time.Time
+		err    error
+	}{
+		{"fresh", time.Minute, now.Add(-30 * time.Second), nil},
+		{"stale", time.Minute, now.Add(-2 * time.Minute), ErrStaleIntroduction},
+		{"future", time.Minute, now.Add(2 * time.Minute), ErrStaleIntroduction},
+		{"disabled", 0, now.Add(-time.Hour), nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			g := newIntroGuard(
+				clock.NewFake(now), tc.maxAge, introReplayCacheSize,
+			)
+			err := g.checkFresh(introAt(t, tc.ts, nil))
+			if tc.err != nil {
+				a.ErrorIs(err, tc.err)
+			} else {
+				a.NoError(err)
+			}
+		})
+	}
+}
+
+func TestIntroGuard_CheckReplay(t *testing.T) {
+	a := require.New(t)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	g := newIntroGuard(c, time.Minute, introReplayCacheSize)
+
+	st := introAt(t, now, []byte("signature"))
+	a.NoError(g.checkReplay(st))
+	a.ErrorIs(g.checkReplay(st), ErrReplayedIntroduction)
+	a.NoError(g.checkReplay(introAt(t, now, []byte("other"))))
+
+	// Once expired, the timestamp check alone rejects the frame, so the
+	// cache entry may be reused.
+	c.Advance(3 * time.Minute)
+	a.NoError(g.checkReplay(st))
+}
+
+func TestIntroGuard_Capacity(t *testing.T) {
+	a := require.New(t)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	g := newIntroGuard(c, time.Minute, 2)
+	first := introAt(t, now, []byte("first"))
+	second := introAt(t, now, []byte("second"))
+
+	a.NoError(g.checkReplay(first))
+	c.Advance(90 * time.Second)
+	a.NoError(g.checkReplay(second))
+
+	// The first entry has expired, so it is the one dropped to make room.
+	c.Advance(40 * time.Second)
+	a.NoError(g.checkReplay(introAt(t, now, []byte("third"))))
+	a.Len(g.seen, 2)
+	a.ErrorIs(g.checkReplay(second), ErrReplayedIntroduction)
+
+	// Without expired entries, the cache still stays within its capacity.
+	a.NoError(g.checkReplay(introAt(t, now, []byte("fourth"))))
+	a.Len(g.seen, 2)
+}
+
+func TestSendIntroduction_Nonce(t *testing.T) {
+	a := require.New(t)
+	at, err := attest.New()
+	a.NoError(err)
+
+	// Identical introductions must still carry distinct signatures, or the
+	// peer's replay cache would reject a legitimate reconnect.
+	var sigs [][]byte
+	for range 2 {
+		c1, c2 := net.Pipe()
+		conn1, conn2 := newConn(c1), newConn(c2)
+		sendErr := make(chan error, 1)
+		go func() {
+			sendErr <- sendIntroduction(
+				conn1, at, "peer", "1.0.0", introFields{},
+			)
+		}()
+		st, err := readSignedTransport(conn2)
+		a.NoError(err)
+		a.NoError(<-sendErr)
+		a.NoError(conn1.Close())
+		a.NoError(conn2.Close())
+
+		var md pb.Metadata
+		a.NoError(proto.Unmarshal(st.GetMetadata(), &md))
+		a.NotEmpty(md.GetID())
+		sigs = append(sigs, st.GetSignature())
+	}
+	a.NotEqual(sigs[0], sigs[1])
+}
+
+func TestServeWithIntroductionReplayCacheSize(t *testing.T) {
+	a := require.New(t)
+	srv := &Server{}
+	a.Error(ServeWithIntroductionReplayCacheSize(0)(srv))
+	a.NoError(ServeWithIntroductionReplayCacheSize(16)(srv))
+	a.Equal(16, srv.introCacheSize)
+}
+
+func TestIntroduce_Metadata(t *testing.T) {
+	tests := []struct {
+		name string
+		md   map[string][]byte
+		err  error
+	}{
+		{"none", nil, nil},
+		{"claims", map[string][]byte{"tenant": []byte("acme")}, nil},
+		{
+			"too large",
+			map[string][]byte{"blob": make([]byte, introMetadataMaxSize)},
+			ErrIntroductionMetadataTooLarge,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			c1, c2 := net.Pipe()
+			conn1, conn2 := newConn(c1), newConn(c2)
+			defer func() {
+				a.NoError(conn1.Close())
+				a.NoError(conn2.Close())
+			}()
+			at, err := attest.New()
+			a.NoError(err)
+
+			sendErr := make(chan error, 1)
+			go func() {
+				sendErr <- sendIntroduction(
+					conn1, at, "peer", "1.0.0", introFields{metadata: tc.md},
+				)
+			}()
+			st, err := readSignedTransport(conn2)
+			a.NoError(err)
+			a.NoError(<-sendErr)
+
+			peer, _, err := receiveIntroduction(st)
+			if tc.err != nil {
+				a.ErrorIs(err, tc.err)
+				return
+			}
+			a.NoError(err)
+			a.Equal(len(tc.md), len(peer.Metadata))
+			for k, v := range tc.md {
+				a.Equal(v, peer.Metadata[k])
+			}
+		})
+	}
+}
+
+func TestDialService(t *testing.T) {
+	tests := []struct {
+		name     string
+		offered  []string
+		service  string
+		err      error
+		handlers int32
+	}{
+		{"plain dial", []string{"chat"}, "", nil, 1},
+		{"offered", []string{"chat", "file-drop"}, "file-drop", nil, 1},
+		{"not offered", []string{"chat"}, "bot", ErrServiceUnavailable, 0},
+		{"none offered", nil, "chat", ErrServiceUnavailable, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			a.NoError(err)
+
+			var handlers atomic.Int32
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			srv, err := NewServer(
+				"", func(t *Transport) error {
+					handlers.Add(1)
+					_, err := t.Send(
+						Bytes([]byte(t.Service())), RouteExchangeMessages,
+					)
+					return err
+				}, store, acceptAll,
+				ServeWithListener(&tcpListener{Listener: l}),
+				ServeWithServices(tc.offered...),
+			)
+			a.NoError(err)
+			go func() { _ = srv.ListenAndServe() }()
+			defer srv.Close()
+
+			clientStore, clientCleanup := newTestStore(t)
+			defer clientCleanup()
+			d, err := NewDialer("", clientStore, acceptAll)
+			a.NoError(err)
+			tr, err := d.DialService(l.Addr().String(), tc.service)
+			if tc.err != nil {
+				a.ErrorIs(err, tc.err)
+				a.Zero(handlers.Load())
+				return
+			}
+			a.NoError(err)
+			defer tr.Close()
+			a.Equal(tc.service, tr.Service())
+			a.Equal(tc.offered, tr.RemotePeer().Services)
+
+			reply := Bytes(nil)
+			_, err = tr.Receive(reply)
+			a.NoError(err)
+			a.Equal(tc.service, string(reply.Value), "server sees the service")
+			a.Equal(tc.handlers, handlers.Load())
+		})
+	}
+}
+
+func TestServeWithServices_RejectsEmptyName(t *testing.T) {
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	_, err := NewServer(
+		"", func(*Transport) error { return nil }, store, acceptAll,
+		ServeWithServices("chat", ""),
+	)
+	a.Error(err)
+}
@@ -0,0 +1,62 @@
+package kamune
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// MessageHash returns the digest under which an outgoing message is recorded
+// in the session's journal; see [ServeWithJournalEnabled]. Applications use it
+// to match [storage.JournalEntry] records against their own outbox.
+func MessageHash(msg Transferable) ([]byte, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling message: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// journalMessage records the state of an outgoing application message in the
+// session's journal, if journaling is enabled. Control messages are not
+// journaled, and neither are wipes, which would outlive the journal they
+// wiped, nor ephemeral messages. Failing to journal does not fail the send.
+func (t *Transport) journalMessage(
+	md *Metadata, req *sendRequest, state storage.MessageState,
+) {
+	if !t.journal || t.store == nil || req.ephemeral {
+		return
+	}
+	if priorityForRoute(req.route) == PriorityControl ||
+		isWipeRoute(req.route) {
+		return
+	}
+	if req.hash == nil {
+		hash, err := MessageHash(req.message)
+		if err != nil {
+			slog.Warn("hashing journaled message", slog.Any("error", err))
+			return
+		}
+		req.hash = hash
+	}
+	err := t.store.JournalMessage(t.sessionID, storage.JournalEntry{
+		ID:       md.ID(),
+		Hash:     req.hash,
+		Sequence: md.SequenceNum(),
+		Route:    int32(req.route),
+		State:    state,
+	})
+	if err != nil {
+		slog.Warn(
+			"journaling message",
+			slog.String("session_id", t.sessionID),
+			slog.String("state", state.String()),
+			slog.Any("error", err),
+		)
+	}
+}
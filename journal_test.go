@@ -0,0 +1,68 @@
+package kamune
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+func TestTransport_Journal(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "enabled", enabled: true},
+		{name: "disabled"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			addr, _, _ := startEchoServer(t)
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			d, err := NewDialer(
+				addr, store, storePeer, DialWithJournalEnabled(tc.enabled),
+			)
+			a.NoError(err)
+			tr, err := d.Dial()
+			a.NoError(err)
+			defer tr.Close()
+
+			var sent []*Metadata
+			for _, text := range []string{"first", "second"} {
+				md, err := tr.Send(Bytes([]byte(text)), RouteExchangeMessages)
+				a.NoError(err)
+				sent = append(sent, md)
+				_, err = tr.Receive(Bytes(nil))
+				a.NoError(err)
+			}
+			_, err = tr.Send(Bytes(nil), RoutePing)
+			a.NoError(err)
+
+			entries, err := store.UnconfirmedMessages(tr.SessionID())
+			a.NoError(err)
+			if !tc.enabled {
+				a.Empty(entries)
+				return
+			}
+			a.Len(entries, 2, "control messages are not journaled")
+			for i, e := range entries {
+				a.Equal(sent[i].ID(), e.ID)
+				a.Equal(sent[i].SequenceNum(), e.Sequence)
+				a.Equal(int32(RouteExchangeMessages), e.Route)
+				a.Equal(storage.MessageSent, e.State)
+			}
+			hash, err := MessageHash(Bytes([]byte("first")))
+			a.NoError(err)
+			a.Equal(hash, entries[0].Hash)
+
+			a.NoError(store.AckMessage(tr.SessionID(), sent[0].ID()))
+			entries, err = store.UnconfirmedMessages(tr.SessionID())
+			a.NoError(err)
+			a.Len(entries, 1)
+			a.Equal(sent[1].ID(), entries[0].ID)
+		})
+	}
+}
@@ -38,6 +38,7 @@ const (
 	handshakeInfo    = "kamune/handshake/v1"
 	handshakeC2SInfo = "kamune/handshake/client-to-server/v1/"
 	handshakeS2CInfo = "kamune/handshake/server-to-client/v1/"
+	negotiationInfo  = "kamune/negotiation/v1"
 
 	// Handshake constants.
 	handshakeSaltSize      = 16
@@ -54,6 +55,51 @@ const (
 	resumptionGracePeriod = 24 * time.Hour
 	resumptionTokenCount  = 20
 	resumptionTokenSize   = 32
+
+	// Adoption domain separation label and the smallest secret accepted.
+	adoptInfo          = "kamune/adopt/v1"
+	adoptSecretMinSize = 32
+
+	// introReplayCacheSize caps the number of introduction signatures the
+	// server remembers for replay detection.
+	introReplayCacheSize = 1 << 16
+	// Upper bound on the combined size of introduction metadata keys and
+	// values. The introduction is sent before key agreement, so it must stay
+	// well within a single frame.
+	introMetadataMaxSize = 4 * 1024
+	// Upper bound on the number of identity claims in an introduction, for
+	// the same reason.
+	claimChainMaxLength = 4
+
+	// Deterministic padding domain separation labels and the smallest seed
+	// accepted; see [ServeWithPaddingSeed].
+	paddingC2SInfo     = "kamune/padding/client-to-server/v1/"
+	paddingS2CInfo     = "kamune/padding/server-to-client/v1/"
+	paddingSeedMinSize = 16
+
+	// Migration domain separation labels.
+	migrationKeyInfo     = "kamune/migration/v1"
+	migrationRequestInfo = "kamune/migration/request/v1"
+	migrationAcceptInfo  = "kamune/migration/accept/v1"
+	migrationBindingInfo = "kamune/migration/binding/v1"
+
+	// Channel binding domain separation label; see
+	// [Transport.ChannelBinding].
+	channelBindingInfo = "kamune/channel-binding/v1"
+
+	// Migration constants. A migration request is accepted for
+	// migrationWindow either side of the responder's clock, and its proofs
+	// are bound to the channel it is sent over by migrationBindingSize bytes
+	// exported from it.
+	migrationNonceSize   = 16
+	migrationBindingSize = 32
+	migrationWindow      = 2 * time.Minute
+
+	// Note-to-self session domain separation label; see [NoteToSelf].
+	selfSessionInfo = "kamune/self/v1"
+
+	// traceDefaultSize is the number of events a [Tracer] keeps by default.
+	traceDefaultSize = 1024
 )
 
 // Bucket sizes for the bucketed padding scheme (pre-encryption target sizes in
@@ -75,7 +121,11 @@ var bumpProbabilities = []int{80, 15, 4, 1}
 
 type (
 	RemoteVerifier func(store *storage.Storage, peer *storage.Peer) error
-	HandlerFunc    func(t *Transport) error
+	// HandlerFunc runs a session of a [Server] in the blocking style: the
+	// session lasts as long as the call, and the connection is closed when
+	// it returns, so it must keep receiving from t until it is done with the
+	// session. Use [Managed] to be called back with messages instead.
+	HandlerFunc func(t *Transport) error
 )
 
 // Transferable is the interface for messages that can be sent over a transport.
@@ -91,6 +141,12 @@ func Bytes(b []byte) *wrapperspb.BytesValue {
 // Metadata contains metadata about a received message.
 type Metadata struct {
 	pb *pb.Metadata
+	// raw and signature are the encoded metadata and the signature of the
+	// message, and message its encoded content, as signed; see
+	// [Metadata.Signature].
+	raw       []byte
+	message   []byte
+	signature []byte
 }
 
 // ID returns the unique message ID.
@@ -99,8 +155,24 @@ func (m Metadata) ID() string { return m.pb.GetID() }
 // Timestamp returns the time the message was sent.
 func (m Metadata) Timestamp() time.Time { return m.pb.Timestamp.AsTime() }
 
+// HybridTime returns the sender's hybrid logical clock reading for the
+// message. Unlike [Metadata.Timestamp], it orders messages consistently across
+// peers with skewed clocks. It is zero for messages from peers that predate
+// it.
+func (m Metadata) HybridTime() HybridTime {
+	return HybridTime(m.pb.GetClock())
+}
+
 // SequenceNum returns the per-message sequence number.
 func (m Metadata) SequenceNum() uint64 { return m.pb.GetSequence() }
 
 // Route returns the route associated with this message.
 func (m Metadata) Route() Route { return RouteFromProto(m.pb.GetRoute()) }
+
+// Ephemeral reports whether the sender asked for the message not to be
+// persisted; see [Transport.SendEphemeral].
+func (m Metadata) Ephemeral() bool { return m.pb.GetEphemeral() }
+
+// AckRequested reports whether the sender asked for the message to be
+// acknowledged; see [Transport.SendWithAck].
+func (m Metadata) AckRequested() bool { return m.pb.GetAckRequested() }
@@ -0,0 +1,151 @@
+package kamune
+
+import (
+	"time"
+)
+
+const (
+	// kcpLossyRate is the share of retransmitted segments above which the
+	// auto-tuner treats a link as lossy and resends eagerly.
+	kcpLossyRate = 0.05
+	// kcpCleanRate is the share of retransmitted segments below which a link
+	// is clean enough to be tuned for throughput.
+	kcpCleanRate = 0.01
+	// kcpLongRTT is the smoothed round-trip time from which a clean link is
+	// tuned for throughput, since its windows must cover more data in flight.
+	kcpLongRTT = 150 * time.Millisecond
+	// kcpMinSegments is the number of segments a tuning interval must have
+	// sent for its retransmissions to say anything about the link.
+	kcpMinSegments = 32
+	// defaultKCPTuneInterval is how often the auto-tuner looks at a link.
+	defaultKCPTuneInterval = 5 * time.Second
+)
+
+// KCPProfile is a set of KCP parameters applied to UDP connections; see
+// [ConnWithKCPProfile]. See the KCP documentation for the meaning of each.
+type KCPProfile struct {
+	// Name identifies the profile in [KCPStats].
+	Name string
+	// NoDelay turns off the minimum retransmission timeout and Interval is
+	// how often, in milliseconds, KCP flushes its queues.
+	NoDelay  bool
+	Interval int
+	// Resend is the number of acknowledgements that skip a segment before it
+	// is retransmitted without waiting for its timeout; zero disables fast
+	// retransmission.
+	Resend int
+	// NoCongestion turns off congestion control.
+	NoCongestion bool
+	// SendWindow and ReceiveWindow are the window sizes, in segments.
+	SendWindow    int
+	ReceiveWindow int
+}
+
+var (
+	// KCPLatency retransmits early and flushes often without congestion
+	// control. It suits interactive sessions and lossy links, at the cost of
+	// more bandwidth.
+	KCPLatency = KCPProfile{
+		Name:          "latency",
+		NoDelay:       true,
+		Interval:      10,
+		Resend:        2,
+		NoCongestion:  true,
+		SendWindow:    128,
+		ReceiveWindow: 128,
+	}
+	// KCPBalanced retransmits early but keeps congestion control.
+	KCPBalanced = KCPProfile{
+		Name:          "balanced",
+		NoDelay:       true,
+		Interval:      20,
+		Resend:        2,
+		SendWindow:    256,
+		ReceiveWindow: 256,
+	}
+	// KCPThroughput uses large windows and KCP's conservative timers. It
+	// suits bulk transfers over clean links with a long round trip.
+	KCPThroughput = KCPProfile{
+		Name:          "throughput",
+		Interval:      40,
+		SendWindow:    1024,
+		ReceiveWindow: 1024,
+	}
+)
+
+// KCPStats describes the KCP layer under a UDP connection; see
+// [TransportStats].
+//
+// kcp-go only counts segments per process, so Segments, Retransmitted,
+// FastRetransmitted, and Lost cover every KCP connection in the process
+// since this one was opened. With a single connection they are its own.
+type KCPStats struct {
+	// Profile is the name of the profile in use, or empty if none was set.
+	Profile string
+	// Retunes is the number of times the auto-tuner switched profiles; see
+	// [ConnWithKCPAutoTune].
+	Retunes uint64
+	// SRTT and RTTVar are KCP's smoothed round-trip time and its variance,
+	// and RTO the current retransmission timeout.
+	SRTT   time.Duration
+	RTTVar time.Duration
+	RTO    time.Duration
+	// Segments is the number of segments sent, and Retransmitted how many of
+	// them were sent again, FastRetransmitted of those after being skipped
+	// by acknowledgements rather than timing out. Lost is the number of
+	// segments that timed out.
+	Segments          uint64
+	Retransmitted     uint64
+	FastRetransmitted uint64
+	Lost              uint64
+}
+
+// RetransmitRate returns the share of sent segments that were retransmitted,
+// or zero if none were sent.
+func (s KCPStats) RetransmitRate() float64 {
+	if s.Segments == 0 {
+		return 0
+	}
+	return float64(s.Retransmitted) / float64(s.Segments)
+}
+
+// ConnWithKCPProfile applies profile to UDP connections. It has no effect on
+// other connections. Both ends should use compatible windows, since each
+// side's send window is bounded by the other's receive window.
+func ConnWithKCPProfile(profile KCPProfile) ConnOption {
+	return func(conn *conn) { conn.kcpProfile = &profile }
+}
+
+// ConnWithKCPAutoTune looks at UDP connections every interval, or every five
+// seconds if interval is not positive, and switches between [KCPLatency],
+// [KCPBalanced], and [KCPThroughput] as the link's round-trip time and
+// retransmissions change. Lossy links get the latency profile, clean links
+// with a long round trip the throughput one, and the rest the balanced one,
+// which is also the first applied unless [ConnWithKCPProfile] names another.
+// It has no effect on other connections.
+func ConnWithKCPAutoTune(interval time.Duration) ConnOption {
+	return func(conn *conn) {
+		if interval <= 0 {
+			interval = defaultKCPTuneInterval
+		}
+		conn.kcpTune = interval
+	}
+}
+
+// chooseKCPProfile picks the profile for a link with the given smoothed
+// round-trip time that sent segments and retransmitted some of them over the
+// last interval. It returns nil if too few were sent to tell.
+func chooseKCPProfile(srtt time.Duration, sent, resent uint64) *KCPProfile {
+	if sent < kcpMinSegments {
+		return nil
+	}
+	rate := float64(resent) / float64(sent)
+	switch {
+	case rate >= kcpLossyRate:
+		return &KCPLatency
+	case rate < kcpCleanRate && srtt >= kcpLongRTT:
+		return &KCPThroughput
+	default:
+		return &KCPBalanced
+	}
+}
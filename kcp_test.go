@@ -0,0 +1,31 @@
+package kamune
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChooseKCPProfile(t *testing.T) {
+	tests := []struct {
+		name   string
+		srtt   time.Duration
+		sent   uint64
+		resent uint64
+		want   *KCPProfile
+	}{
+		{"too few segments", time.Second, kcpMinSegments - 1, 10, nil},
+		{"lossy", 10 * time.Millisecond, 100, 5, &KCPLatency},
+		{"lossy and far", time.Second, 100, 20, &KCPLatency},
+		{"clean and far", 200 * time.Millisecond, 1000, 0, &KCPThroughput},
+		{"clean and near", 10 * time.Millisecond, 1000, 0, &KCPBalanced},
+		{"some loss and far", 200 * time.Millisecond, 100, 2, &KCPBalanced},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := require.New(t)
+			a.Equal(tt.want, chooseKCPProfile(tt.srtt, tt.sent, tt.resent))
+		})
+	}
+}
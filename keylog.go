@@ -0,0 +1,62 @@
+package kamune
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/kamune-org/kamune/internal/enigma"
+)
+
+// Key log labels; see §7.7 of the specification.
+const (
+	keyLogClientLabel = "CLIENT_TRAFFIC_KEY"
+	keyLogServerLabel = "SERVER_TRAFFIC_KEY"
+)
+
+// keyLog writes the traffic keys of every session it sees, one line per key,
+// so that captured traffic can be decrypted offline. It is shared by all the
+// handshakes of a server or dialer.
+type keyLog struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// write derives the traffic keys of a session from the same inputs as its
+// ciphers and appends them to the log. A nil keyLog writes nothing.
+func (l *keyLog) write(sessionID string, secret, c2sSalt, s2cSalt []byte) {
+	if l == nil {
+		return
+	}
+	c2s, err := enigma.Derive(
+		secret, c2sSalt, []byte(handshakeC2SInfo+sessionID), enigma.KeySize,
+	)
+	if err != nil {
+		return
+	}
+	s2c, err := enigma.Derive(
+		secret, s2cSalt, []byte(handshakeS2CInfo+sessionID), enigma.KeySize,
+	)
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = fmt.Fprintf(
+		l.w, "%s %s %x\n%s %s %x\n",
+		keyLogClientLabel, sessionID, c2s,
+		keyLogServerLabel, sessionID, s2c,
+	)
+	if err != nil {
+		slog.Warn("failed to write key log", slog.Any("error", err))
+	}
+}
+
+// newKeyLog returns a key log writing to w, warning loudly that session
+// secrets are being exported.
+func newKeyLog(w io.Writer) *keyLog {
+	slog.Warn("key logging is enabled; session traffic keys will be exported")
+	return &keyLog{w: w}
+}
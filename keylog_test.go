@@ -0,0 +1,76 @@
+package kamune
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// lockedBuffer is a bytes.Buffer that is safe to write from the server's
+// goroutines while the test reads it.
+type lockedBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestKeyLog(t *testing.T) {
+	a := require.New(t)
+	var serverLog, dialerLog lockedBuffer
+	addr, _, _ := startEchoServer(t, ServeWithKeyLog(&serverLog))
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, acceptAll, DialWithKeyLog(&dialerLog))
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+
+	lines := strings.Split(strings.TrimSpace(dialerLog.String()), "\n")
+	a.Len(lines, 2)
+	a.Eventually(
+		func() bool { return serverLog.String() == dialerLog.String() },
+		time.Second, time.Millisecond, "both sides log the same keys",
+	)
+
+	keys := make(map[string][]byte)
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		a.Len(fields, 3)
+		a.Equal(tr.SessionID(), fields[1])
+		key, err := hex.DecodeString(fields[2])
+		a.NoError(err)
+		keys[fields[0]] = key
+	}
+
+	// The logged client key opens what the dialer sends.
+	aead, err := chacha20poly1305.NewX(keys[keyLogClientLabel])
+	a.NoError(err)
+	frame := tr.encoder.Encrypt([]byte("captured"))
+	nonce, ciphertext := frame[:aead.NonceSize()], frame[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	a.NoError(err)
+	a.Equal("captured", string(plaintext))
+
+	aead, err = chacha20poly1305.NewX(keys[keyLogServerLabel])
+	a.NoError(err)
+	_, err = aead.Open(nil, nonce, ciphertext, nil)
+	a.Error(err, "directions use distinct keys")
+}
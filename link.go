@@ -0,0 +1,154 @@
+package kamune
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/kamune-org/kamune/pkg/attest"
+)
+
+const (
+	// linkSigningContext separates session link signatures from every other
+	// signature made with the same key.
+	linkSigningContext = "kamune-session-link\x00"
+	// linkPrefix starts the text form of a [SessionLink].
+	linkPrefix = "kamune-link"
+)
+
+// SessionLink is a proof that two sessions, possibly with different servers,
+// were established by the same identity, for linking the accounts a peer
+// holds with services built on kamune. It names the two sessions and is
+// signed by the identity, but does not hold its public key: each service
+// verifies it with the key of the peer of its own session, using
+// [Transport.VerifyLink], so a link tells whoever holds it nothing beyond
+// the two session IDs. Two services that both accept a link know that their
+// peers are one and the same.
+type SessionLink struct {
+	// Sessions are the IDs of the linked sessions, in the order they were
+	// given to [SignSessionLink].
+	Sessions  [2]string
+	Signature []byte
+}
+
+// SignSessionLink returns the proof, signed by at, that the sessions first
+// and second were both established by at. It does not check that they were.
+func SignSessionLink(
+	at attest.Attester, first, second string,
+) (SessionLink, error) {
+	l := SessionLink{Sessions: [2]string{first, second}}
+	if err := l.validate(); err != nil {
+		return SessionLink{}, err
+	}
+	sig, err := at.Sign(l.signed())
+	if err != nil {
+		return SessionLink{}, fmt.Errorf("signing session link: %w", err)
+	}
+	l.Signature = sig
+	return l, nil
+}
+
+// ProveLink returns the proof that t and the session sessionID, established
+// elsewhere with the same identity, belong together; see [SessionLink].
+func (t *Transport) ProveLink(sessionID string) (SessionLink, error) {
+	return SignSessionLink(t.serde.attest, t.sessionID, sessionID)
+}
+
+// VerifyLink checks that l names t's session and was signed by its peer, and
+// returns the ID of the session l links it to. It fails with
+// [ErrInvalidSessionLink] if l does not name t's session, and with
+// [ErrVerificationFailed] if its signature is not the peer's.
+func (t *Transport) VerifyLink(l SessionLink) (string, error) {
+	var other string
+	switch t.sessionID {
+	case l.Sessions[0]:
+		other = l.Sessions[1]
+	case l.Sessions[1]:
+		other = l.Sessions[0]
+	default:
+		return "", fmt.Errorf(
+			"%w: not about this session", ErrInvalidSessionLink,
+		)
+	}
+	if err := VerifySessionLink(t.remotePeer.PublicKey, l); err != nil {
+		return "", err
+	}
+	return other, nil
+}
+
+// VerifySessionLink checks that l was signed by publicKey, such as when it is
+// shown to a third party that knows the key.
+func VerifySessionLink(publicKey []byte, l SessionLink) error {
+	if err := l.validate(); err != nil {
+		return err
+	}
+	if !attest.Verify(publicKey, l.signed(), l.Signature) {
+		return fmt.Errorf(
+			"%w: invalid session link signature", ErrVerificationFailed,
+		)
+	}
+	return nil
+}
+
+// String returns the text form of l, to be read by [ParseSessionLink].
+func (l SessionLink) String() string {
+	return strings.Join([]string{
+		linkPrefix,
+		l.Sessions[0],
+		l.Sessions[1],
+		base64.RawURLEncoding.EncodeToString(l.Signature),
+	}, " ")
+}
+
+// ParseSessionLink reads the text form of a session link, as returned by
+// [SessionLink.String]. It does not verify the link.
+func ParseSessionLink(s string) (SessionLink, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 4 || fields[0] != linkPrefix {
+		return SessionLink{}, fmt.Errorf(
+			"%w: malformed", ErrInvalidSessionLink,
+		)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(fields[3])
+	if err != nil {
+		return SessionLink{}, fmt.Errorf(
+			"%w: decoding signature", ErrInvalidSessionLink,
+		)
+	}
+	l := SessionLink{
+		Sessions:  [2]string{fields[1], fields[2]},
+		Signature: sig,
+	}
+	if err := l.validate(); err != nil {
+		return SessionLink{}, err
+	}
+	return l, nil
+}
+
+func (l SessionLink) validate() error {
+	for _, id := range l.Sessions {
+		if id == "" || strings.ContainsFunc(id, unicode.IsSpace) {
+			return fmt.Errorf(
+				"%w: invalid session ID %q", ErrInvalidSessionLink, id,
+			)
+		}
+	}
+	if l.Sessions[0] == l.Sessions[1] {
+		return fmt.Errorf(
+			"%w: a session cannot be linked to itself", ErrInvalidSessionLink,
+		)
+	}
+	return nil
+}
+
+// signed returns the message a link's signature covers.
+func (l SessionLink) signed() []byte {
+	b := []byte(linkSigningContext)
+	for _, id := range l.Sessions {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(id)))
+		b = append(b, id...)
+	}
+	return b
+}
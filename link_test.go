@@ -0,0 +1,145 @@
+package kamune
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/attest"
+)
+
+// startLinkServer runs a server that answers every session link it receives
+// with the session it links to, or with the error verifying it.
+func startLinkServer(t *testing.T) string {
+	t.Helper()
+	handler := func(t *Transport) error {
+		for {
+			msg := Bytes(nil)
+			if _, err := t.Receive(msg); err != nil {
+				return nil
+			}
+			reply := "error"
+			link, err := ParseSessionLink(string(msg.Value))
+			if err == nil {
+				reply, err = t.VerifyLink(link)
+			}
+			if err != nil {
+				reply = err.Error()
+			}
+			if _, err := t.Send(Bytes([]byte(reply)), RouteExchangeMessages); err != nil {
+				return err
+			}
+		}
+	}
+	_, addr, _ := startTestServer(t, handler, nil, acceptAll)
+	return addr
+}
+
+func TestSessionLink(t *testing.T) {
+	a := require.New(t)
+	addr1 := startLinkServer(t)
+	addr2 := startLinkServer(t)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d1, err := NewDialer(addr1, store, acceptAll)
+	a.NoError(err)
+	d2, err := d1.Clone(addr2)
+	a.NoError(err)
+	t1, err := d1.Dial()
+	a.NoError(err)
+	defer t1.Close()
+	t2, err := d2.Dial()
+	a.NoError(err)
+	defer t2.Close()
+
+	link, err := t1.ProveLink(t2.SessionID())
+	a.NoError(err)
+	parsed, err := ParseSessionLink(link.String())
+	a.NoError(err)
+	a.Equal(link, parsed)
+
+	// Each server learns the other session of its peer.
+	echoLink := func(tr *Transport, text string) string {
+		_, err := tr.Send(Bytes([]byte(text)), RouteExchangeMessages)
+		a.NoError(err)
+		reply := Bytes(nil)
+		_, err = tr.Receive(reply)
+		a.NoError(err)
+		return string(reply.Value)
+	}
+	a.Equal(t2.SessionID(), echoLink(t1, link.String()))
+	a.Equal(t1.SessionID(), echoLink(t2, link.String()))
+
+	// A link signed by another identity is refused.
+	other, err := attest.New()
+	a.NoError(err)
+	forged, err := SignSessionLink(other, t1.SessionID(), t2.SessionID())
+	a.NoError(err)
+	a.Contains(echoLink(t1, forged.String()), ErrVerificationFailed.Error())
+
+	// So is a link about other sessions.
+	unrelated, err := t1.ProveLink("elsewhere")
+	a.NoError(err)
+	unrelated.Sessions[0] = "somewhere"
+	a.Contains(
+		echoLink(t2, unrelated.String()), ErrInvalidSessionLink.Error(),
+	)
+}
+
+func TestSessionLinkInvalid(t *testing.T) {
+	a := require.New(t)
+	at, err := attest.New()
+	a.NoError(err)
+	link, err := SignSessionLink(at, "first", "second")
+	a.NoError(err)
+	a.NoError(VerifySessionLink(at.MarshalPublicKey(), link))
+
+	swapped := link
+	swapped.Sessions = [2]string{"second", "first"}
+
+	tests := []struct {
+		name string
+		link SessionLink
+		err  error
+	}{
+		{
+			name: "swapped sessions",
+			link: swapped,
+			err:  ErrVerificationFailed,
+		},
+		{
+			name: "same session",
+			link: SessionLink{Sessions: [2]string{"first", "first"}},
+			err:  ErrInvalidSessionLink,
+		},
+		{
+			name: "empty session",
+			link: SessionLink{Sessions: [2]string{"first", ""}},
+			err:  ErrInvalidSessionLink,
+		},
+		{
+			name: "spaced session",
+			link: SessionLink{Sessions: [2]string{"first", "a b"}},
+			err:  ErrInvalidSessionLink,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			err := VerifySessionLink(at.MarshalPublicKey(), tc.link)
+			a.ErrorIs(err, tc.err)
+		})
+	}
+
+	for _, s := range []string{
+		"",
+		"kamune-link first second",
+		"other-link first second AAAA",
+		"kamune-link first second !!!",
+		"kamune-link first first AAAA",
+	} {
+		_, err := ParseSessionLink(s)
+		a.ErrorIs(err, ErrInvalidSessionLink, s)
+	}
+}
@@ -0,0 +1,207 @@
+package kamune
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// minReconnectDelay and maxReconnectDelay bound the backoff between
+	// attempts of [Dialer.Run] to reconnect a lost session.
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+// Handlers are the callbacks of a managed session, whose receive loop is run
+// by the library rather than by the application: see [Managed] for servers
+// and [Dialer.Run] for dialers. They suit applications that react to
+// messages, which would otherwise have to keep a blocking [HandlerFunc] from
+// returning, as returning closes the connection. Every callback is optional,
+// and they are called one at a time for a session.
+type Handlers struct {
+	// OnConnect is called with the transport of a session once it is
+	// established, and again with the new transport each time it is resumed,
+	// before any of its messages are handed to OnMessage. Replies and other
+	// messages may be sent on the transport from any goroutine, until
+	// OnDisconnect is called for it.
+	OnConnect func(t *Transport)
+	// OnMessage is called with every message of the session, in the order
+	// they arrive. The next message is not read until it returns, so it
+	// should hand slow work off to another goroutine. Pings are answered
+	// without it. A message with [InboundMessage.Err] set to
+	// [ErrUnauthorizedRoute] reports a frame the session rejected, and the
+	// session carries on.
+	OnMessage func(m *InboundMessage)
+	// OnDisconnect is called when the transport of a session ends. err is nil
+	// if either side closed the session, and otherwise why the connection
+	// was lost; [Dialer.Run] then reconnects, which servers leave to their
+	// peers.
+	OnDisconnect func(t *Transport, err error)
+}
+
+// Managed returns the handler of a server whose sessions are managed: it
+// calls h.OnConnect, runs the session's receive loop, handing its messages
+// to h.OnMessage, and returns once the session ends, after calling
+// h.OnDisconnect. A session resumed by its peer runs the loop again, with
+// the same session ID. Pass it to [NewServer] in place of a blocking
+// handler.
+func Managed(h Handlers) HandlerFunc {
+	return func(t *Transport) error {
+		err := h.run(t)
+		if lost(t, err) {
+			return fmt.Errorf("receiving: %w", err)
+		}
+		return nil
+	}
+}
+
+// Run dials the server and manages the session with h, as [Managed] does
+// for servers, until ctx ends, either side closes the session, or the dialer
+// is shut down. When the connection is lost, Run resumes the session, as
+// [DialWithResume] does, or else runs a fresh handshake with a new session
+// ID, retrying with a growing delay until it reconnects; h.OnConnect is
+// called with every new transport. A session dropped by a draining server is
+// resumed at the address the server named; see [Server.Drain].
+//
+// Run fails right away if the first dial does. It returns nil once a side
+// has closed the session, and ctx's error if ctx ended first.
+func (d *Dialer) Run(ctx context.Context, h Handlers) error {
+	t, err := d.Dial()
+	if err != nil {
+		return err
+	}
+	for {
+		stop := context.AfterFunc(ctx, func() { _ = t.Close() })
+		err := h.run(t)
+		stop()
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case !lost(t, err):
+			return nil
+		}
+
+		slog.Info(
+			"managed session lost, reconnecting",
+			slog.String("session_id", t.SessionID()),
+			slog.Any("error", err),
+		)
+		if addr := t.ReconnectAddress(); addr != "" {
+			// The server drained and named the one taking over.
+			if d, err = d.Clone(addr); err != nil {
+				return err
+			}
+		}
+		if t, err = d.reconnect(ctx, t.SessionID()); err != nil {
+			return err
+		}
+	}
+}
+
+// reconnect resumes the session, or else dials a new one, until it succeeds,
+// ctx ends, or the dialer is shut down.
+func (d *Dialer) reconnect(
+	ctx context.Context, sessionID string,
+) (*Transport, error) {
+	delay := minReconnectDelay
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		rd := *d
+		rd.handshakeOpts.sessionID = sessionID
+		t, err := rd.Dial()
+		if err != nil && !errors.Is(err, ErrClosedDialer) {
+			slog.Debug(
+				"resumption failed, dialing a new session",
+				slog.String("session_id", sessionID),
+				slog.Any("error", err),
+			)
+			rd.handshakeOpts.sessionID = ""
+			t, err = rd.Dial()
+		}
+		switch {
+		case err == nil:
+			return t, nil
+		case errors.Is(err, ErrClosedDialer):
+			return nil, err
+		}
+
+		slog.Warn(
+			"reconnecting managed session failed",
+			slog.String("session_id", sessionID),
+			slog.Duration("retry_in", delay),
+			slog.Any("error", err),
+		)
+		timer.Reset(delay)
+		delay = min(2*delay, maxReconnectDelay)
+	}
+}
+
+// run calls h's callbacks for t until its session ends, and returns the
+// error that ended it.
+func (h Handlers) run(t *Transport) (err error) {
+	if h.OnConnect != nil {
+		h.OnConnect(t)
+	}
+	defer func() {
+		if h.OnDisconnect == nil {
+			return
+		}
+		if lost(t, err) {
+			h.OnDisconnect(t, err)
+		} else {
+			h.OnDisconnect(t, nil)
+		}
+	}()
+
+	for {
+		md, msg, err := t.receive()
+		switch {
+		case err == nil: // continue
+		case errors.Is(err, ErrUnauthorizedRoute):
+			if h.OnMessage != nil {
+				h.OnMessage(&InboundMessage{Transport: t, Err: err})
+			}
+			continue
+		default:
+			return err
+		}
+
+		if md.Route() == RoutePing {
+			token := Bytes(nil)
+			if err := t.unmarshal(msg, token); err != nil {
+				return err
+			}
+			if _, err := t.Send(token, RoutePong); err != nil {
+				return fmt.Errorf("answering ping: %w", err)
+			}
+			continue
+		}
+		if h.OnMessage != nil {
+			h.OnMessage(&InboundMessage{Transport: t, Metadata: md, data: msg})
+		}
+	}
+}
+
+// lost reports whether err ended t's session without either side closing
+// it, such that it may be resumed.
+func lost(t *Transport, err error) bool {
+	switch {
+	case err == nil, errors.Is(err, ErrPeerDisconnected):
+		return false
+	case errors.Is(err, ErrConnClosed):
+		// A dropped connection reads as closed too.
+		return !t.closed.Load()
+	default:
+		return true
+	}
+}
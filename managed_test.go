@@ -0,0 +1,150 @@
+package kamune
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startManagedServer runs a server managing its sessions with h on a loopback
+// TCP listener, recording its sessions so that they can be resumed.
+func startManagedServer(t *testing.T, h Handlers) string {
+	t.Helper()
+	_, addr, _ := startTestServer(t, Managed(h), nil, storePeer)
+	return addr
+}
+
+func TestManaged(t *testing.T) {
+	a := require.New(t)
+
+	serverConnects := make(chan string, 4)
+	serverDisconnects := make(chan error, 4)
+	addr := startManagedServer(t, Handlers{
+		OnConnect: func(t *Transport) { serverConnects <- t.SessionID() },
+		OnMessage: func(m *InboundMessage) {
+			msg := Bytes(nil)
+			if m.Decode(msg) != nil {
+				return
+			}
+			switch string(msg.Value) {
+			case "drop":
+				// Lose the connection without closing the session.
+				_ = m.Transport.currentConn().Close()
+			case "close":
+				_ = m.Transport.Close()
+			default:
+				_, _ = m.Transport.Send(msg, RouteExchangeMessages)
+			}
+		},
+		OnDisconnect: func(_ *Transport, err error) {
+			serverDisconnects <- err
+		},
+	})
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, storePeer)
+	a.NoError(err)
+
+	connects := make(chan *Transport, 4)
+	replies := make(chan string, 4)
+	disconnects := make(chan error, 4)
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Run(context.Background(), Handlers{
+			OnConnect: func(t *Transport) { connects <- t },
+			OnMessage: func(m *InboundMessage) {
+				msg := Bytes(nil)
+				if m.Decode(msg) == nil {
+					replies <- string(msg.Value)
+				}
+			},
+			OnDisconnect: func(_ *Transport, err error) {
+				disconnects <- err
+			},
+		})
+	}()
+
+	send := func(tr *Transport, text string) {
+		_, err := tr.Send(Bytes([]byte(text)), RouteExchangeMessages)
+		a.NoError(err)
+	}
+	receive := func() string {
+		select {
+		case r := <-replies:
+			return r
+		case <-time.After(5 * time.Second):
+			a.Fail("no reply")
+			return ""
+		}
+	}
+
+	first := <-connects
+	a.Equal(first.SessionID(), <-serverConnects)
+	send(first, "hello")
+	a.Equal("hello", receive())
+
+	// A lost connection is resumed, on both ends.
+	send(first, "drop")
+	a.Error(<-disconnects)
+	a.Error(<-serverDisconnects)
+	var resumed *Transport
+	select {
+	case resumed = <-connects:
+	case <-time.After(5 * time.Second):
+		a.FailNow("session was not resumed")
+	}
+	a.NotSame(first, resumed)
+	a.Equal(first.SessionID(), resumed.SessionID())
+	a.Equal(first.SessionID(), <-serverConnects)
+	send(resumed, "again")
+	a.Equal("again", receive())
+
+	// A session closed by the peer is not.
+	send(resumed, "close")
+	a.NoError(<-serverDisconnects)
+	a.NoError(<-disconnects)
+	select {
+	case err := <-done:
+		a.NoError(err)
+	case <-time.After(5 * time.Second):
+		a.FailNow("Run did not return")
+	}
+	a.Empty(connects)
+}
+
+func TestDialerRun_Cancel(t *testing.T) {
+	a := require.New(t)
+	addr := startManagedServer(t, Handlers{})
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, storePeer)
+	a.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	connected := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Run(ctx, Handlers{
+			OnConnect: func(*Transport) { close(connected) },
+		})
+	}()
+	<-connected
+	cancel()
+	a.ErrorIs(<-done, context.Canceled)
+
+	// The first dial is not retried.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	a.NoError(l.Close())
+	unreachable, err := NewDialer(
+		l.Addr().String(), store, storePeer,
+		DialWithDialTimeout(time.Second),
+	)
+	a.NoError(err)
+	a.Error(unreachable.Run(context.Background(), Handlers{}))
+}
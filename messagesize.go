@@ -0,0 +1,125 @@
+package kamune
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// capabilityMaxMessagePrefix starts the capability advertising the
+	// largest message the sender accepts, as in "max-message/4096".
+	capabilityMaxMessagePrefix = "max-message/"
+
+	// minMessageSizeLimit is the smallest message size limit a peer may
+	// advertise, so that control messages and transfer chunks still fit.
+	minMessageSizeLimit = 1024
+
+	// transferChunkOverhead is the room left for the fields of a transfer
+	// chunk besides its data when chunks are sized to the peer's limit.
+	transferChunkOverhead = 128
+)
+
+// MessageTooLargeError is returned by [Transport.Send] and the methods built
+// on it when a message exceeds the largest one the peer accepts, without
+// anything being sent. It matches [ErrMessageTooLarge] with [errors.Is].
+type MessageTooLargeError struct {
+	// Size is the size of the serialized message, and Limit the most the
+	// peer accepts; see [Transport.MaxMessageSize].
+	Size  int
+	Limit int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf(
+		"%s: %d bytes, peer accepts at most %d",
+		ErrMessageTooLarge, e.Size, e.Limit,
+	)
+}
+
+func (e *MessageTooLargeError) Unwrap() error { return ErrMessageTooLarge }
+
+// setMaxMessageSize replaces the message size limit advertised in caps with
+// size, or removes it if size is zero.
+func setMaxMessageSize(caps []string, size int) ([]string, error) {
+	if size != 0 && (size < minMessageSizeLimit || size > maxTransportSize) {
+		return nil, fmt.Errorf(
+			"max message size must be between %d and %d bytes",
+			minMessageSizeLimit, maxTransportSize,
+		)
+	}
+	caps = slices.DeleteFunc(slices.Clone(caps), func(c string) bool {
+		return strings.HasPrefix(c, capabilityMaxMessagePrefix)
+	})
+	if size != 0 {
+		caps = append(caps, capabilityMaxMessagePrefix+strconv.Itoa(size))
+	}
+	return caps, nil
+}
+
+// maxMessageSize returns the message size limit advertised in caps, or zero
+// if there is none or it is out of range.
+func maxMessageSize(caps []string) int {
+	for _, c := range caps {
+		v, ok := strings.CutPrefix(c, capabilityMaxMessagePrefix)
+		if !ok {
+			continue
+		}
+		size, err := strconv.Atoi(v)
+		if err != nil || size < minMessageSizeLimit ||
+			size > maxTransportSize {
+			return 0
+		}
+		return size
+	}
+	return 0
+}
+
+// frameLimit returns the largest padded frame, before encryption, that
+// carries a message of up to limit bytes: the padding bucket the largest such
+// message falls in. Senders do not pad past it, so that receivers can turn
+// away larger frames without decrypting them. A zero limit allows any frame.
+func frameLimit(limit int) int {
+	if limit == 0 {
+		return frameTargetSize
+	}
+	return paddingBuckets[naturalBucketIndex(limit+reservedProtocolOverhead)]
+}
+
+// MaxMessageSize returns the size of the largest serialized message the peer
+// accepts: the limit it advertised with [ServeWithMaxMessageSize] or
+// [DialWithMaxMessageSize], or the protocol's limit if it advertised none.
+func (t *Transport) MaxMessageSize() int {
+	if t.serde.limit == 0 {
+		return maxTransportSize
+	}
+	return t.serde.limit
+}
+
+// checkFrameSize turns away a frame too large to carry a message within the
+// local limit before it is decrypted, and checkMessageSize a message over
+// the limit once it is.
+func (t *Transport) checkFrameSize(payload []byte) error {
+	if t.receiveLimit == 0 {
+		return nil
+	}
+	limit := frameLimit(t.receiveLimit) + encryptionOverhead
+	if len(payload) > limit {
+		return fmt.Errorf(
+			"%w: received frame of %d bytes, limit is %d",
+			ErrMessageTooLarge, len(payload), limit,
+		)
+	}
+	return nil
+}
+
+func (t *Transport) checkMessageSize(msg []byte) error {
+	if t.receiveLimit != 0 && len(msg) > t.receiveLimit {
+		return fmt.Errorf(
+			"%w: received message of %d bytes, limit is %d",
+			ErrMessageTooLarge, len(msg), t.receiveLimit,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package kamune
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxMessageSize_Negotiated(t *testing.T) {
+	a := require.New(t)
+	addr := startSessionServer(t, ServeWithMaxMessageSize(2048))
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	d, err := NewDialer(addr, store, storePeer, DialWithMaxMessageSize(4096))
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	a.Equal(2048, tr.MaxMessageSize())
+
+	_, err = tr.Send(Bytes(make([]byte, 3000)), RouteExchangeMessages)
+	a.ErrorIs(err, ErrMessageTooLarge)
+	tooLarge, ok := errors.AsType[*MessageTooLargeError](err)
+	a.True(ok)
+	a.Equal(2048, tooLarge.Limit)
+	a.Greater(tooLarge.Size, 3000)
+	// Nothing was sent, so the session carries on.
+	echo(t, tr, "hello")
+	sessionID := tr.SessionID()
+	a.NoError(tr.Close())
+
+	// A resumed session keeps the limit the peer advertised.
+	d, err = NewDialer(addr, store, storePeer, DialWithResume(sessionID))
+	a.NoError(err)
+	tr, err = d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	a.Equal(2048, tr.MaxMessageSize())
+
+	d, err = NewDialer(
+		startSessionServer(t), store, storePeer, DialWithMaxMessageSize(4096),
+	)
+	a.NoError(err)
+	other, err := d.Dial()
+	a.NoError(err)
+	defer other.Close()
+	a.Equal(int(maxTransportSize), other.MaxMessageSize())
+}
+
+func TestTransport_ReceiveLimit(t *testing.T) {
+	a := require.New(t)
+	cn := &scriptedConn{}
+	tr := newLoopbackTransport(t, cn)
+	tr.receiveLimit = 2048
+
+	frame := func(seq uint64, size int) []byte {
+		payload, _, err := tr.serde.serialize(
+			Bytes(make([]byte, size)), RouteExchangeMessages, seq,
+		)
+		a.NoError(err)
+		return tr.encoder.Encrypt(payload)
+	}
+	cn.frames = [][]byte{
+		// Not decryptable, but turned away for its size before that.
+		bytes.Repeat([]byte{1}, frameLimit(2048)+encryptionOverhead+1),
+		frame(1, 3000),
+		frame(1, 100),
+	}
+
+	_, err := tr.Receive(Bytes(nil))
+	a.ErrorIs(err, ErrMessageTooLarge)
+	_, err = tr.Receive(Bytes(nil))
+	a.ErrorIs(err, ErrMessageTooLarge)
+	_, err = tr.Receive(Bytes(nil))
+	a.NoError(err)
+	a.Zero(tr.Stats().Undecryptable)
+}
+
+func TestMaxMessageSize_PaddingCapped(t *testing.T) {
+	a := require.New(t)
+	tr := newLoopbackTransport(t, &scriptedConn{})
+	tr.serde.limit = 2048
+
+	// Without the cap, one message in five would be bumped past the bucket.
+	for seq := range uint64(200) {
+		payload, _, err := tr.serde.serialize(
+			Bytes(make([]byte, 2000)), RouteExchangeMessages, seq+1,
+		)
+		a.NoError(err)
+		a.LessOrEqual(len(payload), frameLimit(2048))
+	}
+}
+
+func TestSetMaxMessageSize(t *testing.T) {
+	a := require.New(t)
+	caps, err := setMaxMessageSize([]string{capabilityDedup}, 4096)
+	a.NoError(err)
+	caps, err = setMaxMessageSize(caps, 2048)
+	a.NoError(err)
+	a.Equal([]string{capabilityDedup, "max-message/2048"}, caps)
+	a.Equal(2048, maxMessageSize(caps))
+	caps, err = setMaxMessageSize(caps, 0)
+	a.NoError(err)
+	a.Equal([]string{capabilityDedup}, caps)
+	a.Zero(maxMessageSize([]string{"max-message/12"}))
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	for _, size := range []int{
+		-1, minMessageSizeLimit - 1, maxTransportSize + 1,
+	} {
+		_, err := NewServer(
+			"", nil, store, acceptAll, ServeWithMaxMessageSize(size),
+		)
+		a.Error(err)
+		_, err = NewDialer(
+			"127.0.0.1:1", store, acceptAll, DialWithMaxMessageSize(size),
+		)
+		a.Error(err)
+	}
+}
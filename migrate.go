@@ -0,0 +1,265 @@
+package kamune
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/internal/clock"
+	"github.com/kamune-org/kamune/internal/enigma"
+	"github.com/kamune-org/kamune/pkg/attest"
+	"github.com/kamune-org/kamune/pkg/exchange"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// migrationProof returns a MAC over the session ID, binding, and nonce, keyed
+// by a secret derived from the session's resumption root. It proves
+// possession of the established session keys without running a new
+// handshake. The label separates the request and accept directions, and the
+// binding, exported from the channel the migration runs over, keeps the
+// proof from being replayed over another.
+func (t *Transport) migrationProof(
+	label string, binding, nonce []byte,
+) ([]byte, error) {
+	if t.resumptionRoot == nil {
+		return nil, fmt.Errorf("session is not established")
+	}
+	key, err := enigma.Derive(
+		t.resumptionRoot, nil, []byte(migrationKeyInfo), sha512.Size,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("deriving migration key: %w", err)
+	}
+	mac := hmac.New(sha512.New, key)
+	mac.Write([]byte(label))
+	mac.Write([]byte(t.sessionID))
+	mac.Write(binding)
+	mac.Write(nonce)
+	return mac.Sum(nil), nil
+}
+
+// migrationBinding returns the secret that binds the proofs of a migration
+// to ec, the channel it runs over.
+func migrationBinding(ec *exchange.Channel) ([]byte, error) {
+	b, err := ec.Export(migrationBindingInfo, migrationBindingSize)
+	if err != nil {
+		return nil, fmt.Errorf("exporting migration binding: %w", err)
+	}
+	return b, nil
+}
+
+// migrationGuard rejects stale and replayed migration requests on the accept
+// path. A request is fresh for migrationWindow either side of the local
+// clock, and its nonce is remembered for as long as it could be fresh.
+type migrationGuard struct {
+	clock clock.Clock
+	seen  map[string]time.Time
+	mu    sync.Mutex
+}
+
+func newMigrationGuard(c clock.Clock) *migrationGuard {
+	return &migrationGuard{clock: c, seen: make(map[string]time.Time)}
+}
+
+// checkFresh reports whether the timestamp of the request st lies within
+// migrationWindow of the local clock.
+func (g *migrationGuard) checkFresh(st *pb.SignedTransport) bool {
+	var md pb.Metadata
+	if err := proto.Unmarshal(st.GetMetadata(), &md); err != nil {
+		return false
+	}
+	if md.GetTimestamp() == nil {
+		return false
+	}
+	age := g.clock.Now().Sub(md.GetTimestamp().AsTime())
+	return age <= migrationWindow && age >= -migrationWindow
+}
+
+// checkReplay records nonce and reports whether it was not seen before. It
+// must only be called once the request's proof has been verified, so that
+// forged requests cannot fill the cache.
+func (g *migrationGuard) checkReplay(nonce []byte) bool {
+	now := g.clock.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for k, expiry := range g.seen {
+		if !now.Before(expiry) {
+			delete(g.seen, k)
+		}
+	}
+	if _, ok := g.seen[string(nonce)]; ok {
+		return false
+	}
+	g.seen[string(nonce)] = now.Add(2 * migrationWindow)
+	return true
+}
+
+// receivedMessages returns the number of application messages received in
+// the session, as counted for retransmission.
+func (t *Transport) receivedMessages() uint64 {
+	if t.retransmit == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.retransmit.received
+}
+
+// receivedSequence returns the sequence number of the last received message.
+func (t *Transport) receivedSequence() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.recvSequence
+}
+
+// migrate rebinds the session to cn and closes the previous connection. The
+// send sequence is realigned to peerSequence, the last sequence number the
+// peer reports having received, so messages lost in flight on the old path do
+// not leave the receiver waiting for a gap that will never be filled. A
+// session that retransmits sends those messages again with resend once the
+// peer knows of the migration; in others, they are lost.
+func (t *Transport) migrate(cn Conn, peerSequence uint64) {
+	t.mu.Lock()
+	old := t.conn
+	t.conn = cn
+	var lost uint64
+	if t.sendSequence > peerSequence {
+		lost = t.sendSequence - peerSequence
+		t.sendSequence = peerSequence
+	}
+	t.mu.Unlock()
+	if t.retransmit != nil {
+		lost = 0
+	}
+
+	if lost > 0 {
+		slog.Warn(
+			"messages lost during migration",
+			slog.String("session_id", t.sessionID),
+			slog.Uint64("count", lost),
+		)
+	}
+	_ = old.Close()
+
+	slog.Info("session migrated", slog.String("session_id", t.sessionID))
+	var detail string
+	if lost > 0 {
+		detail = fmt.Sprintf("%d messages lost", lost)
+	}
+	t.timelineEnded.Store(false)
+	t.recordTimeline(storage.TimelineMigrated, detail)
+}
+
+// beginMigration marks a migration of the session as in progress until the
+// returned function is called, so that a reader whose connection the peer
+// closes meanwhile waits to learn whether the session moved.
+func (t *Transport) beginMigration() (done func()) {
+	ch := make(chan struct{})
+	t.mu.Lock()
+	t.migrating = ch
+	t.mu.Unlock()
+	return func() {
+		t.mu.Lock()
+		if t.migrating == ch {
+			t.migrating = nil
+		}
+		t.mu.Unlock()
+		close(ch)
+	}
+}
+
+// awaitMigration waits for the migration in progress, if any, to finish.
+func (t *Transport) awaitMigration() {
+	t.mu.Lock()
+	ch := t.migrating
+	t.mu.Unlock()
+	if ch != nil {
+		<-ch
+	}
+}
+
+// sendSigned signs msg with the local identity and writes it to conn as a
+// padded SignedTransport on the given route. It is used for the pre-transport
+// control messages exchanged through the HPKE tunnel.
+func sendSigned(
+	conn Conn, at *attest.Attest, msg proto.Message, route Route,
+) error {
+	message, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshalling message: %w", err)
+	}
+
+	md := &pb.Metadata{
+		Route:     route.ToProto(),
+		Timestamp: timestamppb.Now(),
+	}
+	metadataBytes, err := proto.Marshal(md)
+	if err != nil {
+		return fmt.Errorf("marshalling metadata: %w", err)
+	}
+
+	sig, err := at.Sign(signingInput(metadataBytes, message))
+	if err != nil {
+		return fmt.Errorf("signing message: %w", err)
+	}
+
+	st := &pb.SignedTransport{
+		Data:      message,
+		Signature: sig,
+		Metadata:  metadataBytes,
+	}
+	payload, err := padSignedTransport(st)
+	if err != nil {
+		return fmt.Errorf("padding signed transport: %w", err)
+	}
+
+	if err := conn.WriteBytes(payload); err != nil {
+		return fmt.Errorf("writing: %w", err)
+	}
+
+	return nil
+}
+
+// verifySigned checks that st carries the expected route and a valid
+// signature from remote, then unmarshals its data into dst.
+func verifySigned(
+	st *pb.SignedTransport, remote []byte, route Route, dst proto.Message,
+) error {
+	r, err := routeFromST(st)
+	if err != nil {
+		return fmt.Errorf("extracting route: %w", err)
+	}
+	if r != route {
+		return fmt.Errorf(
+			"%w: expected %s, got %s", ErrUnexpectedRoute, route, r,
+		)
+	}
+
+	if !attest.Verify(
+		remote, signingInput(st.GetMetadata(), st.GetData()), st.GetSignature(),
+	) {
+		return ErrInvalidSignature
+	}
+
+	if err := proto.Unmarshal(st.GetData(), dst); err != nil {
+		return fmt.Errorf("deserializing: %w", err)
+	}
+	return nil
+}
+
+// rejectMigration sends a negative MigrateAccept with the given reason and
+// returns an error describing the rejection.
+func rejectMigration(conn Conn, at *attest.Attest, reason string) error {
+	resp := &pb.MigrateAccept{Reason: reason}
+	if err := sendSigned(conn, at, resp, RouteMigrateAccept); err != nil {
+		return fmt.Errorf("sending migrate accept: %w", err)
+	}
+	return fmt.Errorf("%w: %s", ErrMigrationRejected, reason)
+}
@@ -0,0 +1,288 @@
+package kamune
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/internal/clock"
+)
+
+// startEchoServer runs a server on a loopback TCP listener whose handler
+// echoes every message back on the same route. It returns the listening
+// address, the number of handler invocations, and a channel that receives
+// once per handler exit.
+func startEchoServer(
+	t *testing.T, opts ...ServerOptions,
+) (string, *atomic.Int32, <-chan struct{}) {
+	t.Helper()
+	var handlers atomic.Int32
+	exited := make(chan struct{}, 8)
+	handler := func(t *Transport) error {
+		handlers.Add(1)
+		defer func() { exited <- struct{}{} }()
+		for {
+			msg := Bytes(nil)
+			md, err := t.Receive(msg)
+			if err != nil {
+				return nil
+			}
+			if _, err := t.Send(msg, md.Route()); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, addr, _ := startTestServer(t, handler, nil, acceptAll, opts...)
+	return addr, &handlers, exited
+}
+
+func echo(t *testing.T, tr *Transport, text string) {
+	t.Helper()
+	a := require.New(t)
+	_, err := tr.Send(Bytes([]byte(text)), RouteExchangeMessages)
+	a.NoError(err)
+	reply := Bytes(nil)
+	_, err = tr.Receive(reply)
+	a.NoError(err)
+	a.Equal(text, string(reply.Value))
+}
+
+func TestMigrate_HappyPath(t *testing.T) {
+	a := require.New(t)
+	addr, handlers, _ := startEchoServer(t)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+
+	echo(t, tr, "before")
+	oldConn := tr.currentConn()
+
+	a.NoError(d.Migrate(tr))
+	a.NotEqual(oldConn, tr.currentConn())
+
+	echo(t, tr, "after")
+	echo(t, tr, "again")
+	a.EqualValues(1, handlers.Load(), "no new session was established")
+}
+
+func TestMigrate_ReceiveContinuesOnNewPath(t *testing.T) {
+	a := require.New(t)
+	addr, _, _ := startEchoServer(t)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+
+	// Block a receiver on the old connection before migrating.
+	received := make(chan string, 1)
+	go func() {
+		reply := Bytes(nil)
+		if _, err := tr.Receive(reply); err == nil {
+			received <- string(reply.Value)
+		}
+		close(received)
+	}()
+
+	a.NoError(d.Migrate(tr))
+	_, err = tr.Send(Bytes([]byte("ping")), RouteExchangeMessages)
+	a.NoError(err)
+	a.Equal("ping", <-received)
+}
+
+func TestMigrate_ResendsLostMessages(t *testing.T) {
+	a := require.New(t)
+	texts := []string{"1", "2", "3"}
+	addr, sent := startRetransmitServer(
+		t, texts, ServeWithRetransmitWindow(8),
+	)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, storePeer, DialWithRetransmitWindow(8))
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	<-sent
+
+	// The texts are left unread on the old path, which the migration closes.
+	a.NoError(d.Migrate(tr))
+	for _, text := range texts {
+		msg := Bytes(nil)
+		_, err := tr.Receive(msg)
+		a.NoError(err)
+		a.Equal(text, string(msg.GetValue()))
+	}
+	echo(t, tr, "done")
+}
+
+func TestMigrate_RejectedAfterSessionEnded(t *testing.T) {
+	a := require.New(t)
+	addr, _, exited := startEchoServer(t)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+
+	a.NoError(tr.Close())
+	<-exited
+
+	err = d.Migrate(tr)
+	a.ErrorIs(err, ErrMigrationRejected)
+}
+
+func TestMigrate_Disabled(t *testing.T) {
+	a := require.New(t)
+	addr, _, _ := startEchoServer(t, ServeWithMigrationEnabled(false))
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+
+	a.Error(d.Migrate(tr))
+	echo(t, tr, "still on the original path")
+}
+
+func TestMigrationProof(t *testing.T) {
+	a := require.New(t)
+	tr := newLoopbackTransport(t, newGatedConn())
+	binding := randomBytes(migrationBindingSize)
+	nonce := randomBytes(migrationNonceSize)
+
+	_, err := tr.migrationProof(migrationRequestInfo, binding, nonce)
+	a.Error(err, "proof requires an established session")
+
+	tr.setResumptionRoot(randomBytes(32))
+	req, err := tr.migrationProof(migrationRequestInfo, binding, nonce)
+	a.NoError(err)
+	again, err := tr.migrationProof(migrationRequestInfo, binding, nonce)
+	a.NoError(err)
+	a.Equal(req, again)
+
+	acc, err := tr.migrationProof(migrationAcceptInfo, binding, nonce)
+	a.NoError(err)
+	a.NotEqual(req, acc, "directions are domain separated")
+
+	other, err := tr.migrationProof(
+		migrationRequestInfo, binding, randomBytes(migrationNonceSize),
+	)
+	a.NoError(err)
+	a.NotEqual(req, other)
+
+	rebound, err := tr.migrationProof(
+		migrationRequestInfo, randomBytes(migrationBindingSize), nonce,
+	)
+	a.NoError(err)
+	a.NotEqual(req, rebound, "proofs are bound to the channel")
+}
+
+func TestMigrationGuard(t *testing.T) {
+	a := require.New(t)
+	c := clock.NewFake(time.Now())
+	g := newMigrationGuard(c)
+
+	tests := []struct {
+		name   string
+		offset time.Duration
+		stamp  bool
+		fresh  bool
+	}{
+		{name: "now", stamp: true, fresh: true},
+		{name: "recent", offset: -time.Minute, stamp: true, fresh: true},
+		{name: "skewed", offset: time.Minute, stamp: true, fresh: true},
+		{name: "stale", offset: -3 * time.Minute, stamp: true},
+		{name: "future", offset: 3 * time.Minute, stamp: true},
+		{name: "missing"},
+	}
+	for _, tc := range tests {
+		md := &pb.Metadata{}
+		if tc.stamp {
+			md.Timestamp = timestamppb.New(c.Now().Add(tc.offset))
+		}
+		raw, err := proto.Marshal(md)
+		a.NoError(err)
+		st := &pb.SignedTransport{Metadata: raw}
+		a.Equal(tc.fresh, g.checkFresh(st), tc.name)
+	}
+
+	nonce := randomBytes(migrationNonceSize)
+	a.True(g.checkReplay(nonce))
+	a.False(g.checkReplay(nonce), "replayed nonce")
+	a.True(g.checkReplay(randomBytes(migrationNonceSize)))
+	c.Advance(2 * migrationWindow)
+	a.True(g.checkReplay(nonce), "nonce is forgotten once stale")
+}
+
+// failingConn closes failed once a read from the wrapped connection fails.
+type failingConn struct {
+	Conn
+	failed chan struct{}
+}
+
+func (c *failingConn) ReadBytes() ([]byte, error) {
+	b, err := c.Conn.ReadBytes()
+	if err != nil {
+		close(c.failed)
+	}
+	return b, err
+}
+
+func TestMigrate_ReadAwaitsMigrationInProgress(t *testing.T) {
+	a := require.New(t)
+	oldLocal, oldRemote := net.Pipe()
+	newLocal, newRemote := net.Pipe()
+	old := &failingConn{Conn: newConn(oldLocal), failed: make(chan struct{})}
+	tr := &Transport{conn: old, mu: &sync.Mutex{}}
+	done := tr.beginMigration()
+
+	type result struct {
+		payload []byte
+		err     error
+	}
+	read := make(chan result, 1)
+	go func() {
+		payload, err := tr.readPayload()
+		read <- result{payload, err}
+	}()
+
+	// The peer drops the old path before the migration finishes locally.
+	// A read that returned at this point instead of waiting would fail.
+	a.NoError(oldRemote.Close())
+	<-old.failed
+	select {
+	case r := <-read:
+		a.FailNow("read returned during migration", "err: %v", r.err)
+	default:
+	}
+
+	tr.migrate(newConn(newLocal), 0)
+	done()
+	go func() { _ = newConn(newRemote).WriteBytes([]byte("after")) }()
+	r := <-read
+	a.NoError(r.err)
+	a.Equal("after", string(r.payload))
+}
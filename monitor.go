@@ -0,0 +1,539 @@
+package kamune
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// monitorFailures is the number of recent handshake failures kept for
+	// [ServerStatus].
+	monitorFailures = 32
+
+	// monitorRateInterval is the shortest window traffic rates are averaged
+	// over; see [ServerRates].
+	monitorRateInterval = 10 * time.Second
+
+	// monitorSocketMode is the default permission of the monitoring socket.
+	monitorSocketMode fs.FileMode = 0o600
+)
+
+// MonitorConfig configures the monitoring endpoint of a server; see
+// [ServeWithMonitor].
+type MonitorConfig struct {
+	// Network is "unix", the default, or "tcp".
+	Network string
+	// Address is the path of the unix socket, or the host and port to listen
+	// on with "tcp".
+	Address string
+	// Token, if set, must be sent by clients in an "Authorization: Bearer"
+	// header. It is required with "tcp".
+	Token string
+	// Mode is the permission of the unix socket, 0600 by default, so that
+	// only the server's user may connect.
+	Mode fs.FileMode
+}
+
+// ServerStatus is a snapshot of a server's activity. It holds no secrets: the
+// sessions themselves are listed by [SessionRegistry.ExportDiagnostics].
+type ServerStatus struct {
+	// Started is when the server was created.
+	Started  time.Time     `json:"started"`
+	Captured time.Time     `json:"captured"`
+	Uptime   time.Duration `json:"uptime"`
+	// Connections counts the connections accepted since the server started,
+	// and Established those that became a session, fresh or resumed.
+	Connections uint64 `json:"connections"`
+	Established uint64 `json:"established"`
+	// Throttled counts the connections closed because their address
+	// connected faster than [ServeWithConnectionRateLimit] allows.
+	Throttled uint64 `json:"throttled"`
+	// Failed counts the connections that failed before becoming a session,
+	// and FailedSteps the same by the handshake step they failed at.
+	Failed      uint64            `json:"failed"`
+	FailedSteps map[string]uint64 `json:"failedSteps"`
+	// RecentFailures holds the last 32 handshake failures, oldest first.
+	RecentFailures []HandshakeFailure `json:"recentFailures"`
+	// Sessions is the number of live sessions, and Pending the number of
+	// connections waiting on [Server.AcceptPending].
+	Sessions int `json:"sessions"`
+	Pending  int `json:"pending"`
+	// FrameFormats counts the sessions established since the server
+	// started, fresh or resumed, by the frame format they use, as named by
+	// [FrameFormat.String]. The sessions still on an old format tell how
+	// many peers have yet to move on from it; see [FrameFormats].
+	FrameFormats map[string]uint64 `json:"frameFormats"`
+	// Handshakes describes the handshake pool, if the server has one; see
+	// [ServeWithHandshakePool].
+	Handshakes *HandshakePoolStats `json:"handshakes,omitempty"`
+	// Traffic totals the counters of every session the server has had,
+	// closed or live, except for Backlog and Spilled, which only count the
+	// messages queued by live sessions.
+	Traffic   TransportStats  `json:"traffic"`
+	Rates     ServerRates     `json:"rates"`
+	Resources ServerResources `json:"resources"`
+}
+
+// HandshakeFailure is a connection that failed before becoming a session.
+type HandshakeFailure struct {
+	Time time.Time `json:"time"`
+	// Step is the handshake step that was in progress, as named by
+	// [HandshakeStep.String].
+	Step  string `json:"step"`
+	Error string `json:"error"`
+}
+
+// ServerRates are per-second averages over the last 10 to 20 seconds, or
+// since the previous status was taken if that was longer ago.
+type ServerRates struct {
+	Connections      float64 `json:"connections"`
+	MessagesSent     float64 `json:"messagesSent"`
+	MessagesReceived float64 `json:"messagesReceived"`
+	BytesSent        float64 `json:"bytesSent"`
+	BytesReceived    float64 `json:"bytesReceived"`
+}
+
+// ServerResources is the resource usage of the process running the server.
+type ServerResources struct {
+	Goroutines int `json:"goroutines"`
+	// HeapAlloc is the size of the live heap objects, and Sys all the memory
+	// obtained from the operating system, in bytes.
+	HeapAlloc uint64 `json:"heapAlloc"`
+	Sys       uint64 `json:"sys"`
+	NumGC     uint32 `json:"numGC"`
+}
+
+// serverMetrics holds the counters behind [ServerStatus]. They are kept
+// whether or not the monitoring endpoint is enabled.
+type serverMetrics struct {
+	started     time.Time
+	connections atomic.Uint64
+	established atomic.Uint64
+	throttled   atomic.Uint64
+	// closed totals the counters of the sessions that have ended.
+	closed transportStats
+	// formats counts the sessions established by their frame format.
+	formats map[FrameFormat]uint64
+	// failures is a ring buffer; next is the index the next failure goes to.
+	failures    []HandshakeFailure
+	failedSteps map[string]uint64
+	// prev and last are the samples rates are computed from.
+	prev, last rateSample
+	failed     uint64
+	next       int
+	mu         sync.Mutex
+}
+
+type rateSample struct {
+	time    time.Time
+	traffic TransportStats
+	conns   uint64
+}
+
+func newServerMetrics() *serverMetrics {
+	now := time.Now()
+	return &serverMetrics{
+		started:     now,
+		failures:    make([]HandshakeFailure, 0, monitorFailures),
+		failedSteps: make(map[string]uint64),
+		formats:     make(map[FrameFormat]uint64),
+		prev:        rateSample{time: now},
+		last:        rateSample{time: now},
+	}
+}
+
+// handshakeFailed records a connection that failed at step.
+func (m *serverMetrics) handshakeFailed(step HandshakeStep, err error) {
+	f := HandshakeFailure{
+		Time: time.Now(), Step: step.String(), Error: err.Error(),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed++
+	m.failedSteps[f.Step]++
+	if len(m.failures) < monitorFailures {
+		m.failures = append(m.failures, f)
+		return
+	}
+	m.failures[m.next] = f
+	m.next = (m.next + 1) % monitorFailures
+}
+
+// sessionEstablished records a session established with frame format f.
+func (m *serverMetrics) sessionEstablished(f FrameFormat) {
+	m.established.Add(1)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.formats[f]++
+}
+
+// sessionClosed adds the counters of a session that ended to the totals.
+func (m *serverMetrics) sessionClosed(st TransportStats) {
+	m.closed.messagesSent.Add(st.MessagesSent)
+	m.closed.messagesReceived.Add(st.MessagesReceived)
+	m.closed.bytesSent.Add(st.BytesSent)
+	m.closed.bytesReceived.Add(st.BytesReceived)
+	m.closed.undecryptable.Add(st.Undecryptable)
+	m.closed.outOfSync.Add(st.OutOfSync)
+	m.closed.deduplicated.Add(st.Deduplicated)
+	m.closed.rateLimited.Add(st.RateLimited)
+}
+
+// Status returns a snapshot of the server's activity, as served by the
+// monitoring endpoint of [ServeWithMonitor].
+func (s *Server) Status() ServerStatus {
+	m := s.metrics
+	now := time.Now()
+	sessions := s.registry.Sessions()
+	st := ServerStatus{
+		Started:     m.started,
+		Captured:    now,
+		Uptime:      now.Sub(m.started),
+		Connections: m.connections.Load(),
+		Established: m.established.Load(),
+		Throttled:   m.throttled.Load(),
+		Sessions:    len(sessions),
+		Pending:     len(s.Pending()),
+		Handshakes:  s.handshakes.stats(),
+		Traffic: TransportStats{
+			MessagesSent:     m.closed.messagesSent.Load(),
+			MessagesReceived: m.closed.messagesReceived.Load(),
+			BytesSent:        m.closed.bytesSent.Load(),
+			BytesReceived:    m.closed.bytesReceived.Load(),
+			Undecryptable:    m.closed.undecryptable.Load(),
+			OutOfSync:        m.closed.outOfSync.Load(),
+			Deduplicated:     m.closed.deduplicated.Load(),
+			RateLimited:      m.closed.rateLimited.Load(),
+		},
+	}
+	for _, t := range sessions {
+		ts := t.Stats()
+		st.Traffic.MessagesSent += ts.MessagesSent
+		st.Traffic.MessagesReceived += ts.MessagesReceived
+		st.Traffic.BytesSent += ts.BytesSent
+		st.Traffic.BytesReceived += ts.BytesReceived
+		st.Traffic.Undecryptable += ts.Undecryptable
+		st.Traffic.OutOfSync += ts.OutOfSync
+		st.Traffic.Deduplicated += ts.Deduplicated
+		st.Traffic.RateLimited += ts.RateLimited
+		st.Traffic.Backlog += ts.Backlog
+		st.Traffic.Spilled += ts.Spilled
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	st.Resources = ServerResources{
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  mem.HeapAlloc,
+		Sys:        mem.Sys,
+		NumGC:      mem.NumGC,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st.Failed = m.failed
+	st.FailedSteps = make(map[string]uint64, len(m.failedSteps))
+	for step, n := range m.failedSteps {
+		st.FailedSteps[step] = n
+	}
+	st.FrameFormats = make(map[string]uint64, len(m.formats))
+	for f, n := range m.formats {
+		st.FrameFormats[f.String()] = n
+	}
+	st.RecentFailures = make([]HandshakeFailure, 0, len(m.failures))
+	st.RecentFailures = append(st.RecentFailures, m.failures[m.next:]...)
+	st.RecentFailures = append(st.RecentFailures, m.failures[:m.next]...)
+	st.Rates = m.rates(rateSample{
+		time: now, traffic: st.Traffic, conns: st.Connections,
+	})
+	return st
+}
+
+// rates averages the traffic between cur and the oldest kept sample, and
+// keeps cur once the newest is monitorRateInterval old. m.mu must be held.
+func (m *serverMetrics) rates(cur rateSample) ServerRates {
+	if cur.time.Sub(m.last.time) >= monitorRateInterval {
+		m.prev, m.last = m.last, cur
+	}
+	secs := cur.time.Sub(m.prev.time).Seconds()
+	if secs <= 0 {
+		return ServerRates{}
+	}
+	rate := func(cur, prev uint64) float64 {
+		// Sessions closing while a status is taken may be missed by it.
+		if cur < prev {
+			return 0
+		}
+		return float64(cur-prev) / secs
+	}
+	return ServerRates{
+		Connections: rate(cur.conns, m.prev.conns),
+		MessagesSent: rate(
+			cur.traffic.MessagesSent, m.prev.traffic.MessagesSent,
+		),
+		MessagesReceived: rate(
+			cur.traffic.MessagesReceived, m.prev.traffic.MessagesReceived,
+		),
+		BytesSent: rate(cur.traffic.BytesSent, m.prev.traffic.BytesSent),
+		BytesReceived: rate(
+			cur.traffic.BytesReceived, m.prev.traffic.BytesReceived,
+		),
+	}
+}
+
+// ServeWithMonitor serves a read-only view of the server over HTTP while
+// [Server.ListenAndServe] runs, so that operators can observe it without
+// changing the handler:
+//
+//   - GET /status: the [ServerStatus] as JSON.
+//   - GET /sessions: the live sessions, as [SessionRegistry.ExportDiagnostics]
+//     writes them, without secrets.
+//   - GET /metrics: the same counters in the Prometheus text format.
+//
+// Access is limited by the permission of the unix socket, or by the token,
+// which is why listening on "tcp" requires one. A stale socket left at the
+// address by an earlier run is replaced.
+func ServeWithMonitor(cfg MonitorConfig) ServerOptions {
+	return func(s *Server) error {
+		switch cfg.Network {
+		case "":
+			cfg.Network = "unix"
+		case "unix":
+		case "tcp":
+			if cfg.Token == "" {
+				return errors.New("a tcp monitor requires a token")
+			}
+		default:
+			return fmt.Errorf("unsupported monitor network %q", cfg.Network)
+		}
+		if cfg.Address == "" {
+			return errors.New("monitor address must not be empty")
+		}
+		if cfg.Mode == 0 {
+			cfg.Mode = monitorSocketMode
+		}
+		s.monitor = &monitor{cfg: cfg}
+		return nil
+	}
+}
+
+// monitor serves the endpoint configured by [ServeWithMonitor].
+type monitor struct {
+	srv *http.Server
+	cfg MonitorConfig
+}
+
+// start begins serving the monitoring endpoint of s.
+func (mo *monitor) start(s *Server) error {
+	l, err := mo.listen()
+	if err != nil {
+		return fmt.Errorf("listening for monitor: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"GET /status", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(s.Status())
+		},
+	)
+	mux.HandleFunc(
+		"GET /sessions", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = s.registry.ExportDiagnostics(w)
+		},
+	)
+	mux.HandleFunc(
+		"GET /metrics", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			writeMetrics(w, s.Status())
+		},
+	)
+	mo.srv = &http.Server{
+		Handler:           mo.authorize(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		err := mo.srv.Serve(l)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("serve monitor", slog.Any("error", err))
+		}
+	}()
+	slog.Info(
+		"monitor started",
+		slog.String("network", mo.cfg.Network),
+		slog.String("addr", mo.cfg.Address),
+	)
+	return nil
+}
+
+func (mo *monitor) listen() (net.Listener, error) {
+	if mo.cfg.Network != "unix" {
+		return net.Listen(mo.cfg.Network, mo.cfg.Address)
+	}
+	fi, err := os.Lstat(mo.cfg.Address)
+	if err == nil && fi.Mode().Type() == fs.ModeSocket {
+		if err := os.Remove(mo.cfg.Address); err != nil {
+			return nil, fmt.Errorf("removing stale socket: %w", err)
+		}
+	}
+	l, err := net.Listen("unix", mo.cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(mo.cfg.Address, mo.cfg.Mode); err != nil {
+		_ = l.Close()
+		return nil, fmt.Errorf("setting socket permission: %w", err)
+	}
+	return l, nil
+}
+
+// authorize rejects requests without the configured token, if there is one.
+func (mo *monitor) authorize(next http.Handler) http.Handler {
+	if mo.cfg.Token == "" {
+		return next
+	}
+	want := []byte("Bearer " + mo.cfg.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (mo *monitor) close() {
+	if mo == nil || mo.srv == nil {
+		return
+	}
+	_ = mo.srv.Close()
+}
+
+// writeMetrics writes st in the Prometheus text exposition format.
+func writeMetrics(w io.Writer, st ServerStatus) {
+	var b strings.Builder
+	metric := func(name, kind, help string, value any) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		fmt.Fprintf(&b, "%s %v\n", name, value)
+	}
+	metric(
+		"kamune_connections_total", "counter",
+		"Connections accepted by the server.", st.Connections,
+	)
+	metric(
+		"kamune_sessions_established_total", "counter",
+		"Connections that became a session, fresh or resumed.",
+		st.Established,
+	)
+	metric(
+		"kamune_connections_throttled_total", "counter",
+		"Connections closed by the per-address connection rate limit.",
+		st.Throttled,
+	)
+	b.WriteString("# HELP kamune_handshake_failures_total " +
+		"Connections that failed before becoming a session.\n")
+	b.WriteString("# TYPE kamune_handshake_failures_total counter\n")
+	for _, step := range slices.Sorted(maps.Keys(st.FailedSteps)) {
+		fmt.Fprintf(
+			&b, "kamune_handshake_failures_total{step=%q} %d\n",
+			step, st.FailedSteps[step],
+		)
+	}
+	metric(
+		"kamune_sessions", "gauge", "Live sessions.", st.Sessions,
+	)
+	metric(
+		"kamune_pending_connections", "gauge",
+		"Connections waiting on the application's decision.", st.Pending,
+	)
+	if h := st.Handshakes; h != nil {
+		metric(
+			"kamune_handshake_workers", "gauge",
+			"Handshake pool slots.", h.Workers,
+		)
+		metric(
+			"kamune_handshake_workers_busy", "gauge",
+			"Handshake pool slots in use.", h.Busy,
+		)
+		metric(
+			"kamune_handshakes_queued", "gauge",
+			"Connections waiting for a handshake slot.", h.Queued,
+		)
+		metric(
+			"kamune_handshake_queue_wait_seconds_total", "counter",
+			"Time connections spent waiting for a handshake slot.",
+			h.Waited.Seconds(),
+		)
+		metric(
+			"kamune_handshakes_rejected_total", "counter",
+			"Connections closed because the handshake pool was full.",
+			h.Rejected,
+		)
+		metric(
+			"kamune_handshakes_expired_total", "counter",
+			"Connections that waited for a handshake slot until their "+
+				"deadline.",
+			h.Expired,
+		)
+	}
+	metric(
+		"kamune_messages_sent_total", "counter",
+		"Messages sent by all sessions.", st.Traffic.MessagesSent,
+	)
+	metric(
+		"kamune_messages_received_total", "counter",
+		"Messages received by all sessions.", st.Traffic.MessagesReceived,
+	)
+	metric(
+		"kamune_bytes_sent_total", "counter",
+		"Encrypted bytes sent by all sessions.", st.Traffic.BytesSent,
+	)
+	metric(
+		"kamune_bytes_received_total", "counter",
+		"Encrypted bytes received by all sessions.", st.Traffic.BytesReceived,
+	)
+	metric(
+		"kamune_frames_rejected_total", "counter",
+		"Frames that failed decryption or sequence validation.",
+		st.Traffic.Undecryptable+st.Traffic.OutOfSync,
+	)
+	metric(
+		"kamune_uptime_seconds", "gauge",
+		"Time since the server was created.", st.Uptime.Seconds(),
+	)
+	metric(
+		"go_goroutines", "gauge",
+		"Number of goroutines that currently exist.",
+		st.Resources.Goroutines,
+	)
+	metric(
+		"go_memstats_heap_alloc_bytes", "gauge",
+		"Number of heap bytes allocated and still in use.",
+		st.Resources.HeapAlloc,
+	)
+	metric(
+		"go_memstats_sys_bytes", "gauge",
+		"Number of bytes obtained from system.", st.Resources.Sys,
+	)
+	_, _ = io.WriteString(w, b.String())
+}
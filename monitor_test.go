@@ -0,0 +1,197 @@
+package kamune
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// monitorClient returns an HTTP client that reaches the monitor at path.
+func monitorClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func monitorGet(
+	t *testing.T, c *http.Client, method, path, token string,
+) (int, string) {
+	t.Helper()
+	a := require.New(t)
+	req, err := http.NewRequest(method, "http://monitor"+path, nil)
+	a.NoError(err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.Do(req)
+	a.NoError(err)
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	a.NoError(err)
+	return resp.StatusCode, string(body)
+}
+
+func TestServeWithMonitor(t *testing.T) {
+	a := require.New(t)
+	sock := filepath.Join(t.TempDir(), "monitor.sock")
+	// A socket left behind by an earlier run is replaced.
+	stale, err := net.Listen("unix", sock)
+	a.NoError(err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	a.NoError(stale.Close())
+
+	const token = "s3cret"
+	addr, _, _ := startEchoServer(t, ServeWithMonitor(MonitorConfig{
+		Address: sock, Token: token,
+	}))
+	c := monitorClient(sock)
+	a.Eventually(func() bool {
+		resp, err := c.Get("http://monitor/status")
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	fi, err := os.Stat(sock)
+	a.NoError(err)
+	a.Equal(os.FileMode(0o600), fi.Mode().Perm())
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer func() { _ = tr.Close() }()
+	echo(t, tr, "observed")
+
+	// A connection that never completes the exchange.
+	raw, err := net.Dial("tcp", addr)
+	a.NoError(err)
+	_, err = raw.Write([]byte{0, 0, 0, 4, 'j', 'u', 'n', 'k'})
+	a.NoError(err)
+	a.NoError(raw.Close())
+
+	var st ServerStatus
+	a.Eventually(func() bool {
+		code, body := monitorGet(t, c, http.MethodGet, "/status", token)
+		a.Equal(http.StatusOK, code)
+		a.NoError(json.Unmarshal([]byte(body), &st))
+		return st.Failed == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	a.EqualValues(2, st.Connections)
+	a.EqualValues(1, st.Established)
+	a.Equal(1, st.Sessions)
+	a.Equal(map[string]uint64{"exchange": 1}, st.FailedSteps)
+	a.Len(st.RecentFailures, 1)
+	a.Equal("exchange", st.RecentFailures[0].Step)
+	a.GreaterOrEqual(st.Traffic.MessagesReceived, uint64(1))
+	a.Positive(st.Resources.Goroutines)
+
+	code, body := monitorGet(t, c, http.MethodGet, "/sessions", token)
+	a.Equal(http.StatusOK, code)
+	a.Contains(body, tr.SessionID())
+	a.NotContains(body, "peerPublicKey")
+
+	code, body = monitorGet(t, c, http.MethodGet, "/metrics", token)
+	a.Equal(http.StatusOK, code)
+	for _, line := range []string{
+		"kamune_connections_total 2",
+		"kamune_sessions_established_total 1",
+		`kamune_handshake_failures_total{step="exchange"} 1`,
+		"kamune_sessions 1",
+	} {
+		a.Contains(strings.Split(body, "\n"), line)
+	}
+
+	// The endpoint is read-only and requires the token.
+	code, _ = monitorGet(t, c, http.MethodPost, "/status", token)
+	a.Equal(http.StatusMethodNotAllowed, code)
+	code, _ = monitorGet(t, c, http.MethodGet, "/status", "")
+	a.Equal(http.StatusUnauthorized, code)
+	code, _ = monitorGet(t, c, http.MethodGet, "/status", "wrong")
+	a.Equal(http.StatusUnauthorized, code)
+}
+
+func TestServeWithMonitorConfig(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	tests := []struct {
+		name string
+		cfg  MonitorConfig
+		ok   bool
+	}{
+		{name: "unix", cfg: MonitorConfig{Address: "m.sock"}, ok: true},
+		{
+			name: "tcp with token",
+			cfg: MonitorConfig{
+				Network: "tcp", Address: "127.0.0.1:0", Token: "t",
+			},
+			ok: true,
+		},
+		{
+			name: "tcp without token",
+			cfg:  MonitorConfig{Network: "tcp", Address: "127.0.0.1:0"},
+		},
+		{name: "no address", cfg: MonitorConfig{}},
+		{
+			name: "unknown network",
+			cfg:  MonitorConfig{Network: "udp", Address: "x", Token: "t"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := require.New(t)
+			_, err := NewServer(
+				"", NewEchoHandler(), store, acceptAll,
+				ServeWithMonitor(tt.cfg),
+			)
+			if tt.ok {
+				a.NoError(err)
+			} else {
+				a.Error(err)
+			}
+		})
+	}
+}
+
+func TestServerStatusRates(t *testing.T) {
+	a := require.New(t)
+	m := newServerMetrics()
+	start := m.last.time
+
+	r := m.rates(rateSample{
+		time:  start.Add(5 * time.Second),
+		conns: 10,
+	})
+	a.InDelta(2, r.Connections, 1e-9)
+
+	// Samples are kept every monitorRateInterval, and counters that went
+	// backwards do not produce negative rates.
+	r = m.rates(rateSample{
+		time:    start.Add(monitorRateInterval),
+		conns:   20,
+		traffic: TransportStats{BytesSent: 1000},
+	})
+	a.InDelta(2, r.Connections, 1e-9)
+	a.InDelta(100, r.BytesSent, 1e-9)
+	r = m.rates(rateSample{
+		time:  start.Add(monitorRateInterval + 5*time.Second),
+		conns: 15,
+	})
+	a.InDelta(1, r.Connections, 1e-9)
+	a.Zero(r.BytesSent)
+}
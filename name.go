@@ -0,0 +1,54 @@
+package kamune
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/kamune-org/kamune/pkg/attest"
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// localName returns the name to introduce at with: name if one was set with
+// DialWithClientName or ServeWithServerName, otherwise the storage's display
+// name, otherwise the fingerprint of the public key. It is resolved for every
+// introduction, so that a display name changed with
+// [storage.Storage.SetDisplayName] is sent from the next connection on.
+func localName(
+	store *storage.Storage, at *attest.Attest, name string,
+) string {
+	if name != "" {
+		return name
+	}
+	if name, err := store.DisplayName(); err == nil && name != "" {
+		return name
+	}
+	return fingerprint.Sum(at.MarshalPublicKey())
+}
+
+// checkRename compares the name peer introduced itself with to the one it
+// last established a session under, and records the latter in peer.Renamed
+// if they differ. Like checkDowngrade, it only informs the verifiers.
+func checkRename(store *storage.Storage, peer *storage.Peer) {
+	history, err := store.PeerNameHistory(peer.PublicKey)
+	if err != nil || len(history) == 0 {
+		return
+	}
+	last := history[len(history)-1]
+	if last.Name == peer.Name {
+		return
+	}
+	peer.Renamed = &last
+	slog.Warn(
+		"peer introduced itself under a new name",
+		slog.String("peer", peer.Name),
+		slog.String("previous_name", last.Name),
+		slog.Time("previous_since", last.Since),
+	)
+}
+
+// recordName adds the name the peer established a session under to its name
+// history, as the baseline of checkRename for its next connections.
+func recordName(store *storage.Storage, peer *storage.Peer) {
+	_ = store.RecordPeerName(peer.PublicKey, peer.Name, time.Time{})
+}
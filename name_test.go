@@ -0,0 +1,69 @@
+package kamune
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+func TestRename_Verifier(t *testing.T) {
+	a := require.New(t)
+	var server *storage.Storage
+	addr := startSessionServer(t, func(s *Server) error {
+		server = s.storage
+		return nil
+	})
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	var seen *storage.Peer
+	verifier := func(s *storage.Storage, p *storage.Peer) error {
+		seen = p
+		return s.StorePeer(p)
+	}
+	d, err := NewDialer(addr, store, verifier)
+	a.NoError(err)
+	dial := func() {
+		tr, err := d.Dial()
+		a.NoError(err)
+		a.NoError(tr.Close())
+	}
+
+	dial()
+	fp := fingerprint.Sum(seen.PublicKey)
+	a.Equal(fp, seen.Name)
+	a.Nil(seen.Renamed)
+
+	// The display name is picked up without recreating the server.
+	a.NoError(server.SetDisplayName("alice"))
+	dial()
+	a.Equal("alice", seen.Name)
+	a.NotNil(seen.Renamed)
+	a.Equal(fp, seen.Renamed.Name)
+
+	// The accepted introduction became the baseline.
+	dial()
+	a.Nil(seen.Renamed)
+	history, err := store.PeerNameHistory(seen.PublicKey)
+	a.NoError(err)
+	a.Len(history, 2)
+	a.Equal(fp, history[0].Name)
+	a.Equal("alice", history[1].Name)
+}
+
+func TestLocalName(t *testing.T) {
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	at, err := store.Attester()
+	a.NoError(err)
+
+	fp := fingerprint.Sum(at.MarshalPublicKey())
+	a.Equal(fp, localName(store, at, ""))
+	a.NoError(store.SetDisplayName("bob"))
+	a.Equal("bob", localName(store, at, ""))
+	a.Equal("explicit", localName(store, at, "explicit"))
+}
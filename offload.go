@@ -0,0 +1,280 @@
+package kamune
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// HandshakePool bounds the handshakes a server works on at once, so that a
+// burst of connections queues instead of running every key agreement and
+// signature check in parallel; see [ServeWithHandshakePool].
+//
+// Once a connection's first message shows whether it is a fresh handshake,
+// a resumption, or a migration, its verification and key agreement wait for
+// one of Workers slots. Resumptions and migrations, which restore sessions
+// that already exist, are given slots before fresh handshakes. Up to Queue
+// connections may be set up beyond those holding a slot, whether they are
+// waiting for one or still exchanging keys; further connections are closed
+// as soon as they are accepted. A connection that waits for a slot past its
+// handshake deadline fails with [ErrServerBusy].
+type HandshakePool struct {
+	// Workers is the number of slots, or GOMAXPROCS if zero.
+	Workers int
+	Queue   int
+}
+
+// HandshakePoolStats describes the handshake pool of a server; see
+// [ServerStatus].
+type HandshakePoolStats struct {
+	Workers int `json:"workers"`
+	// Busy is the number of slots in use, and Queued the number of
+	// connections waiting for one.
+	Busy   int `json:"busy"`
+	Queued int `json:"queued"`
+	// Granted counts the slots handed out, and Waited the time spent
+	// waiting for them in total.
+	Granted uint64        `json:"granted"`
+	Waited  time.Duration `json:"waited"`
+	// Rejected counts the connections closed because the pool was full when
+	// they were accepted, and Expired those that waited for a slot until
+	// their handshake deadline.
+	Rejected uint64 `json:"rejected"`
+	Expired  uint64 `json:"expired"`
+}
+
+func (p HandshakePool) validate() error {
+	switch {
+	case p.Workers < 0:
+		return errors.New("handshake workers must not be negative")
+	case p.Queue < 0:
+		return errors.New("handshake queue must not be negative")
+	}
+	return nil
+}
+
+// ServeWithHandshakePool has the server's handshakes share a bounded pool of
+// slots as p says; see [HandshakePool]. Without it, every accepted
+// connection is set up at once. It cannot be combined with
+// [ServeWithMaxConcurrentHandshakes].
+func ServeWithHandshakePool(p HandshakePool) ServerOptions {
+	return func(s *Server) error {
+		if err := p.validate(); err != nil {
+			return err
+		}
+		if p.Workers == 0 {
+			p.Workers = runtime.GOMAXPROCS(0)
+		}
+		return s.setHandshakes(p)
+	}
+}
+
+// setHandshakes sets up the pool of handshake slots, which only one option
+// may do, lest the last one silently replace the limits of the others.
+func (s *Server) setHandshakes(p HandshakePool) error {
+	if s.handshakes != nil {
+		return errors.New("handshake pool is set by more than one option")
+	}
+	s.handshakes = newHandshakePool(p)
+	return nil
+}
+
+// handshakePriority orders the connections waiting for a slot.
+type handshakePriority int
+
+const (
+	// priorityRestore is for resumptions and migrations.
+	priorityRestore handshakePriority = iota
+	// priorityFresh is for new sessions.
+	priorityFresh
+	numHandshakePriorities
+)
+
+// handshakePool hands out the slots of a [HandshakePool]. A nil pool admits
+// every connection and never makes one wait.
+type handshakePool struct {
+	waiting [numHandshakePriorities][]*slotWaiter
+	// setup is the number of connections admitted and not yet set up.
+	setup    int
+	limit    int
+	workers  int
+	busy     int
+	granted  uint64
+	rejected uint64
+	expired  uint64
+	waited   time.Duration
+	mu       sync.Mutex
+	closed   bool
+}
+
+// slotWaiter is a connection waiting for a slot. ready is closed once it is
+// granted one, or once the pool is closed.
+type slotWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// handshakeSlot is a slot held by a connection being set up.
+type handshakeSlot struct {
+	pool     *handshakePool
+	priority handshakePriority
+	held     bool
+}
+
+func newHandshakePool(p HandshakePool) *handshakePool {
+	return &handshakePool{workers: p.Workers, limit: p.Workers + p.Queue}
+}
+
+// admit reports whether an accepted connection may be set up. Every admitted
+// connection must be let go with leave once set up or failed.
+func (p *handshakePool) admit() bool {
+	if p == nil {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || p.setup >= p.limit {
+		p.rejected++
+		return false
+	}
+	p.setup++
+	return true
+}
+
+// leave lets go of a connection admitted by admit.
+func (p *handshakePool) leave() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.setup--
+	p.mu.Unlock()
+}
+
+// acquire waits for a slot until deadline, ahead of the connections waiting
+// with a lower priority. The returned slot is nil for a nil pool.
+func (p *handshakePool) acquire(
+	priority handshakePriority, deadline time.Time,
+) (*handshakeSlot, error) {
+	if p == nil {
+		return nil, nil
+	}
+	sl := &handshakeSlot{pool: p, priority: priority}
+	return sl, sl.reacquire(deadline)
+}
+
+// reacquire waits for the slot again after release; see acquire.
+func (sl *handshakeSlot) reacquire(deadline time.Time) error {
+	if sl == nil || sl.held {
+		return nil
+	}
+	p := sl.pool
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrClosedServer
+	}
+	if p.busy < p.workers {
+		p.busy++
+		p.granted++
+		p.mu.Unlock()
+		sl.held = true
+		return nil
+	}
+	w := &slotWaiter{ready: make(chan struct{})}
+	p.waiting[sl.priority] = append(p.waiting[sl.priority], w)
+	p.mu.Unlock()
+
+	start := time.Now()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+	case <-timer.C:
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	waited := time.Since(start)
+	switch {
+	case w.granted:
+		p.waited += waited
+		sl.held = true
+		return nil
+	case p.closed:
+		return ErrClosedServer
+	}
+	queue := p.waiting[sl.priority]
+	if i := slices.Index(queue, w); i >= 0 {
+		p.waiting[sl.priority] = slices.Delete(queue, i, i+1)
+	}
+	p.expired++
+	return fmt.Errorf(
+		"%w: waited %s for a handshake slot",
+		ErrServerBusy, waited.Round(time.Millisecond),
+	)
+}
+
+// release hands the slot to the first connection waiting with the highest
+// priority, or frees it. It does nothing unless the slot is held.
+func (sl *handshakeSlot) release() {
+	if sl == nil || !sl.held {
+		return
+	}
+	sl.held = false
+	p := sl.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, queue := range p.waiting {
+		if len(queue) == 0 {
+			continue
+		}
+		w := queue[0]
+		p.waiting[i] = queue[1:]
+		w.granted = true
+		p.granted++
+		close(w.ready)
+		return
+	}
+	p.busy--
+}
+
+// close fails the connections waiting for a slot, and refuses new ones.
+func (p *handshakePool) close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for i, queue := range p.waiting {
+		for _, w := range queue {
+			close(w.ready)
+		}
+		p.waiting[i] = nil
+	}
+}
+
+// stats returns the pool's statistics, or nil for a nil pool.
+func (p *handshakePool) stats() *HandshakePoolStats {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := &HandshakePoolStats{
+		Workers:  p.workers,
+		Busy:     p.busy,
+		Granted:  p.granted,
+		Waited:   p.waited,
+		Rejected: p.rejected,
+		Expired:  p.expired,
+	}
+	for _, queue := range p.waiting {
+		st.Queued += len(queue)
+	}
+	return st
+}
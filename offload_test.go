@@ -0,0 +1,142 @@
+package kamune
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandshakePool_Priority(t *testing.T) {
+	a := require.New(t)
+	p := newHandshakePool(HandshakePool{Workers: 1, Queue: 4})
+	deadline := time.Now().Add(5 * time.Second)
+
+	first, err := p.acquire(priorityFresh, deadline)
+	a.NoError(err)
+
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+	)
+	wait := func(name string, priority handshakePriority, queued int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sl, err := p.acquire(priority, deadline)
+			a.NoError(err)
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			sl.release()
+		}()
+		a.Eventually(func() bool {
+			return p.stats().Queued == queued
+		}, 5*time.Second, time.Millisecond)
+	}
+	wait("fresh", priorityFresh, 1)
+	wait("resume", priorityRestore, 2)
+
+	first.release()
+	wg.Wait()
+	a.Equal([]string{"resume", "fresh"}, order)
+	st := p.stats()
+	a.Equal(0, st.Busy)
+	a.EqualValues(3, st.Granted)
+	a.Positive(st.Waited)
+}
+
+func TestHandshakePool_Limits(t *testing.T) {
+	a := require.New(t)
+	p := newHandshakePool(HandshakePool{Workers: 1, Queue: 1})
+
+	a.True(p.admit())
+	a.True(p.admit())
+	a.False(p.admit())
+	p.leave()
+	a.True(p.admit())
+
+	held, err := p.acquire(priorityFresh, time.Now().Add(time.Second))
+	a.NoError(err)
+	_, err = p.acquire(priorityRestore, time.Now().Add(20*time.Millisecond))
+	a.ErrorIs(err, ErrServerBusy)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.acquire(priorityFresh, time.Now().Add(5*time.Second))
+		done <- err
+	}()
+	a.Eventually(func() bool {
+		return p.stats().Queued == 1
+	}, 5*time.Second, time.Millisecond)
+	p.close()
+	a.ErrorIs(<-done, ErrClosedServer)
+	a.False(p.admit())
+	held.release()
+
+	st := p.stats()
+	a.EqualValues(2, st.Rejected)
+	a.EqualValues(1, st.Expired)
+	a.Equal(0, st.Queued)
+	a.Equal(0, st.Busy)
+
+	// A nil pool admits everything.
+	var none *handshakePool
+	a.True(none.admit())
+	sl, err := none.acquire(priorityFresh, time.Time{})
+	a.NoError(err)
+	sl.release()
+	a.Nil(none.stats())
+}
+
+func TestServeWithHandshakePool(t *testing.T) {
+	a := require.New(t)
+	var srv *Server
+	addr, _, _ := startEchoServer(t,
+		ServeWithHandshakePool(HandshakePool{Workers: 1}),
+		func(s *Server) error {
+			srv = s
+			return nil
+		},
+	)
+
+	// A connection that never starts the exchange fills the pool, so the
+	// next one is refused.
+	stalled, err := net.Dial("tcp", addr)
+	a.NoError(err)
+	a.Eventually(func() bool {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		defer c.Close()
+		_ = c.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = c.Read(make([]byte, 1))
+		return err != nil && !isTimeout(err)
+	}, 5*time.Second, 10*time.Millisecond)
+	a.NoError(stalled.Close())
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, acceptAll)
+	a.NoError(err)
+	var tr *Transport
+	a.Eventually(func() bool {
+		tr, err = d.Dial()
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	defer func() { _ = tr.Close() }()
+	echo(t, tr, "pooled")
+
+	st := srv.Status().Handshakes
+	a.NotNil(st)
+	a.Equal(1, st.Workers)
+	a.Equal(0, st.Busy)
+	a.Positive(st.Granted)
+	a.Positive(st.Rejected)
+
+	a.Error(ServeWithHandshakePool(HandshakePool{Queue: -1})(srv))
+}
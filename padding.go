@@ -0,0 +1,137 @@
+package kamune
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"sync"
+
+	"golang.org/x/crypto/chacha20"
+
+	"github.com/kamune-org/kamune/internal/enigma"
+)
+
+// paddingDRBG chooses the padding of a session's frames from a ChaCha20
+// keystream instead of the process's random sources, so that a session seeded
+// the same way pads the same messages the same way. A nil paddingDRBG uses the
+// random sources.
+type paddingDRBG struct {
+	rand *mathrand.Rand
+	// stream is shared with rand, whose draws it advances.
+	stream *chacha20.Cipher
+	mu     sync.Mutex
+}
+
+// newPaddingDRBG returns the generator for the frames a session sends in
+// the direction named by info, keyed with seed and the session ID. It returns
+// nil for a nil seed.
+func newPaddingDRBG(seed []byte, sessionID, info string) (*paddingDRBG, error) {
+	if seed == nil {
+		return nil, nil
+	}
+	key, err := enigma.Derive(
+		seed, []byte(sessionID), []byte(info+sessionID), chacha20.KeySize,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("deriving padding key: %w", err)
+	}
+	// The key is unique to the session and direction, so the nonce need not
+	// be.
+	stream, err := chacha20.NewUnauthenticatedCipher(
+		key, make([]byte, chacha20.NonceSize),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating padding stream: %w", err)
+	}
+	return &paddingDRBG{
+		rand:   mathrand.New(keystream{stream}),
+		stream: stream,
+	}, nil
+}
+
+// intN returns a number in [0, n).
+func (d *paddingDRBG) intN(n int) int {
+	if d == nil {
+		return mathrand.IntN(n)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rand.IntN(n)
+}
+
+// bytes returns n bytes of padding.
+func (d *paddingDRBG) bytes(n int) []byte {
+	if d == nil {
+		return randomBytes(n)
+	}
+	buf := make([]byte, n)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stream.XORKeyStream(buf, buf)
+	return buf
+}
+
+// keystream is a [mathrand.Source] reading from a ChaCha20 keystream.
+type keystream struct {
+	c *chacha20.Cipher
+}
+
+func (k keystream) Uint64() uint64 {
+	var buf [8]byte
+	k.c.XORKeyStream(buf[:], buf[:])
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+// seedPadding makes the frames t sends padded by a generator derived from
+// seed, if it is not nil; see [ServeWithPaddingSeed].
+func (t *Transport) seedPadding(seed []byte, info string) error {
+	d, err := newPaddingDRBG(seed, t.sessionID, info)
+	if err != nil {
+		return err
+	}
+	t.serde.padding = d
+	return nil
+}
+
+func checkPaddingSeed(seed []byte) error {
+	if len(seed) < paddingSeedMinSize {
+		return fmt.Errorf(
+			"padding seed must be at least %d bytes", paddingSeedMinSize,
+		)
+	}
+	slog.Warn(
+		"deterministic padding is enabled; frame sizes can be predicted " +
+			"from the seed",
+	)
+	return nil
+}
+
+// ServeWithPaddingSeed makes the padding of every frame the server sends
+// (§12.7), both its size and its bytes, derive from seed and the session ID
+// rather than from random sources, so that tests and debugging sessions can
+// reproduce transcripts byte for byte given the same messages. Anyone who
+// knows the seed can predict the sizes of the frames, so use it only where
+// the network is not adversarial. Seeds shorter than 16 bytes are rejected.
+// The seed is copied.
+func ServeWithPaddingSeed(seed []byte) ServerOptions {
+	return func(s *Server) error {
+		if err := checkPaddingSeed(seed); err != nil {
+			return err
+		}
+		s.handshakeOpts.paddingSeed = bytes.Clone(seed)
+		return nil
+	}
+}
+
+// DialWithPaddingSeed is the dial-side equivalent of [ServeWithPaddingSeed].
+func DialWithPaddingSeed(seed []byte) DialOption {
+	return func(d *Dialer) error {
+		if err := checkPaddingSeed(seed); err != nil {
+			return err
+		}
+		d.handshakeOpts.paddingSeed = bytes.Clone(seed)
+		return nil
+	}
+}
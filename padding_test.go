@@ -0,0 +1,72 @@
+package kamune
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+)
+
+func TestPaddingDRBG(t *testing.T) {
+	a := require.New(t)
+	seed := bytes.Repeat([]byte{7}, paddingSeedMinSize)
+
+	pad := func(sessionID, info string) [][]byte {
+		d, err := newPaddingDRBG(seed, sessionID, info)
+		a.NoError(err)
+		var frames [][]byte
+		for i := range 50 {
+			st := &pb.SignedTransport{Data: bytes.Repeat([]byte{1}, 10*i)}
+			payload, err := padSignedTransportWithin(st, frameTargetSize, 0, d)
+			a.NoError(err)
+			a.Contains(paddingBuckets, len(payload))
+			frames = append(frames, payload)
+		}
+		return frames
+	}
+
+	// The same seed, session, and direction reproduce every frame.
+	a.Equal(pad("session", paddingC2SInfo), pad("session", paddingC2SInfo))
+	a.NotEqual(pad("session", paddingC2SInfo), pad("other", paddingC2SInfo))
+	a.NotEqual(pad("session", paddingC2SInfo), pad("session", paddingS2CInfo))
+
+	d, err := newPaddingDRBG(nil, "session", paddingC2SInfo)
+	a.NoError(err)
+	a.Nil(d)
+}
+
+func TestPaddingSeedOptions(t *testing.T) {
+	a := require.New(t)
+	seed := bytes.Repeat([]byte{7}, paddingSeedMinSize)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	srvStore, cleanup := newTestStore(t)
+	defer cleanup()
+	_, err = NewServer("", NewEchoHandler(), srvStore, acceptAll,
+		ServeWithPaddingSeed(seed[1:]),
+	)
+	a.Error(err)
+	srv, err := NewServer("", NewEchoHandler(), srvStore, acceptAll,
+		ServeWithListener(&tcpListener{Listener: l}),
+		ServeWithPaddingSeed(seed),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(l.Addr().String(), store, acceptAll,
+		DialWithPaddingSeed(seed),
+	)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	a.NotNil(tr.serde.padding)
+	echo(t, tr, "padded")
+}
@@ -0,0 +1,182 @@
+package kamune
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/kamune-org/kamune/pkg/exchange"
+)
+
+// PathPolicy decides which network path a dialer configured with
+// [DialWithUDPPath] establishes its sessions over.
+type PathPolicy uint8
+
+const (
+	// PreferLatency probes the TCP and UDP/KCP paths at once and keeps the
+	// one that answers first.
+	PreferLatency PathPolicy = iota + 1
+	// PreferReliability uses TCP, and only falls back to UDP/KCP if the TCP
+	// path cannot be established.
+	PreferReliability
+)
+
+// String returns the string representation of the policy.
+func (p PathPolicy) String() string {
+	switch p {
+	case PreferLatency:
+		return "PreferLatency"
+	case PreferReliability:
+		return "PreferReliability"
+	default:
+		return "Invalid"
+	}
+}
+
+type udpPath struct {
+	addr   string
+	opts   []ConnOption
+	policy PathPolicy
+}
+
+// pathProbe is a connection on one network path whose exchange, the first
+// round trip of the handshake, has completed, and how long it took.
+type pathProbe struct {
+	conn    Conn
+	channel *exchange.Channel
+	err     error
+	network string
+	rtt     time.Duration
+}
+
+// DialWithUDPPath tells the dialer that the server is also reachable over
+// UDP/KCP at addr, such as by a second [Server] configured with
+// [ServeWithUDP] that shares the first one's identity and storage. The
+// address the dialer was created with remains the TCP path. Each
+// [Dialer.Dial] picks one of the two according to policy, probing a path by
+// running the exchange on it, so that the peer is only introduced and
+// verified once; the losing connection is closed before that. The probe's
+// round trip is the session's first RTT sample, see [TransportStats], and
+// [Transport.Network] reports the path picked. [Dialer.Migrate] always
+// dials the TCP path.
+func DialWithUDPPath(
+	addr string, policy PathPolicy, opts ...ConnOption,
+) DialOption {
+	return func(d *Dialer) error {
+		if !udpSupported {
+			return ErrUDPUnsupported
+		}
+		if addr == "" {
+			return errors.New("udp path address must not be empty")
+		}
+		if policy != PreferLatency && policy != PreferReliability {
+			return fmt.Errorf("invalid path policy: %d", policy)
+		}
+		d.udpPath = &udpPath{addr: addr, opts: opts, policy: policy}
+		return nil
+	}
+}
+
+// Network returns the network, "tcp" or "udp", of the path that a dialer
+// configured with [DialWithUDPPath] picked for the session, or an empty
+// string if it had no choice to make.
+func (t *Transport) Network() string { return t.network }
+
+// dialPaths establishes a session over the path picked by d.udpPath's
+// policy.
+func (d *Dialer) dialPaths() (*Transport, error) {
+	var p pathProbe
+	switch d.udpPath.policy {
+	case PreferReliability:
+		p = d.probe("tcp")
+		if p.err != nil && !d.state.isClosed() {
+			tcpErr := p.err
+			p = d.probe("udp")
+			if p.err != nil {
+				p.err = errors.Join(tcpErr, p.err)
+			}
+		}
+	default:
+		p = d.race()
+	}
+	if p.err != nil {
+		if d.state.isClosed() {
+			return nil, ErrClosedDialer
+		}
+		return nil, fmt.Errorf("dialing: %w", p.err)
+	}
+	defer d.state.unwatch(p.conn)
+	slog.Debug(
+		"picked network path",
+		slog.String("network", p.network),
+		slog.Duration("rtt", p.rtt),
+	)
+
+	t, err := d.handshakeOver(p.conn, p.channel)
+	if err != nil {
+		p.conn.Close()
+		if d.state.isClosed() {
+			return nil, ErrClosedDialer
+		}
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	t.network = p.network
+	t.rtt.observe(p.rtt)
+	return t, nil
+}
+
+// race probes both paths at once and returns the first to answer. The other
+// is closed once its probe ends.
+func (d *Dialer) race() pathProbe {
+	results := make(chan pathProbe, 2)
+	for _, network := range []string{"tcp", "udp"} {
+		go func() { results <- d.probe(network) }()
+	}
+	first := <-results
+	if first.err != nil {
+		second := <-results
+		if second.err != nil {
+			second.err = errors.Join(first.err, second.err)
+		}
+		return second
+	}
+	go func() {
+		if loser := <-results; loser.err == nil {
+			d.state.unwatch(loser.conn)
+			loser.conn.Close()
+		}
+	}()
+	return first
+}
+
+// probe connects over network and runs the exchange, within the handshake
+// timeout. On success, the connection is watched by the dialer's state and
+// must be unwatched by the caller.
+func (d *Dialer) probe(network string) pathProbe {
+	p := pathProbe{network: network}
+	if network == "udp" {
+		p.conn, p.err = dialUDP(d.udpPath.addr, d.udpPath.opts)
+	} else {
+		p.conn, p.err = d.dial(d.address)
+	}
+	if p.err != nil {
+		return p
+	}
+	if !d.state.watch(p.conn) {
+		p.conn.Close()
+		p.err = ErrClosedDialer
+		return p
+	}
+
+	_ = p.conn.SetDeadline(time.Now().Add(d.handshakeOpts.timeout))
+	start := time.Now()
+	p.channel, p.err = exchange.Initiate(p.conn)
+	p.rtt = time.Since(start)
+	if p.err != nil {
+		d.state.unwatch(p.conn)
+		p.conn.Close()
+		p.err = fmt.Errorf("probing %s path: %w", network, p.err)
+	}
+	return p
+}
@@ -0,0 +1,70 @@
+package kamune
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransport_RTT(t *testing.T) {
+	a := require.New(t)
+	addr := startSessionServer(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, storePeer)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	a.Zero(tr.Stats().RTT)
+	a.Empty(tr.Network())
+
+	for i := range 3 {
+		_, err := tr.Send(Bytes([]byte{byte(i)}), RoutePing)
+		a.NoError(err)
+		md, err := tr.Receive(Bytes(nil))
+		a.NoError(err)
+		a.Equal(RoutePong, md.Route())
+	}
+	st := tr.Stats()
+	a.Len(st.RTTSamples, 3)
+	a.Positive(st.MinRTT)
+	a.LessOrEqual(st.MinRTT, st.RTT)
+	for _, s := range st.RTTSamples {
+		a.GreaterOrEqual(s, st.MinRTT)
+	}
+
+	// Pongs that echo no pending ping are not measured.
+	_, err = tr.Send(Bytes([]byte("unsolicited")), RoutePong)
+	a.NoError(err)
+	_, err = tr.Receive(Bytes(nil))
+	a.NoError(err)
+	a.Len(tr.Stats().RTTSamples, 3)
+}
+
+func TestRTTTracker(t *testing.T) {
+	a := require.New(t)
+	var r rttTracker
+	for _, ms := range []time.Duration{80, 40, 120} {
+		r.observe(ms * time.Millisecond)
+	}
+	var st TransportStats
+	r.snapshot(&st)
+	a.Equal(40*time.Millisecond, st.MinRTT)
+	// 80, then 80 + (40-80)/8 = 75, then 75 + (120-75)/8 = 80.625.
+	a.Equal(80625*time.Microsecond, st.RTT)
+
+	for i := range rttWindow + 4 {
+		r.observe(time.Duration(i))
+	}
+	r.snapshot(&st)
+	a.Len(st.RTTSamples, rttWindow)
+	a.Equal(time.Duration(rttWindow+3), st.RTTSamples[rttWindow-1])
+
+	// Unanswered pings are given up on once the limit is reached.
+	for i := range maxPendingPings + 1 {
+		r.sent(Bytes([]byte{byte(i)}))
+	}
+	a.Len(r.pending, maxPendingPings)
+}
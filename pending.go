@@ -0,0 +1,308 @@
+package kamune
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// PendingConnection is a connection from a peer that the server's verifiers
+// did not accept, held until the application decides on it with
+// [Server.AcceptPending] or [Server.RejectPending]. See
+// [ServeWithPendingConnections].
+type PendingConnection struct {
+	// Peer is the peer as read from its verified introduction.
+	Peer *storage.Peer
+	// Received is when the connection was queued, and Expires when it is
+	// turned away unless decided on before.
+	Received time.Time
+	Expires  time.Time
+	// ID identifies the connection to [Server.AcceptPending] and
+	// [Server.RejectPending].
+	ID string
+}
+
+// pendingQueue holds the connections waiting for the application's decision.
+// The goroutine serving each one blocks in wait, so the accept loop and the
+// other connections carry on meanwhile.
+type pendingQueue struct {
+	conns map[string]*pendingConn
+	// unseen holds the connections not yet returned by next, oldest first.
+	unseen []*pendingConn
+	// wake is closed, and replaced, when a connection is queued.
+	wake    chan struct{}
+	done    chan struct{}
+	timeout time.Duration
+	size    int
+	mu      sync.Mutex
+	closed  bool
+}
+
+type pendingConn struct {
+	info *PendingConnection
+	// decision receives the application's verdict: nil to accept, or the
+	// reason for rejecting.
+	decision chan error
+}
+
+func newPendingQueue(size int, timeout time.Duration) *pendingQueue {
+	return &pendingQueue{
+		conns:   make(map[string]*pendingConn),
+		wake:    make(chan struct{}),
+		done:    make(chan struct{}),
+		timeout: timeout,
+		size:    size,
+	}
+}
+
+// wait queues peer and blocks until the application accepts it, rejects it,
+// the timeout passes, or the server closes. It returns nil only if peer was
+// accepted.
+func (q *pendingQueue) wait(peer *storage.Peer) error {
+	now := time.Now()
+	c := &pendingConn{
+		info: &PendingConnection{
+			Peer:     peer,
+			Received: now,
+			Expires:  now.Add(q.timeout),
+			ID:       rand.Text(),
+		},
+		decision: make(chan error, 1),
+	}
+
+	q.mu.Lock()
+	switch {
+	case q.closed:
+		q.mu.Unlock()
+		return ErrClosedServer
+	case len(q.conns) >= q.size:
+		q.mu.Unlock()
+		return fmt.Errorf(
+			"%w: too many pending connections", ErrVerificationFailed,
+		)
+	}
+	q.conns[c.info.ID] = c
+	q.unseen = append(q.unseen, c)
+	close(q.wake)
+	q.wake = make(chan struct{})
+	q.mu.Unlock()
+
+	slog.Info(
+		"connection pending",
+		slog.String("id", c.info.ID),
+		slog.String("peer", peer.Name),
+	)
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-c.decision:
+		return err
+	case <-timer.C:
+	case <-q.done:
+	}
+
+	// A decision made meanwhile has removed the connection already and
+	// still counts.
+	if !q.remove(c.info.ID) {
+		return <-c.decision
+	}
+	select {
+	case <-q.done:
+		return ErrClosedServer
+	default:
+		return fmt.Errorf(
+			"%w: pending connection expired", ErrVerificationFailed,
+		)
+	}
+}
+
+// decide delivers the verdict on the connection with the given id.
+func (q *pendingQueue) decide(id string, verdict error) error {
+	q.mu.Lock()
+	c, ok := q.conns[id]
+	q.mu.Unlock()
+	if !ok || !q.remove(id) {
+		return fmt.Errorf("%w: %s", ErrPendingNotFound, id)
+	}
+	c.decision <- verdict
+	return nil
+}
+
+// remove drops the connection with the given id, reporting whether it was
+// still queued.
+func (q *pendingQueue) remove(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	c, ok := q.conns[id]
+	if !ok {
+		return false
+	}
+	delete(q.conns, id)
+	if i := slices.Index(q.unseen, c); i >= 0 {
+		q.unseen = slices.Delete(q.unseen, i, i+1)
+	}
+	return true
+}
+
+// next returns the oldest connection not yet returned, waiting for one to be
+// queued if there is none.
+func (q *pendingQueue) next(ctx context.Context) (*PendingConnection, error) {
+	for {
+		q.mu.Lock()
+		if len(q.unseen) > 0 {
+			c := q.unseen[0]
+			q.unseen = q.unseen[1:]
+			q.mu.Unlock()
+			info := *c.info
+			return &info, nil
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return nil, ErrClosedServer
+		}
+		wake := q.wake
+		q.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// list returns the queued connections, oldest first.
+func (q *pendingQueue) list() []PendingConnection {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	conns := make([]PendingConnection, 0, len(q.conns))
+	for _, c := range q.conns {
+		conns = append(conns, *c.info)
+	}
+	slices.SortFunc(conns, func(a, b PendingConnection) int {
+		return a.Received.Compare(b.Received)
+	})
+	return conns
+}
+
+// close turns away every queued connection and those queued later.
+func (q *pendingQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.done)
+	close(q.wake)
+}
+
+// ServeWithPendingConnections makes the server hold connections from peers
+// that its verifiers reject, instead of turning them away, until the
+// application decides on them. Applications learn of them with
+// [Server.NextPending] or [Server.Pending], for instance to ask their user
+// whether to accept an incoming connection, and decide with
+// [Server.AcceptPending] or [Server.RejectPending]; the [RemoteVerifier] then
+// only needs to accept known peers, and never blocks to ask. At most size
+// connections are held at once, and each for at most timeout, after which it
+// is turned away; the time spent waiting does not count towards the handshake
+// timeouts. Dialers must be willing to wait as long; see
+// [DialWithApprovalTimeout].
+//
+// Peers are held in place of being admitted as guests (see
+// [ServeWithGuests]). Blocked peers are still refused outright.
+func ServeWithPendingConnections(
+	size int, timeout time.Duration,
+) ServerOptions {
+	return func(s *Server) error {
+		if size <= 0 {
+			return errors.New("pending connection limit must be positive")
+		}
+		if timeout <= 0 {
+			return errors.New("pending connection timeout must be positive")
+		}
+		s.pending = newPendingQueue(size, timeout)
+		return nil
+	}
+}
+
+// holdPending holds peer, which the server's verifiers rejected, until the
+// application decides on it, and stores it as a known peer if accepted.
+// Time spent waiting is added to timer. It returns false, without error, if
+// the server does not hold connections.
+func (s *Server) holdPending(
+	peer *storage.Peer, timer *stepTimer,
+) (bool, error) {
+	if s.pending == nil {
+		return false, nil
+	}
+	// Waiting on the application needs no handshake slot.
+	timer.slot.release()
+	start := time.Now()
+	err := s.pending.wait(peer)
+	timer.extend(time.Since(start))
+	if err != nil {
+		return true, err
+	}
+	if err := timer.slot.reacquire(timer.current); err != nil {
+		return true, err
+	}
+	if _, err := s.storage.FindPeer(peer.PublicKey); err == nil {
+		return true, nil
+	}
+	return true, s.storage.StorePeer(peer)
+}
+
+// NextPending returns the next connection to be held for the application's
+// decision, waiting until one arrives or ctx is done. Each connection is
+// returned once; [Server.Pending] lists those still waiting. It returns
+// [ErrPendingDisabled] unless the server was created with
+// [ServeWithPendingConnections], and [ErrClosedServer] once the server is
+// closed.
+func (s *Server) NextPending(ctx context.Context) (*PendingConnection, error) {
+	if s.pending == nil {
+		return nil, ErrPendingDisabled
+	}
+	return s.pending.next(ctx)
+}
+
+// Pending returns the connections waiting for the application's decision,
+// oldest first.
+func (s *Server) Pending() []PendingConnection {
+	if s.pending == nil {
+		return nil
+	}
+	return s.pending.list()
+}
+
+// AcceptPending accepts the pending connection with the given id. Its peer is
+// stored as a known peer, as a [RemoteVerifier] accepting it would, and the
+// handshake carries on. It returns [ErrPendingNotFound] if the connection is
+// no longer waiting.
+func (s *Server) AcceptPending(id string) error {
+	if s.pending == nil {
+		return ErrPendingDisabled
+	}
+	return s.pending.decide(id, nil)
+}
+
+// RejectPending turns away the pending connection with the given id. The
+// reason is logged with the connection's error; the dialer only sees the
+// connection close, as with any rejection. It returns [ErrPendingNotFound]
+// if the connection is no longer waiting.
+func (s *Server) RejectPending(id, reason string) error {
+	if s.pending == nil {
+		return ErrPendingDisabled
+	}
+	return s.pending.decide(
+		id, fmt.Errorf("%w: rejected: %s", ErrVerificationFailed, reason),
+	)
+}
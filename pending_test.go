@@ -0,0 +1,161 @@
+package kamune
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+func TestPendingQueue(t *testing.T) {
+	a := require.New(t)
+	q := newPendingQueue(2, time.Minute)
+	ctx := context.Background()
+	peer := &storage.Peer{Name: "alice"}
+
+	// hold queues peer and returns what it was queued as, along with the
+	// eventual result of the wait.
+	hold := func(q *pendingQueue) (*PendingConnection, <-chan error) {
+		result := make(chan error, 1)
+		go func() { result <- q.wait(peer) }()
+		c, err := q.next(ctx)
+		a.NoError(err)
+		return c, result
+	}
+
+	accepted, acceptedErr := hold(q)
+	a.Equal("alice", accepted.Peer.Name)
+	rejected, rejectedErr := hold(q)
+	a.Len(q.list(), 2)
+	a.Equal(accepted.ID, q.list()[0].ID)
+
+	// The queue is full.
+	a.ErrorIs(q.wait(peer), ErrVerificationFailed)
+
+	a.NoError(q.decide(accepted.ID, nil))
+	a.NoError(<-acceptedErr)
+	a.NoError(q.decide(rejected.ID, ErrVerificationFailed))
+	a.ErrorIs(<-rejectedErr, ErrVerificationFailed)
+	a.ErrorIs(q.decide(accepted.ID, nil), ErrPendingNotFound)
+	a.Empty(q.list())
+
+	short := newPendingQueue(1, 10*time.Millisecond)
+	expired, expiredErr := hold(short)
+	a.ErrorIs(<-expiredErr, ErrVerificationFailed)
+	a.ErrorIs(short.decide(expired.ID, nil), ErrPendingNotFound)
+
+	_, closedErr := hold(q)
+	q.close()
+	a.ErrorIs(<-closedErr, ErrClosedServer)
+	a.ErrorIs(q.wait(peer), ErrClosedServer)
+	_, err := q.next(ctx)
+	a.ErrorIs(err, ErrClosedServer)
+}
+
+// knownPeers accepts the peers in storage.
+func knownPeers(s *storage.Storage, p *storage.Peer) error {
+	_, err := s.FindPeer(p.PublicKey)
+	return err
+}
+
+func TestServer_PendingConnections(t *testing.T) {
+	a := require.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	serverStore, cleanup := newTestStore(t)
+	defer cleanup()
+	srv, err := NewServer(
+		"", NewEchoHandler(), serverStore, knownPeers,
+		ServeWithListener(&tcpListener{Listener: l}),
+		ServeWithPendingConnections(4, 10*time.Second),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	type dialResult struct {
+		tr  *Transport
+		err error
+	}
+	// dial dials the server with store in the background.
+	dial := func(store *storage.Storage) <-chan dialResult {
+		d, err := NewDialer(
+			l.Addr().String(), store, storePeer,
+			DialWithApprovalTimeout(10*time.Second),
+		)
+		a.NoError(err)
+		result := make(chan dialResult, 1)
+		go func() {
+			tr, err := d.Dial()
+			result <- dialResult{tr: tr, err: err}
+		}()
+		return result
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	aliceStore, cleanup := newTestStore(t)
+	defer cleanup()
+	alice, err := aliceStore.Attester()
+	a.NoError(err)
+	result := dial(aliceStore)
+	pending, err := srv.NextPending(ctx)
+	a.NoError(err)
+	a.Equal(alice.MarshalPublicKey(), pending.Peer.PublicKey)
+	a.Equal([]PendingConnection{*pending}, srv.Pending())
+
+	// The accept loop carries on while the connection waits.
+	bobStore, cleanup := newTestStore(t)
+	defer cleanup()
+	rejected := dial(bobStore)
+	other, err := srv.NextPending(ctx)
+	a.NoError(err)
+	a.NoError(srv.RejectPending(other.ID, "not now"))
+	a.Error((<-rejected).err)
+
+	a.NoError(srv.AcceptPending(pending.ID))
+	r := <-result
+	a.NoError(r.err)
+	echo(t, r.tr, "hello")
+	a.NoError(r.tr.Close())
+	a.ErrorIs(srv.AcceptPending(pending.ID), ErrPendingNotFound)
+	a.Empty(srv.Pending())
+
+	// Accepted peers are stored, and so are not held again.
+	_, err = serverStore.FindPeer(alice.MarshalPublicKey())
+	a.NoError(err)
+	r = <-dial(aliceStore)
+	a.NoError(r.err)
+	defer r.tr.Close()
+	echo(t, r.tr, "again")
+}
+
+func TestServeWithPendingConnections(t *testing.T) {
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	for _, opt := range []ServerOptions{
+		ServeWithPendingConnections(0, time.Second),
+		ServeWithPendingConnections(1, 0),
+	} {
+		_, err := NewServer("", nil, store, acceptAll, opt)
+		a.Error(err)
+	}
+	_, err := NewDialer(
+		"127.0.0.1:1", store, acceptAll, DialWithApprovalTimeout(-1),
+	)
+	a.Error(err)
+
+	srv, err := NewServer("", nil, store, acceptAll)
+	a.NoError(err)
+	_, err = srv.NextPending(context.Background())
+	a.ErrorIs(err, ErrPendingDisabled)
+	a.ErrorIs(srv.AcceptPending("id"), ErrPendingDisabled)
+	a.ErrorIs(srv.RejectPending("id", "reason"), ErrPendingDisabled)
+	a.Empty(srv.Pending())
+}
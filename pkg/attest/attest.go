@@ -16,6 +16,16 @@ var (
 	ErrInvalidKey = errors.New("invalid key type")
 )
 
+// Attester signs on behalf of an identity and verifies signatures made by
+// others. [Attest] is the Ed25519 implementation used by the protocol;
+// functions that only sign, such as kamune.SignClaim, accept any Attester so
+// that they can be tested with a fake.
+type Attester interface {
+	Sign(msg []byte) ([]byte, error)
+	Verify(remote, msg, sig []byte) bool
+	MarshalPublicKey() []byte
+}
+
 // Attest represents the peer's identity.
 type Attest struct {
 	publicKey  ed25519.PublicKey
@@ -46,6 +56,12 @@ func (e Attest) MarshalPrivateKey() ([]byte, error) {
 	return x509.MarshalPKCS8PrivateKey(e.privateKey)
 }
 
+// Seed returns the seed of the private key, from which [FromSeed] recreates
+// the identity. It is as secret as the private key itself.
+func (e Attest) Seed() []byte {
+	return e.privateKey.Seed()
+}
+
 func New() (*Attest, error) {
 	public, private, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
@@ -54,6 +70,19 @@ func New() (*Attest, error) {
 	return &Attest{privateKey: private, publicKey: public}, nil
 }
 
+// FromSeed returns the identity whose private key is derived from seed, as
+// returned by [Attest.Seed].
+func FromSeed(seed []byte) (*Attest, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, ErrInvalidKey
+	}
+	private := ed25519.NewKeyFromSeed(seed)
+	return &Attest{
+		privateKey: private,
+		publicKey:  private.Public().(ed25519.PublicKey),
+	}, nil
+}
+
 func Load(data []byte) (*Attest, error) {
 	key, err := x509.ParsePKCS8PrivateKey(data)
 	if err != nil {
@@ -45,3 +45,20 @@ func TestAttest(t *testing.T) {
 		a.False(verified)
 	})
 }
+
+func TestFromSeed(t *testing.T) {
+	a := require.New(t)
+	e, err := New()
+	a.NoError(err)
+
+	restored, err := FromSeed(e.Seed())
+	a.NoError(err)
+	a.Equal(e.MarshalPublicKey(), restored.MarshalPublicKey())
+	msg := []byte("restored")
+	sig, err := restored.Sign(msg)
+	a.NoError(err)
+	a.True(Verify(e.MarshalPublicKey(), msg, sig))
+
+	_, err = FromSeed([]byte("short"))
+	a.ErrorIs(err, ErrInvalidKey)
+}
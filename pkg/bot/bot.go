@@ -0,0 +1,369 @@
+// Package bot provides a high-level handler API for writing kamune bots.
+//
+// A [Bot] runs the receive loop of each session, routes text messages that
+// start with a prefix ("/" by default) to the command handlers registered
+// with [Bot.Handle], and gives handlers a [Context] to reply through and a
+// per-peer [State] to keep data in. Middleware, rate limiting, and chat
+// history recording are configured once for all sessions:
+//
+//	b := bot.New(bot.WithStorage(store), bot.WithRateLimit(5, time.Second))
+//	b.Handle("echo", "repeat the arguments", func(c *bot.Context) error {
+//		return c.Reply(strings.Join(c.Args, " "))
+//	})
+//	srv, err := kamune.NewServer(addr, b.Serve, store, verifier)
+package bot
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamune-org/kamune"
+	"github.com/kamune-org/kamune/internal/clock"
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+var (
+	// ErrUnknownCommand is passed to the error handler when a message starts
+	// with the command prefix but names no registered command.
+	ErrUnknownCommand = errors.New("unknown command")
+	// ErrRateLimited is passed to the error handler when a peer sends
+	// messages faster than the limit set with [WithRateLimit]. The message
+	// is dropped.
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// Handler handles a command or, for the fallback, a plain text message.
+type Handler func(c *Context) error
+
+// Middleware wraps a handler, e.g. to log, authorize, or recover. It runs
+// for commands and for the fallback alike.
+type Middleware func(next Handler) Handler
+
+// ErrorHandler is called with the errors returned by handlers, and with
+// [ErrUnknownCommand] and [ErrRateLimited].
+type ErrorHandler func(c *Context, err error)
+
+// Option configures a [Bot].
+type Option func(*Bot)
+
+type command struct {
+	handler     Handler
+	description string
+}
+
+// peer is the state the bot keeps about a peer across its sessions.
+type peer struct {
+	state  *State
+	last   time.Time
+	tokens float64
+}
+
+// Bot routes the messages of kamune sessions to command handlers. It is safe
+// to serve any number of sessions concurrently, and to register commands
+// while serving.
+type Bot struct {
+	clock      clock.Clock
+	store      *storage.Storage
+	commands   map[string]command
+	peers      map[string]*peer
+	fallback   Handler
+	onError    ErrorHandler
+	prefix     string
+	middleware []Middleware
+	ratePer    time.Duration
+	rate       int
+	mu         sync.RWMutex
+	signed     bool
+}
+
+// New returns a bot with no commands. Besides the registered commands, it
+// answers "help" with a list of them, unless a "help" command is registered.
+func New(opts ...Option) *Bot {
+	b := &Bot{
+		clock:    clock.Real(),
+		commands: make(map[string]command),
+		peers:    make(map[string]*peer),
+		onError:  defaultErrorHandler,
+		prefix:   "/",
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	return b
+}
+
+// Handle registers h for the command name, replacing any previous handler.
+// Names are matched case-insensitively. The description is listed by the
+// built-in help command.
+func (b *Bot) Handle(name, description string, h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.commands[strings.ToLower(name)] = command{
+		handler:     h,
+		description: description,
+	}
+}
+
+// Use appends middleware. The first middleware added is the outermost.
+func (b *Bot) Use(mw ...Middleware) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.middleware = append(b.middleware, mw...)
+}
+
+// Serve runs the bot on t until the peer disconnects. It has the signature
+// of a [kamune.HandlerFunc], so a bot can be passed to [kamune.NewServer]
+// directly, and may also be run on a dialed transport. Pings are answered
+// with pongs, and messages on routes other than
+// [kamune.RouteExchangeMessages] are ignored.
+func (b *Bot) Serve(t *kamune.Transport) error {
+	record := b.store != nil && !t.IsGuest() && b.ensureSession(t)
+	for {
+		msg := kamune.Bytes(nil)
+		md, err := t.Receive(msg)
+		switch {
+		case err == nil: // continue
+		case errors.Is(err, kamune.ErrPeerDisconnected),
+			errors.Is(err, kamune.ErrConnClosed):
+			return nil
+		default:
+			return fmt.Errorf("receiving: %w", err)
+		}
+
+		switch md.Route() {
+		case kamune.RoutePing:
+			if _, err := t.Send(msg, kamune.RoutePong); err != nil {
+				return fmt.Errorf("sending pong: %w", err)
+			}
+			continue
+		case kamune.RouteExchangeMessages:
+		default:
+			continue
+		}
+
+		c := b.newContext(
+			t, md, string(msg.GetValue()), record && !md.Ephemeral(),
+		)
+		if c.record {
+			c.recordEntry(msg.GetValue(), md, storage.SenderPeer)
+		}
+		b.dispatch(c)
+	}
+}
+
+// ensureSession creates the session record that chat history is stored
+// under, storing the peer first if needed. It reports whether history can
+// be recorded.
+func (b *Bot) ensureSession(t *kamune.Transport) bool {
+	if _, err := b.store.GetPeer(t.SessionID()); err == nil {
+		return true
+	}
+	remote := t.RemotePeer()
+	if _, err := b.store.FindPeer(remote.PublicKey); err != nil {
+		if err := b.store.StorePeer(remote); err != nil {
+			slog.Warn(
+				"bot cannot record chat history",
+				slog.String("session_id", t.SessionID()),
+				slog.Any("error", err),
+			)
+			return false
+		}
+	}
+	err := b.store.CreateSession(t.SessionID(), remote.PublicKey)
+	if err != nil {
+		slog.Warn(
+			"bot cannot record chat history",
+			slog.String("session_id", t.SessionID()),
+			slog.Any("error", err),
+		)
+		return false
+	}
+	return true
+}
+
+func (b *Bot) newContext(
+	t *kamune.Transport, md *kamune.Metadata, text string, record bool,
+) *Context {
+	c := &Context{
+		Transport: t,
+		Metadata:  md,
+		Text:      text,
+		record:    record,
+		bot:       b,
+	}
+	if rest, ok := strings.CutPrefix(strings.TrimSpace(text), b.prefix); ok {
+		if fields := strings.Fields(rest); len(fields) > 0 {
+			c.Command = strings.ToLower(fields[0])
+			c.Args = fields[1:]
+		}
+	}
+	return c
+}
+
+// dispatch runs the handler for c through the middleware, and reports any
+// error to the error handler.
+func (b *Bot) dispatch(c *Context) {
+	p, allowed := b.admit(c.Transport.RemotePeer().PublicKey)
+	c.State = p.state
+	if !allowed {
+		b.onError(c, ErrRateLimited)
+		return
+	}
+
+	b.mu.RLock()
+	h := b.fallback
+	if c.Command != "" {
+		if cmd, ok := b.commands[c.Command]; ok {
+			h = cmd.handler
+		} else if c.Command == "help" {
+			h = b.help
+		} else {
+			h = unknownCommand
+		}
+	}
+	middleware := b.middleware
+	b.mu.RUnlock()
+	if h == nil {
+		return
+	}
+
+	for _, mw := range slices.Backward(middleware) {
+		h = mw(h)
+	}
+	if err := h(c); err != nil {
+		b.onError(c, err)
+	}
+}
+
+// admit returns the state of the peer with the given public key, and whether
+// the rate limit allows another message from it.
+func (b *Bot) admit(publicKey []byte) (*peer, bool) {
+	fp := fingerprint.Sum(publicKey)
+	now := b.clock.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.peers[fp]
+	if !ok {
+		p = &peer{
+			state:  newState(),
+			last:   now,
+			tokens: float64(b.rate),
+		}
+		b.peers[fp] = p
+	}
+	if b.rate <= 0 {
+		return p, true
+	}
+
+	// Token bucket: rate tokens are refilled per ratePer, up to rate.
+	refill := now.Sub(p.last).Seconds() / b.ratePer.Seconds() * float64(b.rate)
+	p.tokens = min(float64(b.rate), p.tokens+refill)
+	p.last = now
+	if p.tokens < 1 {
+		return p, false
+	}
+	p.tokens--
+	return p, true
+}
+
+// help lists the registered commands.
+func (b *Bot) help(c *Context) error {
+	b.mu.RLock()
+	names := slices.Sorted(maps.Keys(b.commands))
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		line := b.prefix + name
+		if desc := b.commands[name].description; desc != "" {
+			line += " - " + desc
+		}
+		lines = append(lines, line)
+	}
+	b.mu.RUnlock()
+
+	if len(lines) == 0 {
+		return c.Reply("No commands available.")
+	}
+	return c.Reply(strings.Join(lines, "\n"))
+}
+
+func unknownCommand(*Context) error { return ErrUnknownCommand }
+
+func defaultErrorHandler(c *Context, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownCommand):
+		_ = c.Replyf(
+			"Unknown command %q. Send %shelp for a list of commands.",
+			c.Command, c.bot.prefix,
+		)
+	case errors.Is(err, ErrRateLimited):
+		// Dropped silently, so that a flood is not answered by another.
+	default:
+		slog.Warn(
+			"bot handler failed",
+			slog.String("session_id", c.Transport.SessionID()),
+			slog.String("command", c.Command),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// WithPrefix sets the prefix that marks a message as a command. The default
+// is "/".
+func WithPrefix(prefix string) Option {
+	return func(b *Bot) { b.prefix = cmp.Or(prefix, "/") }
+}
+
+// WithFallback sets the handler for messages that are not commands. Without
+// one, such messages are ignored.
+func WithFallback(h Handler) Option {
+	return func(b *Bot) { b.fallback = h }
+}
+
+// WithErrorHandler replaces the default error handler, which answers unknown
+// commands, drops rate-limited messages, and logs handler errors.
+func WithErrorHandler(fn ErrorHandler) Option {
+	return func(b *Bot) { b.onError = fn }
+}
+
+// WithRateLimit allows each peer n messages per the given period, across all
+// of its sessions, with bursts of up to n. Messages over the limit are
+// dropped before reaching any handler or middleware.
+func WithRateLimit(n int, per time.Duration) Option {
+	return func(b *Bot) {
+		if n > 0 && per > 0 {
+			b.rate, b.ratePer = n, per
+		}
+	}
+}
+
+// WithStorage records the messages of every session, received and sent, in
+// store's chat history. Peers not yet known to store are stored, and a
+// session record is created for sessions that lack one. The messages of guest
+// sessions (see [kamune.ServeWithGuests]) are not recorded, and neither are
+// ephemeral messages (see [kamune.Transport.SendEphemeral]) and the replies
+// to them.
+func WithStorage(store *storage.Storage) Option {
+	return func(b *Bot) { b.store = store }
+}
+
+// WithSignedHistory makes the bot record the messages of [WithStorage] with
+// their signatures, so that exported history can be verified with
+// [kamune.VerifyTranscript], at the cost of about twice the space.
+func WithSignedHistory() Option {
+	return func(b *Bot) { b.signed = true }
+}
+
+// WithClock sets a custom clock for rate limiting. It is primarily useful for
+// tests.
+func WithClock(c clock.Clock) Option {
+	return func(b *Bot) { b.clock = c }
+}
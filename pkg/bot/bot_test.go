@@ -0,0 +1,278 @@
+package bot
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune"
+	"github.com/kamune-org/kamune/pkg/kamunetest"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+type tcpListener struct{ net.Listener }
+
+func (l tcpListener) Accept() (kamune.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return kamune.NewConn(c), nil
+}
+
+func newStore(t *testing.T) *storage.Storage {
+	t.Helper()
+	s, err := storage.OpenStorage(storage.WithInMemory())
+	require.New(t).NoError(err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// serveBot serves b with a new server and returns a function that opens a
+// session to it from the given client storage.
+func serveBot(
+	t *testing.T, b *Bot, store *storage.Storage,
+) func(client *storage.Storage) *kamune.Transport {
+	t.Helper()
+	a := require.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	srv, err := kamune.NewServer(
+		"", b.Serve, store, kamunetest.AcceptAll,
+		kamune.ServeWithListener(tcpListener{Listener: l}),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	return func(client *storage.Storage) *kamune.Transport {
+		d, err := kamune.NewDialer(
+			l.Addr().String(), client, kamunetest.AcceptAll,
+		)
+		a.NoError(err)
+		tr, err := d.Dial()
+		a.NoError(err)
+		t.Cleanup(func() { _ = tr.Close() })
+		return tr
+	}
+}
+
+func send(t *testing.T, tr *kamune.Transport, text string) {
+	t.Helper()
+	_, err := tr.Send(
+		kamune.Bytes([]byte(text)), kamune.RouteExchangeMessages,
+	)
+	require.New(t).NoError(err)
+}
+
+func ask(t *testing.T, tr *kamune.Transport, text string) string {
+	t.Helper()
+	send(t, tr, text)
+	reply := kamune.Bytes(nil)
+	_, err := tr.Receive(reply)
+	require.New(t).NoError(err)
+	return string(reply.GetValue())
+}
+
+func TestBot_Commands(t *testing.T) {
+	b := New(WithFallback(func(c *Context) error {
+		return c.Reply("you said: " + c.Text)
+	}))
+	b.Handle("echo", "repeat the arguments", func(c *Context) error {
+		return c.Reply(strings.Join(c.Args, " "))
+	})
+	b.Handle("fail", "", func(c *Context) error {
+		return errors.New("boom")
+	})
+	tr := serveBot(t, b, newStore(t))(newStore(t))
+
+	tests := []struct {
+		name  string
+		text  string
+		reply string
+		// silent messages get no reply; the next reply is the one to a
+		// following message.
+		silent bool
+	}{
+		{name: "command", text: "/echo hello  world", reply: "hello world"},
+		{name: "case insensitive", text: " /ECHO hi", reply: "hi"},
+		{name: "no arguments", text: "/echo", reply: ""},
+		{name: "plain text", text: "hi there", reply: "you said: hi there"},
+		{name: "bare prefix", text: "/", reply: "you said: /"},
+		{
+			name:  "unknown",
+			text:  "/nope",
+			reply: `Unknown command "nope". Send /help for a list of commands.`,
+		},
+		{
+			name:  "help",
+			text:  "/help",
+			reply: "/echo - repeat the arguments\n/fail",
+		},
+		// A failing handler is logged, not answered.
+		{name: "error", text: "/fail", silent: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			if tc.silent {
+				send(t, tr, tc.text)
+				a.Equal("after", ask(t, tr, "/echo after"))
+				return
+			}
+			a.Equal(tc.reply, ask(t, tr, tc.text))
+		})
+	}
+}
+
+func TestBot_Middleware(t *testing.T) {
+	a := require.New(t)
+	b := New(WithPrefix("!"))
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(c *Context) error {
+				c.Args = append(c.Args, name)
+				return next(c)
+			}
+		}
+	}
+	b.Use(trace("outer"), trace("inner"))
+	b.Use(func(next Handler) Handler {
+		return func(c *Context) error {
+			if c.Command == "admin" {
+				return c.Reply("denied")
+			}
+			return next(c)
+		}
+	})
+	b.Handle("trace", "", func(c *Context) error {
+		return c.Reply(strings.Join(c.Args, ","))
+	})
+	b.Handle("admin", "", func(c *Context) error {
+		return c.Reply("granted")
+	})
+	tr := serveBot(t, b, newStore(t))(newStore(t))
+
+	a.Equal("outer,inner", ask(t, tr, "!trace"))
+	a.Equal("denied", ask(t, tr, "!admin"))
+}
+
+func TestBot_State(t *testing.T) {
+	a := require.New(t)
+	b := New()
+	b.Handle("count", "", func(c *Context) error {
+		n, _ := c.State.Get("count")
+		count, _ := n.(int)
+		count++
+		c.State.Set("count", count)
+		return c.Reply(strconv.Itoa(count))
+	})
+	dial := serveBot(t, b, newStore(t))
+
+	alice := newStore(t)
+	a.Equal("1", ask(t, dial(alice), "/count"))
+	// The state outlives the session.
+	a.Equal("2", ask(t, dial(alice), "/count"))
+	// Every peer has its own.
+	a.Equal("1", ask(t, dial(newStore(t)), "/count"))
+}
+
+// syncClock is a fake clock that is safe to advance while the bot reads it.
+type syncClock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+func (c *syncClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *syncClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestBot_RateLimit(t *testing.T) {
+	a := require.New(t)
+	clk := &syncClock{now: time.Now()}
+	limited := make(chan string, 4)
+	b := New(
+		WithClock(clk),
+		WithRateLimit(2, time.Minute),
+		WithErrorHandler(func(c *Context, err error) {
+			if errors.Is(err, ErrRateLimited) {
+				limited <- c.Text
+			}
+		}),
+	)
+	b.Handle("ping", "", func(c *Context) error {
+		return c.Reply("pong " + strings.Join(c.Args, ""))
+	})
+	dial := serveBot(t, b, newStore(t))
+	client := newStore(t)
+	tr := dial(client)
+
+	a.Equal("pong 1", ask(t, tr, "/ping 1"))
+	a.Equal("pong 2", ask(t, tr, "/ping 2"))
+	send(t, tr, "/ping 3")
+	a.Equal("/ping 3", <-limited)
+	// The limit is per peer, not per session.
+	send(t, dial(client), "/ping 4")
+	a.Equal("/ping 4", <-limited)
+
+	clk.Advance(30 * time.Second)
+	a.Equal("pong 5", ask(t, tr, "/ping 5"))
+	send(t, tr, "/ping 6")
+	a.Equal("/ping 6", <-limited)
+}
+
+func TestBot_Storage(t *testing.T) {
+	a := require.New(t)
+	store := newStore(t)
+	b := New(WithStorage(store))
+	b.Handle("echo", "", func(c *Context) error {
+		return c.Reply(strings.Join(c.Args, " "))
+	})
+	tr := serveBot(t, b, store)(newStore(t))
+
+	a.Equal("hello", ask(t, tr, "/echo hello"))
+	// Ephemeral messages are answered off the record.
+	_, err := tr.SendEphemeral(
+		kamune.Bytes([]byte("/echo secret")), kamune.RouteExchangeMessages,
+	)
+	a.NoError(err)
+	reply := kamune.Bytes(nil)
+	md, err := tr.Receive(reply)
+	a.NoError(err)
+	a.Equal("secret", string(reply.GetValue()))
+	a.True(md.Ephemeral())
+	a.Equal("world", ask(t, tr, "/echo world"))
+
+	// The last reply is recorded once it has been sent.
+	var history []storage.ChatEntry
+	a.Eventually(func() bool {
+		var err error
+		history, err = store.GetChatHistory(tr.SessionID())
+		return err == nil && len(history) == 4
+	}, time.Second, 10*time.Millisecond)
+	var got []string
+	for _, e := range history {
+		got = append(got, string(e.Data))
+	}
+	a.ElementsMatch(
+		[]string{"/echo hello", "hello", "/echo world", "world"}, got,
+	)
+	peer, err := store.GetPeer(tr.SessionID())
+	a.NoError(err)
+	a.NotNil(peer)
+}
@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/kamune-org/kamune"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// Context carries a received message to its handler.
+type Context struct {
+	// Transport is the session the message arrived on.
+	Transport *kamune.Transport
+	// Metadata is the metadata of the message.
+	Metadata *kamune.Metadata
+	// State is the sender's state, shared by all of its sessions.
+	State *State
+	bot   *Bot
+	// Command is the lowercased command name without the prefix, or empty
+	// for a plain text message.
+	Command string
+	// Text is the full text of the message.
+	Text string
+	// Args are the whitespace-separated words following the command.
+	Args   []string
+	record bool
+}
+
+// Peer returns the sender of the message.
+func (c *Context) Peer() *storage.Peer { return c.Transport.RemotePeer() }
+
+// Reply sends text to the sender. Replies to an ephemeral message are
+// ephemeral too; see [kamune.Transport.SendEphemeral].
+func (c *Context) Reply(text string) error {
+	send := c.Transport.Send
+	if c.Metadata.Ephemeral() {
+		send = c.Transport.SendEphemeral
+	}
+	md, err := send(kamune.Bytes([]byte(text)), kamune.RouteExchangeMessages)
+	if err != nil {
+		return fmt.Errorf("replying: %w", err)
+	}
+	if c.record {
+		c.recordEntry([]byte(text), md, storage.SenderLocal)
+	}
+	return nil
+}
+
+// Replyf formats according to a format specifier and sends the result to the
+// sender.
+func (c *Context) Replyf(format string, args ...any) error {
+	return c.Reply(fmt.Sprintf(format, args...))
+}
+
+func (c *Context) recordEntry(
+	payload []byte, md *kamune.Metadata, sender storage.Sender,
+) {
+	sessionID, ts := c.Transport.SessionID(), md.Timestamp()
+	hlc := uint64(md.HybridTime())
+	var err error
+	if sig := md.Signature(); c.bot.signed && sig != nil {
+		err = c.bot.store.AddSignedChatEntry(
+			sessionID, payload, ts, hlc, sender, *sig,
+		)
+	} else {
+		err = c.bot.store.AddChatEntryWithClock(
+			sessionID, payload, ts, hlc, sender,
+		)
+	}
+	if err != nil {
+		slog.Warn(
+			"failed to record chat entry",
+			slog.String("session_id", sessionID),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// State holds arbitrary per-peer values, such as the step of a conversation
+// or user preferences, for the lifetime of the [Bot]. It is safe for
+// concurrent use.
+type State struct {
+	values map[string]any
+	mu     sync.Mutex
+}
+
+func newState() *State {
+	return &State{values: make(map[string]any)}
+}
+
+// Get returns the value stored under key.
+func (s *State) Get(key string) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// Set stores value under key.
+func (s *State) Set(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+}
+
+// Delete removes the value stored under key.
+func (s *State) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
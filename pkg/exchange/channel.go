@@ -28,15 +28,18 @@ type Channel struct {
 	conn      ReadWriter
 	sender    *hpke.Sender
 	recipient *hpke.Recipient
+	initiator bool
 }
 
 func newChannel(
 	conn ReadWriter, sender *hpke.Sender, recipient *hpke.Recipient,
+	initiator bool,
 ) *Channel {
 	return &Channel{
 		conn:      conn,
 		sender:    sender,
 		recipient: recipient,
+		initiator: initiator,
 	}
 }
 
@@ -65,6 +68,17 @@ func (ch *Channel) WriteBytes(data []byte) error {
 	return nil
 }
 
+// Export derives a secret of length bytes that both ends of the channel
+// compute alike and no other channel shares, from the HPKE context of the
+// initiator's direction. exporterContext separates its uses. It binds proofs
+// made over the channel to it, so that they cannot be replayed over another.
+func (ch *Channel) Export(exporterContext string, length int) ([]byte, error) {
+	if ch.initiator {
+		return ch.sender.Export(exporterContext, length)
+	}
+	return ch.recipient.Export(exporterContext, length)
+}
+
 func (ch *Channel) Close() error {
 	if c, ok := ch.conn.(io.Closer); ok {
 		return c.Close()
@@ -126,7 +140,7 @@ func Initiate(c ReadWriter) (*Channel, error) {
 		return nil, fmt.Errorf("writing ciphertext: %w", err)
 	}
 
-	return newChannel(c, sender, recipient), nil
+	return newChannel(c, sender, recipient, true), nil
 }
 
 func Accept(c ReadWriter) (*Channel, error) {
@@ -170,5 +184,5 @@ func Accept(c ReadWriter) (*Channel, error) {
 		return nil, fmt.Errorf("creating recipient: %w", err)
 	}
 
-	return newChannel(c, sender, recipient), nil
+	return newChannel(c, sender, recipient, false), nil
 }
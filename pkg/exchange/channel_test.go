@@ -0,0 +1,57 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// chanRW is one end of an in-memory message pipe.
+type chanRW struct {
+	in  <-chan []byte
+	out chan<- []byte
+}
+
+func (c chanRW) ReadBytes() ([]byte, error) { return <-c.in, nil }
+func (c chanRW) WriteBytes(b []byte) error  { c.out <- b; return nil }
+
+func newChannelPair(t *testing.T) (initiator, responder *Channel) {
+	t.Helper()
+	a := require.New(t)
+	toResponder, toInitiator := make(chan []byte, 4), make(chan []byte, 4)
+	accepted := make(chan *Channel, 1)
+	go func() {
+		ch, err := Accept(chanRW{in: toResponder, out: toInitiator})
+		a.NoError(err)
+		accepted <- ch
+	}()
+	initiator, err := Initiate(chanRW{in: toInitiator, out: toResponder})
+	a.NoError(err)
+	return initiator, <-accepted
+}
+
+func TestChannel_Export(t *testing.T) {
+	a := require.New(t)
+	i1, r1 := newChannelPair(t)
+	i2, _ := newChannelPair(t)
+
+	e1, err := i1.Export("test", 32)
+	a.NoError(err)
+	a.Len(e1, 32)
+	r, err := r1.Export("test", 32)
+	a.NoError(err)
+	a.Equal(e1, r, "both ends derive the same secret")
+
+	other, err := i1.Export("other", 32)
+	a.NoError(err)
+	a.NotEqual(e1, other)
+	e2, err := i2.Export("test", 32)
+	a.NoError(err)
+	a.NotEqual(e1, e2, "channels do not share secrets")
+
+	msg := []byte("hello")
+	a.NoError(i1.WriteBytes(msg))
+	got, err := r1.ReadBytes()
+	a.NoError(err)
+	a.Equal(msg, got)
+}
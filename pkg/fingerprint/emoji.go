@@ -3,35 +3,154 @@ package fingerprint
 import (
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
+	"slices"
+	"strings"
 )
 
-var emojiList = []string{
-	"😎", "👻", "👍", "👑", "🎃", "🎯", "🧬", "🧨",
-	"🐶", "🐱", "🦁", "🐹", "🐰", "🦊", "🐻", "🐼",
-	"🌸", "🥁", "🪷", "🌹", "🪩", "🍁", "🌳", "🌵",
-	"🍎", "🍌", "🍇", "🍓", "🥝", "🍕", "🍔", "🍟",
-	"☕️", "🍦", "🥕", "☀️", "🌙", "❄️", "☁️", "🧂",
-	"💡", "🎹", "💎", "📷", "🏀", "🎮", "🎲", "🎩",
-	"❤️", "🎁", "⏰", "🧩", "🧲", "🔑", "🚗️", "🚀",
-	"✨", "🔥", "🌈", "🎉", "🎶", "🔒", "📌", "✅",
-	"🤖", "🪐", "🦴", "🍩", "🎪", "🔮", "⛱️", "👽",
-	"🦄", "🐧", "🦋", "🐙", "🦈", "🦅", "🦀", "🪲",
-	"🌻", "🍀", "🌊", "⛰️", "🍄", "🌋", "🌪️", "🥑",
-	"🎸", "🔭", "🧭", "🎨", "⚡", "🗝️", "🧿", "🛡️",
+// emojiCount is the number of emojis in every [EmojiSet]. Fingerprints pick
+// indices into a set, so that all sets render the same key at the same
+// positions.
+const emojiCount = 96
+
+// ErrNoCommonEmojiSet is returned by [NegotiateEmojiSet] when the two sides
+// share no emoji set.
+var ErrNoCommonEmojiSet = errors.New("no common emoji set")
+
+// EmojiSet is a list of emojis that fingerprints are rendered with. Both
+// sides of a verbal verification must use the same set, since sets differ in
+// the emojis they show for the same key; see [NegotiateEmojiSet].
+type EmojiSet struct {
+	// ID names the set and the version of its mapping from indices to
+	// emojis. It changes whenever any emoji does.
+	ID     string
+	emojis [emojiCount]string
+}
+
+var (
+	// EmojiStandard is the default set.
+	EmojiStandard = EmojiSet{
+		ID: "standard/1",
+		emojis: [emojiCount]string{
+			"😎", "👻", "👍", "👑", "🎃", "🎯", "🧬", "🧨",
+			"🐶", "🐱", "🦁", "🐹", "🐰", "🦊", "🐻", "🐼",
+			"🌸", "🥁", "🪷", "🌹", "🪩", "🍁", "🌳", "🌵",
+			"🍎", "🍌", "🍇", "🍓", "🥝", "🍕", "🍔", "🍟",
+			"☕️", "🍦", "🥕", "☀️", "🌙", "❄️", "☁️", "🧂",
+			"💡", "🎹", "💎", "📷", "🏀", "🎮", "🎲", "🎩",
+			"❤️", "🎁", "⏰", "🧩", "🧲", "🔑", "🚗️", "🚀",
+			"✨", "🔥", "🌈", "🎉", "🎶", "🔒", "📌", "✅",
+			"🤖", "🪐", "🦴", "🍩", "🎪", "🔮", "⛱️", "👽",
+			"🦄", "🐧", "🦋", "🐙", "🦈", "🦅", "🦀", "🪲",
+			"🌻", "🍀", "🌊", "⛰️", "🍄", "🌋", "🌪️", "🥑",
+			"🎸", "🔭", "🧭", "🎨", "⚡", "🗝️", "🧿", "🛡️",
+		},
+	}
+	// EmojiBasic only has emojis from Unicode 6.0 or earlier that are
+	// single code points shown as emoji by default, for platforms and
+	// terminals that render EmojiStandard poorly. It keeps the emojis of
+	// EmojiStandard that qualify at the same positions.
+	EmojiBasic = EmojiSet{
+		ID: "basic/1",
+		emojis: [emojiCount]string{
+			"😎", "👻", "👍", "👑", "🎃", "🎯", "🔬", "💣",
+			"🐶", "🐱", "🐯", "🐹", "🐰", "🐺", "🐻", "🐼",
+			"🌸", "🎺", "🌷", "🌹", "💿", "🍁", "🌳", "🌵",
+			"🍎", "🍌", "🍇", "🍓", "🍑", "🍕", "🍔", "🍟",
+			"☕", "🍦", "🌽", "🌞", "🌙", "⛄", "⛅", "🍯",
+			"💡", "🎹", "💎", "📷", "🏀", "🎮", "🎲", "🎩",
+			"💖", "🎁", "⏰", "🎳", "🔧", "🔑", "🚗", "🚀",
+			"✨", "🔥", "🌈", "🎉", "🎶", "🔒", "📌", "✅",
+			"👾", "🌍", "🍖", "🍩", "🎪", "🔮", "☔", "👽",
+			"🐴", "🐧", "🐛", "🐙", "🐳", "🐦", "🐚", "🐞",
+			"🌻", "🍀", "🌊", "🗻", "🍄", "🌋", "🌀", "🍆",
+			"🎸", "🔭", "🔦", "🎨", "⚡", "⚓", "👀", "🏰",
+		},
+	}
+)
+
+// emojiSets lists the known sets, in the order [NegotiateEmojiSet] prefers
+// them.
+var emojiSets = []EmojiSet{EmojiStandard, EmojiBasic}
+
+// EmojiSets returns the IDs of the known emoji sets, the default first.
+func EmojiSets() []string {
+	ids := make([]string, len(emojiSets))
+	for i, s := range emojiSets {
+		ids[i] = s.ID
+	}
+	return ids
+}
+
+// LookupEmojiSet returns the emoji set with the given ID, if it is known.
+func LookupEmojiSet(id string) (EmojiSet, bool) {
+	for _, s := range emojiSets {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return EmojiSet{}, false
 }
 
-// Emoji returns 8 emojis deterministically derived from the input bytes.
-// There are 96 distinct emojis, giving 96⁸ ≈ 7.2 quadrillion possible
-// combinations (~50% collision at 100 million sessions).
-func Emoji(s []byte) []string {
-	hash := sha256.Sum256(s)
-	offset := 0
-	l := uint32(len(emojiList))
-	emojis := make([]string, 8)
-	for i := range 8 {
-		offset = i * 4
-		num := binary.BigEndian.Uint32(hash[offset : offset+4])
-		emojis[i] = emojiList[num%l]
+// NegotiateEmojiSet returns the set both sides should render fingerprints
+// with, given the IDs of the sets each supports: the first known set, in the
+// order of [EmojiSets], that both list. The result does not depend on which
+// side is local, so each side finds the same set on its own. A side that
+// lists no sets, such as a peer that predates them, supports only
+// EmojiStandard.
+func NegotiateEmojiSet(local, remote []string) (EmojiSet, error) {
+	if len(local) == 0 {
+		local = []string{EmojiStandard.ID}
+	}
+	if len(remote) == 0 {
+		remote = []string{EmojiStandard.ID}
+	}
+	for _, s := range emojiSets {
+		if slices.Contains(local, s.ID) && slices.Contains(remote, s.ID) {
+			return s, nil
+		}
+	}
+	return EmojiSet{}, ErrNoCommonEmojiSet
+}
+
+// FormatEmojiSets encodes a list of set IDs as a single comma-separated
+// value, such as for introduction metadata.
+func FormatEmojiSets(ids []string) string { return strings.Join(ids, ",") }
+
+// ParseEmojiSets is the inverse of [FormatEmojiSets]. Empty IDs are dropped.
+func ParseEmojiSets(s string) []string {
+	var ids []string
+	for id := range strings.SplitSeq(s, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Emoji returns 8 emojis from s deterministically derived from the input
+// bytes. There are 96 distinct emojis, giving 96⁸ ≈ 7.2 quadrillion
+// possible combinations (~50% collision at 100 million sessions).
+func (s EmojiSet) Emoji(b []byte) []string {
+	indices := emojiIndices(b)
+	emojis := make([]string, len(indices))
+	for i, idx := range indices {
+		emojis[i] = s.emojis[idx]
 	}
 	return emojis
 }
+
+// Emoji returns the fingerprint of s rendered with [EmojiStandard].
+func Emoji(s []byte) []string { return EmojiStandard.Emoji(s) }
+
+// emojiIndices returns the positions, in any set, of the 8 emojis derived
+// from b. They are the mapping every set shares, and must never change.
+func emojiIndices(b []byte) [8]int {
+	hash := sha256.Sum256(b)
+	var indices [8]int
+	for i := range indices {
+		num := binary.BigEndian.Uint32(hash[i*4 : i*4+4])
+		indices[i] = int(num % emojiCount)
+	}
+	return indices
+}
@@ -2,9 +2,11 @@ package fingerprint
 
 import (
 	"encoding/base64"
+	"encoding/binary"
 	"strconv"
 	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/require"
 )
@@ -25,14 +27,27 @@ func TestBase64(t *testing.T) {
 }
 
 func TestEmojiListDistinct(t *testing.T) {
-	a := require.New(t)
+	for _, set := range emojiSets {
+		t.Run(set.ID, func(t *testing.T) {
+			a := require.New(t)
+			seen := make(map[string]bool, len(set.emojis))
+			for _, e := range set.emojis {
+				a.NotEmpty(e)
+				a.False(seen[e], "duplicate emoji: %s", e)
+				seen[e] = true
+			}
+		})
+	}
+}
 
-	a.Len(emojiList, 96)
-	seen := make(map[string]bool, len(emojiList))
-	for _, e := range emojiList {
-		a.NotEmpty(e)
-		a.False(seen[e], "duplicate emoji: %s", e)
-		seen[e] = true
+func TestEmojiBasicSingleCodePoint(t *testing.T) {
+	a := require.New(t)
+	for i, e := range EmojiBasic.emojis {
+		a.Equal(1, utf8.RuneCountInString(e), "emoji %d: %q", i, e)
+		// The blocks after Transport and Map Symbols' first emojis only
+		// came with later versions of Unicode.
+		r, _ := utf8.DecodeRuneInString(e)
+		a.Less(r, rune(0x1F6C6), "emoji %d: %q", i, e)
 	}
 }
 
@@ -43,7 +58,7 @@ func TestEmoji(t *testing.T) {
 	emojis := Emoji(input)
 	a.Len(emojis, 8)
 	for _, e := range emojis {
-		a.Contains(emojiList, e)
+		a.Contains(EmojiStandard.emojis[:], e)
 	}
 
 	// Same input should give same result
@@ -53,6 +68,115 @@ func TestEmoji(t *testing.T) {
 	// Different input different result (likely)
 	emojis3 := Emoji([]byte("different"))
 	a.NotEqual(emojis, emojis3)
+
+	// The mapping must never change, or peers on different versions would
+	// see different fingerprints.
+	a.Equal(
+		[]string{"🧬", "🔥", "✅", "🎶", "🌻", "🔑", "🪐", "📌"},
+		Emoji([]byte("kamune")),
+	)
+	a.Equal(
+		[]string{"🔬", "🔥", "✅", "🎶", "🌻", "🔑", "🌍", "📌"},
+		EmojiBasic.Emoji([]byte("kamune")),
+	)
+}
+
+func TestEmojiCollisions(t *testing.T) {
+	a := require.New(t)
+
+	// With 96⁸ fingerprints, 100k keys collide with a probability of about
+	// one in a million.
+	const keys = 100_000
+	seen := make(map[[8]int]int, keys)
+	var counts [emojiCount]int
+	key := make([]byte, 32)
+	for i := range keys {
+		binary.BigEndian.PutUint64(key, uint64(i))
+		indices := emojiIndices(key)
+		prev, ok := seen[indices]
+		a.False(ok, "keys %d and %d collide", prev, i)
+		seen[indices] = i
+		for _, idx := range indices {
+			counts[idx]++
+		}
+	}
+
+	// Every emoji is used about equally often.
+	want := keys * 8 / emojiCount
+	for i, n := range counts {
+		a.InDelta(want, n, float64(want)/10, "emoji %d", i)
+	}
+
+	// Every set renders distinct keys as distinct fingerprints.
+	for _, set := range emojiSets {
+		a.NotEqual(set.Emoji([]byte("a")), set.Emoji([]byte("b")))
+	}
+}
+
+func TestNegotiateEmojiSet(t *testing.T) {
+	standard, basic := EmojiStandard.ID, EmojiBasic.ID
+	tests := []struct {
+		name   string
+		local  []string
+		remote []string
+		want   EmojiSet
+		err    error
+	}{
+		{name: "legacy peers", want: EmojiStandard},
+		{
+			name:   "legacy remote",
+			local:  []string{basic, standard},
+			remote: nil,
+			want:   EmojiStandard,
+		},
+		{
+			name:   "both",
+			local:  []string{basic, standard},
+			remote: []string{standard, basic},
+			want:   EmojiStandard,
+		},
+		{
+			name:   "basic only",
+			local:  []string{basic},
+			remote: []string{standard, basic},
+			want:   EmojiBasic,
+		},
+		{
+			name:   "unknown",
+			local:  []string{"future/1", basic},
+			remote: []string{"future/1", basic},
+			want:   EmojiBasic,
+		},
+		{
+			name:   "none in common",
+			local:  []string{basic},
+			remote: []string{standard},
+			err:    ErrNoCommonEmojiSet,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			got, err := NegotiateEmojiSet(tc.local, tc.remote)
+			a.ErrorIs(err, tc.err)
+			a.Equal(tc.want, got)
+			// Both sides reach the same set.
+			got, err = NegotiateEmojiSet(tc.remote, tc.local)
+			a.ErrorIs(err, tc.err)
+			a.Equal(tc.want, got)
+		})
+	}
+
+	a := require.New(t)
+	a.Equal(EmojiSets(), ParseEmojiSets(FormatEmojiSets(EmojiSets())))
+	a.Equal([]string{basic}, ParseEmojiSets(" , basic/1,"))
+	for _, id := range EmojiSets() {
+		set, ok := LookupEmojiSet(id)
+		a.True(ok)
+		a.Equal(id, set.ID)
+	}
+	_, ok := LookupEmojiSet("future/1")
+	a.False(ok)
 }
 
 func TestHex(t *testing.T) {
@@ -0,0 +1,73 @@
+package kamunetest
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"slices"
+	"sync"
+
+	"github.com/kamune-org/kamune/pkg/attest"
+)
+
+var _ attest.Attester = (*Attester)(nil)
+
+// Attester is a fake [attest.Attester]. Its public key is an arbitrary
+// string, and a signature is the SHA-256 digest of the public key and the
+// message, which anyone can forge. Its signatures are only accepted by
+// [Attester.Verify], not by [attest.Verify] or a real peer.
+type Attester struct {
+	err       error
+	publicKey []byte
+	signed    [][]byte
+	mu        sync.Mutex
+}
+
+// NewAttester returns an attester whose public key is name.
+func NewAttester(name string) *Attester {
+	return &Attester{publicKey: []byte(name)}
+}
+
+// Sign records msg and returns its signature, or the error given to
+// [Attester.FailSigning].
+func (a *Attester) Sign(msg []byte) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return nil, a.err
+	}
+	a.signed = append(a.signed, slices.Clone(msg))
+	return digest(a.publicKey, msg), nil
+}
+
+// Verify reports whether sig is a signature of msg by the fake attester
+// whose public key is remote.
+func (a *Attester) Verify(remote, msg, sig []byte) bool {
+	return subtle.ConstantTimeCompare(digest(remote, msg), sig) == 1
+}
+
+// MarshalPublicKey returns the attester's public key.
+func (a *Attester) MarshalPublicKey() []byte {
+	return slices.Clone(a.publicKey)
+}
+
+// Signed returns the messages signed so far, oldest first.
+func (a *Attester) Signed() [][]byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.signed)
+}
+
+// FailSigning makes every later signature fail with err, or stops failing
+// them if err is nil.
+func (a *Attester) FailSigning(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.err = err
+}
+
+func digest(publicKey, msg []byte) []byte {
+	h := sha256.New()
+	h.Write(publicKey)
+	h.Write(msg)
+	return h.Sum(nil)
+}
@@ -0,0 +1,219 @@
+package kamunetest
+
+import (
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kamune-org/kamune"
+)
+
+// Conn is a fake [kamune.Conn]. Reads return the frames given to
+// [Conn.Feed], in order, and block while there are none; writes are recorded
+// and returned by [Conn.Written]. Deadlines are honoured. The zero value is
+// not usable; create one with [NewConn].
+type Conn struct {
+	deadline time.Time
+	readErr  error
+	writeErr error
+	ready    chan struct{}
+	done     chan struct{}
+	incoming [][]byte
+	written  [][]byte
+	mu       sync.Mutex
+	closed   bool
+}
+
+// NewConn returns an open [Conn] with nothing to read.
+func NewConn() *Conn {
+	return &Conn{
+		ready: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+}
+
+// Feed queues frames to be returned by [Conn.ReadBytes].
+func (c *Conn) Feed(frames ...[]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range frames {
+		c.incoming = append(c.incoming, slices.Clone(f))
+	}
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Written returns the frames written so far, oldest first.
+func (c *Conn) Written() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.written)
+}
+
+// FailReads makes every later read fail with err, or stops failing them if
+// err is nil.
+func (c *Conn) FailReads(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readErr = err
+}
+
+// FailWrites makes every later write fail with err, or stops failing them if
+// err is nil.
+func (c *Conn) FailWrites(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeErr = err
+}
+
+// Closed reports whether [Conn.Close] has been called.
+func (c *Conn) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// ReadBytes returns the next fed frame. It fails with [kamune.ErrConnClosed]
+// once the connection is closed, and with [os.ErrDeadlineExceeded] if the
+// deadline passes first.
+func (c *Conn) ReadBytes() ([]byte, error) {
+	for {
+		c.mu.Lock()
+		switch {
+		case c.readErr != nil:
+			c.mu.Unlock()
+			return nil, c.readErr
+		case c.closed:
+			c.mu.Unlock()
+			return nil, kamune.ErrConnClosed
+		case len(c.incoming) > 0:
+			f := c.incoming[0]
+			c.incoming = c.incoming[1:]
+			c.mu.Unlock()
+			return f, nil
+		}
+		deadline := c.deadline
+		c.mu.Unlock()
+
+		if !c.wait(deadline) {
+			return nil, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// wait blocks until frames are fed or the connection is closed, and reports
+// false if deadline passes first.
+func (c *Conn) wait(deadline time.Time) bool {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-c.ready:
+	case <-c.done:
+	case <-timeout:
+		return false
+	}
+	return true
+}
+
+// WriteBytes records b.
+func (c *Conn) WriteBytes(b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.writeErr != nil:
+		return c.writeErr
+	case c.closed:
+		return kamune.ErrConnClosed
+	}
+	c.written = append(c.written, slices.Clone(b))
+	return nil
+}
+
+// SetDeadline sets the deadline for later reads. A zero value
+// disables it.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+// Close closes the connection, unblocking pending reads. Closing it again
+// fails with [kamune.ErrConnClosed].
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return kamune.ErrConnClosed
+	}
+	c.closed = true
+	close(c.done)
+	return nil
+}
+
+// Pipe returns the two ends of a synchronous, in-memory connection. Each
+// frame written to one end is read from the other.
+func Pipe(opts ...kamune.ConnOption) (kamune.Conn, kamune.Conn) {
+	c1, c2 := net.Pipe()
+	return kamune.NewConn(c1, opts...), kamune.NewConn(c2, opts...)
+}
+
+// Listener is an in-memory [kamune.Listener]. Serve on it with
+// [kamune.ServeWithListener], and dial it with [kamune.DialWithFunc] and
+// [Listener.Dial]; the dialer's address is ignored.
+type Listener struct {
+	conns chan kamune.Conn
+	done  chan struct{}
+	opts  []kamune.ConnOption
+	once  sync.Once
+}
+
+// NewListener returns a listener whose connections are created by [Pipe]
+// with opts.
+func NewListener(opts ...kamune.ConnOption) *Listener {
+	return &Listener{
+		conns: make(chan kamune.Conn),
+		done:  make(chan struct{}),
+		opts:  opts,
+	}
+}
+
+// Accept waits for the next call to [Listener.Dial] and returns the server's
+// end of its connection.
+func (l *Listener) Accept() (kamune.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Dial connects to the listener and returns the client's end of the
+// connection, once it has been accepted. It fails with [net.ErrClosed] if
+// the listener is closed.
+func (l *Listener) Dial(string) (kamune.Conn, error) {
+	client, server := Pipe(l.opts...)
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		_ = client.Close()
+		_ = server.Close()
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the listener. Connections already accepted stay open.
+func (l *Listener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
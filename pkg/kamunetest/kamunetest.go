@@ -0,0 +1,40 @@
+// Package kamunetest provides test doubles for applications built on kamune,
+// so that their logic can be unit tested without Bolt files, sockets, or real
+// signatures:
+//
+//   - [NewStorage] opens an in-memory [storage.Storage].
+//   - [Conn] is a scripted [kamune.Conn] that records what is written to it.
+//   - [Listener] and [Pipe] connect a [kamune.Server] and [kamune.Dialer]
+//     in memory, so that complete sessions can be established.
+//   - [Attester] is an [attest.Attester] whose signatures are plain digests.
+//   - [AcceptAll] is a [kamune.RemoteVerifier] that trusts every peer.
+package kamunetest
+
+import (
+	"testing"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// NewStorage opens an in-memory storage that needs no passphrase, and closes
+// it when the test ends. opts are applied after those defaults.
+func NewStorage(
+	tb testing.TB, opts ...storage.StorageOption,
+) *storage.Storage {
+	tb.Helper()
+	s, err := storage.OpenStorage(append(
+		[]storage.StorageOption{
+			storage.WithInMemory(), storage.WithNoPassphrase(),
+		},
+		opts...,
+	)...)
+	if err != nil {
+		tb.Fatalf("opening storage: %v", err)
+	}
+	tb.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+// AcceptAll is a [kamune.RemoteVerifier] that trusts every peer without
+// storing it, so sessions with it are not recorded.
+func AcceptAll(*storage.Storage, *storage.Peer) error { return nil }
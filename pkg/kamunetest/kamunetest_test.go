@@ -0,0 +1,109 @@
+package kamunetest
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune"
+)
+
+func TestListener(t *testing.T) {
+	a := require.New(t)
+	l := NewListener()
+	srv, err := kamune.NewServer(
+		"", kamune.NewEchoHandler(), NewStorage(t), AcceptAll,
+		kamune.ServeWithListener(l),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	d, err := kamune.NewDialer(
+		"server", NewStorage(t), AcceptAll, kamune.DialWithFunc(l.Dial),
+	)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+
+	_, err = tr.Send(kamune.Bytes([]byte("hello")), kamune.RouteExchangeMessages)
+	a.NoError(err)
+	reply := kamune.Bytes(nil)
+	_, err = tr.Receive(reply)
+	a.NoError(err)
+	a.Equal("hello", string(reply.Value))
+
+	a.NoError(l.Close())
+	_, err = l.Dial("server")
+	a.ErrorIs(err, net.ErrClosed)
+	_, err = l.Accept()
+	a.ErrorIs(err, net.ErrClosed)
+}
+
+func TestConn(t *testing.T) {
+	a := require.New(t)
+	c := NewConn()
+
+	a.NoError(c.WriteBytes([]byte("one")))
+	a.NoError(c.WriteBytes([]byte("two")))
+	a.Equal([][]byte{[]byte("one"), []byte("two")}, c.Written())
+
+	go c.Feed([]byte("first"), []byte("second"))
+	for _, want := range []string{"first", "second"} {
+		got, err := c.ReadBytes()
+		a.NoError(err)
+		a.Equal(want, string(got))
+	}
+
+	a.NoError(c.SetDeadline(time.Now().Add(10 * time.Millisecond)))
+	_, err := c.ReadBytes()
+	a.ErrorIs(err, os.ErrDeadlineExceeded)
+	a.NoError(c.SetDeadline(time.Time{}))
+
+	errBroken := errors.New("broken")
+	c.FailWrites(errBroken)
+	a.ErrorIs(c.WriteBytes([]byte("three")), errBroken)
+	c.FailWrites(nil)
+	c.FailReads(errBroken)
+	_, err = c.ReadBytes()
+	a.ErrorIs(err, errBroken)
+	c.FailReads(nil)
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := c.ReadBytes()
+		read <- err
+	}()
+	a.NoError(c.Close())
+	a.ErrorIs(<-read, kamune.ErrConnClosed)
+	a.True(c.Closed())
+	a.ErrorIs(c.Close(), kamune.ErrConnClosed)
+	a.ErrorIs(c.WriteBytes(nil), kamune.ErrConnClosed)
+}
+
+func TestAttester(t *testing.T) {
+	a := require.New(t)
+	alice, bob := NewAttester("alice"), NewAttester("bob")
+	msg := []byte("message")
+
+	sig, err := alice.Sign(msg)
+	a.NoError(err)
+	a.True(bob.Verify(alice.MarshalPublicKey(), msg, sig))
+	a.False(bob.Verify(bob.MarshalPublicKey(), msg, sig))
+	a.False(bob.Verify(alice.MarshalPublicKey(), []byte("other"), sig))
+	a.Equal([][]byte{msg}, alice.Signed())
+
+	errHSM := errors.New("hsm unavailable")
+	alice.FailSigning(errHSM)
+	_, err = kamune.SignDirectory(alice, nil)
+	a.ErrorIs(err, errHSM)
+	alice.FailSigning(nil)
+	_, err = kamune.SignDirectory(alice, nil)
+	a.NoError(err)
+	a.Len(alice.Signed(), 2)
+}
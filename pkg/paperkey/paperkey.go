@@ -0,0 +1,79 @@
+// Package paperkey encodes secrets as recovery phrases that can be written
+// down on paper, in the manner of BIP 39: every byte of the secret is a word
+// from a fixed list of 256, and a last word checks the others, so that most
+// mistakes in copying a phrase are caught rather than yielding another
+// secret. Words may be shortened to their first four letters.
+package paperkey
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrInvalidPhrase is returned when a phrase is empty or holds a word
+	// that is not on the list.
+	ErrInvalidPhrase = errors.New("invalid recovery phrase")
+	// ErrChecksum is returned when the words of a phrase are all on the list
+	// but do not match its last word, as when one was copied wrong.
+	ErrChecksum = errors.New("recovery phrase checksum mismatch")
+)
+
+// prefixLen is the number of letters a word can be shortened to.
+const prefixLen = 4
+
+// index maps the first four letters of every word to its byte value.
+var index = func() map[string]byte {
+	m := make(map[string]byte, len(words))
+	for i, w := range words {
+		m[prefix(w)] = byte(i)
+	}
+	return m
+}()
+
+func prefix(w string) string {
+	return w[:min(len(w), prefixLen)]
+}
+
+func checksum(secret []byte) byte {
+	sum := sha256.Sum256(secret)
+	return sum[0]
+}
+
+// Encode returns the phrase for secret: a word for each of its bytes, then
+// the checksum word, separated by spaces.
+func Encode(secret []byte) string {
+	phrase := make([]string, 0, len(secret)+1)
+	for _, b := range secret {
+		phrase = append(phrase, words[b])
+	}
+	phrase = append(phrase, words[checksum(secret)])
+	return strings.Join(phrase, " ")
+}
+
+// Decode returns the secret that phrase encodes. Words are separated by white
+// space, and are matched regardless of case, in full or by their first four
+// letters.
+func Decode(phrase string) ([]byte, error) {
+	fields := strings.Fields(strings.ToLower(phrase))
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("%w: too few words", ErrInvalidPhrase)
+	}
+	secret := make([]byte, 0, len(fields))
+	for i, w := range fields {
+		b, ok := index[prefix(w)]
+		if !ok || !strings.HasPrefix(words[b], w) {
+			return nil, fmt.Errorf(
+				"%w: unknown word %q at %d", ErrInvalidPhrase, w, i+1,
+			)
+		}
+		secret = append(secret, b)
+	}
+	secret, sum := secret[:len(secret)-1], secret[len(secret)-1]
+	if checksum(secret) != sum {
+		return nil, ErrChecksum
+	}
+	return secret, nil
+}
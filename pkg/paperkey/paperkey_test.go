@@ -0,0 +1,88 @@
+package paperkey
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWordsDistinct(t *testing.T) {
+	a := require.New(t)
+	seen := make(map[string]bool, len(words))
+	for _, w := range words {
+		a.NotEmpty(w)
+		a.Equal(strings.ToLower(w), w)
+		a.False(seen[prefix(w)], "duplicate prefix: %s", w)
+		seen[prefix(w)] = true
+	}
+	a.Len(index, len(words))
+}
+
+func TestEncodeDecode(t *testing.T) {
+	a := require.New(t)
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+
+	phrase := Encode(secret)
+	a.Len(strings.Fields(phrase), len(secret)+1)
+	a.Equal(phrase, Encode(secret))
+	got, err := Decode(phrase)
+	a.NoError(err)
+	a.Equal(secret, got)
+
+	// Case, spacing, and abbreviations do not matter.
+	var short []string
+	for _, w := range strings.Fields(phrase) {
+		short = append(short, strings.ToUpper(prefix(w)))
+	}
+	got, err = Decode("  " + strings.Join(short, "\n\t") + " ")
+	a.NoError(err)
+	a.Equal(secret, got)
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	secret := []byte{0, 1, 2, 255}
+	phrase := strings.Fields(Encode(secret))
+	swapped := append([]string{}, phrase...)
+	swapped[0], swapped[1] = swapped[1], swapped[0]
+	misspelt := append([]string{}, phrase...)
+	misspelt[2] += "s"
+
+	tests := []struct {
+		name   string
+		phrase string
+		err    error
+	}{
+		{name: "empty", phrase: "", err: ErrInvalidPhrase},
+		{name: "single word", phrase: phrase[0], err: ErrInvalidPhrase},
+		{
+			name:   "unknown word",
+			phrase: "kamune " + strings.Join(phrase[1:], " "),
+			err:    ErrInvalidPhrase,
+		},
+		{
+			name:   "misspelt word",
+			phrase: strings.Join(misspelt, " "),
+			err:    ErrInvalidPhrase,
+		},
+		{
+			name:   "swapped words",
+			phrase: strings.Join(swapped, " "),
+			err:    ErrChecksum,
+		},
+		{
+			name:   "missing word",
+			phrase: strings.Join(phrase[1:], " "),
+			err:    ErrChecksum,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			_, err := Decode(tc.phrase)
+			a.ErrorIs(err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package paperkey
+
+// words holds the 256 words of a phrase, one per byte value, in order. No
+// two share their first four letters.
+var words = [256]string{
+	"acid", "acorn", "actor", "adult", "agent", "alarm", "album", "alley",
+	"amber", "angle", "ankle", "apple", "april", "arena", "armor", "arrow",
+	"atlas", "attic", "audio", "badge", "banjo", "beach", "berry", "board",
+	"bonus", "bread", "brush", "cabin", "camel", "canal", "cedar", "cello",
+	"chalk", "clay", "climb", "clock", "cloud", "coast", "comet", "coral",
+	"daisy", "dance", "dawn", "delta", "denim", "dream", "drift", "drum",
+	"dune", "dust", "eagle", "early", "earth", "easel", "edge", "eight",
+	"elbow", "elder", "ember", "enjoy", "equal", "error", "fancy", "fence",
+	"ferry", "fever", "fiber", "flame", "fleet", "flute", "focus", "fox",
+	"frame", "frost", "gecko", "giant", "glove", "goat", "gold", "habit",
+	"hazel", "hero", "honey", "hotel", "humble", "hunter", "idea", "igloo",
+	"index", "indoor", "island", "ivory", "jacket", "jaguar", "jelly", "jewel",
+	"jigsaw", "jockey", "jungle", "junior", "kayak", "kettle", "kidney",
+	"kitten", "koala", "ladder", "lagoon", "lamp", "laptop", "lava", "lemon",
+	"letter", "lily", "limit", "linen", "lizard", "locket", "lumber", "lunar",
+	"magnet", "mango", "maple", "marble", "market", "meadow", "melon", "mercy",
+	"metal", "mirror", "monkey", "mosaic", "motor", "muffin", "museum",
+	"napkin", "narrow", "nature", "nectar", "needle", "nephew", "nickel",
+	"noble", "noodle", "north", "number", "nutmeg", "oasis", "ocean", "office",
+	"olive", "onion", "opera", "orange", "orbit", "orchid", "otter", "oven",
+	"oyster", "paddle", "palace", "panda", "paper", "parrot", "pebble",
+	"pencil", "pepper", "piano", "pigeon", "pillow", "planet", "pocket",
+	"potato", "puzzle", "quartz", "quest", "quiet", "quilt", "rabbit", "radar",
+	"raft", "raven", "razor", "recipe", "reef", "ribbon", "river", "robot",
+	"rocket", "rubber", "saddle", "salmon", "sandal", "saturn", "scarf",
+	"school", "season", "shadow", "silver", "sketch", "socket", "spider",
+	"spirit", "sugar", "summer", "sunset", "table", "tackle", "talent",
+	"teapot", "temple", "tennis", "ticket", "tiger", "timber", "toast",
+	"tomato", "topaz", "tower", "tulip", "tunnel", "turtle", "twelve", "uncle",
+	"update", "upper", "urban", "useful", "valley", "velvet", "venus", "vessel",
+	"violin", "voyage", "wagon", "walnut", "walrus", "wander", "warm", "water",
+	"whale", "wheat", "window", "winter", "wizard", "wolf", "wonder", "yacht",
+	"yellow", "yogurt", "young", "zebra", "zenith", "zero", "zigzag", "zipper",
+	"zodiac",
+}
@@ -39,6 +39,10 @@ type ListenResult struct {
 	Token      []byte
 	TTL        time.Duration
 	SessionTTL time.Duration
+	// PushRegistered reports whether the relay accepted the push wakeup
+	// set with WithPush. It is false if the relay has push disabled or
+	// does not know the provider.
+	PushRegistered bool
 }
 
 func (l *RelayListener) TTL() time.Duration        { return l.ttl }
@@ -115,6 +119,19 @@ func listenHandshake(
 		opt(&o)
 	}
 
+	var push *pb.Push
+	if o.push != nil {
+		if len(o.token) == 0 {
+			closeFn()
+			return nil, ErrPushRequiresToken
+		}
+		if len(o.push.blob) > MaxPushBlobSize {
+			closeFn()
+			return nil, ErrPushBlobTooLarge
+		}
+		push = &pb.Push{Provider: o.push.provider, Blob: o.push.blob}
+	}
+
 	ch, err := exchange.Initiate(rw)
 	if err != nil {
 		closeFn()
@@ -136,6 +153,7 @@ func listenHandshake(
 		Kind: &pb.Frame_Register{Register: &pb.Register{
 			Mode:  pb.Register_MODE_CREATE,
 			Token: o.token,
+			Push:  push,
 		}},
 	}
 	regBytes, err := proto.Marshal(registerFrame)
@@ -176,10 +194,11 @@ func listenHandshake(
 
 	go l.readPump()
 	return &ListenResult{
-		Listener:   l,
-		Token:      token,
-		TTL:        ttl,
-		SessionTTL: sessionTTL,
+		Listener:       l,
+		Token:          token,
+		TTL:            ttl,
+		SessionTTL:     sessionTTL,
+		PushRegistered: reg.GetPushRegistered(),
 	}, nil
 }
 
@@ -1,10 +1,16 @@
 package relayconn
 
 type options struct {
+	push     *pushOption
 	password string
 	token    []byte
 }
 
+type pushOption struct {
+	provider string
+	blob     []byte
+}
+
 type Option func(*options)
 
 // WithPassword sets a pre-shared key for relay authentication. The
@@ -25,3 +31,17 @@ func WithToken(t []byte) Option {
 		o.token = t
 	}
 }
+
+// WithPush registers a push wakeup with the relay alongside the listener. The
+// listener must also use WithToken. Once the listener is gone, for instance
+// because a mobile operating system suspended it, a dialer joining its token
+// makes the relay hand blob to the named push provider, which wakes the
+// device so that it can register again. Seal the platform device token with
+// SealPushToken so that the relay cannot read it. An empty blob removes an
+// earlier registration. Whether the relay accepted the registration is
+// reported by ListenResult.PushRegistered.
+func WithPush(provider string, blob []byte) Option {
+	return func(o *options) {
+		o.push = &pushOption{provider: provider, blob: blob}
+	}
+}
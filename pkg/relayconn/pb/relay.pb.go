@@ -222,6 +222,7 @@ type Register struct {
 	// to generate a random token; 16 bytes in MODE_CREATE =
 	// precomputed static token (relay must accept it)
 	Mode          Register_Mode `protobuf:"varint,2,opt,name=mode,proto3,enum=relayconn.Register_Mode" json:"mode,omitempty"` // required: MODE_CREATE or MODE_JOIN
+	Push          *Push         `protobuf:"bytes,3,opt,name=push,proto3" json:"push,omitempty"`                               // optional, MODE_CREATE with a 32-byte token only
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -270,11 +271,19 @@ func (x *Register) GetMode() Register_Mode {
 	return Register_MODE_UNSPECIFIED
 }
 
+func (x *Register) GetPush() *Push {
+	if x != nil {
+		return x.Push
+	}
+	return nil
+}
+
 type Registered struct {
 	state             protoimpl.MessageState `protogen:"open.v1"`
 	Token             []byte                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`                                                     // session token assigned by relay
 	TtlSeconds        uint32                 `protobuf:"varint,2,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`                        // token validity duration in seconds (0 = unknown)
 	SessionTtlSeconds uint32                 `protobuf:"varint,3,opt,name=session_ttl_seconds,json=sessionTtlSeconds,proto3" json:"session_ttl_seconds,omitempty"` // paired session max lifetime (0 = no limit)
+	PushRegistered    bool                   `protobuf:"varint,4,opt,name=push_registered,json=pushRegistered,proto3" json:"push_registered,omitempty"`            // Register.push was accepted
 	unknownFields     protoimpl.UnknownFields
 	sizeCache         protoimpl.SizeCache
 }
@@ -330,6 +339,65 @@ func (x *Registered) GetSessionTtlSeconds() uint32 {
 	return 0
 }
 
+func (x *Registered) GetPushRegistered() bool {
+	if x != nil {
+		return x.PushRegistered
+	}
+	return false
+}
+
+type Push struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Provider      string                 `protobuf:"bytes,1,opt,name=provider,proto3" json:"provider,omitempty"` // name of a push provider configured on the relay
+	Blob          []byte                 `protobuf:"bytes,2,opt,name=blob,proto3" json:"blob,omitempty"`         // opaque, sealed for the push gateway; empty = remove
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Push) Reset() {
+	*x = Push{}
+	mi := &file_pb_relay_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Push) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Push) ProtoMessage() {}
+
+func (x *Push) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_relay_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Push.ProtoReflect.Descriptor instead.
+func (*Push) Descriptor() ([]byte, []int) {
+	return file_pb_relay_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Push) GetProvider() string {
+	if x != nil {
+		return x.Provider
+	}
+	return ""
+}
+
+func (x *Push) GetBlob() []byte {
+	if x != nil {
+		return x.Blob
+	}
+	return nil
+}
+
 type Message struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
@@ -339,7 +407,7 @@ type Message struct {
 
 func (x *Message) Reset() {
 	*x = Message{}
-	mi := &file_pb_relay_proto_msgTypes[3]
+	mi := &file_pb_relay_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -351,7 +419,7 @@ func (x *Message) String() string {
 func (*Message) ProtoMessage() {}
 
 func (x *Message) ProtoReflect() protoreflect.Message {
-	mi := &file_pb_relay_proto_msgTypes[3]
+	mi := &file_pb_relay_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -364,7 +432,7 @@ func (x *Message) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Message.ProtoReflect.Descriptor instead.
 func (*Message) Descriptor() ([]byte, []int) {
-	return file_pb_relay_proto_rawDescGZIP(), []int{3}
+	return file_pb_relay_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *Message) GetData() []byte {
@@ -382,7 +450,7 @@ type Ping struct {
 
 func (x *Ping) Reset() {
 	*x = Ping{}
-	mi := &file_pb_relay_proto_msgTypes[4]
+	mi := &file_pb_relay_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -394,7 +462,7 @@ func (x *Ping) String() string {
 func (*Ping) ProtoMessage() {}
 
 func (x *Ping) ProtoReflect() protoreflect.Message {
-	mi := &file_pb_relay_proto_msgTypes[4]
+	mi := &file_pb_relay_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -407,7 +475,7 @@ func (x *Ping) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Ping.ProtoReflect.Descriptor instead.
 func (*Ping) Descriptor() ([]byte, []int) {
-	return file_pb_relay_proto_rawDescGZIP(), []int{4}
+	return file_pb_relay_proto_rawDescGZIP(), []int{5}
 }
 
 type Pong struct {
@@ -418,7 +486,7 @@ type Pong struct {
 
 func (x *Pong) Reset() {
 	*x = Pong{}
-	mi := &file_pb_relay_proto_msgTypes[5]
+	mi := &file_pb_relay_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -430,7 +498,7 @@ func (x *Pong) String() string {
 func (*Pong) ProtoMessage() {}
 
 func (x *Pong) ProtoReflect() protoreflect.Message {
-	mi := &file_pb_relay_proto_msgTypes[5]
+	mi := &file_pb_relay_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -443,7 +511,7 @@ func (x *Pong) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Pong.ProtoReflect.Descriptor instead.
 func (*Pong) Descriptor() ([]byte, []int) {
-	return file_pb_relay_proto_rawDescGZIP(), []int{5}
+	return file_pb_relay_proto_rawDescGZIP(), []int{6}
 }
 
 type Auth struct {
@@ -455,7 +523,7 @@ type Auth struct {
 
 func (x *Auth) Reset() {
 	*x = Auth{}
-	mi := &file_pb_relay_proto_msgTypes[6]
+	mi := &file_pb_relay_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -467,7 +535,7 @@ func (x *Auth) String() string {
 func (*Auth) ProtoMessage() {}
 
 func (x *Auth) ProtoReflect() protoreflect.Message {
-	mi := &file_pb_relay_proto_msgTypes[6]
+	mi := &file_pb_relay_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -480,7 +548,7 @@ func (x *Auth) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Auth.ProtoReflect.Descriptor instead.
 func (*Auth) Descriptor() ([]byte, []int) {
-	return file_pb_relay_proto_rawDescGZIP(), []int{6}
+	return file_pb_relay_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *Auth) GetPsk() []byte {
@@ -504,20 +572,25 @@ const file_pb_relay_proto_rawDesc = "" +
 	"\x04ping\x18\x04 \x01(\v2\x0f.relayconn.PingH\x00R\x04ping\x12%\n" +
 	"\x04pong\x18\x05 \x01(\v2\x0f.relayconn.PongH\x00R\x04pong\x12%\n" +
 	"\x04auth\x18\x06 \x01(\v2\x0f.relayconn.AuthH\x00R\x04authB\x06\n" +
-	"\x04kind\"\x8c\x01\n" +
+	"\x04kind\"\xb1\x01\n" +
 	"\bRegister\x12\x14\n" +
 	"\x05token\x18\x01 \x01(\fR\x05token\x12,\n" +
-	"\x04mode\x18\x02 \x01(\x0e2\x18.relayconn.Register.ModeR\x04mode\"<\n" +
+	"\x04mode\x18\x02 \x01(\x0e2\x18.relayconn.Register.ModeR\x04mode\x12#\n" +
+	"\x04push\x18\x03 \x01(\v2\x0f.relayconn.PushR\x04push\"<\n" +
 	"\x04Mode\x12\x14\n" +
 	"\x10MODE_UNSPECIFIED\x10\x00\x12\x0f\n" +
 	"\vMODE_CREATE\x10\x01\x12\r\n" +
-	"\tMODE_JOIN\x10\x02\"s\n" +
+	"\tMODE_JOIN\x10\x02\"\x9c\x01\n" +
 	"\n" +
 	"Registered\x12\x14\n" +
 	"\x05token\x18\x01 \x01(\fR\x05token\x12\x1f\n" +
 	"\vttl_seconds\x18\x02 \x01(\rR\n" +
 	"ttlSeconds\x12.\n" +
-	"\x13session_ttl_seconds\x18\x03 \x01(\rR\x11sessionTtlSeconds\"\x1d\n" +
+	"\x13session_ttl_seconds\x18\x03 \x01(\rR\x11sessionTtlSeconds\x12'\n" +
+	"\x0fpush_registered\x18\x04 \x01(\bR\x0epushRegistered\"6\n" +
+	"\x04Push\x12\x1a\n" +
+	"\bprovider\x18\x01 \x01(\tR\bprovider\x12\x12\n" +
+	"\x04blob\x18\x02 \x01(\fR\x04blob\"\x1d\n" +
 	"\aMessage\x12\x12\n" +
 	"\x04data\x18\x01 \x01(\fR\x04data\"\x06\n" +
 	"\x04Ping\"\x06\n" +
@@ -538,30 +611,32 @@ func file_pb_relay_proto_rawDescGZIP() []byte {
 }
 
 var file_pb_relay_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_pb_relay_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_pb_relay_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
 var file_pb_relay_proto_goTypes = []any{
 	(Register_Mode)(0), // 0: relayconn.Register.Mode
 	(*Frame)(nil),      // 1: relayconn.Frame
 	(*Register)(nil),   // 2: relayconn.Register
 	(*Registered)(nil), // 3: relayconn.Registered
-	(*Message)(nil),    // 4: relayconn.Message
-	(*Ping)(nil),       // 5: relayconn.Ping
-	(*Pong)(nil),       // 6: relayconn.Pong
-	(*Auth)(nil),       // 7: relayconn.Auth
+	(*Push)(nil),       // 4: relayconn.Push
+	(*Message)(nil),    // 5: relayconn.Message
+	(*Ping)(nil),       // 6: relayconn.Ping
+	(*Pong)(nil),       // 7: relayconn.Pong
+	(*Auth)(nil),       // 8: relayconn.Auth
 }
 var file_pb_relay_proto_depIdxs = []int32{
 	2, // 0: relayconn.Frame.register:type_name -> relayconn.Register
 	3, // 1: relayconn.Frame.registered:type_name -> relayconn.Registered
-	4, // 2: relayconn.Frame.msg:type_name -> relayconn.Message
-	5, // 3: relayconn.Frame.ping:type_name -> relayconn.Ping
-	6, // 4: relayconn.Frame.pong:type_name -> relayconn.Pong
-	7, // 5: relayconn.Frame.auth:type_name -> relayconn.Auth
+	5, // 2: relayconn.Frame.msg:type_name -> relayconn.Message
+	6, // 3: relayconn.Frame.ping:type_name -> relayconn.Ping
+	7, // 4: relayconn.Frame.pong:type_name -> relayconn.Pong
+	8, // 5: relayconn.Frame.auth:type_name -> relayconn.Auth
 	0, // 6: relayconn.Register.mode:type_name -> relayconn.Register.Mode
-	7, // [7:7] is the sub-list for method output_type
-	7, // [7:7] is the sub-list for method input_type
-	7, // [7:7] is the sub-list for extension type_name
-	7, // [7:7] is the sub-list for extension extendee
-	0, // [0:7] is the sub-list for field type_name
+	4, // 7: relayconn.Register.push:type_name -> relayconn.Push
+	8, // [8:8] is the sub-list for method output_type
+	8, // [8:8] is the sub-list for method input_type
+	8, // [8:8] is the sub-list for extension type_name
+	8, // [8:8] is the sub-list for extension extendee
+	0, // [0:8] is the sub-list for field type_name
 }
 
 func init() { file_pb_relay_proto_init() }
@@ -583,7 +658,7 @@ func file_pb_relay_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_pb_relay_proto_rawDesc), len(file_pb_relay_proto_rawDesc)),
 			NumEnums:      1,
-			NumMessages:   7,
+			NumMessages:   8,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
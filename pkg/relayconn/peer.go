@@ -0,0 +1,256 @@
+package relayconn
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamune-org/kamune"
+)
+
+const (
+	// minRelayBackoff and maxRelayBackoff bound how long a relay peer
+	// listener waits before registering with the relay again.
+	minRelayBackoff = time.Second
+	maxRelayBackoff = 30 * time.Second
+)
+
+// ErrUnknownRelayScheme is returned by DialWithRelay and ServeWithRelay when
+// the relay address has a scheme other than ws, wss, tcp, or tls.
+var ErrUnknownRelayScheme = errors.New("unknown relay address scheme")
+
+// DialWithRelay makes a kamune.Dialer reach the peer with the given public key
+// through the relay at relayAddr instead of dialing the dialer's address.
+// Both peers derive the session token from their public keys with
+// TokenFromKeys, so the peer must be listening with ServeWithRelay and this
+// dialer's public key. The relay only routes the kamune handshake and the
+// frames that follow it, all of which are encrypted end to end.
+//
+// relayAddr is host:port, optionally prefixed with ws://, wss://, tcp://, or
+// tls:// to pick the relay transport; WebSocket is the default. peerPublicKey
+// is an ed25519 key, raw or in the PKIX form returned by PublicKey.
+func DialWithRelay(
+	relayAddr string, peerPublicKey []byte, opts ...Option,
+) kamune.DialOption {
+	return func(d *kamune.Dialer) error {
+		return kamune.DialWithFunc(func(string) (kamune.Conn, error) {
+			token, err := peerToken(d.PublicKey(), peerPublicKey)
+			if err != nil {
+				return nil, err
+			}
+			ctx := context.Background()
+			conn, err := dialRelayAddr(ctx, relayAddr, token, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return conn, nil
+		})(d)
+	}
+}
+
+// ServeWithRelay makes a kamune.Server accept the peer with the given public
+// key through the relay at relayAddr, for servers that cannot accept inbound
+// connections. It registers with the relay under the token both peers derive
+// with TokenFromKeys, and registers again with a growing backoff whenever the
+// relay session ends, so the peer can dial with DialWithRelay at any time.
+// relayAddr and peerPublicKey are as for DialWithRelay.
+func ServeWithRelay(
+	relayAddr string, peerPublicKey []byte, opts ...Option,
+) kamune.ServerOptions {
+	return func(s *kamune.Server) error {
+		if _, _, err := splitRelayAddr(relayAddr); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		return kamune.ServeWithListener(&peerListener{
+			ctx:     ctx,
+			cancel:  cancel,
+			addr:    relayAddr,
+			peer:    peerPublicKey,
+			local:   s.PublicKey,
+			opts:    opts,
+			backoff: minRelayBackoff,
+		})(s)
+	}
+}
+
+// peerListener is a kamune.Listener that keeps a RelayListener registered
+// under the static token of a pair of peers.
+type peerListener struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	current *RelayListener
+	local   func() []byte
+	addr    string
+	peer    []byte
+	opts    []Option
+	backoff time.Duration
+	mu      sync.Mutex
+}
+
+func (l *peerListener) Accept() (kamune.Conn, error) {
+	for {
+		rl, err := l.listener()
+		if err != nil {
+			return nil, err
+		}
+		conn, err := rl.Accept()
+		if err == nil {
+			return conn, nil
+		}
+		l.mu.Lock()
+		if l.current == rl {
+			l.current = nil
+		}
+		l.mu.Unlock()
+		rl.Close()
+	}
+}
+
+func (l *peerListener) Close() error {
+	l.cancel()
+	l.mu.Lock()
+	rl := l.current
+	l.current = nil
+	l.mu.Unlock()
+	if rl != nil {
+		rl.Close()
+	}
+	return nil
+}
+
+// listener returns the current RelayListener, registering a new one if there
+// is none. It returns net.ErrClosed once the listener is closed.
+func (l *peerListener) listener() (*RelayListener, error) {
+	l.mu.Lock()
+	rl := l.current
+	l.mu.Unlock()
+	if rl != nil {
+		return rl, nil
+	}
+
+	token, err := peerToken(l.local(), l.peer)
+	if err != nil {
+		return nil, err
+	}
+	opts := append([]Option{WithToken(token)}, l.opts...)
+	for {
+		if l.ctx.Err() != nil {
+			return nil, net.ErrClosed
+		}
+		res, err := listenRelayAddr(l.ctx, l.addr, opts...)
+		if err == nil {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.ctx.Err() != nil {
+				res.Listener.Close()
+				return nil, net.ErrClosed
+			}
+			l.current = res.Listener
+			l.backoff = minRelayBackoff
+			return res.Listener, nil
+		}
+		slog.Warn(
+			"relayconn: registering with relay",
+			slog.String("relay", l.addr),
+			slog.Any("error", err),
+		)
+		select {
+		case <-l.ctx.Done():
+			return nil, net.ErrClosed
+		case <-time.After(l.backoff):
+		}
+		l.backoff = min(2*l.backoff, maxRelayBackoff)
+	}
+}
+
+// peerToken returns the static token of the peers with the given public
+// keys, each raw or in PKIX form.
+func peerToken(local, peer []byte) ([]byte, error) {
+	a, err := rawPublicKey(local)
+	if err != nil {
+		return nil, err
+	}
+	b, err := rawPublicKey(peer)
+	if err != nil {
+		return nil, err
+	}
+	return TokenFromKeys(a, b)
+}
+
+// rawPublicKey returns key as a raw ed25519 public key, parsing it as PKIX
+// unless it already has the raw size.
+func rawPublicKey(key []byte) (ed25519.PublicKey, error) {
+	if len(key) == ed25519.PublicKeySize {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKIXPublicKey(key)
+	if err != nil {
+		return nil, ErrInvalidKeySize
+	}
+	ed, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, ErrInvalidKeySize
+	}
+	return ed, nil
+}
+
+// splitRelayAddr splits a relay address into its scheme, ws if it has none,
+// and host:port.
+func splitRelayAddr(addr string) (scheme, host string, err error) {
+	scheme, host, ok := strings.Cut(addr, "://")
+	if !ok {
+		return "ws", addr, nil
+	}
+	switch scheme {
+	case "ws", "wss", "tcp", "tls":
+		return scheme, host, nil
+	default:
+		return "", "", fmt.Errorf("%w: %q", ErrUnknownRelayScheme, scheme)
+	}
+}
+
+func dialRelayAddr(
+	ctx context.Context, addr string, token []byte, opts ...Option,
+) (*RelayConn, error) {
+	scheme, host, err := splitRelayAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch scheme {
+	case "wss":
+		return DialRelayWSS(ctx, host, token, nil, opts...)
+	case "tcp":
+		return DialRelayTCP(ctx, host, token, opts...)
+	case "tls":
+		return DialRelayTLS(ctx, host, token, nil, opts...)
+	default:
+		return DialRelay(ctx, host, token, opts...)
+	}
+}
+
+func listenRelayAddr(
+	ctx context.Context, addr string, opts ...Option,
+) (*ListenResult, error) {
+	scheme, host, err := splitRelayAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch scheme {
+	case "wss":
+		return ListenRelayWSS(ctx, host, nil, opts...)
+	case "tcp":
+		return ListenRelayTCP(ctx, host, opts...)
+	case "tls":
+		return ListenRelayTLS(ctx, host, nil, opts...)
+	default:
+		return ListenRelay(ctx, host, opts...)
+	}
+}
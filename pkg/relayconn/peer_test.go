@@ -0,0 +1,192 @@
+package relayconn
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/kamune-org/kamune"
+	"github.com/kamune-org/kamune/pkg/exchange"
+	"github.com/kamune-org/kamune/pkg/kamunetest"
+	"github.com/kamune-org/kamune/pkg/relayconn/pb"
+)
+
+// startPairingRelay starts a TCP relay that pairs a listener and a dialer
+// registered under the same token and forwards messages between them. It
+// returns the relay's address and the number of registrations it has seen.
+func startPairingRelay(t *testing.T) (string, func() int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.New(t).NoError(err)
+	t.Cleanup(func() { ln.Close() })
+
+	var (
+		mu        sync.Mutex
+		listeners = make(map[string]*exchange.Channel)
+		creates   int
+	)
+	forward := func(from, to *exchange.Channel) {
+		defer to.Close()
+		for {
+			data, err := from.ReadBytes()
+			if err != nil {
+				return
+			}
+			if err := to.WriteBytes(data); err != nil {
+				return
+			}
+		}
+	}
+	serve := func(conn net.Conn) {
+		ch, err := exchange.Accept(newTCPAdapter(conn))
+		if err != nil {
+			conn.Close()
+			return
+		}
+		data, err := ch.ReadBytes()
+		if err != nil {
+			ch.Close()
+			return
+		}
+		var f pb.Frame
+		if err := proto.Unmarshal(data, &f); err != nil {
+			ch.Close()
+			return
+		}
+		reg := f.GetRegister()
+		registered, _ := proto.Marshal(&pb.Frame{
+			Kind: &pb.Frame_Registered{
+				Registered: &pb.Registered{Token: reg.GetToken()},
+			},
+		})
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch reg.GetMode() {
+		case pb.Register_MODE_CREATE:
+			creates++
+			listeners[string(reg.GetToken())] = ch
+			_ = ch.WriteBytes(registered)
+		case pb.Register_MODE_JOIN:
+			peer, ok := listeners[string(reg.GetToken())]
+			if !ok {
+				ch.Close()
+				return
+			}
+			delete(listeners, string(reg.GetToken()))
+			_ = ch.WriteBytes(registered)
+			go forward(ch, peer)
+			go forward(peer, ch)
+		}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	return ln.Addr().String(), func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return creates
+	}
+}
+
+func TestServeWithRelay(t *testing.T) {
+	a := require.New(t)
+	relayAddr, creates := startPairingRelay(t)
+	addr := "tcp://" + relayAddr
+
+	dialerStore := kamunetest.NewStorage(t)
+	dialerID, err := dialerStore.Attester()
+	a.NoError(err)
+	serverStore := kamunetest.NewStorage(t)
+	serverID, err := serverStore.Attester()
+	a.NoError(err)
+
+	srv, err := kamune.NewServer(
+		"", kamune.NewEchoHandler(), serverStore, kamunetest.AcceptAll,
+		ServeWithRelay(addr, dialerID.MarshalPublicKey()),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	d, err := kamune.NewDialer(
+		"", dialerStore, kamunetest.AcceptAll,
+		DialWithRelay(addr, serverID.MarshalPublicKey()),
+	)
+	a.NoError(err)
+
+	// Each session uses up the relay session, so the server registers again
+	// before the second one.
+	for i, text := range []string{"first", "second"} {
+		a.Eventually(func() bool {
+			return creates() > i
+		}, 5*time.Second, 10*time.Millisecond)
+		tr, err := d.Dial()
+		a.NoError(err)
+		_, err = tr.Send(
+			kamune.Bytes([]byte(text)), kamune.RouteExchangeMessages,
+		)
+		a.NoError(err)
+		reply := kamune.Bytes(nil)
+		_, err = tr.Receive(reply)
+		a.NoError(err)
+		a.Equal(text, string(reply.Value))
+		a.NoError(tr.Close())
+	}
+}
+
+func TestSplitRelayAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		scheme string
+		host   string
+		err    error
+	}{
+		{"relay.example:8080", "ws", "relay.example:8080", nil},
+		{"wss://relay.example:443", "wss", "relay.example:443", nil},
+		{"tcp://relay.example:9000", "tcp", "relay.example:9000", nil},
+		{"tls://relay.example:9001", "tls", "relay.example:9001", nil},
+		{"http://relay.example", "", "", ErrUnknownRelayScheme},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			a := require.New(t)
+			scheme, host, err := splitRelayAddr(tt.addr)
+			a.ErrorIs(err, tt.err)
+			a.Equal(tt.scheme, scheme)
+			a.Equal(tt.host, host)
+		})
+	}
+}
+
+func TestPeerToken(t *testing.T) {
+	a := require.New(t)
+	one, err := kamunetest.NewStorage(t).Attester()
+	a.NoError(err)
+	two, err := kamunetest.NewStorage(t).Attester()
+	a.NoError(err)
+
+	pkix, err := peerToken(one.MarshalPublicKey(), two.MarshalPublicKey())
+	a.NoError(err)
+	rawOne, err := rawPublicKey(one.MarshalPublicKey())
+	a.NoError(err)
+	rawTwo, err := rawPublicKey(two.MarshalPublicKey())
+	a.NoError(err)
+	raw, err := peerToken(rawTwo, rawOne)
+	a.NoError(err)
+	a.Equal(pkix, raw)
+
+	_, err = peerToken([]byte("short"), rawTwo)
+	a.ErrorIs(err, ErrInvalidKeySize)
+}
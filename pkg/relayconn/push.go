@@ -0,0 +1,69 @@
+package relayconn
+
+import (
+	"crypto/hpke"
+	"errors"
+	"fmt"
+)
+
+const (
+	// MaxPushBlobSize is the largest push blob the relay accepts. It leaves
+	// room for a platform device token sealed by SealPushToken, whose
+	// encapsulated key alone takes 1120 bytes.
+	MaxPushBlobSize = 2048
+
+	// pushInfo is the HPKE info string binding sealed push tokens to their
+	// purpose.
+	pushInfo = "kamune/relay-push/v1"
+)
+
+var (
+	// ErrPushRequiresToken is returned when WithPush is used without
+	// WithToken. A relay-generated token cannot be registered again once
+	// the listener is gone, so there would be nothing to wake it for.
+	ErrPushRequiresToken = errors.New("push wakeups require a static token")
+
+	// ErrPushBlobTooLarge is returned when the push blob exceeds
+	// MaxPushBlobSize.
+	ErrPushBlobTooLarge = errors.New("push blob too large")
+)
+
+// PushGatewayKEM returns the HPKE KEM of push gateway keys. A gateway
+// generates its key pair with PushGatewayKEM().GenerateKey() and publishes
+// the public key to its clients.
+func PushGatewayKEM() hpke.KEM { return hpke.MLKEM768X25519() }
+
+// SealPushToken encrypts a platform device token (an FCM registration token
+// or an APNs device token) to the public key of a push gateway. The result is
+// the blob passed to WithPush: the relay stores it and hands it to the
+// gateway when the listener must be woken, without being able to read it.
+func SealPushToken(gatewayKey, deviceToken []byte) ([]byte, error) {
+	pub, err := PushGatewayKEM().NewPublicKey(gatewayKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing gateway key: %w", err)
+	}
+	blob, err := hpke.Seal(
+		pub, hpke.HKDFSHA512(), hpke.ChaCha20Poly1305(),
+		[]byte(pushInfo), deviceToken,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sealing push token: %w", err)
+	}
+	if len(blob) > MaxPushBlobSize {
+		return nil, ErrPushBlobTooLarge
+	}
+	return blob, nil
+}
+
+// OpenPushToken decrypts a blob sealed by SealPushToken. It is used by push
+// gateways, not by the relay.
+func OpenPushToken(gatewayKey hpke.PrivateKey, blob []byte) ([]byte, error) {
+	token, err := hpke.Open(
+		gatewayKey, hpke.HKDFSHA512(), hpke.ChaCha20Poly1305(),
+		[]byte(pushInfo), blob,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("opening push token: %w", err)
+	}
+	return token, nil
+}
@@ -0,0 +1,72 @@
+package relayconn
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealPushToken(t *testing.T) {
+	a := require.New(t)
+	gateway, err := PushGatewayKEM().GenerateKey()
+	a.NoError(err)
+	pub := gateway.PublicKey().Bytes()
+
+	device := []byte("fcm-registration-token")
+	blob, err := SealPushToken(pub, device)
+	a.NoError(err)
+	a.LessOrEqual(len(blob), MaxPushBlobSize)
+	a.NotContains(string(blob), string(device))
+
+	got, err := OpenPushToken(gateway, blob)
+	a.NoError(err)
+	a.Equal(device, got)
+
+	other, err := PushGatewayKEM().GenerateKey()
+	a.NoError(err)
+	_, err = OpenPushToken(other, blob)
+	a.Error(err)
+
+	_, err = SealPushToken(pub, make([]byte, MaxPushBlobSize))
+	a.ErrorIs(err, ErrPushBlobTooLarge)
+	_, err = SealPushToken([]byte("short"), device)
+	a.Error(err)
+}
+
+func TestListenHandshake_WithPush(t *testing.T) {
+	token := make([]byte, 32)
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr error
+	}{
+		{
+			name:    "without token",
+			opts:    []Option{WithPush("fcm", []byte("sealed"))},
+			wantErr: ErrPushRequiresToken,
+		},
+		{
+			name: "blob too large",
+			opts: []Option{
+				WithToken(token),
+				WithPush("fcm", make([]byte, MaxPushBlobSize+1)),
+			},
+			wantErr: ErrPushBlobTooLarge,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			c, s := net.Pipe()
+			defer s.Close()
+
+			_, err := listenHandshake(
+				context.Background(), newTCPAdapter(c),
+				func() { c.Close() }, tc.opts...,
+			)
+			a.ErrorIs(err, tc.wantErr)
+		})
+	}
+}
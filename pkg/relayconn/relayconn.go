@@ -53,6 +53,23 @@
 //
 // PSK authentication is optional via WithPassword().
 //
+// A kamune.Server that cannot accept inbound connections can serve a known
+// peer through a relay with ServeWithRelay, which keeps it registered under
+// the static token of the two peers, and the peer reaches it with the
+// DialWithRelay option of its kamune.Dialer. The kamune handshake then runs
+// over the relay as over any other connection.
+//
+// # Push wakeups
+//
+// A mobile listener that the operating system suspends cannot keep its
+// relay connection open. With WithToken and WithPush, it registers an
+// opaque blob with the relay: its platform push token sealed for a push
+// gateway by SealPushToken. When a dialer later joins the token and no
+// listener is connected, the relay passes the blob to the gateway, which
+// wakes the device, and holds the dialer until the listener registers
+// again. The relay learns neither the push token nor who is dialing, and
+// the gateway learns neither the rendezvous token nor any message.
+//
 // # Protocol design
 //
 // The relay is intentionally "blind": it sees only the framing and
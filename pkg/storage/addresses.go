@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// ErrStaleAddresses is returned when recording addresses that were announced
+// no later than those already recorded for the peer.
+var ErrStaleAddresses = errors.New("addresses are not newer than recorded")
+
+// PeerAddress is an address a peer can be reached at.
+type PeerAddress struct {
+	// Network is how the address is reached, such as "tcp", "udp", or
+	// "relay".
+	Network string `json:"network"`
+	Address string `json:"address"`
+}
+
+// AnnouncedAddresses are the addresses a peer last announced it can be reached
+// at, as signed by the peer.
+type AnnouncedAddresses struct {
+	// Issued is when the peer made the announcement, by its own clock.
+	Issued time.Time `json:"issued"`
+	// Received is when the announcement was recorded, by the local clock.
+	Received  time.Time     `json:"received"`
+	Addresses []PeerAddress `json:"addresses"`
+	// Signature is the peer's signature of the announcement.
+	Signature []byte `json:"signature"`
+}
+
+// SetPeerAddresses records the addresses announced by the peer with the given
+// public key, replacing those recorded before. It fails with
+// [ErrStaleAddresses] unless a is newer than the recorded announcement, so
+// that a replayed announcement cannot roll the peer's addresses back. The
+// caller is responsible for checking the signature.
+func (s *Storage) SetPeerAddresses(
+	publicKey []byte, a AnnouncedAddresses,
+) error {
+	if len(publicKey) == 0 {
+		return ErrInvalidPublicKey
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("marshalling peer addresses: %w", err)
+	}
+	key := peerKey(publicKey)
+	err = s.engine.Command(func(b engine.Namespace) error {
+		addrs := b.Ensure([]byte(engine.AddressesNamespace))
+		if prev, err := addrs.GetEncrypted(key); err == nil {
+			var p AnnouncedAddresses
+			if err := json.Unmarshal(prev, &p); err == nil &&
+				!a.Issued.After(p.Issued) {
+				return ErrStaleAddresses
+			}
+		} else if !isMissing(err) {
+			return err
+		}
+		return addrs.PutEncrypted(key, data)
+	})
+	if err != nil {
+		return fmt.Errorf("setting peer addresses: %w", err)
+	}
+	s.notify(peerEvent(EventUpdated, publicKey))
+	return nil
+}
+
+// PeerAddresses returns the addresses recorded for the peer with the given
+// public key by [Storage.SetPeerAddresses], or nil if there are none.
+func (s *Storage) PeerAddresses(publicKey []byte) (*AnnouncedAddresses, error) {
+	var data []byte
+	err := s.engine.Query(func(b engine.Namespace) error {
+		var err error
+		data, err = b.Sub([]byte(engine.AddressesNamespace)).
+			GetEncrypted(peerKey(publicKey))
+		if isMissing(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting peer addresses: %w", err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	var a AnnouncedAddresses
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("unmarshalling peer addresses: %w", err)
+	}
+	return &a, nil
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"cmp"
+	"crypto/sha3"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+const maxAliasLength = 64
+
+var (
+	// ErrInvalidAlias is returned for aliases that are empty, too long, or
+	// contain unsupported characters.
+	ErrInvalidAlias = errors.New("invalid alias")
+	// ErrAliasTaken is returned when assigning an alias that already names
+	// another peer.
+	ErrAliasTaken = errors.New("alias is assigned to another peer")
+	// ErrAliasNotFound is returned when resolving an alias that is not
+	// assigned.
+	ErrAliasNotFound = errors.New("alias not found")
+)
+
+// PeerAlias is a handle assigned to a peer with [Storage.SetAlias].
+type PeerAlias struct {
+	Alias     string `json:"alias"`
+	PublicKey []byte `json:"public_key"`
+}
+
+// aliasKey returns the storage key of alias, so that aliases are not kept in
+// the clear.
+func aliasKey(alias string) []byte {
+	h := sha3.Sum512([]byte(alias))
+	return h[:]
+}
+
+// SetAlias assigns alias, a stable handle such as "alice-laptop", to the peer
+// with the given public key, so that applications can address the peer by it
+// rather than by its rotating session IDs. Aliases are made of letters,
+// digits, '.', '-' and '_', up to 64 of them. A peer may have several
+// aliases, but an alias names a single peer: assigning one held by another
+// peer fails with [ErrAliasTaken] until it is removed.
+func (s *Storage) SetAlias(alias string, publicKey []byte) error {
+	if err := checkAlias(alias); err != nil {
+		return err
+	}
+	if len(publicKey) == 0 {
+		return ErrInvalidPublicKey
+	}
+	data, err := json.Marshal(PeerAlias{Alias: alias, PublicKey: publicKey})
+	if err != nil {
+		return fmt.Errorf("marshalling alias: %w", err)
+	}
+	key := aliasKey(alias)
+	err = s.engine.Command(func(b engine.Namespace) error {
+		aliases := b.Ensure([]byte(engine.AliasesNamespace))
+		prev, err := aliases.GetEncrypted(key)
+		switch {
+		case isMissing(err):
+		case err != nil:
+			return err
+		default:
+			var p PeerAlias
+			if err := json.Unmarshal(prev, &p); err != nil {
+				return fmt.Errorf("unmarshalling alias: %w", err)
+			}
+			if !slices.Equal(p.PublicKey, publicKey) {
+				return fmt.Errorf("%w: %q", ErrAliasTaken, alias)
+			}
+		}
+		return aliases.PutEncrypted(key, data)
+	})
+	if err != nil {
+		return fmt.Errorf("setting alias: %w", err)
+	}
+	return nil
+}
+
+// RemoveAlias removes alias. Removing an alias that is not assigned is not
+// an error.
+func (s *Storage) RemoveAlias(alias string) error {
+	err := s.engine.Command(func(b engine.Namespace) error {
+		return b.Sub([]byte(engine.AliasesNamespace)).Delete(aliasKey(alias))
+	})
+	if err != nil && !isMissing(err) {
+		return fmt.Errorf("removing alias: %w", err)
+	}
+	return nil
+}
+
+// ResolveAlias returns the public key of the peer alias is assigned to, or
+// [ErrAliasNotFound].
+func (s *Storage) ResolveAlias(alias string) ([]byte, error) {
+	var data []byte
+	err := s.engine.Query(func(b engine.Namespace) error {
+		var err error
+		data, err = b.Sub([]byte(engine.AliasesNamespace)).
+			GetEncrypted(aliasKey(alias))
+		return err
+	})
+	if isMissing(err) {
+		return nil, fmt.Errorf("%w: %q", ErrAliasNotFound, alias)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("resolving alias: %w", err)
+	}
+	var p PeerAlias
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("unmarshalling alias: %w", err)
+	}
+	return p.PublicKey, nil
+}
+
+// ListAliases returns every assigned alias, sorted by alias.
+func (s *Storage) ListAliases() ([]PeerAlias, error) {
+	var aliases []PeerAlias
+	err := s.engine.Query(func(b engine.Namespace) error {
+		for _, value := range b.Sub(
+			[]byte(engine.AliasesNamespace),
+		).IterateEncrypted() {
+			var p PeerAlias
+			if err := json.Unmarshal(value, &p); err != nil {
+				continue
+			}
+			aliases = append(aliases, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing aliases: %w", err)
+	}
+	slices.SortFunc(aliases, func(a, b PeerAlias) int {
+		return cmp.Compare(a.Alias, b.Alias)
+	})
+	return aliases, nil
+}
+
+// checkAlias rejects aliases that are empty, too long, or contain characters
+// other than letters, digits, '.', '-' and '_'.
+func checkAlias(alias string) error {
+	valid := alias != "" && len(alias) <= maxAliasLength &&
+		!strings.ContainsFunc(alias, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+				r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_')
+		})
+	if !valid {
+		return fmt.Errorf("%w: %q", ErrInvalidAlias, alias)
+	}
+	return nil
+}
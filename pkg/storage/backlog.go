@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// backlogKey returns the key of a spilled message: the queue it belongs to,
+// so that the queues of successive connections of a session do not collide,
+// and its number within the queue.
+func backlogKey(queue, number uint64) []byte {
+	key := binary.BigEndian.AppendUint64(nil, queue)
+	return binary.BigEndian.AppendUint64(key, number)
+}
+
+// SpillMessage keeps data, a received message that the application has not
+// taken yet, in the session's backlog under the given queue and number, until
+// [Storage.TakeSpilledMessage] removes it. The session must have been created
+// with [Storage.CreateSession].
+func (s *Storage) SpillMessage(
+	sessionID string, queue, number uint64, data []byte,
+) error {
+	err := s.engine.Command(func(b engine.Namespace) error {
+		backlog := b.Sub([]byte(engine.SessionsNamespace)).
+			Sub([]byte(sessionID)).
+			Ensure([]byte("backlog"))
+		return backlog.PutEncrypted(backlogKey(queue, number), data)
+	})
+	if isMissing(err) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("spilling message %d: %w", number, err)
+	}
+	return nil
+}
+
+// TakeSpilledMessage removes the message spilled under the given queue and
+// number from the session's backlog and returns it.
+func (s *Storage) TakeSpilledMessage(
+	sessionID string, queue, number uint64,
+) ([]byte, error) {
+	var data []byte
+	err := s.engine.Command(func(b engine.Namespace) error {
+		backlog := b.Sub([]byte(engine.SessionsNamespace)).
+			Sub([]byte(sessionID)).
+			Sub([]byte("backlog"))
+		key := backlogKey(queue, number)
+		value, err := backlog.GetEncrypted(key)
+		if err != nil {
+			return err
+		}
+		data = bytes.Clone(value)
+		return backlog.Delete(key)
+	})
+	if isMissing(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("taking spilled message %d: %w", number, err)
+	}
+	return data, nil
+}
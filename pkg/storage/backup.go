@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+const (
+	backupPrefix = "backup-"
+	backupSuffix = ".db"
+	// backupTimeLayout has a fixed width, so that backups sort by name in the
+	// order they were taken.
+	backupTimeLayout = "20060102T150405.000000000Z"
+)
+
+var (
+	ErrBackupsDisabled   = errors.New("backups are not configured")
+	ErrBackupUnsupported = errors.New("storage backend cannot be backed up")
+	ErrCorruptDB         = engine.ErrCorruptDB
+)
+
+// Backup is a snapshot of the database taken by [Storage.Backup].
+type Backup struct {
+	// Created is when the backup was taken.
+	Created time.Time
+	Path    string
+	Size    int64
+}
+
+// WithBackups keeps copies of the database in dir, so that a database file
+// damaged by a crash or a failing disk does not take the identity and history
+// with it. A backup is taken when the storage opens if the latest one is older
+// than interval, and then every interval while it stays open; [Storage.Backup]
+// takes one at any time. Each backup is checked before it is kept, and only the
+// keep most recent are, with keep below 1 counting as 1. A zero interval only
+// takes backups on request. Backups are encrypted like the database itself.
+// See [RestoreFromBackup].
+//
+// Backups are not taken of stores other than BoltDB, such as with
+// [WithInMemory].
+func WithBackups(dir string, keep int, interval time.Duration) StorageOption {
+	return func(p *Storage) {
+		p.backupDir = dir
+		p.backupKeep = max(keep, 1)
+		p.backupInterval = interval
+	}
+}
+
+// Backup takes a backup of the database into the directory set by
+// [WithBackups] while the storage stays in use, checks it, and removes the
+// backups beyond the number to keep.
+func (s *Storage) Backup() (Backup, error) {
+	if s.backupDir == "" {
+		return Backup{}, ErrBackupsDisabled
+	}
+	b, ok := s.engine.(engine.Backuper)
+	if !ok {
+		return Backup{}, ErrBackupUnsupported
+	}
+	s.backupMu.Lock()
+	defer s.backupMu.Unlock()
+
+	if err := os.MkdirAll(s.backupDir, 0700); err != nil {
+		return Backup{}, fmt.Errorf("creating backup directory: %w", err)
+	}
+	created := s.clock.Now().UTC()
+	path := filepath.Join(
+		s.backupDir,
+		backupPrefix+created.Format(backupTimeLayout)+backupSuffix,
+	)
+	if err := b.Backup(path); err != nil {
+		return Backup{}, fmt.Errorf("backing up: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Backup{}, fmt.Errorf("backing up: %w", err)
+	}
+
+	backups, err := ListBackups(s.backupDir)
+	if err != nil {
+		return Backup{}, err
+	}
+	for _, old := range backups[:max(len(backups)-s.backupKeep, 0)] {
+		if err := os.Remove(old.Path); err != nil {
+			return Backup{}, fmt.Errorf("removing old backup: %w", err)
+		}
+	}
+	return Backup{Created: created, Path: path, Size: info.Size()}, nil
+}
+
+// ListBackups returns the backups in dir, oldest first.
+func ListBackups(dir string) ([]Backup, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing backups: %w", err)
+	}
+	var backups []Backup
+	for _, e := range entries {
+		name := e.Name()
+		stamp, ok := strings.CutPrefix(name, backupPrefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(stamp, backupSuffix)
+		if !ok {
+			continue
+		}
+		created, err := time.Parse(backupTimeLayout, stamp)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{
+			Created: created,
+			Path:    filepath.Join(dir, name),
+			Size:    info.Size(),
+		})
+	}
+	slices.SortFunc(backups, func(a, b Backup) int {
+		return a.Created.Compare(b.Created)
+	})
+	return backups, nil
+}
+
+// RestoreFromBackup replaces the database at path with the backup at backup,
+// such as one returned by [ListBackups]. The backup is checked first and
+// [ErrCorruptDB] is returned if it is damaged; the database being replaced is
+// kept next to it with a ".before-restore" suffix, numbered after the first
+// restore. The database must not be open: like [CompactDB], it fails with
+// [ErrStoreInUse] if another process holds it for longer than timeout. The
+// restored database opens with the passphrase it had when the backup was
+// taken.
+func RestoreFromBackup(path, backup string, timeout time.Duration) error {
+	return engine.RestoreBoltDB(path, backup, timeout)
+}
+
+func (s *Storage) startBackups() {
+	if s.backupDir == "" || s.backupInterval <= 0 {
+		return
+	}
+	if _, ok := s.engine.(engine.Backuper); !ok {
+		return
+	}
+	s.backupIfDue()
+
+	var ctx context.Context
+	ctx, s.stopBackups = context.WithCancel(context.Background())
+	go s.backupLoop(ctx)
+}
+
+func (s *Storage) backupLoop(ctx context.Context) {
+	ticker := time.NewTicker(
+		max(min(s.backupInterval/4, time.Hour), time.Second),
+	)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.backupIfDue()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// backupIfDue takes a backup if the latest one is older than the backup
+// interval. Failures are logged, as there is no caller to report them to.
+func (s *Storage) backupIfDue() {
+	backups, err := ListBackups(s.backupDir)
+	if err == nil && len(backups) > 0 {
+		latest := backups[len(backups)-1].Created
+		if s.clock.Now().Sub(latest) < s.backupInterval {
+			return
+		}
+	}
+	if _, err := s.Backup(); err != nil {
+		slog.Warn("scheduled backup failed", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// BlockedPeer is an entry of the blocklist.
+type BlockedPeer struct {
+	Blocked   time.Time
+	PublicKey []byte
+}
+
+// blockHooks holds the callbacks registered with [Storage.OnBlock].
+type blockHooks struct {
+	fns  map[uint64]func(publicKey []byte)
+	next uint64
+}
+
+// BlockPeer adds the peer with the given public key to the blocklist and
+// notifies every callback registered with [Storage.OnBlock], which the
+// servers and dialers using this storage rely on to close the peer's live
+// sessions. Blocking a peer twice keeps the original time.
+//
+// The peer's record, sessions and history are left in place. Blocked peers
+// can neither establish nor resume a session; see [Storage.IsBlocked].
+func (s *Storage) BlockPeer(publicKey []byte) error {
+	if len(publicKey) == 0 {
+		return ErrInvalidPublicKey
+	}
+	key := peerKey(publicKey)
+	value := make([]byte, 8, 8+len(publicKey))
+	binary.BigEndian.PutUint64(value, uint64(s.clock.Now().UnixNano()))
+	value = append(value, publicKey...)
+
+	err := s.engine.Command(func(b engine.Namespace) error {
+		blocked := b.Ensure([]byte(engine.BlockedNamespace))
+		if _, err := blocked.GetEncrypted(key); err == nil {
+			return nil
+		}
+		return blocked.PutEncrypted(key, value)
+	})
+	if err != nil {
+		return fmt.Errorf("blocking peer: %w", err)
+	}
+
+	s.hooksMu.Lock()
+	fns := make([]func([]byte), 0, len(s.blockHooks.fns))
+	for _, fn := range s.blockHooks.fns {
+		fns = append(fns, fn)
+	}
+	s.hooksMu.Unlock()
+	for _, fn := range fns {
+		fn(publicKey)
+	}
+	return nil
+}
+
+// UnblockPeer removes the peer with the given public key from the blocklist.
+// Unblocking a peer that is not blocked is not an error.
+func (s *Storage) UnblockPeer(publicKey []byte) error {
+	key := peerKey(publicKey)
+	err := s.engine.Command(func(b engine.Namespace) error {
+		return b.Sub([]byte(engine.BlockedNamespace)).Delete(key)
+	})
+	if err != nil && !isMissing(err) {
+		return fmt.Errorf("unblocking peer: %w", err)
+	}
+	return nil
+}
+
+// IsBlocked reports whether the peer with the given public key is blocked.
+func (s *Storage) IsBlocked(publicKey []byte) (bool, error) {
+	key := peerKey(publicKey)
+	var blocked bool
+	err := s.engine.Query(func(b engine.Namespace) error {
+		_, err := b.Sub([]byte(engine.BlockedNamespace)).GetEncrypted(key)
+		blocked = err == nil
+		if isMissing(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("checking blocklist: %w", err)
+	}
+	return blocked, nil
+}
+
+// ListBlockedPeers returns the blocklist, most recently blocked first.
+func (s *Storage) ListBlockedPeers() ([]BlockedPeer, error) {
+	var peers []BlockedPeer
+	err := s.engine.Query(func(b engine.Namespace) error {
+		blocked := b.Sub([]byte(engine.BlockedNamespace))
+		for _, value := range blocked.IterateEncrypted() {
+			if len(value) <= 8 {
+				continue
+			}
+			peers = append(peers, BlockedPeer{
+				Blocked: time.Unix(
+					0, int64(binary.BigEndian.Uint64(value[:8])),
+				),
+				PublicKey: bytes.Clone(value[8:]),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing blocked peers: %w", err)
+	}
+	slices.SortFunc(peers, func(a, b BlockedPeer) int {
+		return cmp.Compare(b.Blocked.UnixNano(), a.Blocked.UnixNano())
+	})
+	return peers, nil
+}
+
+// OnBlock registers fn to be called with the public key of every peer blocked
+// through [Storage.BlockPeer], after the block is persisted. Callbacks run
+// synchronously on the blocking goroutine and must not call OnBlock or the
+// returned function themselves. Calling the returned function unregisters fn.
+func (s *Storage) OnBlock(fn func(publicKey []byte)) (cancel func()) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	if s.blockHooks.fns == nil {
+		s.blockHooks.fns = make(map[uint64]func([]byte))
+	}
+	id := s.blockHooks.next
+	s.blockHooks.next++
+	s.blockHooks.fns[id] = fn
+	return func() {
+		s.hooksMu.Lock()
+		defer s.hooksMu.Unlock()
+		delete(s.blockHooks.fns, id)
+	}
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/kamune-org/kamune/internal/engine"
+	"github.com/kamune-org/kamune/internal/enigma"
+)
+
+// Chat keys live in chat_keys/, one random secret per conversation, keyed by
+// conversation ID. The cipher of a conversation's entries is derived from its
+// secret and the peer's public key, so that it is bound to the peer's
+// identity, and destroying the secret leaves the entries unreadable.
+var chatKeyInfo = []byte("kamune-chat-entries")
+
+// chatKeySize is the length of a conversation's secret.
+const chatKeySize = 32
+
+// WithConversationKeys makes new chat entries be encrypted with a key of
+// their conversation, in addition to the storage's own encryption. A copy of
+// the database shared for diagnostics then only exposes the conversations
+// whose keys it is given, and [Storage.ShredConversation] erases a peer's
+// history by destroying its key. Entries stored while it is disabled, and
+// those of sessions without a conversation, are only encrypted with the
+// storage key. It is disabled by default.
+//
+// The search index is shared by every conversation and keeps hashes of their
+// terms; disable it with [WithSearchIndex] for full separation.
+func WithConversationKeys(v bool) StorageOption {
+	return func(p *Storage) { p.conversationKeys = v }
+}
+
+func newChatCipher(secret, publicKey []byte) (*enigma.Enigma, error) {
+	return enigma.NewEnigma(secret, publicKey, chatKeyInfo)
+}
+
+// chatCipher returns the cipher of the chat entries of a session, or nil if
+// its conversation has no key.
+func chatCipher(b engine.Namespace, sessionID string) *enigma.Enigma {
+	meta := sessionMeta(b, sessionID)
+	id, err := meta.GetEncrypted([]byte(ConversationKey))
+	if err != nil {
+		return nil
+	}
+	secret, err := b.Sub([]byte(engine.ChatKeysNamespace)).GetEncrypted(id)
+	if err != nil {
+		return nil
+	}
+	publicKey, err := meta.GetEncrypted([]byte(PeerKey))
+	if err != nil {
+		return nil
+	}
+	c, err := newChatCipher(secret, publicKey)
+	if err != nil {
+		return nil
+	}
+	return c
+}
+
+// ensureChatCipher is chatCipher, generating a key for the session's
+// conversation if it has none. It returns nil if the session is not part of a
+// conversation. It must be called inside a Command.
+func ensureChatCipher(
+	b engine.Namespace, sessionID string,
+) (*enigma.Enigma, error) {
+	if c := chatCipher(b, sessionID); c != nil {
+		return c, nil
+	}
+	meta := sessionMeta(b, sessionID)
+	id, err := meta.GetEncrypted([]byte(ConversationKey))
+	if isMissing(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := meta.GetEncrypted([]byte(PeerKey))
+	if err != nil {
+		return nil, fmt.Errorf("loading peer key: %w", err)
+	}
+
+	secret := make([]byte, chatKeySize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generating chat key: %w", err)
+	}
+	keys := b.Ensure([]byte(engine.ChatKeysNamespace))
+	if err := keys.PutEncrypted(id, secret); err != nil {
+		return nil, fmt.Errorf("storing chat key: %w", err)
+	}
+	return newChatCipher(secret, publicKey)
+}
+
+// sealChatValue encrypts an encoded chat value with c, if it is not nil.
+func sealChatValue(c *enigma.Enigma, value []byte) []byte {
+	if c == nil {
+		return value
+	}
+	return append(bytes.Clone(sealedValueMagic), c.Encrypt(value)...)
+}
+
+// openChatEntry is decodeChatEntry for values that may have been sealed with
+// their conversation's cipher c. It reports false for sealed values that c,
+// which may be nil, cannot open.
+func openChatEntry(c *enigma.Enigma, key, value []byte) (ChatEntry, bool) {
+	if bytes.HasPrefix(value, sealedValueMagic) {
+		if c == nil {
+			return ChatEntry{}, false
+		}
+		var err error
+		value, err = c.Decrypt(value[len(sealedValueMagic):])
+		if err != nil {
+			return ChatEntry{}, false
+		}
+	}
+	return decodeChatEntry(key, value)
+}
+
+// ShredConversation destroys the key of the conversation with the peer
+// owning publicKey. The entries encrypted with it, see
+// [WithConversationKeys], can no longer be read and are left out of history
+// and search results from then on, as though they were deleted, without
+// having to find and overwrite every one of them. Entries that were not
+// encrypted with the key are kept. Their space is reclaimed when their
+// sessions are deleted or pruned. Shredding a conversation without a key is
+// not an error.
+func (s *Storage) ShredConversation(publicKey []byte) error {
+	if len(publicKey) == 0 {
+		return ErrInvalidPublicKey
+	}
+	id := conversationID(publicKey)
+	var sessions []string
+	err := s.engine.Command(func(b engine.Namespace) error {
+		if c, err := getConversation(b, id); err == nil {
+			sessions = c.GetSessions()
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return b.Sub([]byte(engine.ChatKeysNamespace)).Delete([]byte(id))
+	})
+	if err != nil && !isMissing(err) {
+		return fmt.Errorf("shredding conversation: %w", err)
+	}
+
+	events := make([]Event, 0, len(sessions))
+	for _, sid := range sessions {
+		events = append(events, Event{
+			Class: ChatBucket, Op: EventDeleted, SessionID: sid,
+		})
+	}
+	s.notify(events...)
+	return nil
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// autoCompactMinFree is the least free space that [WithAutoCompaction]
+// reclaims, so that small databases are not rewritten for a few pages.
+const autoCompactMinFree = 1 << 20
+
+// ErrCompactUnsupported is returned by the compaction methods for backends
+// that cannot be compacted while open.
+var ErrCompactUnsupported = errors.New("storage backend cannot be compacted")
+
+// Compaction reports a decision of [WithAutoCompaction], for
+// [WithCompactionHandler].
+type Compaction struct {
+	// Space is the state of the file when the decision was made.
+	Space SpaceStats
+	// Compacted reports whether the file was compacted, in which case After
+	// is its new size and Duration how long it took, or Err why it failed.
+	Compacted bool
+	After     int64
+	Duration  time.Duration
+	Err       error
+}
+
+// WithAutoCompaction compacts the database in place, as
+// [Storage.CompactInPlace] does, whenever free pages take more than threshold
+// of the file, a fraction between 0 and 1, and at least 1 MiB. The file is
+// checked when the storage opens, and then every interval while it stays
+// open; a zero interval only checks it on open. Every decision is logged,
+// and reported to [WithCompactionHandler]. A threshold of zero or less, the
+// default, disables automatic compaction, and so do backends other than
+// BoltDB.
+func WithAutoCompaction(
+	threshold float64, interval time.Duration,
+) StorageOption {
+	return func(p *Storage) {
+		p.compactThreshold = threshold
+		p.compactInterval = interval
+	}
+}
+
+// WithCompactionHandler sets a function that is called with every decision of
+// [WithAutoCompaction], whether or not the file was compacted, so that
+// applications can export the free space and compactions as metrics.
+func WithCompactionHandler(fn func(Compaction)) StorageOption {
+	return func(p *Storage) { p.compactionHandler = fn }
+}
+
+// Space reports the size of the database file and how much of it is taken by
+// pages that deleted data left free. Backends other than BoltDB return
+// [ErrCompactUnsupported].
+func (s *Storage) Space() (SpaceStats, error) {
+	c, ok := s.engine.(engine.Compactor)
+	if !ok {
+		return SpaceStats{}, ErrCompactUnsupported
+	}
+	return c.Space()
+}
+
+// CompactTo writes a compacted copy of the database to path, which must not
+// exist, while the storage stays in use. The copy is a consistent snapshot,
+// encrypted like the database itself. progress, if not nil, is called as the
+// copy goes.
+func (s *Storage) CompactTo(
+	path string, progress func(CompactProgress),
+) error {
+	c, ok := s.engine.(engine.Compactor)
+	if !ok {
+		return ErrCompactUnsupported
+	}
+	return c.CompactTo(path, progress)
+}
+
+// CompactInPlace rewrites the open database without its free pages, as
+// [CompactDB] does for a closed one, and returns its size in bytes before and
+// after. Reads and writes wait until it is done. progress, if not nil, is
+// called as the copy goes.
+func (s *Storage) CompactInPlace(
+	progress func(CompactProgress),
+) (before, after int64, err error) {
+	c, ok := s.engine.(engine.Compactor)
+	if !ok {
+		return 0, 0, ErrCompactUnsupported
+	}
+	return c.CompactInPlace(progress)
+}
+
+func (s *Storage) startAutoCompaction() {
+	if s.compactThreshold <= 0 {
+		return
+	}
+	if _, ok := s.engine.(engine.Compactor); !ok {
+		return
+	}
+	s.compactIfNeeded()
+	if s.compactInterval <= 0 {
+		return
+	}
+
+	var ctx context.Context
+	ctx, s.stopCompaction = context.WithCancel(context.Background())
+	go s.compactionLoop(ctx)
+}
+
+func (s *Storage) compactionLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.compactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.compactIfNeeded()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// compactIfNeeded compacts the database if free pages take more than the
+// threshold of [WithAutoCompaction]. Decisions are logged, as there is no
+// caller to report them to, and passed to the compaction handler.
+func (s *Storage) compactIfNeeded() {
+	space, err := s.Space()
+	if err != nil {
+		slog.Warn("checking storage free space", slog.Any("error", err))
+		return
+	}
+	c := Compaction{Space: space}
+	attrs := []any{
+		slog.Int64("file_bytes", space.FileBytes),
+		slog.Int64("free_bytes", space.FreeBytes),
+		slog.Float64("free_ratio", space.FreeRatio()),
+	}
+	if space.FreeRatio() <= s.compactThreshold ||
+		space.FreeBytes < autoCompactMinFree {
+		slog.Debug("storage compaction not needed", attrs...)
+		s.reportCompaction(c)
+		return
+	}
+
+	start := s.clock.Now()
+	_, after, err := s.CompactInPlace(nil)
+	c.Compacted = true
+	c.After = after
+	c.Duration = s.clock.Now().Sub(start)
+	c.Err = err
+	if err != nil {
+		slog.Warn(
+			"storage compaction failed",
+			append(attrs, slog.Any("error", err))...,
+		)
+	} else {
+		slog.Info(
+			"storage compacted",
+			append(attrs,
+				slog.Int64("after_bytes", after),
+				slog.Duration("duration", c.Duration),
+			)...,
+		)
+	}
+	s.reportCompaction(c)
+}
+
+func (s *Storage) reportCompaction(c Compaction) {
+	if s.compactionHandler != nil {
+		s.compactionHandler(c)
+	}
+}
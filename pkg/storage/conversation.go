@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// Conversation groups every session held with a peer. Each handshake yields a
+// new session ID, so without it the history with a peer is split across as
+// many sessions as there were connections. A conversation is created with the
+// first session stored for a peer and each later session is attached to it by
+// [Storage.CreateSession].
+type Conversation struct {
+	Created time.Time
+	// Updated is when the latest session was attached.
+	Updated time.Time
+	ID      string
+	PeerKey []byte
+	// Sessions lists the attached session IDs, oldest first.
+	Sessions []string
+}
+
+// ConversationEntry is a chat entry together with the session it belongs to.
+type ConversationEntry struct {
+	ChatEntry
+	SessionID string
+}
+
+var conversationEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// conversationID derives the stable conversation ID of a peer from its public
+// key.
+func conversationID(publicKey []byte) string {
+	return conversationEncoding.EncodeToString(peerKey(publicKey)[:15])
+}
+
+func conversationFromPB(c *pb.Conversation) *Conversation {
+	return &Conversation{
+		ID:       c.GetID(),
+		PeerKey:  c.GetPeerKey(),
+		Created:  c.GetCreated().AsTime(),
+		Updated:  c.GetUpdated().AsTime(),
+		Sessions: c.GetSessions(),
+	}
+}
+
+// getConversation loads a conversation record. It returns [ErrNotFound] if it
+// does not exist.
+func getConversation(b engine.Namespace, id string) (*pb.Conversation, error) {
+	data, err := b.Sub([]byte(engine.ConversationsNamespace)).
+		GetEncrypted([]byte(id))
+	if err != nil {
+		if isMissing(err) {
+			return nil, fmt.Errorf("conversation %s: %w", id, ErrNotFound)
+		}
+		return nil, err
+	}
+	var c pb.Conversation
+	if err := proto.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("unmarshaling conversation: %w", err)
+	}
+	return &c, nil
+}
+
+func putConversation(b engine.Namespace, c *pb.Conversation) error {
+	data, err := proto.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("marshaling conversation: %w", err)
+	}
+	return b.Ensure([]byte(engine.ConversationsNamespace)).
+		PutEncrypted([]byte(c.GetID()), data)
+}
+
+// attachSession adds a session to its peer's conversation, creating the
+// conversation if needed. A new conversation also picks up the peer's sessions
+// stored before conversations existed.
+func (s *Storage) attachSession(
+	b engine.Namespace, sessionID string, publicKey []byte,
+) error {
+	id := conversationID(publicKey)
+	now := s.clock.Now()
+	c, err := getConversation(b, id)
+	switch {
+	case err == nil: // continue
+	case errors.Is(err, ErrNotFound):
+		c = &pb.Conversation{
+			ID:       id,
+			PeerKey:  publicKey,
+			Created:  timestamppb.New(now),
+			Sessions: legacySessions(b, publicKey),
+		}
+	default:
+		return err
+	}
+
+	if !slices.Contains(c.Sessions, sessionID) {
+		c.Sessions = append(c.Sessions, sessionID)
+	}
+	c.Updated = timestamppb.New(now)
+	if err := putConversation(b, c); err != nil {
+		return err
+	}
+	return sessionMeta(b, sessionID).PutEncrypted(
+		[]byte(ConversationKey), []byte(id),
+	)
+}
+
+// legacySessions returns the peer's sessions that are not attached to a
+// conversation, ordered by establishment time.
+func legacySessions(b engine.Namespace, publicKey []byte) []string {
+	type session struct {
+		established time.Time
+		id          string
+	}
+	var found []session
+	sessions := b.Sub([]byte(engine.SessionsNamespace))
+	for _, sid := range sessions.ListSubNamespaces() {
+		meta := sessionMeta(b, sid)
+		key, err := meta.GetEncrypted([]byte(PeerKey))
+		if err != nil || !bytes.Equal(key, publicKey) {
+			continue
+		}
+		if _, err := meta.GetEncrypted([]byte(ConversationKey)); err == nil {
+			continue
+		}
+		var established time.Time
+		if ts, err := meta.GetEncrypted([]byte(EstablishedAtKey)); err == nil &&
+			len(ts) == 8 {
+			established = time.Unix(0, int64(binary.BigEndian.Uint64(ts)))
+		}
+		found = append(found, session{established, sid})
+	}
+	slices.SortFunc(found, func(a, b session) int {
+		if c := a.established.Compare(b.established); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.id, b.id)
+	})
+
+	ids := make([]string, len(found))
+	for i, f := range found {
+		ids[i] = f.id
+	}
+	return ids
+}
+
+// detachSession removes a session from its conversation. The conversation is
+// deleted along with its last session. It must be called before the session's
+// metadata is deleted.
+func detachSession(b engine.Namespace, sessionID string) error {
+	id, err := sessionMeta(b, sessionID).GetEncrypted([]byte(ConversationKey))
+	if err != nil {
+		return nil
+	}
+	c, err := getConversation(b, string(id))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	c.Sessions = slices.DeleteFunc(c.Sessions, func(sid string) bool {
+		return sid == sessionID
+	})
+	if len(c.Sessions) == 0 {
+		return b.Sub([]byte(engine.ConversationsNamespace)).Delete(id)
+	}
+	return putConversation(b, c)
+}
+
+// GetConversation returns the conversation with the given ID, or
+// [ErrNotFound].
+func (s *Storage) GetConversation(id string) (*Conversation, error) {
+	var c *pb.Conversation
+	err := s.engine.Query(func(b engine.Namespace) error {
+		var err error
+		c, err = getConversation(b, id)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get conversation: %w", err)
+	}
+	return conversationFromPB(c), nil
+}
+
+// FindConversationByPeer returns the conversation with the peer owning the
+// given public key (44-byte PKIX form), or [ErrNotFound] if no session has
+// been stored for it.
+func (s *Storage) FindConversationByPeer(
+	publicKey []byte,
+) (*Conversation, error) {
+	return s.GetConversation(conversationID(publicKey))
+}
+
+// ListConversations returns all conversations, most recently updated first.
+func (s *Storage) ListConversations() ([]Conversation, error) {
+	var convs []Conversation
+	err := s.engine.Query(func(b engine.Namespace) error {
+		ns := b.Sub([]byte(engine.ConversationsNamespace))
+		for _, data := range ns.IterateEncrypted() {
+			var c pb.Conversation
+			if err := proto.Unmarshal(data, &c); err != nil {
+				return fmt.Errorf("unmarshaling conversation: %w", err)
+			}
+			convs = append(convs, *conversationFromPB(&c))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing conversations: %w", err)
+	}
+	slices.SortFunc(convs, func(a, b Conversation) int {
+		return b.Updated.Compare(a.Updated)
+	})
+	return convs, nil
+}
+
+// GetConversationHistory returns the chat entries of every session in the
+// conversation, merged and sorted as by [Storage.GetChatHistory].
+func (s *Storage) GetConversationHistory(
+	id string,
+) ([]ConversationEntry, error) {
+	var entries []ConversationEntry
+	err := s.engine.Query(func(b engine.Namespace) error {
+		c, err := getConversation(b, id)
+		if err != nil {
+			return err
+		}
+		for _, sid := range c.GetSessions() {
+			ck := chatCipher(b, sid)
+			for key, value := range sessionChat(b, sid).IterateEncrypted() {
+				if entry, ok := openChatEntry(ck, key, value); ok {
+					entries = append(entries, ConversationEntry{entry, sid})
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("querying conversation history: %w", err)
+	}
+
+	slices.SortFunc(entries, func(a, b ConversationEntry) int {
+		return compareChatEntries(a.ChatEntry, b.ChatEntry)
+	})
+	return entries, nil
+}
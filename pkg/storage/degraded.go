@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// ErrStorageUnavailable is returned when a write fails because the disk
+// holding the storage is full or failing. See [Storage.Degraded].
+var ErrStorageUnavailable = engine.ErrUnavailable
+
+const (
+	// maxPendingChatEntries caps the chat entries kept in memory while the
+	// storage is unavailable.
+	maxPendingChatEntries = 1024
+
+	// minChatRetry and maxChatRetry bound the backoff between attempts to
+	// write the pending chat entries.
+	minChatRetry = 500 * time.Millisecond
+	maxChatRetry = 30 * time.Second
+)
+
+// chatWrite is a chat entry, encoded and keyed, ready to be written.
+type chatWrite struct {
+	ts        time.Time
+	sessionID string
+	key       []byte
+	enc       []byte
+	payload   []byte
+	hlc       uint64
+	sender    Sender
+}
+
+// chatRetry holds the chat entries that could not be written while the
+// storage is unavailable, in the order they were added.
+type chatRetry struct {
+	// err is the last write failure, or nil while the storage is healthy.
+	err     error
+	pending []*chatWrite
+	stop    context.CancelFunc
+	mu      sync.Mutex
+	closed  bool
+}
+
+// WithDegradedHandler sets a function that is called with the error when
+// chat entries start failing to be written with [ErrStorageUnavailable], and
+// with nil once they have all been written. It runs on the goroutine that
+// noticed the change, which may be a background one.
+func WithDegradedHandler(fn func(err error)) StorageOption {
+	return func(p *Storage) { p.degradedHandler = fn }
+}
+
+// Degraded returns the error chat entries last failed to be written with, as
+// long as some are still waiting to be, or nil if the storage is healthy.
+func (s *Storage) Degraded() error {
+	s.retry.mu.Lock()
+	defer s.retry.mu.Unlock()
+	return s.retry.err
+}
+
+// deferChatEntry queues w behind the entries already waiting for the storage
+// to recover, if there are any, so that a failing disk is not tried again on
+// every message. It reports whether w was queued.
+func (s *Storage) deferChatEntry(w *chatWrite) bool {
+	r := &s.retry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil || r.closed || len(r.pending) >= maxPendingChatEntries {
+		return false
+	}
+	r.pending = append(r.pending, w)
+	return true
+}
+
+// retryChatEntry queues w, which failed to be written with err, and starts
+// retrying in the background. It reports false if the queue is full.
+func (s *Storage) retryChatEntry(w *chatWrite, err error) bool {
+	r := &s.retry
+	r.mu.Lock()
+	if r.closed || len(r.pending) >= maxPendingChatEntries {
+		r.mu.Unlock()
+		return false
+	}
+	r.pending = append(r.pending, w)
+	degraded := r.err == nil
+	r.err = err
+	var ctx context.Context
+	if r.stop == nil {
+		ctx, r.stop = context.WithCancel(context.Background())
+		go s.retryLoop(ctx)
+	}
+	r.mu.Unlock()
+
+	if degraded {
+		slog.Warn(
+			"storage unavailable, keeping chat entries in memory",
+			slog.Any("error", err),
+		)
+		if s.degradedHandler != nil {
+			s.degradedHandler(err)
+		}
+	}
+	return true
+}
+
+// retryLoop writes the pending chat entries, backing off while the storage
+// stays unavailable, until there are none left.
+func (s *Storage) retryLoop(ctx context.Context) {
+	r := &s.retry
+	backoff := minChatRetry
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		if err := s.flushChatEntries(); err != nil {
+			r.mu.Lock()
+			r.err = err
+			r.mu.Unlock()
+			backoff = min(2*backoff, maxChatRetry)
+			timer.Reset(backoff)
+			continue
+		}
+
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			return
+		}
+		if len(r.pending) > 0 {
+			// Added while the last ones were written.
+			r.mu.Unlock()
+			backoff = minChatRetry
+			timer.Reset(0)
+			continue
+		}
+		r.err = nil
+		r.stop()
+		r.stop = nil
+		r.mu.Unlock()
+
+		slog.Info("storage recovered, pending chat entries written")
+		if s.degradedHandler != nil {
+			s.degradedHandler(nil)
+		}
+		return
+	}
+}
+
+// flushChatEntries writes the pending chat entries in order, and returns the
+// error that stopped it if the storage is still unavailable. Entries that
+// fail for any other reason are dropped, as they never will be written.
+func (s *Storage) flushChatEntries() error {
+	r := &s.retry
+	for {
+		r.mu.Lock()
+		if r.closed || len(r.pending) == 0 {
+			r.mu.Unlock()
+			return nil
+		}
+		w := r.pending[0]
+		r.mu.Unlock()
+
+		err := s.writeChatEntry(w)
+		if errors.Is(err, ErrStorageUnavailable) {
+			return err
+		}
+		if err != nil {
+			slog.Error(
+				"dropped pending chat entry",
+				slog.String("session_id", w.sessionID),
+				slog.Any("error", err),
+			)
+		}
+		r.mu.Lock()
+		if len(r.pending) > 0 {
+			r.pending = r.pending[1:]
+		}
+		r.mu.Unlock()
+	}
+}
+
+// stopRetry stops retrying, dropping the entries that are still pending.
+func (s *Storage) stopRetry() {
+	r := &s.retry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	if r.stop != nil {
+		r.stop()
+		r.stop = nil
+	}
+	if len(r.pending) > 0 {
+		slog.Warn(
+			"storage closed with unwritten chat entries",
+			slog.Int("entries", len(r.pending)),
+		)
+		r.pending = nil
+	}
+}
@@ -0,0 +1,113 @@
+package storage
+
+// BucketClass is a class of stored records whose changes can be subscribed to
+// with [Storage.Subscribe].
+type BucketClass int
+
+const (
+	// ChatBucket is the chat history of every session.
+	ChatBucket BucketClass = iota
+	// PeersBucket is the known peers.
+	PeersBucket
+	// SessionsBucket is the session records and their names.
+	SessionsBucket
+)
+
+// EventOp is the kind of change an [Event] reports.
+type EventOp int
+
+const (
+	EventAdded EventOp = iota
+	EventUpdated
+	EventDeleted
+)
+
+// Event reports a change to stored records.
+type Event struct {
+	// Entry is the chat entry added, for ChatBucket additions.
+	Entry *ChatEntry
+	// SessionID is the session that changed, for ChatBucket and
+	// SessionsBucket events.
+	SessionID string
+	// PublicKey is the public key of the peer that changed, for PeersBucket
+	// events.
+	PublicKey []byte
+	Class     BucketClass
+	Op        EventOp
+}
+
+// subscription is a callback registered with [Storage.Subscribe].
+type subscription struct {
+	fn    func(Event)
+	class BucketClass
+}
+
+// subscribers holds the callbacks registered with [Storage.Subscribe].
+type subscribers struct {
+	subs map[uint64]subscription
+	next uint64
+}
+
+// Subscribe registers fn to be called with every change made through the
+// storage to records of class, after the change is persisted, so that views
+// such as a history viewer, contact list or session panel can update as the
+// data changes instead of re-querying it or keeping copies of their own:
+//   - ChatBucket reports entries added with [Storage.AddChatEntry], and
+//     deletions of a session's entries through quotas or
+//     [Storage.PruneChatHistory], with the session's ID;
+//   - PeersBucket reports peers stored, seen, given new addresses, deleted,
+//     or removed once expired, with their public key;
+//   - SessionsBucket reports sessions created, renamed, or deleted, with
+//     their ID. The chat history of a deleted session goes with it, without
+//     a ChatBucket event of its own.
+//
+// Callbacks run synchronously on the goroutine that made the change and must
+// not call Subscribe or the returned function themselves; they may read from
+// the storage. Calling the returned function unregisters fn.
+func (s *Storage) Subscribe(
+	class BucketClass, fn func(Event),
+) (cancel func()) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+	if s.subscribers.subs == nil {
+		s.subscribers.subs = make(map[uint64]subscription)
+	}
+	id := s.subscribers.next
+	s.subscribers.next++
+	s.subscribers.subs[id] = subscription{fn: fn, class: class}
+	return func() {
+		s.hooksMu.Lock()
+		defer s.hooksMu.Unlock()
+		delete(s.subscribers.subs, id)
+	}
+}
+
+// notify calls the callbacks subscribed to the class of every event, in
+// order. It must not be called from within a transaction.
+func (s *Storage) notify(events ...Event) {
+	if len(events) == 0 {
+		return
+	}
+	s.hooksMu.Lock()
+	subs := make([]subscription, 0, len(s.subscribers.subs))
+	for _, sub := range s.subscribers.subs {
+		subs = append(subs, sub)
+	}
+	s.hooksMu.Unlock()
+
+	for _, e := range events {
+		for _, sub := range subs {
+			if sub.class == e.Class {
+				sub.fn(e)
+			}
+		}
+	}
+}
+
+func peerEvent(op EventOp, publicKey []byte) Event {
+	return Event{Class: PeersBucket, Op: op, PublicKey: publicKey}
+}
+
+func sessionEvent(op EventOp, sessionID string) Event {
+	return Event{Class: SessionsBucket, Op: op, SessionID: sessionID}
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// GroupState is what is kept of a group chat: its identity, its membership,
+// and how far its messages have been ordered.
+type GroupState struct {
+	// Created is when the group was created or joined.
+	Created time.Time `json:"created"`
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	// Owner is the public key of the member that orders the group's
+	// messages and decides its membership.
+	Owner []byte `json:"owner"`
+	// Members are the public keys of every member, the owner included.
+	Members [][]byte `json:"members"`
+	// Sequence is the number of the last message ordered by the owner: the
+	// last one assigned, for the owner, and the last one received, for the
+	// other members.
+	Sequence uint64 `json:"sequence"`
+}
+
+// SaveGroup stores g under its ID, replacing any state saved before.
+func (s *Storage) SaveGroup(g GroupState) error {
+	if g.ID == "" {
+		return errors.New("group state has no ID")
+	}
+	if len(g.Owner) == 0 {
+		return ErrInvalidPublicKey
+	}
+	data, err := json.Marshal(g)
+	if err != nil {
+		return fmt.Errorf("marshalling group state: %w", err)
+	}
+	err = s.engine.Command(func(b engine.Namespace) error {
+		return b.Ensure([]byte(engine.GroupsNamespace)).
+			PutEncrypted([]byte(g.ID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("saving group state: %w", err)
+	}
+	return nil
+}
+
+// Group returns the state saved for the group with the given ID, or
+// [ErrNotFound] if there is none.
+func (s *Storage) Group(id string) (GroupState, error) {
+	var g GroupState
+	err := s.engine.Query(func(b engine.Namespace) error {
+		data, err := b.Sub([]byte(engine.GroupsNamespace)).
+			GetEncrypted([]byte(id))
+		if isMissing(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &g); err != nil {
+			return fmt.Errorf("unmarshalling group state: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return GroupState{}, fmt.Errorf("getting group state: %w", err)
+	}
+	return g, nil
+}
+
+// Groups returns the states of every group saved, oldest first.
+func (s *Storage) Groups() ([]GroupState, error) {
+	var groups []GroupState
+	err := s.engine.Query(func(b engine.Namespace) error {
+		ns := b.Sub([]byte(engine.GroupsNamespace))
+		for _, v := range ns.IterateEncrypted() {
+			var g GroupState
+			if err := json.Unmarshal(v, &g); err != nil {
+				return fmt.Errorf("unmarshalling group state: %w", err)
+			}
+			groups = append(groups, g)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing group states: %w", err)
+	}
+	slices.SortFunc(groups, func(a, b GroupState) int {
+		return cmp.Or(a.Created.Compare(b.Created), cmp.Compare(a.ID, b.ID))
+	})
+	return groups, nil
+}
+
+// DeleteGroup deletes the state saved for the group with the given ID.
+// Deleting a state that does not exist is not an error.
+func (s *Storage) DeleteGroup(id string) error {
+	err := s.engine.Command(func(b engine.Namespace) error {
+		return b.Sub([]byte(engine.GroupsNamespace)).Delete([]byte(id))
+	})
+	if err != nil && !isMissing(err) {
+		return fmt.Errorf("deleting group state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/kamune-org/kamune/internal/engine"
+	"github.com/kamune-org/kamune/pkg/attest"
+)
+
+var identityKey = []byte("attest")
+
+// Attester returns the storage's identity, generating and persisting one if
+// the storage has none yet. With [WithRequireExistingIdentity], a missing
+// identity is reported as [ErrIdentityNotFound] instead.
+func (s *Storage) Attester() (*attest.Attest, error) {
+	at, err := s.LoadIdentity()
+	if !errors.Is(err, ErrIdentityNotFound) || s.requireIdentity {
+		return at, err
+	}
+	at, err = s.CreateIdentity()
+	if errors.Is(err, ErrIdentityExists) {
+		// Another caller created it first.
+		return s.LoadIdentity()
+	}
+	return at, err
+}
+
+// LoadIdentity returns the storage's identity, or [ErrIdentityNotFound] if
+// none has been created.
+func (s *Storage) LoadIdentity() (*attest.Attest, error) {
+	var id []byte
+	err := s.engine.Query(func(b engine.Namespace) error {
+		var err error
+		id, err = b.Sub([]byte(engine.DefaultNamespace)).
+			GetEncrypted(identityKey)
+		return err
+	})
+	switch {
+	case err == nil:
+		return attest.Load(id)
+	case isMissing(err):
+		return nil, ErrIdentityNotFound
+	default:
+		return nil, fmt.Errorf("getting identity: %w", err)
+	}
+}
+
+// CreateIdentity generates and persists a new identity. It fails with
+// [ErrIdentityExists], leaving the storage untouched, if the storage already
+// has one; the check and the write happen in a single transaction.
+func (s *Storage) CreateIdentity() (*attest.Attest, error) {
+	at, err := attest.New()
+	if err != nil {
+		return nil, fmt.Errorf("new attest: %w", err)
+	}
+	data, err := at.MarshalPrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("marshalling private key: %w", err)
+	}
+	if err := s.putIdentity(data); err != nil {
+		return nil, err
+	}
+
+	slog.Info("created new identity", slog.String("db_path", s.dbPath))
+	if s.identityHandler != nil {
+		s.identityHandler(at.MarshalPublicKey())
+	}
+	return at, nil
+}
+
+// putIdentity persists the marshalled private key data as the storage's
+// identity, unless it already has one.
+func (s *Storage) putIdentity(data []byte) error {
+	err := s.engine.Command(func(b engine.Namespace) error {
+		ns := b.Ensure([]byte(engine.DefaultNamespace))
+		_, err := ns.GetEncrypted(identityKey)
+		switch {
+		case err == nil:
+			return ErrIdentityExists
+		case !isMissing(err):
+			return fmt.Errorf("getting identity: %w", err)
+		}
+		return ns.PutEncrypted(identityKey, data)
+	})
+	if errors.Is(err, ErrIdentityExists) {
+		return ErrIdentityExists
+	}
+	if err != nil {
+		return fmt.Errorf("persisting identity: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// journalEntrySize is the encoded size of a [JournalEntry] without its ID and
+// hash: state, route, sequence, and time.
+const journalEntrySize = 1 + 4 + 8 + 8
+
+// MessageState is the delivery state of a journaled outgoing message.
+type MessageState uint8
+
+const (
+	// MessagePending is the state of a message about to be written to the
+	// connection. A message left pending may or may not have left the
+	// process.
+	MessagePending MessageState = iota + 1
+	// MessageSent is the state of a message written to the connection but not
+	// yet acknowledged with [Storage.AckMessage].
+	MessageSent
+)
+
+// String returns the string representation of the state.
+func (s MessageState) String() string {
+	switch s {
+	case MessagePending:
+		return "Pending"
+	case MessageSent:
+		return "Sent"
+	default:
+		return "Invalid"
+	}
+}
+
+// JournalEntry records an outgoing application message of a session. It holds
+// the message's hash rather than its payload, so the application can match it
+// against its own outbox.
+type JournalEntry struct {
+	Time time.Time
+	// ID is the message ID, as reported by its metadata.
+	ID string
+	// Hash is the SHA-256 of the deterministically marshaled message.
+	Hash     []byte
+	Sequence uint64
+	Route    int32
+	State    MessageState
+}
+
+func sessionJournal(b engine.Namespace, sessionID string) engine.Namespace {
+	return b.Sub([]byte(engine.SessionsNamespace)).
+		Sub([]byte(sessionID)).
+		Ensure([]byte("journal"))
+}
+
+func (e JournalEntry) marshal() []byte {
+	buf := make([]byte, journalEntrySize, journalEntrySize+len(e.Hash))
+	buf[0] = byte(e.State)
+	binary.BigEndian.PutUint32(buf[1:], uint32(e.Route))
+	binary.BigEndian.PutUint64(buf[5:], e.Sequence)
+	binary.BigEndian.PutUint64(buf[13:], uint64(e.Time.UnixNano()))
+	return append(buf, e.Hash...)
+}
+
+func unmarshalJournalEntry(id, data []byte) (JournalEntry, error) {
+	if len(data) < journalEntrySize {
+		return JournalEntry{}, fmt.Errorf("journal entry %s is truncated", id)
+	}
+	return JournalEntry{
+		ID:       string(id),
+		State:    MessageState(data[0]),
+		Route:    int32(binary.BigEndian.Uint32(data[1:])),
+		Sequence: binary.BigEndian.Uint64(data[5:]),
+		Time:     time.Unix(0, int64(binary.BigEndian.Uint64(data[13:]))),
+		Hash:     slices.Clone(data[journalEntrySize:]),
+	}, nil
+}
+
+// JournalMessage records an outgoing message of the session, or updates the
+// state of the one with the same ID. The session must have been created with
+// [Storage.CreateSession].
+func (s *Storage) JournalMessage(sessionID string, e JournalEntry) error {
+	if e.ID == "" {
+		return errors.New("journal entry has no ID")
+	}
+	if e.Time.IsZero() {
+		e.Time = s.clock.Now()
+	}
+	err := s.engine.Command(func(b engine.Namespace) error {
+		return sessionJournal(b, sessionID).PutEncrypted(
+			[]byte(e.ID), e.marshal(),
+		)
+	})
+	if isMissing(err) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("journaling message %s: %w", e.ID, err)
+	}
+	return nil
+}
+
+// AckMessage removes the message with the given ID from the session's
+// journal once the application knows the peer has it. Acknowledging an
+// unknown message is not an error.
+func (s *Storage) AckMessage(sessionID, id string) error {
+	err := s.engine.Command(func(b engine.Namespace) error {
+		return sessionJournal(b, sessionID).Delete([]byte(id))
+	})
+	if err != nil && !isMissing(err) {
+		return fmt.Errorf("acknowledging message %s: %w", id, err)
+	}
+	return nil
+}
+
+// UnconfirmedMessages returns the journaled messages of the session that were
+// not acknowledged, oldest first. After a crash, these are the messages the
+// application may have to send again.
+func (s *Storage) UnconfirmedMessages(
+	sessionID string,
+) ([]JournalEntry, error) {
+	var entries []JournalEntry
+	err := s.engine.Query(func(b engine.Namespace) error {
+		journal := b.Sub([]byte(engine.SessionsNamespace)).
+			Sub([]byte(sessionID)).
+			Sub([]byte("journal"))
+		for id, data := range journal.IterateEncrypted() {
+			e, err := unmarshalJournalEntry(id, data)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading journal of %s: %w", sessionID, err)
+	}
+	slices.SortFunc(entries, func(a, b JournalEntry) int {
+		return cmp.Or(
+			a.Time.Compare(b.Time), cmp.Compare(a.Sequence, b.Sequence),
+		)
+	})
+	return entries, nil
+}
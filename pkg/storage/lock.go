@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// Lock drops the data encryption key from memory. Until [Storage.Unlock] is
+// called, every method that reads or writes the database fails with
+// [ErrStorageLocked], and so do the kamune operations that need it, such as
+// dialing, accepting, or resuming sessions. Established transports keep their
+// session keys and are not affected.
+//
+// Lock returns [ErrLockUnsupported] if the backend has no key to drop, as with
+// [WithInMemory].
+func (s *Storage) Lock() error {
+	l, ok := s.engine.(engine.Locker)
+	if !ok {
+		return ErrLockUnsupported
+	}
+	s.lockMu.Lock()
+	wasLocked := l.Locked()
+	l.Lock()
+	s.lockMu.Unlock()
+
+	if !wasLocked && s.lockHandler != nil {
+		s.lockHandler()
+	}
+	return nil
+}
+
+// Unlock restores the data encryption key from passphrase and restarts the
+// idle timer of [WithAutoLock]. On a storage that is not locked, it only
+// checks the passphrase.
+func (s *Storage) Unlock(passphrase []byte) error {
+	l, ok := s.engine.(engine.Locker)
+	if !ok {
+		return ErrLockUnsupported
+	}
+	s.lockMu.Lock()
+	defer s.lockMu.Unlock()
+	if err := l.Unlock(passphrase); err != nil {
+		return fmt.Errorf("unlocking: %w", err)
+	}
+	s.lastActive = s.clock.Now()
+	return nil
+}
+
+// Locked reports whether the storage is locked.
+func (s *Storage) Locked() bool {
+	l, ok := s.engine.(engine.Locker)
+	return ok && l.Locked()
+}
+
+// Touch records user activity, postponing the lock of [WithAutoLock].
+// Applications call it on input such as key presses. Traffic on open sessions
+// does not count, so an unattended application locks even while messages
+// arrive.
+func (s *Storage) Touch() {
+	s.lockMu.Lock()
+	defer s.lockMu.Unlock()
+	s.lastActive = s.clock.Now()
+}
+
+func (s *Storage) startAutoLock() {
+	if s.autoLock <= 0 {
+		return
+	}
+	if _, ok := s.engine.(engine.Locker); !ok {
+		return
+	}
+	s.lastActive = s.clock.Now()
+
+	var ctx context.Context
+	ctx, s.stopAutoLock = context.WithCancel(context.Background())
+	go s.autoLockLoop(ctx)
+}
+
+func (s *Storage) autoLockLoop(ctx context.Context) {
+	ticker := time.NewTicker(max(min(s.autoLock/4, time.Minute), time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.lockIfIdle()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// lockIfIdle locks the storage if it has seen no activity for the auto-lock
+// duration.
+func (s *Storage) lockIfIdle() {
+	s.lockMu.Lock()
+	idle := s.clock.Now().Sub(s.lastActive)
+	s.lockMu.Unlock()
+
+	if idle < s.autoLock || s.Locked() {
+		return
+	}
+	// startAutoLock made sure that the backend is a Locker.
+	_ = s.Lock()
+}
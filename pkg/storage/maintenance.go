@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// ErrInspectUnsupported is returned by [Storage.Inspect] for backends that
+// cannot report their statistics.
+var ErrInspectUnsupported = errors.New("storage backend cannot be inspected")
+
+// InspectDB returns statistics for every top-level bucket of the database at
+// path. The database is opened read-only and nothing is decrypted, so no
+// passphrase is needed. If another process holds the database open for longer
+// than timeout, [ErrStoreInUse] is returned.
+func InspectDB(path string, timeout time.Duration) ([]BucketStats, error) {
+	return engine.InspectBoltDB(path, timeout)
+}
+
+// Inspect returns statistics for every top-level bucket of the open store, as
+// [InspectDB] does for a closed one. Backends other than BoltDB return
+// [ErrInspectUnsupported].
+func (s *Storage) Inspect() ([]BucketStats, error) {
+	i, ok := s.engine.(engine.Inspector)
+	if !ok {
+		return nil, ErrInspectUnsupported
+	}
+	return i.Inspect()
+}
+
+// CompactDB rewrites the database at path to release the space left behind by
+// deleted data, and returns its size in bytes before and after. Like
+// [InspectDB], it needs no passphrase and fails with [ErrStoreInUse] while the
+// database is open elsewhere.
+func CompactDB(
+	path string, timeout time.Duration,
+) (before, after int64, err error) {
+	return engine.CompactBoltDB(path, timeout)
+}
+
+// PruneExpiredPeers removes every peer past the expiry duration (see
+// [WithExpiryDuration]) and returns how many were removed.
+func (s *Storage) PruneExpiredPeers() (int, error) {
+	var events []Event
+	err := s.engine.Command(func(b engine.Namespace) error {
+		peers := b.Sub([]byte(engine.PeersNamespace))
+		cutoff := s.clock.Now().Add(-s.expiryDuration)
+		var expired [][]byte
+		for key, value := range peers.IterateEncrypted() {
+			var p pb.Peer
+			if err := proto.Unmarshal(value, &p); err != nil {
+				continue
+			}
+			if p.FirstSeen.AsTime().Before(cutoff) {
+				expired = append(expired, bytes.Clone(key))
+				events = append(events, peerEvent(EventDeleted, p.PublicKey))
+			}
+		}
+		for _, key := range expired {
+			if err := peers.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("pruning peers: %w", err)
+	}
+	s.notify(events...)
+	return len(events), nil
+}
+
+// lastActivity returns the later of a session's establishment time and its
+// most recent chat entry.
+func lastActivity(b engine.Namespace, sessionID string) time.Time {
+	var last time.Time
+	ts, err := sessionMeta(b, sessionID).GetEncrypted([]byte(EstablishedAtKey))
+	if err == nil && len(ts) == 8 {
+		last = time.Unix(0, int64(binary.BigEndian.Uint64(ts)))
+	}
+	if key := sessionChat(b, sessionID).LastKey(); len(key) >= 8 {
+		chat := time.Unix(0, int64(binary.BigEndian.Uint64(key[:8])))
+		if chat.After(last) {
+			last = chat
+		}
+	}
+	return last
+}
+
+// PruneSessions deletes every session without activity since before, as
+// [Storage.DeleteSession] would, and returns how many were deleted.
+func (s *Storage) PruneSessions(before time.Time) (int, error) {
+	var events []Event
+	err := s.engine.Command(func(b engine.Namespace) error {
+		sessions := b.Sub([]byte(engine.SessionsNamespace))
+		for _, sid := range sessions.ListSubNamespaces() {
+			if !lastActivity(b, sid).Before(before) {
+				continue
+			}
+			if err := deleteSession(b, sid); err != nil {
+				return fmt.Errorf("session %s: %w", sid, err)
+			}
+			events = append(events, sessionEvent(EventDeleted, sid))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("pruning sessions: %w", err)
+	}
+	s.notify(events...)
+	return len(events), nil
+}
+
+// PruneChatHistory deletes the chat entries of all sessions that were stored
+// before the given time, and returns how many were deleted. The search index
+// is rebuilt if any entry was removed.
+func (s *Storage) PruneChatHistory(before time.Time) (int, error) {
+	var cutoff [8]byte
+	binary.BigEndian.PutUint64(cutoff[:], uint64(before.UnixNano()))
+
+	var n int
+	var events []Event
+	err := s.engine.Command(func(b engine.Namespace) error {
+		sessions := b.Sub([]byte(engine.SessionsNamespace))
+		for _, sid := range sessions.ListSubNamespaces() {
+			chat := sessionChat(b, sid)
+			var expired [][]byte
+			var size int64
+			for key, value := range chat.IterateEncrypted() {
+				if bytes.Compare(key[:min(len(key), 8)], cutoff[:]) >= 0 {
+					break
+				}
+				expired = append(expired, bytes.Clone(key))
+				size += int64(len(value))
+			}
+			for _, key := range expired {
+				if err := chat.Delete(key); err != nil {
+					return err
+				}
+			}
+			if err := adjustChatBytes(b, sid, -size); err != nil {
+				return err
+			}
+			n += len(expired)
+			if len(expired) > 0 {
+				events = append(events, Event{
+					Class: ChatBucket, Op: EventDeleted, SessionID: sid,
+				})
+			}
+		}
+
+		if _, indexed := loadSearchIndex(b); n == 0 || !indexed {
+			return nil
+		}
+		if err := dropSearchIndex(b); err != nil {
+			return err
+		}
+		if s.searchIndex {
+			_, err := ensureSearchIndex(b)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("pruning chat history: %w", err)
+	}
+	s.notify(events...)
+	return n, nil
+}
+
+// PruneResumptionTokens deletes the unused resumption tokens of sessions
+// established before the given time, which can no longer be resumed, and
+// returns how many sessions were affected.
+func (s *Storage) PruneResumptionTokens(before time.Time) (int, error) {
+	var n int
+	err := s.engine.Command(func(b engine.Namespace) error {
+		sessions := b.Sub([]byte(engine.SessionsNamespace))
+		for _, sid := range sessions.ListSubNamespaces() {
+			meta := sessionMeta(b, sid)
+			if _, err := meta.GetEncrypted(
+				[]byte(ResumptionTokensKey),
+			); err != nil {
+				continue
+			}
+			ts, err := meta.GetEncrypted([]byte(EstablishedAtKey))
+			if err != nil || len(ts) != 8 {
+				continue
+			}
+			established := time.Unix(0, int64(binary.BigEndian.Uint64(ts)))
+			if !established.Before(before) {
+				continue
+			}
+			if err := meta.Delete([]byte(ResumptionTokensKey)); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("pruning resumption tokens: %w", err)
+	}
+	return n, nil
+}
+
+// RotatePassphrase re-wraps the data encryption key with a new passphrase.
+// Stored data is not re-encrypted.
+func (s *Storage) RotatePassphrase(old, new []byte) error {
+	if err := s.engine.RotatePassphrase(old, new); err != nil {
+		return fmt.Errorf("rotating passphrase: %w", err)
+	}
+	return nil
+}
+
+// RotateDataKey generates a new data encryption key, wraps it with the new
+// passphrase, and re-encrypts every stored value. Pass the same passphrase
+// twice to keep it.
+func (s *Storage) RotateDataKey(old, new []byte) error {
+	if err := s.engine.RotateDataKey(old, new); err != nil {
+		return fmt.Errorf("rotating data key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+const (
+	// maxDisplayNameLength is the longest display name, in bytes, that
+	// [Storage.SetDisplayName] accepts.
+	maxDisplayNameLength = 64
+	// maxNameHistory is the number of names kept per peer; older ones are
+	// dropped first.
+	maxNameHistory = 16
+)
+
+// ErrInvalidDisplayName is returned by [Storage.SetDisplayName] for names
+// that are not valid UTF-8 or are longer than 64 bytes.
+var ErrInvalidDisplayName = errors.New("invalid display name")
+
+var displayNameKey = []byte("display-name")
+
+// PeerName is a name a peer introduced itself with.
+type PeerName struct {
+	Name string `json:"name"`
+	// Since is when the peer first established a session under the name.
+	Since time.Time `json:"since"`
+}
+
+// SetDisplayName sets the name the storage's identity introduces itself with,
+// in place of the fingerprint of its public key. An empty name removes it.
+func (s *Storage) SetDisplayName(name string) error {
+	if len(name) > maxDisplayNameLength || !utf8.ValidString(name) {
+		return ErrInvalidDisplayName
+	}
+	err := s.engine.Command(func(b engine.Namespace) error {
+		ns := b.Ensure([]byte(engine.DefaultNamespace))
+		if name == "" {
+			return ns.Delete(displayNameKey)
+		}
+		return ns.PutEncrypted(displayNameKey, []byte(name))
+	})
+	if err != nil && !isMissing(err) {
+		return fmt.Errorf("setting display name: %w", err)
+	}
+	return nil
+}
+
+// DisplayName returns the name set with [Storage.SetDisplayName], or an empty
+// string if there is none.
+func (s *Storage) DisplayName() (string, error) {
+	var name []byte
+	err := s.engine.Query(func(b engine.Namespace) error {
+		var err error
+		name, err = b.Sub([]byte(engine.DefaultNamespace)).
+			GetEncrypted(displayNameKey)
+		if isMissing(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("getting display name: %w", err)
+	}
+	return string(name), nil
+}
+
+// RecordPeerName records that the peer with the given public key established
+// a session under name at t, or now if t is zero. The name is added to the
+// peer's history, and a [PeersBucket] update is reported, only if it differs
+// from the last one recorded. Only the last 16 names are kept.
+func (s *Storage) RecordPeerName(
+	publicKey []byte, name string, t time.Time,
+) error {
+	if len(publicKey) == 0 {
+		return ErrInvalidPublicKey
+	}
+	if t.IsZero() {
+		t = s.clock.Now()
+	}
+	key := peerKey(publicKey)
+	var changed bool
+	err := s.engine.Command(func(b engine.Namespace) error {
+		ns := b.Ensure([]byte(engine.NamesNamespace))
+		history, err := getNameHistory(ns, key)
+		if err != nil {
+			return err
+		}
+		if n := len(history); n > 0 && history[n-1].Name == name {
+			return nil
+		}
+		history = append(history, PeerName{Name: name, Since: t})
+		if len(history) > maxNameHistory {
+			history = history[len(history)-maxNameHistory:]
+		}
+		data, err := json.Marshal(history)
+		if err != nil {
+			return fmt.Errorf("marshalling name history: %w", err)
+		}
+		changed = true
+		return ns.PutEncrypted(key, data)
+	})
+	if err != nil {
+		return fmt.Errorf("recording peer name: %w", err)
+	}
+	if changed {
+		s.notify(peerEvent(EventUpdated, publicKey))
+	}
+	return nil
+}
+
+// PeerNameHistory returns the names recorded for the peer with the given
+// public key by [Storage.RecordPeerName], oldest first, or nil if there are
+// none.
+func (s *Storage) PeerNameHistory(publicKey []byte) ([]PeerName, error) {
+	var history []PeerName
+	err := s.engine.Query(func(b engine.Namespace) error {
+		var err error
+		history, err = getNameHistory(
+			b.Sub([]byte(engine.NamesNamespace)), peerKey(publicKey),
+		)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting peer name history: %w", err)
+	}
+	return history, nil
+}
+
+func getNameHistory(ns engine.Namespace, key []byte) ([]PeerName, error) {
+	data, err := ns.GetEncrypted(key)
+	if isMissing(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var history []PeerName
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("unmarshalling name history: %w", err)
+	}
+	return history, nil
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// BufferedMessage is an outgoing application message kept in a session's
+// outbox so that it can be sent again if the peer never received it.
+type BufferedMessage struct {
+	// Data is the marshaled message.
+	Data []byte
+	// Number counts the application messages sent in the session, across
+	// resumptions, up to and including this one.
+	Number uint64
+	Route  int32
+}
+
+func sessionOutbox(b engine.Namespace, sessionID string) engine.Namespace {
+	return b.Sub([]byte(engine.SessionsNamespace)).
+		Sub([]byte(sessionID)).
+		Ensure([]byte("outbox"))
+}
+
+func outboxKey(number uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, number)
+}
+
+// BufferMessage adds m to the session's outbox, replacing any message with the
+// same number, and drops the messages that fall out of the last window
+// numbers. The session must have been created with [Storage.CreateSession].
+func (s *Storage) BufferMessage(
+	sessionID string, m BufferedMessage, window int,
+) error {
+	if window < 1 {
+		return errors.New("outbox window must be positive")
+	}
+	value := binary.BigEndian.AppendUint32(nil, uint32(m.Route))
+	value = append(value, m.Data...)
+
+	err := s.engine.Command(func(b engine.Namespace) error {
+		outbox := sessionOutbox(b, sessionID)
+		if err := outbox.PutEncrypted(outboxKey(m.Number), value); err != nil {
+			return err
+		}
+		if m.Number <= uint64(window) {
+			return nil
+		}
+		oldest := outboxKey(m.Number - uint64(window) + 1)
+		var expired [][]byte
+		for key := range outbox.IterateEncrypted() {
+			if bytes.Compare(key, oldest) >= 0 {
+				break
+			}
+			expired = append(expired, bytes.Clone(key))
+		}
+		for _, key := range expired {
+			if err := outbox.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if isMissing(err) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("buffering message %d: %w", m.Number, err)
+	}
+	return nil
+}
+
+// TakeBufferedMessages removes the messages numbered after the given number
+// from the session's outbox and returns them, oldest first.
+func (s *Storage) TakeBufferedMessages(
+	sessionID string, after uint64,
+) ([]BufferedMessage, error) {
+	var messages []BufferedMessage
+	err := s.engine.Command(func(b engine.Namespace) error {
+		outbox := b.Sub([]byte(engine.SessionsNamespace)).
+			Sub([]byte(sessionID)).
+			Sub([]byte("outbox"))
+		first := outboxKey(after + 1)
+		for key, value := range outbox.IterateEncrypted() {
+			if bytes.Compare(key, first) < 0 {
+				continue
+			}
+			if len(key) != 8 || len(value) < 4 {
+				return fmt.Errorf("outbox entry %x is malformed", key)
+			}
+			messages = append(messages, BufferedMessage{
+				Data:   bytes.Clone(value[4:]),
+				Number: binary.BigEndian.Uint64(key),
+				Route:  int32(binary.BigEndian.Uint32(value)),
+			})
+		}
+		for _, m := range messages {
+			if err := outbox.Delete(outboxKey(m.Number)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && !isMissing(err) {
+		return nil, fmt.Errorf("reading outbox of %s: %w", sessionID, err)
+	}
+	return messages, nil
+}
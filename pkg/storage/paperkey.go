@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/enigma"
+	"github.com/kamune-org/kamune/pkg/attest"
+	"github.com/kamune-org/kamune/pkg/paperkey"
+)
+
+// ErrPaperKeyMismatch is returned when a paper key backup cannot be opened
+// with the phrase it is restored with, because it was made for another
+// identity or has been damaged.
+var ErrPaperKeyMismatch = errors.New("backup does not match the paper key")
+
+var (
+	// paperBackupMagic starts every paper key backup, so that other files
+	// are told apart from damaged backups.
+	paperBackupMagic = []byte("KMNP\x01")
+	// paperKeyInfo binds the cipher of a paper key backup to its purpose.
+	// The cipher is derived from the identity's seed and public key.
+	paperKeyInfo = []byte("kamune-paper-key-backup")
+)
+
+// PaperKey is a way to recreate a storage's identity, and to restore the
+// peers it trusts, on a fresh install; see [Storage.ExportPaperKey].
+type PaperKey struct {
+	// Phrase is the identity's private key as words to be written down; see
+	// package [paperkey]. It is the same every time it is exported, and must
+	// be kept as secret as the key itself.
+	Phrase string
+	// Backup is the list of known peers, encrypted with a key derived from
+	// the phrase. It may be kept anywhere, such as with a cloud provider or
+	// printed as a QR code, as it cannot be read without the phrase.
+	Backup []byte
+}
+
+// paperBackup is the content of [PaperKey.Backup].
+type paperBackup struct {
+	Created time.Time   `json:"created"`
+	Peers   []paperPeer `json:"peers"`
+}
+
+type paperPeer struct {
+	FirstSeen time.Time `json:"firstSeen"`
+	Name      string    `json:"name"`
+	PublicKey []byte    `json:"publicKey"`
+}
+
+func paperCipher(at *attest.Attest) (*enigma.Enigma, error) {
+	return enigma.NewEnigma(at.Seed(), at.MarshalPublicKey(), paperKeyInfo)
+}
+
+// ExportPaperKey returns the paper key of the storage's identity, with a
+// backup of the peers it knows and has not blocked. It fails with
+// [ErrIdentityNotFound] if the storage has no identity. Restore the paper key
+// with [Storage.RestorePaperKey].
+func (s *Storage) ExportPaperKey() (PaperKey, error) {
+	at, err := s.LoadIdentity()
+	if err != nil {
+		return PaperKey{}, err
+	}
+	peers, err := s.ListPeers()
+	if err != nil {
+		return PaperKey{}, err
+	}
+
+	backup := paperBackup{Created: s.clock.Now()}
+	for _, p := range peers {
+		blocked, err := s.IsBlocked(p.PublicKey)
+		if err != nil {
+			return PaperKey{}, err
+		}
+		if blocked {
+			continue
+		}
+		backup.Peers = append(backup.Peers, paperPeer{
+			FirstSeen: p.FirstSeen, Name: p.Name, PublicKey: p.PublicKey,
+		})
+	}
+	data, err := json.Marshal(backup)
+	if err != nil {
+		return PaperKey{}, fmt.Errorf("marshalling paper key backup: %w", err)
+	}
+	c, err := paperCipher(at)
+	if err != nil {
+		return PaperKey{}, fmt.Errorf("paper key cipher: %w", err)
+	}
+	return PaperKey{
+		Phrase: paperkey.Encode(at.Seed()),
+		Backup: append(bytes.Clone(paperBackupMagic), c.Encrypt(data)...),
+	}, nil
+}
+
+// RestorePaperKey recreates the identity that phrase was exported for, and
+// stores the peers in backup, which may be nil to restore the identity
+// alone. It fails with [ErrIdentityExists], storing nothing, if the storage
+// already has an identity; with an error wrapping
+// [paperkey.ErrInvalidPhrase] or [paperkey.ErrChecksum] if phrase is
+// mistyped; and with [ErrPaperKeyMismatch] if backup was not exported with
+// phrase. Peers are restored as first seen when they originally were, and
+// last seen now.
+func (s *Storage) RestorePaperKey(
+	phrase string, backup []byte,
+) (*attest.Attest, error) {
+	seed, err := paperkey.Decode(phrase)
+	if err != nil {
+		return nil, err
+	}
+	at, err := attest.FromSeed(seed)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"%w: not an identity key", paperkey.ErrInvalidPhrase,
+		)
+	}
+
+	var content paperBackup
+	if backup != nil {
+		content, err = openPaperBackup(at, backup)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := at.MarshalPrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("marshalling private key: %w", err)
+	}
+	if err := s.putIdentity(data); err != nil {
+		return nil, err
+	}
+	for _, p := range content.Peers {
+		err := s.StorePeer(&Peer{
+			FirstSeen: p.FirstSeen, Name: p.Name, PublicKey: p.PublicKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("restoring peer %q: %w", p.Name, err)
+		}
+	}
+	return at, nil
+}
+
+func openPaperBackup(at *attest.Attest, backup []byte) (paperBackup, error) {
+	sealed, ok := bytes.CutPrefix(backup, paperBackupMagic)
+	if !ok {
+		return paperBackup{}, ErrPaperKeyMismatch
+	}
+	c, err := paperCipher(at)
+	if err != nil {
+		return paperBackup{}, fmt.Errorf("paper key cipher: %w", err)
+	}
+	data, err := c.Decrypt(sealed)
+	if err != nil {
+		return paperBackup{}, ErrPaperKeyMismatch
+	}
+	var content paperBackup
+	if err := json.Unmarshal(data, &content); err != nil {
+		return paperBackup{}, fmt.Errorf(
+			"unmarshalling paper key backup: %w", err,
+		)
+	}
+	return content, nil
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// PeerParameters are the protocol parameters a peer introduced itself with.
+type PeerParameters struct {
+	AppVersion   string   `json:"app_version"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// Downgrade describes how a peer's introduction offers less than the one it
+// last established a session with, which may be the work of a man in the
+// middle stripping what it cannot attack.
+type Downgrade struct {
+	// Previous is what the peer offered last time.
+	Previous PeerParameters
+	// Capabilities lists the capabilities the peer no longer advertises.
+	Capabilities []string
+	// OlderVersion is set if the peer now reports an older version.
+	OlderVersion bool
+}
+
+// SetPeerParameters records the parameters the peer with the given public key
+// last established a session with, replacing those recorded before.
+func (s *Storage) SetPeerParameters(publicKey []byte, p PeerParameters) error {
+	if len(publicKey) == 0 {
+		return ErrInvalidPublicKey
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("marshalling peer parameters: %w", err)
+	}
+	err = s.engine.Command(func(b engine.Namespace) error {
+		return b.Ensure([]byte(engine.ParametersNamespace)).
+			PutEncrypted(peerKey(publicKey), data)
+	})
+	if err != nil {
+		return fmt.Errorf("setting peer parameters: %w", err)
+	}
+	return nil
+}
+
+// PeerParameters returns the parameters recorded for the peer with the given
+// public key by [Storage.SetPeerParameters], or nil if there are none.
+func (s *Storage) PeerParameters(publicKey []byte) (*PeerParameters, error) {
+	var data []byte
+	err := s.engine.Query(func(b engine.Namespace) error {
+		var err error
+		data, err = b.Sub([]byte(engine.ParametersNamespace)).
+			GetEncrypted(peerKey(publicKey))
+		if isMissing(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting peer parameters: %w", err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	var p PeerParameters
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("unmarshalling peer parameters: %w", err)
+	}
+	return &p, nil
+}
@@ -20,6 +20,31 @@ type Peer struct {
 	Name       string
 	AppVersion string
 	PublicKey  []byte
+	// Metadata holds the signed application metadata the peer attached to
+	// its introduction, such as capabilities or custom claims. It describes
+	// a single connection and is not persisted.
+	Metadata map[string][]byte
+	// Services lists the services the peer offers, and Service names the one
+	// it requested from us, if any. Like Metadata, they come from the
+	// introduction and are not persisted.
+	Services []string
+	Service  string
+	// Capabilities lists the optional protocol features the peer supports,
+	// as advertised in its introduction.
+	Capabilities []string
+	// Subject is the identity, such as an email address, that the claims in
+	// the peer's introduction vouch for, if a ClaimVerifier accepted them.
+	// Like Metadata, it is not persisted.
+	Subject string
+	// Downgrade is set when the peer's introduction offers less than the one
+	// it last established a session with, so that verifiers can warn the
+	// user or refuse the connection. Like Metadata, it is not persisted.
+	Downgrade *Downgrade
+	// Renamed is set to the name the peer last established a session under
+	// when its introduction carries another, so that an impersonation by
+	// rename is visible to verifiers and the user. Like Metadata, it is not
+	// persisted; see [Storage.PeerNameHistory].
+	Renamed *PeerName
 }
 
 var (
@@ -37,14 +62,21 @@ func peerKey(claim []byte) []byte {
 
 func (s *Storage) FindPeer(claim []byte) (*Peer, error) {
 	var peer *Peer
+	key := peerKey(claim)
 	err := s.engine.Query(func(b engine.Namespace) error {
 		var err error
-		peer, err = s.findPeer(b, peerKey(claim))
+		peer, err = s.findPeer(b, key)
 		return err
 	})
+	if errors.Is(err, ErrPeerExpired) {
+		s.removeExpiredPeer(claim)
+	}
 	return peer, err
 }
 
+// findPeer loads the peer stored under key. An expired peer yields
+// [ErrPeerExpired]; since b may belong to a read transaction, removing it is
+// left to the caller, through removeExpiredPeer once the transaction is done.
 func (s *Storage) findPeer(b engine.Namespace, key []byte) (*Peer, error) {
 	peers := b.Sub([]byte(engine.PeersNamespace))
 	data, err := peers.GetEncrypted(key)
@@ -58,17 +90,6 @@ func (s *Storage) findPeer(b engine.Namespace, key []byte) (*Peer, error) {
 	}
 
 	if p.FirstSeen.AsTime().Add(s.expiryDuration).Before(s.clock.Now()) {
-		err = s.engine.Command(func(b engine.Namespace) error {
-			peers := b.Sub([]byte(engine.PeersNamespace))
-			return peers.Delete(key)
-		})
-		if err != nil {
-			slog.Warn(
-				"failed to remove expired peer",
-				slog.String("peer_name", p.GetName()),
-				slog.Any("error", err),
-			)
-		}
 		return nil, ErrPeerExpired
 	}
 
@@ -76,6 +97,7 @@ func (s *Storage) findPeer(b engine.Namespace, key []byte) (*Peer, error) {
 	if p.LastSeen != nil {
 		lastSeen = p.LastSeen.AsTime()
 	}
+	lastSeen = s.lastSeen(p.PublicKey, lastSeen)
 
 	return &Peer{
 		Name:       p.Name,
@@ -116,13 +138,18 @@ func (s *Storage) StorePeer(peer *Peer) error {
 		return fmt.Errorf("marshaling peer: %w", err)
 	}
 	key := peerKey(pubKey)
+	op := EventAdded
 	err = s.engine.Command(func(b engine.Namespace) error {
 		peers := b.Sub([]byte(engine.PeersNamespace))
+		if _, err := peers.GetEncrypted(key); err == nil {
+			op = EventUpdated
+		}
 		return peers.PutEncrypted(key, data)
 	})
 	if err != nil {
 		return fmt.Errorf("adding peer to storage: %w", err)
 	}
+	s.notify(peerEvent(op, pubKey))
 
 	return nil
 }
@@ -169,19 +196,34 @@ func (s *Storage) UpdatePeerLastSeen(claim []byte, t time.Time) error {
 	if err != nil {
 		return fmt.Errorf("persisting LastSeen update: %w", err)
 	}
+	s.notify(peerEvent(EventUpdated, claim))
 
 	return nil
 }
 
+// removeExpiredPeer deletes the peer with the given public key. It must not be
+// called from within a transaction.
+func (s *Storage) removeExpiredPeer(publicKey []byte) {
+	err := s.engine.Command(func(b engine.Namespace) error {
+		peers := b.Sub([]byte(engine.PeersNamespace))
+		return peers.Delete(peerKey(publicKey))
+	})
+	if err != nil {
+		slog.Warn("failed to remove expired peer", slog.Any("error", err))
+		return
+	}
+	s.notify(peerEvent(EventDeleted, publicKey))
+}
+
 // ListPeers returns all non-expired peers stored in the database.
 // Expired peers are silently removed during iteration.
 func (s *Storage) ListPeers() ([]*Peer, error) {
 	var peers []*Peer
-	var expiredKeys [][]byte
+	var expired [][]byte
 
 	err := s.engine.Query(func(b engine.Namespace) error {
 		ns := b.Sub([]byte(engine.PeersNamespace))
-		for key, value := range ns.IterateEncrypted() {
+		for _, value := range ns.IterateEncrypted() {
 			var p pb.Peer
 			if err := proto.Unmarshal(value, &p); err != nil {
 				slog.Warn(
@@ -191,9 +233,7 @@ func (s *Storage) ListPeers() ([]*Peer, error) {
 			}
 
 			if p.FirstSeen.AsTime().Add(s.expiryDuration).Before(s.clock.Now()) {
-				keyCopy := make([]byte, len(key))
-				copy(keyCopy, key)
-				expiredKeys = append(expiredKeys, keyCopy)
+				expired = append(expired, p.PublicKey)
 				continue
 			}
 
@@ -201,6 +241,7 @@ func (s *Storage) ListPeers() ([]*Peer, error) {
 			if p.LastSeen != nil {
 				lastSeen = p.LastSeen.AsTime()
 			}
+			lastSeen = s.lastSeen(p.PublicKey, lastSeen)
 
 			peers = append(peers, &Peer{
 				Name:       p.Name,
@@ -217,13 +258,8 @@ func (s *Storage) ListPeers() ([]*Peer, error) {
 	}
 
 	// Clean up expired entries outside the read transaction.
-	for _, key := range expiredKeys {
-		if err := s.engine.Command(func(b engine.Namespace) error {
-			peers := b.Sub([]byte(engine.PeersNamespace))
-			return peers.Delete(key)
-		}); err != nil {
-			slog.Warn("failed to remove expired peer", slog.Any("error", err))
-		}
+	for _, publicKey := range expired {
+		s.removeExpiredPeer(publicKey)
 	}
 
 	return peers, nil
@@ -232,8 +268,13 @@ func (s *Storage) ListPeers() ([]*Peer, error) {
 // DeletePeer removes a peer from storage by its public key claim.
 func (s *Storage) DeletePeer(claim []byte) error {
 	key := peerKey(claim)
-	return s.engine.Command(func(b engine.Namespace) error {
+	err := s.engine.Command(func(b engine.Namespace) error {
 		peers := b.Sub([]byte(engine.PeersNamespace))
 		return peers.Delete(key)
 	})
+	if err != nil {
+		return err
+	}
+	s.notify(peerEvent(EventDeleted, claim))
+	return nil
 }
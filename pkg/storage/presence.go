@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// defaultPresenceResolution is how stale a peer's persisted LastSeen may get
+// while it keeps being seen; see [WithPresenceResolution].
+const defaultPresenceResolution = time.Minute
+
+// presence caches when each peer was last seen, so that marking a peer seen on
+// every frame costs a write only once per resolution.
+type presence struct {
+	peers map[string]*sighting
+	mu    sync.Mutex
+}
+
+// sighting is the last time a peer was seen, and the last time persisted.
+type sighting struct {
+	seen      time.Time
+	persisted time.Time
+}
+
+// WithPresenceResolution sets how often [Storage.MarkPeerSeen] persists the
+// LastSeen of a peer that keeps being seen. The default is a minute; zero
+// persists every sighting.
+func WithPresenceResolution(d time.Duration) StorageOption {
+	return func(p *Storage) { p.seenResolution = d }
+}
+
+// MarkPeerSeen records that the peer with the given public key was seen at t,
+// or now if t is zero. Sightings are kept in memory and written with
+// [Storage.UpdatePeerLastSeen] once the persisted LastSeen is a resolution
+// behind, see [WithPresenceResolution], so it is cheap enough to call on
+// every received frame. A sighting after a resolution of silence is written
+// at once, so [PeersBucket] subscribers learn that a peer is back as it
+// happens, and then at most once per resolution while it stays. Unwritten
+// sightings are written by [Storage.Close]. Unknown peers are ignored.
+func (s *Storage) MarkPeerSeen(publicKey []byte, t time.Time) error {
+	if t.IsZero() {
+		t = s.clock.Now()
+	}
+	key := string(publicKey)
+
+	s.presence.mu.Lock()
+	if s.presence.peers == nil {
+		s.presence.peers = make(map[string]*sighting)
+	}
+	e, ok := s.presence.peers[key]
+	if !ok {
+		e = &sighting{}
+		s.presence.peers[key] = e
+	}
+	if !t.After(e.seen) {
+		s.presence.mu.Unlock()
+		return nil
+	}
+	e.seen = t
+	if t.Sub(e.persisted) < s.seenResolution {
+		s.presence.mu.Unlock()
+		return nil
+	}
+	persisted := e.persisted
+	e.persisted = t
+	s.presence.mu.Unlock()
+
+	if err := s.UpdatePeerLastSeen(publicKey, t); err != nil {
+		// Leave the sighting to be written by the next one, or by Close.
+		s.presence.mu.Lock()
+		if e.persisted.Equal(t) {
+			e.persisted = persisted
+		}
+		s.presence.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// PeerLastSeen returns when the peer with the given public key was last seen,
+// including sightings [Storage.MarkPeerSeen] has not written yet. It returns
+// the same errors as [Storage.FindPeer].
+func (s *Storage) PeerLastSeen(publicKey []byte) (time.Time, error) {
+	peer, err := s.FindPeer(publicKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return peer.LastSeen, nil
+}
+
+// lastSeen returns the later of persisted and the cached sighting of the peer
+// with the given public key.
+func (s *Storage) lastSeen(publicKey []byte, persisted time.Time) time.Time {
+	s.presence.mu.Lock()
+	defer s.presence.mu.Unlock()
+	if e, ok := s.presence.peers[string(publicKey)]; ok {
+		if e.seen.After(persisted) {
+			return e.seen
+		}
+	}
+	return persisted
+}
+
+// flushPresence writes the sightings that [Storage.MarkPeerSeen] has not
+// written yet.
+func (s *Storage) flushPresence() {
+	s.presence.mu.Lock()
+	pending := make(map[string]time.Time)
+	for key, e := range s.presence.peers {
+		if e.seen.After(e.persisted) {
+			pending[key] = e.seen
+			e.persisted = e.seen
+		}
+	}
+	s.presence.mu.Unlock()
+
+	for key, seen := range pending {
+		if err := s.UpdatePeerLastSeen([]byte(key), seen); err != nil {
+			slog.Warn("persisting peer last seen", slog.Any("error", err))
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// DefaultProfile is the name applications give the profile they create
+	// when the user has none.
+	DefaultProfile = "default"
+
+	profilesDir      = "profiles"
+	profileDBFile    = "kamune.db"
+	profileSettings  = "settings.json"
+	maxProfileLength = 64
+)
+
+var (
+	ErrProfileNotFound    = errors.New("profile not found")
+	ErrProfileExists      = errors.New("profile already exists")
+	ErrInvalidProfileName = errors.New("invalid profile name")
+)
+
+// Profiles manages named profiles, each with its own database, and so its own
+// identity, peers, and history, along with its own settings. It lets
+// applications offer account switching instead of asking users for database
+// paths. Profiles live in a directory each, under the data directory:
+//
+//	<data directory>/profiles/<name>/kamune.db
+//	<data directory>/profiles/<name>/settings.json
+type Profiles struct {
+	dir string
+}
+
+// Profile is a profile managed by [Profiles].
+type Profile struct {
+	// Name identifies the profile. It consists of letters, digits, '.', '-',
+	// and '_', and does not start with a '.'.
+	Name string
+	// Dir is the directory holding the profile's files.
+	Dir string
+}
+
+// DefaultDataDir returns the standard per-OS directory for kamune's data:
+// kamune under [os.UserConfigDir], such as ~/.config/kamune on Linux,
+// ~/Library/Application Support/kamune on macOS, or %AppData%\kamune on
+// Windows. The KAMUNE_DATA_DIR environment variable overrides it.
+func DefaultDataDir() (string, error) {
+	if dir := os.Getenv("KAMUNE_DATA_DIR"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("getting user's config directory: %w", err)
+	}
+	return filepath.Join(dir, "kamune"), nil
+}
+
+// NewProfiles returns the profiles kept under the data directory dir, or
+// under [DefaultDataDir] if dir is empty. Nothing is created until a profile
+// is.
+func NewProfiles(dir string) (*Profiles, error) {
+	if dir == "" {
+		var err error
+		if dir, err = DefaultDataDir(); err != nil {
+			return nil, err
+		}
+	}
+	return &Profiles{dir: dir}, nil
+}
+
+// Dir returns the data directory the profiles are kept under.
+func (p *Profiles) Dir() string { return p.dir }
+
+// List returns the profiles, sorted by name.
+func (p *Profiles) List() ([]Profile, error) {
+	entries, err := os.ReadDir(filepath.Join(p.dir, profilesDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing profiles: %w", err)
+	}
+	var profiles []Profile
+	for _, e := range entries {
+		if !e.IsDir() || checkProfileName(e.Name()) != nil {
+			continue
+		}
+		profiles = append(profiles, p.profile(e.Name()))
+	}
+	slices.SortFunc(profiles, func(a, b Profile) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return profiles, nil
+}
+
+// Create creates a profile named name, failing with [ErrProfileExists] if
+// there is one already. Its database, and with it its identity, is created
+// the first time it is opened.
+func (p *Profiles) Create(name string) (Profile, error) {
+	if err := checkProfileName(name); err != nil {
+		return Profile{}, err
+	}
+	parent := filepath.Join(p.dir, profilesDir)
+	if err := os.MkdirAll(parent, 0700); err != nil {
+		return Profile{}, fmt.Errorf("creating profiles directory: %w", err)
+	}
+	profile := p.profile(name)
+	err := os.Mkdir(profile.Dir, 0700)
+	if errors.Is(err, fs.ErrExist) {
+		return Profile{}, fmt.Errorf("%w: %s", ErrProfileExists, name)
+	}
+	if err != nil {
+		return Profile{}, fmt.Errorf("creating profile %s: %w", name, err)
+	}
+	return profile, nil
+}
+
+// Get returns the profile named name, or [ErrProfileNotFound].
+func (p *Profiles) Get(name string) (Profile, error) {
+	if err := checkProfileName(name); err != nil {
+		return Profile{}, err
+	}
+	profile := p.profile(name)
+	info, err := os.Stat(profile.Dir)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !info.IsDir()) {
+		return Profile{}, fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+	}
+	if err != nil {
+		return Profile{}, fmt.Errorf("reading profile %s: %w", name, err)
+	}
+	return profile, nil
+}
+
+// Open opens the storage of the profile named name with opts, which must not
+// set its path. See [Profile.Open].
+func (p *Profiles) Open(name string, opts ...StorageOption) (*Storage, error) {
+	profile, err := p.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return profile.Open(opts...)
+}
+
+// Remove deletes the profile named name along with its database and
+// settings. The profile's storage must not be open.
+func (p *Profiles) Remove(name string) error {
+	profile, err := p.Get(name)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(profile.Dir); err != nil {
+		return fmt.Errorf("removing profile %s: %w", name, err)
+	}
+	return nil
+}
+
+func (p *Profiles) profile(name string) Profile {
+	return Profile{
+		Name: name,
+		Dir:  filepath.Join(p.dir, profilesDir, name),
+	}
+}
+
+// DBPath returns the path of the profile's database.
+func (p Profile) DBPath() string {
+	return filepath.Join(p.Dir, profileDBFile)
+}
+
+// Open opens the profile's storage with opts, creating its database if
+// needed. A path set by opts is overridden.
+func (p Profile) Open(opts ...StorageOption) (*Storage, error) {
+	return OpenStorage(append(slices.Clone(opts), WithDBPath(p.DBPath()))...)
+}
+
+// Settings returns the profile's settings, such as application preferences.
+// A profile without settings has an empty map.
+func (p Profile) Settings() (map[string]string, error) {
+	settings := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(p.Dir, profileSettings))
+	if errors.Is(err, fs.ErrNotExist) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading settings of %s: %w", p.Name, err)
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("parsing settings of %s: %w", p.Name, err)
+	}
+	return settings, nil
+}
+
+// SaveSettings replaces the profile's settings. Settings are stored
+// unencrypted next to the database, so they must not hold secrets.
+func (p Profile) SaveSettings(settings map[string]string) error {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling settings: %w", err)
+	}
+	path := filepath.Join(p.Dir, profileSettings)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("writing settings of %s: %w", p.Name, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing settings of %s: %w", p.Name, err)
+	}
+	return nil
+}
+
+// checkProfileName rejects names that are empty, too long, or could escape
+// the profiles directory.
+func checkProfileName(name string) error {
+	valid := name != "" && len(name) <= maxProfileLength && name[0] != '.' &&
+		!strings.ContainsFunc(name, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+				r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_')
+		})
+	if !valid {
+		return fmt.Errorf("%w: %q", ErrInvalidProfileName, name)
+	}
+	return nil
+}
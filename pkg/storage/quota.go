@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// chatBytesKey caches the total size of a session's chat entries in its meta
+// namespace, so that byte quotas can be enforced without reading the whole
+// history on every write.
+const chatBytesKey = "chat_bytes"
+
+// ChatQuota caps the size of a chat history. Zero fields are unlimited.
+type ChatQuota struct {
+	// Messages is the maximum number of entries.
+	Messages int
+	// Bytes is the maximum total size of the entries: their payloads plus 13
+	// bytes of header each.
+	Bytes int64
+}
+
+func (q ChatQuota) enabled() bool { return q.Messages > 0 || q.Bytes > 0 }
+
+func (q ChatQuota) exceeded(messages int, size int64) bool {
+	return q.Messages > 0 && messages > q.Messages ||
+		q.Bytes > 0 && size > q.Bytes
+}
+
+// Eviction reports the chat entries removed from a session to enforce a
+// [ChatQuota]. Entries are evicted oldest first, so every entry of the session
+// stored up to Until is gone.
+type Eviction struct {
+	// Until is the local storage time of the newest evicted entry.
+	Until     time.Time
+	SessionID string
+	Messages  int
+	Bytes     int64
+	// Peer is set when the entries were evicted to enforce the peer quota
+	// rather than the session quota.
+	Peer bool
+}
+
+// chatUsage returns the number of entries of a session and their total size.
+// The size is computed from the entries the first time and cached afterwards.
+func chatUsage(b engine.Namespace, sessionID string) (int, int64, error) {
+	chat := sessionChat(b, sessionID)
+	meta := sessionMeta(b, sessionID)
+	value, err := meta.GetEncrypted([]byte(chatBytesKey))
+	if err == nil && len(value) == 8 {
+		return chat.KeyCount(), int64(binary.BigEndian.Uint64(value)), nil
+	}
+
+	var size int64
+	for _, value := range chat.IterateEncrypted() {
+		size += int64(len(value))
+	}
+	return chat.KeyCount(), size, putChatBytes(meta, size)
+}
+
+// adjustChatBytes adds delta to the cached size of a session's chat entries.
+// It is a no-op until [chatUsage] has computed the size.
+func adjustChatBytes(b engine.Namespace, sessionID string, delta int64) error {
+	meta := sessionMeta(b, sessionID)
+	value, err := meta.GetEncrypted([]byte(chatBytesKey))
+	if err != nil || len(value) != 8 {
+		return nil
+	}
+	return putChatBytes(meta, int64(binary.BigEndian.Uint64(value))+delta)
+}
+
+func putChatBytes(meta engine.Namespace, size int64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(max(size, 0)))
+	return meta.PutEncrypted([]byte(chatBytesKey), buf[:])
+}
+
+// evictions accumulates the entries evicted from each session during a single
+// write.
+type evictions struct {
+	bySession map[string]*Eviction
+	order     []string
+}
+
+func (ev *evictions) record(
+	sessionID string, key []byte, size int64, peer bool,
+) {
+	if ev.bySession == nil {
+		ev.bySession = make(map[string]*Eviction)
+	}
+	e, ok := ev.bySession[sessionID]
+	if !ok {
+		e = &Eviction{SessionID: sessionID}
+		ev.bySession[sessionID] = e
+		ev.order = append(ev.order, sessionID)
+	}
+	e.Messages++
+	e.Bytes += size
+	e.Until = time.Unix(0, int64(binary.BigEndian.Uint64(key[:8])))
+	e.Peer = e.Peer || peer
+}
+
+func (ev *evictions) list() []Eviction {
+	list := make([]Eviction, 0, len(ev.order))
+	for _, sid := range ev.order {
+		list = append(list, *ev.bySession[sid])
+	}
+	return list
+}
+
+// oldestKey returns the key of the oldest chat entry other than keep, or nil
+// if there is none.
+func oldestKey(chat engine.Namespace, keep []byte) []byte {
+	for key := range chat.IterateEncrypted() {
+		if !bytes.Equal(key, keep) {
+			return bytes.Clone(key)
+		}
+	}
+	return nil
+}
+
+// evictEntry deletes a chat entry of a session, removing it from the search
+// index if idx is not nil, and returns its size.
+func evictEntry(
+	b engine.Namespace, sessionID string, key []byte, idx *searchIndex,
+) (int64, error) {
+	chat := sessionChat(b, sessionID)
+	value, err := chat.GetEncrypted(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := chat.Delete(key); err != nil {
+		return 0, err
+	}
+	size := int64(len(value))
+	if err := adjustChatBytes(b, sessionID, -size); err != nil {
+		return 0, err
+	}
+	entry, ok := openChatEntry(chatCipher(b, sessionID), key, value)
+	if ok && idx != nil {
+		if err := idx.remove(sessionID, key, entry.Data); err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// enforceChatQuotas evicts the oldest entries of the session, and then of all
+// sessions with the same peer, until both quotas are met. The entry stored
+// under added is never evicted, even if it exceeds a quota on its own.
+func (s *Storage) enforceChatQuotas(
+	b engine.Namespace, sessionID string, added []byte, idx *searchIndex,
+) ([]Eviction, error) {
+	var ev evictions
+	if s.sessionQuota.enabled() {
+		n, size, err := chatUsage(b, sessionID)
+		if err != nil {
+			return nil, err
+		}
+		for n > 1 && s.sessionQuota.exceeded(n, size) {
+			key := oldestKey(sessionChat(b, sessionID), added)
+			evicted, err := evictEntry(b, sessionID, key, idx)
+			if err != nil {
+				return nil, err
+			}
+			ev.record(sessionID, key, evicted, false)
+			n--
+			size -= evicted
+		}
+	}
+
+	if s.peerQuota.enabled() {
+		err := s.enforcePeerQuota(b, sessionID, added, idx, &ev)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ev.list(), nil
+}
+
+func (s *Storage) enforcePeerQuota(
+	b engine.Namespace,
+	sessionID string,
+	added []byte,
+	idx *searchIndex,
+	ev *evictions,
+) error {
+	id, err := sessionMeta(b, sessionID).GetEncrypted([]byte(ConversationKey))
+	if err != nil {
+		return nil
+	}
+	c, err := getConversation(b, string(id))
+	if err != nil {
+		return nil
+	}
+
+	var n int
+	var size int64
+	for _, sid := range c.GetSessions() {
+		sn, ssize, err := chatUsage(b, sid)
+		if err != nil {
+			return err
+		}
+		n += sn
+		size += ssize
+	}
+
+	for n > 1 && s.peerQuota.exceeded(n, size) {
+		// Keys start with the storage time, so the smallest first key
+		// belongs to the peer's oldest entry.
+		var oldest string
+		var first []byte
+		for _, sid := range c.GetSessions() {
+			key := oldestKey(sessionChat(b, sid), added)
+			if key != nil && (first == nil || bytes.Compare(key, first) < 0) {
+				oldest, first = sid, key
+			}
+		}
+		if first == nil {
+			break
+		}
+		evicted, err := evictEntry(b, oldest, first, idx)
+		if err != nil {
+			return err
+		}
+		ev.record(oldest, first, evicted, true)
+		n--
+		size -= evicted
+	}
+	return nil
+}
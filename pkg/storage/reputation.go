@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// Offense is a kind of misbehaviour counted against a peer's [Reputation].
+type Offense int
+
+const (
+	// OffenseHandshake is a handshake the peer failed after introducing
+	// itself.
+	OffenseHandshake Offense = iota
+	// OffenseAbuse is a session closed because of the messages the peer
+	// sent on it.
+	OffenseAbuse
+)
+
+func (o Offense) String() string {
+	switch o {
+	case OffenseHandshake:
+		return "handshake"
+	case OffenseAbuse:
+		return "abuse"
+	default:
+		return fmt.Sprintf("Offense(%d)", int(o))
+	}
+}
+
+// Reputation is the record of the offenses of a peer, known or not, by its
+// public key.
+type Reputation struct {
+	// First and Last are when the first and the latest offense on record
+	// were recorded.
+	First     time.Time `json:"first"`
+	Last      time.Time `json:"last"`
+	PublicKey []byte    `json:"publicKey"`
+	// HandshakeFailures and Abuses count the offenses of each kind.
+	HandshakeFailures int `json:"handshakeFailures"`
+	Abuses            int `json:"abuses"`
+}
+
+// Offenses returns the number of offenses on record.
+func (r Reputation) Offenses() int { return r.HandshakeFailures + r.Abuses }
+
+// RecordOffense counts an offense of kind o against the peer owning
+// publicKey, and returns its updated reputation. A record whose latest
+// offense is older than forgetBefore is forgotten first, so that a peer that
+// has behaved for long enough starts over; a zero forgetBefore keeps every
+// offense.
+func (s *Storage) RecordOffense(
+	publicKey []byte, o Offense, forgetBefore time.Time,
+) (Reputation, error) {
+	if len(publicKey) == 0 {
+		return Reputation{}, ErrInvalidPublicKey
+	}
+	key := peerKey(publicKey)
+	now := s.clock.Now()
+	var r Reputation
+	err := s.engine.Command(func(b engine.Namespace) error {
+		ns := b.Ensure([]byte(engine.ReputationNamespace))
+		var err error
+		r, err = getReputation(ns, key)
+		if err != nil {
+			return err
+		}
+		if r.Last.Before(forgetBefore) {
+			r = Reputation{}
+		}
+		if r.First.IsZero() {
+			r.First = now
+		}
+		r.Last = now
+		r.PublicKey = bytes.Clone(publicKey)
+		switch o {
+		case OffenseHandshake:
+			r.HandshakeFailures++
+		case OffenseAbuse:
+			r.Abuses++
+		default:
+			return fmt.Errorf("unknown offense: %s", o)
+		}
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return ns.PutEncrypted(key, data)
+	})
+	if err != nil {
+		return Reputation{}, fmt.Errorf("recording offense: %w", err)
+	}
+	return r, nil
+}
+
+// getReputation returns the record stored under key in ns, or a zero one.
+func getReputation(ns engine.Namespace, key []byte) (Reputation, error) {
+	var r Reputation
+	data, err := ns.GetEncrypted(key)
+	if isMissing(err) {
+		return r, nil
+	}
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return r, fmt.Errorf("unmarshalling reputation: %w", err)
+	}
+	return r, nil
+}
+
+// GetReputation returns the reputation of the peer owning publicKey, with no
+// offenses if it has no record.
+func (s *Storage) GetReputation(publicKey []byte) (Reputation, error) {
+	key := peerKey(publicKey)
+	var r Reputation
+	err := s.engine.Query(func(b engine.Namespace) error {
+		var err error
+		r, err = getReputation(b.Sub([]byte(engine.ReputationNamespace)), key)
+		return err
+	})
+	if err != nil && !isMissing(err) {
+		return Reputation{}, fmt.Errorf("getting reputation: %w", err)
+	}
+	if r.PublicKey == nil {
+		r.PublicKey = bytes.Clone(publicKey)
+	}
+	return r, nil
+}
+
+// ListReputations returns the records of every peer with offenses, the most
+// recent offender first.
+func (s *Storage) ListReputations() ([]Reputation, error) {
+	var list []Reputation
+	err := s.engine.Query(func(b engine.Namespace) error {
+		ns := b.Sub([]byte(engine.ReputationNamespace))
+		for _, v := range ns.IterateEncrypted() {
+			var r Reputation
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("unmarshalling reputation: %w", err)
+			}
+			list = append(list, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing reputations: %w", err)
+	}
+	slices.SortFunc(list, func(a, b Reputation) int {
+		return cmp.Compare(b.Last.UnixNano(), a.Last.UnixNano())
+	})
+	return list, nil
+}
+
+// ClearReputation forgets the offenses of the peer owning publicKey.
+// Clearing a peer without offenses is not an error.
+func (s *Storage) ClearReputation(publicKey []byte) error {
+	key := peerKey(publicKey)
+	err := s.engine.Command(func(b engine.Namespace) error {
+		return b.Sub([]byte(engine.ReputationNamespace)).Delete(key)
+	})
+	if err != nil && !isMissing(err) {
+		return fmt.Errorf("clearing reputation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// SetPeerRole assigns a role, such as "admin" or "read-only", to the peer
+// with the given public key. Roles mean nothing to the storage; they are
+// interpreted by access policies such as kamune.AccessPolicy. An empty role
+// removes the assignment.
+func (s *Storage) SetPeerRole(publicKey []byte, role string) error {
+	if len(publicKey) == 0 {
+		return ErrInvalidPublicKey
+	}
+	key := peerKey(publicKey)
+	err := s.engine.Command(func(b engine.Namespace) error {
+		roles := b.Ensure([]byte(engine.RolesNamespace))
+		if role == "" {
+			return roles.Delete(key)
+		}
+		return roles.PutEncrypted(key, []byte(role))
+	})
+	if err != nil && !isMissing(err) {
+		return fmt.Errorf("setting peer role: %w", err)
+	}
+	return nil
+}
+
+// PeerRole returns the role assigned to the peer with the given public key
+// by [Storage.SetPeerRole], or an empty string if it has none.
+func (s *Storage) PeerRole(publicKey []byte) (string, error) {
+	key := peerKey(publicKey)
+	var role []byte
+	err := s.engine.Query(func(b engine.Namespace) error {
+		var err error
+		role, err = b.Sub([]byte(engine.RolesNamespace)).GetEncrypted(key)
+		if isMissing(err) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("getting peer role: %w", err)
+	}
+	return string(role), nil
+}
@@ -0,0 +1,361 @@
+package storage
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// The search index lives in search/index/. Its "key" item is a random secret
+// used to derive the term keys, and terms/ maps each term key to the postings
+// of the chat entries containing that term. Namespace keys are not encrypted
+// at rest, so terms are stored as keyed hashes rather than in plaintext. The
+// index namespace exists only while the index covers every chat entry.
+var (
+	searchIndexName = []byte("index")
+	searchTermsName = []byte("terms")
+	searchKeyName   = []byte("key")
+)
+
+// termKeySize is the length of the truncated HMAC identifying a term.
+const termKeySize = 16
+
+// SearchResult is a chat entry matched by [Storage.SearchChatHistory].
+type SearchResult struct {
+	ChatEntry
+	SessionID string
+}
+
+// posting identifies a chat entry by session ID and chat key.
+type posting struct {
+	sessionID string
+	key       []byte
+}
+
+func (p posting) id() string { return p.sessionID + "\x00" + string(p.key) }
+
+func encodePostings(ps []posting) []byte {
+	var buf []byte
+	for _, p := range ps {
+		buf = binary.AppendUvarint(buf, uint64(len(p.sessionID)))
+		buf = append(buf, p.sessionID...)
+		buf = binary.AppendUvarint(buf, uint64(len(p.key)))
+		buf = append(buf, p.key...)
+	}
+	return buf
+}
+
+func decodePostings(buf []byte) ([]posting, error) {
+	var ps []posting
+	next := func() ([]byte, error) {
+		n, l := binary.Uvarint(buf)
+		if l <= 0 || uint64(len(buf)-l) < n {
+			return nil, errors.New("malformed posting list")
+		}
+		field := buf[l : l+int(n)]
+		buf = buf[l+int(n):]
+		return field, nil
+	}
+	for len(buf) > 0 {
+		sid, err := next()
+		if err != nil {
+			return nil, err
+		}
+		key, err := next()
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, posting{sessionID: string(sid), key: key})
+	}
+	return ps, nil
+}
+
+// searchIndex is an open handle on the search index within a transaction.
+type searchIndex struct {
+	terms engine.Namespace
+	key   []byte
+}
+
+// loadSearchIndex opens the search index. It reports false if the index has
+// not been built.
+func loadSearchIndex(b engine.Namespace) (*searchIndex, bool) {
+	idx := b.Sub([]byte(engine.SearchNamespace)).Sub(searchIndexName)
+	key, err := idx.GetEncrypted(searchKeyName)
+	if err != nil {
+		return nil, false
+	}
+	return &searchIndex{terms: idx.Sub(searchTermsName), key: key}, true
+}
+
+// ensureSearchIndex opens the search index, building it from every stored
+// chat entry if it does not exist. It must be called inside a Command.
+func ensureSearchIndex(b engine.Namespace) (*searchIndex, error) {
+	if idx, ok := loadSearchIndex(b); ok {
+		return idx, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating search index key: %w", err)
+	}
+	ns := b.Ensure([]byte(engine.SearchNamespace)).Ensure(searchIndexName)
+	if err := ns.PutEncrypted(searchKeyName, key); err != nil {
+		return nil, fmt.Errorf("creating search index: %w", err)
+	}
+	idx := &searchIndex{terms: ns.Ensure(searchTermsName), key: key}
+
+	sessions := b.Sub([]byte(engine.SessionsNamespace))
+	for _, sid := range sessions.ListSubNamespaces() {
+		c := chatCipher(b, sid)
+		for key, value := range sessionChat(b, sid).IterateEncrypted() {
+			entry, ok := openChatEntry(c, key, value)
+			if !ok {
+				continue
+			}
+			if err := idx.add(sid, key, entry.Data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return idx, nil
+}
+
+// dropSearchIndex deletes the search index, if any.
+func dropSearchIndex(b engine.Namespace) error {
+	search := b.Sub([]byte(engine.SearchNamespace))
+	if err := search.DeleteNamespace(searchIndexName); err != nil &&
+		!errors.Is(err, engine.ErrMissingNamespace) {
+		return fmt.Errorf("dropping search index: %w", err)
+	}
+	return nil
+}
+
+// unindexSession removes every posting of a session from the search index.
+func unindexSession(b engine.Namespace, sessionID string) error {
+	idx, ok := loadSearchIndex(b)
+	if !ok {
+		return nil
+	}
+
+	// Collect changes first; the namespace must not be modified while it is
+	// being iterated.
+	updates := make(map[string][]posting)
+	for term, value := range idx.terms.IterateEncrypted() {
+		ps, err := decodePostings(value)
+		if err != nil {
+			return fmt.Errorf("reading search index: %w", err)
+		}
+		kept := slices.DeleteFunc(slices.Clone(ps), func(p posting) bool {
+			return p.sessionID == sessionID
+		})
+		if len(kept) != len(ps) {
+			updates[string(term)] = kept
+		}
+	}
+	for term, ps := range updates {
+		var err error
+		if len(ps) == 0 {
+			err = idx.terms.Delete([]byte(term))
+		} else {
+			err = idx.terms.PutEncrypted([]byte(term), encodePostings(ps))
+		}
+		if err != nil {
+			return fmt.Errorf("updating search index: %w", err)
+		}
+	}
+	return nil
+}
+
+func (idx *searchIndex) termKey(term string) []byte {
+	mac := hmac.New(sha256.New, idx.key)
+	mac.Write([]byte(term))
+	return mac.Sum(nil)[:termKeySize]
+}
+
+// lookup returns the postings of a term.
+func (idx *searchIndex) lookup(term string) ([]posting, error) {
+	value, err := idx.terms.GetEncrypted(idx.termKey(term))
+	switch {
+	case err == nil:
+		return decodePostings(value)
+	case errors.Is(err, engine.ErrMissingItem):
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// add indexes the chat entry stored under key in the given session.
+func (idx *searchIndex) add(sessionID string, key, payload []byte) error {
+	p := posting{sessionID: sessionID, key: key}
+	for _, term := range tokenize(string(payload)) {
+		ps, err := idx.lookup(term)
+		if err != nil {
+			return fmt.Errorf("reading search index: %w", err)
+		}
+		err = idx.terms.PutEncrypted(
+			idx.termKey(term), encodePostings(append(ps, p)),
+		)
+		if err != nil {
+			return fmt.Errorf("updating search index: %w", err)
+		}
+	}
+	return nil
+}
+
+// remove drops the chat entry stored under key in the given session from the
+// index. payload must be the entry's data, from which its terms are derived.
+func (idx *searchIndex) remove(sessionID string, key, payload []byte) error {
+	id := posting{sessionID: sessionID, key: key}.id()
+	for _, term := range tokenize(string(payload)) {
+		ps, err := idx.lookup(term)
+		if err != nil {
+			return fmt.Errorf("reading search index: %w", err)
+		}
+		ps = slices.DeleteFunc(ps, func(p posting) bool { return p.id() == id })
+		termKey := idx.termKey(term)
+		if len(ps) == 0 {
+			err = idx.terms.Delete(termKey)
+		} else {
+			err = idx.terms.PutEncrypted(termKey, encodePostings(ps))
+		}
+		if err != nil {
+			return fmt.Errorf("updating search index: %w", err)
+		}
+	}
+	return nil
+}
+
+// search returns the postings of entries containing every term.
+func (idx *searchIndex) search(terms []string) ([]posting, error) {
+	var matches []posting
+	for i, term := range terms {
+		ps, err := idx.lookup(term)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			matches = ps
+			continue
+		}
+		found := make(map[string]struct{}, len(ps))
+		for _, p := range ps {
+			found[p.id()] = struct{}{}
+		}
+		matches = slices.DeleteFunc(matches, func(p posting) bool {
+			_, ok := found[p.id()]
+			return !ok
+		})
+		if len(matches) == 0 {
+			break
+		}
+	}
+	return matches, nil
+}
+
+// SearchChatHistory returns the chat entries of all sessions that contain
+// every word of query, newest first. Words are matched whole and ignoring case.
+// In Persian and Arabic text, diacritics, tatweel, zero-width joiners, and the
+// Arabic forms of kaf and yeh are ignored. Chinese and Japanese characters are
+// matched one at a time; other words shorter than two letters are ignored.
+//
+// When the search index is enabled the lookup only touches matching entries;
+// otherwise every stored entry is scanned.
+func (s *Storage) SearchChatHistory(query string) ([]SearchResult, error) {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var results []SearchResult
+	err := s.engine.Query(func(b engine.Namespace) error {
+		idx, ok := loadSearchIndex(b)
+		if !ok {
+			results = scanChatHistory(b, terms)
+			return nil
+		}
+		matches, err := idx.search(terms)
+		if err != nil {
+			return fmt.Errorf("reading search index: %w", err)
+		}
+		for _, p := range matches {
+			value, err := sessionChat(b, p.sessionID).GetEncrypted(p.key)
+			if err != nil {
+				continue
+			}
+			c := chatCipher(b, p.sessionID)
+			if entry, ok := openChatEntry(c, p.key, value); ok {
+				results = append(results, SearchResult{entry, p.sessionID})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("searching chat history: %w", err)
+	}
+
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		if c := b.Timestamp.Compare(a.Timestamp); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.SessionID, b.SessionID)
+	})
+	return results, nil
+}
+
+// scanChatHistory returns the entries of all sessions that contain every term,
+// without using the index.
+func scanChatHistory(b engine.Namespace, terms []string) []SearchResult {
+	var results []SearchResult
+	sessions := b.Sub([]byte(engine.SessionsNamespace))
+	for _, sid := range sessions.ListSubNamespaces() {
+		c := chatCipher(b, sid)
+		for key, value := range sessionChat(b, sid).IterateEncrypted() {
+			entry, ok := openChatEntry(c, key, value)
+			if !ok {
+				continue
+			}
+			words := tokenize(string(entry.Data))
+			if !slices.ContainsFunc(terms, func(t string) bool {
+				return !slices.Contains(words, t)
+			}) {
+				results = append(results, SearchResult{entry, sid})
+			}
+		}
+	}
+	return results
+}
+
+// RebuildSearchIndex discards the search index and rebuilds it from every
+// stored chat entry. It returns [ErrSearchDisabled] if the index was disabled
+// with [WithSearchIndex].
+func (s *Storage) RebuildSearchIndex() error {
+	if !s.searchIndex {
+		return ErrSearchDisabled
+	}
+	err := s.engine.Command(func(b engine.Namespace) error {
+		if err := dropSearchIndex(b); err != nil {
+			return err
+		}
+		_, err := ensureSearchIndex(b)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("rebuilding search index: %w", err)
+	}
+	return nil
+}
+
+// DropSearchIndex deletes the search index. Searches scan every entry until
+// the index is rebuilt, which happens on the next [Storage.AddChatEntry] unless
+// the index is disabled.
+func (s *Storage) DropSearchIndex() error {
+	return s.engine.Command(dropSearchIndex)
+}
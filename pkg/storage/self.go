@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+const (
+	// SelfSessionID is the ID of the note-to-self session, in which the
+	// local identity is its own peer; see [Storage.SelfSession]. It cannot
+	// collide with the ID of a session established over the network, and is
+	// the same on every device that holds the identity.
+	SelfSessionID = "self"
+	// SelfPeerName is the name of the peer of the note-to-self session.
+	SelfPeerName = "Note to self"
+)
+
+// SelfSession returns the ID of the note-to-self session, in which notes and
+// files the user sends to themselves are kept like any other chat history,
+// creating it on first use. Its peer, as returned by [Storage.GetPeer], is
+// the local identity itself, named [SelfPeerName], and it belongs to a
+// [Conversation] of its own.
+func (s *Storage) SelfSession() (string, error) {
+	self, err := s.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	var created bool
+	err = s.engine.Command(func(b engine.Namespace) error {
+		sessions := b.Ensure([]byte(engine.SessionsNamespace))
+		session := sessions.Ensure([]byte(SelfSessionID))
+		meta := session.Ensure([]byte("meta"))
+		_ = session.Ensure([]byte("chat"))
+		if _, err := meta.GetEncrypted([]byte(PeerKey)); err == nil {
+			return nil
+		} else if !isMissing(err) {
+			return err
+		}
+
+		created = true
+		if err := meta.PutEncrypted([]byte(PeerKey), self); err != nil {
+			return fmt.Errorf("store peer key: %w", err)
+		}
+		var tsBuf [8]byte
+		binary.BigEndian.PutUint64(tsBuf[:], uint64(s.clock.Now().UnixNano()))
+		err := meta.PutEncrypted([]byte(EstablishedAtKey), tsBuf[:])
+		if err != nil {
+			return fmt.Errorf("store established_at: %w", err)
+		}
+		if err := s.attachSession(b, SelfSessionID, self); err != nil {
+			return fmt.Errorf("attach to conversation: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("create self session: %w", err)
+	}
+	if created {
+		s.notify(sessionEvent(EventAdded, SelfSessionID))
+	}
+	return SelfSessionID, nil
+}
+
+// selfPeer returns the peer of the note-to-self session, whose public key is
+// publicKey.
+func (s *Storage) selfPeer(publicKey []byte) (*Peer, error) {
+	established, err := s.GetEstablishedAt(SelfSessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &Peer{
+		Name:      SelfPeerName,
+		PublicKey: publicKey,
+		FirstSeen: established,
+		LastSeen:  s.clock.Now(),
+	}, nil
+}
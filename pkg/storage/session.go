@@ -43,6 +43,11 @@ const (
 	EstablishedAtKey    = "established_at"
 	ResumptionTokensKey = "resumption_tokens"
 	RelayTokensKey      = "relay_tokens"
+	ServiceKey          = "service"
+	ConversationKey     = "conversation"
+	CapabilitiesKey     = "capabilities"
+	ReceivedCountKey    = "received_count"
+	FrameFormatKey      = "frame_format"
 )
 
 var (
@@ -51,10 +56,12 @@ var (
 )
 
 // CreateSession creates a new session record under sessions/<id>/meta/ with
-// peer key, peer name, and establishment timestamp.
+// peer key, peer name, and establishment timestamp, and attaches the session
+// to the peer's [Conversation].
 func (s *Storage) CreateSession(sessionID string, publicKey []byte) error {
+	key := peerKey(publicKey)
 	err := s.engine.Command(func(b engine.Namespace) error {
-		peer, err := s.findPeer(b, peerKey(publicKey))
+		peer, err := s.findPeer(b, key)
 		if err != nil {
 			return fmt.Errorf("find peer: %w", err)
 		}
@@ -78,11 +85,19 @@ func (s *Storage) CreateSession(sessionID string, publicKey []byte) error {
 			return fmt.Errorf("store established_at: %w", err)
 		}
 
+		if err := s.attachSession(b, sessionID, peer.PublicKey); err != nil {
+			return fmt.Errorf("attach to conversation: %w", err)
+		}
+
 		return nil
 	})
+	if errors.Is(err, ErrPeerExpired) {
+		s.removeExpiredPeer(publicKey)
+	}
 	if err != nil {
 		return fmt.Errorf("create session %s: %w", sessionID, err)
 	}
+	s.notify(sessionEvent(EventAdded, sessionID))
 	return nil
 }
 
@@ -132,7 +147,8 @@ func (s *Storage) DeleteMeta(sessionID, key string) error {
 	return nil
 }
 
-// GetPeer returns the peer associated with a session.
+// GetPeer returns the peer associated with a session, which for the
+// note-to-self session is the local identity; see [Storage.SelfSession].
 func (s *Storage) GetPeer(sessionID string) (*Peer, error) {
 	m, err := s.GetMeta(sessionID, PeerKey)
 	if err != nil {
@@ -141,6 +157,9 @@ func (s *Storage) GetPeer(sessionID string) (*Peer, error) {
 	if m.Value() == nil {
 		return nil, ErrSessionNotFound
 	}
+	if sessionID == SelfSessionID {
+		return s.selfPeer(m.Value())
+	}
 	var peer *Peer
 	err = s.engine.Query(func(b engine.Namespace) error {
 		p, findErr := s.findPeer(b, peerKey(m.Value()))
@@ -150,6 +169,9 @@ func (s *Storage) GetPeer(sessionID string) (*Peer, error) {
 		peer = p
 		return nil
 	})
+	if errors.Is(err, ErrPeerExpired) {
+		s.removeExpiredPeer(m.Value())
+	}
 	if err != nil {
 		return nil, fmt.Errorf("find peer for session %s: %w", sessionID, err)
 	}
@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// SessionStats holds the counters of a single connection of a session, from
+// the moment it was established or resumed until it was closed.
+type SessionStats struct {
+	Start            time.Time
+	End              time.Time
+	SessionID        string
+	PeerKey          []byte
+	MessagesSent     uint64
+	MessagesReceived uint64
+	BytesSent        uint64
+	BytesReceived    uint64
+	Resumed          bool
+}
+
+// Usage is the sum of one or more [SessionStats] records.
+type Usage struct {
+	MessagesSent     uint64
+	MessagesReceived uint64
+	BytesSent        uint64
+	BytesReceived    uint64
+	Duration         time.Duration
+	// Connections is the number of records summed, and Resumptions how many
+	// of them were resumed sessions.
+	Connections int
+	Resumptions int
+}
+
+// Traffic returns the total number of bytes sent and received.
+func (u Usage) Traffic() uint64 { return u.BytesSent + u.BytesReceived }
+
+func (u *Usage) add(st *pb.SessionStats) {
+	u.MessagesSent += st.GetMessagesSent()
+	u.MessagesReceived += st.GetMessagesReceived()
+	u.BytesSent += st.GetBytesSent()
+	u.BytesReceived += st.GetBytesReceived()
+	u.Duration += st.GetEnd().AsTime().Sub(st.GetStart().AsTime())
+	u.Connections++
+	if st.GetResumed() {
+		u.Resumptions++
+	}
+}
+
+// PeerUsage is the usage attributed to a single peer.
+type PeerUsage struct {
+	PublicKey []byte
+	Usage
+}
+
+// DailyUsage is the usage of all sessions that ended on a given UTC day.
+type DailyUsage struct {
+	Day time.Time
+	Usage
+}
+
+// timeKey returns the storage key for a stats or timeline record of a
+// session. Keys start with the big-endian time of the record so that records
+// iterate in chronological order and pruning can stop at the first record it
+// keeps.
+func timeKey(end time.Time, sessionID string) []byte {
+	key := make([]byte, 8, 8+len(sessionID)+4)
+	binary.BigEndian.PutUint64(key, uint64(end.UnixNano()))
+	key = append(key, sessionID...)
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return append(key, suffix...)
+}
+
+// RecordSessionStats persists the counters of a closed connection. Records
+// older than the configured retention (see [WithStatsRetention]) are pruned in
+// the same transaction.
+func (s *Storage) RecordSessionStats(st SessionStats) error {
+	end := st.End
+	if end.IsZero() {
+		end = s.clock.Now()
+	}
+	data, err := proto.Marshal(&pb.SessionStats{
+		SessionID:        st.SessionID,
+		PeerKey:          st.PeerKey,
+		Start:            timestamppb.New(st.Start),
+		End:              timestamppb.New(end),
+		MessagesSent:     st.MessagesSent,
+		MessagesReceived: st.MessagesReceived,
+		BytesSent:        st.BytesSent,
+		BytesReceived:    st.BytesReceived,
+		Resumed:          st.Resumed,
+	})
+	if err != nil {
+		return fmt.Errorf("marshaling session stats: %w", err)
+	}
+
+	err = s.engine.Command(func(b engine.Namespace) error {
+		stats := b.Ensure([]byte(engine.StatsNamespace))
+		if s.statsRetention > 0 {
+			pruneBefore(stats, s.clock.Now().Add(-s.statsRetention))
+		}
+		return stats.PutEncrypted(timeKey(end, st.SessionID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("record stats for session %s: %w", st.SessionID, err)
+	}
+	return nil
+}
+
+// PruneSessionStats removes all stats records that ended before the given
+// time and returns how many were removed.
+func (s *Storage) PruneSessionStats(before time.Time) (int, error) {
+	var n int
+	err := s.engine.Command(func(b engine.Namespace) error {
+		n = pruneBefore(b.Sub([]byte(engine.StatsNamespace)), before)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("pruning session stats: %w", err)
+	}
+	return n, nil
+}
+
+// pruneBefore deletes the records of ns keyed by timeKey before the cutoff.
+func pruneBefore(ns engine.Namespace, before time.Time) int {
+	var cutoff [8]byte
+	binary.BigEndian.PutUint64(cutoff[:], uint64(before.UnixNano()))
+
+	var expired [][]byte
+	for key := range ns.IterateEncrypted() {
+		if bytes.Compare(key[:min(len(key), 8)], cutoff[:]) >= 0 {
+			break
+		}
+		expired = append(expired, key)
+	}
+	for _, key := range expired {
+		if err := ns.Delete(key); err != nil {
+			slog.Warn("failed to prune expired record", slog.Any("error", err))
+		}
+	}
+	return len(expired)
+}
+
+// forEachStats calls fn for every stats record that ended at or after since.
+func (s *Storage) forEachStats(
+	since time.Time, fn func(*pb.SessionStats),
+) error {
+	return s.engine.Query(func(b engine.Namespace) error {
+		for _, value := range b.Sub(
+			[]byte(engine.StatsNamespace),
+		).IterateEncrypted() {
+			var st pb.SessionStats
+			if err := proto.Unmarshal(value, &st); err != nil {
+				slog.Warn(
+					"skipping malformed stats entry", slog.Any("error", err),
+				)
+				continue
+			}
+			if st.GetEnd().AsTime().Before(since) {
+				continue
+			}
+			fn(&st)
+		}
+		return nil
+	})
+}
+
+// SessionUsage returns the cumulative usage of a session across all of its
+// recorded connections, including resumptions.
+func (s *Storage) SessionUsage(sessionID string) (Usage, error) {
+	var u Usage
+	err := s.forEachStats(time.Time{}, func(st *pb.SessionStats) {
+		if st.GetSessionID() == sessionID {
+			u.add(st)
+		}
+	})
+	if err != nil {
+		return Usage{}, fmt.Errorf("querying session usage: %w", err)
+	}
+	return u, nil
+}
+
+// TopPeersByTraffic returns up to limit peers ordered by the number of bytes
+// exchanged with them in sessions that ended at or after since. A limit of
+// zero or less returns every peer.
+func (s *Storage) TopPeersByTraffic(
+	since time.Time, limit int,
+) ([]PeerUsage, error) {
+	byPeer := make(map[string]*PeerUsage)
+	err := s.forEachStats(since, func(st *pb.SessionStats) {
+		pu, ok := byPeer[string(st.GetPeerKey())]
+		if !ok {
+			pu = &PeerUsage{PublicKey: st.GetPeerKey()}
+			byPeer[string(st.GetPeerKey())] = pu
+		}
+		pu.add(st)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("querying peer usage: %w", err)
+	}
+
+	peers := make([]PeerUsage, 0, len(byPeer))
+	for _, pu := range byPeer {
+		peers = append(peers, *pu)
+	}
+	slices.SortFunc(peers, func(a, b PeerUsage) int {
+		if c := cmp.Compare(b.Traffic(), a.Traffic()); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.PublicKey, b.PublicKey)
+	})
+	if limit > 0 && len(peers) > limit {
+		peers = peers[:limit]
+	}
+	return peers, nil
+}
+
+// UsageByDay returns the total usage per UTC day for sessions that ended at or
+// after since, oldest day first. Days without any session are omitted.
+func (s *Storage) UsageByDay(since time.Time) ([]DailyUsage, error) {
+	var days []DailyUsage
+	err := s.forEachStats(since, func(st *pb.SessionStats) {
+		day := st.GetEnd().AsTime().UTC().Truncate(24 * time.Hour)
+		if n := len(days); n == 0 || !days[n-1].Day.Equal(day) {
+			days = append(days, DailyUsage{Day: day})
+		}
+		days[len(days)-1].add(st)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("querying daily usage: %w", err)
+	}
+	return days, nil
+}
@@ -2,6 +2,8 @@ package storage
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
@@ -10,24 +12,34 @@ import (
 	"os"
 	"path/filepath"
 	"slices"
+	"sync"
 	"time"
 
 	"github.com/kamune-org/kamune/internal/clock"
 	"github.com/kamune-org/kamune/internal/engine"
-	"github.com/kamune-org/kamune/pkg/attest"
+	"github.com/kamune-org/kamune/pkg/storage/store"
 )
 
 // Store and Namespace are aliases for the interfaces defined in
 // internal/engine. They allow external clients to implement custom storage
 // backends without importing internal packages.
 type (
-	Store     = engine.Store
-	Namespace = engine.Namespace
+	Store           = engine.Store
+	Namespace       = engine.Namespace
+	BucketStats     = engine.BucketStats
+	SpaceStats      = engine.SpaceStats
+	CompactProgress = engine.CompactProgress
 )
 
 var (
 	ErrMissingChatBucket = errors.New("chat bucket not found")
 	ErrEmptyAppName      = errors.New("app name must not be empty")
+	ErrSearchDisabled    = errors.New("search index is disabled")
+	ErrStoreInUse        = engine.ErrStoreInUse
+	ErrStorageLocked     = engine.ErrLocked
+	ErrLockUnsupported   = errors.New("storage backend cannot be locked")
+	ErrIdentityNotFound  = errors.New("identity not found")
+	ErrIdentityExists    = errors.New("identity already exists")
 
 	sessionMetaKey = []byte("name")
 
@@ -35,6 +47,15 @@ var (
 	// versioned format (sender timestamp embedded in value) from legacy
 	// entries that store raw message data only.
 	valueMagic = []byte("KMNE\x01")
+	// clockedValueMagic marks values that also carry the sender's hybrid
+	// logical clock reading after the timestamp.
+	clockedValueMagic = []byte("KMNE\x02")
+	// sealedValueMagic marks values that are further encrypted with their
+	// conversation's key; see WithConversationKeys.
+	sealedValueMagic = []byte("KMNE\x03")
+	// signedValueMagic marks values that carry the sender's clock reading
+	// and the signature of the message; see AddSignedChatEntry.
+	signedValueMagic = []byte("KMNE\x04")
 )
 
 // SessionSummary holds a session ID together with its first and last message
@@ -57,9 +78,35 @@ const (
 
 // ChatEntry represents a decrypted chat message stored in the DB.
 type ChatEntry struct {
-	Timestamp time.Time
-	Data      []byte
-	Sender    Sender
+	Timestamp time.Time `json:"timestamp"`
+	Data      []byte    `json:"data"`
+	// Clock is the sender's hybrid logical clock reading, as reported by
+	// the metadata of kamune messages, or zero if it was not recorded.
+	Clock  uint64 `json:"clock,omitempty"`
+	Sender Sender `json:"sender"`
+	// Signature is what the sender signed when sending the message, if it
+	// was recorded with [Storage.AddSignedChatEntry].
+	Signature *MessageSignature `json:"signature,omitempty"`
+}
+
+// order returns the reading entries are ordered by. Entries recorded without
+// a clock reading get one from their timestamp, with the same layout:
+// milliseconds in the high 48 bits and a counter in the low 16.
+func (e ChatEntry) order() uint64 {
+	if e.Clock != 0 {
+		return e.Clock
+	}
+	return uint64(e.Timestamp.UnixMilli()) << 16
+}
+
+// compareChatEntries orders chat entries by clock reading, then timestamp,
+// then sender.
+func compareChatEntries(a, b ChatEntry) int {
+	return cmp.Or(
+		cmp.Compare(a.order(), b.order()),
+		a.Timestamp.Compare(b.Timestamp),
+		cmp.Compare(a.Sender, b.Sender),
+	)
 }
 
 type PassphraseHandler func() ([]byte, error)
@@ -74,27 +121,86 @@ func defaultPassphraseHandler() ([]byte, error) {
 type Storage struct {
 	clock             clock.Clock
 	passphraseHandler PassphraseHandler
+	evictionHandler   func(Eviction)
+	lockHandler       func()
+	identityHandler   func(publicKey []byte)
+	degradedHandler   func(err error)
+	compactionHandler func(Compaction)
+	stopAutoLock      context.CancelFunc
+	stopBackups       context.CancelFunc
+	stopCompaction    context.CancelFunc
 	engine            engine.Store
+	backend           store.Backend
+	lastActive        time.Time
+	blockHooks        blockHooks
+	subscribers       subscribers
+	presence          presence
+	retry             chatRetry
 	dbPath            string
+	backupDir         string
+	sessionQuota      ChatQuota
+	peerQuota         ChatQuota
 	expiryDuration    time.Duration
+	statsRetention    time.Duration
+	timelineRetention time.Duration
 	timeout           time.Duration
+	autoLock          time.Duration
+	backupInterval    time.Duration
+	compactInterval   time.Duration
+	compactThreshold  float64
+	seenResolution    time.Duration
+	backupKeep        int
+	hooksMu           sync.Mutex
+	lockMu            sync.Mutex
+	backupMu          sync.Mutex
 	createDB          bool
+	inMemory          bool
+	searchIndex       bool
+	requireIdentity   bool
+	conversationKeys  bool
 }
 
 func OpenStorage(opts ...StorageOption) (*Storage, error) {
 	s := &Storage{
 		passphraseHandler: defaultPassphraseHandler,
 		expiryDuration:    7 * 24 * time.Hour,
+		statsRetention:    90 * 24 * time.Hour,
+		timelineRetention: 30 * 24 * time.Hour,
 		timeout:           5 * time.Second,
+		seenResolution:    defaultPresenceResolution,
 		clock:             clock.Real(),
 		createDB:          true,
+		searchIndex:       true,
 	}
 	for _, opt := range opts {
 		opt(s)
 	}
 
-	// If a backend was injected via WithBackend, skip BoltDB setup.
+	if s.engine == nil && s.backend != nil {
+		var pass []byte
+		if !s.inMemory {
+			var err error
+			if pass, err = s.passphraseHandler(); err != nil {
+				return nil, fmt.Errorf("getting passphrase: %w", err)
+			}
+		}
+		db, err := engine.NewBackendStore(s.backend, pass)
+		if err != nil {
+			return nil, fmt.Errorf("opening kamune store: %w", err)
+		}
+		s.engine = db
+		if s.inMemory {
+			// The passphrase of a store in memory protects nothing, so
+			// there is no key worth locking away.
+			s.engine = struct{ engine.Store }{db}
+		}
+	}
+	// If a backend was injected via WithBackend or WithStore, skip BoltDB
+	// setup.
 	if s.engine != nil {
+		s.startAutoLock()
+		s.startBackups()
+		s.startAutoCompaction()
 		return s, nil
 	}
 
@@ -133,11 +239,25 @@ func OpenStorage(opts ...StorageOption) (*Storage, error) {
 		return nil, fmt.Errorf("opening kamune db: %w", err)
 	}
 	s.engine = db
+	s.startAutoLock()
+	s.startBackups()
+	s.startAutoCompaction()
 
 	return s, nil
 }
 
 func (s *Storage) Close() error {
+	s.flushPresence()
+	if s.stopAutoLock != nil {
+		s.stopAutoLock()
+	}
+	if s.stopBackups != nil {
+		s.stopBackups()
+	}
+	if s.stopCompaction != nil {
+		s.stopCompaction()
+	}
+	s.stopRetry()
 	return s.engine.Close()
 }
 
@@ -151,41 +271,6 @@ func (s *Storage) PublicKey() ([]byte, error) {
 	return at.MarshalPublicKey(), nil
 }
 
-func (s *Storage) Attester() (*attest.Attest, error) {
-	key := []byte("attest")
-	var id []byte
-	err := s.engine.Query(func(b engine.Namespace) error {
-		var err error
-		id, err = b.Sub([]byte(engine.DefaultNamespace)).GetEncrypted(key)
-		return err
-	})
-	switch {
-	case err == nil:
-		return attest.Load(id)
-	case errors.Is(err, engine.ErrMissingItem):
-		// continue
-	default:
-		return nil, fmt.Errorf("getting identity: %w", err)
-	}
-
-	at, err := attest.New()
-	if err != nil {
-		return nil, fmt.Errorf("new attest: %w", err)
-	}
-	data, err := at.MarshalPrivateKey()
-	if err != nil {
-		return nil, fmt.Errorf("marshalling private key: %w", err)
-	}
-	err = s.engine.Command(func(b engine.Namespace) error {
-		return b.Ensure([]byte(engine.DefaultNamespace)).PutEncrypted(key, data)
-	})
-	if err != nil {
-		return nil, fmt.Errorf("persisting generated attest: %w", err)
-	}
-
-	return at, nil
-}
-
 // sessionChat returns the chat sub-namespace for a session.
 func sessionChat(b engine.Namespace, sessionID string) engine.Namespace {
 	return b.Sub([]byte(engine.SessionsNamespace)).
@@ -207,27 +292,18 @@ func sessionMeta(b engine.Namespace, sessionID string) engine.Namespace {
 //   - 2 bytes: sender ID (big-endian; 0 means local user, 1 means remote user)
 //   - 4 bytes: random suffix to avoid collision
 //
-// The sender's original timestamp is extracted from the value envelope (5-byte
-// magic + 8-byte timestamp + payload). Results are sorted by timestamp then
-// sender.
+// The sender's original timestamp, and its clock reading if one was recorded,
+// are extracted from the value envelope. Results are sorted by clock reading,
+// so that history reads the same on both peers even if their clocks are
+// skewed; entries without a reading are placed by their timestamp.
 func (s *Storage) GetChatHistory(sessionID string) ([]ChatEntry, error) {
 	var entries []ChatEntry
 	err := s.engine.Query(func(b engine.Namespace) error {
-		chat := sessionChat(b, sessionID)
+		chat, c := sessionChat(b, sessionID), chatCipher(b, sessionID)
 		for key, value := range chat.IterateEncrypted() {
-			if len(key) < 14 {
-				continue
+			if entry, ok := openChatEntry(c, key, value); ok {
+				entries = append(entries, entry)
 			}
-			sender := Sender(binary.BigEndian.Uint16(key[8:]))
-
-			ts := time.Unix(0, int64(binary.BigEndian.Uint64(value[5:13])))
-			data := value[13:]
-
-			entries = append(entries, ChatEntry{
-				Timestamp: ts,
-				Data:      data,
-				Sender:    sender,
-			})
 		}
 
 		return nil
@@ -236,16 +312,38 @@ func (s *Storage) GetChatHistory(sessionID string) ([]ChatEntry, error) {
 		return nil, fmt.Errorf("querying chat history: %w", err)
 	}
 
-	slices.SortFunc(entries, func(a, b ChatEntry) int {
-		if c := a.Timestamp.Compare(b.Timestamp); c != 0 {
-			return c
-		}
-		return int(a.Sender) - int(b.Sender)
-	})
+	slices.SortFunc(entries, compareChatEntries)
 
 	return entries, nil
 }
 
+// decodeChatEntry decodes a chat entry from its key and value, as laid out by
+// AddChatEntry. It reports false for malformed entries.
+func decodeChatEntry(key, value []byte) (ChatEntry, bool) {
+	if len(key) < 14 || len(value) < 13 {
+		return ChatEntry{}, false
+	}
+	if bytes.HasPrefix(value, signedValueMagic) {
+		return decodeSignedChatEntry(key, value)
+	}
+	if bytes.HasPrefix(value, clockedValueMagic) {
+		if len(value) < 21 {
+			return ChatEntry{}, false
+		}
+		return ChatEntry{
+			Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(value[5:13]))),
+			Clock:     binary.BigEndian.Uint64(value[13:21]),
+			Data:      value[21:],
+			Sender:    Sender(binary.BigEndian.Uint16(key[8:])),
+		}, true
+	}
+	return ChatEntry{
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(value[5:13]))),
+		Data:      value[13:],
+		Sender:    Sender(binary.BigEndian.Uint16(key[8:])),
+	}, true
+}
+
 // ListSessions returns a list of session IDs stored under the sessions namespace.
 func (s *Storage) ListSessions() ([]string, error) {
 	var sessions []string
@@ -384,6 +482,7 @@ func (s *Storage) SetSessionName(sessionID, name string) error {
 		if err != nil && !errors.Is(err, engine.ErrMissingNamespace) {
 			return fmt.Errorf("clear session name for %s: %w", sessionID, err)
 		}
+		s.notify(sessionEvent(EventUpdated, sessionID))
 		return nil
 	}
 	err := s.engine.Command(func(b engine.Namespace) error {
@@ -393,6 +492,7 @@ func (s *Storage) SetSessionName(sessionID, name string) error {
 	if err != nil {
 		return fmt.Errorf("set session name for %s: %w", sessionID, err)
 	}
+	s.notify(sessionEvent(EventUpdated, sessionID))
 	return nil
 }
 
@@ -453,22 +553,31 @@ func (s *Storage) SetSettings(app, key, value string) error {
 }
 
 // DeleteSession removes the session sub-namespace (chat, meta, resumption)
-// for the given session ID.
+// for the given session ID, along with its entries in the search index, and
+// detaches it from its conversation.
 func (s *Storage) DeleteSession(sessionID string) error {
 	err := s.engine.Command(func(b engine.Namespace) error {
-		sessions := b.Sub([]byte(engine.SessionsNamespace))
-		if err := sessions.DeleteNamespace([]byte(sessionID)); err != nil &&
-			!errors.Is(err, engine.ErrMissingNamespace) {
-			return err
-		}
-		return nil
+		return deleteSession(b, sessionID)
 	})
 	if err != nil {
 		return fmt.Errorf("delete session %s: %w", sessionID, err)
 	}
+	s.notify(sessionEvent(EventDeleted, sessionID))
 	return nil
 }
 
+func deleteSession(b engine.Namespace, sessionID string) error {
+	if err := detachSession(b, sessionID); err != nil {
+		return err
+	}
+	sessions := b.Sub([]byte(engine.SessionsNamespace))
+	if err := sessions.DeleteNamespace([]byte(sessionID)); err != nil &&
+		!errors.Is(err, engine.ErrMissingNamespace) {
+		return err
+	}
+	return unindexSession(b, sessionID)
+}
+
 // AddChatEntry stores a chat message for the given session ID. The message
 // is stored in sessions/<sessionID>/chat/.
 //
@@ -484,9 +593,45 @@ func (s *Storage) DeleteSession(sessionID string) error {
 //   - remaining: message payload
 //
 // The ts parameter is the sender's original timestamp and is preserved in
-// the value for display, separate from the ordering key.
+// the value for display, separate from the ordering key. Messages that carry
+// a hybrid logical clock reading are better stored with
+// [Storage.AddChatEntryWithClock].
+//
+// Unless disabled with [WithSearchIndex], the entry is also added to the search
+// index used by [Storage.SearchChatHistory]. With [WithConversationKeys], the
+// value is encrypted with the conversation's key before it is stored.
+//
+// If the entry takes the session or its peer over a quota set with
+// [WithSessionChatQuota] or [WithPeerChatQuota], the oldest entries are evicted
+// in the same transaction and reported to the [WithEvictionHandler] handler.
+//
+// If the entry cannot be written because the disk is full or failing, it is
+// kept in memory and written in the background once the storage recovers, in
+// the order entries were added, and AddChatEntry returns nil; see
+// [Storage.Degraded] and [WithDegradedHandler]. Events and evictions for such
+// entries are reported when they are written. Only once 1024 entries are
+// waiting does it fail, with [ErrStorageUnavailable].
 func (s *Storage) AddChatEntry(
 	sessionID string, payload []byte, ts time.Time, sender Sender,
+) error {
+	return s.addChatEntry(sessionID, payload, ts, 0, sender, nil)
+}
+
+// AddChatEntryWithClock is [Storage.AddChatEntry] for a message that carries
+// the sender's hybrid logical clock reading, which is stored after the
+// timestamp under the magic prefix "KMNE\x02". History is ordered by these
+// readings, which, unlike timestamps, agree with the order in which the peers
+// saw the messages.
+func (s *Storage) AddChatEntryWithClock(
+	sessionID string, payload []byte, ts time.Time, hlc uint64,
+	sender Sender,
+) error {
+	return s.addChatEntry(sessionID, payload, ts, hlc, sender, nil)
+}
+
+func (s *Storage) addChatEntry(
+	sessionID string, payload []byte, ts time.Time, hlc uint64,
+	sender Sender, sig *MessageSignature,
 ) error {
 	// Key uses local time to avoid clock skew in ordering
 	key := make([]byte, 14)
@@ -498,18 +643,111 @@ func (s *Storage) AddChatEntry(
 	}
 
 	// Encode sender timestamp into value for correct display
-	enc := make([]byte, 13+len(payload))
-	copy(enc, valueMagic)
+	var enc []byte
+	switch {
+	case sig != nil:
+		enc = make([]byte, 21, 21+sig.size()+len(payload))
+		copy(enc, signedValueMagic)
+		binary.BigEndian.PutUint64(enc[13:], hlc)
+		enc = sig.append(enc)
+	case hlc == 0:
+		enc = make([]byte, 13, 13+len(payload))
+		copy(enc, valueMagic)
+	default:
+		enc = make([]byte, 21, 21+len(payload))
+		copy(enc, clockedValueMagic)
+		binary.BigEndian.PutUint64(enc[13:], hlc)
+	}
 	binary.BigEndian.PutUint64(enc[5:], uint64(ts.UnixNano()))
-	copy(enc[13:], payload)
+	enc = append(enc, payload...)
+
+	w := &chatWrite{
+		ts:        ts,
+		sessionID: sessionID,
+		key:       key,
+		enc:       enc,
+		// Not payload itself, which may be reused before a retry.
+		payload: enc[len(enc)-len(payload):],
+		hlc:     hlc,
+		sender:  sender,
+	}
+	if s.deferChatEntry(w) {
+		return nil
+	}
+	err := s.writeChatEntry(w)
+	if errors.Is(err, ErrStorageUnavailable) && s.retryChatEntry(w, err) {
+		return nil
+	}
+	return err
+}
 
+// writeChatEntry stores w, enforcing the chat quotas, and reports the entry
+// and any evictions.
+func (s *Storage) writeChatEntry(w *chatWrite) error {
+	sessionID, key := w.sessionID, w.key
+	var evicted []Eviction
 	err := s.engine.Command(func(b engine.Namespace) error {
+		var idx *searchIndex
+		if s.searchIndex {
+			var err error
+			if idx, err = ensureSearchIndex(b); err != nil {
+				return err
+			}
+		} else if err := dropSearchIndex(b); err != nil {
+			return err
+		}
+
+		value := w.enc
+		if s.conversationKeys {
+			c, err := ensureChatCipher(b, sessionID)
+			if err != nil {
+				return err
+			}
+			value = sealChatValue(c, w.enc)
+		}
 		chat := sessionChat(b, sessionID)
-		return chat.PutEncrypted(key, enc)
+		if err := chat.PutEncrypted(key, value); err != nil {
+			return err
+		}
+		if idx != nil {
+			if err := idx.add(sessionID, key, w.payload); err != nil {
+				return err
+			}
+		}
+		if err := adjustChatBytes(b, sessionID, int64(len(value))); err != nil {
+			return err
+		}
+
+		var err error
+		evicted, err = s.enforceChatQuotas(b, sessionID, key, idx)
+		return err
 	})
 	if err != nil {
-		return fmt.Errorf("store chat entry: %w", err)
+		return fmt.Errorf("store chat entry: %w", engine.WrapUnavailable(err))
+	}
+	if s.evictionHandler != nil {
+		for _, e := range evicted {
+			s.evictionHandler(e)
+		}
+	}
+
+	events := []Event{{
+		Class:     ChatBucket,
+		Op:        EventAdded,
+		SessionID: sessionID,
+		Entry: &ChatEntry{
+			Timestamp: w.ts,
+			Data:      w.payload,
+			Clock:     w.hlc,
+			Sender:    w.sender,
+		},
+	}}
+	for _, e := range evicted {
+		events = append(events, Event{
+			Class: ChatBucket, Op: EventDeleted, SessionID: e.SessionID,
+		})
 	}
+	s.notify(events...)
 	return nil
 }
 
@@ -544,12 +782,110 @@ func WithBackend(b engine.Store) StorageOption {
 	return func(p *Storage) { p.engine = b }
 }
 
+// WithStore keeps the storage in b, a [store.Backend], instead of a BoltDB
+// file, such as [store.NewMemory] for tests. Values are encrypted before they
+// reach b, with a key derived from the passphrase as for the file. Path,
+// timeout, and creation options are ignored, as are maintenance features the
+// backend does not offer, such as backups and compaction.
+func WithStore(b store.Backend) StorageOption {
+	return func(p *Storage) {
+		p.backend = b
+		p.inMemory = false
+	}
+}
+
+// WithStatsRetention sets how long session statistics are kept. Older records
+// are pruned whenever new statistics are recorded. The default is 90 days; zero
+// keeps them forever.
+func WithStatsRetention(d time.Duration) StorageOption {
+	return func(p *Storage) { p.statsRetention = d }
+}
+
+// WithTimelineRetention sets how long session timeline events are kept; see
+// [Storage.RecordTimelineEvent]. Older events are pruned whenever new ones are
+// recorded. The default is 30 days; zero keeps them forever.
+func WithTimelineRetention(d time.Duration) StorageOption {
+	return func(p *Storage) { p.timelineRetention = d }
+}
+
+// WithInMemory keeps the identity, peers, sessions, and chat history in memory
+// only. Nothing is written to disk and everything is wiped by [Storage.Close],
+// so each storage opened this way starts with a fresh identity. It suits
+// kiosk or incognito use and tests. It is [WithStore] with [store.NewMemory],
+// except that path and passphrase options are ignored and the storage cannot
+// be locked.
+func WithInMemory() StorageOption {
+	return func(p *Storage) {
+		p.backend = store.NewMemory()
+		p.inMemory = true
+	}
+}
+
+// WithSearchIndex controls whether chat entries are indexed for
+// [Storage.SearchChatHistory]. The default is true. When disabled, an existing
+// index is deleted on the next [Storage.AddChatEntry], or immediately with
+// [Storage.DropSearchIndex], and searches fall back to scanning every entry.
+func WithSearchIndex(v bool) StorageOption {
+	return func(p *Storage) { p.searchIndex = v }
+}
+
+// WithSessionChatQuota caps the chat history of every session. Once an entry
+// added with [Storage.AddChatEntry] exceeds the quota, the oldest entries of
+// the session are evicted.
+func WithSessionChatQuota(q ChatQuota) StorageOption {
+	return func(p *Storage) { p.sessionQuota = q }
+}
+
+// WithPeerChatQuota caps the combined chat history of all sessions with a peer,
+// as grouped by its [Conversation]. Once an entry exceeds the quota, the oldest
+// entries across those sessions are evicted.
+func WithPeerChatQuota(q ChatQuota) StorageOption {
+	return func(p *Storage) { p.peerQuota = q }
+}
+
+// WithEvictionHandler sets a function that is called once per affected session
+// whenever entries are evicted to enforce a chat quota. It runs after the
+// eviction is committed, on the goroutine that called [Storage.AddChatEntry].
+func WithEvictionHandler(fn func(Eviction)) StorageOption {
+	return func(p *Storage) { p.evictionHandler = fn }
+}
+
+// WithAutoLock locks the storage once it has been idle for d, as if by
+// [Storage.Lock]. Only [Storage.Touch] and [Storage.Unlock] count as activity.
+// A zero duration, the default, never locks automatically.
+func WithAutoLock(d time.Duration) StorageOption {
+	return func(p *Storage) { p.autoLock = d }
+}
+
+// WithLockHandler sets a function that is called whenever the storage becomes
+// locked, either by [Storage.Lock] or by [WithAutoLock]. Applications use it
+// to show their lock screen.
+func WithLockHandler(fn func()) StorageOption {
+	return func(p *Storage) { p.lockHandler = fn }
+}
+
 // WithCreateDB controls whether OpenStorage creates the database when it does
 // not exist. The default is true.
 func WithCreateDB(v bool) StorageOption {
 	return func(p *Storage) { p.createDB = v }
 }
 
+// WithRequireExistingIdentity makes [Storage.Attester] fail with
+// [ErrIdentityNotFound] instead of generating an identity when the storage has
+// none. Use it when opening a storage that is expected to exist, so that a
+// wrong database path surfaces as an error rather than as a new identity;
+// create identities explicitly with [Storage.CreateIdentity].
+func WithRequireExistingIdentity() StorageOption {
+	return func(p *Storage) { p.requireIdentity = true }
+}
+
+// WithIdentityHandler sets a function that is called with the public key of
+// every identity the storage generates, whether by [Storage.CreateIdentity] or
+// implicitly by [Storage.Attester]. It runs after the identity is persisted.
+func WithIdentityHandler(fn func(publicKey []byte)) StorageOption {
+	return func(p *Storage) { p.identityHandler = fn }
+}
+
 // WithTimeout sets the maximum time the backend waits for the database to open
 // or connect. A zero value keeps the backend default (5s for BoltDB). Ignored
 // when [WithBackend] is used.
@@ -1,13 +1,29 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/internal/clock"
+	"github.com/kamune-org/kamune/internal/engine"
 	"github.com/kamune-org/kamune/pkg/attest"
+	"github.com/kamune-org/kamune/pkg/paperkey"
+	"github.com/kamune-org/kamune/pkg/storage/store"
 )
 
 func newTestStorage(t *testing.T) (*Storage, func()) {
@@ -170,6 +186,157 @@ func TestUpdatePeerLastSeenMissingPeer(t *testing.T) {
 	a.NoError(err)
 }
 
+func TestDisplayName(t *testing.T) {
+	a := require.New(t)
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	name, err := storage.DisplayName()
+	a.NoError(err)
+	a.Empty(name)
+
+	a.NoError(storage.SetDisplayName("alice"))
+	name, err = storage.DisplayName()
+	a.NoError(err)
+	a.Equal("alice", name)
+
+	a.ErrorIs(
+		storage.SetDisplayName(strings.Repeat("a", 65)), ErrInvalidDisplayName,
+	)
+	a.ErrorIs(storage.SetDisplayName("\xff"), ErrInvalidDisplayName)
+
+	a.NoError(storage.SetDisplayName(""))
+	a.NoError(storage.SetDisplayName(""), "removing twice")
+	name, err = storage.DisplayName()
+	a.NoError(err)
+	a.Empty(name)
+}
+
+func TestRecordPeerName(t *testing.T) {
+	a := require.New(t)
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+	key := []byte("peer")
+	var updates int
+	storage.Subscribe(PeersBucket, func(e Event) {
+		a.Equal(EventUpdated, e.Op)
+		updates++
+	})
+
+	history, err := storage.PeerNameHistory(key)
+	a.NoError(err)
+	a.Nil(history)
+
+	start := time.Now().Truncate(time.Second)
+	a.NoError(storage.RecordPeerName(key, "alice", start))
+	a.NoError(storage.RecordPeerName(key, "alice", start.Add(time.Hour)))
+	a.NoError(storage.RecordPeerName(key, "mallory", start.Add(2*time.Hour)))
+	a.Equal(2, updates, "unchanged names are not recorded")
+	history, err = storage.PeerNameHistory(key)
+	a.NoError(err)
+	a.Len(history, 2)
+	a.Equal("alice", history[0].Name)
+	a.True(start.Equal(history[0].Since))
+	a.Equal("mallory", history[1].Name)
+
+	for i := range maxNameHistory {
+		name := fmt.Sprintf("name-%d", i)
+		a.NoError(storage.RecordPeerName(key, name, time.Time{}))
+	}
+	history, err = storage.PeerNameHistory(key)
+	a.NoError(err)
+	a.Len(history, maxNameHistory)
+	a.Equal("name-0", history[0].Name)
+	a.ErrorIs(
+		storage.RecordPeerName(nil, "alice", time.Time{}), ErrInvalidPublicKey,
+	)
+}
+
+func TestMarkPeerSeen(t *testing.T) {
+	a := require.New(t)
+	f, err := os.CreateTemp("", "kamune-storage-test-*.db")
+	a.NoError(err)
+	a.NoError(f.Close())
+	defer os.Remove(f.Name())
+	open := func() *Storage {
+		s, err := OpenStorage(
+			WithDBPath(f.Name()), WithNoPassphrase(),
+			WithExpiryDuration(24*time.Hour),
+			WithPresenceResolution(time.Minute),
+		)
+		a.NoError(err)
+		return s
+	}
+	storage := open()
+
+	att, err := attest.New()
+	a.NoError(err)
+	key := att.MarshalPublicKey()
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	a.NoError(storage.StorePeer(&Peer{
+		Name: "erin", PublicKey: key, FirstSeen: start, LastSeen: start,
+	}))
+	var writes int
+	storage.Subscribe(PeersBucket, func(e Event) {
+		a.Equal(EventUpdated, e.Op)
+		writes++
+	})
+
+	persisted := func() time.Time {
+		var p pb.Peer
+		err := storage.engine.Query(func(b engine.Namespace) error {
+			data, err := b.Sub([]byte(engine.PeersNamespace)).
+				GetEncrypted(peerKey(key))
+			if err != nil {
+				return err
+			}
+			return proto.Unmarshal(data, &p)
+		})
+		a.NoError(err)
+		return p.LastSeen.AsTime()
+	}
+
+	steps := []struct {
+		name      string
+		at        time.Duration
+		lastSeen  time.Duration
+		persisted time.Duration
+		writes    int
+	}{
+		{"first sighting is written", 0, 0, 0, 1},
+		{"sighting within resolution is cached", 10 * time.Second,
+			10 * time.Second, 0, 1},
+		{"older sighting is ignored", 5 * time.Second,
+			10 * time.Second, 0, 1},
+		{"sighting past resolution is written", 70 * time.Second,
+			70 * time.Second, 70 * time.Second, 2},
+		{"later sighting is cached", 80 * time.Second,
+			80 * time.Second, 70 * time.Second, 2},
+	}
+	for _, step := range steps {
+		at := start.Add(time.Minute)
+		a.NoError(storage.MarkPeerSeen(key, at.Add(step.at)), step.name)
+		seen, err := storage.PeerLastSeen(key)
+		a.NoError(err)
+		a.True(seen.Equal(at.Add(step.lastSeen)), step.name)
+		a.True(persisted().Equal(at.Add(step.persisted)), step.name)
+		a.Equal(step.writes, writes, step.name)
+	}
+
+	// Unknown peers are ignored.
+	a.NoError(storage.MarkPeerSeen([]byte("unknown"), time.Time{}))
+	_, err = storage.PeerLastSeen([]byte("unknown"))
+	a.Error(err)
+
+	// Close writes the cached sighting.
+	a.NoError(storage.Close())
+	storage = open()
+	defer func() { a.NoError(storage.Close()) }()
+	found, err := storage.FindPeer(key)
+	a.NoError(err)
+	a.True(found.LastSeen.Equal(start.Add(time.Minute + 80*time.Second)))
+}
+
 func TestListPeers(t *testing.T) {
 	a := require.New(t)
 	storage, cleanup := newTestStorage(t)
@@ -243,6 +410,87 @@ func TestListPeersSkipsExpired(t *testing.T) {
 	a.Equal("recent-peer", peers[0].Name)
 }
 
+func TestInMemoryStorage(t *testing.T) {
+	a := require.New(t)
+
+	storage, err := OpenStorage(
+		WithInMemory(), WithExpiryDuration(time.Hour),
+	)
+	a.NoError(err)
+
+	pub, err := storage.PublicKey()
+	a.NoError(err)
+	again, err := storage.PublicKey()
+	a.NoError(err)
+	a.Equal(pub, again, "identity is stable for the storage's lifetime")
+
+	att, err := attest.New()
+	a.NoError(err)
+	a.NoError(storage.StorePeer(&Peer{
+		Name:      "old-peer",
+		PublicKey: att.MarshalPublicKey(),
+		FirstSeen: time.Now().Add(-48 * time.Hour),
+	}))
+
+	// Expired peers are removed after the lookup's transaction ends.
+	_, err = storage.FindPeer(att.MarshalPublicKey())
+	a.ErrorIs(err, ErrPeerExpired)
+	_, err = storage.FindPeer(att.MarshalPublicKey())
+	a.Error(err)
+	a.NotErrorIs(err, ErrPeerExpired, "peer was removed")
+	a.NoError(storage.Close())
+
+	other, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = other.Close() }()
+	otherPub, err := other.PublicKey()
+	a.NoError(err)
+	a.NotEqual(pub, otherPub, "each in-memory storage has its own identity")
+}
+
+func TestStorageWithStore(t *testing.T) {
+	a := require.New(t)
+	backend := store.NewMemory()
+	pass := func() ([]byte, error) { return []byte("pass"), nil }
+
+	storage, err := OpenStorage(WithStore(backend), WithPassphraseHandler(pass))
+	a.NoError(err)
+	pub, err := storage.PublicKey()
+	a.NoError(err)
+	addSearchFixtures(t, storage)
+
+	// The backend only sees ciphertext, and keeps the data for the next
+	// storage opened on it.
+	a.NoError(backend.View(func(root store.Bucket) error {
+		var walk func(b store.Bucket)
+		walk = func(b store.Bucket) {
+			for _, v := range b.Values() {
+				a.False(bytes.Contains(v, []byte("station")))
+			}
+			for _, name := range b.Buckets() {
+				walk(b.Bucket([]byte(name)))
+			}
+		}
+		walk(root)
+		return nil
+	}))
+	reopened, err := OpenStorage(
+		WithStore(backend), WithPassphraseHandler(pass),
+	)
+	a.NoError(err)
+	again, err := reopened.PublicKey()
+	a.NoError(err)
+	a.Equal(pub, again)
+	history, err := reopened.GetChatHistory("s1")
+	a.NoError(err)
+	a.Len(history, 2)
+	a.Len(searchTexts(t, reopened, "station"), 3)
+
+	_, err = OpenStorage(WithStore(backend), WithNoPassphrase())
+	a.Error(err)
+	a.NoError(reopened.Close())
+}
+
 func TestListPeersEmpty(t *testing.T) {
 	a := require.New(t)
 	storage, cleanup := newTestStorage(t)
@@ -401,6 +649,109 @@ func TestAddChatEntryToCreatedSession(t *testing.T) {
 	a.Equal([]byte("hello"), entries[0].Data)
 }
 
+func TestMessageJournal(t *testing.T) {
+	a := require.New(t)
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	a.ErrorIs(
+		storage.JournalMessage("nope", JournalEntry{ID: "m1"}),
+		ErrSessionNotFound,
+	)
+
+	att, err := attest.New()
+	a.NoError(err)
+	a.NoError(storage.StorePeer(&Peer{
+		Name:      "alice",
+		PublicKey: att.MarshalPublicKey(),
+		FirstSeen: time.Now(),
+	}))
+	a.NoError(storage.CreateSession("sess", att.MarshalPublicKey()))
+
+	now := time.Now()
+	for i, id := range []string{"m1", "m2", "m3"} {
+		a.NoError(storage.JournalMessage("sess", JournalEntry{
+			Time:     now.Add(time.Duration(i) * time.Second),
+			ID:       id,
+			Hash:     []byte(id),
+			Sequence: uint64(i + 1),
+			Route:    7,
+			State:    MessagePending,
+		}))
+	}
+	a.NoError(storage.JournalMessage("sess", JournalEntry{
+		Time: now.Add(time.Second), ID: "m2", Hash: []byte("m2"),
+		Sequence: 2, Route: 7, State: MessageSent,
+	}))
+	a.NoError(storage.AckMessage("sess", "m1"))
+	a.NoError(storage.AckMessage("sess", "unknown"))
+
+	entries, err := storage.UnconfirmedMessages("sess")
+	a.NoError(err)
+	a.Len(entries, 2)
+	a.Equal("m2", entries[0].ID)
+	a.Equal(MessageSent, entries[0].State)
+	a.Equal([]byte("m2"), entries[0].Hash)
+	a.EqualValues(2, entries[0].Sequence)
+	a.EqualValues(7, entries[0].Route)
+	a.True(now.Add(time.Second).Equal(entries[0].Time))
+	a.Equal("m3", entries[1].ID)
+	a.Equal(MessagePending, entries[1].State)
+
+	entries, err = storage.UnconfirmedMessages("other")
+	a.NoError(err)
+	a.Empty(entries)
+}
+
+func TestChatHistoryOrderedByClock(t *testing.T) {
+	a := require.New(t)
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	att, err := attest.New()
+	a.NoError(err)
+	a.NoError(storage.StorePeer(&Peer{
+		Name:      "alice",
+		PublicKey: att.MarshalPublicKey(),
+		FirstSeen: time.Now(),
+	}))
+	a.NoError(storage.CreateSession("clocked", att.MarshalPublicKey()))
+
+	// The peer's clock runs a minute ahead, so its timestamps alone would
+	// put the question after the local answer.
+	base := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	reading := func(t time.Time, logical uint64) uint64 {
+		return uint64(t.UnixMilli())<<16 | logical
+	}
+	question := base.Add(time.Minute)
+	entries := []struct {
+		ts     time.Time
+		text   string
+		clock  uint64
+		sender Sender
+	}{
+		{base.Add(time.Second), "answer", reading(question, 1), SenderLocal},
+		{question, "question", reading(question, 0), SenderPeer},
+		{base.Add(-time.Hour), "legacy", 0, SenderLocal},
+	}
+	for _, e := range entries {
+		a.NoError(storage.AddChatEntryWithClock(
+			"clocked", []byte(e.text), e.ts, e.clock, e.sender,
+		))
+	}
+
+	history, err := storage.GetChatHistory("clocked")
+	a.NoError(err)
+	var texts []string
+	for _, e := range history {
+		texts = append(texts, string(e.Data))
+	}
+	a.Equal([]string{"legacy", "question", "answer"}, texts)
+	a.Zero(history[0].Clock)
+	a.Equal(reading(question, 1), history[2].Clock)
+	a.True(history[2].Timestamp.Equal(base.Add(time.Second)))
+}
+
 // ---------------------------------------------------------------------------
 // Resumption token tests
 // ---------------------------------------------------------------------------
@@ -574,3 +925,2074 @@ func TestRemoveSessionToken_RejectsAlreadyRemovedToken(t *testing.T) {
 	err = storage.RemoveListItem("sess-used", ResumptionTokensKey, tok)
 	a.ErrorIs(err, ErrNotFound)
 }
+
+// ---------------------------------------------------------------------------
+// Session stats tests
+// ---------------------------------------------------------------------------
+
+func TestSessionStatsReporting(t *testing.T) {
+	a := require.New(t)
+	day := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	c := clock.NewFake(day)
+	storage, err := OpenStorage(WithInMemory(), WithClock(c))
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	alice, bob := []byte("alice"), []byte("bob")
+	record := func(
+		id string, peer []byte, end time.Time, bytes uint64, resumed bool,
+	) {
+		a.NoError(storage.RecordSessionStats(SessionStats{
+			Start:            end.Add(-time.Minute),
+			End:              end,
+			SessionID:        id,
+			PeerKey:          peer,
+			MessagesSent:     1,
+			MessagesReceived: 2,
+			BytesSent:        bytes,
+			BytesReceived:    bytes,
+			Resumed:          resumed,
+		}))
+	}
+	record("s1", alice, day.Add(-25*time.Hour), 100, false)
+	record("s1", alice, day.Add(-time.Hour), 50, true)
+	record("s2", bob, day.Add(-2*time.Hour), 500, false)
+
+	u, err := storage.SessionUsage("s1")
+	a.NoError(err)
+	a.Equal(Usage{
+		MessagesSent:     2,
+		MessagesReceived: 4,
+		BytesSent:        150,
+		BytesReceived:    150,
+		Duration:         2 * time.Minute,
+		Connections:      2,
+		Resumptions:      1,
+	}, u)
+
+	top, err := storage.TopPeersByTraffic(time.Time{}, 0)
+	a.NoError(err)
+	a.Len(top, 2)
+	a.Equal(bob, top[0].PublicKey)
+	a.EqualValues(1000, top[0].Traffic())
+	a.Equal(alice, top[1].PublicKey)
+
+	top, err = storage.TopPeersByTraffic(day.Add(-3*time.Hour), 1)
+	a.NoError(err)
+	a.Len(top, 1)
+	a.Equal(bob, top[0].PublicKey)
+
+	days, err := storage.UsageByDay(time.Time{})
+	a.NoError(err)
+	a.Len(days, 2)
+	a.Equal(day.Add(-24*time.Hour).Truncate(24*time.Hour), days[0].Day)
+	a.Equal(1, days[0].Connections)
+	a.Equal(day.Truncate(24*time.Hour), days[1].Day)
+	a.Equal(2, days[1].Connections)
+}
+
+func TestSessionStatsPruning(t *testing.T) {
+	a := require.New(t)
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	storage, err := OpenStorage(
+		WithInMemory(), WithClock(c), WithStatsRetention(48*time.Hour),
+	)
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	for _, age := range []time.Duration{72, 36, 12} {
+		end := now.Add(-age * time.Hour)
+		a.NoError(storage.RecordSessionStats(SessionStats{
+			Start: end, End: end, SessionID: "s",
+		}))
+	}
+
+	// The 72h-old record was pruned by the later writes.
+	u, err := storage.SessionUsage("s")
+	a.NoError(err)
+	a.Equal(2, u.Connections)
+
+	n, err := storage.PruneSessionStats(now.Add(-24 * time.Hour))
+	a.NoError(err)
+	a.Equal(1, n)
+	u, err = storage.SessionUsage("s")
+	a.NoError(err)
+	a.Equal(1, u.Connections)
+}
+
+func TestSessionTimeline(t *testing.T) {
+	a := require.New(t)
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	storage, err := OpenStorage(
+		WithInMemory(), WithClock(c), WithTimelineRetention(48*time.Hour),
+	)
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	at := func(h int) time.Time { return now.Add(time.Duration(h) * time.Hour) }
+	for _, e := range []TimelineEvent{
+		{Time: at(-72), Kind: TimelineEstablished},
+		{Time: at(-36), Kind: TimelineEstablished},
+		{Time: at(-30), Kind: TimelineCheckpoint, SendSequence: 10},
+		{Time: at(-24), Kind: TimelineError, Detail: "broken pipe"},
+		{Time: at(-12), Kind: TimelineResumed, SendSequence: 12},
+		{Time: at(-6), Kind: TimelineClosed, ReceiveSequence: 7},
+		{Time: at(-6), SessionID: "other", Kind: TimelineEstablished},
+	} {
+		if e.SessionID == "" {
+			e.SessionID = "s"
+		}
+		a.NoError(storage.RecordTimelineEvent(e))
+	}
+
+	// The 72h-old event was pruned by the later writes.
+	events, err := storage.SessionTimeline("s")
+	a.NoError(err)
+	a.Len(events, 5)
+	a.Equal(TimelineEstablished, events[0].Kind)
+	a.Equal(TimelineClosed, events[4].Kind)
+
+	r, err := storage.ReplaySession("s", at(-20))
+	a.NoError(err)
+	a.Len(r.Events, 3)
+	a.False(r.Connected)
+	a.Equal("broken pipe", r.LastError)
+	a.Equal(1, r.Errors)
+	a.EqualValues(10, r.SendSequence)
+	a.True(r.Established.Equal(at(-36)))
+
+	r, err = storage.ReplaySession("s", time.Time{})
+	a.NoError(err)
+	a.Equal(1, r.Resumptions)
+	a.True(r.Resumed.Equal(at(-12)))
+	a.EqualValues(12, r.SendSequence)
+	a.EqualValues(7, r.ReceiveSequence)
+	a.Equal(TimelineClosed, r.LastEvent.Kind)
+
+	n, err := storage.PruneTimeline(at(-24))
+	a.NoError(err)
+	a.Equal(2, n)
+	events, err = storage.SessionTimeline("s")
+	a.NoError(err)
+	a.Len(events, 3)
+}
+
+// ---------------------------------------------------------------------------
+// Search tests
+// ---------------------------------------------------------------------------
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"words", "Hello, hello WORLD!", []string{"hello", "world"}},
+		{"short words", "a b cd 7 42", []string{"cd", "42"}},
+		{
+			"persian", "کتاب\u200cها را خواندم",
+			[]string{"کتابها", "را", "خواندم"},
+		},
+		{"arabic forms", "كتاب كبير", []string{"کتاب", "کبیر"}},
+		{"diacritics", "كِتَاب", []string{"کتاب"}},
+		{"digits", "۱۲۳ ١٢٣", []string{"123"}},
+		{"cjk", "東京タワー", []string{"東", "京", "タ", "ワ"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			a.Equal(tc.want, tokenize(tc.text))
+		})
+	}
+}
+
+func addSearchFixtures(t *testing.T, storage *Storage) time.Time {
+	t.Helper()
+	a := require.New(t)
+	att, err := attest.New()
+	a.NoError(err)
+	a.NoError(storage.StorePeer(&Peer{
+		Name:      "alice",
+		PublicKey: att.MarshalPublicKey(),
+		FirstSeen: time.Now(),
+	}))
+
+	base := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	for i, e := range []struct{ session, text string }{
+		{"s1", "Meet me at the station"},
+		{"s1", "which station?"},
+		{"s2", "The STATION on Main street"},
+		{"s2", "see you there"},
+	} {
+		if _, err := storage.GetPeer(e.session); err != nil {
+			a.NoError(storage.CreateSession(e.session, att.MarshalPublicKey()))
+		}
+		a.NoError(storage.AddChatEntry(
+			e.session, []byte(e.text),
+			base.Add(time.Duration(i)*time.Minute), SenderPeer,
+		))
+	}
+	return base
+}
+
+func searchTexts(t *testing.T, storage *Storage, query string) []string {
+	t.Helper()
+	a := require.New(t)
+	results, err := storage.SearchChatHistory(query)
+	a.NoError(err)
+	var texts []string
+	for _, r := range results {
+		texts = append(texts, r.SessionID+": "+string(r.Data))
+	}
+	return texts
+}
+
+func hasSearchIndex(t *testing.T, storage *Storage) bool {
+	t.Helper()
+	a := require.New(t)
+	var ok bool
+	a.NoError(storage.engine.Query(func(b engine.Namespace) error {
+		_, ok = loadSearchIndex(b)
+		return nil
+	}))
+	return ok
+}
+
+func TestSearchChatHistory(t *testing.T) {
+	for _, indexed := range []bool{true, false} {
+		t.Run(fmt.Sprintf("indexed=%t", indexed), func(t *testing.T) {
+			a := require.New(t)
+			storage, err := OpenStorage(
+				WithInMemory(), WithSearchIndex(indexed),
+			)
+			a.NoError(err)
+			defer func() { _ = storage.Close() }()
+			addSearchFixtures(t, storage)
+			a.Equal(indexed, hasSearchIndex(t, storage))
+
+			a.Equal([]string{
+				"s2: The STATION on Main street",
+				"s1: which station?",
+				"s1: Meet me at the station",
+			}, searchTexts(t, storage, "station"))
+			a.Equal(
+				[]string{"s2: The STATION on Main street"},
+				searchTexts(t, storage, "main Station"),
+			)
+			a.Empty(searchTexts(t, storage, "station there"))
+			a.Empty(searchTexts(t, storage, "?!"))
+
+			a.NoError(storage.DeleteSession("s2"))
+			a.Equal([]string{
+				"s1: which station?",
+				"s1: Meet me at the station",
+			}, searchTexts(t, storage, "station"))
+		})
+	}
+}
+
+func TestSearchIndexLifecycle(t *testing.T) {
+	a := require.New(t)
+	backend, err := engine.NewBackendStore(store.NewMemory(), nil)
+	a.NoError(err)
+	defer func() { _ = backend.Close() }()
+
+	private, err := OpenStorage(WithBackend(backend), WithSearchIndex(false))
+	a.NoError(err)
+	addSearchFixtures(t, private)
+	a.False(hasSearchIndex(t, private))
+	a.ErrorIs(private.RebuildSearchIndex(), ErrSearchDisabled)
+
+	// Enabling the index builds it from the existing history.
+	storage, err := OpenStorage(WithBackend(backend))
+	a.NoError(err)
+	a.NoError(storage.AddChatEntry(
+		"s1", []byte("station again"), time.Now(), SenderLocal,
+	))
+	a.True(hasSearchIndex(t, storage))
+	a.Len(searchTexts(t, storage, "station"), 4)
+
+	a.NoError(storage.DropSearchIndex())
+	a.False(hasSearchIndex(t, storage))
+	a.Len(searchTexts(t, storage, "station"), 4)
+	a.NoError(storage.RebuildSearchIndex())
+	a.True(hasSearchIndex(t, storage))
+	a.Len(searchTexts(t, storage, "station"), 4)
+
+	// A storage with the index disabled deletes it on its next write.
+	a.NoError(private.AddChatEntry(
+		"s2", []byte("bye"), time.Now(), SenderLocal,
+	))
+	a.False(hasSearchIndex(t, storage))
+}
+
+// ---------------------------------------------------------------------------
+// Conversation tests
+// ---------------------------------------------------------------------------
+
+func TestConversations(t *testing.T) {
+	a := require.New(t)
+	c := clock.NewFake(time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC))
+	storage, err := OpenStorage(WithInMemory(), WithClock(c))
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	var keys [][]byte
+	for _, name := range []string{"alice", "bob"} {
+		att, err := attest.New()
+		a.NoError(err)
+		a.NoError(storage.StorePeer(&Peer{
+			Name: name, PublicKey: att.MarshalPublicKey(), FirstSeen: c.Now(),
+		}))
+		keys = append(keys, att.MarshalPublicKey())
+	}
+	alice, bob := keys[0], keys[1]
+
+	for i, sid := range []string{"a1", "b1", "a2"} {
+		key := alice
+		if sid[0] == 'b' {
+			key = bob
+		}
+		c.Advance(time.Minute)
+		a.NoError(storage.CreateSession(sid, key))
+		a.NoError(storage.AddChatEntry(
+			sid, []byte(sid), c.Now(), Sender(i%2),
+		))
+	}
+
+	conv, err := storage.FindConversationByPeer(alice)
+	a.NoError(err)
+	a.Equal([]string{"a1", "a2"}, conv.Sessions)
+	a.Equal(alice, conv.PeerKey)
+	a.True(conv.Updated.After(conv.Created))
+	m, err := storage.GetMeta("a2", ConversationKey)
+	a.NoError(err)
+	a.Equal(conv.ID, string(m.Value()))
+
+	history, err := storage.GetConversationHistory(conv.ID)
+	a.NoError(err)
+	a.Len(history, 2)
+	a.Equal("a1", history[0].SessionID)
+	a.Equal([]byte("a2"), history[1].Data)
+
+	convs, err := storage.ListConversations()
+	a.NoError(err)
+	a.Len(convs, 2)
+	a.Equal(conv.ID, convs[0].ID, "most recently updated first")
+
+	a.NoError(storage.DeleteSession("a1"))
+	conv, err = storage.GetConversation(conv.ID)
+	a.NoError(err)
+	a.Equal([]string{"a2"}, conv.Sessions)
+	a.NoError(storage.DeleteSession("a2"))
+	_, err = storage.GetConversation(conv.ID)
+	a.ErrorIs(err, ErrNotFound)
+}
+
+func TestConversationAdoptsExistingSessions(t *testing.T) {
+	a := require.New(t)
+	c := clock.NewFake(time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC))
+	storage, err := OpenStorage(WithInMemory(), WithClock(c))
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	att, err := attest.New()
+	a.NoError(err)
+	key := att.MarshalPublicKey()
+	a.NoError(storage.StorePeer(&Peer{
+		Name: "alice", PublicKey: key, FirstSeen: c.Now(),
+	}))
+	for _, sid := range []string{"old-2", "old-1"} {
+		c.Advance(time.Minute)
+		a.NoError(storage.CreateSession(sid, key))
+	}
+
+	// Simulate sessions stored before conversations existed.
+	a.NoError(storage.engine.Command(func(b engine.Namespace) error {
+		for _, sid := range []string{"old-1", "old-2"} {
+			err := sessionMeta(b, sid).Delete([]byte(ConversationKey))
+			if err != nil {
+				return err
+			}
+		}
+		return b.Sub([]byte(engine.ConversationsNamespace)).
+			Delete([]byte(conversationID(key)))
+	}))
+
+	c.Advance(time.Minute)
+	a.NoError(storage.CreateSession("new", key))
+	conv, err := storage.FindConversationByPeer(key)
+	a.NoError(err)
+	a.Equal([]string{"old-2", "old-1", "new"}, conv.Sessions)
+}
+
+func TestConversationKeys(t *testing.T) {
+	a := require.New(t)
+	storage, err := OpenStorage(WithInMemory(), WithConversationKeys(true))
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	var keys [][]byte
+	for _, name := range []string{"alice", "bob"} {
+		att, err := attest.New()
+		a.NoError(err)
+		a.NoError(storage.StorePeer(&Peer{
+			Name: name, PublicKey: att.MarshalPublicKey(), FirstSeen: time.Now(),
+		}))
+		keys = append(keys, att.MarshalPublicKey())
+	}
+	alice, bob := keys[0], keys[1]
+	a.NoError(storage.CreateSession("a1", alice))
+	a.NoError(storage.CreateSession("b1", bob))
+	for _, sid := range []string{"a1", "b1"} {
+		a.NoError(storage.AddChatEntry(
+			sid, []byte("secret plans"), time.Now(), SenderLocal,
+		))
+	}
+
+	// Entries are sealed under the storage encryption.
+	err = storage.engine.Query(func(b engine.Namespace) error {
+		for _, value := range sessionChat(b, "a1").IterateEncrypted() {
+			a.True(bytes.HasPrefix(value, sealedValueMagic))
+			a.NotContains(string(value), "secret plans")
+		}
+		return nil
+	})
+	a.NoError(err)
+	history, err := storage.GetChatHistory("a1")
+	a.NoError(err)
+	a.Len(history, 1)
+	a.Equal([]byte("secret plans"), history[0].Data)
+	results, err := storage.SearchChatHistory("plans")
+	a.NoError(err)
+	a.Len(results, 2)
+
+	var events []Event
+	cancel := storage.Subscribe(ChatBucket, func(e Event) {
+		events = append(events, e)
+	})
+	a.NoError(storage.ShredConversation(alice))
+	cancel()
+	a.Equal([]Event{{
+		Class: ChatBucket, Op: EventDeleted, SessionID: "a1",
+	}}, events)
+
+	history, err = storage.GetChatHistory("a1")
+	a.NoError(err)
+	a.Empty(history)
+	results, err = storage.SearchChatHistory("plans")
+	a.NoError(err)
+	a.Len(results, 1)
+	a.Equal("b1", results[0].SessionID)
+	history, err = storage.GetChatHistory("b1")
+	a.NoError(err)
+	a.Len(history, 1)
+
+	// Later entries get a new key.
+	a.NoError(storage.AddChatEntry(
+		"a1", []byte("fresh start"), time.Now(), SenderPeer,
+	))
+	conv, err := storage.FindConversationByPeer(alice)
+	a.NoError(err)
+	entries, err := storage.GetConversationHistory(conv.ID)
+	a.NoError(err)
+	a.Len(entries, 1)
+	a.Equal([]byte("fresh start"), entries[0].Data)
+
+	a.NoError(storage.ShredConversation([]byte("unknown")))
+	a.ErrorIs(storage.ShredConversation(nil), ErrInvalidPublicKey)
+}
+
+// ---------------------------------------------------------------------------
+// Maintenance tests
+// ---------------------------------------------------------------------------
+
+func TestPruneExpiredPeers(t *testing.T) {
+	a := require.New(t)
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	storage, err := OpenStorage(
+		WithInMemory(), WithClock(c), WithExpiryDuration(24*time.Hour),
+	)
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	for _, age := range []time.Duration{48, 36, 1} {
+		att, err := attest.New()
+		a.NoError(err)
+		a.NoError(storage.StorePeer(&Peer{
+			Name:      "peer",
+			PublicKey: att.MarshalPublicKey(),
+			FirstSeen: now.Add(-age * time.Hour),
+		}))
+	}
+
+	n, err := storage.PruneExpiredPeers()
+	a.NoError(err)
+	a.Equal(2, n)
+	n, err = storage.PruneExpiredPeers()
+	a.NoError(err)
+	a.Zero(n)
+	peers, err := storage.ListPeers()
+	a.NoError(err)
+	a.Len(peers, 1)
+}
+
+func TestPruneSessionsAndChatHistory(t *testing.T) {
+	a := require.New(t)
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now.Add(-72 * time.Hour))
+	storage, err := OpenStorage(WithInMemory(), WithClock(c))
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	att, err := attest.New()
+	a.NoError(err)
+	a.NoError(storage.StorePeer(&Peer{
+		Name: "alice", PublicKey: att.MarshalPublicKey(), FirstSeen: c.Now(),
+	}))
+
+	// "idle" has not been used since it was established, while "active"
+	// was established at the same time but has a recent message.
+	for _, sid := range []string{"idle", "active"} {
+		a.NoError(storage.CreateSession(sid, att.MarshalPublicKey()))
+		a.NoError(storage.AddChatEntry(
+			sid, []byte("old station"), c.Now(), SenderLocal,
+		))
+	}
+	c.Set(now)
+	a.NoError(storage.AddChatEntry(
+		"active", []byte("new station"), c.Now(), SenderPeer,
+	))
+	a.Len(searchTexts(t, storage, "station"), 3)
+
+	n, err := storage.PruneChatHistory(now.Add(-24 * time.Hour))
+	a.NoError(err)
+	a.Equal(2, n)
+	a.True(hasSearchIndex(t, storage), "index is rebuilt")
+	a.Equal([]string{"active: new station"}, searchTexts(t, storage, "station"))
+
+	n, err = storage.PruneSessions(now.Add(-24 * time.Hour))
+	a.NoError(err)
+	a.Equal(1, n)
+	sessions, err := storage.ListSessions()
+	a.NoError(err)
+	a.Equal([]string{"active"}, sessions)
+	conv, err := storage.FindConversationByPeer(att.MarshalPublicKey())
+	a.NoError(err)
+	a.Equal([]string{"active"}, conv.Sessions)
+}
+
+func TestPruneResumptionTokens(t *testing.T) {
+	a := require.New(t)
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now.Add(-48 * time.Hour))
+	storage, err := OpenStorage(WithInMemory(), WithClock(c))
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	att, err := attest.New()
+	a.NoError(err)
+	a.NoError(storage.StorePeer(&Peer{
+		Name: "alice", PublicKey: att.MarshalPublicKey(), FirstSeen: c.Now(),
+	}))
+	tokens := [][]byte{makeToken(1, 32)}
+	for _, sid := range []string{"old", "new"} {
+		a.NoError(storage.CreateSession(sid, att.MarshalPublicKey()))
+		a.NoError(storage.SetMeta(
+			sid, NewByteSlicesMeta(ResumptionTokensKey, tokens),
+		))
+		c.Set(now)
+	}
+
+	n, err := storage.PruneResumptionTokens(now.Add(-time.Hour))
+	a.NoError(err)
+	a.Equal(1, n)
+	m, err := storage.GetMeta("old", ResumptionTokensKey)
+	a.NoError(err)
+	a.Empty(m.Value())
+	m, err = storage.GetMeta("new", ResumptionTokensKey)
+	a.NoError(err)
+	a.NotEmpty(m.Value())
+}
+
+// ---------------------------------------------------------------------------
+// Blocklist tests
+// ---------------------------------------------------------------------------
+
+func TestBlockPeer(t *testing.T) {
+	a := require.New(t)
+	c := clock.NewFake(time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC))
+	storage, err := OpenStorage(WithInMemory(), WithClock(c))
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	var notified [][]byte
+	cancel := storage.OnBlock(func(pub []byte) {
+		notified = append(notified, pub)
+	})
+	alice, bob := []byte("alice-key"), []byte("bob-key")
+
+	blocked, err := storage.IsBlocked(alice)
+	a.NoError(err)
+	a.False(blocked)
+
+	a.NoError(storage.BlockPeer(alice))
+	c.Advance(time.Minute)
+	a.NoError(storage.BlockPeer(bob))
+	c.Advance(time.Minute)
+	a.NoError(storage.BlockPeer(alice), "blocking twice is not an error")
+	a.Equal([][]byte{alice, bob, alice}, notified)
+	a.ErrorIs(storage.BlockPeer(nil), ErrInvalidPublicKey)
+
+	list, err := storage.ListBlockedPeers()
+	a.NoError(err)
+	a.Len(list, 2)
+	a.Equal(bob, list[0].PublicKey)
+	a.Equal(alice, list[1].PublicKey)
+	a.Equal(c.Now().Add(-2*time.Minute), list[1].Blocked.UTC())
+
+	a.NoError(storage.UnblockPeer(alice))
+	a.NoError(storage.UnblockPeer(alice))
+	blocked, err = storage.IsBlocked(alice)
+	a.NoError(err)
+	a.False(blocked)
+	blocked, err = storage.IsBlocked(bob)
+	a.NoError(err)
+	a.True(blocked)
+
+	cancel()
+	a.NoError(storage.BlockPeer(alice))
+	a.Len(notified, 3, "cancelled callbacks are not called")
+}
+
+// ---------------------------------------------------------------------------
+// Role tests
+// ---------------------------------------------------------------------------
+
+func TestPeerRole(t *testing.T) {
+	a := require.New(t)
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+	alice := []byte("alice-key")
+
+	role, err := storage.PeerRole(alice)
+	a.NoError(err)
+	a.Empty(role)
+
+	a.NoError(storage.SetPeerRole(alice, "admin"))
+	a.NoError(storage.SetPeerRole(alice, "reader"))
+	role, err = storage.PeerRole(alice)
+	a.NoError(err)
+	a.Equal("reader", role)
+
+	a.NoError(storage.SetPeerRole(alice, ""))
+	a.NoError(storage.SetPeerRole(alice, ""), "removing twice")
+	role, err = storage.PeerRole(alice)
+	a.NoError(err)
+	a.Empty(role)
+	a.ErrorIs(storage.SetPeerRole(nil, "admin"), ErrInvalidPublicKey)
+}
+
+// ---------------------------------------------------------------------------
+// Quota tests
+// ---------------------------------------------------------------------------
+
+func TestChatQuota(t *testing.T) {
+	type add struct{ session, text string }
+	tests := []struct {
+		name      string
+		session   ChatQuota
+		peer      ChatQuota
+		adds      []add
+		remaining map[string][]string
+		evictions []Eviction
+	}{
+		{
+			name:    "session messages",
+			session: ChatQuota{Messages: 2},
+			adds: []add{
+				{"a1", "one"}, {"a1", "two"}, {"a1", "three"}, {"a2", "four"},
+			},
+			remaining: map[string][]string{
+				"a1": {"two", "three"}, "a2": {"four"},
+			},
+			evictions: []Eviction{{SessionID: "a1", Messages: 1, Bytes: 16}},
+		},
+		{
+			name:    "session bytes",
+			session: ChatQuota{Bytes: 30},
+			adds:    []add{{"a1", "one"}, {"a1", "two"}, {"a1", "three"}},
+			remaining: map[string][]string{
+				"a1": {"three"},
+			},
+			evictions: []Eviction{{SessionID: "a1", Messages: 2, Bytes: 32}},
+		},
+		{
+			name:    "oversized entry is kept",
+			session: ChatQuota{Bytes: 10},
+			adds:    []add{{"a1", "one"}, {"a1", "too long to fit"}},
+			remaining: map[string][]string{
+				"a1": {"too long to fit"},
+			},
+			evictions: []Eviction{{SessionID: "a1", Messages: 1, Bytes: 16}},
+		},
+		{
+			name: "peer messages",
+			peer: ChatQuota{Messages: 3},
+			adds: []add{
+				{"a1", "one"}, {"a1", "two"}, {"a2", "three"}, {"a2", "four"},
+				{"b1", "five"},
+			},
+			remaining: map[string][]string{
+				"a1": {"two"}, "a2": {"three", "four"}, "b1": {"five"},
+			},
+			evictions: []Eviction{
+				{SessionID: "a1", Messages: 1, Bytes: 16, Peer: true},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			start := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+			c := clock.NewFake(start)
+			var evictions []Eviction
+			storage, err := OpenStorage(
+				WithInMemory(),
+				WithClock(c),
+				WithSessionChatQuota(tc.session),
+				WithPeerChatQuota(tc.peer),
+				WithEvictionHandler(func(e Eviction) {
+					evictions = append(evictions, e)
+				}),
+			)
+			a.NoError(err)
+			defer func() { _ = storage.Close() }()
+
+			keys := make(map[byte][]byte)
+			for _, name := range []byte{'a', 'b'} {
+				att, err := attest.New()
+				a.NoError(err)
+				a.NoError(storage.StorePeer(&Peer{
+					Name:      string(name),
+					PublicKey: att.MarshalPublicKey(),
+					FirstSeen: c.Now(),
+				}))
+				keys[name] = att.MarshalPublicKey()
+			}
+			for _, e := range tc.adds {
+				if _, err := storage.GetPeer(e.session); err != nil {
+					key := keys[e.session[0]]
+					a.NoError(storage.CreateSession(e.session, key))
+				}
+				c.Advance(time.Second)
+				a.NoError(storage.AddChatEntry(
+					e.session, []byte(e.text), c.Now(), SenderPeer,
+				))
+			}
+
+			for sid, texts := range tc.remaining {
+				history, err := storage.GetChatHistory(sid)
+				a.NoError(err)
+				var got []string
+				for _, entry := range history {
+					got = append(got, string(entry.Data))
+				}
+				a.Equal(texts, got, sid)
+			}
+			for i := range evictions {
+				a.False(evictions[i].Until.IsZero())
+				evictions[i].Until = time.Time{}
+			}
+			// Evictions are reported per write; merge them per session.
+			merged := make(map[string]*Eviction)
+			var order []string
+			for _, e := range evictions {
+				m, ok := merged[e.SessionID]
+				if !ok {
+					m = &Eviction{SessionID: e.SessionID, Peer: e.Peer}
+					merged[e.SessionID] = m
+					order = append(order, e.SessionID)
+				}
+				m.Messages += e.Messages
+				m.Bytes += e.Bytes
+			}
+			var got []Eviction
+			for _, sid := range order {
+				got = append(got, *merged[sid])
+			}
+			a.Equal(tc.evictions, got)
+
+			// Evicted entries are dropped from the search index too.
+			for _, e := range tc.adds {
+				var want []string
+				if slices.Contains(tc.remaining[e.session], e.text) {
+					want = []string{e.session + ": " + e.text}
+				}
+				a.Equal(want, searchTexts(t, storage, e.text))
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Lock tests
+// ---------------------------------------------------------------------------
+
+func TestStorageLock(t *testing.T) {
+	a := require.New(t)
+	locks := 0
+	backend, err := engine.NewBoltDB(
+		filepath.Join(t.TempDir(), "db"), []byte("secret"),
+	)
+	a.NoError(err)
+	storage, err := OpenStorage(
+		WithBackend(backend), WithLockHandler(func() { locks++ }),
+	)
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	_, err = storage.Attester()
+	a.NoError(err)
+	a.False(storage.Locked())
+
+	a.NoError(storage.Lock())
+	a.NoError(storage.Lock())
+	a.True(storage.Locked())
+	a.Equal(1, locks, "handler runs once per lock")
+	_, err = storage.Attester()
+	a.ErrorIs(err, ErrStorageLocked)
+	_, err = storage.ListPeers()
+	a.ErrorIs(err, ErrStorageLocked)
+
+	a.Error(storage.Unlock([]byte("wrong")))
+	a.True(storage.Locked())
+	a.NoError(storage.Unlock([]byte("secret")))
+	a.False(storage.Locked())
+	_, err = storage.Attester()
+	a.NoError(err)
+
+	memory, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = memory.Close() }()
+	a.ErrorIs(memory.Lock(), ErrLockUnsupported)
+	a.False(memory.Locked())
+}
+
+func TestStorageAutoLock(t *testing.T) {
+	a := require.New(t)
+	c := clock.NewFake(time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC))
+	backend, err := engine.NewBoltDB(filepath.Join(t.TempDir(), "db"), []byte(""))
+	a.NoError(err)
+	storage, err := OpenStorage(
+		WithBackend(backend), WithClock(c), WithAutoLock(10*time.Minute),
+	)
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	c.Advance(6 * time.Minute)
+	storage.Touch()
+	c.Advance(6 * time.Minute)
+	storage.lockIfIdle()
+	a.False(storage.Locked(), "touched 6 minutes ago")
+
+	c.Advance(4 * time.Minute)
+	storage.lockIfIdle()
+	a.True(storage.Locked())
+
+	a.NoError(storage.Unlock(nil))
+	c.Advance(9 * time.Minute)
+	storage.lockIfIdle()
+	a.False(storage.Locked(), "unlocking counts as activity")
+}
+
+// ---------------------------------------------------------------------------
+// Identity tests
+// ---------------------------------------------------------------------------
+
+func TestIdentityBootstrap(t *testing.T) {
+	a := require.New(t)
+	path := filepath.Join(t.TempDir(), "db")
+	var minted [][]byte
+	open := func(opts ...StorageOption) *Storage {
+		s, err := OpenStorage(append([]StorageOption{
+			WithDBPath(path),
+			WithNoPassphrase(),
+			WithIdentityHandler(func(pub []byte) {
+				minted = append(minted, pub)
+			}),
+		}, opts...)...)
+		a.NoError(err)
+		return s
+	}
+
+	s := open(WithRequireExistingIdentity())
+	_, err := s.Attester()
+	a.ErrorIs(err, ErrIdentityNotFound)
+	_, err = s.LoadIdentity()
+	a.ErrorIs(err, ErrIdentityNotFound)
+	a.Empty(minted)
+
+	created, err := s.CreateIdentity()
+	a.NoError(err)
+	a.Len(minted, 1)
+	a.Equal(created.MarshalPublicKey(), minted[0])
+	_, err = s.CreateIdentity()
+	a.ErrorIs(err, ErrIdentityExists)
+	a.NoError(s.Close())
+
+	s = open(WithRequireExistingIdentity())
+	loaded, err := s.Attester()
+	a.NoError(err)
+	a.Equal(created.MarshalPublicKey(), loaded.MarshalPublicKey())
+	a.NoError(s.Close())
+	a.Len(minted, 1, "no identity minted on load")
+}
+
+func TestAttesterCreatesIdentity(t *testing.T) {
+	a := require.New(t)
+	var minted int
+	s, err := OpenStorage(
+		WithInMemory(),
+		WithIdentityHandler(func([]byte) { minted++ }),
+	)
+	a.NoError(err)
+	defer s.Close()
+
+	first, err := s.Attester()
+	a.NoError(err)
+	second, err := s.Attester()
+	a.NoError(err)
+	a.Equal(first.MarshalPublicKey(), second.MarshalPublicKey())
+	a.Equal(1, minted)
+}
+
+// ---------------------------------------------------------------------------
+// Subscription tests
+// ---------------------------------------------------------------------------
+
+func TestSubscribe(t *testing.T) {
+	a := require.New(t)
+	c := clock.NewFake(time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC))
+	storage, err := OpenStorage(
+		WithInMemory(), WithClock(c), WithExpiryDuration(24*time.Hour),
+		WithSessionChatQuota(ChatQuota{Messages: 1}),
+	)
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	events := make(map[BucketClass][]Event)
+	for _, class := range []BucketClass{
+		ChatBucket, PeersBucket, SessionsBucket,
+	} {
+		storage.Subscribe(class, func(e Event) {
+			a.Equal(class, e.Class)
+			events[class] = append(events[class], e)
+		})
+	}
+	cancelled := storage.Subscribe(PeersBucket, func(Event) {
+		a.Fail("cancelled subscriber called")
+	})
+	cancelled()
+
+	att, err := attest.New()
+	a.NoError(err)
+	key := att.MarshalPublicKey()
+	a.NoError(storage.StorePeer(&Peer{Name: "alice", PublicKey: key}))
+	a.NoError(storage.StorePeer(&Peer{Name: "alicia", PublicKey: key}))
+	a.NoError(storage.UpdatePeerLastSeen(key, time.Time{}))
+
+	a.NoError(storage.CreateSession("s1", key))
+	a.NoError(storage.SetSessionName("s1", "work"))
+	a.NoError(storage.AddChatEntry("s1", []byte("hi"), c.Now(), SenderPeer))
+	c.Advance(time.Second)
+	a.NoError(storage.AddChatEntry("s1", []byte("yo"), c.Now(), SenderLocal))
+	_, err = storage.PruneChatHistory(c.Now().Add(time.Hour))
+	a.NoError(err)
+	a.NoError(storage.DeleteSession("s1"))
+
+	a.NoError(storage.CreateSession("s2", key))
+	_, err = storage.PruneSessions(c.Now().Add(time.Hour))
+	a.NoError(err)
+	a.NoError(storage.DeletePeer(key))
+
+	a.Equal([]Event{
+		{Class: PeersBucket, Op: EventAdded, PublicKey: key},
+		{Class: PeersBucket, Op: EventUpdated, PublicKey: key},
+		{Class: PeersBucket, Op: EventUpdated, PublicKey: key},
+		{Class: PeersBucket, Op: EventDeleted, PublicKey: key},
+	}, events[PeersBucket])
+	a.Equal([]Event{
+		{Class: SessionsBucket, Op: EventAdded, SessionID: "s1"},
+		{Class: SessionsBucket, Op: EventUpdated, SessionID: "s1"},
+		{Class: SessionsBucket, Op: EventDeleted, SessionID: "s1"},
+		{Class: SessionsBucket, Op: EventAdded, SessionID: "s2"},
+		{Class: SessionsBucket, Op: EventDeleted, SessionID: "s2"},
+	}, events[SessionsBucket])
+
+	chat := events[ChatBucket]
+	a.Len(chat, 4)
+	a.Equal(EventAdded, chat[0].Op)
+	a.Equal("hi", string(chat[0].Entry.Data))
+	a.Equal(SenderPeer, chat[0].Entry.Sender)
+	a.Equal(EventAdded, chat[1].Op)
+	a.Equal("yo", string(chat[1].Entry.Data))
+	// The quota evicted the first entry, and pruning the second.
+	for _, e := range chat[2:] {
+		a.Equal(EventDeleted, e.Op)
+		a.Equal("s1", e.SessionID)
+		a.Nil(e.Entry)
+	}
+}
+
+func TestSubscribeExpiredPeers(t *testing.T) {
+	a := require.New(t)
+	c := clock.NewFake(time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC))
+	storage, err := OpenStorage(
+		WithInMemory(), WithClock(c), WithExpiryDuration(time.Hour),
+	)
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	var deleted [][]byte
+	storage.Subscribe(PeersBucket, func(e Event) {
+		if e.Op == EventDeleted {
+			deleted = append(deleted, e.PublicKey)
+		}
+	})
+	var keys [][]byte
+	for range 3 {
+		att, err := attest.New()
+		a.NoError(err)
+		keys = append(keys, att.MarshalPublicKey())
+		a.NoError(storage.StorePeer(&Peer{PublicKey: keys[len(keys)-1]}))
+	}
+	c.Advance(2 * time.Hour)
+
+	_, err = storage.FindPeer(keys[0])
+	a.ErrorIs(err, ErrPeerExpired)
+	a.Equal([][]byte{keys[0]}, deleted)
+	n, err := storage.PruneExpiredPeers()
+	a.NoError(err)
+	a.Equal(2, n)
+	a.ElementsMatch(keys, deleted)
+}
+
+// ---------------------------------------------------------------------------
+// Outbox tests
+// ---------------------------------------------------------------------------
+
+func TestOutbox(t *testing.T) {
+	a := require.New(t)
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	err := storage.BufferMessage("missing", BufferedMessage{Number: 1}, 2)
+	a.ErrorIs(err, ErrSessionNotFound)
+	msgs, err := storage.TakeBufferedMessages("missing", 0)
+	a.NoError(err)
+	a.Empty(msgs)
+
+	att, err := attest.New()
+	a.NoError(err)
+	key := att.MarshalPublicKey()
+	a.NoError(storage.StorePeer(&Peer{Name: "alice", PublicKey: key}))
+	a.NoError(storage.CreateSession("s1", key))
+	a.Error(storage.BufferMessage("s1", BufferedMessage{Number: 1}, 0))
+	for n := uint64(1); n <= 4; n++ {
+		a.NoError(storage.BufferMessage("s1", BufferedMessage{
+			Data:   fmt.Appendf(nil, "m%d", n),
+			Number: n,
+			Route:  int32(n),
+		}, 3))
+	}
+
+	// The first message fell out of the window of three.
+	msgs, err = storage.TakeBufferedMessages("s1", 2)
+	a.NoError(err)
+	a.Equal([]BufferedMessage{
+		{Data: []byte("m3"), Number: 3, Route: 3},
+		{Data: []byte("m4"), Number: 4, Route: 4},
+	}, msgs)
+	// Taken messages are gone, and so are the ones outside the window.
+	msgs, err = storage.TakeBufferedMessages("s1", 0)
+	a.NoError(err)
+	a.Equal([]BufferedMessage{
+		{Data: []byte("m2"), Number: 2, Route: 2},
+	}, msgs)
+	msgs, err = storage.TakeBufferedMessages("s1", 0)
+	a.NoError(err)
+	a.Empty(msgs)
+}
+
+// ---------------------------------------------------------------------------
+// Backlog tests
+// ---------------------------------------------------------------------------
+
+func TestSpillMessage(t *testing.T) {
+	a := require.New(t)
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	err := storage.SpillMessage("missing", 1, 1, []byte("m"))
+	a.ErrorIs(err, ErrSessionNotFound)
+	_, err = storage.TakeSpilledMessage("missing", 1, 1)
+	a.ErrorIs(err, ErrNotFound)
+
+	att, err := attest.New()
+	a.NoError(err)
+	key := att.MarshalPublicKey()
+	a.NoError(storage.StorePeer(&Peer{Name: "alice", PublicKey: key}))
+	a.NoError(storage.CreateSession("s1", key))
+	// Queues of the same session keep their messages apart.
+	for _, queue := range []uint64{1, 2} {
+		for n := uint64(1); n <= 2; n++ {
+			a.NoError(storage.SpillMessage(
+				"s1", queue, n, fmt.Appendf(nil, "q%d-m%d", queue, n),
+			))
+		}
+	}
+
+	data, err := storage.TakeSpilledMessage("s1", 2, 1)
+	a.NoError(err)
+	a.Equal("q2-m1", string(data))
+	data, err = storage.TakeSpilledMessage("s1", 1, 2)
+	a.NoError(err)
+	a.Equal("q1-m2", string(data))
+	// Taken messages are gone.
+	_, err = storage.TakeSpilledMessage("s1", 2, 1)
+	a.ErrorIs(err, ErrNotFound)
+
+	// The backlog goes with its session.
+	a.NoError(storage.DeleteSession("s1"))
+	_, err = storage.TakeSpilledMessage("s1", 1, 1)
+	a.ErrorIs(err, ErrNotFound)
+}
+
+// ---------------------------------------------------------------------------
+// Profile tests
+// ---------------------------------------------------------------------------
+
+func TestProfiles(t *testing.T) {
+	a := require.New(t)
+	profiles, err := NewProfiles(t.TempDir())
+	a.NoError(err)
+
+	list, err := profiles.List()
+	a.NoError(err)
+	a.Empty(list)
+	_, err = profiles.Open("work", WithNoPassphrase())
+	a.ErrorIs(err, ErrProfileNotFound)
+	for _, name := range []string{"", ".hidden", "../up", "a/b", "sp ace"} {
+		_, err = profiles.Create(name)
+		a.ErrorIs(err, ErrInvalidProfileName, name)
+	}
+
+	for _, name := range []string{"work", DefaultProfile} {
+		_, err := profiles.Create(name)
+		a.NoError(err)
+	}
+	_, err = profiles.Create("work")
+	a.ErrorIs(err, ErrProfileExists)
+	list, err = profiles.List()
+	a.NoError(err)
+	a.Equal([]string{DefaultProfile, "work"}, []string{
+		list[0].Name, list[1].Name,
+	})
+
+	// Each profile has an identity of its own.
+	keys := make(map[string][]byte)
+	for _, name := range []string{"work", DefaultProfile} {
+		s, err := profiles.Open(name, WithNoPassphrase())
+		a.NoError(err)
+		at, err := s.Attester()
+		a.NoError(err)
+		keys[name] = at.MarshalPublicKey()
+		a.NoError(s.Close())
+	}
+	a.NotEqual(keys["work"], keys[DefaultProfile])
+	s, err := profiles.Open("work", WithNoPassphrase())
+	a.NoError(err)
+	at, err := s.Attester()
+	a.NoError(err)
+	a.Equal(keys["work"], at.MarshalPublicKey())
+	a.NoError(s.Close())
+
+	work, err := profiles.Get("work")
+	a.NoError(err)
+	settings, err := work.Settings()
+	a.NoError(err)
+	a.Empty(settings)
+	a.NoError(work.SaveSettings(map[string]string{"theme": "dark"}))
+	settings, err = work.Settings()
+	a.NoError(err)
+	a.Equal(map[string]string{"theme": "dark"}, settings)
+
+	a.NoError(profiles.Remove("work"))
+	_, err = profiles.Get("work")
+	a.ErrorIs(err, ErrProfileNotFound)
+	list, err = profiles.List()
+	a.NoError(err)
+	a.Len(list, 1)
+}
+
+// ---------------------------------------------------------------------------
+// Backup tests
+// ---------------------------------------------------------------------------
+
+func TestBackups(t *testing.T) {
+	a := require.New(t)
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "kamune.db")
+	backupDir := filepath.Join(dir, "backups")
+	c := clock.NewFake(time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC))
+	open := func() *Storage {
+		s, err := OpenStorage(
+			WithDBPath(dbPath), WithNoPassphrase(), WithClock(c),
+			WithBackups(backupDir, 2, time.Hour),
+		)
+		a.NoError(err)
+		return s
+	}
+
+	// A backup is taken on open, and then once an interval has passed.
+	s := open()
+	at, err := s.Attester()
+	a.NoError(err)
+	backups, err := ListBackups(backupDir)
+	a.NoError(err)
+	a.Len(backups, 1)
+	a.Equal(c.Now(), backups[0].Created)
+	s.backupIfDue()
+	backups, err = ListBackups(backupDir)
+	a.NoError(err)
+	a.Len(backups, 1)
+
+	c.Advance(time.Hour)
+	s.backupIfDue()
+	c.Advance(time.Minute)
+	latest, err := s.Backup()
+	a.NoError(err)
+	a.Positive(latest.Size)
+	backups, err = ListBackups(backupDir)
+	a.NoError(err)
+	a.Len(backups, 2, "older backups are pruned")
+	a.Equal(latest, backups[1])
+
+	// Restoring needs the database to be closed.
+	a.ErrorIs(
+		RestoreFromBackup(dbPath, latest.Path, 10*time.Millisecond),
+		ErrStoreInUse,
+	)
+	a.NoError(s.Close())
+
+	// A damaged database is replaced by the latest backup, and kept aside.
+	a.NoError(os.WriteFile(dbPath, []byte("damaged"), 0600))
+	damaged := filepath.Join(dir, "damaged.db")
+	a.NoError(os.WriteFile(damaged, []byte("damaged"), 0600))
+	a.ErrorIs(RestoreFromBackup(dbPath, damaged, time.Second), ErrCorruptDB)
+	a.NoError(RestoreFromBackup(dbPath, latest.Path, time.Second))
+	kept, err := os.ReadFile(dbPath + ".before-restore")
+	a.NoError(err)
+	a.Equal("damaged", string(kept))
+
+	// Restoring again keeps the earlier database too.
+	a.NoError(RestoreFromBackup(dbPath, latest.Path, time.Second))
+	kept, err = os.ReadFile(dbPath + ".before-restore")
+	a.NoError(err)
+	a.Equal("damaged", string(kept))
+	_, err = os.Stat(dbPath + ".before-restore.1")
+	a.NoError(err)
+
+	s = open()
+	defer func() { _ = s.Close() }()
+	restored, err := s.Attester()
+	a.NoError(err)
+	a.Equal(at.MarshalPublicKey(), restored.MarshalPublicKey())
+
+	memory, err := OpenStorage(
+		WithInMemory(), WithBackups(backupDir, 1, time.Hour),
+	)
+	a.NoError(err)
+	defer func() { _ = memory.Close() }()
+	_, err = memory.Backup()
+	a.ErrorIs(err, ErrBackupUnsupported)
+	other, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = other.Close() }()
+	_, err = other.Backup()
+	a.ErrorIs(err, ErrBackupsDisabled)
+}
+
+func TestAutoCompaction(t *testing.T) {
+	a := require.New(t)
+	dbPath := filepath.Join(t.TempDir(), "kamune.db")
+	var decisions []Compaction
+	s, err := OpenStorage(
+		WithDBPath(dbPath), WithNoPassphrase(),
+		WithAutoCompaction(0.3, 0),
+		WithCompactionHandler(func(c Compaction) {
+			decisions = append(decisions, c)
+		}),
+	)
+	a.NoError(err)
+	defer func() { _ = s.Close() }()
+	at, err := s.Attester()
+	a.NoError(err)
+
+	// A fresh database has nothing worth reclaiming.
+	a.Len(decisions, 1)
+	a.False(decisions[0].Compacted)
+
+	value := make([]byte, 1024)
+	for _, del := range []bool{false, true} {
+		a.NoError(s.engine.Command(func(b engine.Namespace) error {
+			ns := b.Sub([]byte(engine.SettingsNamespace))
+			for i := range 4096 {
+				key := fmt.Appendf(nil, "k%04d", i)
+				if del {
+					if err := ns.Delete(key); err != nil {
+						return err
+					}
+				} else if err := ns.PutEncrypted(key, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	space, err := s.Space()
+	a.NoError(err)
+	a.Greater(space.FreeRatio(), 0.3)
+
+	s.compactIfNeeded()
+	a.Len(decisions, 2)
+	c := decisions[1]
+	a.True(c.Compacted)
+	a.NoError(c.Err)
+	a.Equal(space, c.Space)
+	a.Less(c.After, space.FileBytes)
+	space, err = s.Space()
+	a.NoError(err)
+	a.Less(space.FreeRatio(), 0.3)
+	again, err := s.Attester()
+	a.NoError(err)
+	a.Equal(at.MarshalPublicKey(), again.MarshalPublicKey())
+
+	memory, err := OpenStorage(WithInMemory(), WithAutoCompaction(0.3, 0))
+	a.NoError(err)
+	defer func() { _ = memory.Close() }()
+	_, err = memory.Space()
+	a.ErrorIs(err, ErrCompactUnsupported)
+	a.ErrorIs(
+		memory.CompactTo(filepath.Join(t.TempDir(), "copy.db"), nil),
+		ErrCompactUnsupported,
+	)
+}
+
+// ---------------------------------------------------------------------------
+// Peer parameter tests
+// ---------------------------------------------------------------------------
+
+func TestPeerParameters(t *testing.T) {
+	a := require.New(t)
+	s, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = s.Close() }()
+	key := []byte("peer")
+
+	p, err := s.PeerParameters(key)
+	a.NoError(err)
+	a.Nil(p)
+	a.ErrorIs(
+		s.SetPeerParameters(nil, PeerParameters{}), ErrInvalidPublicKey,
+	)
+
+	for _, want := range []PeerParameters{
+		{AppVersion: "1.2.3", Capabilities: []string{"dedup", "journal"}},
+		{AppVersion: "1.3.0"},
+	} {
+		a.NoError(s.SetPeerParameters(key, want))
+		p, err = s.PeerParameters(key)
+		a.NoError(err)
+		a.Equal(want, *p)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Alias tests
+// ---------------------------------------------------------------------------
+
+func TestAliases(t *testing.T) {
+	a := require.New(t)
+	s, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = s.Close() }()
+	alice, bob := []byte("alice"), []byte("bob")
+
+	_, err = s.ResolveAlias("alice-laptop")
+	a.ErrorIs(err, ErrAliasNotFound)
+	for _, alias := range []string{"", "with space", "ünïcode", "a/b"} {
+		a.ErrorIs(s.SetAlias(alias, alice), ErrInvalidAlias, alias)
+	}
+	a.ErrorIs(s.SetAlias("alice", nil), ErrInvalidPublicKey)
+
+	a.NoError(s.SetAlias("alice-laptop", alice))
+	a.NoError(s.SetAlias("alice-laptop", alice))
+	a.NoError(s.SetAlias("alice_phone", alice))
+	a.NoError(s.SetAlias("bob.desk", bob))
+	a.ErrorIs(s.SetAlias("alice-laptop", bob), ErrAliasTaken)
+
+	key, err := s.ResolveAlias("alice-laptop")
+	a.NoError(err)
+	a.Equal(alice, key)
+	aliases, err := s.ListAliases()
+	a.NoError(err)
+	a.Equal([]PeerAlias{
+		{Alias: "alice-laptop", PublicKey: alice},
+		{Alias: "alice_phone", PublicKey: alice},
+		{Alias: "bob.desk", PublicKey: bob},
+	}, aliases)
+
+	// A removed alias may be assigned to someone else.
+	a.NoError(s.RemoveAlias("alice-laptop"))
+	a.NoError(s.RemoveAlias("alice-laptop"))
+	a.NoError(s.SetAlias("alice-laptop", bob))
+	key, err = s.ResolveAlias("alice-laptop")
+	a.NoError(err)
+	a.Equal(bob, key)
+}
+
+// ---------------------------------------------------------------------------
+// Peer addresses
+// ---------------------------------------------------------------------------
+
+func TestPeerAddresses(t *testing.T) {
+	a := require.New(t)
+	s, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = s.Close() }()
+	peer := []byte("peer")
+
+	got, err := s.PeerAddresses(peer)
+	a.NoError(err)
+	a.Nil(got)
+	a.ErrorIs(
+		s.SetPeerAddresses(nil, AnnouncedAddresses{}), ErrInvalidPublicKey,
+	)
+
+	var events []Event
+	cancel := s.Subscribe(PeersBucket, func(e Event) {
+		events = append(events, e)
+	})
+	defer cancel()
+
+	issued := time.Now().Truncate(time.Second)
+	first := AnnouncedAddresses{
+		Issued:    issued,
+		Addresses: []PeerAddress{{Network: "tcp", Address: "10.0.0.1:9000"}},
+		Signature: []byte("signature"),
+	}
+	a.NoError(s.SetPeerAddresses(peer, first))
+	got, err = s.PeerAddresses(peer)
+	a.NoError(err)
+	a.True(got.Issued.Equal(issued))
+	a.Equal(first.Addresses, got.Addresses)
+	a.Equal(first.Signature, got.Signature)
+	a.Len(events, 1)
+	a.Equal(EventUpdated, events[0].Op)
+	a.Equal(peer, events[0].PublicKey)
+
+	// A replayed or older announcement does not roll the addresses back.
+	a.ErrorIs(s.SetPeerAddresses(peer, first), ErrStaleAddresses)
+	older := first
+	older.Issued = issued.Add(-time.Minute)
+	older.Addresses = []PeerAddress{{Network: "udp", Address: "10.0.0.2:9000"}}
+	a.ErrorIs(s.SetPeerAddresses(peer, older), ErrStaleAddresses)
+	a.Len(events, 1)
+
+	newer := older
+	newer.Issued = issued.Add(time.Minute)
+	a.NoError(s.SetPeerAddresses(peer, newer))
+	got, err = s.PeerAddresses(peer)
+	a.NoError(err)
+	a.Equal(newer.Addresses, got.Addresses)
+}
+
+// ---------------------------------------------------------------------------
+// Degraded storage
+// ---------------------------------------------------------------------------
+
+// fullDisk is a backend whose writes fail as on a full disk while full is
+// set.
+type fullDisk struct {
+	engine.Store
+	full atomic.Bool
+}
+
+func (d *fullDisk) Command(f func(engine.Namespace) error) error {
+	if d.full.Load() {
+		return &fs.PathError{Op: "write", Path: "db", Err: syscall.ENOSPC}
+	}
+	return d.Store.Command(f)
+}
+
+func TestDegradedStorage(t *testing.T) {
+	a := require.New(t)
+	db, err := engine.NewBackendStore(store.NewMemory(), nil)
+	a.NoError(err)
+	backend := &fullDisk{Store: db}
+	var mu sync.Mutex
+	var states []error
+	storage, err := OpenStorage(
+		WithBackend(backend),
+		WithDegradedHandler(func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			states = append(states, err)
+		}),
+	)
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+	att, err := attest.New()
+	a.NoError(err)
+	a.NoError(storage.StorePeer(&Peer{
+		Name: "alice", PublicKey: att.MarshalPublicKey(), FirstSeen: time.Now(),
+	}))
+	a.NoError(storage.CreateSession("s1", att.MarshalPublicKey()))
+	var added []string
+	cancel := storage.Subscribe(ChatBucket, func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if e.Op == EventAdded {
+			added = append(added, string(e.Entry.Data))
+		}
+	})
+	defer cancel()
+
+	a.NoError(storage.AddChatEntry("s1", []byte("one"), time.Now(), SenderLocal))
+	a.NoError(storage.Degraded())
+
+	// Entries are kept while the disk is full, and the caller carries on.
+	backend.full.Store(true)
+	payload := []byte("two")
+	a.NoError(storage.AddChatEntry("s1", payload, time.Now(), SenderPeer))
+	copy(payload, "xxx")
+	a.NoError(storage.AddChatEntry("s1", []byte("three"), time.Now(), SenderLocal))
+	a.ErrorIs(storage.Degraded(), ErrStorageUnavailable)
+	a.ErrorIs(storage.Degraded(), syscall.ENOSPC)
+	history, err := storage.GetChatHistory("s1")
+	a.NoError(err)
+	a.Len(history, 1)
+
+	// They are written in order once it recovers.
+	backend.full.Store(false)
+	a.Eventually(func() bool {
+		return storage.Degraded() == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	history, err = storage.GetChatHistory("s1")
+	a.NoError(err)
+	var texts []string
+	for _, e := range history {
+		texts = append(texts, string(e.Data))
+	}
+	a.Equal([]string{"one", "two", "three"}, texts)
+
+	mu.Lock()
+	a.Equal([]string{"one", "two", "three"}, added)
+	a.Len(states, 2)
+	a.ErrorIs(states[0], ErrStorageUnavailable)
+	a.NoError(states[1])
+	mu.Unlock()
+
+	// Once too many entries are waiting, they are refused.
+	backend.full.Store(true)
+	for i := range maxPendingChatEntries {
+		a.NoError(storage.AddChatEntry(
+			"s1", fmt.Appendf(nil, "%d", i), time.Now(), SenderLocal,
+		))
+	}
+	err = storage.AddChatEntry("s1", []byte("lost"), time.Now(), SenderLocal)
+	a.ErrorIs(err, ErrStorageUnavailable)
+}
+
+// ---------------------------------------------------------------------------
+// Conversation wipes
+// ---------------------------------------------------------------------------
+
+func TestWipeConversation(t *testing.T) {
+	a := require.New(t)
+	storage, err := OpenStorage(WithInMemory(), WithConversationKeys(true))
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	var keys [][]byte
+	for _, name := range []string{"alice", "bob"} {
+		att, err := attest.New()
+		a.NoError(err)
+		a.NoError(storage.StorePeer(&Peer{
+			Name: name, PublicKey: att.MarshalPublicKey(), FirstSeen: time.Now(),
+		}))
+		keys = append(keys, att.MarshalPublicKey())
+	}
+	alice, bob := keys[0], keys[1]
+	for _, s := range []struct {
+		id  string
+		key []byte
+	}{{"a1", alice}, {"a2", alice}, {"b1", bob}} {
+		a.NoError(storage.CreateSession(s.id, s.key))
+		a.NoError(storage.AddChatEntry(
+			s.id, []byte("secret plans"), time.Now(), SenderLocal,
+		))
+		a.NoError(storage.RecordSessionStats(SessionStats{
+			Start: time.Now(), SessionID: s.id, PeerKey: s.key,
+		}))
+	}
+
+	var events []Event
+	cancel := storage.Subscribe(SessionsBucket, func(e Event) {
+		events = append(events, e)
+	})
+	defer cancel()
+
+	a.ErrorIs(storage.WipeConversation(nil), ErrInvalidPublicKey)
+	a.NoError(storage.WipeConversation(alice))
+	a.Len(events, 2)
+	for _, e := range events {
+		a.Equal(EventDeleted, e.Op)
+	}
+
+	sessions, err := storage.ListSessions()
+	a.NoError(err)
+	a.Equal([]string{"b1"}, sessions)
+	history, err := storage.GetChatHistory("a1")
+	a.NoError(err)
+	a.Empty(history)
+	results, err := storage.SearchChatHistory("plans")
+	a.NoError(err)
+	a.Len(results, 1)
+	_, err = storage.FindConversationByPeer(alice)
+	a.ErrorIs(err, ErrNotFound)
+	usage, err := storage.SessionUsage("a1")
+	a.NoError(err)
+	a.Zero(usage.Connections)
+	usage, err = storage.SessionUsage("b1")
+	a.NoError(err)
+	a.Equal(1, usage.Connections)
+
+	// The peer stays known, and wiping again finds nothing to wipe.
+	_, err = storage.FindPeer(alice)
+	a.NoError(err)
+	a.NoError(storage.WipeConversation(alice))
+	a.Len(events, 2)
+
+	// A new conversation starts from scratch.
+	a.NoError(storage.CreateSession("a3", alice))
+	conv, err := storage.FindConversationByPeer(alice)
+	a.NoError(err)
+	a.Equal([]string{"a3"}, conv.Sessions)
+}
+
+func TestWipeReceipts(t *testing.T) {
+	a := require.New(t)
+	storage, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+	alice, bob := []byte("alice"), []byte("bob")
+
+	got, err := storage.WipeReceipts(alice)
+	a.NoError(err)
+	a.Empty(got)
+	a.ErrorIs(storage.RecordWipeReceipt(WipeReceipt{ID: "x"}), ErrInvalidPublicKey)
+	a.Error(storage.RecordWipeReceipt(WipeReceipt{PeerKey: alice}))
+
+	base := time.Now().Truncate(time.Second)
+	for _, r := range []WipeReceipt{
+		{ID: "late", PeerKey: alice, Requested: base.Add(time.Minute)},
+		{ID: "early", PeerKey: alice, Requested: base},
+		{ID: "other", PeerKey: bob, Requested: base},
+	} {
+		r.Wiped = r.Requested.Add(time.Second)
+		r.SessionID, r.Signature = "s", []byte("signature")
+		a.NoError(storage.RecordWipeReceipt(r))
+	}
+
+	got, err = storage.WipeReceipts(alice)
+	a.NoError(err)
+	a.Len(got, 2)
+	a.Equal("early", got[0].ID)
+	a.Equal("late", got[1].ID)
+	a.True(got[0].Wiped.Equal(base.Add(time.Second)))
+	a.Equal([]byte("signature"), got[0].Signature)
+
+	// Receipts outlive the conversation they are about.
+	a.NoError(storage.WipeConversation(alice))
+	got, err = storage.WipeReceipts(alice)
+	a.NoError(err)
+	a.Len(got, 2)
+}
+
+func TestTransferState(t *testing.T) {
+	a := require.New(t)
+	storage, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+	alice, bob := []byte("alice"), []byte("bob")
+
+	_, err = storage.Transfer(alice, "t1")
+	a.ErrorIs(err, ErrNotFound)
+	a.ErrorIs(storage.SaveTransfer(TransferState{ID: "t1"}), ErrInvalidPublicKey)
+	a.Error(storage.SaveTransfer(TransferState{PeerKey: alice}))
+
+	for _, st := range []TransferState{
+		{ID: "t1", PeerKey: alice, Path: "in.bin", Size: 10, Offset: 4},
+		{ID: "t2", PeerKey: alice, Path: "out.bin", Outgoing: true},
+		{ID: "t1", PeerKey: bob, Path: "other.bin"},
+	} {
+		a.NoError(storage.SaveTransfer(st))
+	}
+
+	st, err := storage.Transfer(alice, "t1")
+	a.NoError(err)
+	a.Equal("in.bin", st.Path)
+	a.EqualValues(4, st.Offset)
+	a.False(st.Updated.IsZero())
+
+	got, err := storage.Transfers(alice)
+	a.NoError(err)
+	a.Len(got, 2)
+
+	a.NoError(storage.DeleteTransfer(alice, "t1"))
+	a.NoError(storage.DeleteTransfer(alice, "t1"))
+	_, err = storage.Transfer(alice, "t1")
+	a.ErrorIs(err, ErrNotFound)
+	st, err = storage.Transfer(bob, "t1")
+	a.NoError(err)
+	a.Equal("other.bin", st.Path)
+}
+
+func TestGroupState(t *testing.T) {
+	a := require.New(t)
+	storage, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+	alice, bob := []byte("alice"), []byte("bob")
+
+	_, err = storage.Group("g1")
+	a.ErrorIs(err, ErrNotFound)
+	a.ErrorIs(storage.SaveGroup(GroupState{ID: "g1"}), ErrInvalidPublicKey)
+	a.Error(storage.SaveGroup(GroupState{Owner: alice}))
+
+	now := time.Now()
+	for _, g := range []GroupState{
+		{ID: "g2", Owner: bob, Created: now.Add(time.Minute)},
+		{ID: "g1", Owner: alice, Members: [][]byte{alice}, Created: now},
+		{ID: "g1", Owner: alice, Members: [][]byte{alice, bob}, Created: now},
+	} {
+		a.NoError(storage.SaveGroup(g))
+	}
+
+	g, err := storage.Group("g1")
+	a.NoError(err)
+	a.Equal([][]byte{alice, bob}, g.Members)
+
+	groups, err := storage.Groups()
+	a.NoError(err)
+	a.Len(groups, 2)
+	a.Equal("g1", groups[0].ID)
+
+	a.NoError(storage.DeleteGroup("g1"))
+	a.NoError(storage.DeleteGroup("g1"))
+	_, err = storage.Group("g1")
+	a.ErrorIs(err, ErrNotFound)
+}
+
+// ---------------------------------------------------------------------------
+// Paper keys
+// ---------------------------------------------------------------------------
+
+func TestPaperKey(t *testing.T) {
+	a := require.New(t)
+	old, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = old.Close() }()
+
+	_, err = old.ExportPaperKey()
+	a.ErrorIs(err, ErrIdentityNotFound)
+	identity, err := old.Attester()
+	a.NoError(err)
+
+	firstSeen := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var keys [][]byte
+	for _, name := range []string{"alice", "mallory"} {
+		att, err := attest.New()
+		a.NoError(err)
+		a.NoError(old.StorePeer(&Peer{
+			Name: name, PublicKey: att.MarshalPublicKey(), FirstSeen: firstSeen,
+		}))
+		keys = append(keys, att.MarshalPublicKey())
+	}
+	alice, mallory := keys[0], keys[1]
+	a.NoError(old.BlockPeer(mallory))
+
+	key, err := old.ExportPaperKey()
+	a.NoError(err)
+	again, err := old.ExportPaperKey()
+	a.NoError(err)
+	a.Equal(key.Phrase, again.Phrase)
+	a.NotContains(string(key.Backup), "alice")
+
+	// The backup only opens with its own phrase.
+	fresh, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = fresh.Close() }()
+	other, err := attest.New()
+	a.NoError(err)
+	_, err = fresh.RestorePaperKey(paperkey.Encode(other.Seed()), key.Backup)
+	a.ErrorIs(err, ErrPaperKeyMismatch)
+	damaged := bytes.Clone(key.Backup)
+	damaged[len(damaged)-1] ^= 1
+	_, err = fresh.RestorePaperKey(key.Phrase, damaged)
+	a.ErrorIs(err, ErrPaperKeyMismatch)
+	words := strings.Fields(key.Phrase)
+	words[0], words[1] = words[1], words[0]
+	_, err = fresh.RestorePaperKey(strings.Join(words, " "), key.Backup)
+	a.Error(err)
+	_, err = fresh.LoadIdentity()
+	a.ErrorIs(err, ErrIdentityNotFound)
+
+	restored, err := fresh.RestorePaperKey(key.Phrase, key.Backup)
+	a.NoError(err)
+	a.Equal(identity.MarshalPublicKey(), restored.MarshalPublicKey())
+	loaded, err := fresh.LoadIdentity()
+	a.NoError(err)
+	a.Equal(identity.MarshalPublicKey(), loaded.MarshalPublicKey())
+
+	peers, err := fresh.ListPeers()
+	a.NoError(err)
+	a.Len(peers, 1)
+	a.Equal("alice", peers[0].Name)
+	a.Equal(alice, peers[0].PublicKey)
+	a.True(peers[0].FirstSeen.Equal(firstSeen))
+
+	_, err = fresh.RestorePaperKey(key.Phrase, nil)
+	a.ErrorIs(err, ErrIdentityExists)
+
+	// The identity alone can be restored without the backup.
+	bare, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = bare.Close() }()
+	restored, err = bare.RestorePaperKey(key.Phrase, nil)
+	a.NoError(err)
+	a.Equal(identity.MarshalPublicKey(), restored.MarshalPublicKey())
+	peers, err = bare.ListPeers()
+	a.NoError(err)
+	a.Empty(peers)
+}
+
+// ---------------------------------------------------------------------------
+// Transcripts
+// ---------------------------------------------------------------------------
+
+func TestChatTranscript(t *testing.T) {
+	a := require.New(t)
+	storage, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	peer, err := attest.New()
+	a.NoError(err)
+	a.NoError(storage.StorePeer(&Peer{
+		Name: "alice", PublicKey: peer.MarshalPublicKey(),
+	}))
+	a.NoError(storage.CreateSession("session", peer.MarshalPublicKey()))
+	_, err = storage.ExportChatHistory("unknown", true)
+	a.ErrorIs(err, ErrSessionNotFound)
+
+	base := time.Now().Truncate(time.Second)
+	sig := MessageSignature{
+		Metadata:  []byte("metadata"),
+		Message:   []byte("message"),
+		Signature: []byte("signature"),
+	}
+	a.NoError(storage.AddSignedChatEntry(
+		"session", []byte("signed hello"), base, 1<<16, SenderPeer, sig,
+	))
+	a.NoError(storage.AddChatEntry(
+		"session", []byte("plain reply"), base.Add(time.Second), SenderLocal,
+	))
+
+	history, err := storage.GetChatHistory("session")
+	a.NoError(err)
+	a.Len(history, 2)
+	a.Equal("signed hello", string(history[0].Data))
+	a.Equal(uint64(1<<16), history[0].Clock)
+	a.Equal(&sig, history[0].Signature)
+	a.Nil(history[1].Signature)
+	results, err := storage.SearchChatHistory("signed")
+	a.NoError(err)
+	a.Len(results, 1)
+
+	plain, err := storage.ExportChatHistory("session", false)
+	a.NoError(err)
+	a.Nil(plain.Entries[0].Signature)
+	tr, err := storage.ExportChatHistory("session", true)
+	a.NoError(err)
+	a.Equal("alice", tr.PeerName)
+	a.Equal(peer.MarshalPublicKey(), tr.PeerKey)
+	a.Equal(&sig, tr.Entries[0].Signature)
+	data, err := json.Marshal(tr)
+	a.NoError(err)
+	var decoded Transcript
+	a.NoError(json.Unmarshal(data, &decoded))
+
+	// Importing again adds nothing.
+	added, err := storage.ImportChatHistory(&decoded)
+	a.NoError(err)
+	a.Zero(added)
+
+	// Another identity cannot import it.
+	other, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = other.Close() }()
+	_, err = other.ImportChatHistory(&decoded)
+	a.ErrorIs(err, ErrTranscriptMismatch)
+
+	// The same identity, restored elsewhere, can.
+	key, err := storage.ExportPaperKey()
+	a.NoError(err)
+	restored, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = restored.Close() }()
+	_, err = restored.RestorePaperKey(key.Phrase, nil)
+	a.NoError(err)
+	added, err = restored.ImportChatHistory(&decoded)
+	a.NoError(err)
+	a.Equal(2, added)
+	imported, err := restored.GetChatHistory("session")
+	a.NoError(err)
+	a.Len(imported, 2)
+	a.Equal(history[0].Data, imported[0].Data)
+	a.Equal(&sig, imported[0].Signature)
+	a.True(history[1].Timestamp.Equal(imported[1].Timestamp))
+	got, err := restored.GetPeer("session")
+	a.NoError(err)
+	a.Equal("alice", got.Name)
+
+	decoded.PeerKey = key.Backup[:44]
+	_, err = restored.ImportChatHistory(&decoded)
+	a.ErrorIs(err, ErrTranscriptMismatch)
+}
+
+// ---------------------------------------------------------------------------
+// Reputation
+// ---------------------------------------------------------------------------
+
+func TestReputation(t *testing.T) {
+	a := require.New(t)
+	storage, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+
+	first, err := attest.New()
+	a.NoError(err)
+	second, err := attest.New()
+	a.NoError(err)
+	key1, key2 := first.MarshalPublicKey(), second.MarshalPublicKey()
+
+	r, err := storage.GetReputation(key1)
+	a.NoError(err)
+	a.Zero(r.Offenses())
+	a.Equal(key1, r.PublicKey)
+	_, err = storage.RecordOffense(nil, OffenseAbuse, time.Time{})
+	a.ErrorIs(err, ErrInvalidPublicKey)
+
+	_, err = storage.RecordOffense(key1, OffenseHandshake, time.Time{})
+	a.NoError(err)
+	r, err = storage.RecordOffense(key1, OffenseAbuse, time.Time{})
+	a.NoError(err)
+	a.Equal(1, r.HandshakeFailures)
+	a.Equal(1, r.Abuses)
+	a.False(r.First.After(r.Last))
+	got, err := storage.GetReputation(key1)
+	a.NoError(err)
+	a.Equal(2, got.Offenses())
+	a.True(got.Last.Equal(r.Last))
+
+	_, err = storage.RecordOffense(key2, OffenseHandshake, time.Time{})
+	a.NoError(err)
+	list, err := storage.ListReputations()
+	a.NoError(err)
+	a.Len(list, 2)
+	a.Equal(key2, list[0].PublicKey)
+
+	// Offenses older than the cutoff are forgotten.
+	r, err = storage.RecordOffense(
+		key1, OffenseHandshake, time.Now().Add(time.Hour),
+	)
+	a.NoError(err)
+	a.Equal(1, r.Offenses())
+	a.Equal(1, r.HandshakeFailures)
+
+	a.NoError(storage.ClearReputation(key1))
+	a.NoError(storage.ClearReputation(key1))
+	r, err = storage.GetReputation(key1)
+	a.NoError(err)
+	a.Zero(r.Offenses())
+	list, err = storage.ListReputations()
+	a.NoError(err)
+	a.Len(list, 1)
+}
+
+// ---------------------------------------------------------------------------
+// Self session
+// ---------------------------------------------------------------------------
+
+func TestSelfSession(t *testing.T) {
+	a := require.New(t)
+	storage, err := OpenStorage(WithInMemory())
+	a.NoError(err)
+	defer func() { _ = storage.Close() }()
+	self, err := storage.PublicKey()
+	a.NoError(err)
+
+	id, err := storage.SelfSession()
+	a.NoError(err)
+	a.Equal(SelfSessionID, id)
+	established, err := storage.GetEstablishedAt(id)
+	a.NoError(err)
+
+	peer, err := storage.GetPeer(id)
+	a.NoError(err)
+	a.Equal(SelfPeerName, peer.Name)
+	a.Equal(self, peer.PublicKey)
+	a.True(peer.FirstSeen.Equal(established))
+	_, err = storage.FindPeer(self)
+	a.Error(err, "the identity is not stored as a peer")
+
+	now := time.Now()
+	a.NoError(storage.AddChatEntry(id, []byte("note"), now, SenderLocal))
+
+	// Opening it again keeps it as it was.
+	again, err := storage.SelfSession()
+	a.NoError(err)
+	a.Equal(id, again)
+	got, err := storage.GetEstablishedAt(id)
+	a.NoError(err)
+	a.True(got.Equal(established))
+	history, err := storage.GetChatHistory(id)
+	a.NoError(err)
+	a.Len(history, 1)
+	a.Equal([]byte("note"), history[0].Data)
+
+	sessions, err := storage.ListSessions()
+	a.NoError(err)
+	a.Equal([]string{id}, sessions)
+	c, err := storage.FindConversationByPeer(self)
+	a.NoError(err)
+	a.Equal([]string{id}, c.Sessions)
+}
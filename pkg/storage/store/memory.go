@@ -0,0 +1,216 @@
+package store
+
+import (
+	"fmt"
+	"iter"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// Memory is a [Backend] that keeps everything in memory. Close zeroes every
+// stored value before dropping it. It suits tests, and applications that
+// must leave nothing on disk.
+type Memory struct {
+	root   *memBucket
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewMemory returns an empty Memory backend.
+func NewMemory() *Memory {
+	return &Memory{root: newMemBucket()}
+}
+
+type memBucket struct {
+	values map[string][]byte
+	subs   map[string]*memBucket
+}
+
+func newMemBucket() *memBucket {
+	return &memBucket{
+		values: make(map[string][]byte),
+		subs:   make(map[string]*memBucket),
+	}
+}
+
+// memTx records how to undo the changes of an update.
+type memTx struct {
+	undo     []func()
+	writable bool
+}
+
+func (tx *memTx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	tx.undo = nil
+}
+
+func (m *Memory) View(fn func(root Bucket) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return ErrClosed
+	}
+	return fn(&memView{tx: &memTx{}, bucket: m.root})
+}
+
+func (m *Memory) Update(fn func(root Bucket) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	tx := &memTx{writable: true}
+	if err := fn(&memView{tx: tx, bucket: m.root}); err != nil {
+		tx.rollback()
+		return err
+	}
+	return nil
+}
+
+// Close wipes every stored value. The backend cannot be used afterwards.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.root.wipe()
+	m.root = nil
+	m.closed = true
+	return nil
+}
+
+func (b *memBucket) wipe() {
+	for k, v := range b.values {
+		clear(v)
+		delete(b.values, k)
+	}
+	for k, sub := range b.subs {
+		sub.wipe()
+		delete(b.subs, k)
+	}
+}
+
+// memView is a bucket of a Memory as seen by a transaction.
+type memView struct {
+	tx     *memTx
+	bucket *memBucket
+}
+
+func (v *memView) Get(key []byte) []byte {
+	return v.bucket.values[string(key)]
+}
+
+func (v *memView) Put(key, value []byte) error {
+	if !v.tx.writable {
+		return fmt.Errorf("put: %w", ErrReadOnly)
+	}
+	v.set(string(key), slices.Clone(value))
+	return nil
+}
+
+func (v *memView) Delete(key []byte) error {
+	if !v.tx.writable {
+		return fmt.Errorf("delete: %w", ErrReadOnly)
+	}
+	v.set(string(key), nil)
+	return nil
+}
+
+// set stores value under key, or deletes key if value is nil, and records
+// how to restore the previous state.
+func (v *memView) set(key string, value []byte) {
+	values := v.bucket.values
+	prev, existed := values[key]
+	if value == nil {
+		delete(values, key)
+	} else {
+		values[key] = value
+	}
+	v.tx.undo = append(v.tx.undo, func() {
+		if existed {
+			values[key] = prev
+		} else {
+			delete(values, key)
+		}
+	})
+}
+
+func (v *memView) Values() iter.Seq2[[]byte, []byte] {
+	return func(yield func(k, v []byte) bool) {
+		for _, k := range slices.Sorted(maps.Keys(v.bucket.values)) {
+			if !yield([]byte(k), v.bucket.values[k]) {
+				return
+			}
+		}
+	}
+}
+
+func (v *memView) First() []byte {
+	if len(v.bucket.values) == 0 {
+		return nil
+	}
+	return []byte(slices.Min(slices.Collect(maps.Keys(v.bucket.values))))
+}
+
+func (v *memView) Last() []byte {
+	if len(v.bucket.values) == 0 {
+		return nil
+	}
+	return []byte(slices.Max(slices.Collect(maps.Keys(v.bucket.values))))
+}
+
+func (v *memView) Len() int {
+	return len(v.bucket.values)
+}
+
+func (v *memView) Bucket(name []byte) Bucket {
+	sub, ok := v.bucket.subs[string(name)]
+	if !ok {
+		return nil
+	}
+	return &memView{tx: v.tx, bucket: sub}
+}
+
+func (v *memView) CreateBucket(name []byte) (Bucket, error) {
+	key := string(name)
+	if sub, ok := v.bucket.subs[key]; ok {
+		return &memView{tx: v.tx, bucket: sub}, nil
+	}
+	if !v.tx.writable {
+		return nil, fmt.Errorf("create bucket %q: %w", name, ErrReadOnly)
+	}
+	if len(name) == 0 {
+		return nil, fmt.Errorf("create bucket: empty name")
+	}
+	sub := newMemBucket()
+	parent := v.bucket
+	parent.subs[key] = sub
+	v.tx.undo = append(v.tx.undo, func() { delete(parent.subs, key) })
+	return &memView{tx: v.tx, bucket: sub}, nil
+}
+
+func (v *memView) DeleteBucket(name []byte) error {
+	if !v.tx.writable {
+		return fmt.Errorf("delete bucket %q: %w", name, ErrReadOnly)
+	}
+	key := string(name)
+	sub, ok := v.bucket.subs[key]
+	if !ok {
+		return fmt.Errorf("delete bucket %q: %w", name, ErrBucketNotFound)
+	}
+	parent := v.bucket
+	delete(parent.subs, key)
+	v.tx.undo = append(v.tx.undo, func() { parent.subs[key] = sub })
+	return nil
+}
+
+func (v *memView) Buckets() []string {
+	if len(v.bucket.subs) == 0 {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(v.bucket.subs))
+}
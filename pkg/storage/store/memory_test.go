@@ -0,0 +1,104 @@
+package store
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var _ Backend = (*Memory)(nil)
+
+func TestMemory_Buckets(t *testing.T) {
+	a := require.New(t)
+	m := NewMemory()
+	defer m.Close()
+
+	a.NoError(m.Update(func(root Bucket) error {
+		b, err := root.CreateBucket([]byte("b"))
+		a.NoError(err)
+		a.NoError(b.Put([]byte("z"), []byte("3")))
+		a.NoError(b.Put([]byte("a"), []byte("1")))
+		a.NoError(b.Put([]byte("m"), []byte("2")))
+		_, err = b.CreateBucket([]byte("child"))
+		a.NoError(err)
+		_, err = b.CreateBucket([]byte("other"))
+		return err
+	}))
+
+	a.NoError(m.View(func(root Bucket) error {
+		b := root.Bucket([]byte("b"))
+		a.NotNil(b)
+		a.Nil(root.Bucket([]byte("missing")))
+		a.Equal([]byte("2"), b.Get([]byte("m")))
+		a.Nil(b.Get([]byte("missing")))
+		a.Equal(3, b.Len())
+		a.Equal([]byte("a"), b.First())
+		a.Equal([]byte("z"), b.Last())
+		a.Equal([]string{"child", "other"}, b.Buckets())
+
+		var keys []string
+		for k := range b.Values() {
+			keys = append(keys, string(k))
+		}
+		a.Equal([]string{"a", "m", "z"}, keys)
+		return nil
+	}))
+
+	a.NoError(m.Update(func(root Bucket) error {
+		b := root.Bucket([]byte("b"))
+		a.NoError(b.Delete([]byte("m")))
+		a.NoError(b.Delete([]byte("missing")))
+		a.NoError(b.DeleteBucket([]byte("other")))
+		a.ErrorIs(b.DeleteBucket([]byte("other")), ErrBucketNotFound)
+		return nil
+	}))
+	a.NoError(m.View(func(root Bucket) error {
+		b := root.Bucket([]byte("b"))
+		a.Equal(2, b.Len())
+		a.Equal([]string{"child"}, b.Buckets())
+		return nil
+	}))
+}
+
+func TestMemory_Transactions(t *testing.T) {
+	a := require.New(t)
+	m := NewMemory()
+
+	a.NoError(m.Update(func(root Bucket) error {
+		b, err := root.CreateBucket([]byte("b"))
+		a.NoError(err)
+		return b.Put([]byte("k"), []byte("v"))
+	}))
+
+	// A failed update leaves nothing behind.
+	failed := errors.New("failed")
+	a.ErrorIs(m.Update(func(root Bucket) error {
+		b := root.Bucket([]byte("b"))
+		a.NoError(b.Put([]byte("k"), []byte("changed")))
+		a.NoError(b.Put([]byte("new"), []byte("v")))
+		_, err := root.CreateBucket([]byte("new"))
+		a.NoError(err)
+		a.NoError(root.DeleteBucket([]byte("b")))
+		return failed
+	}), failed)
+
+	a.NoError(m.View(func(root Bucket) error {
+		a.Nil(root.Bucket([]byte("new")))
+		b := root.Bucket([]byte("b"))
+		a.Equal([]byte("v"), b.Get([]byte("k")))
+		a.Equal(1, b.Len())
+
+		a.ErrorIs(b.Put([]byte("k"), nil), ErrReadOnly)
+		a.ErrorIs(b.Delete([]byte("k")), ErrReadOnly)
+		_, err := root.CreateBucket([]byte("new"))
+		a.ErrorIs(err, ErrReadOnly)
+		a.ErrorIs(root.DeleteBucket([]byte("b")), ErrReadOnly)
+		return nil
+	}))
+
+	a.NoError(m.Close())
+	a.NoError(m.Close())
+	a.ErrorIs(m.View(func(Bucket) error { return nil }), ErrClosed)
+	a.ErrorIs(m.Update(func(Bucket) error { return nil }), ErrClosed)
+}
@@ -0,0 +1,67 @@
+// Package store defines the key-value backends that a storage can keep its
+// data in, in place of the default BoltDB file; see storage.WithStore.
+//
+// Backends are deliberately dumb: they hold nested buckets of opaque keys and
+// values and run transactions over them. Encryption happens above them, so a
+// backend only ever sees ciphertext, apart from the salts and the wrapped key
+// the storage needs to derive its key from the passphrase. A backend for
+// another database, such as SQLite for concurrent readers and external
+// tooling, implements [Backend] in its own module, which keeps the database
+// driver out of the binaries of those that do not use it.
+package store
+
+import (
+	"errors"
+	"iter"
+)
+
+var (
+	// ErrBucketNotFound is returned when deleting a bucket that does not
+	// exist.
+	ErrBucketNotFound = errors.New("bucket not found")
+	// ErrReadOnly is returned by the writes made inside [Backend.View].
+	ErrReadOnly = errors.New("transaction is read-only")
+	// ErrClosed is returned by the transactions of a closed backend.
+	ErrClosed = errors.New("backend is closed")
+)
+
+// Backend is a transactional store of nested buckets. Transactions are
+// serializable: View may run alongside other Views, and Update alone.
+type Backend interface {
+	// View runs fn in a read-only transaction on the root bucket.
+	View(fn func(root Bucket) error) error
+	// Update runs fn in a read-write transaction on the root bucket. If fn
+	// returns an error, none of its changes are kept.
+	Update(fn func(root Bucket) error) error
+	Close() error
+}
+
+// Bucket holds key-value pairs and child buckets, whose names are separate
+// from the keys. The slices a bucket returns are only valid until the end of
+// the transaction, and must not be modified.
+type Bucket interface {
+	// Get returns the value of key, or nil if there is none.
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	// Delete deletes key. Deleting a missing key is not an error.
+	Delete(key []byte) error
+	// Values yields the key-value pairs in byte-wise order of their keys.
+	Values() iter.Seq2[[]byte, []byte]
+	// First and Last return the smallest and largest keys, or nil if the
+	// bucket holds no values.
+	First() []byte
+	Last() []byte
+	// Len returns the number of key-value pairs.
+	Len() int
+
+	// Bucket returns the child bucket called name, or nil if there is none.
+	Bucket(name []byte) Bucket
+	// CreateBucket returns the child bucket called name, creating it if it
+	// does not exist.
+	CreateBucket(name []byte) (Bucket, error)
+	// DeleteBucket deletes the child bucket called name and everything in
+	// it, and fails with [ErrBucketNotFound] if there is none.
+	DeleteBucket(name []byte) error
+	// Buckets returns the names of the child buckets in byte-wise order.
+	Buckets() []string
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// TimelineKind is the kind of a [TimelineEvent].
+type TimelineKind uint8
+
+const (
+	// TimelineEstablished is a session being established by a handshake.
+	TimelineEstablished TimelineKind = iota + 1
+	// TimelineResumed is a session being resumed on a new connection, with
+	// keys derived afresh.
+	TimelineResumed
+	// TimelineMigrated is a session moving to a new connection.
+	TimelineMigrated
+	// TimelineCheckpoint records the sequence numbers a session has reached.
+	TimelineCheckpoint
+	// TimelineError is an error that broke off a session's connection.
+	TimelineError
+	// TimelineClosed is a session's connection being closed by either side.
+	TimelineClosed
+)
+
+// String returns the string representation of the kind.
+func (k TimelineKind) String() string {
+	switch k {
+	case TimelineEstablished:
+		return "Established"
+	case TimelineResumed:
+		return "Resumed"
+	case TimelineMigrated:
+		return "Migrated"
+	case TimelineCheckpoint:
+		return "Checkpoint"
+	case TimelineError:
+		return "Error"
+	case TimelineClosed:
+		return "Closed"
+	default:
+		return "Invalid"
+	}
+}
+
+// TimelineEvent is an event in the lifecycle of a session; see
+// [Storage.RecordTimelineEvent].
+type TimelineEvent struct {
+	Time      time.Time    `json:"time"`
+	SessionID string       `json:"sessionId"`
+	Detail    string       `json:"detail,omitempty"`
+	Kind      TimelineKind `json:"kind"`
+	// SendSequence and ReceiveSequence are the sequence numbers of the last
+	// messages the session sent and received when the event happened.
+	SendSequence    uint64 `json:"send"`
+	ReceiveSequence uint64 `json:"receive"`
+}
+
+// SessionReplay is the state of a session as its timeline tells it at a
+// point in time; see [Storage.ReplaySession].
+type SessionReplay struct {
+	// Established is when the session was established, and Resumed when it
+	// was last resumed, if it was.
+	Established time.Time
+	Resumed     time.Time
+	// LastEvent is the latest event up to the point replayed to.
+	LastEvent TimelineEvent
+	// LastError is the detail of the latest TimelineError event.
+	LastError string
+	// Events are the events replayed, oldest first.
+	Events          []TimelineEvent
+	SendSequence    uint64
+	ReceiveSequence uint64
+	Resumptions     int
+	Migrations      int
+	Errors          int
+	// Connected is whether a connection of the session was open.
+	Connected bool
+}
+
+// RecordTimelineEvent appends e to the timeline of its session, setting its
+// time to now if it is zero. The timeline is append-only: events are never
+// changed, and are only removed once they are older than the configured
+// retention (see [WithTimelineRetention]), pruned in the same transaction, or
+// when the conversation they belong to is wiped.
+func (s *Storage) RecordTimelineEvent(e TimelineEvent) error {
+	if e.Time.IsZero() {
+		e.Time = s.clock.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshalling timeline event: %w", err)
+	}
+	err = s.engine.Command(func(b engine.Namespace) error {
+		ns := b.Ensure([]byte(engine.TimelineNamespace))
+		if s.timelineRetention > 0 {
+			pruneBefore(ns, s.clock.Now().Add(-s.timelineRetention))
+		}
+		return ns.PutEncrypted(timeKey(e.Time, e.SessionID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("recording timeline event: %w", err)
+	}
+	return nil
+}
+
+// SessionTimeline returns the recorded events of the session, oldest first.
+func (s *Storage) SessionTimeline(sessionID string) ([]TimelineEvent, error) {
+	var events []TimelineEvent
+	err := s.engine.Query(func(b engine.Namespace) error {
+		ns := b.Sub([]byte(engine.TimelineNamespace))
+		for _, v := range ns.IterateEncrypted() {
+			var e TimelineEvent
+			if err := json.Unmarshal(v, &e); err != nil {
+				slog.Warn(
+					"skipping malformed timeline event",
+					slog.Any("error", err),
+				)
+				continue
+			}
+			if e.SessionID == sessionID {
+				events = append(events, e)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting session timeline: %w", err)
+	}
+	return events, nil
+}
+
+// ReplaySession replays the timeline of the session up to and including
+// the events at the given time, or all of it if the time is zero, and
+// returns the state it leads to. It tells what became of a session after the
+// fact, such as why one broke off at a given time.
+func (s *Storage) ReplaySession(
+	sessionID string, at time.Time,
+) (SessionReplay, error) {
+	events, err := s.SessionTimeline(sessionID)
+	if err != nil {
+		return SessionReplay{}, err
+	}
+	if !at.IsZero() {
+		events = slices.DeleteFunc(events, func(e TimelineEvent) bool {
+			return e.Time.After(at)
+		})
+	}
+
+	r := SessionReplay{Events: events}
+	for _, e := range events {
+		r.LastEvent = e
+		r.SendSequence = max(r.SendSequence, e.SendSequence)
+		r.ReceiveSequence = max(r.ReceiveSequence, e.ReceiveSequence)
+		switch e.Kind {
+		case TimelineEstablished:
+			r.Established = e.Time
+			r.Connected = true
+		case TimelineResumed:
+			r.Resumed = e.Time
+			r.Resumptions++
+			r.Connected = true
+		case TimelineMigrated:
+			r.Migrations++
+			r.Connected = true
+		case TimelineError:
+			r.LastError = e.Detail
+			r.Errors++
+			r.Connected = false
+		case TimelineClosed:
+			r.Connected = false
+		}
+	}
+	return r, nil
+}
+
+// PruneTimeline removes all timeline events from before the given time and
+// returns how many were removed.
+func (s *Storage) PruneTimeline(before time.Time) (int, error) {
+	var n int
+	err := s.engine.Command(func(b engine.Namespace) error {
+		n = pruneBefore(b.Sub([]byte(engine.TimelineNamespace)), before)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("pruning timeline: %w", err)
+	}
+	return n, nil
+}
+
+// wipeTimeline deletes the timeline events of the given sessions.
+func wipeTimeline(timeline engine.Namespace, sessions []string) error {
+	var keys [][]byte
+	for key, value := range timeline.IterateEncrypted() {
+		var e TimelineEvent
+		err := json.Unmarshal(value, &e)
+		if err == nil && slices.Contains(sessions, e.SessionID) {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		if err := timeline.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxTokenRunes caps the length of an indexed token. Longer words are
+// truncated, so a query for the full word still matches.
+const maxTokenRunes = 64
+
+// tokenize splits text into the distinct, normalized terms used by the chat
+// search index. Words are runs of letters, digits, and combining marks, case
+// folded and kept only when at least two runes long. Han, Hiragana, and
+// Katakana are written without spaces and are indexed one character at a
+// time instead. Arabic-script text is normalized so that the Arabic and
+// Persian forms of kaf and yeh, diacritics, tatweel, and zero-width joiners do
+// not affect matching, and Arabic-Indic digits are folded to ASCII.
+func tokenize(text string) []string {
+	var (
+		tokens []string
+		seen   = make(map[string]struct{})
+		word   strings.Builder
+		n      int
+	)
+	emit := func(tok string) {
+		if _, ok := seen[tok]; ok {
+			return
+		}
+		seen[tok] = struct{}{}
+		tokens = append(tokens, tok)
+	}
+	flush := func() {
+		if n >= 2 {
+			emit(word.String())
+		}
+		word.Reset()
+		n = 0
+	}
+
+	for _, r := range text {
+		r, keep := normalizeRune(r)
+		switch {
+		case !keep:
+			// Ignorable inside a word: neither part of it nor a boundary.
+		case isIdeograph(r):
+			flush()
+			emit(string(r))
+		case unicode.In(r, unicode.Letter, unicode.Digit, unicode.Mn):
+			if n < maxTokenRunes {
+				word.WriteRune(r)
+				n++
+			}
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+// normalizeRune folds r to its indexed form. It reports false for runes that
+// are dropped entirely without splitting the surrounding word.
+func normalizeRune(r rune) (rune, bool) {
+	switch {
+	case r == '\u200c' || r == '\u200d' || r == '\u0640':
+		// ZWNJ, ZWJ, and tatweel only affect how a word is rendered.
+		return 0, false
+	case r >= '\u064b' && r <= '\u065f' || r == '\u0670':
+		// Arabic diacritics are usually omitted when typing.
+		return 0, false
+	case r == '\u0643':
+		return '\u06a9', true // Arabic kaf to Persian keheh.
+	case r == '\u064a' || r == '\u0649':
+		return '\u06cc', true // Arabic yeh and alef maksura to Persian yeh.
+	case r >= '\u0660' && r <= '\u0669':
+		return '0' + r - '\u0660', true
+	case r >= '\u06f0' && r <= '\u06f9':
+		return '0' + r - '\u06f0', true
+	}
+	return unicode.ToLower(r), true
+}
+
+// isIdeograph reports whether r belongs to a script written without spaces
+// between words.
+func isIdeograph(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
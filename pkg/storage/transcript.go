@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTranscriptMismatch is returned when a transcript is imported into a
+// storage whose identity, or whose record of the session, is not the one it
+// was exported with.
+var ErrTranscriptMismatch = errors.New("transcript belongs to another identity")
+
+// MessageSignature is what the sender of a kamune message signed, kept with
+// its chat entry so that who sent it can be checked again later, such as in
+// an exported [Transcript]. It is checked with kamune.VerifyChatEntry.
+type MessageSignature struct {
+	// Metadata and Message are the encoded metadata and content of the
+	// message, exactly as they were signed.
+	Metadata  []byte `json:"metadata"`
+	Message   []byte `json:"message"`
+	Signature []byte `json:"signature"`
+}
+
+func (m *MessageSignature) size() int {
+	return 12 + len(m.Metadata) + len(m.Message) + len(m.Signature)
+}
+
+// append appends the length-prefixed fields of m to b.
+func (m *MessageSignature) append(b []byte) []byte {
+	for _, field := range [][]byte{m.Metadata, m.Message, m.Signature} {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(field)))
+		b = append(b, field...)
+	}
+	return b
+}
+
+// decodeSignedChatEntry is decodeChatEntry for values laid out by
+// AddSignedChatEntry.
+func decodeSignedChatEntry(key, value []byte) (ChatEntry, bool) {
+	if len(value) < 21 {
+		return ChatEntry{}, false
+	}
+	rest := value[21:]
+	var fields [3][]byte
+	for i := range fields {
+		if len(rest) < 4 {
+			return ChatEntry{}, false
+		}
+		n := binary.BigEndian.Uint32(rest)
+		if uint64(len(rest)-4) < uint64(n) {
+			return ChatEntry{}, false
+		}
+		fields[i], rest = rest[4:4+n], rest[4+n:]
+	}
+	return ChatEntry{
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(value[5:13]))),
+		Clock:     binary.BigEndian.Uint64(value[13:21]),
+		Data:      rest,
+		Sender:    Sender(binary.BigEndian.Uint16(key[8:])),
+		Signature: &MessageSignature{
+			Metadata:  fields[0],
+			Message:   fields[1],
+			Signature: fields[2],
+		},
+	}, true
+}
+
+// AddSignedChatEntry is [Storage.AddChatEntryWithClock] for a message whose
+// signature is kept with it, under the magic prefix "KMNE\x04", so that its
+// sender can be verified again later, such as after the history is exported
+// with [Storage.ExportChatHistory]. The signature takes as much space as the
+// message again, or more.
+func (s *Storage) AddSignedChatEntry(
+	sessionID string, payload []byte, ts time.Time, hlc uint64,
+	sender Sender, sig MessageSignature,
+) error {
+	return s.addChatEntry(sessionID, payload, ts, hlc, sender, &sig)
+}
+
+// Transcript is the chat history of a session, as exported by
+// [Storage.ExportChatHistory] and imported by [Storage.ImportChatHistory].
+// It is meant to be encoded as JSON.
+type Transcript struct {
+	Exported  time.Time `json:"exported"`
+	SessionID string    `json:"sessionId"`
+	PeerName  string    `json:"peerName,omitempty"`
+	// PeerKey is the public key of the session's peer, and LocalKey that of
+	// the identity that exported it. The signatures of entries sent by
+	// [SenderPeer] are checked against PeerKey, and those of entries sent by
+	// [SenderLocal] against LocalKey.
+	PeerKey  []byte      `json:"peerKey"`
+	LocalKey []byte      `json:"localKey"`
+	Entries  []ChatEntry `json:"entries"`
+}
+
+// ExportChatHistory returns the chat history of a session with its peer, in
+// the order of [Storage.GetChatHistory]. With signatures, the entries keep
+// the signatures they were recorded with, if any, so that their senders can
+// be verified by whoever reads the transcript; without, the transcript
+// proves nothing, but is smaller and can be shown to others without the
+// signatures binding the participants to its content. It fails with
+// [ErrSessionNotFound] if the session has no record.
+func (s *Storage) ExportChatHistory(
+	sessionID string, signatures bool,
+) (*Transcript, error) {
+	local, err := s.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	m, err := s.GetMeta(sessionID, PeerKey)
+	if err != nil {
+		return nil, err
+	}
+	if m.Value() == nil {
+		return nil, ErrSessionNotFound
+	}
+	entries, err := s.GetChatHistory(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !signatures {
+		for i := range entries {
+			entries[i].Signature = nil
+		}
+	}
+
+	tr := &Transcript{
+		Exported:  s.clock.Now(),
+		SessionID: sessionID,
+		PeerKey:   m.Value(),
+		LocalKey:  local,
+		Entries:   entries,
+	}
+	if peer, err := s.FindPeer(tr.PeerKey); err == nil {
+		tr.PeerName = peer.Name
+	}
+	return tr, nil
+}
+
+// ImportChatHistory adds the entries of tr to its session, creating the
+// session record, and storing its peer, if the storage has neither. Entries
+// the session already holds, with the same timestamp, sender, and content,
+// are skipped, so that a transcript can be imported more than once. It
+// returns the number of entries added.
+//
+// It fails with [ErrTranscriptMismatch] if tr was exported by another
+// identity, or if the storage has recorded the session with another peer.
+// Signatures are imported as they are, without being checked.
+func (s *Storage) ImportChatHistory(tr *Transcript) (int, error) {
+	local, err := s.PublicKey()
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(local, tr.LocalKey) {
+		return 0, ErrTranscriptMismatch
+	}
+	if err := s.ensureTranscriptSession(tr); err != nil {
+		return 0, err
+	}
+
+	existing, err := s.GetChatHistory(tr.SessionID)
+	if err != nil {
+		return 0, err
+	}
+	type entryKey struct {
+		data   string
+		ts     int64
+		sender Sender
+	}
+	seen := make(map[entryKey]bool, len(existing))
+	for _, e := range existing {
+		seen[entryKey{string(e.Data), e.Timestamp.UnixNano(), e.Sender}] = true
+	}
+
+	var added int
+	for _, e := range tr.Entries {
+		k := entryKey{string(e.Data), e.Timestamp.UnixNano(), e.Sender}
+		if seen[k] {
+			continue
+		}
+		err := s.addChatEntry(
+			tr.SessionID, e.Data, e.Timestamp, e.Clock, e.Sender, e.Signature,
+		)
+		if err != nil {
+			return added, fmt.Errorf("importing entry: %w", err)
+		}
+		seen[k] = true
+		added++
+	}
+	return added, nil
+}
+
+// ensureTranscriptSession creates the record of tr's session, and stores its
+// peer, unless the storage has them already.
+func (s *Storage) ensureTranscriptSession(tr *Transcript) error {
+	m, err := s.GetMeta(tr.SessionID, PeerKey)
+	if err != nil {
+		return err
+	}
+	if m.Value() != nil {
+		if !bytes.Equal(m.Value(), tr.PeerKey) {
+			return ErrTranscriptMismatch
+		}
+		return nil
+	}
+
+	if _, err := s.FindPeer(tr.PeerKey); err != nil {
+		err := s.StorePeer(&Peer{Name: tr.PeerName, PublicKey: tr.PeerKey})
+		if err != nil {
+			return fmt.Errorf("storing peer: %w", err)
+		}
+	}
+	return s.CreateSession(tr.SessionID, tr.PeerKey)
+}
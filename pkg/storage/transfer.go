@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// TransferState is what is kept of an unfinished file transfer so that it can
+// be resumed over a later session with the same peer.
+type TransferState struct {
+	// Updated is when the state was last saved.
+	Updated time.Time `json:"updated"`
+	ID      string    `json:"id"`
+	// Path is the file being sent or written to.
+	Path string `json:"path"`
+	Name string `json:"name"`
+	// PeerKey is the public key of the peer on the other end.
+	PeerKey []byte `json:"peerKey"`
+	// Hash is the SHA-256 hash of the whole file.
+	Hash []byte `json:"hash"`
+	// Digest is the state of the hash of the first Offset bytes of the file,
+	// for transfers being received.
+	Digest []byte `json:"digest,omitempty"`
+	Size   uint64 `json:"size"`
+	// Offset is the number of bytes of the file known to be written, for
+	// transfers being received.
+	Offset uint64 `json:"offset,omitempty"`
+	// Outgoing is whether the file is sent rather than received.
+	Outgoing bool `json:"outgoing"`
+}
+
+func transferKey(publicKey []byte, id string) []byte {
+	return append(peerKey(publicKey), id...)
+}
+
+// SaveTransfer stores st under its peer and ID, replacing any state saved
+// before, and sets its Updated time.
+func (s *Storage) SaveTransfer(st TransferState) error {
+	if len(st.PeerKey) == 0 {
+		return ErrInvalidPublicKey
+	}
+	if st.ID == "" {
+		return errors.New("transfer state has no ID")
+	}
+	st.Updated = s.clock.Now()
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("marshalling transfer state: %w", err)
+	}
+	err = s.engine.Command(func(b engine.Namespace) error {
+		return b.Ensure([]byte(engine.TransfersNamespace)).
+			PutEncrypted(transferKey(st.PeerKey, st.ID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("saving transfer state: %w", err)
+	}
+	return nil
+}
+
+// Transfer returns the state saved for the transfer with the given ID to or
+// from the peer owning publicKey, or [ErrNotFound] if there is none.
+func (s *Storage) Transfer(publicKey []byte, id string) (TransferState, error) {
+	var st TransferState
+	err := s.engine.Query(func(b engine.Namespace) error {
+		data, err := b.Sub([]byte(engine.TransfersNamespace)).
+			GetEncrypted(transferKey(publicKey, id))
+		if isMissing(err) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &st); err != nil {
+			return fmt.Errorf("unmarshalling transfer state: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return TransferState{}, fmt.Errorf("getting transfer state: %w", err)
+	}
+	return st, nil
+}
+
+// Transfers returns the states saved for transfers to or from the peer owning
+// publicKey, least recently updated first.
+func (s *Storage) Transfers(publicKey []byte) ([]TransferState, error) {
+	var states []TransferState
+	err := s.engine.Query(func(b engine.Namespace) error {
+		ns := b.Sub([]byte(engine.TransfersNamespace))
+		for _, v := range ns.IterateEncrypted() {
+			var st TransferState
+			if err := json.Unmarshal(v, &st); err != nil {
+				return fmt.Errorf("unmarshalling transfer state: %w", err)
+			}
+			if bytes.Equal(st.PeerKey, publicKey) {
+				states = append(states, st)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing transfer states: %w", err)
+	}
+	slices.SortFunc(states, func(a, b TransferState) int {
+		return cmp.Or(a.Updated.Compare(b.Updated), cmp.Compare(a.ID, b.ID))
+	})
+	return states, nil
+}
+
+// DeleteTransfer deletes the state saved for the transfer with the given ID
+// to or from the peer owning publicKey. Deleting a state that does not exist
+// is not an error.
+func (s *Storage) DeleteTransfer(publicKey []byte, id string) error {
+	err := s.engine.Command(func(b engine.Namespace) error {
+		return b.Sub([]byte(engine.TransfersNamespace)).
+			Delete(transferKey(publicKey, id))
+	})
+	if err != nil && !isMissing(err) {
+		return fmt.Errorf("deleting transfer state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/internal/engine"
+)
+
+// WipeReceipt is the record of a peer wiping its copy of the conversation at
+// the local side's request. It outlives the conversation itself.
+type WipeReceipt struct {
+	// Requested is when the wipe was requested, by the local clock, and
+	// Wiped when the peer carried it out, by its own.
+	Requested time.Time `json:"requested"`
+	Wiped     time.Time `json:"wiped"`
+	ID        string    `json:"id"`
+	// SessionID is the session the wipe was requested on.
+	SessionID string `json:"sessionId"`
+	PeerKey   []byte `json:"peerKey"`
+	// Signature is the peer's signature of the acknowledgment.
+	Signature []byte `json:"signature"`
+}
+
+// WipeConversation deletes every session held with the peer owning publicKey,
+// with their chat history, metadata, resumption state, statistics, and
+// timelines, along with the conversation grouping them and its key, if any.
+// The peer itself stays known. Wiping a conversation that does not exist is
+// not an error.
+func (s *Storage) WipeConversation(publicKey []byte) error {
+	if len(publicKey) == 0 {
+		return ErrInvalidPublicKey
+	}
+	id := conversationID(publicKey)
+	var sessions []string
+	err := s.engine.Command(func(b engine.Namespace) error {
+		if c, err := getConversation(b, id); err == nil {
+			sessions = c.GetSessions()
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		sessions = append(sessions, legacySessions(b, publicKey)...)
+		for _, sid := range sessions {
+			if err := deleteSession(b, sid); err != nil {
+				return err
+			}
+		}
+		for _, ns := range []string{
+			engine.ConversationsNamespace, engine.ChatKeysNamespace,
+		} {
+			err := b.Sub([]byte(ns)).Delete([]byte(id))
+			if err != nil && !isMissing(err) {
+				return err
+			}
+		}
+		timeline := b.Sub([]byte(engine.TimelineNamespace))
+		if err := wipeTimeline(timeline, sessions); err != nil {
+			return err
+		}
+		return wipeStats(b.Sub([]byte(engine.StatsNamespace)), publicKey)
+	})
+	if err != nil {
+		return fmt.Errorf("wiping conversation: %w", err)
+	}
+
+	events := make([]Event, 0, len(sessions))
+	for _, sid := range sessions {
+		events = append(events, sessionEvent(EventDeleted, sid))
+	}
+	s.notify(events...)
+	return nil
+}
+
+// wipeStats deletes the stats records of sessions held with the peer owning
+// publicKey.
+func wipeStats(stats engine.Namespace, publicKey []byte) error {
+	var keys [][]byte
+	for key, value := range stats.IterateEncrypted() {
+		var st pb.SessionStats
+		err := proto.Unmarshal(value, &st)
+		if err == nil && bytes.Equal(st.GetPeerKey(), publicKey) {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		if err := stats.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordWipeReceipt stores r. The caller is responsible for checking the
+// signature.
+func (s *Storage) RecordWipeReceipt(r WipeReceipt) error {
+	if len(r.PeerKey) == 0 {
+		return ErrInvalidPublicKey
+	}
+	if r.ID == "" {
+		return errors.New("wipe receipt has no ID")
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshalling wipe receipt: %w", err)
+	}
+	err = s.engine.Command(func(b engine.Namespace) error {
+		return b.Ensure([]byte(engine.WipesNamespace)).
+			PutEncrypted([]byte(r.ID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("recording wipe receipt: %w", err)
+	}
+	return nil
+}
+
+// WipeReceipts returns the receipts recorded for wipes by the peer owning
+// publicKey, oldest first.
+func (s *Storage) WipeReceipts(publicKey []byte) ([]WipeReceipt, error) {
+	var receipts []WipeReceipt
+	err := s.engine.Query(func(b engine.Namespace) error {
+		wipes := b.Sub([]byte(engine.WipesNamespace))
+		for _, v := range wipes.IterateEncrypted() {
+			var r WipeReceipt
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("unmarshalling wipe receipt: %w", err)
+			}
+			if bytes.Equal(r.PeerKey, publicKey) {
+				receipts = append(receipts, r)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing wipe receipts: %w", err)
+	}
+	slices.SortFunc(receipts, func(a, b WipeReceipt) int {
+		return cmp.Or(
+			a.Requested.Compare(b.Requested), cmp.Compare(a.ID, b.ID),
+		)
+	})
+	return receipts, nil
+}
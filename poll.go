@@ -0,0 +1,151 @@
+package kamune
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// wait is how long a receive waits for the next frame. The zero value waits
+// until one arrives.
+type wait struct {
+	// deadline, if set, is when to give up with ErrReceiveTimeout.
+	deadline time.Time
+	// poll gives up with ErrNoMessage if no frame has been read yet.
+	poll bool
+}
+
+// frameReader reads a session's frames on a goroutine of its own, for
+// receives that must not block past a deadline. It reads one frame ahead of
+// the application at most, so a session nobody receives from is not read
+// either, and its peer is held back by the connection's flow control as it
+// would be otherwise.
+type frameReader struct {
+	frames chan frameResult
+	done   chan struct{}
+	// err is the error that ended the reads. It is set before frames is
+	// closed.
+	err      error
+	initOnce sync.Once
+	runOnce  sync.Once
+	stopOnce sync.Once
+	running  atomic.Bool
+}
+
+type frameResult struct {
+	err     error
+	payload []byte
+}
+
+func (r *frameReader) init() {
+	r.initOnce.Do(func() {
+		r.frames = make(chan frameResult)
+		r.done = make(chan struct{})
+	})
+}
+
+// run starts read in the background, unless it has already been started.
+func (r *frameReader) run(read func() ([]byte, error)) {
+	r.init()
+	r.runOnce.Do(func() {
+		r.running.Store(true)
+		go r.loop(read)
+	})
+}
+
+func (r *frameReader) loop(read func() ([]byte, error)) {
+	for {
+		payload, err := read()
+		select {
+		case r.frames <- frameResult{payload: payload, err: err}:
+		case <-r.done:
+			return
+		}
+		// An idle connection times out before anything is read from it, and
+		// may still be read from afterwards.
+		if err != nil && !errors.Is(err, ErrReceiveTimeout) {
+			r.err = err
+			close(r.frames)
+			return
+		}
+	}
+}
+
+// stop makes pending and later waits for a frame fail with ErrConnClosed.
+func (r *frameReader) stop() {
+	r.init()
+	r.stopOnce.Do(func() { close(r.done) })
+}
+
+// next returns the next frame read in the background, waiting for it as w
+// allows.
+func (r *frameReader) next(w wait) ([]byte, error) {
+	select {
+	case res, ok := <-r.frames:
+		return r.result(res, ok)
+	case <-r.done:
+		return nil, ErrConnClosed
+	default:
+	}
+	if w.poll {
+		return nil, ErrNoMessage
+	}
+
+	var timeout <-chan time.Time
+	if !w.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(w.deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case res, ok := <-r.frames:
+		return r.result(res, ok)
+	case <-r.done:
+		return nil, ErrConnClosed
+	case <-timeout:
+		return nil, ErrReceiveTimeout
+	}
+}
+
+func (r *frameReader) result(res frameResult, ok bool) ([]byte, error) {
+	if !ok {
+		return nil, r.err
+	}
+	return res.payload, res.err
+}
+
+// nextPayload returns the next frame of the session, waiting for it as w
+// allows. Until a receive has had to bound its wait, frames are read from
+// the connection directly.
+func (t *Transport) nextPayload(w wait) ([]byte, error) {
+	if w == (wait{}) && !t.reader.running.Load() {
+		return t.readPayload()
+	}
+	t.reader.run(t.readPayload)
+	return t.reader.next(w)
+}
+
+// ReceiveDeadline is like [Transport.Receive], but fails with
+// [ErrReceiveTimeout] if no message has arrived by deadline. A zero deadline
+// waits as long as Receive does.
+//
+// ReceiveDeadline and [Transport.TryReceive] let an event loop serve sessions
+// without a goroutine blocked in Receive for each. The first call to either
+// starts reading the session's frames in the background, and from then on
+// Receive takes messages from the same reader. It reads no more than a frame
+// ahead of the application, so a session that is not received from still
+// pushes back on its peer. As with Receive, only one goroutine may receive
+// from a session at a time.
+func (t *Transport) ReceiveDeadline(
+	dst Transferable, deadline time.Time,
+) (*Metadata, error) {
+	return t.receiveInto(dst, wait{deadline: deadline})
+}
+
+// TryReceive returns the next message if it has already arrived, and fails
+// with [ErrNoMessage] otherwise, without waiting on the connection. See
+// [Transport.ReceiveDeadline].
+func (t *Transport) TryReceive(dst Transferable) (*Metadata, error) {
+	return t.receiveInto(dst, wait{poll: true})
+}
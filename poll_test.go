@@ -0,0 +1,85 @@
+package kamune
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransport_TryReceive(t *testing.T) {
+	a := require.New(t)
+	addr, _, _ := startEchoServer(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+
+	_, err = tr.TryReceive(Bytes(nil))
+	a.ErrorIs(err, ErrNoMessage)
+
+	for _, text := range []string{"one", "two"} {
+		_, err = tr.Send(Bytes([]byte(text)), RouteExchangeMessages)
+		a.NoError(err)
+	}
+	for _, want := range []string{"one", "two"} {
+		reply := Bytes(nil)
+		a.Eventually(func() bool {
+			_, err = tr.TryReceive(reply)
+			return err == nil
+		}, 5*time.Second, time.Millisecond)
+		a.Equal(want, string(reply.Value))
+	}
+	_, err = tr.TryReceive(Bytes(nil))
+	a.ErrorIs(err, ErrNoMessage)
+
+	// Receive keeps working once frames are read in the background.
+	echo(t, tr, "blocking")
+}
+
+func TestTransport_ReceiveDeadline(t *testing.T) {
+	a := require.New(t)
+	addr, _, _ := startEchoServer(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+
+	start := time.Now()
+	_, err = tr.ReceiveDeadline(Bytes(nil), start.Add(50*time.Millisecond))
+	a.ErrorIs(err, ErrReceiveTimeout)
+	a.GreaterOrEqual(time.Since(start), 50*time.Millisecond)
+	_, err = tr.ReceiveDeadline(Bytes(nil), time.Now().Add(-time.Second))
+	a.ErrorIs(err, ErrReceiveTimeout)
+
+	// A timed out receive leaves the session usable.
+	_, err = tr.Send(Bytes([]byte("late")), RouteExchangeMessages)
+	a.NoError(err)
+	reply := Bytes(nil)
+	md, err := tr.ReceiveDeadline(reply, time.Now().Add(5*time.Second))
+	a.NoError(err)
+	a.Equal(RouteExchangeMessages, md.Route())
+	a.Equal("late", string(reply.Value))
+
+	// Closing the session ends pending and later receives.
+	received := make(chan error, 1)
+	go func() {
+		_, err := tr.ReceiveDeadline(Bytes(nil), time.Time{})
+		received <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	a.NoError(tr.Close())
+	select {
+	case err := <-received:
+		a.ErrorIs(err, ErrConnClosed)
+	case <-time.After(5 * time.Second):
+		a.Fail("receive did not return after close")
+	}
+	_, err = tr.TryReceive(Bytes(nil))
+	a.ErrorIs(err, ErrConnClosed)
+}
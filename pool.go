@@ -0,0 +1,347 @@
+package kamune
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/clock"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// HealthCheck reports whether a pooled transport is still usable. The pool
+// does not read from its transports, since their messages belong to the
+// application; a check that sends a ping must rely on the application's
+// receive loop to observe the pong.
+type HealthCheck func(t *Transport) error
+
+// DialerPool maintains sessions to many servers from a single identity and
+// storage, for hub-style applications such as bots and bridges.
+// [DialerPool.Get] returns the live session to an address, dialing it on
+// first use. When a session is lost, the next Get resumes it (see
+// [DialWithResume]) and falls back to a fresh handshake if the server
+// refuses.
+//
+// The pool records every new session with [storage.Storage.CreateSession],
+// which needs the server's identity to be in storage, typically stored by the
+// [RemoteVerifier]. Sessions with unknown servers cannot be resumed and are
+// replaced by a fresh handshake instead.
+//
+// Sessions left unused for longer than the idle timeout are closed, as are
+// those failing the health check; both are resumed on demand.
+type DialerPool struct {
+	clock          clock.Clock
+	dialer         *Dialer
+	healthCheck    HealthCheck
+	entries        map[string]*poolEntry
+	stop           chan struct{}
+	dialOpts       []DialOption
+	wg             sync.WaitGroup
+	idleTimeout    time.Duration
+	healthInterval time.Duration
+	mu             sync.Mutex
+	closed         bool
+}
+
+// poolEntry is the state of a single target. Its session ID outlives the
+// transport so that a lost session can be resumed.
+type poolEntry struct {
+	lastUsed  time.Time
+	t         *Transport
+	addr      string
+	sessionID string
+	mu        sync.Mutex
+}
+
+// PoolOption configures a [DialerPool].
+type PoolOption func(*DialerPool) error
+
+// NewDialerPool creates a pool whose sessions share the identity held by
+// store. Targets are dialed with the given [RemoteVerifier] and the options
+// set through [PoolWithDialOptions].
+func NewDialerPool(
+	store *storage.Storage, rv RemoteVerifier, opts ...PoolOption,
+) (*DialerPool, error) {
+	p := &DialerPool{
+		clock:   clock.Real(),
+		entries: make(map[string]*poolEntry),
+		stop:    make(chan struct{}),
+	}
+	for _, o := range opts {
+		if err := o(p); err != nil {
+			return nil, err
+		}
+	}
+
+	d, err := NewDialer("", store, rv, p.dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating dialer: %w", err)
+	}
+	d.handshakeOpts.sessionID = ""
+	p.dialer = d
+
+	if interval := p.sweepInterval(); interval > 0 {
+		p.wg.Add(1)
+		go p.maintain(interval)
+	}
+
+	return p, nil
+}
+
+// Get returns the live session to addr, establishing or resuming one if
+// needed. Concurrent calls for the same address share a single dial.
+func (p *DialerPool) Get(addr string) (*Transport, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrClosedPool
+	}
+	e, ok := p.entries[addr]
+	if !ok {
+		e = &poolEntry{addr: addr}
+		p.entries[addr] = e
+	}
+	p.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.t != nil && p.alive(e.t) {
+		e.lastUsed = p.clock.Now()
+		return e.t, nil
+	}
+
+	t, err := p.connect(e)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		_ = t.Close()
+		return nil, ErrClosedPool
+	}
+
+	e.t = t
+	e.sessionID = t.SessionID()
+	e.lastUsed = p.clock.Now()
+	return t, nil
+}
+
+// connect resumes the entry's previous session, if it has one, or else runs
+// a fresh handshake. Caller must hold e.mu.
+func (p *DialerPool) connect(e *poolEntry) (*Transport, error) {
+	d := *p.dialer
+	d.address = e.addr
+	if e.sessionID != "" {
+		d.handshakeOpts.sessionID = e.sessionID
+		t, err := d.Dial()
+		if err == nil {
+			return t, nil
+		}
+		slog.Debug(
+			"resumption failed, dialing a new session",
+			slog.String("addr", e.addr),
+			slog.String("session_id", e.sessionID),
+			slog.Any("error", err),
+		)
+		d.handshakeOpts.sessionID = ""
+	}
+	return d.Dial()
+}
+
+// alive reports whether t has not been closed. Closed transports, including
+// those of blocked peers, leave the dialer's registry.
+func (p *DialerPool) alive(t *Transport) bool {
+	live, ok := p.dialer.registry.Get(t.SessionID())
+	return ok && live == t
+}
+
+// Remove closes the session to addr, if any, and forgets the target so that
+// the next Get runs a fresh handshake.
+func (p *DialerPool) Remove(addr string) error {
+	p.mu.Lock()
+	e, ok := p.entries[addr]
+	delete(p.entries, addr)
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.t == nil {
+		return nil
+	}
+	err := e.t.Close()
+	e.t = nil
+	return err
+}
+
+// Len returns the number of live sessions in the pool.
+func (p *DialerPool) Len() int {
+	return p.dialer.registry.Len()
+}
+
+// SessionRegistry returns the registry of the pool's live sessions.
+func (p *DialerPool) SessionRegistry() *SessionRegistry {
+	return p.dialer.registry
+}
+
+// Close stops the pool's maintenance and closes all of its sessions. The
+// storage is left open.
+func (p *DialerPool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	entries := slices.Collect(maps.Values(p.entries))
+	p.entries = nil
+	p.mu.Unlock()
+
+	close(p.stop)
+	p.wg.Wait()
+
+	for _, e := range entries {
+		e.mu.Lock()
+		if e.t != nil {
+			_ = e.t.Close()
+			e.t = nil
+		}
+		e.mu.Unlock()
+	}
+	return nil
+}
+
+// sweepInterval returns how often maintenance runs, or zero if neither idle
+// eviction nor health checking is enabled.
+func (p *DialerPool) sweepInterval() time.Duration {
+	interval := p.healthInterval
+	if p.idleTimeout > 0 && (interval == 0 || p.idleTimeout/2 < interval) {
+		interval = p.idleTimeout / 2
+	}
+	return interval
+}
+
+func (p *DialerPool) maintain(interval time.Duration) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.sweep()
+		}
+	}
+}
+
+// sweep closes idle and unhealthy sessions, and forgets targets whose last
+// session can no longer be resumed. Entries busy dialing are skipped.
+func (p *DialerPool) sweep() {
+	p.mu.Lock()
+	entries := slices.Collect(maps.Values(p.entries))
+	p.mu.Unlock()
+
+	now := p.clock.Now()
+	for _, e := range entries {
+		if !e.mu.TryLock() {
+			continue
+		}
+		if e.t != nil && !p.alive(e.t) {
+			e.t = nil
+		}
+		switch {
+		case e.t == nil:
+			if now.Sub(e.lastUsed) > resumptionGracePeriod {
+				p.forget(e)
+			}
+		case p.idleTimeout > 0 && now.Sub(e.lastUsed) >= p.idleTimeout:
+			slog.Debug(
+				"closing idle pooled session",
+				slog.String("addr", e.addr),
+				slog.String("session_id", e.sessionID),
+			)
+			_ = e.t.Close()
+			e.t = nil
+		case p.healthCheck != nil:
+			if err := p.healthCheck(e.t); err != nil {
+				slog.Warn(
+					"closing unhealthy pooled session",
+					slog.String("addr", e.addr),
+					slog.String("session_id", e.sessionID),
+					slog.Any("error", err),
+				)
+				_ = e.t.Close()
+				e.t = nil
+			}
+		}
+		e.mu.Unlock()
+	}
+}
+
+// forget drops e from the pool unless it has been replaced meanwhile.
+func (p *DialerPool) forget(e *poolEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.entries[e.addr] == e {
+		delete(p.entries, e.addr)
+	}
+}
+
+// PoolWithDialOptions sets the options used to dial every target. A session
+// ID given through [DialWithResume] is ignored; the pool tracks sessions per
+// target itself.
+func PoolWithDialOptions(opts ...DialOption) PoolOption {
+	return func(p *DialerPool) error {
+		p.dialOpts = append(p.dialOpts, opts...)
+		return nil
+	}
+}
+
+// PoolWithIdleTimeout closes sessions that have not been returned by
+// [DialerPool.Get] for the given duration. Zero, the default, keeps them open
+// until they fail.
+func PoolWithIdleTimeout(timeout time.Duration) PoolOption {
+	return func(p *DialerPool) error {
+		if timeout < 0 {
+			return fmt.Errorf("idle timeout must be non-negative")
+		}
+		p.idleTimeout = timeout
+		return nil
+	}
+}
+
+// PoolWithHealthCheck runs check against every live session at the given
+// interval and closes those for which it fails. Without a health check a
+// session is only replaced once it has been closed, for instance by the
+// application after a failed receive.
+func PoolWithHealthCheck(interval time.Duration, check HealthCheck) PoolOption {
+	return func(p *DialerPool) error {
+		if interval <= 0 {
+			return fmt.Errorf("health check interval must be positive")
+		}
+		if check == nil {
+			return fmt.Errorf("health check must not be nil")
+		}
+		p.healthInterval = interval
+		p.healthCheck = check
+		return nil
+	}
+}
+
+// PoolWithClock sets a custom clock for the pool. It is primarily useful for
+// tests of idle eviction.
+func PoolWithClock(c clock.Clock) PoolOption {
+	return func(p *DialerPool) error {
+		p.clock = c
+		return nil
+	}
+}
@@ -0,0 +1,177 @@
+package kamune
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/internal/clock"
+)
+
+// startSessionServer starts an echo server that stores its peers, so that
+// their sessions are recorded and can be resumed.
+func startSessionServer(t *testing.T, opts ...ServerOptions) string {
+	t.Helper()
+	_, addr, _ := startTestServer(
+		t, NewEchoHandler(), nil, storePeer, opts...,
+	)
+	return addr
+}
+
+func TestDialerPool(t *testing.T) {
+	a := require.New(t)
+	addr1 := startSessionServer(t)
+	addr2 := startSessionServer(t)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	p, err := NewDialerPool(store, storePeer)
+	a.NoError(err)
+	defer p.Close()
+
+	t1, err := p.Get(addr1)
+	a.NoError(err)
+	again, err := p.Get(addr1)
+	a.NoError(err)
+	a.Same(t1, again)
+	t2, err := p.Get(addr2)
+	a.NoError(err)
+	a.NotEqual(t1.SessionID(), t2.SessionID())
+	a.Equal(2, p.Len())
+	echo(t, t1, "first")
+	echo(t, t2, "second")
+
+	// A lost session is resumed on the next Get.
+	a.NoError(t1.Close())
+	resumed, err := p.Get(addr1)
+	a.NoError(err)
+	a.NotSame(t1, resumed)
+	a.Equal(t1.SessionID(), resumed.SessionID())
+	echo(t, resumed, "resumed")
+
+	// A removed target starts over with a fresh handshake.
+	a.NoError(p.Remove(addr2))
+	a.Equal(1, p.Len())
+	fresh, err := p.Get(addr2)
+	a.NoError(err)
+	a.NotEqual(t2.SessionID(), fresh.SessionID())
+
+	a.NoError(p.Close())
+	a.Zero(p.Len())
+	_, err = p.Get(addr1)
+	a.ErrorIs(err, ErrClosedPool)
+}
+
+func TestDialerPool_ResumptionFallback(t *testing.T) {
+	a := require.New(t)
+	addr := startSessionServer(t, ServeWithResumeEnabled(false))
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	p, err := NewDialerPool(store, storePeer)
+	a.NoError(err)
+	defer p.Close()
+
+	tr, err := p.Get(addr)
+	a.NoError(err)
+	a.NoError(tr.Close())
+
+	fresh, err := p.Get(addr)
+	a.NoError(err)
+	a.NotEqual(tr.SessionID(), fresh.SessionID())
+	echo(t, fresh, "fresh")
+}
+
+func TestDialerPool_Sweep(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []PoolOption
+		idle   time.Duration
+		closed bool
+	}{
+		{name: "active", idle: 30 * time.Second},
+		{name: "idle", idle: 2 * time.Minute, closed: true},
+		{
+			name: "healthy",
+			opts: []PoolOption{PoolWithHealthCheck(
+				time.Hour, func(*Transport) error { return nil },
+			)},
+		},
+		{
+			name: "unhealthy",
+			opts: []PoolOption{PoolWithHealthCheck(
+				time.Hour, func(*Transport) error {
+					return errors.New("no pong")
+				},
+			)},
+			closed: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			addr := startSessionServer(t)
+
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			c := clock.NewFake(time.Now())
+			p, err := NewDialerPool(
+				store, storePeer, append(tc.opts,
+					PoolWithClock(c), PoolWithIdleTimeout(time.Minute),
+				)...,
+			)
+			a.NoError(err)
+			defer p.Close()
+
+			tr, err := p.Get(addr)
+			a.NoError(err)
+			echo(t, tr, "hello")
+			c.Advance(tc.idle)
+			p.sweep()
+
+			if !tc.closed {
+				a.Equal(1, p.Len())
+				echo(t, tr, "kept")
+				return
+			}
+			a.Zero(p.Len())
+			resumed, err := p.Get(addr)
+			a.NoError(err)
+			a.Equal(tr.SessionID(), resumed.SessionID())
+		})
+	}
+}
+
+func TestDialerPool_ConcurrentGet(t *testing.T) {
+	a := require.New(t)
+	addr, handlers, _ := startEchoServer(t)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	p, err := NewDialerPool(store, storePeer)
+	a.NoError(err)
+	defer p.Close()
+
+	var failed atomic.Int32
+	results := make(chan *Transport, 8)
+	for range cap(results) {
+		go func() {
+			tr, err := p.Get(addr)
+			if err != nil {
+				failed.Add(1)
+			}
+			results <- tr
+		}()
+	}
+	first := <-results
+	for range cap(results) - 1 {
+		a.Same(first, <-results)
+	}
+	a.Zero(failed.Load())
+	a.Eventually(func() bool {
+		return handlers.Load() == 1
+	}, time.Second, 10*time.Millisecond)
+}
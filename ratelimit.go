@@ -0,0 +1,163 @@
+package kamune
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// RateLimitAction is what a session does with a message that the peer sends
+// beyond its [RateLimit].
+type RateLimitAction int
+
+const (
+	// RateLimitError consumes the message and reports it through
+	// [Transport.Receive] as [ErrRateLimited]. The session stays usable, and
+	// the handler decides whether to carry on.
+	RateLimitError RateLimitAction = iota
+	// RateLimitDrop silently discards the message.
+	RateLimitDrop
+	// RateLimitDelay holds the message back until the bucket refills, which
+	// stops reading from the connection and so slows the peer down.
+	RateLimitDelay
+)
+
+// RateLimit is a token bucket that refills at Rate tokens per second up to
+// Burst tokens. Each message the peer sends takes a token; control messages
+// (ping, pong, and close) and transfers are exempt, since transfers are
+// already throttled by their offers. Applications may take tokens from the
+// same bucket with [Transport.Allow] and [Transport.Reserve], for instance to
+// charge more for expensive requests.
+type RateLimit struct {
+	Rate   float64
+	Burst  int
+	Action RateLimitAction
+}
+
+func (l RateLimit) validate() error {
+	switch {
+	case l.Rate <= 0:
+		return errors.New("rate limit must be positive")
+	case l.Burst < 1:
+		return errors.New("rate limit burst must be at least one")
+	case l.Action < RateLimitError || l.Action > RateLimitDelay:
+		return fmt.Errorf("invalid rate limit action: %d", l.Action)
+	}
+	return nil
+}
+
+// rateLimiter is a session's token bucket.
+type rateLimiter struct {
+	last   time.Time
+	limit  RateLimit
+	tokens float64
+	mu     sync.Mutex
+}
+
+func newRateLimiter(l RateLimit) *rateLimiter {
+	return &rateLimiter{
+		last:   time.Now(),
+		limit:  l,
+		tokens: float64(l.Burst),
+	}
+}
+
+// refill adds the tokens earned since the last call. It must be called with
+// mu held.
+func (r *rateLimiter) refill(now time.Time) {
+	r.tokens += now.Sub(r.last).Seconds() * r.limit.Rate
+	r.tokens = min(r.tokens, float64(r.limit.Burst))
+	r.last = now
+}
+
+func (r *rateLimiter) allow(n int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refill(time.Now())
+	if r.tokens < float64(n) {
+		return false
+	}
+	r.tokens -= float64(n)
+	return true
+}
+
+func (r *rateLimiter) reserve(n int) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refill(time.Now())
+	r.tokens -= float64(n)
+	if r.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-r.tokens / r.limit.Rate * float64(time.Second))
+}
+
+// limit gives the session a rate limit bucket for l. A nil l leaves the
+// session unlimited.
+func (t *Transport) limit(l *RateLimit) {
+	if l != nil {
+		t.limiter = newRateLimiter(*l)
+	}
+}
+
+// Allow takes n tokens from the session's rate limit bucket if it holds that
+// many, and reports whether it did. It always reports true for sessions
+// without a rate limit. See [ServeWithRateLimit].
+func (t *Transport) Allow(n int) bool {
+	if t.limiter == nil {
+		return true
+	}
+	return t.limiter.allow(n)
+}
+
+// Reserve takes n tokens from the session's rate limit bucket, going into
+// debt if it holds fewer, and returns how long to wait before acting on
+// them. Messages the peer sends meanwhile are limited as if the tokens were
+// already spent. It always returns zero for sessions without a rate limit.
+func (t *Transport) Reserve(n int) time.Duration {
+	if t.limiter == nil {
+		return 0
+	}
+	return t.limiter.reserve(n)
+}
+
+// throttle applies the session's rate limit to a message received on route.
+// It reports whether the message is to be dropped, and returns
+// [ErrRateLimited] if it is to be reported instead.
+func (t *Transport) throttle(route Route) (drop bool, err error) {
+	return t.throttleWith(t.limiter, route)
+}
+
+// throttleWith is throttle with the bucket l, which may be nil.
+func (t *Transport) throttleWith(
+	l *rateLimiter, route Route,
+) (drop bool, err error) {
+	if l == nil || priorityForRoute(route) == PriorityControl {
+		return false, nil
+	}
+
+	switch l.limit.Action {
+	case RateLimitDelay:
+		time.Sleep(l.reserve(1))
+		return false, nil
+	case RateLimitDrop:
+		if l.allow(1) {
+			return false, nil
+		}
+		t.stats.rateLimited.Add(1)
+		slog.Debug(
+			"rate limited message dropped",
+			slog.String("session_id", t.sessionID),
+			slog.String("route", route.String()),
+		)
+		return true, nil
+	default:
+		if l.allow(1) {
+			return false, nil
+		}
+		t.stats.rateLimited.Add(1)
+		return false, fmt.Errorf("%w: %s", ErrRateLimited, route)
+	}
+}
@@ -0,0 +1,161 @@
+package kamune
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// receiveResult is what a server handler got from a single Receive call.
+type receiveResult struct {
+	err  error
+	text string
+}
+
+// startLimitedServer runs a server that limits its sessions to l and sends
+// what each Receive call returned on the returned channel, followed by the
+// session's stats once it ends.
+func startLimitedServer(
+	t *testing.T, l RateLimit,
+) (string, <-chan receiveResult, <-chan TransportStats) {
+	t.Helper()
+	messages := make(chan receiveResult, 16)
+	stats := make(chan TransportStats, 1)
+	handler := func(tr *Transport) error {
+		defer func() { stats <- tr.Stats() }()
+		for {
+			msg := Bytes(nil)
+			_, err := tr.Receive(msg)
+			if errors.Is(err, ErrConnClosed) ||
+				errors.Is(err, ErrPeerDisconnected) {
+				return nil
+			}
+			messages <- receiveResult{err: err, text: string(msg.GetValue())}
+		}
+	}
+
+	_, addr, _ := startTestServer(
+		t, handler, nil, acceptAll, ServeWithRateLimit(l),
+	)
+	return addr, messages, stats
+}
+
+func TestServeWithRateLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   RateLimit
+		want    []receiveResult
+		limited uint64
+		// minElapsed is the least time the messages take to arrive.
+		minElapsed time.Duration
+	}{
+		{
+			name:  "error",
+			limit: RateLimit{Rate: 0.1, Burst: 2, Action: RateLimitError},
+			want: []receiveResult{
+				{text: "1"}, {text: "2"},
+				{err: ErrRateLimited}, {err: ErrRateLimited},
+				{text: "ping"},
+			},
+			limited: 2,
+		},
+		{
+			name:  "drop",
+			limit: RateLimit{Rate: 0.1, Burst: 2, Action: RateLimitDrop},
+			want: []receiveResult{
+				{text: "1"}, {text: "2"}, {text: "ping"},
+			},
+			limited: 2,
+		},
+		{
+			name:  "delay",
+			limit: RateLimit{Rate: 20, Burst: 2, Action: RateLimitDelay},
+			want: []receiveResult{
+				{text: "1"}, {text: "2"}, {text: "3"}, {text: "4"},
+				{text: "ping"},
+			},
+			minElapsed: 80 * time.Millisecond,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			addr, messages, stats := startLimitedServer(t, tc.limit)
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			d, err := NewDialer(addr, store, acceptAll)
+			a.NoError(err)
+			tr, err := d.Dial()
+			a.NoError(err)
+
+			start := time.Now()
+			for _, text := range []string{"1", "2", "3", "4"} {
+				_, err := tr.Send(Bytes([]byte(text)), RouteExchangeMessages)
+				a.NoError(err)
+			}
+			// Control messages are never limited.
+			_, err = tr.Send(Bytes([]byte("ping")), RoutePing)
+			a.NoError(err)
+
+			for _, want := range tc.want {
+				got := <-messages
+				if want.err != nil {
+					a.ErrorIs(got.err, want.err)
+					continue
+				}
+				a.NoError(got.err)
+				a.Equal(want.text, got.text)
+			}
+			a.GreaterOrEqual(time.Since(start), tc.minElapsed)
+			a.NoError(tr.Close())
+			a.Equal(tc.limited, (<-stats).RateLimited)
+		})
+	}
+}
+
+func TestTransport_AllowReserve(t *testing.T) {
+	a := require.New(t)
+	tr := &Transport{}
+	a.True(tr.Allow(1000))
+	a.Zero(tr.Reserve(1000))
+
+	tr.limit(&RateLimit{Rate: 10, Burst: 5})
+	a.True(tr.Allow(3))
+	a.False(tr.Allow(3))
+	a.True(tr.Allow(2))
+	// The bucket is empty, so ten tokens are a second away.
+	wait := tr.Reserve(10)
+	a.InDelta(time.Second, wait, float64(50*time.Millisecond))
+	a.False(tr.Allow(1))
+}
+
+func TestRateLimitOptions(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	tests := []struct {
+		name  string
+		limit RateLimit
+	}{
+		{name: "zero rate", limit: RateLimit{Burst: 1}},
+		{name: "zero burst", limit: RateLimit{Rate: 1}},
+		{
+			name:  "invalid action",
+			limit: RateLimit{Rate: 1, Burst: 1, Action: RateLimitAction(9)},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			_, err := NewServer(
+				"", nil, store, acceptAll, ServeWithRateLimit(tc.limit),
+			)
+			a.Error(err)
+			_, err = NewDialer(
+				"127.0.0.1:1", store, acceptAll, DialWithRateLimit(tc.limit),
+			)
+			a.Error(err)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package kamune
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// SessionRegistry indexes the live sessions of a [Server] or a [Dialer] by
+// session ID and by peer fingerprint. It lets code running outside a handler
+// goroutine, such as a webhook or a scheduler, find a connected peer's
+// [Transport] and push messages to it. [Transport.Send] is safe for concurrent
+// use, so the returned transports may be written to while their handler is
+// running.
+//
+// A session is registered once its handshake or resumption completes and
+// removed when its handler returns, or for a dialer, when it is closed. A
+// transport obtained from the registry may therefore be closed at any time;
+// callers must handle send errors.
+//
+// While it holds any session, the registry watches the storage for blocked
+// peers (see [storage.Storage.BlockPeer]) and closes their sessions at once.
+type SessionRegistry struct {
+	store   *storage.Storage
+	byID    map[string]*Transport
+	byPeer  map[string]map[string]*Transport
+	unwatch func()
+	mu      sync.RWMutex
+}
+
+func newSessionRegistry(store *storage.Storage) *SessionRegistry {
+	return &SessionRegistry{
+		store:  store,
+		byID:   make(map[string]*Transport),
+		byPeer: make(map[string]map[string]*Transport),
+	}
+}
+
+// Get returns the live session with the given ID.
+func (r *SessionRegistry) Get(sessionID string) (*Transport, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.byID[sessionID]
+	return t, ok
+}
+
+// ByPeer returns the live sessions of the peer with the given fingerprint, as
+// computed by [fingerprint.Sum] over its public key. A peer may hold several
+// sessions at once.
+func (r *SessionRegistry) ByPeer(fp string) []*Transport {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sessions := make([]*Transport, 0, len(r.byPeer[fp]))
+	for _, t := range r.byPeer[fp] {
+		sessions = append(sessions, t)
+	}
+	return sessions
+}
+
+// ByAlias returns the live sessions of the peer that alias is assigned to in
+// the storage, see [storage.Storage.SetAlias], or none if it is unassigned.
+// Unlike session IDs, aliases stay the same across reconnections.
+func (r *SessionRegistry) ByAlias(alias string) []*Transport {
+	if r.store == nil {
+		return nil
+	}
+	publicKey, err := r.store.ResolveAlias(alias)
+	if err != nil {
+		return nil
+	}
+	return r.ByPeer(fingerprint.Sum(publicKey))
+}
+
+// Sessions returns all live sessions, in no particular order.
+func (r *SessionRegistry) Sessions() []*Transport {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sessions := make([]*Transport, 0, len(r.byID))
+	for _, t := range r.byID {
+		sessions = append(sessions, t)
+	}
+	return sessions
+}
+
+// Len returns the number of live sessions.
+func (r *SessionRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.byID)
+}
+
+// add registers t, replacing any previous session with the same ID. If the
+// peer was blocked while its handshake was in flight, t is closed right away.
+func (r *SessionRegistry) add(t *Transport) {
+	fp := fingerprint.Sum(t.remotePeer.PublicKey)
+
+	r.mu.Lock()
+	if prev, ok := r.byID[t.sessionID]; ok {
+		r.removeLocked(prev)
+	}
+	r.byID[t.sessionID] = t
+	peer, ok := r.byPeer[fp]
+	if !ok {
+		peer = make(map[string]*Transport)
+		r.byPeer[fp] = peer
+	}
+	peer[t.sessionID] = t
+	if r.unwatch == nil && r.store != nil {
+		r.unwatch = r.store.OnBlock(r.closePeer)
+	}
+	r.mu.Unlock()
+
+	if r.store == nil {
+		return
+	}
+	if blocked, _ := r.store.IsBlocked(t.remotePeer.PublicKey); blocked {
+		r.closeSession(t)
+	}
+}
+
+// remove unregisters t. It is a no-op if t has since been replaced by another
+// transport with the same session ID.
+func (r *SessionRegistry) remove(t *Transport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.byID[t.sessionID] == t {
+		r.removeLocked(t)
+	}
+}
+
+// removeLocked drops t from both indexes. Caller must hold r.mu.
+func (r *SessionRegistry) removeLocked(t *Transport) {
+	delete(r.byID, t.sessionID)
+	fp := fingerprint.Sum(t.remotePeer.PublicKey)
+	if peer, ok := r.byPeer[fp]; ok {
+		delete(peer, t.sessionID)
+		if len(peer) == 0 {
+			delete(r.byPeer, fp)
+		}
+	}
+	if len(r.byID) == 0 && r.unwatch != nil {
+		r.unwatch()
+		r.unwatch = nil
+	}
+}
+
+// closePeer closes every live session of the peer with the given public key.
+func (r *SessionRegistry) closePeer(publicKey []byte) {
+	for _, t := range r.ByPeer(fingerprint.Sum(publicKey)) {
+		r.closeSession(t)
+	}
+}
+
+func (r *SessionRegistry) closeSession(t *Transport) {
+	slog.Info(
+		"closing session of blocked peer",
+		slog.String("session_id", t.SessionID()),
+	)
+	r.remove(t)
+	_ = t.Close()
+}
+
+// checkBlocked returns [ErrPeerBlocked] if the peer with the given public key
+// is on the blocklist of store.
+func checkBlocked(store *storage.Storage, publicKey []byte) error {
+	blocked, err := store.IsBlocked(publicKey)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrPeerBlocked
+	}
+	return nil
+}
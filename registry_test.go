@@ -0,0 +1,179 @@
+package kamune
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+func TestSessionRegistry(t *testing.T) {
+	a := require.New(t)
+	r := newSessionRegistry(nil)
+
+	peer := &storage.Peer{PublicKey: randomBytes(32)}
+	other := &storage.Peer{PublicKey: randomBytes(32)}
+	newSession := func(id string, p *storage.Peer) *Transport {
+		tr := newLoopbackTransport(t, newGatedConn())
+		tr.sessionID = id
+		tr.remotePeer = p
+		return tr
+	}
+	s1 := newSession("s1", peer)
+	s2 := newSession("s2", peer)
+	s3 := newSession("s3", other)
+	r.add(s1)
+	r.add(s2)
+	r.add(s3)
+
+	a.Equal(3, r.Len())
+	got, ok := r.Get("s2")
+	a.True(ok)
+	a.Same(s2, got)
+	a.ElementsMatch(
+		[]*Transport{s1, s2}, r.ByPeer(fingerprint.Sum(peer.PublicKey)),
+	)
+	a.ElementsMatch([]*Transport{s1, s2, s3}, r.Sessions())
+
+	// A replaced session is not removed by its stale owner.
+	s1b := newSession("s1", peer)
+	r.add(s1b)
+	r.remove(s1)
+	got, ok = r.Get("s1")
+	a.True(ok)
+	a.Same(s1b, got)
+	a.Len(r.ByPeer(fingerprint.Sum(peer.PublicKey)), 2)
+
+	r.remove(s1b)
+	r.remove(s2)
+	_, ok = r.Get("s1")
+	a.False(ok)
+	a.Empty(r.ByPeer(fingerprint.Sum(peer.PublicKey)))
+	a.Equal(1, r.Len())
+}
+
+func TestSessionRegistry_ByAlias(t *testing.T) {
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	r := newSessionRegistry(store)
+
+	peer := &storage.Peer{PublicKey: randomBytes(32)}
+	tr := newLoopbackTransport(t, newGatedConn())
+	tr.sessionID = "s1"
+	tr.remotePeer = peer
+	r.add(tr)
+
+	a.Empty(r.ByAlias("alice-laptop"))
+	a.NoError(store.SetAlias("alice-laptop", peer.PublicKey))
+	a.Equal([]*Transport{tr}, r.ByAlias("alice-laptop"))
+	a.NoError(store.RemoveAlias("alice-laptop"))
+	a.Empty(r.ByAlias("alice-laptop"))
+	a.Nil(newSessionRegistry(nil).ByAlias("alice-laptop"))
+}
+
+func TestBlockPeer_ClosesLiveSessions(t *testing.T) {
+	a := require.New(t)
+	serverStore, serverCleanup := newTestStore(t)
+	defer serverCleanup()
+	clientStore, clientCleanup := newTestStore(t)
+	defer clientCleanup()
+
+	exited := make(chan error, 1)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	srv, err := NewServer(
+		"", func(t *Transport) error {
+			for {
+				msg := Bytes(nil)
+				md, err := t.Receive(msg)
+				if err != nil {
+					exited <- err
+					return nil
+				}
+				if _, err := t.Send(msg, md.Route()); err != nil {
+					return err
+				}
+			}
+		}, serverStore, acceptAll,
+		ServeWithListener(&tcpListener{Listener: l}),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	d, err := NewDialer(l.Addr().String(), clientStore, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	echo(t, tr, "hello")
+	a.Equal(1, d.SessionRegistry().Len())
+
+	a.NoError(serverStore.BlockPeer(d.PublicKey()))
+	a.Error(<-exited)
+	_, err = tr.Receive(Bytes(nil))
+	a.ErrorIs(err, ErrPeerDisconnected)
+	a.Eventually(func() bool { return srv.SessionRegistry().Len() == 0 },
+		time.Second, 10*time.Millisecond)
+
+	_, err = d.Dial()
+	a.Error(err, "blocked peers cannot establish a new session")
+
+	// Blocking works the same way on the dialer's side.
+	a.NoError(serverStore.UnblockPeer(d.PublicKey()))
+	tr, err = d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	echo(t, tr, "again")
+	a.NoError(clientStore.BlockPeer(srv.PublicKey()))
+	a.Zero(d.SessionRegistry().Len())
+	_, err = tr.Send(Bytes([]byte("closed")), RouteExchangeMessages)
+	a.Error(err)
+	_, err = d.Dial()
+	a.ErrorIs(err, ErrPeerBlocked)
+}
+
+func TestBlockPeer_RejectsResumption(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		client bool
+	}{
+		{name: "blocked by server", err: ErrResumptionRejected},
+		{name: "blocked by client", err: ErrPeerBlocked, client: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			ctx := setupResumptionTest(t)
+			defer ctx.cleanup()
+			store, remote := ctx.storage2, ctx.attest1
+			if tc.client {
+				store, remote = ctx.storage1, ctx.attest2
+			}
+			a.NoError(store.BlockPeer(remote.MarshalPublicKey()))
+
+			srv, err := NewServer("", func(*Transport) error {
+				return nil
+			}, ctx.storage2, acceptAll)
+			a.NoError(err)
+			srv.attest = ctx.attest2
+			d, err := NewDialer(
+				"", ctx.storage1, acceptAll, DialWithResume(ctx.sessionID),
+			)
+			a.NoError(err)
+			d.attest = ctx.attest1
+
+			c1, c2 := net.Pipe()
+			defer c1.Close()
+			go func() { _ = srv.serve(newConn(c2)) }()
+			_, err = d.handshake(newConn(c1))
+			a.ErrorIs(err, tc.err)
+		})
+	}
+}
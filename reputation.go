@@ -0,0 +1,133 @@
+package kamune
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/kamune-org/kamune/pkg/fingerprint"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// ReputationPolicy refuses, for a while, the connections of peers with
+// offenses on record: handshakes they failed after introducing themselves,
+// and sessions closed because of their messages under [AbuseControl]. Each
+// offense refuses the peer for Backoff, doubling with every offense on
+// record up to MaxBackoff; once it has BanAfter offenses, every further one
+// refuses it for BanFor instead. A zero BanAfter disables bans.
+//
+// Offenses are counted by the peer's public key, whether the peer is known
+// or not, and kept in storage; see [storage.Storage.GetReputation] and
+// [storage.Storage.ClearReputation]. Peers are only checked once their
+// introduction or resumption request has been verified, so that nobody can
+// get another peer refused.
+type ReputationPolicy struct {
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	BanFor     time.Duration
+	// Forgive is how long a peer has to go without an offense for its
+	// record to be forgotten at the next one. A zero Forgive keeps offenses
+	// until they are cleared.
+	Forgive  time.Duration
+	BanAfter int
+}
+
+func (p ReputationPolicy) validate() error {
+	switch {
+	case p.Backoff <= 0:
+		return errors.New("reputation backoff must be positive")
+	case p.MaxBackoff < p.Backoff:
+		return errors.New("reputation max backoff must be at least backoff")
+	case p.BanAfter < 0:
+		return errors.New("reputation ban count must not be negative")
+	case p.BanAfter > 0 && p.BanFor <= 0:
+		return errors.New("reputation ban duration must be positive")
+	case p.Forgive < 0:
+		return errors.New("reputation forgive period must not be negative")
+	}
+	return nil
+}
+
+// RefusedUntil returns when a peer with reputation r may connect again under
+// p. Peers without offenses are never refused.
+func (p ReputationPolicy) RefusedUntil(r storage.Reputation) time.Time {
+	n := r.Offenses()
+	if n == 0 {
+		return r.Last
+	}
+	if p.BanAfter > 0 && n >= p.BanAfter {
+		return r.Last.Add(p.BanFor)
+	}
+
+	backoff := p.Backoff
+	for i := 1; i < n && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return r.Last.Add(min(backoff, p.MaxBackoff))
+}
+
+// admit returns [ErrPeerThrottled] if the peer owning publicKey is refused
+// at now. A nil p admits everyone.
+func (p *ReputationPolicy) admit(
+	store *storage.Storage, publicKey []byte, now time.Time,
+) error {
+	if p == nil {
+		return nil
+	}
+	r, err := store.GetReputation(publicKey)
+	if err != nil {
+		return err
+	}
+	if until := p.RefusedUntil(r); now.Before(until) {
+		return fmt.Errorf(
+			"%w: %d offenses, refused for %s",
+			ErrPeerThrottled,
+			r.Offenses(),
+			until.Sub(now).Round(time.Second),
+		)
+	}
+	return nil
+}
+
+// record counts an offense of kind o against the peer owning publicKey. A
+// nil p records nothing.
+func (p *ReputationPolicy) record(
+	store *storage.Storage, publicKey []byte, o storage.Offense,
+) {
+	if p == nil || store == nil {
+		return
+	}
+	var forgetBefore time.Time
+	if p.Forgive > 0 {
+		forgetBefore = time.Now().Add(-p.Forgive)
+	}
+	r, err := store.RecordOffense(publicKey, o, forgetBefore)
+	if err != nil {
+		slog.Error(
+			"failed to record offense",
+			slog.String("offense", o.String()),
+			slog.Any("error", err),
+		)
+		return
+	}
+	slog.Info(
+		"offense recorded against peer",
+		slog.String("fingerprint", fingerprint.Sum(publicKey)),
+		slog.String("offense", o.String()),
+		slog.Int("offenses", r.Offenses()),
+	)
+}
+
+// ServeWithReputation refuses the connections of peers with offenses on
+// record as p says; see [ReputationPolicy]. Refused peers are dropped like
+// blocked ones, without being told why.
+func ServeWithReputation(p ReputationPolicy) ServerOptions {
+	return func(s *Server) error {
+		if err := p.validate(); err != nil {
+			return err
+		}
+		s.reputation = &p
+		return nil
+	}
+}
@@ -0,0 +1,119 @@
+package kamune
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+func TestReputationPolicy_RefusedUntil(t *testing.T) {
+	last := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := ReputationPolicy{
+		Backoff:    time.Minute,
+		MaxBackoff: 10 * time.Minute,
+		BanAfter:   6,
+		BanFor:     24 * time.Hour,
+	}
+	tests := []struct {
+		name     string
+		failures int
+		abuses   int
+		want     time.Duration
+	}{
+		{name: "clean", want: 0},
+		{name: "first offense", failures: 1, want: time.Minute},
+		{name: "doubling", failures: 2, abuses: 1, want: 4 * time.Minute},
+		{name: "capped", abuses: 5, want: 10 * time.Minute},
+		{name: "banned", failures: 3, abuses: 3, want: 24 * time.Hour},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			r := storage.Reputation{
+				Last:              last,
+				HandshakeFailures: tc.failures,
+				Abuses:            tc.abuses,
+			}
+			a.Equal(last.Add(tc.want), p.RefusedUntil(r))
+		})
+	}
+}
+
+func TestReputationPolicy_Validate(t *testing.T) {
+	a := require.New(t)
+	for _, p := range []ReputationPolicy{
+		{},
+		{Backoff: time.Minute, MaxBackoff: time.Second},
+		{Backoff: time.Second, MaxBackoff: time.Minute, BanAfter: 3},
+		{Backoff: time.Second, MaxBackoff: time.Minute, BanAfter: -1},
+		{Backoff: time.Second, MaxBackoff: time.Minute, Forgive: -1},
+	} {
+		a.Error(p.validate(), "%+v", p)
+	}
+	a.NoError(ReputationPolicy{
+		Backoff: time.Second, MaxBackoff: time.Minute,
+	}.validate())
+}
+
+func TestServeWithReputation(t *testing.T) {
+	a := require.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	handler := func(tr *Transport) error {
+		for {
+			if _, err := tr.Receive(Bytes(nil)); err != nil {
+				return nil
+			}
+		}
+	}
+	serverStore, cleanup := newTestStore(t)
+	defer cleanup()
+	srv, err := NewServer(
+		"", handler, serverStore, storePeer,
+		ServeWithListener(&tcpListener{Listener: ln}),
+		ServeWithAbuseControl(AbuseControl{
+			Scorer:          scoreSpam(AbuseDrop),
+			Plaintext:       true,
+			DisconnectAfter: 1,
+		}),
+		ServeWithReputation(ReputationPolicy{
+			Backoff: time.Hour, MaxBackoff: time.Hour,
+		}),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(ln.Addr().String(), store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	_, err = tr.Send(Bytes([]byte("spam")), RouteExchangeMessages)
+	a.NoError(err)
+	_, err = tr.Receive(Bytes(nil))
+	a.Error(err)
+	_ = tr.Close()
+
+	publicKey, err := store.PublicKey()
+	a.NoError(err)
+	a.Eventually(func() bool {
+		r, err := serverStore.GetReputation(publicKey)
+		return err == nil && r.Abuses == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// The peer is refused until its offense is cleared.
+	_, err = d.Dial()
+	a.Error(err)
+	a.NoError(serverStore.ClearReputation(publicKey))
+	tr, err = d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	_, err = tr.Send(Bytes([]byte("hello")), RouteExchangeMessages)
+	a.NoError(err)
+}
@@ -10,13 +10,17 @@ import (
 )
 
 // sendResumeRequest sends a ResumeRequest through the HPKE tunnel. The request
-// contains the session ID and a resumption token.
+// contains the session ID, a resumption token, and the number of application
+// messages received in the session, so that the server can resend the ones
+// that were missed.
 func sendResumeRequest(
 	conn Conn, at *attest.Attest, sessionID string, token []byte,
+	received uint64,
 ) error {
 	req := &pb.ResumeRequest{
 		SessionID: sessionID,
 		Token:     token,
+		Received:  received,
 	}
 	message, err := proto.Marshal(req)
 	if err != nil {
@@ -55,21 +59,22 @@ func sendResumeRequest(
 
 // receiveResumeAccept reads and parses a ResumeAccept from the HPKE tunnel,
 // verifying the signature against the server's public key. Returns whether
-// resumption was accepted and any rejection reason.
+// resumption was accepted, any rejection reason, and the number of
+// application messages the server received in the session.
 func receiveResumeAccept(
 	conn Conn, remote []byte,
-) (accepted bool, reason string, err error) {
+) (accepted bool, reason string, received uint64, err error) {
 	st, err := readSignedTransport(conn)
 	if err != nil {
-		return false, "", fmt.Errorf("reading resume accept: %w", err)
+		return false, "", 0, fmt.Errorf("reading resume accept: %w", err)
 	}
 
 	r, err := routeFromST(st)
 	if err != nil {
-		return false, "", fmt.Errorf("extracting route: %w", err)
+		return false, "", 0, fmt.Errorf("extracting route: %w", err)
 	}
 	if r != RouteResumeAccept {
-		return false, "", fmt.Errorf(
+		return false, "", 0, fmt.Errorf(
 			"%w: expected %s, got %s", ErrUnexpectedRoute, RouteResumeAccept, r,
 		)
 	}
@@ -77,20 +82,23 @@ func receiveResumeAccept(
 	if !attest.Verify(
 		remote, signingInput(st.GetMetadata(), st.GetData()), st.GetSignature(),
 	) {
-		return false, "", ErrInvalidSignature
+		return false, "", 0, ErrInvalidSignature
 	}
 
 	var accept pb.ResumeAccept
 	if err := proto.Unmarshal(st.GetData(), &accept); err != nil {
-		return false, "", fmt.Errorf("deserializing resume accept: %w", err)
+		return false, "", 0, fmt.Errorf("deserializing resume accept: %w", err)
 	}
 
-	return accept.GetAccepted(), accept.GetReason(), nil
+	return accept.GetAccepted(), accept.GetReason(), accept.GetReceived(), nil
 }
 
 // sendResumeAccept signs and sends a ResumeAccept response through the HPKE
-// tunnel.
-func sendResumeAccept(conn Conn, at *attest.Attest, accepted bool) error {
+// tunnel. An accepting response carries the number of application messages
+// received in the session; see [sendResumeRequest].
+func sendResumeAccept(
+	conn Conn, at *attest.Attest, accepted bool, received uint64,
+) error {
 	var reason string
 	if !accepted {
 		reason = "resumption not available"
@@ -98,6 +106,7 @@ func sendResumeAccept(conn Conn, at *attest.Attest, accepted bool) error {
 	resp := &pb.ResumeAccept{
 		Accepted: accepted,
 		Reason:   reason,
+		Received: received,
 	}
 	message, err := proto.Marshal(resp)
 	if err != nil {
@@ -3,7 +3,6 @@ package kamune
 import (
 	"crypto/rand"
 	"net"
-	"os"
 	"testing"
 	"time"
 
@@ -26,26 +25,54 @@ func newTestStore(
 ) (*storage.Storage, func()) {
 	t.Helper()
 	a := require.New(t)
-	f, err := os.CreateTemp("", "kamune-resume-test-*.db")
-	a.NoError(err)
-	a.NoError(f.Close())
-
 	s, err := storage.OpenStorage(
-		append(
-			[]storage.StorageOption{
-				storage.WithDBPath(f.Name()),
-				storage.WithNoPassphrase(),
-			},
-			opts...,
-		)...,
+		append([]storage.StorageOption{storage.WithInMemory()}, opts...)...,
 	)
 	a.NoError(err)
+	return s, func() { s.Close() }
+}
 
-	cleanup := func() {
-		s.Close()
-		os.Remove(f.Name())
+// acceptAll is a RemoteVerifier that trusts every peer without storing it.
+func acceptAll(*storage.Storage, *storage.Peer) error { return nil }
+
+// storePeer is a RemoteVerifier that trusts and stores every peer, so that
+// sessions with it can be recorded.
+func storePeer(s *storage.Storage, p *storage.Peer) error {
+	return s.StorePeer(p)
+}
+
+// startTestServer serves handler on a loopback TCP listener, verifying peers
+// with rv, and closes the server when the test ends. It opens a test store
+// when store is nil. It returns the server, its address, and a channel that
+// receives the result of [Server.ListenAndServe].
+func startTestServer(
+	t *testing.T,
+	handler HandlerFunc,
+	store *storage.Storage,
+	rv RemoteVerifier,
+	opts ...ServerOptions,
+) (*Server, string, <-chan error) {
+	t.Helper()
+	a := require.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	if store == nil {
+		var cleanup func()
+		store, cleanup = newTestStore(t)
+		t.Cleanup(cleanup)
 	}
-	return s, cleanup
+	srv, err := NewServer(
+		"", handler, store, rv,
+		append([]ServerOptions{ServeWithListener(&tcpListener{Listener: l})},
+			opts...)...,
+	)
+	a.NoError(err)
+	served := make(chan error, 1)
+	go func() { served <- srv.ListenAndServe() }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	return srv, l.Addr().String(), served
 }
 
 func setupExchange(
@@ -118,7 +145,9 @@ func setupResumptionTest(
 	introDone := make(chan struct{})
 	go func() {
 		defer close(introDone)
-		introErr = sendIntroduction(ec1, att1, "client", AppVersion)
+		introErr = sendIntroduction(
+			ec1, att1, "client", AppVersion, introFields{},
+		)
 	}()
 	st, err := readSignedTransport(ec2)
 	a.NoError(err)
@@ -134,7 +163,9 @@ func setupResumptionTest(
 	sendDone := make(chan struct{})
 	go func() {
 		defer close(sendDone)
-		sendIntroErr = sendIntroduction(ec2, att2, "server", AppVersion)
+		sendIntroErr = sendIntroduction(
+			ec2, att2, "server", AppVersion, introFields{},
+		)
 	}()
 	stClient, err := readSignedTransport(ec1)
 	a.NoError(err)
@@ -228,7 +259,7 @@ func TestResumeRequest_Roundtrip(t *testing.T) {
 	done := make(chan struct{})
 	go func() {
 		defer close(done)
-		sendErr = sendResumeRequest(ec1, att, sessionID, token)
+		sendErr = sendResumeRequest(ec1, att, sessionID, token, 42)
 	}()
 
 	// Server reads the SignedTransport.
@@ -247,6 +278,7 @@ func TestResumeRequest_Roundtrip(t *testing.T) {
 	a.NoError(proto.Unmarshal(st.GetData(), &req))
 	a.Equal(sessionID, req.GetSessionID())
 	a.Equal(token, req.GetToken())
+	a.Equal(uint64(42), req.GetReceived())
 
 	// Verify signature.
 	a.True(attest.Verify(att.MarshalPublicKey(), signingInput(st.GetMetadata(), st.GetData()), st.GetSignature()))
@@ -273,16 +305,19 @@ func TestResumeAccept_Roundtrip_Accepted(t *testing.T) {
 	done := make(chan struct{})
 	go func() {
 		defer close(done)
-		sendErr = sendResumeAccept(ec2, att, true)
+		sendErr = sendResumeAccept(ec2, att, true, 7)
 	}()
 
 	// Client receives and verifies.
-	accepted, reason, err := receiveResumeAccept(ec1, att.MarshalPublicKey())
+	accepted, reason, received, err := receiveResumeAccept(
+		ec1, att.MarshalPublicKey(),
+	)
 	<-done
 	a.NoError(sendErr)
 	a.NoError(err)
 	a.True(accepted)
 	a.Empty(reason)
+	a.Equal(uint64(7), received)
 }
 
 func TestResumeAccept_Roundtrip_Rejected(t *testing.T) {
@@ -306,11 +341,11 @@ func TestResumeAccept_Roundtrip_Rejected(t *testing.T) {
 	done := make(chan struct{})
 	go func() {
 		defer close(done)
-		sendErr = sendResumeAccept(ec2, att, false)
+		sendErr = sendResumeAccept(ec2, att, false, 0)
 	}()
 
 	// Client receives and verifies.
-	accepted, reason, err := receiveResumeAccept(ec1, att.MarshalPublicKey())
+	accepted, reason, _, err := receiveResumeAccept(ec1, att.MarshalPublicKey())
 	<-done
 	a.NoError(sendErr)
 	a.NoError(err)
@@ -345,7 +380,7 @@ func TestResume_HappyPath(t *testing.T) {
 	reqDone := make(chan struct{})
 	go func() {
 		defer close(reqDone)
-		reqErr = sendResumeRequest(ec3, ctx.attest1, ctx.sessionID, token)
+		reqErr = sendResumeRequest(ec3, ctx.attest1, ctx.sessionID, token, 0)
 	}()
 
 	// Server: read and validate.
@@ -380,11 +415,11 @@ func TestResume_HappyPath(t *testing.T) {
 	acceptDone := make(chan struct{})
 	go func() {
 		defer close(acceptDone)
-		acceptErr = sendResumeAccept(ec4, ctx.attest2, true)
+		acceptErr = sendResumeAccept(ec4, ctx.attest2, true, 0)
 	}()
 
 	// Client: receive accept.
-	accepted, reason, err := receiveResumeAccept(ec3, ctx.attest2.MarshalPublicKey())
+	accepted, reason, _, err := receiveResumeAccept(ec3, ctx.attest2.MarshalPublicKey())
 	<-acceptDone
 	a.NoError(acceptErr)
 	a.NoError(err)
@@ -457,7 +492,7 @@ func TestResumeRejected_InvalidToken(t *testing.T) {
 	reqDone := make(chan struct{})
 	go func() {
 		defer close(reqDone)
-		reqErr = sendResumeRequest(ec3, ctx.attest1, ctx.sessionID, badToken)
+		reqErr = sendResumeRequest(ec3, ctx.attest1, ctx.sessionID, badToken, 0)
 	}()
 
 	// Server: read and attempt validation.
@@ -480,11 +515,11 @@ func TestResumeRejected_InvalidToken(t *testing.T) {
 	rejectDone := make(chan struct{})
 	go func() {
 		defer close(rejectDone)
-		rejectErr = sendResumeAccept(ec4, ctx.attest2, false)
+		rejectErr = sendResumeAccept(ec4, ctx.attest2, false, 0)
 	}()
 
 	// Client receives rejection.
-	accepted, reason, err := receiveResumeAccept(ec3, ctx.attest2.MarshalPublicKey())
+	accepted, reason, _, err := receiveResumeAccept(ec3, ctx.attest2.MarshalPublicKey())
 	<-rejectDone
 	a.NoError(rejectErr)
 	a.NoError(err)
@@ -515,7 +550,7 @@ func TestResumeRejected_ExpiredSession(t *testing.T) {
 	reqDone := make(chan struct{})
 	go func() {
 		defer close(reqDone)
-		reqErr = sendResumeRequest(ec3, ctx.attest1, ctx.sessionID, token)
+		reqErr = sendResumeRequest(ec3, ctx.attest1, ctx.sessionID, token, 0)
 	}()
 
 	// Server: read and validate.
@@ -548,11 +583,11 @@ func TestResumeRejected_ExpiredSession(t *testing.T) {
 	rejectDone2 := make(chan struct{})
 	go func() {
 		defer close(rejectDone2)
-		rejectErr2 = sendResumeAccept(ec4, ctx.attest2, false)
+		rejectErr2 = sendResumeAccept(ec4, ctx.attest2, false, 0)
 	}()
 
 	// Client receives rejection.
-	accepted, reason, err := receiveResumeAccept(ec3, ctx.attest2.MarshalPublicKey())
+	accepted, reason, _, err := receiveResumeAccept(ec3, ctx.attest2.MarshalPublicKey())
 	<-rejectDone2
 	a.NoError(rejectErr2)
 	a.NoError(err)
@@ -586,7 +621,7 @@ func TestResumeRejected_SignatureMismatch(t *testing.T) {
 	reqDone := make(chan struct{})
 	go func() {
 		defer close(reqDone)
-		reqErr = sendResumeRequest(ec3, attWrong, ctx.sessionID, token)
+		reqErr = sendResumeRequest(ec3, attWrong, ctx.sessionID, token, 0)
 	}()
 
 	// Server: read and validate.
@@ -614,11 +649,11 @@ func TestResumeRejected_SignatureMismatch(t *testing.T) {
 	rejectDone3 := make(chan struct{})
 	go func() {
 		defer close(rejectDone3)
-		rejectErr3 = sendResumeAccept(ec4, ctx.attest2, false)
+		rejectErr3 = sendResumeAccept(ec4, ctx.attest2, false, 0)
 	}()
 
 	// Client receives rejection.
-	accepted, reason, err := receiveResumeAccept(ec3, ctx.attest2.MarshalPublicKey())
+	accepted, reason, _, err := receiveResumeAccept(ec3, ctx.attest2.MarshalPublicKey())
 	<-rejectDone3
 	a.NoError(rejectErr3)
 	a.NoError(err)
@@ -647,7 +682,7 @@ func TestResumeRejected_Disabled(t *testing.T) {
 	reqDone := make(chan struct{})
 	go func() {
 		defer close(reqDone)
-		reqErr = sendResumeRequest(ec3, ctx.attest1, ctx.sessionID, token)
+		reqErr = sendResumeRequest(ec3, ctx.attest1, ctx.sessionID, token, 0)
 	}()
 
 	// Server reads and checks route — simulating resumeEnabled: false.
@@ -660,3 +695,55 @@ func TestResumeRejected_Disabled(t *testing.T) {
 	a.NoError(err)
 	a.Equal(RouteResumeRequest, route)
 }
+
+func TestHandshake_RecordsSession(t *testing.T) {
+	trust := func(*storage.Storage, *storage.Peer) error { return nil }
+	tests := []struct {
+		name     string
+		verifier RemoteVerifier
+		recorded bool
+	}{
+		{name: "stored peer", verifier: storePeer, recorded: true},
+		{name: "unstored peer", verifier: trust, recorded: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			a.NoError(err)
+			serverStore, cleanup := newTestStore(t)
+			defer cleanup()
+			recorded := make(chan bool, 1)
+			handler := func(tr *Transport) error {
+				recorded <- hasResumptionTokens(serverStore, tr.SessionID())
+				return nil
+			}
+			srv, err := NewServer(
+				"", handler, serverStore, tc.verifier,
+				ServeWithListener(&tcpListener{Listener: l}),
+			)
+			a.NoError(err)
+			go func() { _ = srv.ListenAndServe() }()
+			defer srv.Close()
+
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			d, err := NewDialer(l.Addr().String(), store, tc.verifier)
+			a.NoError(err)
+			tr, err := d.Dial()
+			a.NoError(err)
+			defer tr.Close()
+
+			a.Equal(
+				tc.recorded, hasResumptionTokens(store, tr.SessionID()),
+				"dialer recorded the session",
+			)
+			a.Equal(tc.recorded, <-recorded, "server recorded the session")
+		})
+	}
+}
+
+func hasResumptionTokens(s *storage.Storage, sessionID string) bool {
+	m, err := s.GetMeta(sessionID, storage.ResumptionTokensKey)
+	return err == nil && m.Value() != nil
+}
@@ -0,0 +1,181 @@
+package kamune
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// capabilityRetransmit is advertised in the introduction by peers that keep
+// their recent messages for retransmission after resumption.
+const capabilityRetransmit = "retransmit/v1"
+
+// retransmitter numbers a session's application messages across resumptions
+// and keeps the last window of those sent in the session's outbox, so that
+// the ones the peer missed can be sent again when the session is resumed.
+type retransmitter struct {
+	window int
+	// sent and received count the application messages sent and received in
+	// the session, across resumptions.
+	sent     uint64
+	received uint64
+}
+
+// isRetransmitted reports whether messages on route are numbered and kept for
+// retransmission. Control messages are not, and neither are transfers, which
+// do not survive the connection, or wipes, which leave no session to resume.
+func isRetransmitted(r Route) bool {
+	return priorityForRoute(r) != PriorityControl &&
+		!isTransferRoute(r) && !isWipeRoute(r)
+}
+
+// enableRetransmit numbers the session's messages and keeps the last window
+// of them if both sides advertised retransmission. Sessions that are not
+// recorded in storage cannot be resumed, and are left alone.
+func (t *Transport) enableRetransmit(window int) {
+	if window < 1 || t.store == nil ||
+		!slices.Contains(t.remotePeer.Capabilities, capabilityRetransmit) {
+		return
+	}
+	if _, err := t.store.GetEstablishedAt(t.sessionID); err != nil {
+		return
+	}
+	t.retransmit = &retransmitter{window: window}
+	if t.resumed {
+		t.retransmit.received = receivedCount(t.store, t.sessionID)
+	}
+}
+
+// receivedCount returns the number of application messages received in the
+// session so far, as recorded in store.
+func receivedCount(store *storage.Storage, sessionID string) uint64 {
+	m, err := store.GetMeta(sessionID, storage.ReceivedCountKey)
+	if err != nil || len(m.Value()) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(m.Value())
+}
+
+// countReceived records the receipt of an application message on route.
+func (t *Transport) countReceived(route Route) {
+	if t.retransmit == nil || !isRetransmitted(route) {
+		return
+	}
+	t.mu.Lock()
+	t.retransmit.received++
+	n := t.retransmit.received
+	t.mu.Unlock()
+
+	err := t.store.SetMeta(t.sessionID, storage.NewBytesMeta(
+		storage.ReceivedCountKey, binary.BigEndian.AppendUint64(nil, n),
+	))
+	if err != nil {
+		slog.Warn(
+			"recording received messages",
+			slog.String("session_id", t.sessionID),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// bufferSent keeps a message just written on the session in its outbox.
+// Ephemeral messages are counted but not kept, so they are lost with the
+// connection, and so are messages awaiting acknowledgment, which are sent
+// again by redeliver instead. Failing to keep a message does not fail the
+// send.
+func (t *Transport) bufferSent(req *sendRequest) {
+	if t.retransmit == nil || !isRetransmitted(req.route) {
+		return
+	}
+	data, err := proto.Marshal(req.message)
+	if err != nil {
+		slog.Warn("marshalling buffered message", slog.Any("error", err))
+		return
+	}
+	t.mu.Lock()
+	t.retransmit.sent++
+	n := t.retransmit.sent
+	t.mu.Unlock()
+	req.number = n
+	if req.ephemeral || req.ack {
+		return
+	}
+
+	err = t.store.BufferMessage(t.sessionID, storage.BufferedMessage{
+		Data:   data,
+		Number: n,
+		Route:  int32(req.route),
+	}, t.retransmit.window)
+	if err != nil {
+		slog.Warn(
+			"buffering message",
+			slog.String("session_id", t.sessionID),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// resend sends again the messages that the peer did not receive before the
+// session was resumed, peerReceived being the number of application messages
+// it reports having received: those buffered in the outbox, and those
+// awaiting acknowledgment.
+func (t *Transport) resend(peerReceived uint64) error {
+	if err := t.resendOutbox(peerReceived); err != nil {
+		return err
+	}
+	t.redeliver(peerReceived)
+	return nil
+}
+
+// resendOutbox sends again, in order, the buffered messages that the peer
+// did not receive. Messages that fell out of the window are lost, and logged
+// as such.
+func (t *Transport) resendOutbox(peerReceived uint64) error {
+	if t.retransmit == nil {
+		return nil
+	}
+	missed, err := t.store.TakeBufferedMessages(t.sessionID, peerReceived)
+	if err != nil {
+		return fmt.Errorf("reading outbox: %w", err)
+	}
+	t.mu.Lock()
+	t.retransmit.sent = peerReceived
+	t.mu.Unlock()
+
+	if len(missed) > 0 && missed[0].Number > peerReceived+1 {
+		slog.Warn(
+			"messages lost beyond retransmit window",
+			slog.String("session_id", t.sessionID),
+			slog.Uint64("count", missed[0].Number-peerReceived-1),
+		)
+	}
+	for _, m := range missed {
+		// An empty message keeps every field as unknown, so it marshals
+		// back to the bytes the peer would have received.
+		msg := &emptypb.Empty{}
+		if err := proto.Unmarshal(m.Data, msg); err != nil {
+			return fmt.Errorf("unmarshalling buffered message: %w", err)
+		}
+		if _, err := t.Send(msg, Route(m.Route)); err != nil {
+			return fmt.Errorf("resending message %d: %w", m.Number, err)
+		}
+	}
+	if len(missed) > 0 {
+		slog.Info(
+			"missed messages resent",
+			slog.String("session_id", t.sessionID),
+			slog.Int("count", len(missed)),
+		)
+	}
+	return nil
+}
+
+// Retransmitting reports whether the session's messages are kept for
+// retransmission after resumption; see [ServeWithRetransmitWindow].
+func (t *Transport) Retransmitting() bool { return t.retransmit != nil }
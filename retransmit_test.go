@@ -0,0 +1,129 @@
+package kamune
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// startRetransmitServer runs a server that sends texts at the start of every
+// new session, signals on the returned channel once they are sent, and then
+// echoes what it receives.
+func startRetransmitServer(
+	t *testing.T, texts []string, opts ...ServerOptions,
+) (string, <-chan struct{}) {
+	t.Helper()
+	sent := make(chan struct{}, 1)
+	echo := NewEchoHandler()
+	handler := func(tr *Transport) error {
+		if !tr.resumed {
+			for _, text := range texts {
+				_, err := tr.Send(Bytes([]byte(text)), RouteExchangeMessages)
+				if err != nil {
+					return err
+				}
+			}
+			sent <- struct{}{}
+		}
+		return echo(tr)
+	}
+
+	_, addr, _ := startTestServer(t, handler, nil, storePeer, opts...)
+	return addr, sent
+}
+
+func TestRetransmitWindow(t *testing.T) {
+	texts := []string{"1", "2", "3", "4"}
+	tests := []struct {
+		name         string
+		serverWindow int
+		// received is how many of the texts the client claims to have
+		// received when it resumes.
+		received uint64
+		want     []string
+	}{
+		{name: "all missed", serverWindow: 8, want: texts},
+		{
+			name:         "some missed",
+			serverWindow: 8,
+			received:     2,
+			want:         []string{"3", "4"},
+		},
+		{
+			name:         "beyond window",
+			serverWindow: 2,
+			received:     1,
+			want:         []string{"3", "4"},
+		},
+		{name: "none missed", serverWindow: 8, received: 4},
+		{name: "server disabled", received: 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			addr, sent := startRetransmitServer(
+				t, texts, ServeWithRetransmitWindow(tc.serverWindow),
+			)
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+
+			d, err := NewDialer(
+				addr, store, storePeer, DialWithRetransmitWindow(8),
+			)
+			a.NoError(err)
+			tr, err := d.Dial()
+			a.NoError(err)
+			a.Equal(tc.serverWindow > 0, tr.Retransmitting())
+			<-sent
+			for _, text := range texts {
+				msg := Bytes(nil)
+				_, err := tr.Receive(msg)
+				a.NoError(err)
+				a.Equal(text, string(msg.GetValue()))
+			}
+			sessionID := tr.SessionID()
+			a.NoError(tr.Close())
+
+			// Pretend the last messages were lost along with the connection.
+			a.NoError(store.SetMeta(sessionID, storage.NewBytesMeta(
+				storage.ReceivedCountKey,
+				binary.BigEndian.AppendUint64(nil, tc.received),
+			)))
+
+			d, err = NewDialer(
+				addr, store, storePeer,
+				DialWithRetransmitWindow(8), DialWithResume(sessionID),
+			)
+			a.NoError(err)
+			tr, err = d.Dial()
+			a.NoError(err)
+			defer tr.Close()
+			a.Equal(sessionID, tr.SessionID())
+			for _, text := range tc.want {
+				msg := Bytes(nil)
+				_, err := tr.Receive(msg)
+				a.NoError(err)
+				a.Equal(text, string(msg.GetValue()))
+			}
+			// Nothing else was resent before the echo.
+			echo(t, tr, "done")
+		})
+	}
+}
+
+func TestRetransmitWindowOptions(t *testing.T) {
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	_, err := NewServer(
+		"", nil, store, acceptAll, ServeWithRetransmitWindow(-1),
+	)
+	a.Error(err)
+	_, err = NewDialer(
+		"127.0.0.1:1", store, acceptAll, DialWithRetransmitWindow(-1),
+	)
+	a.Error(err)
+}
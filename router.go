@@ -0,0 +1,186 @@
+package kamune
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+)
+
+// RouteHandler handles the messages of a route dispatched by a [Router].
+type RouteHandler func(m *InboundMessage)
+
+// TypeHandler handles the messages of an application message type
+// dispatched by a [Router].
+type TypeHandler func(m *TypedMessage)
+
+// TypedMessage is a message sent with [SendTyped], as handed to a
+// [TypeHandler].
+type TypedMessage struct {
+	*InboundMessage
+	// Message is the payload, decoded into the type the [Router]'s
+	// [TypeRegistry] holds for Type.
+	Message Transferable
+	// Type is the application message type the sender named, e.g.
+	// "chat.text".
+	Type string
+}
+
+// SendTyped sends msg over t on [RouteTypedMessage], wrapped in an envelope
+// naming its application message type, such as "chat.text", so that the
+// peer's [Router] hands it to the handler registered for that type. It lets
+// applications multiplex their kinds of messages without framing of their
+// own.
+func SendTyped(
+	t *Transport, typ string, msg Transferable,
+) (*Metadata, error) {
+	if typ == "" {
+		return nil, ErrInvalidMessageType
+	}
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling payload: %w", err)
+	}
+	envelope := &pb.Envelope{Type: typ, Payload: payload}
+	return t.Send(envelope, RouteTypedMessage)
+}
+
+// Router dispatches the messages of sessions to handlers by route and, for
+// messages sent with [SendTyped], by application message type. Use
+// [Router.Serve] as the handler of a server, or [Router.Dispatch] as the
+// OnMessage callback of [Handlers]:
+//
+//	types := kamune.NewTypeRegistry()
+//	err := types.RegisterType("chat.text", kamune.Bytes(nil))
+//	r := kamune.NewRouter(types)
+//	err = r.HandleType("chat.text", func(m *kamune.TypedMessage) { ... })
+//	srv, err := kamune.NewServer(addr, r.Serve, store, verifier)
+//
+// Only messages on [RouteTypedMessage] are read as envelopes. Those whose type
+// has no handler go to the handler of that route, if any. Messages with no
+// handler are dropped. It is safe to register handlers while serving.
+type Router struct {
+	types    *TypeRegistry
+	routes   map[Route]RouteHandler
+	handlers map[string]TypeHandler
+	mu       sync.RWMutex
+}
+
+// NewRouter returns a Router without handlers that decodes typed messages
+// into the types registered in types. A nil types only allows dispatching by
+// route.
+func NewRouter(types *TypeRegistry) *Router {
+	if types == nil {
+		types = NewTypeRegistry()
+	}
+	return &Router{
+		types:    types,
+		routes:   make(map[Route]RouteHandler),
+		handlers: make(map[string]TypeHandler),
+	}
+}
+
+// Handle registers h for the messages of route, replacing any handler it
+// had. A nil h removes the handler.
+func (r *Router) Handle(route Route, h RouteHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h == nil {
+		delete(r.routes, route)
+		return
+	}
+	r.routes[route] = h
+}
+
+// HandleType registers h for the messages of the application message type
+// typ, replacing any handler it had. A nil h removes the handler. The type
+// must be registered in the router's [TypeRegistry], or
+// [ErrUnregisteredType] is returned.
+func (r *Router) HandleType(typ string, h TypeHandler) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h == nil {
+		delete(r.handlers, typ)
+		return nil
+	}
+	if _, err := r.types.NewType(typ); err != nil {
+		return err
+	}
+	r.handlers[typ] = h
+	return nil
+}
+
+// Serve runs the receive loop of t, dispatching its messages, until the
+// session ends. It is a [HandlerFunc], managed as [Managed] describes.
+func (r *Router) Serve(t *Transport) error {
+	return Managed(Handlers{OnMessage: r.Dispatch})(t)
+}
+
+// Dispatch hands m to the handler registered for its type or route. Frames
+// the session rejected are dropped.
+func (r *Router) Dispatch(m *InboundMessage) {
+	if m.Err != nil {
+		slog.Debug(
+			"router dropped rejected frame",
+			slog.String("session_id", m.Transport.SessionID()),
+			slog.Any("error", m.Err),
+		)
+		return
+	}
+	route := m.Metadata.Route()
+	if route == RouteTypedMessage && r.dispatchTyped(m) {
+		return
+	}
+
+	r.mu.RLock()
+	routeHandler := r.routes[route]
+	r.mu.RUnlock()
+	if routeHandler == nil {
+		slog.Debug(
+			"router dropped message without handler",
+			slog.String("session_id", m.Transport.SessionID()),
+			slog.String("route", route.String()),
+		)
+		return
+	}
+	routeHandler(m)
+}
+
+// dispatchTyped hands m, an envelope, to the handler of its type. It reports
+// false when the envelope is malformed or its type has no handler.
+func (r *Router) dispatchTyped(m *InboundMessage) bool {
+	var envelope pb.Envelope
+	if err := proto.Unmarshal(m.data, &envelope); err != nil {
+		slog.Debug(
+			"router received malformed envelope",
+			slog.String("session_id", m.Transport.SessionID()),
+			slog.Any("error", err),
+		)
+		return false
+	}
+	typ := envelope.GetType()
+	r.mu.RLock()
+	h := r.handlers[typ]
+	r.mu.RUnlock()
+	if h == nil {
+		return false
+	}
+	msg, err := r.types.NewType(typ)
+	if err == nil {
+		err = m.Transport.unmarshal(envelope.GetPayload(), msg)
+	}
+	if err != nil {
+		slog.Debug(
+			"router dropped undecodable typed message",
+			slog.String("session_id", m.Transport.SessionID()),
+			slog.String("type", typ),
+			slog.Any("error", err),
+		)
+		return true
+	}
+	h(&TypedMessage{InboundMessage: m, Message: msg, Type: typ})
+	return true
+}
@@ -0,0 +1,125 @@
+package kamune
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// startRouterServer runs a server that serves r.
+func startRouterServer(t *testing.T, r *Router) string {
+	t.Helper()
+	_, addr, _ := startTestServer(t, r.Serve, nil, storePeer)
+	return addr
+}
+
+func TestRouter(t *testing.T) {
+	a := require.New(t)
+	types := NewTypeRegistry()
+	a.NoError(types.RegisterType("chat.text", Bytes(nil)))
+	a.NoError(types.RegisterType("chat.reaction", &wrapperspb.StringValue{}))
+	a.NoError(types.RegisterType("chat.poll", Bytes(nil)))
+
+	got := make(chan string, 1)
+	r := NewRouter(types)
+	a.NoError(r.HandleType("chat.text", func(m *TypedMessage) {
+		msg := m.Message.(*wrapperspb.BytesValue)
+		got <- m.Type + ": " + string(msg.GetValue())
+	}))
+	a.NoError(r.HandleType("chat.reaction", func(m *TypedMessage) {
+		msg := m.Message.(*wrapperspb.StringValue)
+		got <- m.Type + ": " + msg.GetValue()
+	}))
+	a.ErrorIs(
+		r.HandleType("chat.unknown", func(*TypedMessage) {}),
+		ErrUnregisteredType,
+	)
+	r.Handle(RouteExchangeMessages, func(m *InboundMessage) {
+		got <- "route: " + m.Metadata.Route().String()
+	})
+	r.Handle(RouteTypedMessage, func(m *InboundMessage) {
+		got <- "route: " + m.Metadata.Route().String()
+	})
+	r.Handle(RouteSessionData, func(m *InboundMessage) {
+		got <- "session data"
+	})
+	addr := startRouterServer(t, r)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, storePeer)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+
+	tests := []struct {
+		name string
+		send func() error
+		want string
+	}{
+		{
+			name: "text",
+			send: func() error {
+				_, err := SendTyped(tr, "chat.text", Bytes([]byte("hi")))
+				return err
+			},
+			want: "chat.text: hi",
+		},
+		{
+			name: "reaction",
+			send: func() error {
+				_, err := SendTyped(
+					tr, "chat.reaction", wrapperspb.String("+1"),
+				)
+				return err
+			},
+			want: "chat.reaction: +1",
+		},
+		{
+			name: "type without handler",
+			send: func() error {
+				_, err := SendTyped(tr, "chat.poll", Bytes(nil))
+				return err
+			},
+			want: "route: TypedMessage",
+		},
+		{
+			name: "plain message decoding as envelope",
+			send: func() error {
+				// The value of a Bytes message is field 1, like the type
+				// of an envelope.
+				_, err := tr.Send(
+					Bytes([]byte("chat.text")), RouteExchangeMessages,
+				)
+				return err
+			},
+			want: "route: ExchangeMessages",
+		},
+		{
+			name: "other route",
+			send: func() error {
+				_, err := tr.Send(Bytes(nil), RouteSessionData)
+				return err
+			},
+			want: "session data",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			a.NoError(tc.send())
+			select {
+			case text := <-got:
+				a.Equal(tc.want, text)
+			case <-time.After(5 * time.Second):
+				t.Fatal("message not dispatched")
+			}
+		})
+	}
+
+	_, err = SendTyped(tr, "", Bytes(nil))
+	a.ErrorIs(err, ErrInvalidMessageType)
+}
@@ -22,6 +22,17 @@ const (
 	RouteResumeRequest
 	RouteResumeAccept
 	RouteSessionData
+	RouteMigrateRequest
+	RouteMigrateAccept
+	RouteTransferOffer
+	RouteTransferReply
+	RouteTransferData
+	RouteWipeRequest
+	RouteWipeAccept
+	RouteReconnectHint
+	RouteGroupMessage
+	RouteAck
+	RouteTypedMessage
 )
 
 // String returns the string representation of the route.
@@ -53,6 +64,28 @@ func (r Route) String() string {
 		return "ResumeAccept"
 	case RouteSessionData:
 		return "SessionData"
+	case RouteMigrateRequest:
+		return "MigrateRequest"
+	case RouteMigrateAccept:
+		return "MigrateAccept"
+	case RouteTransferOffer:
+		return "TransferOffer"
+	case RouteTransferReply:
+		return "TransferReply"
+	case RouteTransferData:
+		return "TransferData"
+	case RouteWipeRequest:
+		return "WipeRequest"
+	case RouteWipeAccept:
+		return "WipeAccept"
+	case RouteReconnectHint:
+		return "ReconnectHint"
+	case RouteGroupMessage:
+		return "GroupMessage"
+	case RouteAck:
+		return "Ack"
+	case RouteTypedMessage:
+		return "TypedMessage"
 	default:
 		return "Invalid"
 	}
@@ -60,7 +93,7 @@ func (r Route) String() string {
 
 // IsValid returns true if the route is a valid, non-invalid route.
 func (r Route) IsValid() bool {
-	return r > RouteInvalid && r <= RouteSessionData
+	return r > RouteInvalid && r <= RouteTypedMessage
 }
 
 // ToProto converts the Route to its protobuf enum representation.
@@ -92,6 +125,28 @@ func (r Route) ToProto() pb.Route {
 		return pb.Route_ROUTE_RESUME_ACCEPT
 	case RouteSessionData:
 		return pb.Route_ROUTE_SESSION_DATA
+	case RouteMigrateRequest:
+		return pb.Route_ROUTE_MIGRATE_REQUEST
+	case RouteMigrateAccept:
+		return pb.Route_ROUTE_MIGRATE_ACCEPT
+	case RouteTransferOffer:
+		return pb.Route_ROUTE_TRANSFER_OFFER
+	case RouteTransferReply:
+		return pb.Route_ROUTE_TRANSFER_REPLY
+	case RouteTransferData:
+		return pb.Route_ROUTE_TRANSFER_DATA
+	case RouteWipeRequest:
+		return pb.Route_ROUTE_WIPE_REQUEST
+	case RouteWipeAccept:
+		return pb.Route_ROUTE_WIPE_ACCEPT
+	case RouteReconnectHint:
+		return pb.Route_ROUTE_RECONNECT_HINT
+	case RouteGroupMessage:
+		return pb.Route_ROUTE_GROUP_MESSAGE
+	case RouteAck:
+		return pb.Route_ROUTE_ACK
+	case RouteTypedMessage:
+		return pb.Route_ROUTE_TYPED_MESSAGE
 	default:
 		return pb.Route_ROUTE_INVALID
 	}
@@ -126,6 +181,28 @@ func RouteFromProto(r pb.Route) Route {
 		return RouteResumeAccept
 	case pb.Route_ROUTE_SESSION_DATA:
 		return RouteSessionData
+	case pb.Route_ROUTE_MIGRATE_REQUEST:
+		return RouteMigrateRequest
+	case pb.Route_ROUTE_MIGRATE_ACCEPT:
+		return RouteMigrateAccept
+	case pb.Route_ROUTE_TRANSFER_OFFER:
+		return RouteTransferOffer
+	case pb.Route_ROUTE_TRANSFER_REPLY:
+		return RouteTransferReply
+	case pb.Route_ROUTE_TRANSFER_DATA:
+		return RouteTransferData
+	case pb.Route_ROUTE_WIPE_REQUEST:
+		return RouteWipeRequest
+	case pb.Route_ROUTE_WIPE_ACCEPT:
+		return RouteWipeAccept
+	case pb.Route_ROUTE_RECONNECT_HINT:
+		return RouteReconnectHint
+	case pb.Route_ROUTE_GROUP_MESSAGE:
+		return RouteGroupMessage
+	case pb.Route_ROUTE_ACK:
+		return RouteAck
+	case pb.Route_ROUTE_TYPED_MESSAGE:
+		return RouteTypedMessage
 	default:
 		return RouteInvalid
 	}
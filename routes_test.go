@@ -29,6 +29,17 @@ func TestRouteString(t *testing.T) {
 		{"ResumeRequest", RouteResumeRequest},
 		{"ResumeAccept", RouteResumeAccept},
 		{"SessionData", RouteSessionData},
+		{"MigrateRequest", RouteMigrateRequest},
+		{"MigrateAccept", RouteMigrateAccept},
+		{"TransferOffer", RouteTransferOffer},
+		{"TransferReply", RouteTransferReply},
+		{"TransferData", RouteTransferData},
+		{"WipeRequest", RouteWipeRequest},
+		{"WipeAccept", RouteWipeAccept},
+		{"ReconnectHint", RouteReconnectHint},
+		{"GroupMessage", RouteGroupMessage},
+		{"Ack", RouteAck},
+		{"TypedMessage", RouteTypedMessage},
 		{"Invalid", Route(999)},
 	}
 
@@ -55,6 +66,17 @@ func TestRouteIsValid(t *testing.T) {
 		RouteResumeRequest,
 		RouteResumeAccept,
 		RouteSessionData,
+		RouteMigrateRequest,
+		RouteMigrateAccept,
+		RouteTransferOffer,
+		RouteTransferReply,
+		RouteTransferData,
+		RouteWipeRequest,
+		RouteWipeAccept,
+		RouteReconnectHint,
+		RouteGroupMessage,
+		RouteAck,
+		RouteTypedMessage,
 	}
 
 	for _, route := range validRoutes {
@@ -95,6 +117,17 @@ func TestRouteProtoConversion(t *testing.T) {
 		{RouteResumeRequest, pb.Route_ROUTE_RESUME_REQUEST},
 		{RouteResumeAccept, pb.Route_ROUTE_RESUME_ACCEPT},
 		{RouteSessionData, pb.Route_ROUTE_SESSION_DATA},
+		{RouteMigrateRequest, pb.Route_ROUTE_MIGRATE_REQUEST},
+		{RouteMigrateAccept, pb.Route_ROUTE_MIGRATE_ACCEPT},
+		{RouteTransferOffer, pb.Route_ROUTE_TRANSFER_OFFER},
+		{RouteTransferReply, pb.Route_ROUTE_TRANSFER_REPLY},
+		{RouteTransferData, pb.Route_ROUTE_TRANSFER_DATA},
+		{RouteWipeRequest, pb.Route_ROUTE_WIPE_REQUEST},
+		{RouteWipeAccept, pb.Route_ROUTE_WIPE_ACCEPT},
+		{RouteReconnectHint, pb.Route_ROUTE_RECONNECT_HINT},
+		{RouteGroupMessage, pb.Route_ROUTE_GROUP_MESSAGE},
+		{RouteAck, pb.Route_ROUTE_ACK},
+		{RouteTypedMessage, pb.Route_ROUTE_TYPED_MESSAGE},
 		{RouteInvalid, pb.Route_ROUTE_INVALID},
 	}
 
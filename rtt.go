@@ -0,0 +1,95 @@
+package kamune
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// rttWindow is the number of recent round-trip samples kept.
+	rttWindow = 16
+	// maxPendingPings bounds the pings awaiting a pong. Once it is reached,
+	// the oldest is given up on, since its pong may never come.
+	maxPendingPings = 16
+)
+
+// rttTracker measures the round-trip time to the peer from each ping to the
+// pong that echoes its payload, as every pong handler does.
+type rttTracker struct {
+	pending  map[string]time.Time
+	samples  []time.Duration
+	smoothed time.Duration
+	min      time.Duration
+	mu       sync.Mutex
+}
+
+// sent records that a ping carrying message was just written.
+func (r *rttTracker) sent(message Transferable) {
+	key, err := proto.MarshalOptions{Deterministic: true}.Marshal(message)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending == nil {
+		r.pending = make(map[string]time.Time)
+	}
+	if len(r.pending) >= maxPendingPings {
+		var oldest string
+		var oldestAt time.Time
+		for k, at := range r.pending {
+			if oldestAt.IsZero() || at.Before(oldestAt) {
+				oldest, oldestAt = k, at
+			}
+		}
+		delete(r.pending, oldest)
+	}
+	r.pending[string(key)] = time.Now()
+}
+
+// answered records a sample if msg, the payload of a received pong, echoes
+// a pending ping.
+func (r *rttTracker) answered(msg []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sent, ok := r.pending[string(msg)]
+	if !ok {
+		return
+	}
+	delete(r.pending, string(msg))
+	r.add(time.Since(sent))
+}
+
+// observe records a sample measured by other means than a ping.
+func (r *rttTracker) observe(sample time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.add(sample)
+}
+
+// add records a sample. The smoothed time follows RFC 6298, weighing each new
+// sample by 1/8. r.mu must be held.
+func (r *rttTracker) add(sample time.Duration) {
+	if len(r.samples) == 0 {
+		r.smoothed = sample
+		r.min = sample
+	} else {
+		r.smoothed += (sample - r.smoothed) / 8
+		r.min = min(r.min, sample)
+	}
+	if len(r.samples) == rttWindow {
+		r.samples = r.samples[1:]
+	}
+	r.samples = append(r.samples, sample)
+}
+
+// snapshot fills in the round-trip fields of st.
+func (r *rttTracker) snapshot(st *TransportStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st.RTT = r.smoothed
+	st.MinRTT = r.min
+	st.RTTSamples = append([]time.Duration(nil), r.samples...)
+}
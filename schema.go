@@ -0,0 +1,299 @@
+package kamune
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// capabilitySchemaPrefix starts the capability a peer advertises for every
+// schema it knows, followed by "<name>@<first>-<last>".
+const capabilitySchemaPrefix = "schema/"
+
+// Schema describes the versions of an application message type carried on a
+// route, such as a chat message that gained reactions in its second version.
+// Each version is its own protobuf type, and consecutive versions are linked
+// by conversions, so peers running different releases can still talk in the
+// newest version both of them know.
+type Schema struct {
+	// Name identifies the schema between peers. It must not contain commas.
+	Name  string
+	Route Route
+	// First is the number of Versions[0]; zero means 1. Raising it drops
+	// support for older versions.
+	First    uint32
+	Versions []SchemaVersion
+}
+
+// SchemaVersion is one version of a [Schema].
+type SchemaVersion struct {
+	// Message is a value of the version's type; a nil pointer is enough.
+	Message Transferable
+	// Upgrade converts a message of the previous version into this one.
+	// Downgrade converts a message of this version into the previous one.
+	// Both are required for every version but the first.
+	Upgrade   func(Transferable) (Transferable, error)
+	Downgrade func(Transferable) (Transferable, error)
+}
+
+type schemaEntry struct {
+	types []protoreflect.MessageType
+	Schema
+}
+
+func (s *schemaEntry) last() uint32 {
+	return s.First + uint32(len(s.Versions)) - 1
+}
+
+// SchemaRegistry is a [TypeRegistry] for messages whose type evolves. Peers
+// advertise the versions they know of each schema in their introduction, and
+// every message is sent in the newest version both sides know. The sender
+// converts its message down, or up, to that version, and the receiver
+// converts it up to its own newest version, so the application only ever
+// handles the latest type. When the peers share no version of a schema, its
+// messages are refused with [ErrSchemaMismatch] instead of being decoded into
+// the wrong type.
+//
+// Register every schema before passing the registry to [ServeWithSchemas] or
+// [DialWithSchemas], which advertise what is registered at that time. It is
+// safe for concurrent use.
+type SchemaRegistry struct {
+	byRoute map[Route]*schemaEntry
+	byName  map[string]*schemaEntry
+	// versions maps each version's message type to its schema and number.
+	versions map[protoreflect.FullName]schemaVersionRef
+	mu       sync.RWMutex
+}
+
+type schemaVersionRef struct {
+	schema  *schemaEntry
+	version uint32
+}
+
+// NewSchemaRegistry returns an empty SchemaRegistry.
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{
+		byRoute:  make(map[Route]*schemaEntry),
+		byName:   make(map[string]*schemaEntry),
+		versions: make(map[protoreflect.FullName]schemaVersionRef),
+	}
+}
+
+// Register adds s to the registry. Each route carries at most one schema,
+// and each message type belongs to at most one version.
+func (r *SchemaRegistry) Register(s Schema) error {
+	if !s.Route.IsValid() {
+		return fmt.Errorf("%w: %d", ErrInvalidRoute, s.Route)
+	}
+	if s.Name == "" || strings.Contains(s.Name, ",") {
+		return fmt.Errorf("invalid schema name %q", s.Name)
+	}
+	if len(s.Versions) == 0 {
+		return fmt.Errorf("schema %s has no versions", s.Name)
+	}
+	if s.First == 0 {
+		s.First = 1
+	}
+	e := &schemaEntry{Schema: s}
+	for i, v := range s.Versions {
+		if v.Message == nil {
+			return fmt.Errorf(
+				"schema %s: version %d has no message",
+				s.Name, s.First+uint32(i),
+			)
+		}
+		if i > 0 && (v.Upgrade == nil || v.Downgrade == nil) {
+			return fmt.Errorf(
+				"schema %s: version %d needs both conversions", s.Name,
+				s.First+uint32(i),
+			)
+		}
+		e.types = append(e.types, v.Message.ProtoReflect().Type())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prev, ok := r.byRoute[s.Route]; ok {
+		return fmt.Errorf(
+			"route %s already carries schema %s", s.Route, prev.Name,
+		)
+	}
+	if _, ok := r.byName[s.Name]; ok {
+		return fmt.Errorf("schema %s is already registered", s.Name)
+	}
+	for _, mt := range e.types {
+		name := mt.Descriptor().FullName()
+		if prev, ok := r.versions[name]; ok {
+			return fmt.Errorf(
+				"%s is already version %d of schema %s",
+				name, prev.version, prev.schema.Name,
+			)
+		}
+	}
+	for i, mt := range e.types {
+		r.versions[mt.Descriptor().FullName()] = schemaVersionRef{
+			schema: e, version: s.First + uint32(i),
+		}
+	}
+	r.byRoute[s.Route] = e
+	r.byName[s.Name] = e
+	return nil
+}
+
+// capabilities returns the capabilities advertising the registered schemas.
+func (r *SchemaRegistry) capabilities() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	caps := make([]string, 0, len(r.byName))
+	for _, e := range r.byName {
+		caps = append(caps, fmt.Sprintf(
+			"%s%s@%d-%d", capabilitySchemaPrefix, e.Name, e.First, e.last(),
+		))
+	}
+	slices.Sort(caps)
+	return caps
+}
+
+// remoteSchemaRange returns the versions of the schema name the peer of t
+// advertised.
+func remoteSchemaRange(t *Transport, name string) (uint32, uint32, bool) {
+	if t.remotePeer == nil {
+		return 0, 0, false
+	}
+	prefix := capabilitySchemaPrefix + name + "@"
+	for _, c := range t.remotePeer.Capabilities {
+		versions, ok := strings.CutPrefix(c, prefix)
+		if !ok {
+			continue
+		}
+		lo, hi, ok := strings.Cut(versions, "-")
+		if !ok {
+			return 0, 0, false
+		}
+		first, err := strconv.ParseUint(lo, 10, 32)
+		if err != nil {
+			return 0, 0, false
+		}
+		last, err := strconv.ParseUint(hi, 10, 32)
+		if err != nil || last < first {
+			return 0, 0, false
+		}
+		return uint32(first), uint32(last), true
+	}
+	return 0, 0, false
+}
+
+// Version returns the version of the schema name used on t: the newest one
+// both peers know.
+func (r *SchemaRegistry) Version(t *Transport, name string) (uint32, error) {
+	r.mu.RLock()
+	e, ok := r.byName[name]
+	r.mu.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("unknown schema %s", name)
+	}
+	return e.version(t)
+}
+
+func (e *schemaEntry) version(t *Transport) (uint32, error) {
+	first, last, ok := remoteSchemaRange(t, e.Name)
+	if !ok {
+		return 0, fmt.Errorf(
+			"%w: peer does not support schema %s", ErrSchemaMismatch, e.Name,
+		)
+	}
+	v := min(last, e.last())
+	if v < max(first, e.First) {
+		return 0, fmt.Errorf(
+			"%w: %s versions %d-%d, peer has %d-%d", ErrSchemaMismatch,
+			e.Name, e.First, e.last(), first, last,
+		)
+	}
+	return v, nil
+}
+
+// convert converts msg from version from to version to, one step at a time.
+func (e *schemaEntry) convert(
+	msg Transferable, from, to uint32,
+) (Transferable, error) {
+	var err error
+	for ; from < to; from++ {
+		msg, err = e.Versions[from+1-e.First].Upgrade(msg)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"upgrading %s to version %d: %w", e.Name, from+1, err,
+			)
+		}
+	}
+	for ; from > to; from-- {
+		msg, err = e.Versions[from-e.First].Downgrade(msg)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"downgrading %s to version %d: %w", e.Name, from-1, err,
+			)
+		}
+	}
+	return msg, nil
+}
+
+// Send converts msg, which may be of any registered version, to the version
+// negotiated with the peer of t and sends it on its schema's route.
+func (r *SchemaRegistry) Send(
+	t *Transport, msg Transferable,
+) (*Metadata, error) {
+	name := msg.ProtoReflect().Descriptor().FullName()
+	r.mu.RLock()
+	ref, ok := r.versions[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnregisteredRoute, name)
+	}
+	e := ref.schema
+	v, err := e.version(t)
+	if err != nil {
+		return nil, err
+	}
+	msg, err = e.convert(msg, ref.version, v)
+	if err != nil {
+		return nil, err
+	}
+	return t.Send(msg, e.Route)
+}
+
+// Receive reads the next message from t, decodes it as the version
+// negotiated for its route's schema, and converts it to the newest
+// registered version. As with [TypeRegistry.Receive], a message on a route
+// without a schema is consumed and reported with [ErrUnregisteredRoute]; one
+// whose schema the peers share no version of is consumed and reported with
+// [ErrSchemaMismatch]. In both cases the metadata is returned.
+func (r *SchemaRegistry) Receive(
+	t *Transport,
+) (Transferable, *Metadata, error) {
+	md, data, err := t.receive()
+	if err != nil {
+		return nil, nil, err
+	}
+	r.mu.RLock()
+	e, ok := r.byRoute[md.Route()]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, md, fmt.Errorf("%w: %s", ErrUnregisteredRoute, md.Route())
+	}
+	v, err := e.version(t)
+	if err != nil {
+		return nil, md, err
+	}
+	msg := e.types[v-e.First].New().Interface()
+	if err := t.unmarshal(data, msg); err != nil {
+		return nil, nil, err
+	}
+	msg, err = e.convert(msg, v, e.last())
+	if err != nil {
+		return nil, md, err
+	}
+	return msg, md, nil
+}
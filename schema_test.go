@@ -0,0 +1,179 @@
+package kamune
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// chatSchema returns a chat schema whose first version is plain text and
+// whose second adds a reaction. With v2 false, only the first is known.
+func chatSchema(v2 bool) Schema {
+	s := Schema{
+		Name:     "chat",
+		Route:    RouteExchangeMessages,
+		Versions: []SchemaVersion{{Message: (*wrapperspb.StringValue)(nil)}},
+	}
+	if !v2 {
+		return s
+	}
+	s.Versions = append(s.Versions, SchemaVersion{
+		Message: (*structpb.Struct)(nil),
+		Upgrade: func(m Transferable) (Transferable, error) {
+			return structpb.NewStruct(map[string]any{
+				"text": m.(*wrapperspb.StringValue).GetValue(),
+			})
+		},
+		Downgrade: func(m Transferable) (Transferable, error) {
+			text := m.(*structpb.Struct).GetFields()["text"].GetStringValue()
+			return wrapperspb.String(text), nil
+		},
+	})
+	return s
+}
+
+func TestSchemaRegistry_Register(t *testing.T) {
+	a := require.New(t)
+	r := NewSchemaRegistry()
+	a.NoError(r.Register(chatSchema(true)))
+
+	noConversions := chatSchema(true)
+	noConversions.Name, noConversions.Route = "other", RouteSessionData
+	noConversions.Versions[1].Downgrade = nil
+	typeTaken := chatSchema(false)
+	typeTaken.Name, typeTaken.Route = "other", RouteSessionData
+	nameTaken := chatSchema(false)
+	nameTaken.Route = RouteSessionData
+
+	tests := []struct {
+		name   string
+		schema Schema
+	}{
+		{name: "invalid route", schema: Schema{Name: "x", Route: RouteInvalid}},
+		{name: "invalid name", schema: Schema{
+			Name: "a,b", Route: RouteSessionData,
+		}},
+		{name: "no versions", schema: Schema{
+			Name: "x", Route: RouteSessionData,
+		}},
+		{name: "no conversions", schema: noConversions},
+		{name: "route taken", schema: chatSchema(false)},
+		{name: "type taken", schema: typeTaken},
+		{name: "name taken", schema: nameTaken},
+	}
+	for _, tc := range tests {
+		a.Error(r.Register(tc.schema), tc.name)
+	}
+
+	a.NoError(r.Register(Schema{
+		Name:     "counter",
+		Route:    RouteSessionData,
+		First:    3,
+		Versions: []SchemaVersion{{Message: (*wrapperspb.Int64Value)(nil)}},
+	}))
+	a.Equal(
+		[]string{"schema/chat@1-2", "schema/counter@3-3"}, r.capabilities(),
+	)
+}
+
+func TestSchemaRegistry_SendReceive(t *testing.T) {
+	a := require.New(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+
+	// The server knows both versions and answers every chat message with a
+	// reaction, echoing its text.
+	server := NewSchemaRegistry()
+	a.NoError(server.Register(chatSchema(true)))
+	handler := func(t *Transport) error {
+		for {
+			msg, _, err := server.Receive(t)
+			if err != nil {
+				return nil
+			}
+			fields := msg.(*structpb.Struct).GetFields()
+			reply, err := structpb.NewStruct(map[string]any{
+				"text":     fields["text"].GetStringValue(),
+				"reaction": "👍",
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := server.Send(t, reply); err != nil {
+				return err
+			}
+		}
+	}
+	serverStore, cleanup := newTestStore(t)
+	defer cleanup()
+	srv, err := NewServer(
+		"", handler, serverStore, acceptAll,
+		ServeWithListener(&tcpListener{Listener: l}),
+		ServeWithSchemas(server),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	dial := func(opts ...DialOption) *Transport {
+		store, cleanup := newTestStore(t)
+		t.Cleanup(cleanup)
+		d, err := NewDialer(l.Addr().String(), store, acceptAll, opts...)
+		a.NoError(err)
+		tr, err := d.Dial()
+		a.NoError(err)
+		t.Cleanup(func() { _ = tr.Close() })
+		return tr
+	}
+
+	tests := []struct {
+		name    string
+		v2      bool
+		version uint32
+	}{
+		{name: "same versions", v2: true, version: 2},
+		{name: "older peer", version: 1},
+	}
+	for _, tc := range tests {
+		client := NewSchemaRegistry()
+		a.NoError(client.Register(chatSchema(tc.v2)))
+		tr := dial(DialWithSchemas(client))
+
+		v, err := client.Version(tr, "chat")
+		a.NoError(err, tc.name)
+		a.Equal(tc.version, v, tc.name)
+
+		_, err = client.Send(tr, wrapperspb.String("hello"))
+		a.NoError(err, tc.name)
+		msg, md, err := client.Receive(tr)
+		a.NoError(err, tc.name)
+		a.Equal(RouteExchangeMessages, md.Route(), tc.name)
+		if !tc.v2 {
+			a.Equal("hello", msg.(*wrapperspb.StringValue).GetValue())
+			continue
+		}
+		fields := msg.(*structpb.Struct).GetFields()
+		a.Equal("hello", fields["text"].GetStringValue(), tc.name)
+		a.Equal("👍", fields["reaction"].GetStringValue(), tc.name)
+	}
+
+	// A peer that shares no version is refused before anything is sent.
+	client := NewSchemaRegistry()
+	newer := chatSchema(true)
+	newer.First = 3
+	newer.Versions = newer.Versions[1:]
+	newer.Versions[0].Upgrade, newer.Versions[0].Downgrade = nil, nil
+	a.NoError(client.Register(newer))
+	tr := dial(DialWithSchemas(client))
+	_, err = client.Send(tr, &structpb.Struct{})
+	a.ErrorIs(err, ErrSchemaMismatch)
+
+	tr = dial()
+	_, err = client.Send(tr, &structpb.Struct{})
+	a.ErrorIs(err, ErrSchemaMismatch)
+	_, err = client.Send(tr, wrapperspb.Bool(true))
+	a.ErrorIs(err, ErrUnregisteredRoute)
+}
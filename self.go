@@ -0,0 +1,152 @@
+package kamune
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/enigma"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// NoteToSelf opens the note-to-self session of the identity in store, for
+// notes and files the user sends to themselves; see
+// [storage.Storage.SelfSession]. It runs no handshake and needs no network:
+// every message sent on the returned transport is received back on it, as
+// it would be by another device of the user, so applications record each
+// message once, as sent, under [storage.SelfSessionID]. Transfers work as
+// with any peer, which the transport's handler is offered back.
+//
+// The session keeps its ID and chat history across calls, but messages that
+// were not received before the transport was closed are gone.
+func NoteToSelf(store *storage.Storage) (*Transport, error) {
+	at, err := store.Attester()
+	if err != nil {
+		return nil, fmt.Errorf("loading attester: %w", err)
+	}
+	sessionID, err := store.SelfSession()
+	if err != nil {
+		return nil, err
+	}
+	peer, err := store.GetPeer(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generating session key: %w", err)
+	}
+	cipher, err := enigma.NewEnigma(secret, nil, []byte(selfSessionInfo))
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	t := newTransport(
+		newLoopbackConn(),
+		newSignedSerde(peer.PublicKey, at),
+		sessionID,
+		cipher,
+		cipher,
+	)
+	t.remotePeer = peer
+	t.bindStorage(store, false)
+	t.enableCapabilities(nil)
+
+	slog.Info(
+		"note-to-self session opened", slog.String("session_id", sessionID),
+	)
+	return t, nil
+}
+
+// loopbackConn is a [Conn] to itself: every frame written to it is read
+// back from it, in order. Writes never block, so that a transport can send
+// to itself from the goroutine receiving from it.
+type loopbackConn struct {
+	// wake is signalled whenever a frame is queued, the connection is
+	// closed, or the deadline changes.
+	wake     chan struct{}
+	frames   [][]byte
+	deadline time.Time
+	mu       sync.Mutex
+	closed   bool
+}
+
+func newLoopbackConn() *loopbackConn {
+	return &loopbackConn{wake: make(chan struct{}, 1)}
+}
+
+func (c *loopbackConn) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *loopbackConn) ReadBytes() ([]byte, error) {
+	for {
+		c.mu.Lock()
+		switch {
+		case len(c.frames) > 0:
+			frame := c.frames[0]
+			c.frames[0] = nil
+			c.frames = c.frames[1:]
+			c.mu.Unlock()
+			return frame, nil
+		case c.closed:
+			c.mu.Unlock()
+			return nil, io.EOF
+		case !c.deadline.IsZero() && !time.Now().Before(c.deadline):
+			c.mu.Unlock()
+			return nil, os.ErrDeadlineExceeded
+		}
+		deadline := c.deadline
+		c.mu.Unlock()
+
+		if deadline.IsZero() {
+			<-c.wake
+			continue
+		}
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-c.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (c *loopbackConn) WriteBytes(b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrConnClosed
+	}
+	c.frames = append(c.frames, append([]byte(nil), b...))
+	c.signal()
+	return nil
+}
+
+func (c *loopbackConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	c.signal()
+	return nil
+}
+
+func (c *loopbackConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrConnClosed
+	}
+	c.closed = true
+	c.frames = nil
+	c.signal()
+	return nil
+}
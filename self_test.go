@@ -0,0 +1,87 @@
+package kamune
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+func TestNoteToSelf(t *testing.T) {
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	self, err := store.PublicKey()
+	a.NoError(err)
+
+	tr, err := NoteToSelf(store)
+	a.NoError(err)
+	a.Equal(storage.SelfSessionID, tr.SessionID())
+	a.Equal(self, tr.remotePeer.PublicKey)
+
+	// Sending does not wait for the message to be received.
+	for _, text := range []string{"first", "second"} {
+		_, err := tr.Send(Bytes([]byte(text)), RouteExchangeMessages)
+		a.NoError(err)
+	}
+	for _, text := range []string{"first", "second"} {
+		msg := Bytes(nil)
+		md, err := tr.Receive(msg)
+		a.NoError(err)
+		a.Equal(text, string(msg.GetValue()))
+		a.Equal(RouteExchangeMessages, md.Route())
+	}
+	_, err = tr.ReceiveDeadline(Bytes(nil), time.Now().Add(10*time.Millisecond))
+	a.ErrorIs(err, ErrReceiveTimeout)
+
+	// Files are offered back to the transport's own handler.
+	data := make([]byte, 3*transferChunkSize+7)
+	_, _ = rand.Read(data)
+	received := make(chan []byte, 1)
+	tr.HandleTransfers(func(in *IncomingTransfer) {
+		var buf bytes.Buffer
+		if in.Accept(&buf) == nil {
+			received <- buf.Bytes()
+		}
+	})
+	go func() {
+		for {
+			if _, err := tr.Receive(Bytes(nil)); err != nil {
+				return
+			}
+		}
+	}()
+	err = tr.Transfer(
+		context.Background(),
+		TransferOffer{Name: "notes.bin", Size: uint64(len(data))},
+		bytes.NewReader(data),
+	)
+	a.NoError(err)
+	select {
+	case got := <-received:
+		a.Equal(data, got)
+	case <-time.After(5 * time.Second):
+		a.FailNow("transfer was not received")
+	}
+	a.NoError(tr.Close())
+	_, err = tr.Send(Bytes([]byte("late")), RouteExchangeMessages)
+	a.Error(err)
+
+	// The session, unlike the messages in flight, outlives the transport.
+	a.NoError(store.AddChatEntry(
+		tr.SessionID(), []byte("note"), time.Now(), storage.SenderLocal,
+	))
+	tr, err = NoteToSelf(store)
+	a.NoError(err)
+	defer tr.Close()
+	history, err := store.GetChatHistory(tr.SessionID())
+	a.NoError(err)
+	a.Len(history, 1)
+	_, err = tr.ReceiveDeadline(Bytes(nil), time.Now().Add(10*time.Millisecond))
+	a.ErrorIs(err, ErrReceiveTimeout)
+}
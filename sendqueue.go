@@ -0,0 +1,168 @@
+package kamune
+
+import "sync"
+
+// Priority selects the send lane a message is queued on. Messages on a higher
+// priority lane are written before any queued messages on lower lanes, while
+// ordering within a single lane is preserved.
+type Priority uint8
+
+const (
+	// PriorityNormal is the default lane for application messages.
+	PriorityNormal Priority = iota
+	// PriorityControl is for small control messages (acks, presence, pings)
+	// that should not wait behind queued application traffic.
+	PriorityControl
+	// PriorityBulk is for large or latency-insensitive payloads such as file
+	// chunks. It is drained only when the other lanes are empty.
+	PriorityBulk
+
+	numPriorities = 3
+)
+
+// laneOrder is the order in which lanes are drained by the writer.
+var laneOrder = [numPriorities]Priority{
+	PriorityControl, PriorityNormal, PriorityBulk,
+}
+
+// String returns the string representation of the priority.
+func (p Priority) String() string {
+	switch p {
+	case PriorityNormal:
+		return "Normal"
+	case PriorityControl:
+		return "Control"
+	case PriorityBulk:
+		return "Bulk"
+	default:
+		return "Invalid"
+	}
+}
+
+// IsValid returns true if the priority is a known lane.
+func (p Priority) IsValid() bool { return p < numPriorities }
+
+// priorityForRoute returns the default lane for a protocol route.
+func priorityForRoute(r Route) Priority {
+	switch r {
+	case RoutePing, RoutePong, RouteCloseTransport, RouteReconnectHint,
+		RouteAck:
+		return PriorityControl
+	case RouteTransferData:
+		return PriorityBulk
+	default:
+		return PriorityNormal
+	}
+}
+
+// sendRequest is a single queued message waiting to be written.
+type sendRequest struct {
+	message  Transferable
+	metadata *Metadata
+	err      error
+	ready    chan struct{}
+	hash     []byte
+	route    Route
+	priority Priority
+	written  bool
+	// ephemeral is set by [Transport.SendEphemeral].
+	ephemeral bool
+	// id and ack are set by [Transport.SendWithAck], and number is the
+	// message's number for retransmission once written, if it has one.
+	id     string
+	ack    bool
+	number uint64
+}
+
+// sendQueue serializes writes to a connection while letting higher priority
+// lanes overtake queued lower priority messages.
+//
+// It uses a flat-combining scheme instead of a dedicated writer goroutine: the
+// first caller to find the queue idle becomes the writer and drains requests,
+// highest lane first, until its own request has been written. If requests are
+// still pending at that point, the writer role is handed to the next one in
+// line. Waiting callers are woken either when their request is written or when
+// they are promoted to writer.
+type sendQueue struct {
+	write   func(*sendRequest)
+	lanes   [numPriorities][]*sendRequest
+	mu      sync.Mutex
+	writing bool
+}
+
+func newSendQueue(write func(*sendRequest)) *sendQueue {
+	return &sendQueue{write: write}
+}
+
+// submit enqueues req and blocks until it has been written. The result is
+// stored in req.metadata and req.err.
+func (q *sendQueue) submit(req *sendRequest) {
+	req.ready = make(chan struct{})
+
+	q.mu.Lock()
+	q.lanes[req.priority] = append(q.lanes[req.priority], req)
+	if q.writing {
+		q.mu.Unlock()
+		<-req.ready
+		if req.written {
+			return
+		}
+		// Promoted to writer by the previous one.
+		q.mu.Lock()
+	}
+	q.writing = true
+
+	for !req.written {
+		next := q.popLocked()
+		q.mu.Unlock()
+		q.write(next)
+		q.mu.Lock()
+		next.written = true
+		if next != req {
+			close(next.ready)
+		}
+	}
+
+	if head := q.peekLocked(); head != nil {
+		close(head.ready)
+	} else {
+		q.writing = false
+	}
+	q.mu.Unlock()
+}
+
+// peekLocked returns the next request to be written without removing it.
+// Caller must hold q.mu.
+func (q *sendQueue) peekLocked() *sendRequest {
+	for _, p := range laneOrder {
+		if len(q.lanes[p]) > 0 {
+			return q.lanes[p][0]
+		}
+	}
+	return nil
+}
+
+// popLocked removes and returns the next request to be written. Caller must
+// hold q.mu.
+func (q *sendQueue) popLocked() *sendRequest {
+	for _, p := range laneOrder {
+		if lane := q.lanes[p]; len(lane) > 0 {
+			req := lane[0]
+			lane[0] = nil
+			q.lanes[p] = lane[1:]
+			return req
+		}
+	}
+	return nil
+}
+
+// pending returns the number of queued requests per lane.
+func (q *sendQueue) pending() [numPriorities]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var n [numPriorities]int
+	for p, lane := range q.lanes {
+		n[p] = len(lane)
+	}
+	return n
+}
@@ -0,0 +1,151 @@
+package kamune
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/internal/enigma"
+	"github.com/kamune-org/kamune/pkg/attest"
+)
+
+// gatedConn records written frames. The first write blocks until release is
+// closed, which lets tests queue messages behind an in-flight write.
+type gatedConn struct {
+	release chan struct{}
+	started chan struct{}
+	frames  [][]byte
+	mu      sync.Mutex
+	once    sync.Once
+}
+
+func newGatedConn() *gatedConn {
+	return &gatedConn{
+		release: make(chan struct{}),
+		started: make(chan struct{}),
+	}
+}
+
+func (c *gatedConn) WriteBytes(b []byte) error {
+	c.once.Do(func() {
+		close(c.started)
+		<-c.release
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frames = append(c.frames, b)
+	return nil
+}
+
+func (c *gatedConn) ReadBytes() ([]byte, error)    { return nil, ErrConnClosed }
+func (c *gatedConn) SetDeadline(t time.Time) error { return nil }
+func (c *gatedConn) Close() error                  { return nil }
+
+func newLoopbackTransport(t *testing.T, cn Conn) *Transport {
+	t.Helper()
+	a := require.New(t)
+	at, err := attest.New()
+	a.NoError(err)
+	secret := randomBytes(32)
+	salt := randomBytes(handshakeSaltSize)
+	encoder, err := enigma.NewEnigma(secret, salt, []byte("test"))
+	a.NoError(err)
+	decoder, err := enigma.NewEnigma(secret, salt, []byte("test"))
+	a.NoError(err)
+	serde := newSignedSerde(at.MarshalPublicKey(), at)
+	return newTransport(cn, serde, "session", encoder, decoder)
+}
+
+func TestSendQueue_PriorityOvertakes(t *testing.T) {
+	a := require.New(t)
+	cn := newGatedConn()
+	tr := newLoopbackTransport(t, cn)
+
+	var wg sync.WaitGroup
+	send := func(v string, p Priority) {
+		wg.Go(func() {
+			_, err := tr.SendWithPriority(
+				Bytes([]byte(v)), RouteExchangeMessages, p,
+			)
+			a.NoError(err)
+		})
+	}
+
+	// The first message occupies the writer until released.
+	send("first", PriorityNormal)
+	<-cn.started
+
+	send("bulk", PriorityBulk)
+	a.Eventually(func() bool {
+		return tr.queue.pending()[PriorityBulk] == 1
+	}, time.Second, time.Millisecond)
+	send("normal-1", PriorityNormal)
+	a.Eventually(func() bool {
+		return tr.queue.pending()[PriorityNormal] == 1
+	}, time.Second, time.Millisecond)
+	send("normal-2", PriorityNormal)
+	send("control", PriorityControl)
+	a.Eventually(func() bool {
+		n := tr.queue.pending()
+		return n[PriorityNormal] == 2 && n[PriorityControl] == 1
+	}, time.Second, time.Millisecond)
+
+	close(cn.release)
+	wg.Wait()
+
+	expected := []string{"first", "control", "normal-1", "normal-2", "bulk"}
+	a.Len(cn.frames, len(expected))
+	for i, frame := range cn.frames {
+		decrypted, err := tr.decoder.Decrypt(frame)
+		a.NoError(err)
+		msg := Bytes(nil)
+		md, err := tr.serde.deserialize(decrypted, msg)
+		a.NoError(err)
+		a.Equal(expected[i], string(msg.Value))
+		a.Equal(uint64(i+1), md.SequenceNum(), "sequence follows wire order")
+	}
+}
+
+func TestSendQueue_Concurrent(t *testing.T) {
+	a := require.New(t)
+	cn := newGatedConn()
+	close(cn.release)
+	tr := newLoopbackTransport(t, cn)
+
+	const n = 200
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Go(func() {
+			p := Priority(i % numPriorities)
+			_, err := tr.SendWithPriority(Bytes(nil), RouteExchangeMessages, p)
+			a.NoError(err)
+		})
+	}
+	wg.Wait()
+
+	a.Len(cn.frames, n)
+	a.Equal([numPriorities]int{}, tr.queue.pending())
+	a.Equal(uint64(n), tr.sendSequence)
+}
+
+func TestSendWithPriority_Invalid(t *testing.T) {
+	a := require.New(t)
+	tr := newLoopbackTransport(t, newGatedConn())
+
+	_, err := tr.SendWithPriority(Bytes(nil), RouteExchangeMessages, 42)
+	a.ErrorIs(err, ErrInvalidPriority)
+	_, err = tr.SendWithPriority(Bytes(nil), RouteInvalid, PriorityNormal)
+	a.ErrorIs(err, ErrInvalidRoute)
+}
+
+func TestPriorityForRoute(t *testing.T) {
+	a := require.New(t)
+	a.Equal(PriorityControl, priorityForRoute(RoutePing))
+	a.Equal(PriorityControl, priorityForRoute(RoutePong))
+	a.Equal(PriorityControl, priorityForRoute(RouteCloseTransport))
+	a.Equal(PriorityNormal, priorityForRoute(RouteExchangeMessages))
+	a.Equal(PriorityNormal, priorityForRoute(RouteSessionData))
+	a.Equal(PriorityBulk, priorityForRoute(RouteTransferData))
+}
@@ -1,10 +1,10 @@
 package kamune
 
 import (
+	"cmp"
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
-	mathrand "math/rand/v2"
 
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -17,61 +17,127 @@ import (
 // signature enforcement.
 type signedSerde struct {
 	attest *attest.Attest
+	clock  *hybridClock
 	remote []byte
+	// limit is the largest message the peer accepts, or zero for
+	// maxTransportSize; see [ServeWithMaxMessageSize].
+	limit int
+	// padding chooses the padding of frames, or is nil to choose it at
+	// random; see [ServeWithPaddingSeed].
+	padding *paddingDRBG
+	// framing is the number of bytes the session's frame format adds to
+	// each envelope, which its padding leaves room for.
+	framing int
 }
 
 func newSignedSerde(remote []byte, attest *attest.Attest) *signedSerde {
 	return &signedSerde{
 		remote: remote,
 		attest: attest,
+		clock:  processClock,
 	}
 }
 
 func (s *signedSerde) serialize(
 	msg Transferable, route Route, sequence uint64,
+) ([]byte, *Metadata, error) {
+	return s.serializeWith(msg, route, sequence, nil, nil, metadataMarks{})
+}
+
+// metadataMarks are what a sender asks of the receiver in the metadata of a
+// message.
+type metadataMarks struct {
+	// id replaces the random message ID, for messages sent again.
+	id string
+	// ephemeral is set by [Transport.SendEphemeral].
+	ephemeral bool
+	// ack is set by [Transport.SendWithAck].
+	ack bool
+}
+
+// serializeWith is serialize with payload deduplication: a message that the
+// receiver already holds according to dedup is replaced by a reference to it.
+// A nil dedup disables deduplication. A non-empty heartbeat is carried in the
+// metadata, and so are marks.
+func (s *signedSerde) serializeWith(
+	msg Transferable, route Route, sequence uint64, dedup *dedupCache,
+	heartbeat []byte, marks metadataMarks,
 ) ([]byte, *Metadata, error) {
 	message, err := proto.Marshal(msg)
 	if err != nil {
 		return nil, nil, fmt.Errorf("marshalling message: %w", err)
 	}
-	if len(message) > int(maxTransportSize) {
-		return nil, nil, ErrMessageTooLarge
+	if limit := cmp.Or(s.limit, maxTransportSize); len(message) > limit {
+		return nil, nil, &MessageTooLargeError{
+			Size: len(message), Limit: limit,
+		}
 	}
 	md := &pb.Metadata{
-		ID:        rand.Text(),
-		Timestamp: timestamppb.Now(),
-		Sequence:  sequence,
-		Route:     route.ToProto(),
+		ID:           cmp.Or(marks.id, rand.Text()),
+		Timestamp:    timestamppb.Now(),
+		Sequence:     sequence,
+		Route:        route.ToProto(),
+		Clock:        uint64(s.clock.now()),
+		Heartbeat:    heartbeat,
+		Ephemeral:    marks.ephemeral,
+		AckRequested: marks.ack,
+	}
+	data := message
+	sum, cached := dedup.reference(message)
+	if cached {
+		md.Reference = sum
+		data = nil
 	}
 	metadataBytes, err := proto.Marshal(md)
 	if err != nil {
 		return nil, nil, fmt.Errorf("marshalling metadata: %w", err)
 	}
 
-	sig, err := s.attest.Sign(signingInput(metadataBytes, message))
+	sig, err := s.attest.Sign(signingInput(metadataBytes, data))
 	if err != nil {
 		return nil, nil, fmt.Errorf("signing: %w", err)
 	}
 
 	st := &pb.SignedTransport{
-		Data:      message,
+		Data:      data,
 		Signature: sig,
 		Metadata:  metadataBytes,
 	}
-	payload, err := padSignedTransport(st)
+	payload, err := padSignedTransportWithin(
+		st, frameLimit(s.limit), s.framing, s.padding,
+	)
 	if err != nil {
 		return nil, nil, fmt.Errorf("padding signed transport: %w", err)
 	}
 
-	return payload, &Metadata{md}, nil
+	if sum != nil {
+		dedup.add(sum, message)
+	}
+	return payload, &Metadata{
+		pb: md, raw: metadataBytes, message: message, signature: sig,
+	}, nil
 }
 
 func (s *signedSerde) deserialize(
 	payload []byte, dst Transferable,
 ) (*Metadata, error) {
+	md, msg, err := s.verify(payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := proto.Unmarshal(msg, dst); err != nil {
+		return nil, fmt.Errorf("unmarshalling message: %w", err)
+	}
+	return md, nil
+}
+
+// verify checks the signature of a serialized SignedTransport and returns its
+// metadata together with the still-encoded message, so that the message type
+// can be chosen from the route.
+func (s *signedSerde) verify(payload []byte) (*Metadata, []byte, error) {
 	var st pb.SignedTransport
 	if err := proto.Unmarshal(payload, &st); err != nil {
-		return nil, fmt.Errorf("unmarshalling data: %w", err)
+		return nil, nil, fmt.Errorf("unmarshalling data: %w", err)
 	}
 
 	msg := st.GetData()
@@ -79,18 +145,20 @@ func (s *signedSerde) deserialize(
 	if ok := s.attest.Verify(
 		s.remote, signingInput(metadataBytes, msg), st.Signature,
 	); !ok {
-		return nil, ErrInvalidSignature
+		return nil, nil, ErrInvalidSignature
 	}
 
 	var md pb.Metadata
 	if err := proto.Unmarshal(metadataBytes, &md); err != nil {
-		return nil, fmt.Errorf("unmarshalling metadata: %w", err)
+		return nil, nil, fmt.Errorf("unmarshalling metadata: %w", err)
 	}
-	if err := proto.Unmarshal(msg, dst); err != nil {
-		return nil, fmt.Errorf("unmarshalling message: %w", err)
+	if c := md.GetClock(); c != 0 {
+		s.clock.observe(HybridTime(c))
 	}
 
-	return &Metadata{&md}, nil
+	return &Metadata{
+		pb: &md, raw: metadataBytes, message: msg, signature: st.Signature,
+	}, msg, nil
 }
 
 // signingInput constructs the domain-separated signing input per RFC002 §5.1:
@@ -138,9 +206,22 @@ func readSignedTransport(c Conn) (*pb.SignedTransport, error) {
 // (0-3) is applied independently per message and capped at the last bucket. If
 // the unpadded size already exceeds the last bucket, padding is left empty.
 func padSignedTransport(st *pb.SignedTransport) ([]byte, error) {
+	return padSignedTransportWithin(st, frameTargetSize, 0, nil)
+}
+
+// padSignedTransportWithin is padSignedTransport with the bump capped at the
+// bucket limit, unless the natural bucket is larger, and the padding chosen
+// by d. The envelope is padded overhead bytes short of its bucket, for the
+// frame it is laid out in to fill the bucket.
+func padSignedTransportWithin(
+	st *pb.SignedTransport, limit, overhead int, d *paddingDRBG,
+) ([]byte, error) {
 	st.Padding = nil
 	baseSize := proto.Size(st)
-	target := selectBucketSize(baseSize)
+	target := max(
+		min(selectBucketSize(baseSize+overhead, d), limit),
+		paddingBuckets[naturalBucketIndex(baseSize+overhead)],
+	) - overhead
 	if baseSize >= target {
 		return proto.Marshal(st)
 	}
@@ -154,7 +235,7 @@ func padSignedTransport(st *pb.SignedTransport) ([]byte, error) {
 		}
 		padLen = newPadLen
 	}
-	st.Padding = randomBytes(padLen)
+	st.Padding = d.bytes(padLen)
 	return proto.Marshal(st)
 }
 
@@ -181,14 +262,14 @@ func naturalBucketIndex(baseSize int) int {
 	return len(paddingBuckets) - 1
 }
 
-// selectBump returns a random bump level (0-3) according to  bumpProbabilities.
-// Index 0 corresponds to "stay", index 3 to "+3".
-func selectBump() int {
+// selectBump returns a random bump level (0-3) according to  bumpProbabilities,
+// drawn from d. Index 0 corresponds to "stay", index 3 to "+3".
+func selectBump(d *paddingDRBG) int {
 	total := 0
 	for _, p := range bumpProbabilities {
 		total += p
 	}
-	n := mathrand.IntN(total)
+	n := d.intN(total)
 	for i, p := range bumpProbabilities {
 		if n < p {
 			return i
@@ -199,10 +280,10 @@ func selectBump() int {
 }
 
 // selectBucketSize returns the padding bucket size for a given base size,
-// applying a random cross-bucket bump capped at the last bucket.
-func selectBucketSize(baseSize int) int {
+// applying a random cross-bucket bump, drawn from d, capped at the last bucket.
+func selectBucketSize(baseSize int, d *paddingDRBG) int {
 	idx := naturalBucketIndex(baseSize)
-	idx += selectBump()
+	idx += selectBump(d)
 	if idx >= len(paddingBuckets) {
 		idx = len(paddingBuckets) - 1
 	}
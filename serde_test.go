@@ -66,7 +66,7 @@ func TestSelectBump_Distribution(t *testing.T) {
 	const iterations = 10000
 	hits := make([]int, len(bumpProbabilities))
 	for range iterations {
-		hits[selectBump()]++
+		hits[selectBump(nil)]++
 	}
 	for i, want := range bumpProbabilities {
 		got := hits[i] * 100 / iterations
@@ -82,7 +82,7 @@ func TestSelectBucketSize_CappedAtLastBucket(t *testing.T) {
 	a := require.New(t)
 	last := len(paddingBuckets) - 1
 	for range 1000 {
-		got := selectBucketSize(paddingBuckets[last])
+		got := selectBucketSize(paddingBuckets[last], nil)
 		a.LessOrEqual(got, paddingBuckets[last])
 		a.GreaterOrEqual(got, paddingBuckets[last])
 	}
@@ -93,7 +93,7 @@ func TestSelectBucketSize_AlwaysAtLeastBase(t *testing.T) {
 	sizes := []int{0, 1, 100, 500, 512, 513, 1024, 4096, 16_384}
 	for _, base := range sizes {
 		for range 100 {
-			got := selectBucketSize(base)
+			got := selectBucketSize(base, nil)
 			a.GreaterOrEqual(got, base)
 			a.LessOrEqual(got, frameTargetSize)
 		}
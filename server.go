@@ -1,22 +1,23 @@
 package kamune
 
 import (
+	"crypto/hmac"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/xtaci/kcp-go/v5"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/kamune-org/kamune/internal/box/pb"
 	"github.com/kamune-org/kamune/internal/clock"
 	"github.com/kamune-org/kamune/pkg/attest"
 	"github.com/kamune-org/kamune/pkg/exchange"
-	"github.com/kamune-org/kamune/pkg/fingerprint"
 	"github.com/kamune-org/kamune/pkg/storage"
 )
 
@@ -39,33 +40,47 @@ func (l *tcpListener) Accept() (Conn, error) {
 	return newConn(c, l.connOpts...), nil
 }
 
-type udpListener struct {
-	net.Listener
-	connOpts []ConnOption
-}
-
-func (l *udpListener) Accept() (Conn, error) {
-	c, err := l.Listener.Accept()
-	if err != nil {
-		return nil, err
-	}
-	return newConn(c, l.connOpts...), nil
-}
-
 // Server handles incoming connections and manages the handshake process.
 type Server struct {
-	listener      Listener
-	clock         clock.Clock
-	attest        *attest.Attest
-	storage       *storage.Storage
-	handlerFunc   HandlerFunc
-	serverName    string
-	addr          string
-	handshakeOpts handshakeOpts
-	connOpts      []ConnOption
-	mu            sync.Mutex
-	resumeEnabled bool
-	closed        bool
+	listener         Listener
+	clock            clock.Clock
+	attest           *attest.Attest
+	storage          *storage.Storage
+	handlerFunc      HandlerFunc
+	introGuard       *introGuard
+	migrations       *migrationGuard
+	policy           *AccessPolicy
+	guests           *guestPolicy
+	rateLimit        *RateLimit
+	abuse            *AbuseControl
+	reputation       *ReputationPolicy
+	inbox            *inbox
+	pending          *pendingQueue
+	tracer           *Tracer
+	registry         *SessionRegistry
+	metrics          *serverMetrics
+	monitor          *monitor
+	handshakes       *handshakePool
+	connLimit        *connLimiter
+	serverName       string
+	addr             string
+	reconnectAddr    string
+	handshakeOpts    handshakeOpts
+	connOpts         []ConnOption
+	introMaxAge      time.Duration
+	introCacheSize   int
+	retransmitWindow int
+	inboxSpill       int
+	mu               sync.Mutex
+	resumeEnabled    bool
+	migrationEnabled bool
+	journal          bool
+	timeline         bool
+	closed           bool
+	draining         atomic.Bool
+	shuttingDown     atomic.Bool
+	// handling counts the sessions whose handler is running.
+	handling atomic.Int64
 }
 
 // ListenAndServe starts the server and listens for incoming connections. It
@@ -86,6 +101,12 @@ func (s *Server) ListenAndServe() error {
 		}
 		s.listener = &tcpListener{Listener: l, connOpts: s.connOpts}
 	}
+	if s.monitor != nil && s.monitor.srv == nil {
+		if err := s.monitor.start(s); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+	}
 	s.mu.Unlock()
 
 	slog.Info("server started", slog.String("addr", s.addr))
@@ -101,7 +122,20 @@ func (s *Server) ListenAndServe() error {
 			slog.Error("accept conn", slog.Any("error", err))
 			continue
 		}
+		s.metrics.connections.Add(1)
+		if !s.connLimit.admit(remoteIP(cn), s.clock.Now()) {
+			s.metrics.throttled.Add(1)
+			_ = cn.Close()
+			continue
+		}
+		if !s.handshakes.admit() {
+			// Counted by the pool; logging every refusal would only add to
+			// the burst.
+			_ = cn.Close()
+			continue
+		}
 		go func() {
+			defer s.handshakes.leave()
 			if err := s.serve(cn); err != nil {
 				slog.Error("serve conn", slog.Any("error", err))
 			}
@@ -110,7 +144,8 @@ func (s *Server) ListenAndServe() error {
 }
 
 // Close gracefully shuts down the server by closing the underlying listener,
-// causing [Server.ListenAndServe] to return. It is safe to call multiple times
+// causing [Server.ListenAndServe] to return. Live sessions are left running;
+// see [Server.Shutdown] to end them too. It is safe to call multiple times
 // and concurrently.
 func (s *Server) Close() error {
 	s.mu.Lock()
@@ -123,12 +158,23 @@ func (s *Server) Close() error {
 	if s.listener != nil {
 		_ = s.listener.Close()
 	}
+	if s.inbox != nil {
+		s.inbox.close()
+	}
+	if s.pending != nil {
+		s.pending.close()
+	}
+	s.handshakes.close()
+	s.monitor.close()
 
 	s.closed = true
 	return nil
 }
 
 func (s *Server) serve(cn Conn) (err error) {
+	// adopted is set when cn has been handed over to an existing session
+	// through migration and must outlive this call.
+	var adopted bool
 	defer func() {
 		if msg := recover(); msg != nil {
 			slog.Error(
@@ -138,19 +184,43 @@ func (s *Server) serve(cn Conn) (err error) {
 			)
 			err = fmt.Errorf("serve panic: %v", msg)
 		}
+		if adopted {
+			return
+		}
 		if err := cn.Close(); err != nil && !errors.Is(err, ErrConnClosed) {
 			slog.Error("close conn", slog.Any("err", err))
 		}
 	}()
 
+	// Bound everything up to the handler, including the exchange and reading
+	// the first message, so that idle or slow connections cannot hold server
+	// resources indefinitely. Each path finishes the timer before handing
+	// the connection over.
+	timer := newStepTimer(
+		cn, s.handshakeOpts.timeout, s.handshakeOpts.timeouts,
+	)
+	timer.trace = s.tracer.start()
+	defer func() {
+		timer.slot.release()
+		err = timer.wrap(err)
+		if err != nil {
+			timer.trace.failed(err)
+		}
+		if err != nil && !timer.done {
+			s.metrics.handshakeFailed(timer.step, err)
+		}
+	}()
+
 	// Step 0: Exchange HPKE keys to derive an encrypted connection for the
 	// handshake
+	timer.begin(StepExchange)
 	ec, err := exchange.Accept(cn)
 	if err != nil {
 		return fmt.Errorf("accepting exchange: %w", err)
 	}
 
 	// Step 1: Receive introduction
+	timer.begin(StepIntroduction)
 	st, err := readSignedTransport(ec)
 	if err != nil {
 		return fmt.Errorf("reading transport: %w", err)
@@ -161,9 +231,18 @@ func (s *Server) serve(cn Conn) (err error) {
 	if err != nil {
 		return fmt.Errorf("extracting route: %w", err)
 	}
+	timer.trace.received(route)
+	priority := priorityFresh
+	if route == RouteResumeRequest || route == RouteMigrateRequest {
+		priority = priorityRestore
+	}
+	timer.slot, err = s.handshakes.acquire(priority, timer.current)
+	if err != nil {
+		return err
+	}
 	switch route {
 	case RouteIdentity:
-		return s.handleNewConnection(cn, ec, st)
+		return s.handleNewConnection(cn, ec, st, timer)
 	case RouteResumeRequest:
 		if !s.resumeEnabled {
 			return fmt.Errorf(
@@ -171,7 +250,19 @@ func (s *Server) serve(cn Conn) (err error) {
 				ErrUnexpectedRoute, RouteIdentity, route,
 			)
 		}
-		return s.handleResume(cn, ec, st)
+		return s.handleResume(cn, ec, st, timer)
+	case RouteMigrateRequest:
+		if !s.migrationEnabled {
+			return fmt.Errorf(
+				"%w: expected %s, got %s",
+				ErrUnexpectedRoute, RouteIdentity, route,
+			)
+		}
+		if err := s.handleMigrate(cn, ec, st, timer); err != nil {
+			return err
+		}
+		adopted = true
+		return nil
 	default:
 		return fmt.Errorf(
 			"%w: expected %s, got %s",
@@ -183,33 +274,91 @@ func (s *Server) serve(cn Conn) (err error) {
 }
 
 func (s *Server) handleNewConnection(
-	cn Conn, ec *exchange.Channel, st *pb.SignedTransport,
+	cn Conn, ec *exchange.Channel, st *pb.SignedTransport, timer *stepTimer,
 ) error {
-	// Bound the handshake to avoid indefinite blocking.
-	_ = cn.SetDeadline(time.Now().Add(s.handshakeOpts.timeout))
-	defer func() { _ = cn.SetDeadline(time.Time{}) }()
+	// Cheapest checks first: freshness needs no cryptography, and replay
+	// detection must follow signature verification. Everything here runs
+	// before storage access and key agreement.
+	if err := s.introGuard.checkFresh(st); err != nil {
+		return fmt.Errorf("checking introduction: %w", err)
+	}
 
 	peer, remoteVersion, err := receiveIntroduction(st)
 	if err != nil {
 		return fmt.Errorf("receiving introduction: %w", err)
 	}
 
+	if err := s.introGuard.checkReplay(st); err != nil {
+		return fmt.Errorf("checking introduction: %w", err)
+	}
+
 	if err := checkVersion(remoteVersion); err != nil {
 		return fmt.Errorf("version check: %w", err)
 	}
 
-	if err := s.handshakeOpts.remoteVerifier(s.storage, peer); err != nil {
-		return fmt.Errorf("verify remote: %w", err)
+	if err := checkBlocked(s.storage, peer.PublicKey); err != nil {
+		return err
+	}
+	err = s.reputation.admit(s.storage, peer.PublicKey, s.clock.Now())
+	if err != nil {
+		return err
 	}
+	checkDowngrade(s.storage, peer)
+	checkRename(s.storage, peer)
 
-	err = sendIntroduction(ec, s.attest, s.serverName, AppVersion)
+	var guest bool
+	if err := s.verifyRemote(peer, st); err != nil {
+		held, holdErr := s.holdPending(peer, timer)
+		switch {
+		case held && holdErr != nil:
+			return fmt.Errorf("pending connection: %w", holdErr)
+		case held: // accepted by the application
+		case s.admitsGuest(peer):
+			guest = true
+		default:
+			return fmt.Errorf("verify remote: %w", err)
+		}
+	}
+
+	var role string
+	if !guest {
+		if role, err = s.peerRole(peer); err != nil {
+			return err
+		}
+	}
+
+	name := localName(s.storage, s.attest, s.serverName)
+	err = sendIntroduction(
+		ec, s.attest, name, AppVersion, s.handshakeOpts.intro,
+	)
 	if err != nil {
 		return fmt.Errorf("sending introduction: %w", err)
 	}
+	timer.trace.sent(RouteIdentity)
+
+	// The dialer has our list of services by now and gives up on its own.
+	err = checkService(s.handshakeOpts.intro.services, peer.Service)
+	if err != nil {
+		return err
+	}
+	err = checkFrameFormats(s.handshakeOpts.intro.capabilities, peer)
+	if err != nil {
+		return err
+	}
 
 	serde := newSignedSerde(peer.PublicKey, s.attest)
-	t, err := acceptHandshake(ec, serde, s.handshakeOpts)
+	opts := s.handshakeOpts
+	opts.timer = timer
+	opts.negotiation = negotiationHash(
+		peerIntroParams(peer), localIntroParams(opts.intro),
+	)
+	t, err := acceptHandshake(ec, serde, opts)
 	if err != nil {
+		if !guest {
+			s.reputation.record(
+				s.storage, peer.PublicKey, storage.OffenseHandshake,
+			)
+		}
 		return fmt.Errorf("accepting handshake: %w", err)
 	}
 
@@ -217,16 +366,41 @@ func (s *Server) handleNewConnection(
 	// derived from the handshake, we can switch to the plain connection.
 	t.conn = cn
 	t.remotePeer = peer
-	_ = s.storage.SetMeta(t.sessionID, storage.NewByteSlicesMeta(
-		storage.ResumptionTokensKey, t.deriveResumptionTokens(),
-	))
+	if guest {
+		// Guests leave nothing behind, so nothing is written to storage.
+		t.bindStorage(nil, false)
+		t.service = peer.Service
+		t.enableCapabilities(s.handshakeOpts.intro.capabilities)
+		t.limit(s.rateLimit)
+		t.watchAbuse(s.abuse)
+		defer t.admitGuest(s.guests)()
+	} else {
+		t.bindStorage(s.storage, false)
+		t.journal = s.journal
+		t.timeline = s.timeline
+		t.record(s.storage)
+		t.setService(s.storage, peer.Service)
+		t.negotiate(s.storage, s.handshakeOpts.intro.capabilities)
+		t.enableRetransmit(s.retransmitWindow)
+		t.restrict(s.policy, role)
+		t.limit(s.rateLimit)
+		t.watchAbuse(s.abuse)
+		t.reputation = s.reputation
+		_ = s.storage.SetMeta(t.sessionID, storage.NewByteSlicesMeta(
+			storage.ResumptionTokensKey, t.deriveResumptionTokens(),
+		))
+	}
 
 	slog.Info(
 		"session established",
 		slog.String("session_id", t.SessionID()),
 		slog.String("peer", peer.Name),
 	)
+	timer.trace.phase("established")
+	t.recordTimeline(storage.TimelineEstablished, "")
 
+	timer.finish()
+	defer s.track(cn, t)()
 	if err := s.handlerFunc(t); err != nil {
 		return fmt.Errorf("handler: %w", err)
 	}
@@ -234,12 +408,45 @@ func (s *Server) handleNewConnection(
 	return nil
 }
 
+// verifyRemote verifies the dialer with the claims in its introduction st if
+// a [ClaimVerifier] accepts them, or failing that, with the [RemoteVerifier].
+func (s *Server) verifyRemote(
+	peer *storage.Peer, st *pb.SignedTransport,
+) error {
+	verified, err := s.handshakeOpts.claimVerifier.verify(s.storage, peer, st)
+	if err != nil || verified {
+		return err
+	}
+	return s.handshakeOpts.remoteVerifier(s.storage, peer)
+}
+
+// peerRole returns the role of peer under the server's access policy, or an
+// empty string if there is none.
+func (s *Server) peerRole(peer *storage.Peer) (string, error) {
+	if s.policy == nil {
+		return "", nil
+	}
+	role, err := s.policy.Role(s.storage, peer)
+	if err != nil {
+		return "", fmt.Errorf("resolving peer role: %w", err)
+	}
+	return role, nil
+}
+
 // handleResume processes an incoming ResumeRequest.
 func (s *Server) handleResume(
-	cn Conn, ec *exchange.Channel, st *pb.SignedTransport,
+	cn Conn, ec *exchange.Channel, st *pb.SignedTransport, timer *stepTimer,
 ) error {
-	_ = cn.SetDeadline(time.Now().Add(s.handshakeOpts.timeout))
-	defer func() { _ = cn.SetDeadline(time.Time{}) }()
+	timer.begin(StepResumption)
+	// respond accepts or rejects the request.
+	respond := func(accepted bool, received uint64) error {
+		err := sendResumeAccept(ec, s.attest, accepted, received)
+		if err != nil {
+			return fmt.Errorf("sending resume accept: %w", err)
+		}
+		timer.trace.sent(RouteResumeAccept)
+		return nil
+	}
 
 	// Parse the ResumeRequest.
 	var req pb.ResumeRequest
@@ -249,13 +456,17 @@ func (s *Server) handleResume(
 
 	sessionID := req.GetSessionID()
 	token := req.GetToken()
+	timer.trace.session(sessionID)
 
 	err := s.storage.RemoveListItem(
 		sessionID, storage.ResumptionTokensKey, token,
 	)
+	if errors.Is(err, storage.ErrStorageLocked) {
+		return fmt.Errorf("removing resumption token: %w", err)
+	}
 	if err != nil {
-		if err := sendResumeAccept(ec, s.attest, false); err != nil {
-			return fmt.Errorf("sending resume accept: %w", err)
+		if err := respond(false, 0); err != nil {
+			return err
 		}
 		return fmt.Errorf("resume rejected: token invalid")
 	}
@@ -275,36 +486,70 @@ func (s *Server) handleResume(
 		signingInput(st.GetMetadata(), st.GetData()),
 		st.GetSignature(),
 	) {
-		if err := sendResumeAccept(ec, s.attest, false); err != nil {
-			return fmt.Errorf("sending resume accept: %w", err)
+		if err := respond(false, 0); err != nil {
+			return err
 		}
 		return fmt.Errorf("resume rejected: invalid signature")
 	}
 
+	if err := checkBlocked(s.storage, peer.PublicKey); err != nil {
+		if err := respond(false, 0); err != nil {
+			return err
+		}
+		return fmt.Errorf("resume rejected: %w", err)
+	}
+	err = s.reputation.admit(s.storage, peer.PublicKey, s.clock.Now())
+	if err != nil {
+		if err := respond(false, 0); err != nil {
+			return err
+		}
+		return fmt.Errorf("resume rejected: %w", err)
+	}
+
 	// Check the resumption window.
 	if s.clock.Now().Sub(establishedAt) > resumptionGracePeriod {
-		if err := sendResumeAccept(ec, s.attest, false); err != nil {
-			return fmt.Errorf("sending resume accept: %w", err)
+		if err := respond(false, 0); err != nil {
+			return err
 		}
 		return fmt.Errorf("resume rejected: session expired")
 	}
 
+	role, err := s.peerRole(peer)
+	if err != nil {
+		return err
+	}
+
 	// Resume accepted — send accept and proceed to handshake.
-	if err := sendResumeAccept(ec, s.attest, true); err != nil {
-		return fmt.Errorf("sending resume accept: %w", err)
+	received := receivedCount(s.storage, sessionID)
+	if err := respond(true, received); err != nil {
+		return err
 	}
 
 	serde := newSignedSerde(peer.PublicKey, s.attest)
 
 	opts := s.handshakeOpts
 	opts.sessionID = sessionID
+	opts.timer = timer
 	t, err := acceptHandshake(ec, serde, opts)
 	if err != nil {
+		s.reputation.record(
+			s.storage, peer.PublicKey, storage.OffenseHandshake,
+		)
 		return fmt.Errorf("accepting handshake after resume: %w", err)
 	}
 
 	t.conn = cn
 	t.remotePeer = peer
+	t.bindStorage(s.storage, true)
+	t.journal = s.journal
+	t.timeline = s.timeline
+	t.loadService(s.storage)
+	t.loadCapabilities(s.storage, s.handshakeOpts.intro.capabilities)
+	t.enableRetransmit(s.retransmitWindow)
+	t.restrict(s.policy, role)
+	t.limit(s.rateLimit)
+	t.watchAbuse(s.abuse)
+	t.reputation = s.reputation
 	_ = s.storage.SetMeta(t.sessionID, storage.NewByteSlicesMeta(
 		storage.ResumptionTokensKey, t.deriveResumptionTokens(),
 	))
@@ -314,7 +559,16 @@ func (s *Server) handleResume(
 		slog.String("session_id", t.sessionID),
 		slog.String("peer", peer.Name),
 	)
+	timer.trace.phase("resumed")
+	t.recordTimeline(storage.TimelineResumed, "")
 
+	if err := t.resend(req.GetReceived()); err != nil {
+		_ = t.Close()
+		return fmt.Errorf("resending missed messages: %w", err)
+	}
+
+	timer.finish()
+	defer s.track(cn, t)()
 	if err := s.handlerFunc(t); err != nil {
 		return fmt.Errorf("handler: %w", err)
 	}
@@ -322,6 +576,126 @@ func (s *Server) handleResume(
 	return nil
 }
 
+// handleMigrate processes an incoming MigrateRequest. On success, the live
+// session identified by the request is rebound to cn and the previous
+// connection is closed; the session's handler keeps running undisturbed.
+func (s *Server) handleMigrate(
+	cn Conn, ec *exchange.Channel, st *pb.SignedTransport, timer *stepTimer,
+) error {
+	reject := func(reason string) error {
+		err := rejectMigration(ec, s.attest, reason)
+		if errors.Is(err, ErrMigrationRejected) {
+			timer.trace.sent(RouteMigrateAccept)
+		}
+		return err
+	}
+	var req pb.MigrateRequest
+	if err := proto.Unmarshal(st.GetData(), &req); err != nil {
+		return fmt.Errorf("deserializing migrate request: %w", err)
+	}
+
+	t, ok := s.registry.Get(req.GetSessionID())
+	if !ok {
+		return reject("session is not live")
+	}
+
+	err := verifySigned(st, t.remotePeer.PublicKey, RouteMigrateRequest, &req)
+	if err != nil {
+		return reject("invalid signature")
+	}
+	if err := checkBlocked(s.storage, t.remotePeer.PublicKey); err != nil {
+		return reject("session is not live")
+	}
+	if !s.migrations.checkFresh(st) {
+		return reject("stale request")
+	}
+	if len(req.GetNonce()) != migrationNonceSize {
+		return reject("invalid nonce")
+	}
+	binding, err := migrationBinding(ec)
+	if err != nil {
+		return err
+	}
+	expected, err := t.migrationProof(
+		migrationRequestInfo, binding, req.GetNonce(),
+	)
+	if err != nil {
+		return reject("session is not established")
+	}
+	if !hmac.Equal(expected, req.GetProof()) {
+		return reject("invalid proof")
+	}
+	if !s.migrations.checkReplay(req.GetNonce()) {
+		return reject("replayed request")
+	}
+
+	proof, err := t.migrationProof(
+		migrationAcceptInfo, binding, req.GetNonce(),
+	)
+	if err != nil {
+		return fmt.Errorf("computing migration proof: %w", err)
+	}
+	resp := &pb.MigrateAccept{
+		Accepted: true,
+		Proof:    proof,
+		Sequence: t.receivedSequence(),
+		Received: t.receivedMessages(),
+	}
+
+	// Rebind before replying: once the dialer sees the accept it closes the
+	// old connection, and the handler blocked on it must already find the
+	// new one in place. The deadline set by serve is cleared first for the
+	// same reason.
+	timer.finish()
+	t.migrate(cn, req.GetSequence())
+	if err := sendSigned(ec, s.attest, resp, RouteMigrateAccept); err != nil {
+		return fmt.Errorf("sending migrate accept: %w", err)
+	}
+	timer.trace.sent(RouteMigrateAccept)
+	timer.trace.phase("migrated")
+
+	if t.Retransmitting() {
+		if err := t.resend(req.GetReceived()); err != nil {
+			_ = t.Close()
+			return fmt.Errorf("resending missed messages: %w", err)
+		}
+	}
+	return nil
+}
+
+// track registers t as a live session so that it can be found by incoming
+// migration requests and through [Server.SessionRegistry]. The returned
+// function removes it again, records its statistics, and closes the connection
+// the session migrated to, if any; the original connection cn is closed by
+// serve.
+func (s *Server) track(cn Conn, t *Transport) func() {
+	s.handling.Add(1)
+	s.registry.add(t)
+	s.metrics.sessionEstablished(t.FrameFormat())
+	if s.draining.Load() {
+		s.hintReconnect(t)
+	}
+	if s.shuttingDown.Load() {
+		s.closeForShutdown(t)
+	}
+
+	return func() {
+		defer s.handling.Add(-1)
+		s.registry.remove(t)
+		t.recordStats()
+		s.metrics.sessionClosed(t.Stats())
+
+		if current := t.currentConn(); current != cn {
+			_ = current.Close()
+		}
+	}
+}
+
+// SessionRegistry returns the registry of the server's live sessions.
+func (s *Server) SessionRegistry() *SessionRegistry {
+	return s.registry
+}
+
 // PublicKey returns the server's public key.
 func (s *Server) PublicKey() []byte {
 	return s.attest.MarshalPublicKey()
@@ -345,8 +719,12 @@ func NewServer(
 			remoteVerifier: rv,
 			timeout:        30 * time.Second,
 		},
-		clock:         clock.Real(),
-		resumeEnabled: true,
+		registry:         newSessionRegistry(store),
+		metrics:          newServerMetrics(),
+		clock:            clock.Real(),
+		introCacheSize:   introReplayCacheSize,
+		resumeEnabled:    true,
+		migrationEnabled: true,
 	}
 
 	for _, o := range opts {
@@ -354,6 +732,20 @@ func NewServer(
 			return nil, err
 		}
 	}
+	s.introGuard = newIntroGuard(
+		s.clock, s.introMaxAge, s.introCacheSize,
+	)
+	s.migrations = newMigrationGuard(s.clock)
+	if s.inbox != nil {
+		if handler != nil {
+			return nil, errors.New("a server with an inbox takes no handler")
+		}
+		s.handlerFunc = s.inbox.serve
+		s.inbox.store = store
+		s.inbox.spill = s.inboxSpill
+	} else if s.inboxSpill > 0 {
+		return nil, errors.New("spilling needs an inbox")
+	}
 
 	at, err := s.storage.Attester()
 	if err != nil {
@@ -361,9 +753,6 @@ func NewServer(
 	}
 
 	s.attest = at
-	if s.serverName == "" {
-		s.serverName = fingerprint.Sum(at.MarshalPublicKey())
-	}
 	return s, nil
 }
 
@@ -371,7 +760,9 @@ func NewServer(
 // causes [NewServer] to fail immediately with that error.
 type ServerOptions func(*Server) error
 
-// ServeWithServerName sets the server's advertised name.
+// ServeWithServerName sets the server's advertised name. Without it, the
+// storage's display name is advertised, or the fingerprint of the public key
+// if it has none.
 func ServeWithServerName(name string) ServerOptions {
 	return func(s *Server) error {
 		s.serverName = name
@@ -393,19 +784,6 @@ func ServeWithTCP(opts ...ConnOption) ServerOptions {
 	}
 }
 
-// ServeWithUDP configures the server to use UDP/KCP connections.
-func ServeWithUDP(opts ...ConnOption) ServerOptions {
-	return func(s *Server) error {
-		s.connOpts = opts
-		l, err := kcp.Listen(s.addr)
-		if err != nil {
-			return fmt.Errorf("listening udp: %w", err)
-		}
-		s.listener = &udpListener{Listener: l, connOpts: opts}
-		return nil
-	}
-}
-
 // ServeWithListener uses the caller-provided Listener directly. The addr
 // argument passed to [NewServer] is unused in this case; pass "".
 func ServeWithListener(l Listener) ServerOptions {
@@ -426,6 +804,322 @@ func ServeWithResumeEnabled(enabled bool) ServerOptions {
 	}
 }
 
+// ServeWithMigrationEnabled controls whether the server accepts connection
+// migration requests, which move a live session to a new network path without
+// a new handshake. When disabled, incoming MigrateRequest messages are treated
+// as unexpected routes and the dialer must reconnect and resume instead.
+// Enabled by default.
+func ServeWithMigrationEnabled(enabled bool) ServerOptions {
+	return func(s *Server) error {
+		s.migrationEnabled = enabled
+		return nil
+	}
+}
+
+// ServeWithIntroductionMaxAge enables freshness validation of incoming
+// introductions. Introductions whose timestamp differs from the server's clock
+// by more than maxAge are rejected with [ErrStaleIntroduction], and an
+// introduction presented again while still fresh is rejected with
+// [ErrReplayedIntroduction]. Both checks run before storage access and key
+// agreement. Zero, the default, disables them.
+func ServeWithIntroductionMaxAge(maxAge time.Duration) ServerOptions {
+	return func(s *Server) error {
+		if maxAge < 0 {
+			return fmt.Errorf("introduction max age must be non-negative")
+		}
+		s.introMaxAge = maxAge
+		return nil
+	}
+}
+
+// ServeWithIntroductionReplayCacheSize sets how many introduction signatures
+// the server remembers to detect replays while [ServeWithIntroductionMaxAge]
+// is in effect. When the cache is full, expired entries are dropped first and
+// then arbitrary ones, so a replay may slip through once more than size
+// introductions arrive within twice the max age. The default is 65536.
+func ServeWithIntroductionReplayCacheSize(size int) ServerOptions {
+	return func(s *Server) error {
+		if size <= 0 {
+			return fmt.Errorf("introduction replay cache size must be positive")
+		}
+		s.introCacheSize = size
+		return nil
+	}
+}
+
+// ServeWithHandshakeTimeouts bounds the individual steps of accepting a
+// connection, so that a peer stalling mid-handshake is dropped early instead
+// of holding resources until the overall handshake timeout. The error
+// returned for such a connection is a [*HandshakeTimeoutError] naming the
+// step.
+func ServeWithHandshakeTimeouts(timeouts HandshakeTimeouts) ServerOptions {
+	return func(s *Server) error {
+		if err := timeouts.validate(); err != nil {
+			return err
+		}
+		s.handshakeOpts.timeouts = timeouts
+		return nil
+	}
+}
+
+// ServeWithIntroductionMetadata attaches application metadata to the server's
+// introduction. It is signed along with the introduction and made available
+// to the dialer's [RemoteVerifier] as [storage.Peer.Metadata]. The combined
+// size of keys and values is limited to 4 KiB.
+func ServeWithIntroductionMetadata(md map[string][]byte) ServerOptions {
+	return func(s *Server) error {
+		if err := checkIntroMetadata(md); err != nil {
+			return err
+		}
+		s.handshakeOpts.intro.metadata = md
+		return nil
+	}
+}
+
+// ServeWithClaims presents chain in the server's introduction, so that
+// dialers using [DialWithClaimVerifier] can verify the server by the claims
+// instead of asking their users. chain[0] must be a claim about the server's
+// own public key; see [Claim]. At most four claims may be presented.
+func ServeWithClaims(chain ...Claim) ServerOptions {
+	return func(s *Server) error {
+		if err := checkClaimChain(len(chain)); err != nil {
+			return err
+		}
+		s.handshakeOpts.intro.claims = chain
+		return nil
+	}
+}
+
+// ServeWithClaimVerifier verifies dialers that present identity claims with
+// v before falling back to the [RemoteVerifier]; see [ClaimVerifier].
+// Resumed sessions skip the introduction, and with it the claims.
+func ServeWithClaimVerifier(v ClaimVerifier) ServerOptions {
+	return func(s *Server) error {
+		if v == nil {
+			return errors.New("claim verifier must not be nil")
+		}
+		s.handshakeOpts.claimVerifier = v
+		return nil
+	}
+}
+
+// ServeWithServices advertises the services the server offers, such as
+// "chat", "file-drop", or "bot", in its introduction. A dialer requesting a
+// service with [Dialer.DialService] that is not listed is turned away with
+// [ErrServiceUnavailable]; dialers that do not request one are unaffected.
+// Handlers can tell which service was requested with [Transport.Service].
+func ServeWithServices(services ...string) ServerOptions {
+	return func(s *Server) error {
+		for _, svc := range services {
+			if svc == "" {
+				return fmt.Errorf("service name must not be empty")
+			}
+		}
+		s.handshakeOpts.intro.services = services
+		return nil
+	}
+}
+
+// ServeWithJournalEnabled controls the journal of outgoing messages. When it
+// is enabled, each application message sent in a session is recorded in
+// storage with its route, sequence, and [MessageHash] before it is written,
+// and marked as sent once it is. Control messages are not recorded. Nothing
+// is removed until the application confirms delivery, by its own means, with
+// [storage.Storage.AckMessage]; after a crash,
+// [storage.Storage.UnconfirmedMessages] tells it what may have to be sent
+// again. Sessions are journaled only if they are recorded in storage.
+// Disabled by default.
+func ServeWithJournalEnabled(enabled bool) ServerOptions {
+	return func(s *Server) error {
+		s.journal = enabled
+		return nil
+	}
+}
+
+// ServeWithTimelineEnabled controls the timeline of each session: an
+// append-only record in storage of the session being established, resumed,
+// and migrated, of its connections ending and the errors that ended them,
+// and of the sequence numbers reached every 1024 messages. Reading it back
+// with [storage.Storage.ReplaySession] tells what became of a session after
+// the fact. Events are kept for the storage's timeline retention; see
+// [storage.WithTimelineRetention]. Sessions have a timeline only if they are
+// recorded in storage. Disabled by default.
+func ServeWithTimelineEnabled(enabled bool) ServerOptions {
+	return func(s *Server) error {
+		s.timeline = enabled
+		return nil
+	}
+}
+
+// ServeWithDedupEnabled controls payload deduplication. When it is enabled on
+// both sides of a session, a message of 1 KiB or more that was already sent
+// in the same direction is replaced on the wire by a 32-byte reference, which
+// the receiver resolves transparently from the payloads it has seen. Each
+// side remembers up to 4 MiB of payloads per direction and session. This
+// saves bandwidth when the same content is sent repeatedly, as bots and sync
+// protocols tend to do. Disabled by default.
+func ServeWithDedupEnabled(enabled bool) ServerOptions {
+	return func(s *Server) error {
+		s.handshakeOpts.intro.capabilities = setCapability(
+			s.handshakeOpts.intro.capabilities, capabilityDedup, enabled,
+		)
+		return nil
+	}
+}
+
+// ServeWithRetransmitWindow keeps the last window application messages sent
+// in each session recorded in storage, so that the ones the peer missed can
+// be sent again once it resumes the session. When it is enabled on both
+// sides, resumption exchanges the number of application messages each side
+// received, and each side resends the ones the other is missing, in order,
+// before the session is handed back to the application; messages older than
+// the window are lost. Control messages and transfers are not resent. This
+// costs a storage write per message sent and received. Disabled by default,
+// or when window is zero.
+func ServeWithRetransmitWindow(window int) ServerOptions {
+	return func(s *Server) error {
+		if window < 0 {
+			return errors.New("retransmit window must not be negative")
+		}
+		s.retransmitWindow = window
+		s.handshakeOpts.intro.capabilities = setCapability(
+			s.handshakeOpts.intro.capabilities, capabilityRetransmit,
+			window > 0,
+		)
+		return nil
+	}
+}
+
+// ServeWithSchemas advertises the versions of every schema registered in r
+// in the introduction, so that [SchemaRegistry.Send] and
+// [SchemaRegistry.Receive] can agree with each peer on the version to use.
+// Schemas registered later are not advertised.
+func ServeWithSchemas(r *SchemaRegistry) ServerOptions {
+	return func(s *Server) error {
+		for _, c := range r.capabilities() {
+			s.handshakeOpts.intro.capabilities = setCapability(
+				s.handshakeOpts.intro.capabilities, c, true,
+			)
+		}
+		return nil
+	}
+}
+
+// ServeWithMaxMessageSize advertises size as the largest serialized message
+// the server accepts, between 1 KiB and the protocol's limit of about 60 KiB.
+// Peers that honour it fail to send larger messages with a
+// [MessageTooLargeError] instead of sending them, and pad their frames no
+// further than such messages need, so that larger frames are turned away with
+// [ErrMessageTooLarge] before being decrypted. Peers that predate it do not
+// know of the limit, and their larger frames fail the same way. Zero, the
+// default, advertises no limit.
+func ServeWithMaxMessageSize(size int) ServerOptions {
+	return func(s *Server) error {
+		caps, err := setMaxMessageSize(s.handshakeOpts.intro.capabilities, size)
+		if err != nil {
+			return err
+		}
+		s.handshakeOpts.intro.capabilities = caps
+		return nil
+	}
+}
+
+// ServeWithFrameFormats sets the frame formats the server speaks, to move
+// its sessions to a new wire format; see [FrameFormats]. Sessions with peers
+// that speak none of them fail with [ErrFrameFormatMismatch] before the
+// handshake. Resumed sessions keep the format they were established with.
+// The default is FrameFormatV1 alone, which peers that predate frame formats
+// speak.
+func ServeWithFrameFormats(f FrameFormats) ServerOptions {
+	return func(s *Server) error {
+		caps, err := setFrameFormats(s.handshakeOpts.intro.capabilities, f)
+		if err != nil {
+			return err
+		}
+		s.handshakeOpts.intro.capabilities = caps
+		return nil
+	}
+}
+
+// ServeWithAccessPolicy restricts the routes each peer may send according to
+// its role under p; see [AccessPolicy]. Without a policy, every peer may send
+// on every route.
+func ServeWithAccessPolicy(p *AccessPolicy) ServerOptions {
+	return func(s *Server) error {
+		s.policy = p
+		return nil
+	}
+}
+
+// ServeWithRateLimit limits the messages each peer may send on a session to
+// l; see [RateLimit]. Every session has a bucket of its own, starting full.
+func ServeWithRateLimit(l RateLimit) ServerOptions {
+	return func(s *Server) error {
+		if err := l.validate(); err != nil {
+			return err
+		}
+		s.rateLimit = &l
+		return nil
+	}
+}
+
+// ServeWithInbox makes the server read every session itself and queue the
+// messages for [Server.NextMessage], instead of running a handler per
+// session; the handler passed to [NewServer] must be nil. Each session queues
+// at most size messages. While its queue is full, the server stops reading
+// from that session, so a flooding peer is slowed down without delaying the
+// others.
+func ServeWithInbox(size int) ServerOptions {
+	return func(s *Server) error {
+		if size <= 0 {
+			return fmt.Errorf("inbox size must be positive")
+		}
+		s.inbox = newInbox(size)
+		return nil
+	}
+}
+
+// ServeWithInboxSpill lets each session of a server with an inbox, see
+// [ServeWithInbox], queue up to limit more messages once its queue is full,
+// kept encrypted in the server's storage rather than in memory, before the
+// server stops reading from it. Spilled messages are handed out in order with
+// the others, and [Transport.Stats] reports how many each session has.
+// Sessions that are not recorded in storage, such as those of guests, are
+// not spilled. Messages spilled by a process that exits before they are taken
+// are left in storage until their session is deleted.
+func ServeWithInboxSpill(limit int) ServerOptions {
+	return func(s *Server) error {
+		if limit <= 0 {
+			return fmt.Errorf("inbox spill limit must be positive")
+		}
+		s.inboxSpill = limit
+		return nil
+	}
+}
+
+// ServeWithKeyLog writes the traffic keys of every session to w, so that
+// captured traffic can be decrypted offline while debugging the protocol. The
+// format is described in §7.7 of the specification. Anyone holding the log
+// can read the sessions it covers; never enable it in production.
+func ServeWithKeyLog(w io.Writer) ServerOptions {
+	return func(s *Server) error {
+		s.handshakeOpts.keyLog = newKeyLog(w)
+		return nil
+	}
+}
+
+// ServeWithTracer records the protocol activity of every connection the
+// server accepts with tr; see [Tracer].
+func ServeWithTracer(tr *Tracer) ServerOptions {
+	return func(s *Server) error {
+		if tr == nil {
+			return errors.New("tracer must not be nil")
+		}
+		s.tracer = tr
+		return nil
+	}
+}
+
 // ServeWithClock sets a custom clock for the server. It is primarily useful
 // for tests that need to control time-dependent behavior like session expiry.
 func ServeWithClock(c clock.Clock) ServerOptions {
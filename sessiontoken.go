@@ -0,0 +1,237 @@
+package kamune
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/enigma"
+	"github.com/kamune-org/kamune/pkg/attest"
+)
+
+const (
+	// sessionTokenSigningContext separates session token signatures from
+	// every other signature made with the same key.
+	sessionTokenSigningContext = "kamune-session-token\x00"
+	// sessionTokenPrefix starts the text form of a [SessionToken].
+	sessionTokenPrefix = "kamune-token"
+	// channelBindingSize is the size of [Transport.ChannelBinding].
+	channelBindingSize = 32
+	// maxSessionTokenChallenge bounds the challenge a token is bound to.
+	maxSessionTokenChallenge = 256
+)
+
+// MaxSessionTokenTTL is the longest a [SessionToken] may be valid for.
+const MaxSessionTokenTTL = 5 * time.Minute
+
+// SessionToken is a short-lived proof, signed by a peer's identity from within
+// an established session, that the peer answered a challenge, for signing into
+// services that run alongside a kamune server, such as a web dashboard, with a
+// kamune identity: the service shows a random challenge, the peer mints a
+// token for it with [Transport.MintSessionToken] and hands it over, and the
+// service checks it against the server's side of the session with
+// [Transport.VerifySessionToken].
+//
+// Besides the session ID, the signature covers the session's
+// [Transport.ChannelBinding], which the token does not carry, so that it can
+// only be verified by the other end of the session it was minted in, or by
+// whoever that end shares the binding with.
+type SessionToken struct {
+	// Expires is when the token stops being valid, at most
+	// [MaxSessionTokenTTL] after it was minted.
+	Expires   time.Time
+	SessionID string
+	Challenge []byte
+	Signature []byte
+}
+
+// ChannelBinding returns a value that both ends of the session derive from
+// its shared secret and nobody else can compute, for binding statements to
+// the session, as TLS exporters do. It changes when the session is resumed,
+// and is nil until the session is established.
+func (t *Transport) ChannelBinding() []byte {
+	if t.resumptionRoot == nil {
+		return nil
+	}
+	b, err := enigma.Derive(
+		t.resumptionRoot, nil, []byte(channelBindingInfo), channelBindingSize,
+	)
+	if err != nil {
+		// Derive only fails on invalid parameters; this should never happen.
+		slog.Error("derive channel binding", slog.Any("error", err))
+		return nil
+	}
+	return b
+}
+
+// MintSessionToken returns a token, signed by the local identity, answering
+// challenge within t's session and valid for ttl, which must be positive and
+// at most [MaxSessionTokenTTL]. The challenge is given by the service the
+// token is for, and must be between 1 and 256 bytes long.
+func (t *Transport) MintSessionToken(
+	challenge []byte, ttl time.Duration,
+) (SessionToken, error) {
+	if ttl <= 0 || ttl > MaxSessionTokenTTL {
+		return SessionToken{}, fmt.Errorf(
+			"%w: lifetime %s out of range", ErrInvalidSessionToken, ttl,
+		)
+	}
+	binding := t.ChannelBinding()
+	if binding == nil {
+		return SessionToken{}, fmt.Errorf(
+			"%w: session is not established", ErrInvalidSessionToken,
+		)
+	}
+	tok := SessionToken{
+		Expires:   time.Now().Add(ttl),
+		SessionID: t.sessionID,
+		Challenge: challenge,
+	}
+	if err := tok.validate(); err != nil {
+		return SessionToken{}, err
+	}
+	sig, err := t.serde.attest.Sign(tok.signed(binding))
+	if err != nil {
+		return SessionToken{}, fmt.Errorf("signing session token: %w", err)
+	}
+	tok.Signature = sig
+	return tok, nil
+}
+
+// VerifySessionToken checks that tok was minted by t's peer within t's
+// session, answers challenge, and has not expired. It fails with
+// [ErrInvalidSessionToken] if it does not, and with [ErrVerificationFailed]
+// if its signature is not the peer's.
+func (t *Transport) VerifySessionToken(
+	tok SessionToken, challenge []byte,
+) error {
+	if tok.SessionID != t.sessionID {
+		return fmt.Errorf(
+			"%w: not about this session", ErrInvalidSessionToken,
+		)
+	}
+	return VerifySessionToken(
+		t.remotePeer.PublicKey, t.ChannelBinding(), tok, challenge,
+		time.Now(),
+	)
+}
+
+// VerifySessionToken is [Transport.VerifySessionToken] for a service that
+// does not hold the session itself, but knows the public key of the peer and
+// the channel binding of the session, at time now.
+func VerifySessionToken(
+	publicKey, binding []byte, tok SessionToken, challenge []byte,
+	now time.Time,
+) error {
+	if err := tok.validate(); err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(tok.Challenge, challenge) != 1 {
+		return fmt.Errorf("%w: wrong challenge", ErrInvalidSessionToken)
+	}
+	if !now.Before(tok.Expires) {
+		return fmt.Errorf("%w: expired", ErrInvalidSessionToken)
+	}
+	if tok.Expires.Sub(now) > MaxSessionTokenTTL {
+		return fmt.Errorf(
+			"%w: valid for too long", ErrInvalidSessionToken,
+		)
+	}
+	if len(binding) == 0 ||
+		!attest.Verify(publicKey, tok.signed(binding), tok.Signature) {
+		return fmt.Errorf(
+			"%w: invalid session token signature", ErrVerificationFailed,
+		)
+	}
+	return nil
+}
+
+// String returns the text form of tok, to be read by [ParseSessionToken]. It
+// holds no spaces, commas, or semicolons, so it can be used as is in URLs and
+// cookies.
+func (tok SessionToken) String() string {
+	return strings.Join([]string{
+		sessionTokenPrefix,
+		tok.SessionID,
+		strconv.FormatInt(tok.Expires.UnixNano(), 10),
+		base64.RawURLEncoding.EncodeToString(tok.Challenge),
+		base64.RawURLEncoding.EncodeToString(tok.Signature),
+	}, ".")
+}
+
+// ParseSessionToken reads the text form of a session token, as returned by
+// [SessionToken.String]. It does not verify the token.
+func ParseSessionToken(s string) (SessionToken, error) {
+	fields := strings.Split(s, ".")
+	if len(fields) != 5 || fields[0] != sessionTokenPrefix {
+		return SessionToken{}, fmt.Errorf(
+			"%w: malformed", ErrInvalidSessionToken,
+		)
+	}
+	expires, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return SessionToken{}, fmt.Errorf(
+			"%w: decoding expiry", ErrInvalidSessionToken,
+		)
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(fields[3])
+	if err != nil {
+		return SessionToken{}, fmt.Errorf(
+			"%w: decoding challenge", ErrInvalidSessionToken,
+		)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(fields[4])
+	if err != nil {
+		return SessionToken{}, fmt.Errorf(
+			"%w: decoding signature", ErrInvalidSessionToken,
+		)
+	}
+	tok := SessionToken{
+		Expires:   time.Unix(0, expires),
+		SessionID: fields[1],
+		Challenge: challenge,
+		Signature: sig,
+	}
+	if err := tok.validate(); err != nil {
+		return SessionToken{}, err
+	}
+	return tok, nil
+}
+
+func (tok SessionToken) validate() error {
+	if tok.SessionID == "" ||
+		strings.ContainsFunc(tok.SessionID, isTokenSeparator) {
+		return fmt.Errorf(
+			"%w: invalid session ID %q", ErrInvalidSessionToken, tok.SessionID,
+		)
+	}
+	if n := len(tok.Challenge); n == 0 || n > maxSessionTokenChallenge {
+		return fmt.Errorf(
+			"%w: challenge of %d bytes", ErrInvalidSessionToken, n,
+		)
+	}
+	return nil
+}
+
+// isTokenSeparator reports whether r cannot appear in a session ID in the
+// text form of a token.
+func isTokenSeparator(r rune) bool {
+	return r == '.' || r <= ' ' || r == ',' || r == ';'
+}
+
+// signed returns the message a token's signature covers.
+func (tok SessionToken) signed(binding []byte) []byte {
+	b := []byte(sessionTokenSigningContext)
+	for _, field := range [][]byte{
+		[]byte(tok.SessionID), binding, tok.Challenge,
+	} {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(field)))
+		b = append(b, field...)
+	}
+	return binary.BigEndian.AppendUint64(b, uint64(tok.Expires.UnixNano()))
+}
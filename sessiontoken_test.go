@@ -0,0 +1,133 @@
+package kamune
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionToken(t *testing.T) {
+	a := require.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	serverStore, cleanup := newTestStore(t)
+	defer cleanup()
+	connects := make(chan *Transport, 2)
+	srv, err := NewServer(
+		"", Managed(Handlers{
+			OnConnect: func(t *Transport) { connects <- t },
+		}), serverStore, acceptAll,
+		ServeWithListener(&tcpListener{Listener: l}),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(l.Addr().String(), store, acceptAll)
+	a.NoError(err)
+	client, err := d.Dial()
+	a.NoError(err)
+	defer client.Close()
+	other, err := d.Dial()
+	a.NoError(err)
+	defer other.Close()
+	server := <-connects
+	if server.SessionID() != client.SessionID() {
+		server = <-connects
+	}
+	a.Equal(client.SessionID(), server.SessionID())
+
+	// Both ends derive the same binding, unlike other sessions.
+	a.Len(client.ChannelBinding(), channelBindingSize)
+	a.Equal(client.ChannelBinding(), server.ChannelBinding())
+	a.NotEqual(client.ChannelBinding(), other.ChannelBinding())
+
+	challenge := []byte("dashboard login 42")
+	tok, err := client.MintSessionToken(challenge, time.Minute)
+	a.NoError(err)
+	parsed, err := ParseSessionToken(tok.String())
+	a.NoError(err)
+	a.Equal(tok.String(), parsed.String())
+	a.NoError(server.VerifySessionToken(parsed, challenge))
+	// Either end can mint a token for the other.
+	reverse, err := server.MintSessionToken(challenge, time.Minute)
+	a.NoError(err)
+	a.NoError(client.VerifySessionToken(reverse, challenge))
+
+	elsewhere, err := other.MintSessionToken(challenge, time.Minute)
+	a.NoError(err)
+	forged := tok
+	forged.Signature = append([]byte(nil), tok.Signature...)
+	forged.Signature[0] ^= 1
+	a.ErrorIs(
+		server.VerifySessionToken(tok, []byte("another login")),
+		ErrInvalidSessionToken,
+	)
+	a.ErrorIs(
+		server.VerifySessionToken(elsewhere, challenge),
+		ErrInvalidSessionToken,
+	)
+	a.ErrorIs(
+		server.VerifySessionToken(forged, challenge), ErrVerificationFailed,
+	)
+
+	peer := client.serde.attest.MarshalPublicKey()
+	binding := server.ChannelBinding()
+	tests := []struct {
+		name    string
+		binding []byte
+		now     time.Time
+		err     error
+	}{
+		{name: "valid", binding: binding, now: time.Now()},
+		{
+			name:    "expired",
+			binding: binding,
+			now:     tok.Expires,
+			err:     ErrInvalidSessionToken,
+		},
+		{
+			name:    "valid for too long",
+			binding: binding,
+			now:     tok.Expires.Add(-2 * MaxSessionTokenTTL),
+			err:     ErrInvalidSessionToken,
+		},
+		{
+			name:    "other binding",
+			binding: other.ChannelBinding(),
+			now:     time.Now(),
+			err:     ErrVerificationFailed,
+		},
+		{name: "no binding", now: time.Now(), err: ErrVerificationFailed},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			err := VerifySessionToken(peer, tc.binding, tok, challenge, tc.now)
+			if tc.err == nil {
+				a.NoError(err)
+				return
+			}
+			a.ErrorIs(err, tc.err)
+		})
+	}
+
+	for _, ttl := range []time.Duration{0, MaxSessionTokenTTL + 1} {
+		_, err = client.MintSessionToken(challenge, ttl)
+		a.ErrorIs(err, ErrInvalidSessionToken)
+	}
+	_, err = client.MintSessionToken(nil, time.Minute)
+	a.ErrorIs(err, ErrInvalidSessionToken)
+	for _, s := range []string{
+		"", "kamune-token", "kamune-link.a.1.Yg.Yg", "kamune-token.a.x.Yg.Yg",
+		"kamune-token.a.1.Yg.!", "kamune-token..1.Yg.Yg",
+	} {
+		_, err = ParseSessionToken(s)
+		a.ErrorIs(err, ErrInvalidSessionToken, s)
+	}
+}
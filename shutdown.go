@@ -0,0 +1,73 @@
+package kamune
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Shutdown stops the server and ends its sessions. It stops accepting
+// connections, so that [Server.ListenAndServe] returns, and closes every live
+// session, telling its peer on [RouteCloseTransport], so that the handlers see
+// the session end and return. Sessions whose handshake completes meanwhile are
+// closed as soon as they are established.
+//
+// Shutdown returns once every handler has returned, or once ctx ends, in which
+// case it returns ctx's error and leaves the handlers still running behind.
+// Either way the server is closed afterwards. Unlike [Server.Drain], the
+// sessions cannot be resumed elsewhere.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosedServer
+	}
+	s.shuttingDown.Store(true)
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	s.mu.Unlock()
+
+	sessions := s.registry.Sessions()
+	slog.Info("shutting down server", slog.Int("sessions", len(sessions)))
+	for _, t := range sessions {
+		s.closeForShutdown(t)
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	var err error
+	for err == nil && s.ActiveSessions() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+			slog.Warn(
+				"server shut down with handlers running",
+				slog.Int("sessions", s.ActiveSessions()),
+			)
+		}
+	}
+	if cerr := s.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ActiveSessions returns the number of sessions whose handler is running,
+// including those closed by [Server.Shutdown] whose handler has yet to
+// return.
+func (s *Server) ActiveSessions() int {
+	return int(s.handling.Load())
+}
+
+// closeForShutdown closes t on behalf of [Server.Shutdown].
+func (s *Server) closeForShutdown(t *Transport) {
+	if err := t.Close(); err != nil {
+		slog.Debug(
+			"closing session on shutdown",
+			slog.String("session_id", t.SessionID()),
+			slog.Any("error", err),
+		)
+	}
+}
@@ -0,0 +1,95 @@
+package kamune
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startShutdownServer runs a server whose handlers receive until their
+// session ends and then, if linger is set, wait for it to be closed before
+// returning. It reports every session once established.
+func startShutdownServer(
+	t *testing.T, linger <-chan struct{},
+) (*Server, string, <-chan *Transport, <-chan error) {
+	t.Helper()
+	sessions := make(chan *Transport, 4)
+	handler := func(tr *Transport) error {
+		sessions <- tr
+		for {
+			if _, err := tr.Receive(Bytes(nil)); err != nil {
+				break
+			}
+		}
+		if linger != nil {
+			<-linger
+		}
+		return nil
+	}
+	srv, addr, served := startTestServer(t, handler, nil, storePeer)
+	return srv, addr, sessions, served
+}
+
+func TestServerShutdown(t *testing.T) {
+	a := require.New(t)
+	srv, addr, sessions, served := startShutdownServer(t, nil)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	var dialed []*Transport
+	for range 2 {
+		d, err := NewDialer(addr, store, storePeer)
+		a.NoError(err)
+		tr, err := d.Dial()
+		a.NoError(err)
+		defer tr.Close()
+		dialed = append(dialed, tr)
+		<-sessions
+	}
+	a.Equal(2, srv.ActiveSessions())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a.NoError(srv.Shutdown(ctx))
+	a.Zero(srv.ActiveSessions())
+	a.NoError(<-served)
+	for _, tr := range dialed {
+		_, err := tr.Receive(Bytes(nil))
+		a.ErrorIs(err, ErrPeerDisconnected)
+	}
+
+	a.ErrorIs(srv.Shutdown(ctx), ErrClosedServer)
+	d, err := NewDialer(addr, store, storePeer)
+	a.NoError(err)
+	_, err = d.Dial()
+	a.Error(err)
+}
+
+func TestServerShutdown_Timeout(t *testing.T) {
+	a := require.New(t)
+	linger := make(chan struct{})
+	srv, addr, sessions, _ := startShutdownServer(t, linger)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	d, err := NewDialer(addr, store, storePeer)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	<-sessions
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 100*time.Millisecond,
+	)
+	defer cancel()
+	a.ErrorIs(srv.Shutdown(ctx), context.DeadlineExceeded)
+	a.Equal(1, srv.ActiveSessions())
+
+	close(linger)
+	a.Eventually(func() bool {
+		return srv.ActiveSessions() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,165 @@
+package kamune
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// TransportStats is a point-in-time snapshot of a transport's counters.
+//
+// Rejected frames (Undecryptable, OutOfSync) were never delivered to the
+// caller. They are surfaced so that applications on constrained or lossy
+// links can tell a noisy path apart from a healthy one.
+type TransportStats struct {
+	// MessagesSent and MessagesReceived count delivered messages, including
+	// protocol routes such as pings.
+	MessagesSent     uint64
+	MessagesReceived uint64
+	// BytesSent and BytesReceived count encrypted frame bytes, excluding the
+	// length prefix.
+	BytesSent     uint64
+	BytesReceived uint64
+	// Undecryptable is the number of frames that failed AEAD decryption or
+	// signature verification.
+	Undecryptable uint64
+	// OutOfSync is the number of frames rejected by sequence validation
+	// (duplicates, gaps, or reordering).
+	OutOfSync uint64
+	// Deduplicated is the number of sent messages that were replaced by a
+	// reference to a payload the peer already had.
+	Deduplicated uint64
+	// RateLimited is the number of received messages that were dropped or
+	// rejected for exceeding the session's [RateLimit].
+	RateLimited uint64
+	// Backlog is the number of received messages that a server with an inbox
+	// holds for the application, see [ServeWithInbox], and Spilled how many
+	// of them are kept in storage; see [ServeWithInboxSpill].
+	Backlog uint64
+	Spilled uint64
+	// RTT is the smoothed round-trip time to the peer, measured from each
+	// ping to the pong echoing it, and MinRTT the lowest sample. Both are
+	// zero until a ping has been answered, unless the path was chosen by
+	// [DialWithUDPPath], whose probe counts as the first sample. RTTSamples
+	// holds the most recent samples, oldest first.
+	RTT        time.Duration
+	MinRTT     time.Duration
+	RTTSamples []time.Duration
+	// KCP describes the KCP layer of a UDP connection, and is nil over other
+	// transports. Its retransmission counters tell a lossy link apart from a
+	// slow peer; see [ConnWithKCPProfile] and [ConnWithKCPAutoTune].
+	KCP *KCPStats
+}
+
+// transportStats holds the live counters behind [TransportStats].
+type transportStats struct {
+	messagesSent     atomic.Uint64
+	messagesReceived atomic.Uint64
+	bytesSent        atomic.Uint64
+	bytesReceived    atomic.Uint64
+	undecryptable    atomic.Uint64
+	outOfSync        atomic.Uint64
+	deduplicated     atomic.Uint64
+	rateLimited      atomic.Uint64
+	backlog          atomic.Int64
+	spilled          atomic.Int64
+	// routesSent and routesReceived count delivered messages per route.
+	routesSent     [numRoutes]atomic.Uint64
+	routesReceived [numRoutes]atomic.Uint64
+}
+
+// Stats returns a snapshot of the transport's counters.
+func (t *Transport) Stats() TransportStats {
+	st := TransportStats{
+		MessagesSent:     t.stats.messagesSent.Load(),
+		MessagesReceived: t.stats.messagesReceived.Load(),
+		BytesSent:        t.stats.bytesSent.Load(),
+		BytesReceived:    t.stats.bytesReceived.Load(),
+		Undecryptable:    t.stats.undecryptable.Load(),
+		OutOfSync:        t.stats.outOfSync.Load(),
+		Deduplicated:     t.stats.deduplicated.Load(),
+		RateLimited:      t.stats.rateLimited.Load(),
+		Backlog:          uint64(t.stats.backlog.Load()),
+		Spilled:          uint64(t.stats.spilled.Load()),
+	}
+	t.rtt.snapshot(&st)
+	if c, ok := t.currentConn().(*conn); ok && c.kcp != nil {
+		kst := c.kcp.stats()
+		st.KCP = &kst
+	}
+	return st
+}
+
+// bindStorage attaches the storage that the session's statistics are recorded
+// into when it ends, and marks the start of the connection.
+func (t *Transport) bindStorage(store *storage.Storage, resumed bool) {
+	t.store = store
+	t.established = time.Now()
+	t.resumed = resumed
+}
+
+// markSeen records in storage that the peer was just heard from; see
+// [storage.Storage.MarkPeerSeen].
+func (t *Transport) markSeen() {
+	if t.store == nil || t.remotePeer == nil {
+		return
+	}
+	err := t.store.MarkPeerSeen(t.remotePeer.PublicKey, time.Time{})
+	if err != nil {
+		slog.Debug(
+			"failed to record peer last seen",
+			slog.String("session_id", t.sessionID),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// record creates the session record of a fresh session, without which its
+// metadata, and with it resumption, cannot be kept. It fails, and the session
+// goes unrecorded, when the verifier did not store the peer.
+func (t *Transport) record(store *storage.Storage) {
+	err := store.CreateSession(t.sessionID, t.remotePeer.PublicKey)
+	if err != nil {
+		slog.Debug(
+			"failed to record session",
+			slog.String("session_id", t.sessionID),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// recordStats persists the transport's counters as a
+// [storage.SessionStats] record. Only the first call has an effect, so both
+// [Transport.Close] and the server's session teardown may call it.
+func (t *Transport) recordStats() {
+	if t.store == nil {
+		return
+	}
+	t.statsOnce.Do(func() {
+		st := t.Stats()
+		var peerKey []byte
+		if t.remotePeer != nil {
+			peerKey = t.remotePeer.PublicKey
+		}
+		err := t.store.RecordSessionStats(storage.SessionStats{
+			Start:            t.established,
+			End:              time.Now(),
+			SessionID:        t.sessionID,
+			PeerKey:          peerKey,
+			MessagesSent:     st.MessagesSent,
+			MessagesReceived: st.MessagesReceived,
+			BytesSent:        st.BytesSent,
+			BytesReceived:    st.BytesReceived,
+			Resumed:          t.resumed,
+		})
+		if err != nil {
+			slog.Warn(
+				"failed to record session stats",
+				slog.String("session_id", t.sessionID),
+				slog.Any("error", err),
+			)
+		}
+	})
+}
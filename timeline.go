@@ -0,0 +1,65 @@
+package kamune
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// timelineCheckpointInterval is the number of messages a session sends, or
+// receives, between the checkpoints of its timeline.
+const timelineCheckpointInterval = 1024
+
+// recordTimeline appends an event of the given kind to the session's timeline
+// in storage, if the timeline is enabled; see [ServeWithTimelineEnabled].
+// Failing to record does not fail the session.
+func (t *Transport) recordTimeline(kind storage.TimelineKind, detail string) {
+	if !t.timeline || t.store == nil {
+		return
+	}
+	t.mu.Lock()
+	sent, received := t.sendSequence, t.recvSequence
+	t.mu.Unlock()
+	err := t.store.RecordTimelineEvent(storage.TimelineEvent{
+		SessionID:       t.sessionID,
+		Kind:            kind,
+		Detail:          detail,
+		SendSequence:    sent,
+		ReceiveSequence: received,
+	})
+	if err != nil {
+		slog.Warn(
+			"recording session timeline",
+			slog.String("session_id", t.sessionID),
+			slog.String("kind", kind.String()),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// checkpointTimeline records a checkpoint once every
+// timelineCheckpointInterval messages, given the sequence number of the
+// message just sent or received.
+func (t *Transport) checkpointTimeline(seq uint64) {
+	if seq%timelineCheckpointInterval == 0 {
+		t.recordTimeline(storage.TimelineCheckpoint, "")
+	}
+}
+
+// endTimeline records how the session's connection ended, with err, or with
+// a local close if err is nil. Only the first end of each connection is
+// recorded, and timeouts are not ends.
+func (t *Transport) endTimeline(err error) {
+	if errors.Is(err, ErrReceiveTimeout) || t.timelineEnded.Swap(true) {
+		return
+	}
+	switch {
+	case err == nil:
+		t.recordTimeline(storage.TimelineClosed, "closed locally")
+	case errors.Is(err, ErrPeerDisconnected):
+		t.recordTimeline(storage.TimelineClosed, "closed by peer")
+	default:
+		t.recordTimeline(storage.TimelineError, err.Error())
+	}
+}
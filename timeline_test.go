@@ -0,0 +1,82 @@
+package kamune
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+func timelineKinds(
+	t *testing.T, store *storage.Storage, sessionID string,
+) []storage.TimelineKind {
+	t.Helper()
+	a := require.New(t)
+	events, err := store.SessionTimeline(sessionID)
+	a.NoError(err)
+	kinds := make([]storage.TimelineKind, 0, len(events))
+	for _, e := range events {
+		kinds = append(kinds, e.Kind)
+	}
+	return kinds
+}
+
+func TestTimeline(t *testing.T) {
+	a := require.New(t)
+	var srv *Server
+	addr, _, exited := startEchoServer(t,
+		ServeWithTimelineEnabled(true),
+		func(s *Server) error {
+			srv = s
+			return nil
+		},
+	)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, acceptAll, DialWithTimelineEnabled(true))
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	sid := tr.SessionID()
+
+	echo(t, tr, "before")
+	a.NoError(d.Migrate(tr))
+	echo(t, tr, "after")
+	tr.checkpointTimeline(timelineCheckpointInterval)
+	a.NoError(tr.Close())
+	<-exited
+
+	a.Equal([]storage.TimelineKind{
+		storage.TimelineEstablished,
+		storage.TimelineMigrated,
+		storage.TimelineCheckpoint,
+		storage.TimelineClosed,
+	}, timelineKinds(t, store, sid))
+	r, err := store.ReplaySession(sid, time.Time{})
+	a.NoError(err)
+	a.False(r.Connected)
+	a.Equal(1, r.Migrations)
+	a.Positive(r.SendSequence)
+	a.Equal("closed locally", r.LastEvent.Detail)
+
+	a.Eventually(func() bool {
+		kinds := timelineKinds(t, srv.storage, sid)
+		return len(kinds) > 0 && kinds[len(kinds)-1] == storage.TimelineClosed
+	}, 5*time.Second, 10*time.Millisecond)
+	events, err := srv.storage.SessionTimeline(sid)
+	a.NoError(err)
+	a.Equal(storage.TimelineEstablished, events[0].Kind)
+	a.Equal("closed by peer", events[len(events)-1].Detail)
+
+	// Without the option, nothing is recorded.
+	d, err = NewDialer(addr, store, acceptAll)
+	a.NoError(err)
+	tr, err = d.Dial()
+	a.NoError(err)
+	echo(t, tr, "untracked")
+	a.NoError(tr.Close())
+	a.Empty(timelineKinds(t, store, tr.SessionID()))
+}
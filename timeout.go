@@ -0,0 +1,206 @@
+package kamune
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// HandshakeStep identifies a stage of connection setup, as reported by
+// [HandshakeTimeoutError].
+type HandshakeStep int
+
+const (
+	// StepExchange is the HPKE key exchange that encrypts the rest of the
+	// setup.
+	StepExchange HandshakeStep = iota + 1
+	// StepIntroduction is the exchange of signed introductions. On the
+	// server it also covers reading a resume or migrate request, which
+	// takes the place of the dialer's introduction.
+	StepIntroduction
+	// StepHandshake is the MLKEM handshake request and response.
+	StepHandshake
+	// StepChallenge is the challenge-response that confirms both sides
+	// derived the same keys.
+	StepChallenge
+	// StepResumption is the resume request and its accept or reject.
+	StepResumption
+)
+
+func (s HandshakeStep) String() string {
+	switch s {
+	case StepExchange:
+		return "exchange"
+	case StepIntroduction:
+		return "introduction"
+	case StepHandshake:
+		return "handshake"
+	case StepChallenge:
+		return "challenge"
+	case StepResumption:
+		return "resumption"
+	default:
+		return fmt.Sprintf("step(%d)", int(s))
+	}
+}
+
+// HandshakeTimeouts bounds the individual steps of connection setup. A peer
+// that connects and then stalls is cut off after the timeout of the step it
+// stalled in, rather than only after the overall handshake timeout. A zero
+// duration leaves a step bounded by the overall timeout alone, and no step
+// can extend it.
+type HandshakeTimeouts struct {
+	Exchange     time.Duration
+	Introduction time.Duration
+	Handshake    time.Duration
+	Challenge    time.Duration
+	Resumption   time.Duration
+}
+
+func (h HandshakeTimeouts) of(step HandshakeStep) time.Duration {
+	switch step {
+	case StepExchange:
+		return h.Exchange
+	case StepIntroduction:
+		return h.Introduction
+	case StepHandshake:
+		return h.Handshake
+	case StepChallenge:
+		return h.Challenge
+	case StepResumption:
+		return h.Resumption
+	default:
+		return 0
+	}
+}
+
+func (h HandshakeTimeouts) validate() error {
+	for _, d := range []time.Duration{
+		h.Exchange, h.Introduction, h.Handshake, h.Challenge, h.Resumption,
+	} {
+		if d < 0 {
+			return fmt.Errorf("handshake timeouts must be non-negative")
+		}
+	}
+	return nil
+}
+
+// HandshakeTimeoutError is returned when the remote peer stalls during
+// connection setup. It matches [ErrHandshakeTimeout] with [errors.Is].
+type HandshakeTimeoutError struct {
+	// Err is the underlying deadline error.
+	Err error
+	// Timeout is the limit that expired: the step's own timeout, or what
+	// was left of the overall handshake timeout when the step began.
+	Timeout time.Duration
+	// Step is the step that did not complete in time.
+	Step HandshakeStep
+}
+
+func (e *HandshakeTimeoutError) Error() string {
+	return fmt.Sprintf(
+		"%s: %s step did not complete within %s",
+		ErrHandshakeTimeout, e.Step, e.Timeout,
+	)
+}
+
+func (e *HandshakeTimeoutError) Unwrap() []error {
+	return []error{ErrHandshakeTimeout, e.Err}
+}
+
+// stepTimer applies per-step deadlines to a connection being set up, within
+// the overall handshake deadline, and attributes timeouts to the step that
+// was in progress. A nil stepTimer does nothing.
+type stepTimer struct {
+	conn     Conn
+	deadline time.Time
+	// current is the deadline of the step in progress.
+	current time.Time
+	// trace records the steps, and is also where the rest of the connection
+	// setup records its events.
+	trace    *connTrace
+	timeouts HandshakeTimeouts
+	// slot is the handshake pool slot held by the connection, released once
+	// setup has completed; see ServeWithHandshakePool.
+	slot  *handshakeSlot
+	limit time.Duration
+	step  HandshakeStep
+	done  bool
+}
+
+// newStepTimer starts the overall handshake deadline on conn.
+func newStepTimer(
+	conn Conn, total time.Duration, timeouts HandshakeTimeouts,
+) *stepTimer {
+	st := &stepTimer{
+		conn:     conn,
+		deadline: time.Now().Add(total),
+		timeouts: timeouts,
+		limit:    total,
+	}
+	st.current = st.deadline
+	_ = conn.SetDeadline(st.deadline)
+	return st
+}
+
+// traced returns the trace of the connection, or nil if it is not traced.
+func (st *stepTimer) traced() *connTrace {
+	if st == nil {
+		return nil
+	}
+	return st.trace
+}
+
+// begin marks the start of step and tightens the connection's deadline to
+// the step's timeout, if it has one that expires before the overall deadline.
+func (st *stepTimer) begin(step HandshakeStep) {
+	if st == nil || st.done {
+		return
+	}
+	st.trace.phase(step.String())
+	now := time.Now()
+	st.step = step
+	deadline := st.deadline
+	st.limit = deadline.Sub(now)
+	if d := st.timeouts.of(step); d > 0 && now.Add(d).Before(deadline) {
+		deadline = now.Add(d)
+		st.limit = d
+	}
+	st.current = deadline
+	_ = st.conn.SetDeadline(deadline)
+}
+
+// extend gives the step in progress, and with it the overall deadline, d
+// more time. It accounts for waits on the application rather than the peer.
+func (st *stepTimer) extend(d time.Duration) {
+	if st == nil || st.done || d <= 0 {
+		return
+	}
+	st.deadline = st.deadline.Add(d)
+	st.current = st.current.Add(d)
+	st.limit += d
+	_ = st.conn.SetDeadline(st.current)
+}
+
+// finish clears the deadline once setup has completed. Errors from then on
+// are no longer attributed to a step.
+func (st *stepTimer) finish() {
+	if st == nil {
+		return
+	}
+	st.done = true
+	st.slot.release()
+	_ = st.conn.SetDeadline(time.Time{})
+}
+
+// wrap turns a deadline error raised during setup into a
+// [HandshakeTimeoutError] for the step in progress.
+func (st *stepTimer) wrap(err error) error {
+	if st == nil || st.done || st.step == 0 || err == nil {
+		return err
+	}
+	if !isTimeout(err) && !errors.Is(err, ErrReceiveTimeout) {
+		return err
+	}
+	return &HandshakeTimeoutError{Err: err, Timeout: st.limit, Step: st.step}
+}
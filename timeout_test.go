@@ -0,0 +1,179 @@
+package kamune
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stallConn silently drops every frame written after the first n, so that
+// the remote side waits for a message that never arrives.
+type stallConn struct {
+	Conn
+	n      int
+	writes atomic.Int64
+}
+
+func (c *stallConn) WriteBytes(b []byte) error {
+	if c.writes.Add(1) > int64(c.n) {
+		return nil
+	}
+	return c.Conn.WriteBytes(b)
+}
+
+func TestHandshakeTimeouts(t *testing.T) {
+	const stepTimeout = 100 * time.Millisecond
+	timeouts := HandshakeTimeouts{
+		Exchange:     stepTimeout,
+		Introduction: stepTimeout,
+		Handshake:    stepTimeout,
+		Challenge:    stepTimeout,
+		Resumption:   stepTimeout,
+	}
+	tests := []struct {
+		name string
+		// stalled is the side that observes the timeout; its peer drops
+		// every frame after the first writes.
+		stalled string
+		writes  int
+		resume  bool
+		step    HandshakeStep
+	}{
+		{name: "server exchange", stalled: "server", step: StepExchange},
+		// The dialer writes two frames during the exchange.
+		{
+			name: "server introduction", stalled: "server", writes: 2,
+			step: StepIntroduction,
+		},
+		{
+			name: "server handshake", stalled: "server", writes: 3,
+			step: StepHandshake,
+		},
+		{
+			name: "server challenge", stalled: "server", writes: 4,
+			step: StepChallenge,
+		},
+		{name: "dialer exchange", stalled: "dialer", step: StepExchange},
+		{
+			name: "dialer introduction", stalled: "dialer", writes: 1,
+			step: StepIntroduction,
+		},
+		{
+			name: "dialer handshake", stalled: "dialer", writes: 2,
+			step: StepHandshake,
+		},
+		{
+			name: "dialer challenge", stalled: "dialer", writes: 3,
+			step: StepChallenge,
+		},
+		{
+			name: "dialer resumption", stalled: "dialer", writes: 1,
+			resume: true, step: StepResumption,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			ctx := setupResumptionTest(t)
+			defer ctx.cleanup()
+
+			// Only the stalled side gets step timeouts, so that its peer
+			// does not give up first.
+			var srvOpts []ServerOptions
+			var dialOpts []DialOption
+			if tc.stalled == "server" {
+				srvOpts = append(srvOpts, ServeWithHandshakeTimeouts(timeouts))
+			} else {
+				dialOpts = append(dialOpts, DialWithHandshakeTimeouts(timeouts))
+			}
+			if tc.resume {
+				dialOpts = append(dialOpts, DialWithResume(ctx.sessionID))
+			}
+			srv, err := NewServer(
+				"", func(*Transport) error { return nil }, ctx.storage2,
+				acceptAll, srvOpts...,
+			)
+			a.NoError(err)
+			srv.attest = ctx.attest2
+			srv.handshakeOpts.timeout = 5 * time.Second
+			d, err := NewDialer("", ctx.storage1, acceptAll, dialOpts...)
+			a.NoError(err)
+			d.attest = ctx.attest1
+			d.handshakeOpts.timeout = 5 * time.Second
+
+			c1, c2 := net.Pipe()
+			defer c1.Close()
+			defer c2.Close()
+			var cn1, cn2 Conn = newConn(c1), newConn(c2)
+			if tc.stalled == "server" {
+				cn1 = &stallConn{Conn: cn1, n: tc.writes}
+			} else {
+				cn2 = &stallConn{Conn: cn2, n: tc.writes}
+			}
+
+			served := make(chan error, 1)
+			dialed := make(chan error, 1)
+			go func() { served <- srv.serve(cn2) }()
+			go func() {
+				_, err := d.handshake(cn1)
+				dialed <- err
+			}()
+
+			start := time.Now()
+			errs := served
+			if tc.stalled == "dialer" {
+				errs = dialed
+			}
+			err = <-errs
+			a.Less(time.Since(start), time.Second)
+			a.ErrorIs(err, ErrHandshakeTimeout)
+			var te *HandshakeTimeoutError
+			a.True(errors.As(err, &te))
+			a.Equal(tc.step, te.Step)
+			a.Equal(stepTimeout, te.Timeout)
+			a.Contains(err.Error(), tc.step.String())
+		})
+	}
+}
+
+func TestHandshakeTimeouts_OverallLimit(t *testing.T) {
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	srv, err := NewServer(
+		"", func(*Transport) error { return nil }, store, acceptAll,
+		ServeWithHandshakeTimeouts(HandshakeTimeouts{Exchange: time.Minute}),
+	)
+	a.NoError(err)
+	srv.handshakeOpts.timeout = 100 * time.Millisecond
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	err = srv.serve(newConn(c2))
+	var te *HandshakeTimeoutError
+	a.True(errors.As(err, &te))
+	a.Equal(StepExchange, te.Step)
+	a.LessOrEqual(te.Timeout, 100*time.Millisecond)
+}
+
+func TestHandshakeTimeouts_Invalid(t *testing.T) {
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	invalid := HandshakeTimeouts{Challenge: -time.Second}
+	_, err := NewServer(
+		"", func(*Transport) error { return nil }, store, acceptAll,
+		ServeWithHandshakeTimeouts(invalid),
+	)
+	a.Error(err)
+	_, err = NewDialer(
+		"", store, acceptAll, DialWithHandshakeTimeouts(invalid),
+	)
+	a.Error(err)
+}
@@ -0,0 +1,262 @@
+package kamune
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceKind is the kind of a [TraceEvent].
+type TraceKind int
+
+const (
+	// TraceSent is a message sent to the peer.
+	TraceSent TraceKind = iota + 1
+	// TraceReceived is a message received from the peer.
+	TraceReceived
+	// TracePhase is a step of connection setup beginning, the session being
+	// established, resumed, migrated, or adopted, or the connection failing.
+	TracePhase
+)
+
+func (k TraceKind) String() string {
+	switch k {
+	case TraceSent:
+		return "sent"
+	case TraceReceived:
+		return "received"
+	case TracePhase:
+		return "phase"
+	default:
+		return fmt.Sprintf("kind(%d)", int(k))
+	}
+}
+
+// TraceEvent is an event recorded by a [Tracer].
+type TraceEvent struct {
+	Time time.Time
+	// SessionID is empty until the handshake has agreed on one.
+	SessionID string
+	// Phase is the handshake step, as named by [HandshakeStep.String],
+	// "established", "resumed", "migrated", "adopted", or "failed", for
+	// TracePhase events.
+	Phase string
+	// Detail is the error of a failed connection.
+	Detail string
+	// Conn identifies the connection the event happened on, numbered from 1
+	// by the tracer.
+	Conn uint64
+	// Elapsed is the time since the connection's first event.
+	Elapsed time.Duration
+	// Route is the route of TraceSent and TraceReceived events.
+	Route Route
+	Kind  TraceKind
+}
+
+// Tracer records the protocol activity of every connection of the servers
+// and dialers it is given to: each route sent and received, the steps of
+// connection setup, and how they ended, with their timings. Only the most
+// recent events are kept, so that a tracer can be left enabled and read when
+// a user reports a handshake or resumption problem. Messages are recorded by
+// route alone; their contents never are. See [ServeWithTracer] and
+// [DialWithTracer].
+type Tracer struct {
+	// events is a ring buffer; next is the index the next event goes to.
+	events []TraceEvent
+	next   int
+	conns  uint64
+	mu     sync.Mutex
+	full   bool
+}
+
+// NewTracer returns a tracer that keeps the last size events, or 1024 if size
+// is not positive.
+func NewTracer(size int) *Tracer {
+	if size <= 0 {
+		size = traceDefaultSize
+	}
+	return &Tracer{events: make([]TraceEvent, size)}
+}
+
+// Events returns the recorded events, oldest first.
+func (tr *Tracer) Events() Trace {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if !tr.full {
+		return slices.Clone(tr.events[:tr.next])
+	}
+	return slices.Concat(tr.events[tr.next:], tr.events[:tr.next])
+}
+
+func (tr *Tracer) add(e TraceEvent) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.events[tr.next] = e
+	tr.next++
+	if tr.next == len(tr.events) {
+		tr.next = 0
+		tr.full = true
+	}
+}
+
+// start begins tracing a new connection. A nil Tracer returns a nil
+// connTrace, which records nothing.
+func (tr *Tracer) start() *connTrace {
+	if tr == nil {
+		return nil
+	}
+	tr.mu.Lock()
+	tr.conns++
+	id := tr.conns
+	tr.mu.Unlock()
+	return &connTrace{tracer: tr, started: time.Now(), id: id}
+}
+
+// connTrace records the events of one connection into its tracer.
+type connTrace struct {
+	tracer    *Tracer
+	started   time.Time
+	sessionID string
+	id        uint64
+	mu        sync.Mutex
+}
+
+func (c *connTrace) record(e TraceEvent) {
+	if c == nil {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	e.SessionID = c.sessionID
+	c.mu.Unlock()
+	e.Time = now
+	e.Elapsed = now.Sub(c.started)
+	e.Conn = c.id
+	c.tracer.add(e)
+}
+
+func (c *connTrace) sent(r Route) {
+	c.record(TraceEvent{Kind: TraceSent, Route: r})
+}
+
+func (c *connTrace) received(r Route) {
+	c.record(TraceEvent{Kind: TraceReceived, Route: r})
+}
+
+func (c *connTrace) phase(p string) {
+	c.record(TraceEvent{Kind: TracePhase, Phase: p})
+}
+
+func (c *connTrace) failed(err error) {
+	c.record(TraceEvent{
+		Kind: TracePhase, Phase: "failed", Detail: err.Error(),
+	})
+}
+
+// session tags the events that follow with the agreed session ID.
+func (c *connTrace) session(id string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessionID = id
+}
+
+// Trace is a sequence of [TraceEvent], oldest first.
+type Trace []TraceEvent
+
+// Connection returns the events of the connection numbered id.
+func (t Trace) Connection(id uint64) Trace {
+	var out Trace
+	for _, e := range t {
+		if e.Conn == id {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Session returns the events of every connection that carried the session,
+// whether it established, resumed, or failed to resume it, including the
+// events from before the session ID was agreed on.
+func (t Trace) Session(sessionID string) Trace {
+	conns := make(map[uint64]bool)
+	for _, e := range t {
+		if e.SessionID == sessionID {
+			conns[e.Conn] = true
+		}
+	}
+	var out Trace
+	for _, e := range t {
+		if conns[e.Conn] {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Mermaid renders the trace as a Mermaid sequence diagram between the local
+// side and its peer.
+func (t Trace) Mermaid() string {
+	var b strings.Builder
+	b.WriteString("sequenceDiagram\n")
+	b.WriteString("    participant L as Local\n")
+	b.WriteString("    participant P as Peer\n")
+	// Mermaid ends statements at semicolons and reads '#' as an escape.
+	escape := strings.NewReplacer(";", ",", "#", "", "\n", " ")
+	for _, e := range t {
+		switch e.Kind {
+		case TraceSent:
+			fmt.Fprintf(&b, "    L->>P: %s\n", e.label())
+		case TraceReceived:
+			fmt.Fprintf(&b, "    P->>L: %s\n", e.label())
+		case TracePhase:
+			fmt.Fprintf(
+				&b, "    Note over L,P: %s\n", escape.Replace(e.label()),
+			)
+		}
+	}
+	return b.String()
+}
+
+// PlantUML renders the trace as a PlantUML sequence diagram between the local
+// side and its peer.
+func (t Trace) PlantUML() string {
+	var b strings.Builder
+	b.WriteString("@startuml\n")
+	b.WriteString("participant Local as L\n")
+	b.WriteString("participant Peer as P\n")
+	escape := strings.NewReplacer("\n", " ")
+	for _, e := range t {
+		switch e.Kind {
+		case TraceSent:
+			fmt.Fprintf(&b, "L -> P: %s\n", e.label())
+		case TraceReceived:
+			fmt.Fprintf(&b, "P -> L: %s\n", e.label())
+		case TracePhase:
+			fmt.Fprintf(
+				&b, "note over L, P: %s\n", escape.Replace(e.label()),
+			)
+		}
+	}
+	b.WriteString("@enduml\n")
+	return b.String()
+}
+
+// label describes the event in a diagram.
+func (e TraceEvent) label() string {
+	elapsed := e.Elapsed.Round(time.Microsecond)
+	switch {
+	case e.Kind != TracePhase:
+		return fmt.Sprintf("%s (+%s)", e.Route, elapsed)
+	case e.Detail != "":
+		return fmt.Sprintf(
+			"conn %d: %s: %s (+%s)", e.Conn, e.Phase, e.Detail, elapsed,
+		)
+	default:
+		return fmt.Sprintf("conn %d: %s (+%s)", e.Conn, e.Phase, elapsed)
+	}
+}
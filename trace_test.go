@@ -0,0 +1,184 @@
+package kamune
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// traceSteps describes the events of a trace, one string each.
+func traceSteps(trace Trace) []string {
+	steps := make([]string, 0, len(trace))
+	for _, e := range trace {
+		if e.Kind == TracePhase {
+			steps = append(steps, fmt.Sprintf("%d %s", e.Conn, e.Phase))
+			continue
+		}
+		steps = append(
+			steps, fmt.Sprintf("%d %s %s", e.Conn, e.Kind, e.Route),
+		)
+	}
+	return steps
+}
+
+func TestTracer_Ring(t *testing.T) {
+	a := require.New(t)
+	tr := NewTracer(3)
+	conn := tr.start()
+	for _, r := range []Route{
+		RouteIdentity, RouteRequestHandshake, RouteAcceptHandshake,
+		RouteSendChallenge, RouteVerifyChallenge,
+	} {
+		conn.sent(r)
+	}
+	a.Equal([]string{
+		"1 sent AcceptHandshake",
+		"1 sent SendChallenge",
+		"1 sent VerifyChallenge",
+	}, traceSteps(tr.Events()))
+
+	a.Len(NewTracer(0).events, traceDefaultSize)
+	var none *Tracer
+	none.start().sent(RouteIdentity)
+}
+
+func TestTrace_Diagrams(t *testing.T) {
+	a := require.New(t)
+	trace := Trace{
+		{Kind: TracePhase, Phase: "introduction", Conn: 1},
+		{Kind: TraceSent, Route: RouteIdentity, Conn: 1},
+		{
+			Kind:    TraceReceived,
+			Route:   RouteIdentity,
+			Conn:    1,
+			Elapsed: 1500 * time.Microsecond,
+		},
+		{
+			Kind:    TracePhase,
+			Phase:   "failed",
+			Detail:  "verify remote; #1",
+			Conn:    1,
+			Elapsed: 2 * time.Millisecond,
+		},
+	}
+
+	a.Equal(`sequenceDiagram
+    participant L as Local
+    participant P as Peer
+    Note over L,P: conn 1: introduction (+0s)
+    L->>P: Identity (+0s)
+    P->>L: Identity (+1.5ms)
+    Note over L,P: conn 1: failed: verify remote, 1 (+2ms)
+`, trace.Mermaid())
+	a.Equal(`@startuml
+participant Local as L
+participant Peer as P
+note over L, P: conn 1: introduction (+0s)
+L -> P: Identity (+0s)
+P -> L: Identity (+1.5ms)
+note over L, P: conn 1: failed: verify remote; #1 (+2ms)
+@enduml
+`, trace.PlantUML())
+}
+
+func TestTracer_Sessions(t *testing.T) {
+	a := require.New(t)
+	serverTracer := NewTracer(0)
+	addr := startSessionServer(t, ServeWithTracer(serverTracer))
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	tracer := NewTracer(0)
+
+	d, err := NewDialer(addr, store, storePeer, DialWithTracer(tracer))
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	sessionID := tr.SessionID()
+	echo(t, tr, "hello")
+	a.NoError(tr.Close())
+
+	d, err = NewDialer(
+		addr, store, storePeer,
+		DialWithTracer(tracer), DialWithResume(sessionID),
+	)
+	a.NoError(err)
+	tr, err = d.Dial()
+	a.NoError(err)
+	a.NoError(tr.Close())
+
+	handshake := []string{
+		"handshake",
+		"sent RequestHandshake",
+		"received AcceptHandshake",
+		"challenge",
+		"sent SendChallenge",
+		"received VerifyChallenge",
+		"received SendChallenge",
+		"sent VerifyChallenge",
+	}
+	var want []string
+	add := func(conn int, steps ...string) {
+		for _, s := range steps {
+			want = append(want, fmt.Sprintf("%d %s", conn, s))
+		}
+	}
+	add(1, "exchange", "introduction", "sent Identity", "received Identity")
+	add(1, handshake...)
+	add(1, "established", "sent ExchangeMessages")
+	add(1, "received ExchangeMessages", "sent CloseTransport")
+	add(2, "exchange", "resumption", "sent ResumeRequest")
+	add(2, "received ResumeAccept")
+	add(2, handshake...)
+	add(2, "resumed", "sent CloseTransport")
+	a.Equal(want, traceSteps(tracer.Events()))
+	a.Equal(want, traceSteps(tracer.Events().Session(sessionID)))
+	a.Equal(want[:16], traceSteps(tracer.Events().Connection(1)))
+	a.Empty(tracer.Events().Session("unknown"))
+
+	// The server's trace mirrors the dialer's, and names the session too.
+	a.Eventually(func() bool {
+		return len(serverTracer.Events().Session(sessionID)) >= len(want)
+	}, time.Second, 10*time.Millisecond)
+	a.Equal(
+		[]string{"1 exchange", "1 introduction", "1 received Identity"},
+		traceSteps(serverTracer.Events().Connection(1)[:3]),
+	)
+}
+
+func TestTracer_Failure(t *testing.T) {
+	a := require.New(t)
+	tracer, serverTracer := NewTracer(0), NewTracer(0)
+	serverStore, cleanup := newTestStore(t)
+	defer cleanup()
+	addr, _ := startGuestServer(t, serverStore, ServeWithTracer(serverTracer))
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	d, err := NewDialer(addr, store, storePeer, DialWithTracer(tracer))
+	a.NoError(err)
+	_, err = d.Dial()
+	a.Error(err)
+
+	// The server rejects the dialer before sending its introduction.
+	a.Equal([]string{
+		"1 exchange", "1 introduction", "1 sent Identity", "1 failed",
+	}, traceSteps(tracer.Events()))
+	a.Contains(err.Error(), tracer.Events()[3].Detail)
+	a.Eventually(func() bool {
+		return len(serverTracer.Events()) == 4
+	}, time.Second, 10*time.Millisecond)
+	events := serverTracer.Events()
+	a.Equal([]string{
+		"1 exchange", "1 introduction", "1 received Identity", "1 failed",
+	}, traceSteps(events))
+	a.Contains(events[3].Detail, ErrVerificationFailed.Error())
+
+	_, err = NewServer("", nil, store, acceptAll, ServeWithTracer(nil))
+	a.Error(err)
+	_, err = NewDialer(
+		"127.0.0.1:1", store, acceptAll, DialWithTracer(nil),
+	)
+	a.Error(err)
+}
@@ -0,0 +1,569 @@
+package kamune
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+const (
+	// transferChunkSize is the most data a single transfer chunk carries,
+	// leaving room for the envelope within maxTransportSize.
+	transferChunkSize = 32 * 1024
+
+	// reasonNoHandler is the reason given for offers made to a session
+	// without a [TransferHandler].
+	reasonNoHandler = "transfers are not accepted"
+)
+
+// TransferOffer describes data that a peer offers to send with
+// [Transport.Transfer]. The receiver decides on it before any of the data is
+// sent.
+type TransferOffer struct {
+	// ID identifies the transfer within the session. It is assigned by
+	// [Transport.Transfer].
+	ID string
+	// Name is a name for the data, such as a file name.
+	Name string
+	// Type is the kind of data, such as a media type.
+	Type string
+	// Size is the exact number of bytes to be sent.
+	Size uint64
+	// Hash is the SHA-256 hash of the data, if known. The receiver checks the
+	// data against it once all of it has arrived.
+	Hash []byte
+}
+
+// TransferRejectedError is returned by [Transport.Transfer] when the peer
+// rejects the offer or aborts the transfer. It matches [ErrTransferRejected]
+// with [errors.Is].
+type TransferRejectedError struct {
+	// Reason is the reason the peer gave, if any.
+	Reason string
+}
+
+func (e *TransferRejectedError) Error() string {
+	if e.Reason == "" {
+		return ErrTransferRejected.Error()
+	}
+	return fmt.Sprintf("%s: %s", ErrTransferRejected, e.Reason)
+}
+
+func (e *TransferRejectedError) Unwrap() error { return ErrTransferRejected }
+
+// TransferHandler decides on a transfer the peer offers, by calling
+// [IncomingTransfer.Accept] or [IncomingTransfer.Reject]. It is called on its
+// own goroutine, and may take as long as it needs, such as to ask the user.
+type TransferHandler func(in *IncomingTransfer)
+
+// IncomingTransfer is a transfer offered by the peer. Its data is only sent
+// once it is accepted, and the session fails with [ErrUnsolicitedTransfer] if
+// the peer sends data that was not accepted or more than it offered.
+type IncomingTransfer struct {
+	t        *Transport
+	w        io.Writer
+	hash     hash.Hash
+	err      error
+	done     chan struct{}
+	progress func(TransferProgress)
+	// partial is the saved state of an earlier attempt at the transfer, and
+	// sink the file it is written to if it was accepted with AcceptFile.
+	partial  *storage.TransferState
+	sink     *fileSink
+	offer    TransferOffer
+	received uint64
+	state    transferState
+	mu       sync.Mutex
+}
+
+type transferState int
+
+const (
+	transferOffered transferState = iota
+	transferAccepted
+	// transferAborted is the state of a transfer that failed on the
+	// receiving side while data may still be on its way; that data is
+	// discarded.
+	transferAborted
+	transferFinished
+)
+
+// transfers tracks the transfers of a session in both directions.
+type transfers struct {
+	handler  TransferHandler
+	incoming map[string]*IncomingTransfer
+	// outgoing delivers the outcome of each offer made: the offset to
+	// send from once it is accepted, and an error if it is rejected or the
+	// session ends.
+	outgoing map[string]chan transferOutcome
+	mu       sync.Mutex
+}
+
+type transferOutcome struct {
+	err    error
+	offset uint64
+}
+
+// Offer returns the offer as the peer made it.
+func (in *IncomingTransfer) Offer() TransferOffer { return in.offer }
+
+// Accept accepts the offer and writes the data to w as it arrives. It blocks
+// until all the data is written, and returns an error if the transfer is cut
+// short or w fails, in which case the peer is told to stop.
+//
+// The data is written by the goroutine receiving from the session, so it only
+// arrives while the session is being received from.
+func (in *IncomingTransfer) Accept(w io.Writer) error {
+	return in.accept(w, sha256.New(), 0)
+}
+
+// accept accepts the offer, asking the peer to send the data from offset on,
+// and waits for it to be written to w. h holds the hash of the data before
+// offset.
+func (in *IncomingTransfer) accept(
+	w io.Writer, h hash.Hash, offset uint64,
+) error {
+	in.mu.Lock()
+	if in.state != transferOffered {
+		in.mu.Unlock()
+		return errors.New("transfer was already decided on")
+	}
+	in.w, in.hash, in.received = w, h, offset
+	in.state = transferAccepted
+	in.mu.Unlock()
+	if in.sink == nil {
+		in.forgetPartial()
+	}
+
+	if err := in.reply(true, "", offset); err != nil {
+		in.finish(err)
+	}
+	<-in.done
+	return in.err
+}
+
+// Reject rejects the offer, telling the peer why.
+func (in *IncomingTransfer) Reject(reason string) error {
+	in.mu.Lock()
+	if in.state != transferOffered {
+		in.mu.Unlock()
+		return errors.New("transfer was already decided on")
+	}
+	in.state = transferFinished
+	in.mu.Unlock()
+
+	in.t.transfers.remove(in.offer.ID)
+	in.forgetPartial()
+	in.finish(&TransferRejectedError{Reason: reason})
+	return in.reply(false, reason, 0)
+}
+
+func (in *IncomingTransfer) reply(
+	accepted bool, reason string, offset uint64,
+) error {
+	_, err := in.t.Send(&pb.TransferReply{
+		ID:       in.offer.ID,
+		Accepted: accepted,
+		Reason:   reason,
+		Offset:   offset,
+	}, RouteTransferReply)
+	return err
+}
+
+// finish records the outcome of the transfer. Only the first call has an
+// effect.
+func (in *IncomingTransfer) finish(err error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	select {
+	case <-in.done:
+	default:
+		in.err = err
+		close(in.done)
+	}
+}
+
+// write handles a chunk of the transfer. It returns an error only if the
+// peer broke the protocol, which fails the session.
+func (in *IncomingTransfer) write(c *pb.TransferChunk) error {
+	in.mu.Lock()
+	state := in.state
+	in.mu.Unlock()
+	switch state {
+	case transferAccepted:
+	case transferAborted:
+		if c.GetFinal() {
+			in.t.transfers.remove(in.offer.ID)
+		}
+		return nil
+	default:
+		return fmt.Errorf(
+			"%w: data for transfer %s", ErrUnsolicitedTransfer, in.offer.ID,
+		)
+	}
+
+	data := c.GetData()
+	if c.GetOffset() != in.received {
+		return fmt.Errorf(
+			"%w: transfer %s at offset %d, expected %d",
+			ErrUnsolicitedTransfer, in.offer.ID, c.GetOffset(), in.received,
+		)
+	}
+	if in.received+uint64(len(data)) > in.offer.Size {
+		return fmt.Errorf(
+			"%w: transfer %s exceeds the offered %d bytes",
+			ErrUnsolicitedTransfer, in.offer.ID, in.offer.Size,
+		)
+	}
+
+	if sum := c.GetHash(); sum != nil && !bytes.Equal(sum, hashChunk(data)) {
+		in.abort(fmt.Errorf(
+			"%w: chunk at offset %d of transfer %s",
+			ErrTransferCorrupt, in.received, in.offer.ID,
+		), c.GetFinal())
+		return nil
+	}
+
+	if _, err := in.w.Write(data); err != nil {
+		in.abort(fmt.Errorf("writing transfer: %w", err), c.GetFinal())
+		return nil
+	}
+	in.hash.Write(data)
+	in.received += uint64(len(data))
+	if in.progress != nil {
+		in.progress(TransferProgress{
+			ID:   in.offer.ID,
+			Name: in.offer.Name,
+			Done: in.received,
+			Size: in.offer.Size,
+		})
+	}
+	if !c.GetFinal() {
+		in.sink.checkpoint(in)
+		return nil
+	}
+
+	in.t.transfers.remove(in.offer.ID)
+	in.mu.Lock()
+	in.state = transferFinished
+	in.mu.Unlock()
+	if in.received != in.offer.Size {
+		in.finish(fmt.Errorf(
+			"transfer ended after %d of %d bytes",
+			in.received, in.offer.Size,
+		))
+		return nil
+	}
+	if sum := in.offer.Hash; sum != nil && !bytes.Equal(sum, in.hash.Sum(nil)) {
+		in.finish(fmt.Errorf(
+			"%w: transfer %s does not match its hash",
+			ErrTransferCorrupt, in.offer.ID,
+		))
+		return nil
+	}
+	in.finish(nil)
+	return nil
+}
+
+// abort fails an accepted transfer on the receiving side and tells the peer
+// to stop sending. The rest of its data is discarded as it arrives.
+func (in *IncomingTransfer) abort(err error, final bool) {
+	in.mu.Lock()
+	in.state = transferAborted
+	in.mu.Unlock()
+	if final {
+		in.t.transfers.remove(in.offer.ID)
+	}
+	in.finish(err)
+	_ = in.reply(false, "receiver failed", 0)
+}
+
+// HandleTransfers sets the handler that decides on the transfers the peer
+// offers; without one, every offer is rejected. Offers are read by
+// [Transport.Receive] like any other message, so the handler is only called
+// while the session is being received from. Transfers do not survive the
+// session's connection: resuming it does not resume them, but files received
+// with [IncomingTransfer.AcceptFile] can be resumed by a later transfer.
+func (t *Transport) HandleTransfers(h TransferHandler) {
+	t.transfers.mu.Lock()
+	defer t.transfers.mu.Unlock()
+	t.transfers.handler = h
+}
+
+// Transfer offers the peer offer.Size bytes read from r and, once the peer
+// accepts, sends them. It returns a [*TransferRejectedError] if the peer
+// rejects the offer or aborts the transfer, and an error if r ends early.
+// The peer's reply is read by [Transport.Receive] like any other message, so
+// another goroutine must be receiving from t meanwhile. ctx bounds both the
+// wait for the reply and the transfer itself.
+//
+// The data is sent on the [PriorityBulk] lane, so other messages are not held
+// up behind it.
+func (t *Transport) Transfer(
+	ctx context.Context, offer TransferOffer, r io.Reader,
+) error {
+	offer.ID = rand.Text()
+	return t.transfer(ctx, offer, r, nil)
+}
+
+// transfer makes offer and sends the data read from r, skipping to where the
+// peer asks to resume from if r is an [io.Seeker]. progress, if set, is
+// called after each chunk is sent.
+func (t *Transport) transfer(
+	ctx context.Context,
+	offer TransferOffer,
+	r io.Reader,
+	progress func(TransferProgress),
+) error {
+	outcome := make(chan transferOutcome, 2)
+	t.transfers.mu.Lock()
+	if t.transfers.outgoing == nil {
+		t.transfers.outgoing = make(map[string]chan transferOutcome)
+	}
+	t.transfers.outgoing[offer.ID] = outcome
+	t.transfers.mu.Unlock()
+	defer func() {
+		t.transfers.mu.Lock()
+		delete(t.transfers.outgoing, offer.ID)
+		t.transfers.mu.Unlock()
+	}()
+
+	_, err := t.Send(&pb.TransferOffer{
+		ID:   offer.ID,
+		Name: offer.Name,
+		Type: offer.Type,
+		Size: offer.Size,
+		Hash: offer.Hash,
+	}, RouteTransferOffer)
+	if err != nil {
+		return fmt.Errorf("offering transfer: %w", err)
+	}
+	var offset uint64
+	select {
+	case o := <-outcome:
+		if o.err != nil {
+			return o.err
+		}
+		offset = o.offset
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if offset > 0 {
+		// The final chunk lets the peer forget a transfer that cannot be
+		// resumed.
+		s, ok := r.(io.Seeker)
+		if !ok || offset > offer.Size {
+			_ = t.sendChunk(offer.ID, offset, nil, true)
+			return fmt.Errorf("cannot resume transfer at offset %d", offset)
+		}
+		if _, err := s.Seek(int64(offset), io.SeekStart); err != nil {
+			_ = t.sendChunk(offer.ID, offset, nil, true)
+			return fmt.Errorf("resuming transfer: %w", err)
+		}
+	}
+
+	src := io.LimitReader(r, int64(offer.Size-offset))
+	buf := make(
+		[]byte,
+		min(transferChunkSize, t.MaxMessageSize()-transferChunkOverhead),
+	)
+	for {
+		n, readErr := io.ReadFull(src, buf)
+		if errors.Is(readErr, io.EOF) ||
+			errors.Is(readErr, io.ErrUnexpectedEOF) {
+			readErr = nil
+		}
+		// A chunk that ends the data, early or not, is final; the receiver
+		// tells the two apart by the size.
+		final := readErr != nil || n < len(buf) ||
+			offset+uint64(n) == offer.Size
+
+		select {
+		case o := <-outcome:
+			// The receiver aborted; the final chunk lets it forget the
+			// transfer.
+			_ = t.sendChunk(offer.ID, offset, nil, true)
+			return o.err
+		case <-ctx.Done():
+			_ = t.sendChunk(offer.ID, offset, nil, true)
+			return ctx.Err()
+		default:
+		}
+
+		if err := t.sendChunk(offer.ID, offset, buf[:n], final); err != nil {
+			return fmt.Errorf("sending transfer: %w", err)
+		}
+		offset += uint64(n)
+		if progress != nil {
+			progress(TransferProgress{
+				ID:   offer.ID,
+				Name: offer.Name,
+				Done: offset,
+				Size: offer.Size,
+			})
+		}
+		switch {
+		case readErr != nil:
+			return fmt.Errorf("reading transfer: %w", readErr)
+		case !final:
+			continue
+		case offset != offer.Size:
+			return fmt.Errorf(
+				"reader ended after %d of %d bytes", offset, offer.Size,
+			)
+		default:
+			return nil
+		}
+	}
+}
+
+func (t *Transport) sendChunk(
+	id string, offset uint64, data []byte, final bool,
+) error {
+	_, err := t.SendWithPriority(&pb.TransferChunk{
+		ID:     id,
+		Offset: offset,
+		Data:   data,
+		Final:  final,
+		Hash:   hashChunk(data),
+	}, RouteTransferData, PriorityBulk)
+	return err
+}
+
+// hashChunk returns the SHA-256 hash of the data of a chunk.
+func hashChunk(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// isTransferRoute reports whether messages on r are handled by the transport
+// rather than returned to the application.
+func isTransferRoute(r Route) bool {
+	switch r {
+	case RouteTransferOffer, RouteTransferReply, RouteTransferData:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleTransfer handles a message on a transfer route. It returns an error
+// only if the peer broke the protocol, which fails the session.
+func (t *Transport) handleTransfer(route Route, msg []byte) error {
+	switch route {
+	case RouteTransferOffer:
+		var o pb.TransferOffer
+		if err := t.unmarshal(msg, &o); err != nil {
+			return err
+		}
+		return t.transfers.offered(t, &o)
+
+	case RouteTransferReply:
+		var r pb.TransferReply
+		if err := t.unmarshal(msg, &r); err != nil {
+			return err
+		}
+		outcome := transferOutcome{offset: r.GetOffset()}
+		if !r.GetAccepted() {
+			outcome.err = &TransferRejectedError{Reason: r.GetReason()}
+		}
+		t.transfers.mu.Lock()
+		ch := t.transfers.outgoing[r.GetID()]
+		t.transfers.mu.Unlock()
+		if ch != nil {
+			select {
+			case ch <- outcome:
+			default:
+			}
+		}
+		return nil
+
+	default:
+		var c pb.TransferChunk
+		if err := t.unmarshal(msg, &c); err != nil {
+			return err
+		}
+		t.transfers.mu.Lock()
+		in := t.transfers.incoming[c.GetID()]
+		t.transfers.mu.Unlock()
+		if in == nil {
+			return fmt.Errorf(
+				"%w: data for transfer %s", ErrUnsolicitedTransfer, c.GetID(),
+			)
+		}
+		return in.write(&c)
+	}
+}
+
+// offered registers a transfer offered by the peer and hands it to the
+// handler, or rejects it if there is none.
+func (tr *transfers) offered(t *Transport, o *pb.TransferOffer) error {
+	in := &IncomingTransfer{
+		t:    t,
+		done: make(chan struct{}),
+		offer: TransferOffer{
+			ID:   o.GetID(),
+			Name: o.GetName(),
+			Type: o.GetType(),
+			Size: o.GetSize(),
+			Hash: o.GetHash(),
+		},
+	}
+	tr.mu.Lock()
+	if _, ok := tr.incoming[in.offer.ID]; ok || in.offer.ID == "" {
+		tr.mu.Unlock()
+		return fmt.Errorf(
+			"%w: offer with duplicate ID %q",
+			ErrUnsolicitedTransfer, in.offer.ID,
+		)
+	}
+	if tr.incoming == nil {
+		tr.incoming = make(map[string]*IncomingTransfer)
+	}
+	tr.incoming[in.offer.ID] = in
+	h := tr.handler
+	tr.mu.Unlock()
+	in.partial = t.partialTransfer(in.offer)
+
+	if h == nil {
+		return in.Reject(reasonNoHandler)
+	}
+	go h(in)
+	return nil
+}
+
+func (tr *transfers) remove(id string) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	delete(tr.incoming, id)
+}
+
+// fail ends every transfer of the session with err.
+func (tr *transfers) fail(err error) {
+	tr.mu.Lock()
+	incoming := tr.incoming
+	tr.incoming = nil
+	outgoing := make([]chan transferOutcome, 0, len(tr.outgoing))
+	for _, ch := range tr.outgoing {
+		outgoing = append(outgoing, ch)
+	}
+	tr.mu.Unlock()
+	for _, in := range incoming {
+		in.finish(err)
+	}
+	for _, ch := range outgoing {
+		select {
+		case ch <- transferOutcome{err: err}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package kamune
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+)
+
+// transferResult is what the receiving side of a transfer saw.
+type transferResult struct {
+	err  error
+	data []byte
+}
+
+// startTransferServer runs a server whose sessions hand offers to h and
+// receive until the session fails. Each handler's outcome is sent on results,
+// and the error ending each session on ended.
+func startTransferServer(
+	t *testing.T, h func(in *IncomingTransfer) transferResult,
+) (string, <-chan transferResult, <-chan error) {
+	t.Helper()
+	results := make(chan transferResult, 8)
+	ended := make(chan error, 8)
+	handler := func(tr *Transport) error {
+		if h != nil {
+			tr.HandleTransfers(func(in *IncomingTransfer) {
+				results <- h(in)
+			})
+		}
+		for {
+			if _, err := tr.Receive(Bytes(nil)); err != nil {
+				ended <- err
+				return nil
+			}
+		}
+	}
+
+	_, addr, _ := startTestServer(t, handler, nil, acceptAll)
+	return addr, results, ended
+}
+
+func dialTransfer(t *testing.T, addr string) *Transport {
+	t.Helper()
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	t.Cleanup(cleanup)
+	d, err := NewDialer(addr, store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	t.Cleanup(func() { _ = tr.Close() })
+	go func() {
+		for {
+			if _, err := tr.Receive(Bytes(nil)); err != nil {
+				return
+			}
+		}
+	}()
+	return tr
+}
+
+func TestTransport_Transfer(t *testing.T) {
+	a := require.New(t)
+	data := make([]byte, 3*transferChunkSize+100)
+	_, err := rand.Read(data)
+	a.NoError(err)
+	accept := func(in *IncomingTransfer) transferResult {
+		var buf bytes.Buffer
+		err := in.Accept(&buf)
+		return transferResult{err: err, data: buf.Bytes()}
+	}
+
+	tests := []struct {
+		name    string
+		handler func(in *IncomingTransfer) transferResult
+		size    uint64
+		send    []byte
+		// reason is the reason the offer is rejected with, if it is.
+		reason string
+		// short is set if the sender runs out of data.
+		short bool
+	}{
+		{name: "accepted", handler: accept, size: 10, send: data[:10]},
+		{
+			name:    "several chunks",
+			handler: accept,
+			size:    uint64(len(data)),
+			send:    data,
+		},
+		{
+			name:    "exact chunk",
+			handler: accept,
+			size:    transferChunkSize,
+			send:    data[:transferChunkSize],
+		},
+		{name: "empty", handler: accept},
+		{
+			name: "rejected",
+			handler: func(in *IncomingTransfer) transferResult {
+				return transferResult{err: in.Reject("too large")}
+			},
+			size:   10,
+			send:   data[:10],
+			reason: "too large",
+		},
+		{name: "no handler", size: 10, send: data, reason: reasonNoHandler},
+		{
+			name:    "short reader",
+			handler: accept,
+			size:    uint64(len(data)),
+			send:    data[:transferChunkSize+1],
+			short:   true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			addr, results, _ := startTransferServer(t, tc.handler)
+			tr := dialTransfer(t, addr)
+
+			ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+			defer cancel()
+			offer := TransferOffer{
+				Name: "file.bin",
+				Type: "application/octet-stream",
+				Size: tc.size,
+			}
+			err := tr.Transfer(ctx, offer, bytes.NewReader(tc.send))
+			switch {
+			case tc.reason != "":
+				var rejected *TransferRejectedError
+				a.ErrorAs(err, &rejected)
+				a.Equal(tc.reason, rejected.Reason)
+				a.ErrorIs(err, ErrTransferRejected)
+				if tc.handler != nil {
+					a.NoError((<-results).err)
+				}
+			case tc.short:
+				a.Error(err)
+				a.Error((<-results).err)
+			default:
+				a.NoError(err)
+				res := <-results
+				a.NoError(res.err)
+				a.True(bytes.Equal(tc.send, res.data))
+			}
+
+			// The session carries on after the transfer.
+			_, err = tr.Send(Bytes([]byte("after")), RouteExchangeMessages)
+			a.NoError(err)
+		})
+	}
+}
+
+func TestTransport_TransferOfferDetails(t *testing.T) {
+	a := require.New(t)
+	offers := make(chan TransferOffer, 1)
+	addr, _, _ := startTransferServer(
+		t, func(in *IncomingTransfer) transferResult {
+			offers <- in.Offer()
+			return transferResult{err: in.Reject("")}
+		},
+	)
+	tr := dialTransfer(t, addr)
+
+	err := tr.Transfer(t.Context(), TransferOffer{
+		ID: "ignored", Name: "notes.txt", Type: "text/plain", Size: 42,
+	}, bytes.NewReader(nil))
+	a.ErrorIs(err, ErrTransferRejected)
+	offer := <-offers
+	a.NotEmpty(offer.ID)
+	a.NotEqual("ignored", offer.ID)
+	a.Equal("notes.txt", offer.Name)
+	a.Equal("text/plain", offer.Type)
+	a.EqualValues(42, offer.Size)
+}
+
+func TestTransport_UnsolicitedTransfer(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(tr *Transport) error
+	}{
+		{
+			name: "data without offer",
+			send: func(tr *Transport) error {
+				return tr.sendChunk("unknown", 0, []byte("data"), true)
+			},
+		},
+		{
+			name: "data before accept",
+			send: func(tr *Transport) error {
+				_, err := tr.Send(&pb.TransferOffer{
+					ID: "pending", Size: 4,
+				}, RouteTransferOffer)
+				if err != nil {
+					return err
+				}
+				return tr.sendChunk("pending", 0, []byte("data"), true)
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := require.New(t)
+			// The handler never decides, so the offer stays pending.
+			block := make(chan struct{})
+			t.Cleanup(func() { close(block) })
+			addr, _, ended := startTransferServer(
+				t, func(*IncomingTransfer) transferResult {
+					<-block
+					return transferResult{}
+				},
+			)
+			tr := dialTransfer(t, addr)
+			a.NoError(tc.send(tr))
+
+			select {
+			case err := <-ended:
+				a.True(errors.Is(err, ErrUnsolicitedTransfer), err)
+			case <-time.After(5 * time.Second):
+				a.Fail("session did not fail")
+			}
+		})
+	}
+}
@@ -9,6 +9,10 @@ import (
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
 
 	"github.com/kamune-org/kamune/internal/enigma"
 	"github.com/kamune-org/kamune/pkg/storage"
@@ -30,14 +34,53 @@ func isTimeout(err error) bool {
 type Transport struct {
 	conn           Conn
 	serde          *signedSerde
+	queue          *sendQueue
+	outbound       *dedupCache
+	inbound        *dedupCache
 	encoder        *enigma.Enigma
 	decoder        *enigma.Enigma
 	mu             *sync.Mutex
 	remotePeer     *storage.Peer
+	store          *storage.Storage
+	policy         *AccessPolicy
+	limiter        *rateLimiter
+	abuse          *abuseState
+	reputation     *ReputationPolicy
+	retransmit     *retransmitter
+	trace          *connTrace
+	untrack        func()
+	migrating      chan struct{}
 	sessionID      string
+	service        string
+	reconnectAddr  string
+	role           string
+	network        string
 	resumptionRoot []byte
+	established    time.Time
+	expiresAt      time.Time
+	stats          transportStats
+	transfers      transfers
+	wipes          wipes
+	groups         groupLinks
+	heartbeat      heartbeat
+	rtt            rttTracker
+	reader         frameReader
 	recvSequence   uint64
 	sendSequence   uint64
+	receiveLimit   int
+	frameFormat    FrameFormat
+	statsOnce      sync.Once
+	closed         atomic.Bool
+	timelineEnded  atomic.Bool
+	resumed        bool
+	journal        bool
+	timeline       bool
+	guest          bool
+	addressBook    bool
+	wipeable       bool
+	groupable      bool
+	acking         bool
+	followHints    bool
 }
 
 func newTransport(
@@ -46,7 +89,7 @@ func newTransport(
 	sessionID string,
 	encoder, decoder *enigma.Enigma,
 ) *Transport {
-	return &Transport{
+	t := &Transport{
 		conn:      conn,
 		mu:        &sync.Mutex{},
 		encoder:   encoder,
@@ -54,36 +97,144 @@ func newTransport(
 		sessionID: sessionID,
 		serde:     serde,
 	}
+	t.queue = newSendQueue(t.write)
+	return t
 }
 
 // Receive reads and decrypts the next message from the connection.
 // It populates the dst, returns the metadata and any error.
 func (t *Transport) Receive(dst Transferable) (*Metadata, error) {
-	payload, err := t.conn.ReadBytes()
-	switch {
-	case err == nil: // continue
-	case errors.Is(err, io.EOF):
-		return nil, ErrConnClosed
-	case isTimeout(err):
-		return nil, ErrReceiveTimeout
-	default:
-		return nil, fmt.Errorf("reading payload: %w", err)
+	return t.receiveInto(dst, wait{})
+}
+
+// receiveInto receives the next message into dst, waiting for it as w
+// allows.
+func (t *Transport) receiveInto(
+	dst Transferable, w wait,
+) (*Metadata, error) {
+	metadata, msg, err := t.receiveWithin(w)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.unmarshal(msg, dst); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// unmarshal decodes a message returned by receive into dst. A message that
+// does not fit dst is not counted as undecryptable: it was authenticated, so
+// the mismatch is no sign of a hostile peer.
+func (t *Transport) unmarshal(msg []byte, dst Transferable) error {
+	if err := proto.Unmarshal(msg, dst); err != nil {
+		return fmt.Errorf("deserializing: unmarshalling message: %w", err)
+	}
+	return nil
+}
+
+// receive returns the next message for the application, with its metadata,
+// leaving the choice of message type to the caller. Messages on transfer
+// routes are handled here and not returned.
+func (t *Transport) receive() (*Metadata, []byte, error) {
+	return t.receiveWithin(wait{})
+}
+
+// receiveWithin is receive, waiting for each frame as w allows.
+func (t *Transport) receiveWithin(w wait) (*Metadata, []byte, error) {
+	for {
+		metadata, msg, err := t.receiveFrame(w)
+		if err != nil {
+			t.endTimeline(err)
+			if errors.Is(err, ErrConnClosed) ||
+				errors.Is(err, ErrPeerDisconnected) {
+				t.transfers.fail(err)
+				t.wipes.fail(err)
+				t.detachGroups()
+				t.endDeliveries(err)
+			}
+			return nil, nil, err
+		}
+		if t.handleAnnouncement(metadata.Route(), msg) {
+			continue
+		}
+		if metadata.Route() == RouteReconnectHint {
+			return nil, nil, t.handleReconnectHint(msg)
+		}
+		if isWipeRoute(metadata.Route()) {
+			if err := t.handleWipe(metadata.Route(), msg); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if metadata.Route() == RouteAck {
+			if err := t.handleAck(msg); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if metadata.Route() == RouteGroupMessage {
+			if err := t.handleGroupMessage(msg); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if !isTransferRoute(metadata.Route()) {
+			drop, err := t.throttle(metadata.Route())
+			if err == nil && !drop {
+				drop, err = t.screen(metadata.Route(), msg)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if !drop {
+				t.acknowledge(metadata)
+				return metadata, msg, nil
+			}
+			continue
+		}
+		if err := t.handleTransfer(metadata.Route(), msg); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// receiveFrame reads, decrypts, and validates the next message, waiting for
+// it as w allows.
+func (t *Transport) receiveFrame(w wait) (*Metadata, []byte, error) {
+	payload, err := t.nextPayload(w)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := t.checkFrameSize(payload); err != nil {
+		return nil, nil, err
 	}
 
 	decrypted, err := t.decoder.Decrypt(payload)
 	if err != nil {
-		return nil, fmt.Errorf("decrypting payload: %w", err)
+		t.stats.undecryptable.Add(1)
+		return nil, nil, fmt.Errorf("decrypting payload: %w", err)
+	}
+	decrypted, err = t.unframe(decrypted)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	metadata, err := t.serde.deserialize(decrypted, dst)
+	metadata, msg, err := t.serde.verify(decrypted)
 	if err != nil {
-		return nil, fmt.Errorf("deserializing: %w", err)
+		if errors.Is(err, ErrInvalidSignature) {
+			t.stats.undecryptable.Add(1)
+		}
+		return nil, nil, fmt.Errorf("deserializing: %w", err)
 	}
+	if err := t.checkMessageSize(msg); err != nil {
+		return nil, nil, err
+	}
+	t.trace.received(metadata.Route())
 
 	// Check for protocol-level routes before sequence validation.
 	switch metadata.Route() {
 	case RouteCloseTransport:
-		return nil, ErrPeerDisconnected
+		return nil, nil, ErrPeerDisconnected
 	case RoutePing:
 		// Ping is handled externally by the application; return
 		// metadata for the caller to respond with a pong.
@@ -96,56 +247,231 @@ func (t *Transport) Receive(dst Transferable) (*Metadata, error) {
 	expected := t.recvSequence + 1
 	if seq != expected {
 		t.mu.Unlock()
+		t.stats.outOfSync.Add(1)
 		if seq < expected {
-			return nil, fmt.Errorf(
+			return nil, nil, fmt.Errorf(
 				"%w: duplicate message seq %d, expected %d",
 				ErrOutOfSync, seq, expected,
 			)
 		}
-		return nil, fmt.Errorf(
+		return nil, nil, fmt.Errorf(
 			"%w: missing messages, got seq %d, expected %d",
 			ErrOutOfSync, seq, expected,
 		)
 	}
 	t.recvSequence = seq
 	t.mu.Unlock()
+	t.checkpointTimeline(seq)
 
-	return metadata, nil
+	msg, err = t.inbound.resolve(metadata.pb.GetReference(), msg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolving payload: %w", err)
+	}
+	metadata.message = msg
+	t.stats.messagesReceived.Add(1)
+	t.stats.bytesReceived.Add(uint64(len(payload)))
+	countRoute(&t.stats.routesReceived, metadata.Route())
+	t.heartbeat.deliver(metadata)
+	if metadata.Route() == RoutePong {
+		t.rtt.answered(msg)
+	}
+	t.countReceived(metadata.Route())
+	t.markSeen()
+
+	if err := t.authorize(metadata.Route()); err != nil {
+		return nil, nil, err
+	}
+
+	return metadata, msg, nil
 }
 
-// Send encrypts and sends a message with the specified route.
+// Send encrypts and sends a message with the specified route. The message is
+// queued on the route's default lane: control routes (ping, pong, close) use
+// [PriorityControl] and everything else uses [PriorityNormal].
 func (t *Transport) Send(message Transferable, route Route) (*Metadata, error) {
+	return t.SendWithPriority(message, route, priorityForRoute(route))
+}
+
+// SendWithPriority encrypts and sends a message on the given priority lane.
+// Queued messages on a higher lane are written before those on lower lanes;
+// messages on the same lane are written in the order they were submitted. It
+// blocks until the message has been written or has failed.
+//
+// Sequence numbers are assigned when a message is written, not when it is
+// queued, so overtaking never causes the receiver to see a gap.
+func (t *Transport) SendWithPriority(
+	message Transferable, route Route, priority Priority,
+) (*Metadata, error) {
+	if !route.IsValid() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRoute, route)
+	}
+	if !priority.IsValid() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPriority, priority)
+	}
+
+	req := &sendRequest{message: message, route: route, priority: priority}
+	t.queue.submit(req)
+	return req.metadata, req.err
+}
+
+// SendEphemeral is [Transport.Send] for an off-the-record message: the
+// message is marked in its signed metadata as not to be persisted, which
+// the receiver learns from [Metadata.Ephemeral]. Neither side keeps it in
+// storage: it is not journaled, kept for retransmission, or spilled from the
+// inbox, so it is lost if the connection drops before it is delivered.
+// Applications are expected to leave it out of their chat history, whatever
+// their retention settings; the sender cannot enforce this.
+func (t *Transport) SendEphemeral(
+	message Transferable, route Route,
+) (*Metadata, error) {
 	if !route.IsValid() {
 		return nil, fmt.Errorf("%w: %s", ErrInvalidRoute, route)
 	}
+	req := &sendRequest{
+		message:   message,
+		route:     route,
+		priority:  priorityForRoute(route),
+		ephemeral: true,
+	}
+	t.queue.submit(req)
+	return req.metadata, req.err
+}
+
+// write serializes, encrypts, and writes a single queued request. It is only
+// called by the send queue's current writer, so writes never interleave.
+func (t *Transport) write(req *sendRequest) {
+	heartbeat, err := t.heartbeat.payload(req.route)
+	if err != nil {
+		req.err = err
+		return
+	}
 
 	t.mu.Lock()
 	t.sendSequence++
 	seq := t.sendSequence
+	cn := t.conn
 	t.mu.Unlock()
 
-	payload, metadata, err := t.serde.serialize(message, route, seq)
+	payload, metadata, err := t.serde.serializeWith(
+		req.message, req.route, seq, t.outbound, heartbeat, metadataMarks{
+			id: req.id, ephemeral: req.ephemeral, ack: req.ack,
+		},
+	)
 	if err != nil {
-		return nil, fmt.Errorf("serializing: %w", err)
+		// Give back the sequence number so the receiver does not see a gap.
+		t.mu.Lock()
+		t.sendSequence--
+		t.mu.Unlock()
+		req.err = fmt.Errorf("serializing: %w", err)
+		return
 	}
 
-	if err := t.conn.WriteBytes(t.encoder.Encrypt(payload)); err != nil {
-		return nil, fmt.Errorf("writing: %w", err)
+	// A message journaled as pending but never marked sent may or may not
+	// have reached the peer before a crash.
+	t.journalMessage(metadata, req, storage.MessagePending)
+	encrypted := t.encoder.Encrypt(t.frame(payload))
+	if err := cn.WriteBytes(encrypted); err != nil {
+		req.err = fmt.Errorf("writing: %w", err)
+		return
+	}
+	t.journalMessage(metadata, req, storage.MessageSent)
+	t.checkpointTimeline(seq)
+	t.trace.sent(req.route)
+	t.bufferSent(req)
+	t.stats.messagesSent.Add(1)
+	t.stats.bytesSent.Add(uint64(len(encrypted)))
+	countRoute(&t.stats.routesSent, req.route)
+	if req.route == RoutePing {
+		t.rtt.sent(req.message)
+	}
+	if metadata.pb.GetReference() != nil {
+		t.stats.deduplicated.Add(1)
 	}
 
-	return metadata, nil
+	req.metadata = metadata
 }
 
 // Close closes the transport connection. It sends a RouteCloseTransport frame
 // before closing (best-effort — if the send fails, it closes directly).
 func (t *Transport) Close() error {
 	_, _ = t.Send(Bytes(nil), RouteCloseTransport)
-	return t.conn.Close()
+	t.closed.Store(true)
+	t.reader.stop()
+	err := t.currentConn().Close()
+	t.transfers.fail(ErrConnClosed)
+	t.wipes.fail(ErrConnClosed)
+	t.detachGroups()
+	t.endDeliveries(ErrConnClosed)
+	t.recordStats()
+	t.endTimeline(nil)
+	if t.untrack != nil {
+		t.untrack()
+	}
+	return err
+}
+
+// readPayload reads the next frame from the connection, following the
+// session when it migrates.
+func (t *Transport) readPayload() ([]byte, error) {
+	cn := t.currentConn()
+	payload, err := cn.ReadBytes()
+	for err != nil {
+		// The peer may close the old connection before the migration that
+		// replaces it has finished on this side.
+		t.awaitMigration()
+		if t.currentConn() == cn {
+			break
+		}
+		// The session migrated to a new connection while we were blocked on
+		// the old one; continue reading from the new path.
+		cn = t.currentConn()
+		payload, err = cn.ReadBytes()
+	}
+	switch {
+	case err == nil:
+		return payload, nil
+	case errors.Is(err, io.EOF):
+		return nil, ErrConnClosed
+	case isTimeout(err):
+		return nil, ErrReceiveTimeout
+	default:
+		return nil, fmt.Errorf("reading payload: %w", err)
+	}
+}
+
+// currentConn returns the connection the session is currently bound to. It
+// changes when the session migrates to a new network path.
+func (t *Transport) currentConn() Conn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn
 }
 
 // SessionID returns the unique identifier for this session.
 func (t *Transport) SessionID() string { return t.sessionID }
 
+// Service returns the service the dialer requested for this session with
+// [Dialer.DialService], or an empty string if none was requested. It is kept
+// across resumptions.
+func (t *Transport) Service() string { return t.service }
+
+// setService records the requested service and persists it for resumption.
+func (t *Transport) setService(store *storage.Storage, service string) {
+	t.service = service
+	if service != "" {
+		_ = store.SetMeta(t.sessionID, storage.NewBytesMeta(
+			storage.ServiceKey, []byte(service),
+		))
+	}
+}
+
+// loadService restores the service of a resumed session.
+func (t *Transport) loadService(store *storage.Storage) {
+	if m, err := store.GetMeta(t.sessionID, storage.ServiceKey); err == nil {
+		t.service = string(m.Value())
+	}
+}
+
 // RemotePeer returns the remote peer's identity (name, public key, and app
 // version) as established during the introduction phase.
 func (t *Transport) RemotePeer() *storage.Peer { return t.remotePeer }
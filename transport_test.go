@@ -1,8 +1,11 @@
 package kamune
 
 import (
+	"io"
 	"math"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/proto"
@@ -82,3 +85,216 @@ func TestTransport_EncryptFitsUint16(t *testing.T) {
 		"last bucket + AEAD must fit math.MaxUint16",
 	)
 }
+
+// scriptedConn replays a fixed list of frames from ReadBytes.
+type scriptedConn struct {
+	frames [][]byte
+}
+
+func (c *scriptedConn) ReadBytes() ([]byte, error) {
+	if len(c.frames) == 0 {
+		return nil, io.EOF
+	}
+	f := c.frames[0]
+	c.frames = c.frames[1:]
+	return f, nil
+}
+
+func (c *scriptedConn) WriteBytes([]byte) error       { return nil }
+func (c *scriptedConn) SetDeadline(t time.Time) error { return nil }
+func (c *scriptedConn) Close() error                  { return nil }
+
+func TestTransport_StatsCountsRejectedFrames(t *testing.T) {
+	a := require.New(t)
+	cn := &scriptedConn{}
+	tr := newLoopbackTransport(t, cn)
+
+	frame := func(seq uint64) []byte {
+		payload, _, err := tr.serde.serialize(
+			Bytes([]byte("hi")), RouteExchangeMessages, seq,
+		)
+		a.NoError(err)
+		return tr.encoder.Encrypt(payload)
+	}
+	delivered := frame(1)
+	cn.frames = [][]byte{
+		[]byte("not a valid frame at all, too short to decrypt"),
+		delivered,
+		frame(1),
+		frame(3),
+	}
+
+	_, err := tr.Receive(Bytes(nil))
+	a.Error(err)
+	_, err = tr.Receive(Bytes(nil))
+	a.NoError(err)
+	_, err = tr.Receive(Bytes(nil))
+	a.ErrorIs(err, ErrOutOfSync)
+	_, err = tr.Receive(Bytes(nil))
+	a.ErrorIs(err, ErrOutOfSync)
+	_, err = tr.Receive(Bytes(nil))
+	a.ErrorIs(err, ErrConnClosed)
+
+	a.Equal(TransportStats{
+		MessagesReceived: 1,
+		BytesReceived:    uint64(len(delivered)),
+		Undecryptable:    1,
+		OutOfSync:        2,
+	}, tr.Stats())
+}
+
+func TestTransport_StatsIgnoresMismatchedTypes(t *testing.T) {
+	a := require.New(t)
+	cn := &scriptedConn{}
+	tr := newLoopbackTransport(t, cn)
+	payload, _, err := tr.serde.serialize(
+		Bytes([]byte{0xff}), RouteExchangeMessages, 1,
+	)
+	a.NoError(err)
+	cn.frames = [][]byte{tr.encoder.Encrypt(payload)}
+
+	// The frame is authentic but is no valid Envelope, whose type is a
+	// string.
+	_, err = tr.Receive(&pb.Envelope{})
+	a.Error(err)
+	a.Zero(tr.Stats().Undecryptable)
+}
+
+func TestTransport_StatsCountsDeliveredMessages(t *testing.T) {
+	a := require.New(t)
+	cn := newGatedConn()
+	close(cn.release)
+	tr := newLoopbackTransport(t, cn)
+
+	for _, msg := range []string{"a", "bb", "ccc"} {
+		_, err := tr.Send(Bytes([]byte(msg)), RouteExchangeMessages)
+		a.NoError(err)
+	}
+
+	var size uint64
+	for _, f := range cn.frames {
+		size += uint64(len(f))
+	}
+	stats := tr.Stats()
+	a.EqualValues(3, stats.MessagesSent)
+	a.Equal(size, stats.BytesSent)
+	a.Zero(stats.MessagesReceived)
+}
+
+func TestTransport_RecordsStatsOnClose(t *testing.T) {
+	a := require.New(t)
+	addr, _, _ := startEchoServer(t)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+
+	echo(t, tr, "hello")
+	echo(t, tr, "world")
+	live := tr.Stats()
+	a.NoError(tr.Close())
+
+	u, err := store.SessionUsage(tr.SessionID())
+	a.NoError(err)
+	a.Equal(1, u.Connections)
+	a.Zero(u.Resumptions)
+	a.Equal(live.MessagesReceived, u.MessagesReceived)
+	// The close frame is sent after the snapshot above.
+	a.Equal(live.MessagesSent+1, u.MessagesSent)
+	a.Positive(u.Duration)
+
+	_ = tr.Close()
+	u, err = store.SessionUsage(tr.SessionID())
+	a.NoError(err)
+	a.Equal(1, u.Connections, "stats are recorded once")
+}
+
+func TestTransport_MarksPeerSeen(t *testing.T) {
+	a := require.New(t)
+	addr := startSessionServer(t)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, storePeer)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	key := tr.RemotePeer().PublicKey
+	stored, err := store.PeerLastSeen(key)
+	a.NoError(err)
+
+	time.Sleep(10 * time.Millisecond)
+	before := time.Now()
+	echo(t, tr, "hello")
+	seen, err := store.PeerLastSeen(key)
+	a.NoError(err)
+	a.True(seen.After(stored))
+	a.False(seen.Before(before))
+}
+
+func TestTransport_SendEphemeral(t *testing.T) {
+	a := require.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	serverStore, cleanup := newTestStore(t)
+	defer cleanup()
+	received := make(chan bool, 2)
+	srv, err := NewServer(
+		"", Managed(Handlers{
+			OnMessage: func(m *InboundMessage) {
+				received <- m.Metadata.Ephemeral()
+			},
+		}), serverStore, storePeer,
+		ServeWithListener(&tcpListener{Listener: l}),
+		ServeWithRetransmitWindow(8),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(
+		l.Addr().String(), store, storePeer,
+		DialWithJournalEnabled(true), DialWithRetransmitWindow(8),
+	)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+
+	kept, err := tr.Send(Bytes([]byte("kept")), RouteExchangeMessages)
+	a.NoError(err)
+	a.False(kept.Ephemeral())
+	md, err := tr.SendEphemeral(
+		Bytes([]byte("off the record")), RouteExchangeMessages,
+	)
+	a.NoError(err)
+	a.True(md.Ephemeral())
+	_, err = tr.SendEphemeral(Bytes(nil), Route(0))
+	a.ErrorIs(err, ErrInvalidRoute)
+
+	for _, want := range []bool{false, true} {
+		select {
+		case got := <-received:
+			a.Equal(want, got)
+		case <-time.After(5 * time.Second):
+			a.FailNow("message not received")
+		}
+	}
+
+	// Only the first message is journaled and kept for retransmission.
+	entries, err := store.UnconfirmedMessages(tr.SessionID())
+	a.NoError(err)
+	a.Len(entries, 1)
+	a.Equal(kept.ID(), entries[0].ID)
+	buffered, err := store.TakeBufferedMessages(tr.SessionID(), 0)
+	a.NoError(err)
+	a.Len(buffered, 1)
+	a.Equal(uint64(1), buffered[0].Number)
+}
@@ -0,0 +1,159 @@
+package kamune
+
+import (
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Receive reads the next message from t and decodes it into a new value of
+// type T, sparing the caller from allocating the destination:
+//
+//	msg, md, err := kamune.Receive[*wrapperspb.StringValue](t)
+func Receive[T Transferable](t *Transport) (T, *Metadata, error) {
+	var zero T
+	msg := zero.ProtoReflect().Type().New().Interface().(T)
+	md, err := t.Receive(msg)
+	if err != nil {
+		return zero, nil, err
+	}
+	return msg, md, nil
+}
+
+// Send sends msg over t on route. It is the counterpart of [Receive] and is
+// equivalent to [Transport.Send].
+func Send[T Transferable](t *Transport, msg T, route Route) (*Metadata, error) {
+	return t.Send(msg, route)
+}
+
+// TypeRegistry maps routes to the concrete message types carried on them, so
+// that a receive loop can decode every message into the right type without a
+// switch over [Metadata.Route]. Each route carries at most one type, and each
+// type is carried on at most one route. It also maps the application message
+// types named with [SendTyped] to concrete types, for a [Router] to decode
+// them. It is safe for concurrent use.
+type TypeRegistry struct {
+	types  map[Route]protoreflect.MessageType
+	routes map[protoreflect.FullName]Route
+	named  map[string]protoreflect.MessageType
+	mu     sync.RWMutex
+}
+
+// NewTypeRegistry returns an empty TypeRegistry.
+func NewTypeRegistry() *TypeRegistry {
+	return &TypeRegistry{
+		types:  make(map[Route]protoreflect.MessageType),
+		routes: make(map[protoreflect.FullName]Route),
+		named:  make(map[string]protoreflect.MessageType),
+	}
+}
+
+// Register associates route with the type of msg. The value of msg is not
+// retained; a nil pointer of the right type is enough.
+func (r *TypeRegistry) Register(route Route, msg Transferable) error {
+	if !route.IsValid() {
+		return fmt.Errorf("%w: %d", ErrInvalidRoute, route)
+	}
+	mt := msg.ProtoReflect().Type()
+	name := mt.Descriptor().FullName()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prev, ok := r.types[route]; ok {
+		return fmt.Errorf(
+			"route %s already carries %s", route, prev.Descriptor().FullName(),
+		)
+	}
+	if prev, ok := r.routes[name]; ok {
+		return fmt.Errorf("%s is already registered on route %s", name, prev)
+	}
+	r.types[route] = mt
+	r.routes[name] = route
+	return nil
+}
+
+// RegisterType associates the application message type typ, as named with
+// [SendTyped], with the type of msg. Unlike routes, several names may share a
+// type. Registering a name again with the same type has no effect.
+func (r *TypeRegistry) RegisterType(typ string, msg Transferable) error {
+	if typ == "" {
+		return ErrInvalidMessageType
+	}
+	mt := msg.ProtoReflect().Type()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prev, ok := r.named[typ]; ok {
+		if prev.Descriptor().FullName() == mt.Descriptor().FullName() {
+			return nil
+		}
+		return fmt.Errorf(
+			"%s already carries %s", typ, prev.Descriptor().FullName(),
+		)
+	}
+	r.named[typ] = mt
+	return nil
+}
+
+// NewType returns a new, empty message of the type registered for the
+// application message type typ.
+func (r *TypeRegistry) NewType(typ string) (Transferable, error) {
+	r.mu.RLock()
+	mt, ok := r.named[typ]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnregisteredType, typ)
+	}
+	return mt.New().Interface(), nil
+}
+
+// New returns a new, empty message of the type registered for route.
+func (r *TypeRegistry) New(route Route) (Transferable, error) {
+	r.mu.RLock()
+	mt, ok := r.types[route]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnregisteredRoute, route)
+	}
+	return mt.New().Interface(), nil
+}
+
+// Route returns the route registered for the type of msg.
+func (r *TypeRegistry) Route(msg Transferable) (Route, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	route, ok := r.routes[msg.ProtoReflect().Descriptor().FullName()]
+	return route, ok
+}
+
+// Send sends msg over t on the route registered for its type.
+func (r *TypeRegistry) Send(t *Transport, msg Transferable) (*Metadata, error) {
+	route, ok := r.Route(msg)
+	if !ok {
+		return nil, fmt.Errorf(
+			"%w: %s", ErrUnregisteredRoute,
+			msg.ProtoReflect().Descriptor().FullName(),
+		)
+	}
+	return t.Send(msg, route)
+}
+
+// Receive reads the next message from t and decodes it into the type
+// registered for its route. If the route is not registered, the message is
+// consumed and discarded, and the metadata is returned alongside
+// [ErrUnregisteredRoute] so the caller can decide whether to carry on.
+func (r *TypeRegistry) Receive(t *Transport) (Transferable, *Metadata, error) {
+	md, data, err := t.receive()
+	if err != nil {
+		return nil, nil, err
+	}
+	msg, err := r.New(md.Route())
+	if err != nil {
+		return nil, md, err
+	}
+	if err := t.unmarshal(data, msg); err != nil {
+		return nil, nil, err
+	}
+	return msg, md, nil
+}
@@ -0,0 +1,97 @@
+package kamune
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestTypeRegistry_Register(t *testing.T) {
+	a := require.New(t)
+	r := NewTypeRegistry()
+	a.NoError(r.Register(RouteExchangeMessages, (*wrapperspb.StringValue)(nil)))
+	a.NoError(r.Register(RoutePing, &wrapperspb.Int64Value{}))
+
+	a.ErrorIs(r.Register(RouteInvalid, Bytes(nil)), ErrInvalidRoute)
+	a.Error(r.Register(RouteExchangeMessages, Bytes(nil)), "route taken")
+	a.Error(r.Register(RoutePong, &wrapperspb.StringValue{}), "type taken")
+
+	msg, err := r.New(RouteExchangeMessages)
+	a.NoError(err)
+	a.IsType(&wrapperspb.StringValue{}, msg)
+	_, err = r.New(RoutePong)
+	a.ErrorIs(err, ErrUnregisteredRoute)
+
+	route, ok := r.Route(wrapperspb.Int64(1))
+	a.True(ok)
+	a.Equal(RoutePing, route)
+	_, ok = r.Route(Bytes(nil))
+	a.False(ok)
+}
+
+func TestTypeRegistry_RegisterType(t *testing.T) {
+	a := require.New(t)
+	r := NewTypeRegistry()
+	a.NoError(r.RegisterType("chat.text", Bytes(nil)))
+	a.NoError(r.RegisterType("chat.note", Bytes(nil)), "types are shared")
+	a.NoError(r.RegisterType("chat.text", Bytes(nil)), "same type again")
+
+	a.ErrorIs(r.RegisterType("", Bytes(nil)), ErrInvalidMessageType)
+	a.Error(r.RegisterType("chat.text", &wrapperspb.StringValue{}))
+
+	msg, err := r.NewType("chat.note")
+	a.NoError(err)
+	a.IsType(&wrapperspb.BytesValue{}, msg)
+	_, err = r.NewType("chat.poll")
+	a.ErrorIs(err, ErrUnregisteredType)
+}
+
+func TestTypeRegistry_SendReceive(t *testing.T) {
+	a := require.New(t)
+	addr, _, _ := startEchoServer(t)
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(addr, store, acceptAll)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+
+	r := NewTypeRegistry()
+	a.NoError(r.Register(RouteExchangeMessages, &wrapperspb.StringValue{}))
+	a.NoError(r.Register(RoutePing, &wrapperspb.Int64Value{}))
+
+	_, err = r.Send(tr, wrapperspb.String("hello"))
+	a.NoError(err)
+	_, err = r.Send(tr, wrapperspb.Int64(42))
+	a.NoError(err)
+	_, err = r.Send(tr, wrapperspb.Bool(true))
+	a.ErrorIs(err, ErrUnregisteredRoute)
+
+	msg, md, err := r.Receive(tr)
+	a.NoError(err)
+	a.Equal(RouteExchangeMessages, md.Route())
+	a.Equal("hello", msg.(*wrapperspb.StringValue).GetValue())
+
+	msg, md, err = r.Receive(tr)
+	a.NoError(err)
+	a.Equal(RoutePing, md.Route())
+	a.EqualValues(42, msg.(*wrapperspb.Int64Value).GetValue())
+
+	// A message on an unregistered route is consumed, and its metadata is
+	// still reported.
+	_, err = Send(tr, Bytes([]byte("raw")), RoutePong)
+	a.NoError(err)
+	_, md, err = r.Receive(tr)
+	a.ErrorIs(err, ErrUnregisteredRoute)
+	a.Equal(RoutePong, md.Route())
+
+	_, err = Send(tr, wrapperspb.String("typed"), RouteExchangeMessages)
+	a.NoError(err)
+	s, md, err := Receive[*wrapperspb.StringValue](tr)
+	a.NoError(err)
+	a.Equal(RouteExchangeMessages, md.Route())
+	a.Equal("typed", s.GetValue())
+}
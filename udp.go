@@ -0,0 +1,182 @@
+//go:build !kamune_noudp
+
+package kamune
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtaci/kcp-go/v5"
+)
+
+// udpSupported reports whether this build includes UDP/KCP connections,
+// which the kamune_noudp build tag leaves out.
+const udpSupported = true
+
+type udpListener struct {
+	net.Listener
+	connOpts []ConnOption
+}
+
+func (l *udpListener) Accept() (Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newConn(c, l.connOpts...), nil
+}
+
+// ServeWithUDP configures the server to use UDP/KCP connections. Builds made
+// with the kamune_noudp tag leave KCP out, and fail with [ErrUDPUnsupported]
+// instead.
+func ServeWithUDP(opts ...ConnOption) ServerOptions {
+	return func(s *Server) error {
+		s.connOpts = opts
+		l, err := kcp.Listen(s.addr)
+		if err != nil {
+			return fmt.Errorf("listening udp: %w", err)
+		}
+		s.listener = &udpListener{Listener: l, connOpts: opts}
+		return nil
+	}
+}
+
+// DialWithUDP configures the dialer to use UDP/KCP connections. The dialer
+// will fail at [NewDialer] time if the UDP dialer cannot be created, or with
+// [ErrUDPUnsupported] in builds made with the kamune_noudp tag.
+func DialWithUDP(opts ...ConnOption) DialOption {
+	return func(d *Dialer) error {
+		d.dialFunc = func(addr string) (Conn, error) {
+			return dialUDP(addr, opts)
+		}
+		return nil
+	}
+}
+
+func dialUDP(addr string, opts []ConnOption) (Conn, error) {
+	c, err := kcp.Dial(addr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing udp: %w", err)
+	}
+	return newConn(c, opts...), nil
+}
+
+// kcpState is the KCP layer of a UDP connection.
+type kcpState struct {
+	sess     *kcp.UDPSession
+	baseline kcp.Snmp
+	profile  atomic.Pointer[KCPProfile]
+	retunes  atomic.Uint64
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// newKCPState applies the connection's profile to c and starts the auto-tuner
+// if one was asked for. It returns nil unless c is a KCP session.
+func newKCPState(
+	c net.Conn, profile *KCPProfile, tune time.Duration,
+) *kcpState {
+	sess, ok := c.(*kcp.UDPSession)
+	if !ok {
+		return nil
+	}
+	k := &kcpState{sess: sess, baseline: *kcp.DefaultSnmp.Copy()}
+	if profile == nil && tune > 0 {
+		profile = &KCPBalanced
+	}
+	if profile != nil {
+		k.apply(profile)
+	}
+	if tune > 0 {
+		k.stop = make(chan struct{})
+		go k.tune(tune)
+	}
+	return k
+}
+
+// apply sets the session's parameters to those of p.
+func (k *kcpState) apply(p *KCPProfile) {
+	nodelay, nc := 0, 0
+	if p.NoDelay {
+		nodelay = 1
+	}
+	if p.NoCongestion {
+		nc = 1
+	}
+	k.sess.SetNoDelay(nodelay, p.Interval, p.Resend, nc)
+	k.sess.SetWindowSize(p.SendWindow, p.ReceiveWindow)
+	k.profile.Store(p)
+}
+
+// tune switches profiles every interval until the connection is closed. A
+// switch needs the same verdict twice in a row, so that a single noisy
+// interval does not make the link flap between profiles.
+func (k *kcpState) tune(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := k.stats()
+	var pending *KCPProfile
+	for {
+		select {
+		case <-k.stop:
+			return
+		case <-ticker.C:
+		}
+		cur := k.stats()
+		next := chooseKCPProfile(
+			cur.SRTT,
+			cur.Segments-last.Segments,
+			cur.Retransmitted-last.Retransmitted,
+		)
+		last = cur
+		if next == nil || next.Name == cur.Profile {
+			pending = nil
+			continue
+		}
+		if pending != next {
+			pending = next
+			continue
+		}
+		slog.Debug(
+			"retuning kcp connection",
+			slog.String("from", cur.Profile),
+			slog.String("to", next.Name),
+			slog.Duration("srtt", cur.SRTT),
+		)
+		k.apply(next)
+		k.retunes.Add(1)
+		pending = nil
+	}
+}
+
+// stats returns a snapshot of the KCP layer.
+func (k *kcpState) stats() KCPStats {
+	snmp, base := kcp.DefaultSnmp.Copy(), &k.baseline
+	ms := time.Millisecond
+	st := KCPStats{
+		Retunes:           k.retunes.Load(),
+		SRTT:              time.Duration(k.sess.GetSRTT()) * ms,
+		RTTVar:            time.Duration(k.sess.GetSRTTVar()) * ms,
+		RTO:               time.Duration(k.sess.GetRTO()) * ms,
+		Segments:          snmp.OutSegs - base.OutSegs,
+		Retransmitted:     snmp.RetransSegs - base.RetransSegs,
+		FastRetransmitted: snmp.FastRetransSegs - base.FastRetransSegs,
+		Lost:              snmp.LostSegs - base.LostSegs,
+	}
+	if p := k.profile.Load(); p != nil {
+		st.Profile = p.Name
+	}
+	return st
+}
+
+// close stops the auto-tuner, if any.
+func (k *kcpState) close() {
+	if k.stop == nil {
+		return
+	}
+	k.stopOnce.Do(func() { close(k.stop) })
+}
@@ -0,0 +1,39 @@
+//go:build kamune_noudp
+
+package kamune
+
+import (
+	"net"
+	"time"
+)
+
+// udpSupported reports whether this build includes UDP/KCP connections,
+// which the kamune_noudp build tag leaves out.
+const udpSupported = false
+
+// ServeWithUDP fails with [ErrUDPUnsupported], since this build was made with
+// the kamune_noudp tag.
+func ServeWithUDP(...ConnOption) ServerOptions {
+	return func(*Server) error { return ErrUDPUnsupported }
+}
+
+// DialWithUDP fails with [ErrUDPUnsupported], since this build was made with
+// the kamune_noudp tag.
+func DialWithUDP(...ConnOption) DialOption {
+	return func(*Dialer) error { return ErrUDPUnsupported }
+}
+
+func dialUDP(string, []ConnOption) (Conn, error) {
+	return nil, ErrUDPUnsupported
+}
+
+// kcpState is never created in builds without UDP support.
+type kcpState struct{}
+
+func newKCPState(net.Conn, *KCPProfile, time.Duration) *kcpState {
+	return nil
+}
+
+func (*kcpState) stats() KCPStats { return KCPStats{} }
+
+func (*kcpState) close() {}
@@ -0,0 +1,27 @@
+//go:build kamune_noudp
+
+package kamune
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDPUnsupported(t *testing.T) {
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	_, err := NewServer(
+		"127.0.0.1:0", NewEchoHandler(), store, acceptAll, ServeWithUDP(),
+	)
+	a.ErrorIs(err, ErrUDPUnsupported)
+	_, err = NewDialer("127.0.0.1:1", store, acceptAll, DialWithUDP())
+	a.ErrorIs(err, ErrUDPUnsupported)
+	_, err = NewDialer(
+		"127.0.0.1:1", store, acceptAll,
+		DialWithUDPPath("127.0.0.1:2", PreferLatency),
+	)
+	a.ErrorIs(err, ErrUDPUnsupported)
+}
@@ -0,0 +1,134 @@
+//go:build !kamune_noudp
+
+package kamune
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xtaci/kcp-go/v5"
+)
+
+// startDualServer runs echo servers sharing one identity on a TCP and a
+// UDP/KCP listener, and returns their addresses.
+func startDualServer(t *testing.T) (tcpAddr, udpAddr string) {
+	t.Helper()
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	t.Cleanup(cleanup)
+
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	ul, err := kcp.Listen("127.0.0.1:0")
+	a.NoError(err)
+	for _, l := range []Listener{
+		&tcpListener{Listener: tl}, &udpListener{Listener: ul},
+	} {
+		srv, err := NewServer(
+			"", NewEchoHandler(), store, acceptAll, ServeWithListener(l),
+		)
+		a.NoError(err)
+		go func() { _ = srv.ListenAndServe() }()
+		t.Cleanup(func() { _ = srv.Close() })
+	}
+	return tl.Addr().String(), ul.Addr().String()
+}
+
+func TestDialWithUDPPath(t *testing.T) {
+	a := require.New(t)
+	tcpAddr, udpAddr := startDualServer(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	deadAddr := l.Addr().String()
+	a.NoError(l.Close())
+
+	tests := []struct {
+		name    string
+		addr    string
+		policy  PathPolicy
+		network []string
+	}{
+		{"latency", tcpAddr, PreferLatency, []string{"tcp", "udp"}},
+		{"reliability", tcpAddr, PreferReliability, []string{"tcp"}},
+		{"tcp down, latency", deadAddr, PreferLatency, []string{"udp"}},
+		{"tcp down, reliability", deadAddr, PreferReliability, []string{"udp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := require.New(t)
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			d, err := NewDialer(
+				tt.addr, store, acceptAll, DialWithUDPPath(udpAddr, tt.policy),
+			)
+			a.NoError(err)
+			tr, err := d.Dial()
+			a.NoError(err)
+			defer tr.Close()
+			a.Contains(tt.network, tr.Network())
+
+			// The probe is the first sample.
+			st := tr.Stats()
+			a.Positive(st.RTT)
+			a.Equal(st.RTT, st.MinRTT)
+			a.Len(st.RTTSamples, 1)
+			echo(t, tr, "hello")
+		})
+	}
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	_, err = NewDialer(tcpAddr, store, acceptAll, DialWithUDPPath(udpAddr, 0))
+	a.Error(err)
+}
+
+func TestTransport_KCPStats(t *testing.T) {
+	a := require.New(t)
+	srvStore, cleanup := newTestStore(t)
+	defer cleanup()
+	ul, err := kcp.Listen("127.0.0.1:0")
+	a.NoError(err)
+	l := &udpListener{
+		Listener: ul, connOpts: []ConnOption{ConnWithKCPProfile(KCPLatency)},
+	}
+	srv, err := NewServer(
+		"", NewEchoHandler(), srvStore, acceptAll, ServeWithListener(l),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	defer srv.Close()
+
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	d, err := NewDialer(
+		ul.Addr().String(), store, acceptAll,
+		DialWithUDP(ConnWithKCPAutoTune(10*time.Millisecond)),
+	)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	defer tr.Close()
+	for range 3 {
+		echo(t, tr, "hello")
+	}
+
+	st := tr.Stats().KCP
+	a.NotNil(st)
+	// Loopback is neither lossy nor far, so the tuner stays balanced.
+	a.Equal(KCPBalanced.Name, st.Profile)
+	a.Zero(st.Retunes)
+	a.Positive(st.Segments)
+	a.Positive(st.RTO)
+	a.LessOrEqual(st.RetransmitRate(), 1.0)
+
+	// Other transports have no KCP layer.
+	addr := startSessionServer(t)
+	d, err = NewDialer(addr, store, storePeer)
+	a.NoError(err)
+	tcp, err := d.Dial()
+	a.NoError(err)
+	defer tcp.Close()
+	a.Nil(tcp.Stats().KCP)
+}
@@ -0,0 +1,286 @@
+package kamune
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kamune-org/kamune/internal/clock"
+)
+
+const (
+	defaultWatchInterval     = 5 * time.Second
+	defaultWatchProbeTimeout = 10 * time.Second
+	watchdogPingSize         = 8
+)
+
+// SessionEvent is a change in the health of a watched session, reported by a
+// [Watchdog].
+type SessionEvent uint8
+
+const (
+	// SessionSuspended is reported when the session is suspected to be dead:
+	// the wall clock jumped, as it does when the machine wakes from sleep, or
+	// the peer did not answer a ping after a period of inactivity.
+	SessionSuspended SessionEvent = iota + 1
+	// SessionResumed is reported when a suspended session is usable again,
+	// either because the peer answered or because the session migrated to a
+	// new connection.
+	SessionResumed
+	// SessionLost is reported when a suspended session could not be migrated.
+	// The watchdog stops; the application should close the transport and
+	// resume the session with [DialWithResume].
+	SessionLost
+)
+
+// String returns the string representation of the event.
+func (e SessionEvent) String() string {
+	switch e {
+	case SessionSuspended:
+		return "Suspended"
+	case SessionResumed:
+		return "Resumed"
+	case SessionLost:
+		return "Lost"
+	default:
+		return "Invalid"
+	}
+}
+
+// WatchdogHandler is called by a [Watchdog] for every [SessionEvent] of t.
+// For [SessionLost], err is the reason the session could not be recovered.
+type WatchdogHandler func(t *Transport, event SessionEvent, err error)
+
+// Watchdog detects a half-open session, one whose connection still looks open
+// locally while the server has long dropped it, typically after a laptop
+// slept. A [Transport.Receive] on such a session hangs forever. The watchdog
+// notices a jump of the wall clock between two of its ticks, or, if enabled,
+// a long period without any received message, and then pings the peer. If
+// nothing arrives within the probe timeout, it migrates the session to a new
+// connection with [Dialer.Migrate], which also unblocks a pending Receive.
+//
+// Pongs are delivered to the application like any other message, so the
+// watchdog relies on the application's receive loop to be running: it counts
+// any received message as an answer. Create one with [Dialer.Watch].
+type Watchdog struct {
+	clock        clock.Clock
+	dialer       *Dialer
+	t            *Transport
+	handler      WatchdogHandler
+	stop         chan struct{}
+	done         chan struct{}
+	lastTick     time.Time
+	lastActive   time.Time
+	interval     time.Duration
+	idleTimeout  time.Duration
+	probeTimeout time.Duration
+	lastReceived uint64
+	stopOnce     sync.Once
+}
+
+// WatchdogOption configures a [Watchdog].
+type WatchdogOption func(*Watchdog) error
+
+// Watch starts a [Watchdog] for t, which must have been established by d.
+// The watchdog stops on its own once t is closed or lost, or when
+// [Watchdog.Stop] is called.
+func (d *Dialer) Watch(
+	t *Transport, opts ...WatchdogOption,
+) (*Watchdog, error) {
+	w := &Watchdog{
+		clock:        clock.Real(),
+		dialer:       d,
+		t:            t,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+		interval:     defaultWatchInterval,
+		probeTimeout: defaultWatchProbeTimeout,
+	}
+	for _, o := range opts {
+		if err := o(w); err != nil {
+			return nil, err
+		}
+	}
+	w.lastTick = w.clock.Now()
+	w.lastActive = w.lastTick
+	w.lastReceived = t.stats.messagesReceived.Load()
+
+	go w.run()
+	return w, nil
+}
+
+// Stop stops the watchdog and waits for it to finish. It does not close the
+// transport.
+func (w *Watchdog) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+func (w *Watchdog) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if !w.check() {
+				return
+			}
+		}
+	}
+}
+
+// check runs a single tick of the watchdog. It reports false once the
+// session is closed or lost.
+func (w *Watchdog) check() bool {
+	if w.t.closed.Load() {
+		return false
+	}
+
+	// The wall clock keeps running while the machine sleeps, unlike the
+	// monotonic clock, which is stripped off for the comparison.
+	now := w.clock.Now().Round(0)
+	gap := now.Sub(w.lastTick)
+	w.lastTick = now
+	if w.t.stats.messagesReceived.Load() != w.lastReceived {
+		w.markActive(now)
+	}
+
+	jumped := gap > 2*w.interval
+	idle := w.idleTimeout > 0 && now.Sub(w.lastActive) >= w.idleTimeout
+	if !jumped && !idle {
+		return true
+	}
+
+	if jumped {
+		slog.Info(
+			"clock jump detected, probing session",
+			slog.String("session_id", w.t.sessionID),
+			slog.Duration("gap", gap),
+		)
+		w.emit(SessionSuspended, nil)
+	}
+	if w.probe() {
+		w.markActive(now)
+		if jumped {
+			w.emit(SessionResumed, nil)
+		}
+		return true
+	}
+	if !jumped {
+		w.emit(SessionSuspended, nil)
+	}
+
+	if err := w.dialer.Migrate(w.t); err != nil {
+		slog.Warn(
+			"watched session lost",
+			slog.String("session_id", w.t.sessionID),
+			slog.Any("error", err),
+		)
+		w.emit(SessionLost, err)
+		return false
+	}
+	w.markActive(w.clock.Now().Round(0))
+	w.emit(SessionResumed, nil)
+	return true
+}
+
+// probe pings the peer and reports whether any message arrives within the
+// probe timeout.
+func (w *Watchdog) probe() bool {
+	before := w.t.stats.messagesReceived.Load()
+	token := Bytes(randomBytes(watchdogPingSize))
+	if _, err := w.t.Send(token, RoutePing); err != nil {
+		return false
+	}
+
+	deadline := time.NewTimer(w.probeTimeout)
+	defer deadline.Stop()
+	poll := time.NewTicker(max(w.probeTimeout/20, time.Millisecond))
+	defer poll.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return true
+		case <-deadline.C:
+			return w.t.stats.messagesReceived.Load() != before
+		case <-poll.C:
+			if w.t.stats.messagesReceived.Load() != before {
+				return true
+			}
+		}
+	}
+}
+
+// markActive records that the session was known to be alive at now.
+func (w *Watchdog) markActive(now time.Time) {
+	w.lastActive = now
+	w.lastReceived = w.t.stats.messagesReceived.Load()
+}
+
+func (w *Watchdog) emit(event SessionEvent, err error) {
+	if w.handler != nil {
+		w.handler(w.t, event, err)
+	}
+}
+
+// WatchWithInterval sets how often the watchdog checks the clock and the
+// session's activity. A gap of more than twice the interval between two
+// checks counts as a clock jump. Defaults to 5 seconds.
+func WatchWithInterval(interval time.Duration) WatchdogOption {
+	return func(w *Watchdog) error {
+		if interval <= 0 {
+			return fmt.Errorf("interval must be positive")
+		}
+		w.interval = interval
+		return nil
+	}
+}
+
+// WatchWithIdleTimeout probes the session when no message has been received
+// for the given duration. Zero, the default, probes only after clock jumps.
+func WatchWithIdleTimeout(timeout time.Duration) WatchdogOption {
+	return func(w *Watchdog) error {
+		if timeout < 0 {
+			return fmt.Errorf("idle timeout must be non-negative")
+		}
+		w.idleTimeout = timeout
+		return nil
+	}
+}
+
+// WatchWithProbeTimeout sets how long the watchdog waits for the peer after a
+// ping before migrating the session. Defaults to 10 seconds.
+func WatchWithProbeTimeout(timeout time.Duration) WatchdogOption {
+	return func(w *Watchdog) error {
+		if timeout <= 0 {
+			return fmt.Errorf("probe timeout must be positive")
+		}
+		w.probeTimeout = timeout
+		return nil
+	}
+}
+
+// WatchWithHandler sets the handler notified of the session's events. It is
+// called from the watchdog's goroutine and should not block.
+func WatchWithHandler(h WatchdogHandler) WatchdogOption {
+	return func(w *Watchdog) error {
+		if h == nil {
+			return fmt.Errorf("handler must not be nil")
+		}
+		w.handler = h
+		return nil
+	}
+}
+
+// WatchWithClock sets a custom clock for the watchdog. It is primarily useful
+// for tests of clock jumps.
+func WatchWithClock(c clock.Clock) WatchdogOption {
+	return func(w *Watchdog) error {
+		w.clock = c
+		return nil
+	}
+}
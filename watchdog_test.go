@@ -0,0 +1,187 @@
+package kamune
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/internal/clock"
+)
+
+// halfOpenConn wraps a Conn that can be cut off silently, as a connection is
+// after the machine slept: writes still succeed and reads just wait.
+type halfOpenConn struct {
+	Conn
+	dead atomic.Bool
+}
+
+func (c *halfOpenConn) WriteBytes(b []byte) error {
+	if c.dead.Load() {
+		return nil
+	}
+	return c.Conn.WriteBytes(b)
+}
+
+func (c *halfOpenConn) ReadBytes() ([]byte, error) {
+	for {
+		b, err := c.Conn.ReadBytes()
+		if err != nil || !c.dead.Load() {
+			return b, err
+		}
+	}
+}
+
+// dialHalfOpen returns a dial function whose connections can be cut off
+// through the returned channel, and a switch that makes dialing fail.
+func dialHalfOpen() (
+	func(string) (Conn, error), <-chan *halfOpenConn, *atomic.Bool,
+) {
+	conns := make(chan *halfOpenConn, 4)
+	var unreachable atomic.Bool
+	return func(addr string) (Conn, error) {
+		if unreachable.Load() {
+			return nil, errors.New("network unreachable")
+		}
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		hc := &halfOpenConn{Conn: newConn(c)}
+		conns <- hc
+		return hc, nil
+	}, conns, &unreachable
+}
+
+type watchdogEvent struct {
+	err   error
+	event SessionEvent
+}
+
+// startWatchdog dials the echo server at addr, keeps a receive loop running
+// that forwards exchanged messages, and watches the session with a fake
+// clock. The watchdog never ticks on its own; tests call check directly.
+func startWatchdog(t *testing.T, addr string, opts ...WatchdogOption) (
+	*Transport, *Watchdog, *clock.Fake, *[]watchdogEvent, <-chan string,
+	<-chan *halfOpenConn, *atomic.Bool,
+) {
+	t.Helper()
+	a := require.New(t)
+	dial, conns, unreachable := dialHalfOpen()
+	store, cleanup := newTestStore(t)
+	t.Cleanup(cleanup)
+	d, err := NewDialer(addr, store, acceptAll, DialWithFunc(dial))
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	t.Cleanup(func() { _ = tr.Close() })
+
+	received := make(chan string, 4)
+	go func() {
+		for {
+			msg := Bytes(nil)
+			md, err := tr.Receive(msg)
+			if err != nil {
+				return
+			}
+			if md.Route() == RouteExchangeMessages {
+				received <- string(msg.GetValue())
+			}
+		}
+	}()
+
+	clk := clock.NewFake(time.Now())
+	events := &[]watchdogEvent{}
+	w, err := d.Watch(tr, append([]WatchdogOption{
+		WatchWithClock(clk),
+		WatchWithInterval(time.Hour),
+		WatchWithProbeTimeout(100 * time.Millisecond),
+		WatchWithHandler(func(_ *Transport, e SessionEvent, err error) {
+			*events = append(*events, watchdogEvent{err: err, event: e})
+		}),
+	}, opts...)...)
+	a.NoError(err)
+	t.Cleanup(w.Stop)
+	return tr, w, clk, events, received, conns, unreachable
+}
+
+func TestWatchdog_ClockJump(t *testing.T) {
+	a := require.New(t)
+	addr, _, _ := startEchoServer(t)
+	tr, w, clk, events, received, conns, _ := startWatchdog(t, addr)
+	first := <-conns
+
+	// Nothing happens while the clock runs normally.
+	clk.Advance(time.Hour)
+	a.True(w.check())
+	a.Empty(*events)
+
+	// The connection survived the sleep; the peer answers the ping.
+	clk.Advance(3 * time.Hour)
+	a.True(w.check())
+	a.Equal([]watchdogEvent{
+		{event: SessionSuspended}, {event: SessionResumed},
+	}, *events)
+	a.Len(conns, 0)
+
+	// The server dropped the connection meanwhile; the session migrates and
+	// the pending receive continues on the new one.
+	*events = nil
+	first.dead.Store(true)
+	clk.Advance(3 * time.Hour)
+	a.True(w.check())
+	a.Equal([]watchdogEvent{
+		{event: SessionSuspended}, {event: SessionResumed},
+	}, *events)
+	a.Len(conns, 1)
+
+	_, err := tr.Send(Bytes([]byte("still here")), RouteExchangeMessages)
+	a.NoError(err)
+	select {
+	case msg := <-received:
+		a.Equal("still here", msg)
+	case <-time.After(5 * time.Second):
+		a.Fail("receive did not continue on the new connection")
+	}
+}
+
+func TestWatchdog_IdleSessionLost(t *testing.T) {
+	a := require.New(t)
+	addr, _, _ := startEchoServer(t)
+	_, w, clk, events, _, conns, unreachable := startWatchdog(
+		t, addr, WatchWithIdleTimeout(time.Minute),
+	)
+
+	// An idle session that answers is left alone.
+	clk.Advance(2 * time.Minute)
+	a.True(w.check())
+	a.Empty(*events)
+
+	(<-conns).dead.Store(true)
+	unreachable.Store(true)
+	clk.Advance(2 * time.Minute)
+	a.False(w.check())
+	a.Len(*events, 2)
+	a.Equal(SessionSuspended, (*events)[0].event)
+	a.Equal(SessionLost, (*events)[1].event)
+	a.Error((*events)[1].err)
+}
+
+func TestWatchdog_Options(t *testing.T) {
+	a := require.New(t)
+	tests := []struct {
+		name string
+		opt  WatchdogOption
+	}{
+		{name: "interval", opt: WatchWithInterval(0)},
+		{name: "idle timeout", opt: WatchWithIdleTimeout(-time.Second)},
+		{name: "probe timeout", opt: WatchWithProbeTimeout(0)},
+		{name: "handler", opt: WatchWithHandler(nil)},
+	}
+	for _, tc := range tests {
+		a.Error(tc.opt(&Watchdog{}), tc.name)
+	}
+}
@@ -0,0 +1,185 @@
+package kamune
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// websocketPath is the path WebSocket connections are served on, and the one
+// dialed when the address is not a ws:// or wss:// URL.
+const websocketPath = "/kamune"
+
+// wsListener accepts kamune connections carried over WebSocket. Each
+// connection is a stream of binary messages, read and written as the bytes
+// of a TCP connection would be, so the framing, handshake and transport on
+// top of it are those of TCP.
+type wsListener struct {
+	srv      *http.Server
+	conns    chan Conn
+	done     chan struct{}
+	once     sync.Once
+	connOpts []ConnOption
+}
+
+// newWebSocketListener serves WebSocket connections on ln, behind TLS if
+// tlsConfig is not nil.
+func newWebSocketListener(
+	ln net.Listener, tlsConfig *tls.Config, opts []ConnOption,
+) *wsListener {
+	l := &wsListener{
+		conns:    make(chan Conn),
+		done:     make(chan struct{}),
+		connOpts: opts,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(websocketPath, l.upgrade)
+	l.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	go func() { _ = l.srv.Serve(ln) }()
+	return l
+}
+
+// upgrade turns an HTTP request into a WebSocket connection and hands it to
+// Accept.
+func (l *wsListener) upgrade(w http.ResponseWriter, r *http.Request) {
+	ws, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		// The handshake authenticates both peers, and no cookie or other
+		// ambient credential is ever relied on, so connections from pages of
+		// any origin are as safe as those from any other client.
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		// Accept has already answered the request with an error.
+		return
+	}
+	// The connection outlives the request, so it is not bound to its
+	// context.
+	nc := websocket.NetConn(
+		context.Background(), ws, websocket.MessageBinary,
+	)
+	c := newConn(nc, l.connOpts...)
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		_ = c.Close()
+	}
+}
+
+func (l *wsListener) Accept() (Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections. Like closing a TCP listener, it leaves
+// established connections open.
+func (l *wsListener) Close() error {
+	err := net.ErrClosed
+	l.once.Do(func() {
+		close(l.done)
+		err = l.srv.Close()
+	})
+	return err
+}
+
+// ServeWithWebSocket configures the server to accept connections over
+// WebSocket on the path /kamune, for peers behind networks that only let
+// WebSocket or HTTPS out, and for browsers. With a tlsConfig, connections
+// are served behind TLS, as wss://; without one, as ws://, which suits
+// servers behind a reverse proxy that terminates TLS. The handshake and
+// transport are unchanged.
+func ServeWithWebSocket(
+	tlsConfig *tls.Config, opts ...ConnOption,
+) ServerOptions {
+	return func(s *Server) error {
+		s.connOpts = opts
+		ln, err := net.Listen("tcp", s.addr)
+		if err != nil {
+			return fmt.Errorf("listening websocket: %w", err)
+		}
+		s.listener = newWebSocketListener(ln, tlsConfig, opts)
+		return nil
+	}
+}
+
+// DialWithWebSocketConn configures the dialer to connect over WebSocket to a
+// server configured with [ServeWithWebSocket]. The address is either a
+// host:port, dialed as ws:// or, with a tlsConfig, as wss://, or a ws:// or
+// wss:// URL, dialed as is, which suits servers behind a reverse proxy on
+// another path. A wss:// URL without a tlsConfig is checked against the
+// system's roots.
+func DialWithWebSocketConn(
+	tlsConfig *tls.Config, opts ...ConnOption,
+) DialOption {
+	return func(d *Dialer) error {
+		d.dialFunc = func(addr string) (Conn, error) {
+			return dialWebSocket(
+				d.state.ctx, d.dialTimeout, addr, tlsConfig, opts,
+			)
+		}
+		return nil
+	}
+}
+
+// webSocketURL returns the URL dialed for addr.
+func webSocketURL(addr string, tlsConfig *tls.Config) (string, error) {
+	if strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://") {
+		return addr, nil
+	}
+	if strings.Contains(addr, "://") {
+		return "", errors.New("websocket address must be ws:// or wss://")
+	}
+	scheme := "ws"
+	if tlsConfig != nil {
+		scheme = "wss"
+	}
+	return scheme + "://" + addr + websocketPath, nil
+}
+
+func dialWebSocket(
+	ctx context.Context,
+	timeout time.Duration,
+	addr string,
+	tlsConfig *tls.Config,
+	opts []ConnOption,
+) (Conn, error) {
+	url, err := webSocketURL(addr, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var dopts *websocket.DialOptions
+	if tlsConfig != nil {
+		dopts = &websocket.DialOptions{
+			HTTPClient: &http.Client{
+				Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			},
+		}
+	}
+	ws, _, err := websocket.Dial(ctx, url, dopts)
+	if err != nil {
+		return nil, fmt.Errorf("dialing websocket: %w", err)
+	}
+	nc := websocket.NetConn(
+		context.Background(), ws, websocket.MessageBinary,
+	)
+	return newConn(nc, opts...), nil
+}
@@ -0,0 +1,162 @@
+package kamune
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testTLSConfigs returns a server configuration with a self-signed
+// certificate for 127.0.0.1, and a client configuration trusting it.
+func testTLSConfigs(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	a := require.New(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a.NoError(err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, &template, &template, &key.PublicKey, key,
+	)
+	a.NoError(err)
+	cert, err := x509.ParseCertificate(der)
+	a.NoError(err)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	server = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{der}, PrivateKey: key,
+	}}}
+	return server, &tls.Config{RootCAs: roots}
+}
+
+// startWebSocketServer runs an echo server over WebSocket, behind TLS if
+// tlsConfig is not nil, and returns its address.
+func startWebSocketServer(t *testing.T, tlsConfig *tls.Config) string {
+	t.Helper()
+	a := require.New(t)
+	store, cleanup := newTestStore(t)
+	t.Cleanup(cleanup)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	srv, err := NewServer("", NewEchoHandler(), store, acceptAll,
+		ServeWithListener(newWebSocketListener(ln, tlsConfig, nil)),
+	)
+	a.NoError(err)
+	go func() { _ = srv.ListenAndServe() }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return ln.Addr().String()
+}
+
+func TestWebSocket(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	wsAddr := startWebSocketServer(t, nil)
+	wssAddr := startWebSocketServer(t, serverTLS)
+
+	tests := []struct {
+		name string
+		addr string
+		tls  *tls.Config
+	}{
+		{"ws", wsAddr, nil},
+		{"ws url", "ws://" + wsAddr + websocketPath, nil},
+		{"wss", wssAddr, clientTLS},
+		{"wss url", "wss://" + wssAddr + websocketPath, clientTLS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := require.New(t)
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+			d, err := NewDialer(tt.addr, store, acceptAll,
+				DialWithWebSocketConn(tt.tls),
+			)
+			a.NoError(err)
+			tr, err := d.Dial()
+			a.NoError(err)
+			defer tr.Close()
+			echo(t, tr, "hello")
+
+			// Frames of the largest size cross as they do over TCP.
+			large := bytes.Repeat([]byte{'x'}, 48*1024)
+			echo(t, tr, string(large))
+		})
+	}
+
+	t.Run("untrusted certificate", func(t *testing.T) {
+		a := require.New(t)
+		store, cleanup := newTestStore(t)
+		defer cleanup()
+		d, err := NewDialer(wssAddr, store, acceptAll,
+			DialWithWebSocketConn(&tls.Config{}),
+		)
+		a.NoError(err)
+		_, err = d.Dial()
+		a.Error(err)
+	})
+}
+
+func TestWebSocketURL(t *testing.T) {
+	tests := []struct {
+		addr    string
+		tls     bool
+		want    string
+		wantErr bool
+	}{
+		{"example.org:443", false, "ws://example.org:443/kamune", false},
+		{"example.org:443", true, "wss://example.org:443/kamune", false},
+		{"wss://example.org/chat", false, "wss://example.org/chat", false},
+		{"ws://10.0.0.5:80/k", true, "ws://10.0.0.5:80/k", false},
+		{"https://example.org", false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			a := require.New(t)
+			var cfg *tls.Config
+			if tt.tls {
+				cfg = &tls.Config{}
+			}
+			got, err := webSocketURL(tt.addr, cfg)
+			if tt.wantErr {
+				a.Error(err)
+				return
+			}
+			a.NoError(err)
+			a.Equal(tt.want, got)
+		})
+	}
+}
+
+func TestWebSocketListener_Close(t *testing.T) {
+	a := require.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	l := newWebSocketListener(ln, nil, nil)
+
+	// Other paths are not served.
+	resp, err := http.Get("http://" + ln.Addr().String() + "/")
+	a.NoError(err)
+	a.NoError(resp.Body.Close())
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+
+	a.NoError(l.Close())
+	_, err = l.Accept()
+	a.ErrorIs(err, net.ErrClosed)
+	a.ErrorIs(l.Close(), net.ErrClosed)
+}
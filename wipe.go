@@ -0,0 +1,263 @@
+package kamune
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/kamune-org/kamune/internal/box/pb"
+	"github.com/kamune-org/kamune/pkg/attest"
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+const (
+	// capabilityWipe is advertised in the introduction by peers that honor
+	// wipe requests.
+	capabilityWipe = "wipe/v1"
+
+	// wipeSigningContext separates wipe acknowledgments from every other
+	// signature made with the same key.
+	wipeSigningContext = "kamune-wipe-acknowledgment\x00"
+
+	reasonWipeDisabled = "wipe requests are not accepted"
+	reasonWipeFailed   = "wiping failed"
+)
+
+// wipes tracks the wipe requests of a session awaiting the peer's answer.
+type wipes struct {
+	pending map[string]chan wipeOutcome
+	mu      sync.Mutex
+}
+
+type wipeOutcome struct {
+	accept *pb.WipeAccept
+	err    error
+}
+
+// fail ends every pending request of the session with err.
+func (w *wipes) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, ch := range w.pending {
+		select {
+		case ch <- wipeOutcome{err: err}:
+		default:
+		}
+	}
+}
+
+// signedWipe returns the bytes a wipe acknowledgment's signature covers.
+func signedWipe(sessionID, id string, requested, wiped time.Time) []byte {
+	b := []byte(wipeSigningContext)
+	for _, field := range []string{sessionID, id} {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(field)))
+		b = append(b, field...)
+	}
+	b = binary.BigEndian.AppendUint64(b, uint64(requested.UnixNano()))
+	return binary.BigEndian.AppendUint64(b, uint64(wiped.UnixNano()))
+}
+
+// VerifyWipeReceipt reports whether r was signed by the peer it names, such
+// as when it is shown to a third party.
+func VerifyWipeReceipt(r *storage.WipeReceipt) bool {
+	return attest.Verify(
+		r.PeerKey,
+		signedWipe(r.SessionID, r.ID, r.Requested, r.Wiped),
+		r.Signature,
+	)
+}
+
+// RequestWipe asks the peer to wipe its copy of the conversation, see
+// [storage.Storage.WipeConversation], and then wipes the local copy. The peer
+// acknowledges the wipe with a signature, which is returned and recorded as a
+// [storage.WipeReceipt] that outlives the conversation. The local copy is
+// wiped whatever the outcome, as the request is meant as a panic button; use
+// [storage.Storage.WipeConversation] alone while the peer is offline.
+//
+// It fails with [errors.ErrUnsupported] unless the peer enabled
+// [ServeWithWipeRequests] or [DialWithWipeRequests], in which case nothing is
+// wiped, and with [ErrWipeRefused] if the peer could not wipe its copy. The
+// peer's answer is read by [Transport.Receive] like any other message, so
+// another goroutine must be receiving from t meanwhile; ctx bounds the wait.
+// The session stays usable, but its stored state is gone.
+func (t *Transport) RequestWipe(
+	ctx context.Context,
+) (*storage.WipeReceipt, error) {
+	if !slices.Contains(t.remotePeer.Capabilities, capabilityWipe) {
+		return nil, fmt.Errorf(
+			"%w: peer does not accept wipe requests", errors.ErrUnsupported,
+		)
+	}
+
+	receipt, err := t.requestWipe(ctx)
+	if werr := t.wipe(); werr != nil {
+		err = errors.Join(err, werr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+func (t *Transport) requestWipe(
+	ctx context.Context,
+) (*storage.WipeReceipt, error) {
+	id := rand.Text()
+	requested := time.Now()
+	outcome := make(chan wipeOutcome, 1)
+	t.wipes.mu.Lock()
+	if t.wipes.pending == nil {
+		t.wipes.pending = make(map[string]chan wipeOutcome)
+	}
+	t.wipes.pending[id] = outcome
+	t.wipes.mu.Unlock()
+	defer func() {
+		t.wipes.mu.Lock()
+		delete(t.wipes.pending, id)
+		t.wipes.mu.Unlock()
+	}()
+
+	_, err := t.Send(&pb.WipeRequest{
+		ID: id, Requested: timestamppb.New(requested),
+	}, RouteWipeRequest)
+	if err != nil {
+		return nil, fmt.Errorf("requesting wipe: %w", err)
+	}
+	var accept *pb.WipeAccept
+	select {
+	case o := <-outcome:
+		if o.err != nil {
+			return nil, o.err
+		}
+		accept = o.accept
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if !accept.GetAccepted() {
+		return nil, fmt.Errorf("%w: %s", ErrWipeRefused, accept.GetReason())
+	}
+
+	receipt := &storage.WipeReceipt{
+		Requested: requested,
+		Wiped:     accept.GetWiped().AsTime(),
+		ID:        id,
+		SessionID: t.sessionID,
+		PeerKey:   t.remotePeer.PublicKey,
+		Signature: accept.GetSignature(),
+	}
+	if !VerifyWipeReceipt(receipt) {
+		return nil, fmt.Errorf("wipe acknowledgment: %w", ErrInvalidSignature)
+	}
+	if t.store != nil {
+		if err := t.store.RecordWipeReceipt(*receipt); err != nil {
+			return nil, err
+		}
+	}
+	return receipt, nil
+}
+
+// isWipeRoute reports whether messages on r are handled by the transport
+// rather than returned to the application.
+func isWipeRoute(r Route) bool {
+	return r == RouteWipeRequest || r == RouteWipeAccept
+}
+
+// handleWipe handles a message on a wipe route. It returns an error only if
+// the message cannot be decoded or answered.
+func (t *Transport) handleWipe(route Route, msg []byte) error {
+	if route == RouteWipeAccept {
+		var a pb.WipeAccept
+		if err := t.unmarshal(msg, &a); err != nil {
+			return err
+		}
+		t.wipes.mu.Lock()
+		ch := t.wipes.pending[a.GetID()]
+		t.wipes.mu.Unlock()
+		if ch != nil {
+			select {
+			case ch <- wipeOutcome{accept: &a}:
+			default:
+			}
+		}
+		return nil
+	}
+
+	var r pb.WipeRequest
+	if err := t.unmarshal(msg, &r); err != nil {
+		return err
+	}
+	reply := &pb.WipeAccept{ID: r.GetID()}
+	if !t.wipeable {
+		reply.Reason = reasonWipeDisabled
+	} else if err := t.wipe(); err != nil {
+		slog.Error(
+			"failed to wipe conversation",
+			slog.String("session_id", t.sessionID),
+			slog.Any("error", err),
+		)
+		reply.Reason = reasonWipeFailed
+	} else {
+		slog.Info(
+			"wiped conversation at the peer's request",
+			slog.String("session_id", t.sessionID),
+		)
+		wiped := time.Now()
+		sig, err := t.serde.attest.Sign(signedWipe(
+			t.sessionID, r.GetID(), r.GetRequested().AsTime(), wiped,
+		))
+		if err != nil {
+			return fmt.Errorf("signing wipe acknowledgment: %w", err)
+		}
+		reply.Accepted = true
+		reply.Wiped = timestamppb.New(wiped)
+		reply.Signature = sig
+	}
+	if _, err := t.Send(reply, RouteWipeAccept); err != nil {
+		return fmt.Errorf("acknowledging wipe: %w", err)
+	}
+	return nil
+}
+
+// wipe wipes the local copy of the conversation. Guests leave nothing behind
+// to wipe.
+func (t *Transport) wipe() error {
+	if t.store == nil {
+		return nil
+	}
+	if err := t.store.WipeConversation(t.remotePeer.PublicKey); err != nil {
+		return err
+	}
+	// Recording the statistics on close would leave the session behind.
+	t.statsOnce.Do(func() {})
+	return nil
+}
+
+// ServeWithWipeRequests controls whether dialers may have the server wipe its
+// copy of their conversation; see [Transport.RequestWipe]. Wiping cannot be
+// undone. Disabled by default.
+func ServeWithWipeRequests(enabled bool) ServerOptions {
+	return func(s *Server) error {
+		s.handshakeOpts.intro.capabilities = setCapability(
+			s.handshakeOpts.intro.capabilities, capabilityWipe, enabled,
+		)
+		return nil
+	}
+}
+
+// DialWithWipeRequests is the dialer's equivalent of [ServeWithWipeRequests].
+func DialWithWipeRequests(enabled bool) DialOption {
+	return func(d *Dialer) error {
+		d.handshakeOpts.intro.capabilities = setCapability(
+			d.handshakeOpts.intro.capabilities, capabilityWipe, enabled,
+		)
+		return nil
+	}
+}
@@ -0,0 +1,152 @@
+package kamune
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kamune-org/kamune/pkg/storage"
+)
+
+// startWipeServer runs a server that records a chat entry for every session
+// before handing it to handler, and returns its address and storage.
+func startWipeServer(
+	t *testing.T, handler HandlerFunc, opts ...ServerOptions,
+) (string, *storage.Storage) {
+	t.Helper()
+	store, cleanup := newTestStore(t)
+	t.Cleanup(cleanup)
+	chat := func(t *Transport) error {
+		err := store.AddChatEntry(
+			t.SessionID(), []byte("hi"), time.Now(), storage.SenderPeer,
+		)
+		if err != nil {
+			return err
+		}
+		return handler(t)
+	}
+	_, addr, _ := startTestServer(t, chat, store, storePeer, opts...)
+	return addr, store
+}
+
+// dialForWipe dials addr, exchanges a message and records it, and keeps
+// receiving in the background as [Transport.RequestWipe] requires.
+func dialForWipe(
+	t *testing.T, addr string, store *storage.Storage, echoed bool,
+) *Transport {
+	t.Helper()
+	a := require.New(t)
+	d, err := NewDialer(addr, store, storePeer)
+	a.NoError(err)
+	tr, err := d.Dial()
+	a.NoError(err)
+	t.Cleanup(func() { _ = tr.Close() })
+	if echoed {
+		echo(t, tr, "hi")
+	}
+	a.NoError(store.AddChatEntry(
+		tr.SessionID(), []byte("hi"), time.Now(), storage.SenderLocal,
+	))
+	go func() {
+		for {
+			if _, err := tr.Receive(Bytes(nil)); err != nil {
+				return
+			}
+		}
+	}()
+	return tr
+}
+
+func TestRequestWipe(t *testing.T) {
+	a := require.New(t)
+	addr, srvStore := startWipeServer(
+		t, NewEchoHandler(), ServeWithWipeRequests(true),
+	)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	tr := dialForWipe(t, addr, store, true)
+	sessionID, serverKey := tr.SessionID(), tr.RemotePeer().PublicKey
+
+	for _, s := range []*storage.Storage{store, srvStore} {
+		sessions, err := s.ListSessions()
+		a.NoError(err)
+		a.Contains(sessions, sessionID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	receipt, err := tr.RequestWipe(ctx)
+	a.NoError(err)
+	a.NotEmpty(receipt.ID)
+	a.Equal(sessionID, receipt.SessionID)
+	a.Equal(serverKey, receipt.PeerKey)
+	a.True(VerifyWipeReceipt(receipt))
+
+	for _, s := range []*storage.Storage{store, srvStore} {
+		sessions, err := s.ListSessions()
+		a.NoError(err)
+		a.NotContains(sessions, sessionID)
+		history, err := s.GetChatHistory(sessionID)
+		a.NoError(err)
+		a.Empty(history)
+	}
+
+	receipts, err := store.WipeReceipts(serverKey)
+	a.NoError(err)
+	a.Len(receipts, 1)
+	a.Equal(receipt.ID, receipts[0].ID)
+	a.True(VerifyWipeReceipt(&receipts[0]))
+	receipts[0].SessionID = "another session"
+	a.False(VerifyWipeReceipt(&receipts[0]))
+}
+
+func TestRequestWipeUnanswered(t *testing.T) {
+	a := require.New(t)
+	stop := make(chan struct{})
+	defer close(stop)
+	addr, srvStore := startWipeServer(
+		t,
+		func(*Transport) error { <-stop; return nil },
+		ServeWithWipeRequests(true),
+	)
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	tr := dialForWipe(t, addr, store, false)
+
+	// The local copy is wiped even though the peer never answers.
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 200*time.Millisecond,
+	)
+	defer cancel()
+	_, err := tr.RequestWipe(ctx)
+	a.ErrorIs(err, context.DeadlineExceeded)
+	history, err := store.GetChatHistory(tr.SessionID())
+	a.NoError(err)
+	a.Empty(history)
+	receipts, err := store.WipeReceipts(tr.RemotePeer().PublicKey)
+	a.NoError(err)
+	a.Empty(receipts)
+
+	history, err = srvStore.GetChatHistory(tr.SessionID())
+	a.NoError(err)
+	a.Len(history, 1)
+}
+
+func TestRequestWipeUnsupported(t *testing.T) {
+	a := require.New(t)
+	addr, srvStore := startWipeServer(t, NewEchoHandler())
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	tr := dialForWipe(t, addr, store, true)
+
+	_, err := tr.RequestWipe(context.Background())
+	a.ErrorIs(err, errors.ErrUnsupported)
+	for _, s := range []*storage.Storage{store, srvStore} {
+		history, err := s.GetChatHistory(tr.SessionID())
+		a.NoError(err)
+		a.Len(history, 1)
+	}
+}